@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/llm"
+	"gocognigo/internal/retriever"
+
+	"github.com/joho/godotenv"
+)
+
+// ask answers a question about a project's documents from the terminal,
+// through a running server's /api/query or, with -offline, by loading the
+// project's index from disk itself.
+//
+//	ask -project <id> "What is the termination notice period?"
+//	echo "List every party" | ask -project <id> -json -
+func main() {
+	_ = godotenv.Load() // Ignore error if .env doesn't exist
+
+	server := flag.String("server", envOr("GOCOGNIGO_URL", "http://localhost:8080"), "URL of a running gocognigo server")
+	token := flag.String("token", os.Getenv("GOCOGNIGO_TOKEN"), "bearer token, when the server requires sign-in")
+	project := flag.String("project", "", "project ID (required)")
+	provider := flag.String("provider", "", "LLM provider: openai, anthropic or huggingface (default: the server's default LLM; openai offline)")
+	model := flag.String("model", "", "model name (default: the provider's default)")
+	topK := flag.Int("top-k", 0, "chunks to retrieve (0 lets the question type decide)")
+	asJSON := flag.Bool("json", false, "print the full response as JSON")
+	offline := flag.Bool("offline", false, "load the index from -data instead of asking a server; stop the server first")
+	dataDir := flag.String("data", "data/users/local_dev_user_projects", "offline: the directory holding the projects")
+	timeout := flag.Duration("timeout", 5*time.Minute, "give up after this long")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: ask [flags] <question | ->\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	question := strings.Join(flag.Args(), " ")
+	if question == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to read the question from stdin: %v", err)
+		}
+		question = string(data)
+	}
+	question = strings.TrimSpace(question)
+	if question == "" || *project == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var resp *queryResponse
+	var err error
+	if *offline {
+		resp, err = askOffline(ctx, *dataDir, *project, *provider, *model, question, *topK)
+	} else {
+		resp, err = askServer(ctx, *server, *token, *project, *provider, *model, question, *topK)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(resp, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	printAnswer(resp)
+}
+
+// queryResponse is the part of an /api/query response ask prints; offline
+// answers are shaped the same.
+type queryResponse struct {
+	Answer           *llm.Answer `json:"answer"`
+	TimeSeconds      float64     `json:"time_seconds"`
+	EnhancedQuestion string      `json:"enhanced_question,omitempty"`
+	QueryType        string      `json:"query_type,omitempty"`
+	RoutedDocuments  []string    `json:"routed_documents,omitempty"`
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// askServer posts the question to a running server's /api/query.
+func askServer(ctx context.Context, server, token, project, provider, model, question string, topK int) (*queryResponse, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"question":   question,
+		"project_id": project,
+		"provider":   provider,
+		"model":      model,
+		"top_k":      topK,
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(server, "/")+"/api/query", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach %s: %w", server, err)
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("server error (HTTP %d): %s", httpResp.StatusCode, e.Error)
+		}
+		return nil, fmt.Errorf("server error (HTTP %d): %s", httpResp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var resp queryResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unexpected response: %w", err)
+	}
+	if resp.Answer == nil {
+		return nil, fmt.Errorf("the server returned no answer")
+	}
+	return &resp, nil
+}
+
+// askOffline loads the project's index from dataDir and answers with the
+// keys in the environment, as cmd/ingest does: EMBEDDING_PROVIDER,
+// EMBEDDING_API_KEY (or OPENAI_API_KEY) and EMBEDDING_MODEL must match the
+// ones the project was indexed with, and it refuses an index embedded with
+// others. Like cmd/ingest it won't run beside a server using the same data.
+func askOffline(ctx context.Context, dataDir, project, provider, model, question string, topK int) (*queryResponse, error) {
+	start := time.Now()
+
+	// In the server's layout (<data>/users/<user>_projects) the server
+	// holds the lock on <data>
+	lockDir := dataDir
+	if filepath.Base(filepath.Dir(filepath.Clean(lockDir))) == "users" {
+		lockDir = filepath.Dir(filepath.Dir(filepath.Clean(lockDir)))
+	}
+	dataLock, err := chat.LockDataDir(lockDir, "ask")
+	if err != nil {
+		return nil, fmt.Errorf("%w; ask the running server instead (drop -offline)", err)
+	}
+	defer dataLock.Release()
+
+	store, err := chat.NewProjectStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	proj, err := store.Get(project)
+	if err != nil {
+		return nil, fmt.Errorf("project %s not found in %s", project, dataDir)
+	}
+
+	embedKey := os.Getenv("EMBEDDING_API_KEY")
+	if embedKey == "" {
+		embedKey = os.Getenv("OPENAI_API_KEY") // Fallback
+	}
+	idx, err := indexer.NewIndex(os.Getenv("EMBEDDING_PROVIDER"), embedKey, os.Getenv("EMBEDDING_MODEL"), store.BM25Dir(project))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the index: %w", err)
+	}
+	defer idx.Close()
+	if err := idx.LoadVectors(store.VectorsPath(project)); err != nil {
+		return nil, fmt.Errorf("failed to load vectors: %w", err)
+	}
+	if err := idx.CheckEmbedding(); err != nil {
+		return nil, fmt.Errorf("the project's index doesn't match the embedding settings: %w; set EMBEDDING_PROVIDER and EMBEDDING_MODEL to the ones it was indexed with", err)
+	}
+
+	if provider == "" {
+		provider = "openai"
+	}
+	keyEnv := map[string]string{"openai": "OPENAI_API_KEY", "anthropic": "ANTHROPIC_API_KEY", "huggingface": "HUGGINGFACE_API_KEY"}[provider]
+	if keyEnv == "" {
+		return nil, fmt.Errorf("unknown LLM provider: %s", provider)
+	}
+	apiKey := os.Getenv(keyEnv)
+	if apiKey == "" {
+		return nil, fmt.Errorf("%s is required to answer with %s", keyEnv, provider)
+	}
+	client, err := llm.NewProvider(provider, apiKey, model)
+	if err != nil {
+		return nil, err
+	}
+
+	queryType := llm.ClassifyQuery(question)
+	strategy := llm.StrategyFor(queryType)
+	if topK <= 0 {
+		topK = strategy.TopK
+	}
+	ret := retriever.NewRetriever(idx)
+	results, err := ret.Search(ctx, question, topK)
+	if err != nil {
+		return nil, fmt.Errorf("retrieval error: %w", err)
+	}
+	sysPrompt := proj.SystemPrompt
+	if strategy.Prompt != "" {
+		sysPrompt = strings.TrimSpace(sysPrompt + "\n\n" + strategy.Prompt)
+	}
+	answer, err := client.AnswerQuestion(ctx, question, results, strategy.Summaries(results, ret.DocSummaries), nil, sysPrompt, proj.BasePrompt)
+	if err != nil {
+		return nil, fmt.Errorf("LLM error: %w", err)
+	}
+	return &queryResponse{Answer: answer, TimeSeconds: time.Since(start).Seconds(), QueryType: queryType}, nil
+}
+
+// printAnswer writes the answer, its sources and confidence for reading in
+// a terminal.
+func printAnswer(resp *queryResponse) {
+	a := resp.Answer
+	if resp.EnhancedQuestion != "" {
+		fmt.Printf("(searched for: %s)\n\n", resp.EnhancedQuestion)
+	}
+	fmt.Println(strings.TrimSpace(a.Answer))
+	if len(a.Footnotes) > 0 {
+		fmt.Println("\nSources:")
+		for _, fn := range a.Footnotes {
+			fmt.Printf("  [%d] %s, p. %d\n", fn.ID, fn.Document, fn.Page)
+		}
+	}
+	fmt.Printf("\nConfidence: %.0f%%", a.Confidence*100)
+	if a.ConfidenceReason != "" {
+		fmt.Printf(" (%s)", a.ConfidenceReason)
+	}
+	fmt.Printf(" · %.1fs", resp.TimeSeconds)
+	if resp.QueryType != "" {
+		fmt.Printf(" · %s", resp.QueryType)
+	}
+	fmt.Println()
+}
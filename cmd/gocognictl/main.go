@@ -0,0 +1,625 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// gocognictl manages projects and indexes on a running server, wrapping
+// the admin endpoints so operators don't have to script them with curl.
+//
+//	gocognictl projects list
+//	gocognictl projects export "Case 42" case42.zip
+//	gocognictl index verify "Case 42"
+//	gocognictl usage 2026-09
+func main() {
+	_ = godotenv.Load() // Ignore error if .env doesn't exist
+
+	server := flag.String("server", envOr("GOCOGNIGO_URL", "http://localhost:8080"), "URL of the gocognigo server")
+	token := flag.String("token", os.Getenv("GOCOGNIGO_TOKEN"), "bearer token, when the server requires sign-in")
+	asJSON := flag.Bool("json", false, "print the server's JSON responses instead of tables")
+	yes := flag.Bool("yes", false, "don't ask before deleting a project")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), `Usage: gocognictl [flags] <command> [args]
+
+Commands:
+  projects list
+  projects create <name>
+  projects delete <project>
+  projects export <project> [file.zip | -]
+  projects import <file.zip> [name]
+  index verify <project>      exits 1 when problems are found
+  index rebuild <project>     recreate the keyword index from the vectors
+  index reembed <project>     embed every chunk again with the current model
+  index rechunk <project>     cut the pages again with the current chunk size
+  cache list                  indexes held in memory and the cache budget
+  cache evict <project|all>   drop indexes from memory until next queried
+  settings test               exits 1 when a provider check fails
+  usage [YYYY-MM]
+
+<project> is a project ID or name.
+
+Flags:
+`)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	c := &client{server: strings.TrimRight(*server, "/"), token: *token, json: *asJSON}
+
+	cmd := strings.Join(args[:min(2, len(args))], " ")
+	rest := args[min(2, len(args)):]
+	if args[0] == "usage" {
+		cmd, rest = "usage", args[1:]
+	}
+	var err error
+	switch cmd {
+	case "projects list":
+		err = c.listProjects(ctx)
+	case "projects create":
+		err = c.createProject(ctx, arg(rest, 0, "name"))
+	case "projects delete":
+		err = c.deleteProject(ctx, arg(rest, 0, "project"), *yes)
+	case "projects export":
+		err = c.exportProject(ctx, arg(rest, 0, "project"), optArg(rest, 1))
+	case "projects import":
+		err = c.importProject(ctx, arg(rest, 0, "archive"), optArg(rest, 1))
+	case "index verify":
+		err = c.verifyIndex(ctx, arg(rest, 0, "project"))
+	case "index rebuild":
+		err = c.rebuildIndex(ctx, arg(rest, 0, "project"))
+	case "index reembed":
+		err = c.reembedIndex(ctx, arg(rest, 0, "project"))
+	case "index rechunk":
+		err = c.rechunkIndex(ctx, arg(rest, 0, "project"))
+	case "cache list":
+		err = c.listCache(ctx)
+	case "cache evict":
+		err = c.evictCache(ctx, arg(rest, 0, "project"))
+	case "settings test":
+		err = c.testSettings(ctx)
+	case "usage":
+		err = c.usage(ctx, optArg(rest, 0))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", strings.Join(args, " "))
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// arg returns a required positional argument, exiting with usage if absent.
+func arg(args []string, i int, name string) string {
+	if i >= len(args) || args[i] == "" {
+		fmt.Fprintf(os.Stderr, "Missing <%s>\n\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	return args[i]
+}
+
+func optArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// ========== Client ==========
+
+type client struct {
+	server, token string
+	json          bool
+}
+
+// do sends a request and returns the response body, turning a non-2xx
+// status into an error with the server's message.
+func (c *client) do(ctx context.Context, method, path string, body io.Reader, contentType string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach %s: %w", c.server, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("server error (HTTP %d): %s", resp.StatusCode, e.Error)
+		}
+		return nil, fmt.Errorf("server error (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// call sends in (if non-nil) as JSON and decodes the response into out. With
+// -json it prints the response as well.
+func (c *client) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, _ := json.Marshal(in)
+		body, contentType = bytes.NewReader(data), "application/json"
+	}
+	data, err := c.do(ctx, method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, data, "", "  ") == nil {
+			fmt.Println(pretty.String())
+		} else {
+			fmt.Println(string(data))
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unexpected response from %s: %w", path, err)
+	}
+	return nil
+}
+
+type project struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	FileCount  int       `json:"file_count"`
+	ChunkCount int       `json:"chunk_count"`
+	Status     string    `json:"status"`
+}
+
+func (c *client) projects(ctx context.Context) ([]project, error) {
+	var list []project
+	data, err := c.do(ctx, http.MethodGet, "/api/chats", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unexpected project list: %w", err)
+	}
+	return list, nil
+}
+
+// resolve finds a project by ID, or else by name ignoring case.
+func (c *client) resolve(ctx context.Context, ref string) (*project, error) {
+	list, err := c.projects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if list[i].ID == ref {
+			return &list[i], nil
+		}
+	}
+	var found []project
+	for _, p := range list {
+		if strings.EqualFold(p.Name, ref) {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no project with ID or name %q", ref)
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("%d projects are named %q; use the project ID", len(found), ref)
+}
+
+func table() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
+
+// ========== Projects ==========
+
+func (c *client) listProjects(ctx context.Context) error {
+	var list []project
+	if err := c.call(ctx, http.MethodGet, "/api/chats", nil, &list); err != nil || c.json {
+		return err
+	}
+	tw := table()
+	fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tFILES\tCHUNKS\tCREATED")
+	for _, p := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", p.ID, p.Name, p.Status, p.FileCount, p.ChunkCount, p.CreatedAt.Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+func (c *client) createProject(ctx context.Context, name string) error {
+	var p project
+	if err := c.call(ctx, http.MethodPost, "/api/chats", map[string]string{"name": name}, &p); err != nil || c.json {
+		return err
+	}
+	fmt.Printf("Created project %s (%s)\n", p.Name, p.ID)
+	return nil
+}
+
+func (c *client) deleteProject(ctx context.Context, ref string, yes bool) error {
+	p, err := c.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if !yes {
+		fmt.Printf("Delete project %s (%s) with its %d files and every conversation? Type the project name to confirm: ", p.Name, p.ID, p.FileCount)
+		var answer string
+		fmt.Scanln(&answer)
+		if strings.TrimSpace(answer) != p.Name {
+			return fmt.Errorf("not deleted")
+		}
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/chats/delete", map[string]string{"chat_id": p.ID}, nil); err != nil || c.json {
+		return err
+	}
+	fmt.Printf("Deleted project %s (%s)\n", p.Name, p.ID)
+	return nil
+}
+
+// exportProject saves a project archive to file, "<name>.zip" by default or
+// stdout for "-".
+func (c *client) exportProject(ctx context.Context, ref, file string) error {
+	p, err := c.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	data, err := c.do(ctx, http.MethodGet, "/api/projects/export?project_id="+url.QueryEscape(p.ID), nil, "")
+	if err != nil {
+		return err
+	}
+	if file == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if file == "" {
+		file = strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(p.Name) + ".zip"
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Exported project %s to %s (%d bytes)\n", p.Name, file, len(data))
+	return nil
+}
+
+func (c *client) importProject(ctx context.Context, file, name string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	path := "/api/projects/import"
+	if name != "" {
+		path += "?name=" + url.QueryEscape(name)
+	}
+	data, err := c.do(ctx, http.MethodPost, path, f, "application/zip")
+	if err != nil {
+		return err
+	}
+	if c.json {
+		fmt.Println(string(data))
+		return nil
+	}
+	var p project
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+	fmt.Printf("Imported project %s (%s): %d files, %d chunks, %s\n", p.Name, p.ID, p.FileCount, p.ChunkCount, p.Status)
+	return nil
+}
+
+// ========== Index ==========
+
+func (c *client) verifyIndex(ctx context.Context, ref string) error {
+	p, err := c.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	var report struct {
+		OK       bool     `json:"ok"`
+		Problems []string `json:"problems"`
+		Index    struct {
+			Chunks       int    `json:"chunks"`
+			Documents    int    `json:"documents"`
+			DocSummaries int    `json:"doc_summaries"`
+			BM25Docs     uint64 `json:"bm25_docs"`
+			Dimensions   int    `json:"dimensions"`
+		} `json:"index"`
+		FilesNotIndexed      []string `json:"files_not_indexed"`
+		DocumentsWithoutFile []string `json:"documents_without_file"`
+		EmbedModel           string   `json:"embed_model"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/api/index/verify?project_id="+url.QueryEscape(p.ID), nil, &report); err != nil {
+		return err
+	}
+	if !c.json {
+		ix := report.Index
+		fmt.Printf("Project %s (%s)\n", p.Name, p.ID)
+		fmt.Printf("  %d documents, %d chunks, %d summaries, %d keyword entries, %d-dimension vectors (settings embed with %s)\n",
+			ix.Documents, ix.Chunks, ix.DocSummaries, ix.BM25Docs, ix.Dimensions, report.EmbedModel)
+		if report.OK {
+			fmt.Println("  OK: no problems found")
+		}
+		for _, problem := range report.Problems {
+			fmt.Println("  PROBLEM: " + problem)
+		}
+		for _, f := range report.FilesNotIndexed {
+			fmt.Println("    not indexed: " + f)
+		}
+		for _, d := range report.DocumentsWithoutFile {
+			fmt.Println("    no file: " + d)
+		}
+	}
+	if !report.OK {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func (c *client) rebuildIndex(ctx context.Context, ref string) error {
+	p, err := c.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	var out struct {
+		Chunks int `json:"chunks"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/index/rebuild", map[string]string{"project_id": p.ID}, &out); err != nil || c.json {
+		return err
+	}
+	fmt.Printf("Rebuilt the keyword index of %s: %d chunks\n", p.Name, out.Chunks)
+	return nil
+}
+
+// reembedIndex starts a re-embed and follows its progress until it ends.
+func (c *client) reembedIndex(ctx context.Context, ref string) error {
+	p, err := c.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/index/reembed", map[string]string{"project_id": p.ID}, nil); err != nil {
+		return err
+	}
+	if c.json {
+		return nil
+	}
+	fmt.Printf("Re-embedding %s...\n", p.Name)
+	return c.follow(ctx, "re-embed")
+}
+
+// rechunkIndex starts a re-chunk and follows its progress until it ends.
+func (c *client) rechunkIndex(ctx context.Context, ref string) error {
+	p, err := c.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	var out struct {
+		Chunks   int `json:"chunks"`
+		Reusable int `json:"reusable"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/index/rechunk", map[string]string{"project_id": p.ID}, &out); err != nil || c.json {
+		return err
+	}
+	fmt.Printf("Re-chunking %s into %d chunks, %d of them unchanged...\n", p.Name, out.Chunks, out.Reusable)
+	return c.follow(ctx, "re-chunk")
+}
+
+// follow reports the progress of the embedding what started until it ends.
+func (c *client) follow(ctx context.Context, what string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Printf("\nStopped following; the %s continues on the server (see /api/ingest/status)\n", what)
+			return nil
+		case <-time.After(2 * time.Second):
+		}
+		var st struct {
+			Phase       string `json:"phase"`
+			ChunksTotal int    `json:"chunks_total"`
+			ChunksDone  int    `json:"chunks_done"`
+			Error       string `json:"error"`
+		}
+		data, err := c.do(ctx, http.MethodGet, "/api/ingest/status", nil, "")
+		if err != nil {
+			return err
+		}
+		_ = json.Unmarshal(data, &st)
+		fmt.Printf("\r  %d / %d chunks", st.ChunksDone, st.ChunksTotal)
+		switch st.Phase {
+		case "done":
+			fmt.Println("\nDone.")
+			return nil
+		case "error", "cancelled":
+			fmt.Println()
+			return fmt.Errorf("%s %s: %s", what, st.Phase, st.Error)
+		}
+	}
+}
+
+// ========== Settings & Usage ==========
+
+func (c *client) listCache(ctx context.Context) error {
+	var out struct {
+		UsedBytes  int64 `json:"used_bytes"`
+		MaxBytes   int64 `json:"max_bytes"`
+		MaxEntries int   `json:"max_entries"`
+		Entries    []struct {
+			ProjectID      string    `json:"project_id"`
+			EstimatedBytes int64     `json:"estimated_bytes"`
+			Chunks         int       `json:"chunks"`
+			LastUsed       time.Time `json:"last_used"`
+			Active         bool      `json:"active"`
+		} `json:"entries"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/api/index/cache", nil, &out); err != nil || c.json {
+		return err
+	}
+	fmt.Printf("%s of %s in %d of at most %d indexes\n\n", humanBytes(out.UsedBytes), humanBytes(out.MaxBytes), len(out.Entries), out.MaxEntries)
+	names := make(map[string]string)
+	if ps, err := c.projects(ctx); err == nil {
+		for _, p := range ps {
+			names[p.ID] = p.Name
+		}
+	}
+	tw := table()
+	fmt.Fprintln(tw, "PROJECT	NAME	SIZE	CHUNKS	LAST USED	")
+	for _, e := range out.Entries {
+		active := ""
+		if e.Active {
+			active = "active"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", e.ProjectID, names[e.ProjectID], humanBytes(e.EstimatedBytes),
+			e.Chunks, e.LastUsed.Local().Format("2006-01-02 15:04"), active)
+	}
+	return tw.Flush()
+}
+
+func (c *client) evictCache(ctx context.Context, ref string) error {
+	req := map[string]interface{}{"all": true}
+	if ref != "all" {
+		p, err := c.resolve(ctx, ref)
+		if err != nil {
+			return err
+		}
+		req = map[string]interface{}{"project_id": p.ID}
+	}
+	var out struct {
+		Evicted   int   `json:"evicted"`
+		UsedBytes int64 `json:"used_bytes"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/index/cache/evict", req, &out); err != nil || c.json {
+		return err
+	}
+	fmt.Printf("Evicted %d indexes; %s still cached\n", out.Evicted, humanBytes(out.UsedBytes))
+	return nil
+}
+
+func (c *client) testSettings(ctx context.Context) error {
+	var out struct {
+		Results []struct {
+			Provider   string `json:"provider"`
+			Capability string `json:"capability"`
+			Model      string `json:"model"`
+			OK         bool   `json:"ok"`
+			Error      string `json:"error"`
+			LatencyMs  int64  `json:"latency_ms"`
+		} `json:"results"`
+		AllOK bool `json:"all_ok"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/settings/test", nil, &out); err != nil {
+		return err
+	}
+	if !c.json {
+		tw := table()
+		fmt.Fprintln(tw, "PROVIDER\tCAPABILITY\tMODEL\tRESULT\tLATENCY")
+		for _, r := range out.Results {
+			result := "ok"
+			if !r.OK {
+				result = "FAILED: " + r.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%dms\n", r.Provider, r.Capability, r.Model, result, r.LatencyMs)
+		}
+		tw.Flush()
+	}
+	if !out.AllOK {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func (c *client) usage(ctx context.Context, month string) error {
+	type usage struct {
+		ProjectID   string `json:"project_id"`
+		Name        string `json:"name"`
+		Files       int    `json:"files"`
+		UploadBytes int64  `json:"upload_bytes"`
+		Chunks      int    `json:"chunks"`
+		Tokens      int64  `json:"tokens"`
+		Limits      struct {
+			MonthlyTokenBudget int64 `json:"monthly_token_budget"`
+		} `json:"limits"`
+	}
+	var out struct {
+		Month    string  `json:"month"`
+		Projects []usage `json:"projects"`
+		Total    usage   `json:"total"`
+	}
+	path := "/api/usage"
+	if month != "" {
+		path += "?month=" + url.QueryEscape(month)
+	}
+	if err := c.call(ctx, http.MethodGet, path, nil, &out); err != nil || c.json {
+		return err
+	}
+	fmt.Printf("Usage for %s\n\n", out.Month)
+	tw := table()
+	fmt.Fprintln(tw, "PROJECT\tFILES\tSIZE\tCHUNKS\tTOKENS\tBUDGET")
+	for _, u := range out.Projects {
+		budget := "-"
+		if b := u.Limits.MonthlyTokenBudget; b > 0 {
+			budget = fmt.Sprintf("%d (%.0f%%)", b, float64(u.Tokens)*100/float64(b))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\t%s\n", u.Name, u.Files, humanBytes(u.UploadBytes), u.Chunks, u.Tokens, budget)
+	}
+	t := out.Total
+	fmt.Fprintf(tw, "TOTAL\t%d\t%s\t%d\t%d\t\n", t.Files, humanBytes(t.UploadBytes), t.Chunks, t.Tokens)
+	return tw.Flush()
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
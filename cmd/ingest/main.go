@@ -2,80 +2,275 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"gocognigo/internal/chat"
 	"gocognigo/internal/extractor"
 	"gocognigo/internal/indexer"
+	"gocognigo/internal/llm"
 
 	"github.com/joho/godotenv"
 )
 
+// ingest indexes a directory of PDF and DOCX files into a server project,
+// in the same layout the server writes (uploads/, vectors.json and
+// bm25.index under the project's directory), so the server can query the
+// project as soon as it starts. Files the project has already indexed are
+// skipped, so re-running it adds only new files. Stop the server first: it
+// holds the project's index open.
+//
+//	ingest -project "Case 42" -dir ./corpus
+//	ingest -project <id> -dir ./scans -ocr sarvam
 func main() {
 	_ = godotenv.Load() // Ignore error if .env doesn't exist, we will check os.Getenv below
-	embedProvider := os.Getenv("EMBEDDING_PROVIDER")
-	embedAPIKey := os.Getenv("EMBEDDING_API_KEY")
 
+	project := flag.String("project", "", "project ID or name; a project with this name is created if none matches (required)")
+	corpusDir := flag.String("dir", "corpus", "directory holding the PDF and DOCX files to ingest")
+	dataDir := flag.String("data-dir", "data/users/local_dev_user_projects", "the directory holding the projects, as the server lays them out")
+	provider := flag.String("provider", os.Getenv("EMBEDDING_PROVIDER"), "embedding provider: openai or huggingface (must match the server's)")
+	model := flag.String("model", os.Getenv("EMBEDDING_MODEL"), "embedding model (default: the provider's default; must match the server's)")
+	ocr := flag.String("ocr", envOr("OCR_PROVIDER", "tesseract"), "OCR for scanned pages: tesseract, sarvam or none")
+	ocrLang := flag.String("ocr-lang", envOr("TESSERACT_LANG", "eng"), "tesseract language codes, e.g. eng+hin")
+	summaries := flag.Bool("summaries", true, "generate document summaries with the -summary-provider LLM, when its key is set")
+	summaryProvider := flag.String("summary-provider", "openai", "LLM provider for the summaries: openai, anthropic or huggingface")
+	flag.Parse()
+
+	if *project == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	embedAPIKey := os.Getenv("EMBEDDING_API_KEY")
+	if embedAPIKey == "" && *provider == "huggingface" {
+		embedAPIKey = os.Getenv("HUGGINGFACE_API_KEY")
+	}
 	if embedAPIKey == "" {
 		embedAPIKey = os.Getenv("OPENAI_API_KEY") // Fallback
 	}
-
 	if embedAPIKey == "" {
 		log.Fatal("Embedding Key (EMBEDDING_API_KEY or OPENAI_API_KEY) environment variable is required")
 	}
 
-	index, err := indexer.NewIndex(embedProvider, embedAPIKey, "", "bm25.index")
+	ocrCfg := &extractor.OCRConfig{TesseractLang: *ocrLang}
+	switch *ocr {
+	case "tesseract":
+		ocrCfg.Provider = "tesseract"
+		ocrCfg.TesseractOk = extractor.DetectTesseract()
+		if !ocrCfg.TesseractOk {
+			log.Printf("Warning: tesseract not found; scanned pages will have no text")
+		}
+	case "sarvam":
+		ocrCfg.Provider = "sarvam"
+		ocrCfg.SarvamKey = os.Getenv("SARVAM_API_KEY")
+		if ocrCfg.SarvamKey == "" {
+			log.Fatal("SARVAM_API_KEY environment variable is required for -ocr sarvam")
+		}
+	case "none", "":
+	default:
+		log.Fatalf("Unknown OCR provider %q (want tesseract, sarvam or none)", *ocr)
+	}
+
+	var summarizer *llm.Completer
+	if *summaries {
+		keyEnv := map[string]string{"openai": "OPENAI_API_KEY", "anthropic": "ANTHROPIC_API_KEY", "huggingface": "HUGGINGFACE_API_KEY"}[*summaryProvider]
+		if keyEnv == "" {
+			log.Fatalf("Unknown summary provider: %s", *summaryProvider)
+		}
+		if key := os.Getenv(keyEnv); key != "" {
+			summarizer = &llm.Completer{Provider: *summaryProvider, APIKey: key}
+		} else {
+			log.Printf("%s is not set; skipping document summaries", keyEnv)
+		}
+	}
+
+	// Refuse to run beside a server using the same data: in the server's
+	// layout (<data>/users/<user>_projects) it holds the lock on <data>
+	lockDir := *dataDir
+	if filepath.Base(filepath.Dir(filepath.Clean(lockDir))) == "users" {
+		lockDir = filepath.Dir(filepath.Dir(filepath.Clean(lockDir)))
+	}
+	dataLock, err := chat.LockDataDir(lockDir, "ingest")
+	if err != nil {
+		log.Fatalf("%v; stop the server or upload through it instead", err)
+	}
+	defer dataLock.Release()
+
+	store, err := chat.NewProjectStore(*dataDir)
+	if err != nil {
+		log.Fatalf("Failed to open projects in %s: %v", *dataDir, err)
+	}
+	proj, err := findOrCreateProject(store, *project)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if proj.PIIMode == "mask" {
+		log.Fatalf("Project %s masks personal identifiers at ingest; upload its files through the server", proj.Name)
+	}
+	fmt.Printf("Ingesting into project %s (%s)\n", proj.Name, proj.ID)
+
+	index, err := indexer.NewIndex(*provider, embedAPIKey, *model, store.BM25Dir(proj.ID))
 	if err != nil {
 		log.Fatalf("Failed to initialize index: %v", err)
 	}
+	defer index.Close()
+	vectorsPath := store.VectorsPath(proj.ID)
+	if _, err := os.Stat(vectorsPath); err == nil {
+		if err := index.LoadVectors(vectorsPath); err != nil {
+			log.Fatalf("Failed to load the project's vectors: %v", err)
+		}
+	}
+	indexed := make(map[string]bool)
+	for _, c := range index.Chunks {
+		indexed[c.Document] = true
+	}
 
-	corpusDir := "corpus"
-	files, err := os.ReadDir(corpusDir)
+	files, err := os.ReadDir(*corpusDir)
 	if err != nil {
 		log.Fatalf("Failed to read corpus directory: %v", err)
 	}
 
+	ctx := context.Background()
+	uploadsDir := store.UploadsDir(proj.ID)
+	_ = os.MkdirAll(uploadsDir, 0755)
+
 	start := time.Now()
+	added := 0
 	for _, file := range files {
-		if file.IsDir() {
+		ext := strings.ToLower(filepath.Ext(file.Name()))
+		if file.IsDir() || (ext != ".pdf" && ext != ".docx") {
+			continue // Skip other files
+		}
+		if indexed[file.Name()] {
+			fmt.Printf("Skipping %s: already indexed\n", file.Name())
+			continue
+		}
+
+		// Keep a copy in the project so the server lists, previews and can reprocess it
+		path := filepath.Join(uploadsDir, file.Name())
+		if err := copyFile(filepath.Join(*corpusDir, file.Name()), path); err != nil {
+			log.Printf("Failed to copy %s into the project: %v", file.Name(), err)
 			continue
 		}
-		path := filepath.Join(corpusDir, file.Name())
 
 		fmt.Printf("Processing %s...\n", file.Name())
 
-		var chunks []extractor.DocumentChunk
+		var docChunks []extractor.DocumentChunk
 		var extractErr error
-
-		ext := strings.ToLower(filepath.Ext(file.Name()))
 		if ext == ".pdf" {
-			chunks, extractErr = extractor.ExtractPDF(path, nil)
-		} else if ext == ".docx" {
-			chunks, extractErr = extractor.ExtractDOCX(path)
+			docChunks, extractErr = extractor.ExtractPDF(path, ocrCfg)
 		} else {
-			continue // Skip other files
+			docChunks, extractErr = extractor.ExtractDOCX(path)
 		}
-
 		if extractErr != nil {
 			log.Printf("Failed to extract %s: %v", file.Name(), extractErr)
 			continue
 		}
+		if len(docChunks) == 0 {
+			log.Printf("No text extracted from %s", file.Name())
+			continue
+		}
 
-		fmt.Printf("Extracted %d pages from %s\n", len(chunks), file.Name())
+		chunks := index.ChunkPages(docChunks)
+		fmt.Printf("Extracted %d pages from %s (%d chunks)\n", len(docChunks), file.Name(), len(chunks))
 
-		err = index.AddDocument(context.Background(), chunks)
-		if err != nil {
+		if err := index.EmbedAndIndex(ctx, chunks, nil, 0); err != nil {
 			log.Printf("Failed to index %s: %v", file.Name(), err)
+			continue
+		}
+		added++
+
+		if summarizer != nil {
+			var pages []string
+			for _, c := range docChunks {
+				pages = append(pages, c.Text)
+			}
+			summary, err := llm.GenerateDocSummary(ctx, *summarizer, file.Name(), pages, len(pages))
+			if err != nil {
+				log.Printf("Warning: failed to generate summary for %s: %v", file.Name(), err)
+			} else {
+				index.AddDocSummary(*summary)
+			}
 		}
 	}
 
-	fmt.Printf("Finished ingestion in %v. Saving vector index...\n", time.Since(start))
-	if err := index.SaveVectors("vectors.json"); err != nil {
+	fmt.Printf("Finished ingestion of %d files in %v. Saving vector index...\n", added, time.Since(start))
+	if err := index.SaveVectors(vectorsPath); err != nil {
 		log.Fatalf("Failed to save vectors: %v", err)
 	}
-	fmt.Println("Ingestion complete.")
+	if added > 0 {
+		_ = os.Remove(store.GraphPath(proj.ID)) // the server rebuilds the entity graph on first use
+	}
+
+	entries, _ := os.ReadDir(uploadsDir)
+	proj.FileCount = 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			proj.FileCount++
+		}
+	}
+	proj.ChunkCount = len(index.Chunks)
+	proj.Status = "upload"
+	if proj.ChunkCount > 0 {
+		proj.Status = "ready"
+	}
+	if err := store.Update(*proj); err != nil {
+		log.Fatalf("Failed to update the project: %v", err)
+	}
+	fmt.Printf("Ingestion complete: %d chunks in project %s.\n", proj.ChunkCount, proj.ID)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// findOrCreateProject returns the project with ID or name ref (ignoring
+// case), creating a project named ref when none matches.
+func findOrCreateProject(store *chat.ProjectStore, ref string) (*chat.Project, error) {
+	if proj, err := store.Get(ref); err == nil {
+		return proj, nil
+	}
+	for _, p := range store.List() {
+		if strings.EqualFold(p.Name, ref) {
+			return store.Get(p.ID)
+		}
+	}
+	proj, err := store.Create(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create project %s: %w", ref, err)
+	}
+	fmt.Printf("Created project %s (%s)\n", proj.Name, proj.ID)
+	return proj, nil
+}
+
+// copyFile copies src to dst, unless they are the same file.
+func copyFile(src, dst string) error {
+	if srcInfo, err := os.Stat(src); err != nil {
+		return err
+	} else if dstInfo, err := os.Stat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
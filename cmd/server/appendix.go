@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocognigo/internal/analysis"
+	"gocognigo/internal/chat"
+	"gocognigo/internal/extractor"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/llm"
+	"gocognigo/internal/pdfdoc"
+)
+
+// ========== Citation Appendix ==========
+
+// maxAppendixPages caps the cited pages one appendix compiles, as each may
+// be rendered.
+const maxAppendixPages = 200
+
+// citedAnswer is an answer whose citations go into an appendix, labelled
+// Q1, Q2, … in the order asked.
+type citedAnswer struct {
+	Label     string
+	Question  string
+	Answer    string
+	Footnotes []llm.Footnote
+}
+
+// appendixPage is one cited page and the answers citing it.
+type appendixPage struct {
+	Ref   pageRef
+	Cites []appendixCite
+}
+
+type appendixCite struct {
+	Answer *citedAnswer
+	Marker int
+}
+
+// collectCitedPages lists the pages answers cite, in the order first cited.
+func collectCitedPages(answers []*citedAnswer) []*appendixPage {
+	var pages []*appendixPage
+	byRef := map[pageRef]*appendixPage{}
+	for _, a := range answers {
+		for _, fn := range a.Footnotes {
+			if fn.Document == "" || fn.Page < 1 {
+				continue
+			}
+			ref := pageRef{Document: fn.Document, Page: fn.Page}
+			p, ok := byRef[ref]
+			if !ok {
+				p = &appendixPage{Ref: ref}
+				byRef[ref] = p
+				pages = append(pages, p)
+			}
+			p.Cites = append(p.Cites, appendixCite{Answer: a, Marker: fn.ID})
+		}
+	}
+	return pages
+}
+
+// conversationAnswers returns a conversation's current answers with the
+// questions they answer.
+func conversationAnswers(msgs []chat.Message) []*citedAnswer {
+	var answers []*citedAnswer
+	question := ""
+	for _, m := range msgs {
+		if m.SupersededBy != "" {
+			continue
+		}
+		switch m.Role {
+		case "user":
+			question = strings.TrimSpace(m.Content)
+		case "assistant":
+			answers = append(answers, &citedAnswer{
+				Label:     fmt.Sprintf("Q%d", len(answers)+1),
+				Question:  question,
+				Answer:    m.Content,
+				Footnotes: messageFootnotes(m),
+			})
+		}
+	}
+	return answers
+}
+
+// messageFootnotes returns the footnotes of an assistant message: they are
+// []llm.Footnote when saved, JSON objects once reloaded.
+func messageFootnotes(m chat.Message) []llm.Footnote {
+	var footnotes []llm.Footnote
+	if raw, err := json.Marshal(m.Metadata["footnotes"]); err == nil {
+		_ = json.Unmarshal(raw, &footnotes)
+	}
+	return footnotes
+}
+
+// batchAnswers returns a batch's answered questions.
+func batchAnswers(rec *batchRecord) []*citedAnswer {
+	var answers []*citedAnswer
+	for _, res := range rec.Results {
+		if res.Answer == nil {
+			continue
+		}
+		answers = append(answers, &citedAnswer{
+			Label:     fmt.Sprintf("Q%d", res.Index+1),
+			Question:  res.Question,
+			Answer:    res.Answer.Answer,
+			Footnotes: res.Answer.Footnotes,
+		})
+	}
+	return answers
+}
+
+// handleCitationAppendix compiles the pages a conversation's or a batch's
+// answers cite into a PDF appendix (GET ?project_id&conversation_id or
+// &batch_id), for deliverables. Each cited page follows an index of them,
+// as its extracted text with the cited sentences highlighted or, with
+// pages=images, as a rendered image of the page.
+func (s *Server) handleCitationAppendix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	convID, batchID := q.Get("conversation_id"), q.Get("batch_id")
+	if projectID == "" || (convID == "") == (batchID == "") {
+		jsonErr(w, "project_id and one of conversation_id or batch_id are required", http.StatusBadRequest)
+		return
+	}
+	mode := q.Get("pages")
+	if mode == "" {
+		mode = "text"
+	}
+	if mode != "text" && mode != "images" {
+		jsonErr(w, "pages must be text or images", http.StatusBadRequest)
+		return
+	}
+
+	store := s.getProjectStore(r)
+	proj, err := store.Get(projectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	var answers []*citedAnswer
+	var source, target string
+	if convID != "" {
+		conv, err := store.GetConversation(projectID, convID)
+		if err != nil {
+			jsonErr(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		msgs, err := store.LoadMessages(projectID, convID)
+		if err != nil {
+			jsonErr(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+		answers = conversationAnswers(msgs)
+		source, target = conv.Name, convID
+		if source == "" {
+			source = "Untitled Conversation"
+		}
+	} else {
+		rec, err := s.jobRecord(store, projectID, batchID)
+		if err != nil {
+			jsonErr(w, "Batch not found", http.StatusNotFound)
+			return
+		}
+		answers = batchAnswers(rec)
+		source, target = "Batch "+batchID, batchID
+		if rec.SourceFile != "" {
+			source += " (" + rec.SourceFile + ")"
+		}
+	}
+
+	pages := collectCitedPages(answers)
+	if len(pages) == 0 {
+		jsonErr(w, "The answers cite no pages", http.StatusNotFound)
+		return
+	}
+	omitted := 0
+	if len(pages) > maxAppendixPages {
+		omitted = len(pages) - maxAppendixPages
+		pages = pages[:maxAppendixPages]
+	}
+
+	// Page texts come from the index; without one, only renders are left
+	var chunks []indexer.Chunk
+	if rw, err := s.getRetrieverForProject(projectID); err == nil {
+		chunks = rw.ret.Chunks
+	} else if mode == "text" {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+
+	doc := pdfdoc.New("Cited pages — " + source)
+	writeAppendixIndex(doc, proj.Name, source, answers, pages, omitted)
+	texts := map[string]map[int]string{}
+	for i, p := range pages {
+		doc.NewPage()
+		doc.Heading(fmt.Sprintf("A%d. %s, p. %d", i+1, p.Ref.Document, p.Ref.Page))
+		for _, c := range p.Cites {
+			doc.Note(fmt.Sprintf("Cited by %s [%d]: %s", c.Answer.Label, c.Marker, c.Answer.Question))
+		}
+
+		if mode == "images" {
+			err := appendPageImage(doc, filepath.Join(store.UploadsDir(projectID), filepath.Base(p.Ref.Document)), p.Ref.Page)
+			if err == nil {
+				continue
+			}
+			doc.Note("The page couldn't be rendered (" + err.Error() + "); its extracted text follows.")
+		}
+
+		if texts[p.Ref.Document] == nil {
+			texts[p.Ref.Document] = map[int]string{}
+			for _, passage := range corpusPassages(chunks, p.Ref.Document) {
+				texts[p.Ref.Document][passage.Page] = passage.Text
+			}
+		}
+		text, ok := texts[p.Ref.Document][p.Ref.Page]
+		if !ok {
+			doc.Note("The page's text isn't in the index.")
+			continue
+		}
+		var spans []analysis.Span
+		for _, c := range p.Cites {
+			spans = append(spans, analysis.CitedSpans(c.Answer.Answer, analysis.SourcePage{
+				Marker: c.Marker, Document: p.Ref.Document, Page: p.Ref.Page, Text: text,
+			})...)
+		}
+		doc.Paragraph(highlightRuns(text, spans)...)
+	}
+
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		jsonErr(w, "Failed to write the appendix: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "citations.appendix", projectID, target, map[string]string{"pages": strconv.Itoa(len(pages)), "mode": mode})
+
+	filename := sanitizeFilename(source+" cited pages") + ".pdf"
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeAppendixIndex writes the appendix's first page: what it covers, the
+// questions and the cited pages.
+func writeAppendixIndex(doc *pdfdoc.Document, projectName, source string, answers []*citedAnswer, pages []*appendixPage, omitted int) {
+	doc.Heading("Cited pages — " + source)
+	doc.Note(fmt.Sprintf("Project: %s · Exported %s · %d pages cited", projectName, time.Now().Format("January 2, 2006"), len(pages)+omitted))
+	if omitted > 0 {
+		doc.Note(fmt.Sprintf("Only the first %d cited pages are included; %d more are left out.", len(pages), omitted))
+	}
+
+	doc.Heading("Questions")
+	for _, a := range answers {
+		if len(a.Footnotes) > 0 {
+			doc.Paragraph(pdfdoc.Run{Text: a.Label + " ", Bold: true}, pdfdoc.Run{Text: a.Question})
+		}
+	}
+
+	doc.Heading("Pages")
+	for i, p := range pages {
+		var cites []string
+		for _, c := range p.Cites {
+			cites = append(cites, fmt.Sprintf("%s [%d]", c.Answer.Label, c.Marker))
+		}
+		doc.Paragraph(pdfdoc.Run{Text: fmt.Sprintf("A%d ", i+1), Bold: true},
+			pdfdoc.Run{Text: fmt.Sprintf("%s, p. %d — cited by %s", p.Ref.Document, p.Ref.Page, strings.Join(cites, ", "))})
+	}
+}
+
+// appendPageImage adds a rendered page of an uploaded PDF.
+func appendPageImage(doc *pdfdoc.Document, path string, page int) error {
+	if strings.ToLower(filepath.Ext(path)) != ".pdf" {
+		return fmt.Errorf("not a PDF")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("the upload is gone")
+	}
+	png, err := extractor.RenderPage(path, page, extractor.DefaultRenderDPI)
+	if err != nil {
+		return err
+	}
+	return doc.Image(png)
+}
+
+// highlightRuns splits text into runs, highlighting the spans.
+func highlightRuns(text string, spans []analysis.Span) []pdfdoc.Run {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	var runs []pdfdoc.Run
+	at := 0
+	for _, sp := range spans {
+		if sp.End <= at {
+			continue // within one already highlighted
+		}
+		start := max(sp.Start, at)
+		if start > at {
+			runs = append(runs, pdfdoc.Run{Text: text[at:start]})
+		}
+		runs = append(runs, pdfdoc.Run{Text: text[start:sp.End], Highlight: true})
+		at = sp.End
+	}
+	if at < len(text) {
+		runs = append(runs, pdfdoc.Run{Text: text[at:]})
+	}
+	return runs
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one administrative or destructive action.
+type AuditEntry struct {
+	ID        string            `json:"id"`
+	Time      time.Time         `json:"time"`
+	UserUID   string            `json:"user_uid,omitempty"`
+	UserEmail string            `json:"user_email,omitempty"`
+	Workspace string            `json:"workspace_id,omitempty"`
+	Action    string            `json:"action"` // e.g. "settings.update", "project.delete"
+	ProjectID string            `json:"project_id,omitempty"`
+	Target    string            `json:"target,omitempty"` // file name, conversation ID, ...
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// The audit log is a JSON-lines file that is only ever appended to, so an
+// entry can never be rewritten by a later one.
+const auditPath = "data/audit.jsonl"
+
+var auditMu sync.Mutex
+
+// recordAudit appends an entry for the requesting user. Failures are logged
+// but never fail the action being audited.
+func recordAudit(r *http.Request, action, projectID, target string, details map[string]string) {
+	entry := AuditEntry{
+		ID:        newID(),
+		Time:      time.Now(),
+		UserUID:   getUserUID(r),
+		UserEmail: getUserEmail(r),
+		Workspace: getWorkspaceID(r),
+		Action:    action,
+		ProjectID: projectID,
+		Target:    target,
+		Details:   details,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Warning: audit marshal failed: %v", err)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	_ = os.MkdirAll("data", 0755)
+	f, err := os.OpenFile(auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Warning: could not open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("Warning: audit write failed: %v", err)
+	}
+}
+
+// loadAudit reads every entry, oldest first, keeping those accepted by keep.
+func loadAudit(keep func(AuditEntry) bool) ([]AuditEntry, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	f, err := os.Open(auditPath)
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // skip a torn line rather than hide the rest of the log
+		}
+		if keep(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// handleAudit returns audit entries newest first, paginated with ?limit= (default
+// 50, max 500) and ?offset=. The admin sees every user's entries, optionally
+// filtered with ?user=; everyone else sees only their own. Within a
+// workspace, only its entries are listed, and its owners and admins see
+// every member's.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	uid := getUserUID(r)
+	adminUID := os.Getenv("ADMIN_UID")
+	isAdmin := adminUID != "" && uid == adminUID
+	workspaceID := getWorkspaceID(r)
+	if workspaceID != "" {
+		if ws, err := s.workspaces.Get(workspaceID); err == nil && ws.CanManage(requestUID(r), getVerifiedEmail(r)) {
+			isAdmin = true
+		}
+	}
+	userFilter := r.URL.Query().Get("user")
+	action := r.URL.Query().Get("action")
+
+	entries, err := loadAudit(func(e AuditEntry) bool {
+		if workspaceID != "" && e.Workspace != workspaceID {
+			return false
+		}
+		if !isAdmin && e.UserUID != uid {
+			return false
+		}
+		if isAdmin && userFilter != "" && e.UserUID != userFilter {
+			return false
+		}
+		return action == "" || e.Action == action
+	})
+	if err != nil {
+		jsonErr(w, "failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+
+	// Newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	total := len(entries)
+	page := []AuditEntry{}
+	if offset < total {
+		end := offset + limit
+		if end > total {
+			end = total
+		}
+		page = entries[offset:end]
+	}
+
+	jsonResp(w, map[string]interface{}{
+		"entries": page,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
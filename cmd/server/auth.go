@@ -133,6 +133,9 @@ type firebaseToken struct {
 	ExpiresAt int64  `json:"exp"`
 	Email     string `json:"email,omitempty"`
 	Name      string `json:"name,omitempty"`
+	// EmailVerified is set once the user proved they own Email; until
+	// then anyone could have signed up with it.
+	EmailVerified bool `json:"email_verified,omitempty"`
 }
 
 // verifyFirebaseToken verifies a Firebase ID token and returns the user UID.
@@ -232,8 +235,10 @@ type contextKey string
 
 const userUIDKey contextKey = "userUID"
 const userEmailKey contextKey = "userEmail"
+const userEmailVerifiedKey contextKey = "userEmailVerified"
 
-// authMiddleware checks for a valid Firebase ID token on API requests.
+// authMiddleware checks for a valid Firebase ID token on API requests,
+// then resolves the workspace the request names (see serveInWorkspace).
 // If FIREBASE_PROJECT_ID is not set, auth is disabled (local dev mode).
 func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	projectID := strings.TrimSpace(os.Getenv("FIREBASE_PROJECT_ID"))
@@ -241,7 +246,7 @@ func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Skip auth if not configured (local dev mode)
 		if projectID == "" {
-			next(w, r)
+			s.serveInWorkspace(next, w, r)
 			return
 		}
 
@@ -251,7 +256,7 @@ func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		if path == "/api/auth/config" ||
 			path == "/api/community" ||
 			path == "/api/community/tags" ||
-			!strings.HasPrefix(path, "/api/") {
+			(!strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/v1/")) {
 			next(w, r)
 			return
 		}
@@ -278,7 +283,8 @@ func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		// Store user UID and email in request context
 		ctx := context.WithValue(r.Context(), userUIDKey, claims.Subject)
 		ctx = context.WithValue(ctx, userEmailKey, claims.Email)
-		next(w, r.WithContext(ctx))
+		ctx = context.WithValue(ctx, userEmailVerifiedKey, claims.EmailVerified)
+		s.serveInWorkspace(next, w, r.WithContext(ctx))
 	}
 }
 
@@ -295,3 +301,27 @@ func getUserEmail(r *http.Request) string {
 	email, _ := r.Context().Value(userEmailKey).(string)
 	return email
 }
+
+// getVerifiedEmail returns the user's email if the token vouches for it,
+// else "". Only a verified email may match a workspace invite.
+func getVerifiedEmail(r *http.Request) string {
+	if verified, _ := r.Context().Value(userEmailVerifiedKey).(bool); !verified {
+		return ""
+	}
+	return getUserEmail(r)
+}
+
+// requireAdmin reports whether the request comes from the configured
+// ADMIN_UID, writing a 403 response if not.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	adminUID := os.Getenv("ADMIN_UID")
+	if adminUID == "" {
+		jsonErr(w, "admin access not configured", http.StatusForbidden)
+		return false
+	}
+	if getUserUID(r) != adminUID {
+		jsonErr(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocognigo/internal/backup"
+	"gocognigo/internal/storage"
+)
+
+// ========== Backups ==========
+
+// With backupTargetEnv set, the server snapshots its data — projects'
+// indexes, metadata and conversations, their uploads unless
+// backupExcludeUploadsEnv is set, settings and workspaces — into a zip in
+// that directory or bucket on the cron schedule backupScheduleEnv, and
+// keeps the newest backupKeepEnv of them. "server restore-backup" puts one
+// back. Like durable storage, backups leave out the key file: set
+// GOCOGNIGO_PASSPHRASE so a restored server can read the saved API keys.
+
+const (
+	backupTargetEnv         = "BACKUP_TARGET"
+	backupScheduleEnv       = "BACKUP_SCHEDULE"
+	backupKeepEnv           = "BACKUP_KEEP"
+	backupExcludeUploadsEnv = "BACKUP_EXCLUDE_UPLOADS"
+)
+
+const (
+	defaultBackupSchedule = "0 3 * * *"
+	defaultBackupKeep     = 7
+)
+
+// backupTarget opens the storage backups go to, from location or, if that
+// is empty, backupTargetEnv.
+func backupTarget(location string) (storage.Storage, error) {
+	if location = strings.TrimSpace(location); location == "" {
+		location = strings.TrimSpace(os.Getenv(backupTargetEnv))
+	}
+	if location == "" {
+		return nil, fmt.Errorf("no backup location: set %s", backupTargetEnv)
+	}
+	return storage.Open(location)
+}
+
+// startBackups runs the scheduled backups, if backupTargetEnv is set.
+func startBackups() error {
+	if strings.TrimSpace(os.Getenv(backupTargetEnv)) == "" {
+		return nil
+	}
+	dst, err := backupTarget("")
+	if err != nil {
+		return err
+	}
+	expr := os.Getenv(backupScheduleEnv)
+	if strings.TrimSpace(expr) == "" {
+		expr = defaultBackupSchedule
+	}
+	sched, err := backup.ParseSchedule(expr)
+	if err != nil {
+		return err
+	}
+	keep := defaultBackupKeep
+	if n, err := strconv.Atoi(os.Getenv(backupKeepEnv)); err == nil && n > 0 {
+		keep = n
+	}
+	opts := backup.Options{Skip: mirrorSkip}
+	opts.ExcludeUploads, _ = strconv.ParseBool(os.Getenv(backupExcludeUploadsEnv))
+
+	next := sched.Next(time.Now())
+	if next.IsZero() {
+		return fmt.Errorf("backup schedule %q never runs", expr)
+	}
+	log.Printf("Backups to %s on %q, keeping %d; next at %s", dst, expr, keep, next.Format(time.RFC3339))
+	go func() {
+		for !next.IsZero() {
+			time.Sleep(time.Until(next))
+			runBackup(dst, opts, keep)
+			next = sched.Next(time.Now())
+		}
+	}()
+	return nil
+}
+
+// runBackup takes a backup and rotates the old ones out. With durable
+// storage it backs up the stored copy, which holds the projects not yet
+// fetched too, once the latest changes are pushed.
+func runBackup(dst storage.Storage, opts backup.Options, keep int) {
+	var src storage.Storage = &storage.Local{Root: "data"}
+	if dataMirror != nil {
+		pushDataStorage()
+		src = dataMirror.Store
+	}
+	start := time.Now()
+	info, m, err := backup.Create(src, dst, opts, start)
+	if err != nil {
+		log.Printf("Backup failed: %v", err)
+		return
+	}
+	log.Printf("Backup %s: %d files (%d bytes, %d compressed) in %v",
+		info.Name, m.Files, m.Bytes, info.Size, time.Since(start).Round(time.Millisecond))
+	deleted, err := backup.Rotate(dst, keep)
+	if err != nil {
+		log.Printf("Backup rotation failed: %v", err)
+	}
+	for _, name := range deleted {
+		log.Printf("Backup %s rotated out", name)
+	}
+}
+
+// runRestoreBackup implements the "restore-backup" subcommand: it replaces
+// data/ (and, with durable storage, what it holds) with a backup and exits.
+// Run it with the server stopped.
+//
+//	server restore-backup -list
+//	server restore-backup                                   # the latest backup
+//	server restore-backup -backup gocognigo-backup-20260314T030000Z.zip -force
+func runRestoreBackup(args []string) {
+	fs := flag.NewFlagSet("restore-backup", flag.ExitOnError)
+	from := fs.String("from", "", "backup directory or s3://bucket/prefix (default $"+backupTargetEnv+")")
+	name := fs.String("backup", "", "backup to restore (default the latest)")
+	list := fs.Bool("list", false, "list the backups and exit")
+	force := fs.Bool("force", false, "replace existing data")
+	_ = fs.Parse(args)
+
+	dst, err := backupTarget(*from)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	backups, err := backup.List(dst)
+	if err != nil {
+		log.Fatalf("FATAL: could not list the backups in %s: %v", dst, err)
+	}
+	if *list {
+		for _, b := range backups {
+			fmt.Printf("%s\t%s\t%d bytes\n", b.Name, b.CreatedAt.Local().Format(time.RFC3339), b.Size)
+		}
+		return
+	}
+	if *name == "" {
+		if len(backups) == 0 {
+			log.Fatalf("FATAL: no backups in %s", dst)
+		}
+		*name = backups[0].Name
+	}
+
+	// With durable storage, restore into a full working copy of it, so that
+	// pushing it afterwards leaves the storage as the backup
+	var m *storage.Mirror
+	if location := strings.TrimSpace(os.Getenv(storageEnv)); location != "" {
+		st, err := storage.Open(location)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		m = storage.NewMirror("data", st, mirrorSkip)
+		if _, err := m.Pull("", nil); err != nil {
+			log.Fatalf("FATAL: could not fetch the data from %s: %v", st, err)
+		}
+	}
+
+	if !*force {
+		existing, err := (&storage.Local{Root: "data"}).List("")
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		for _, o := range existing {
+			if !mirrorSkip(o.Key) {
+				log.Fatalf("FATAL: data/ is not empty; use -force to replace it with %s", *name)
+			}
+		}
+	}
+
+	manifest, err := backup.Restore(dst, *name, "data", mirrorSkip)
+	if err != nil {
+		log.Fatalf("FATAL: restore failed: %v", err)
+	}
+	log.Printf("Restored %s: %d files from %s", *name, manifest.Files, manifest.CreatedAt.Local().Format(time.RFC3339))
+	if !manifest.Uploads {
+		log.Printf("The backup has no uploads; the uploads already in data/ were kept")
+	}
+	if m != nil {
+		stored, deleted, err := m.Push("")
+		if err != nil {
+			log.Fatalf("FATAL: could not store the restored data in %s: %v", m.Store, err)
+		}
+		log.Printf("Durable storage: stored %d files, deleted %d", stored, deleted)
+	}
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/llm"
+	"gocognigo/internal/xlsx"
+)
+
+// ========== Background Batch Jobs ==========
+
+// A batch job is a question list uploaded as CSV or JSON and answered in the
+// background, for lists too long to hold an HTTP request open on. Jobs share
+// the on-disk record format of synchronous batches, so a job that partly
+// failed can be resumed through POST /api/batch with resume_batch_id.
+
+const maxJobQuestions = 1000
+
+// batchJob is a submitted job plus everything its worker needs. The provider
+// and the project's system prompt are resolved at submission, while the
+// request and its user settings are still at hand.
+type batchJob struct {
+	mu         sync.Mutex
+	rec        *batchRecord
+	store      *chat.ProjectStore
+	client     llm.Provider
+	sysPrompt  string
+	basePrompt string
+	redact     bool
+	cancel     context.CancelFunc // set once the job runs
+	cancelled  bool               // cancelled before it ran
+
+	docTypePrompts map[string]string // the submitter's per-type prompt overrides
+	mail           *mailConfig       // where to email the results, if set
+}
+
+// jobQueue runs batch jobs on a fixed pool of workers, in submission order.
+// Each job's questions still go through the per-provider LLM limiter. It
+// also tracks synchronous batches in flight, so either kind can be
+// cancelled by ID.
+type jobQueue struct {
+	queue chan *batchJob
+
+	mu     sync.Mutex
+	jobs   map[string]*batchJob   // queued and running jobs, keyed by batch ID
+	inline map[string]inlineBatch // synchronous batches (POST /api/batch) in flight
+}
+
+// inlineBatch is a synchronous batch that can be cancelled.
+type inlineBatch struct {
+	store     *chat.ProjectStore
+	projectID string
+	cancel    context.CancelFunc
+}
+
+// newJobQueue starts the worker pool. BATCH_JOB_WORKERS sets its size
+// (default 2).
+func newJobQueue(s *Server) *jobQueue {
+	workers, err := strconv.Atoi(os.Getenv("BATCH_JOB_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = 2
+	}
+	q := &jobQueue{
+		queue:  make(chan *batchJob, 256),
+		jobs:   make(map[string]*batchJob),
+		inline: make(map[string]inlineBatch),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range q.queue {
+				s.runBatchJob(job)
+				q.mu.Lock()
+				delete(q.jobs, job.rec.ID)
+				q.mu.Unlock()
+			}
+		}()
+	}
+	return q
+}
+
+// submit enqueues a job, failing rather than blocking when the queue is full.
+func (q *jobQueue) submit(job *batchJob) error {
+	q.mu.Lock()
+	q.jobs[job.rec.ID] = job
+	q.mu.Unlock()
+
+	select {
+	case q.queue <- job:
+		return nil
+	default:
+		q.mu.Lock()
+		delete(q.jobs, job.rec.ID)
+		q.mu.Unlock()
+		return fmt.Errorf("job queue is full, try again later")
+	}
+}
+
+// track registers a synchronous batch as cancellable until untrack is
+// called.
+func (q *jobQueue) track(store *chat.ProjectStore, projectID, batchID string, cancel context.CancelFunc) (untrack func()) {
+	q.mu.Lock()
+	q.inline[batchID] = inlineBatch{store: store, projectID: projectID, cancel: cancel}
+	q.mu.Unlock()
+	return func() {
+		q.mu.Lock()
+		delete(q.inline, batchID)
+		q.mu.Unlock()
+	}
+}
+
+// cancel stops a batch of the given store and project: a queued job never
+// runs, and a running job or synchronous batch stops its LLM calls, leaving
+// unanswered questions pending for a resume. It reports whether the batch
+// was found.
+func (q *jobQueue) cancel(store *chat.ProjectStore, projectID, batchID string) bool {
+	q.mu.Lock()
+	job, isJob := q.jobs[batchID]
+	b, isInline := q.inline[batchID]
+	q.mu.Unlock()
+
+	switch {
+	case isInline && b.store == store && b.projectID == projectID:
+		b.cancel()
+		return true
+	case isJob && job.store == store && job.rec.ProjectID == projectID:
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		if job.cancel != nil {
+			job.cancel()
+		} else {
+			job.cancelled = true
+		}
+		return true
+	}
+	return false
+}
+
+// snapshot returns a copy of an active job's record, or nil if the job is not
+// queued or running.
+func (q *jobQueue) snapshot(batchID string) *batchRecord {
+	q.mu.Lock()
+	job, ok := q.jobs[batchID]
+	q.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	rec := *job.rec
+	rec.Results = append([]BatchResult(nil), job.rec.Results...)
+	return &rec
+}
+
+// runBatchJob answers every pending question of a job, saving the record as
+// each one finishes so progress survives a restart.
+func (s *Server) runBatchJob(job *batchJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	job.mu.Lock()
+	job.cancel = cancel
+	rec := job.rec
+	if job.cancelled {
+		rec.Status = "cancelled"
+		rec.UpdatedAt = time.Now()
+		s.saveJobRecord(job)
+		job.mu.Unlock()
+		log.Printf("Batch job %s cancelled before it ran", rec.ID)
+		return
+	}
+	rec.Status = "running"
+	rec.UpdatedAt = time.Now()
+	s.saveJobRecord(job)
+	projectID := rec.ProjectID
+	work := append([]BatchResult(nil), rec.Results...)
+	job.mu.Unlock()
+
+	finish := func(status, errMsg string) {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		rec.Status = status
+		rec.Error = errMsg
+		rec.UpdatedAt = time.Now()
+		s.saveJobRecord(job)
+		if job.mail != nil {
+			notifyBatchJobDone(*job.mail, job.store, rec)
+		}
+	}
+
+	rw, err := s.getRetrieverForProject(projectID)
+	if err != nil {
+		finish("failed", "No documents indexed. Upload and process documents first.")
+		return
+	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		finish("failed", err.Error())
+		return
+	}
+
+	var pending []int
+	for i, res := range work {
+		if res.Status != "ok" {
+			pending = append(pending, i)
+		}
+	}
+
+	log.Printf("Batch job %s: answering %d questions for project %s", rec.ID, len(pending), projectID)
+	runner := &batchRunner{rw: rw, client: job.client, customSysPrompt: job.sysPrompt, basePrompt: job.basePrompt, redact: job.redact, docTypePrompts: job.docTypePrompts}
+	var usage llm.Usage
+	runner.run(ctx, work, pending, func(res BatchResult) {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		rec.Results[res.Index] = res
+		rec.UpdatedAt = time.Now()
+		if res.Answer != nil && res.Answer.Usage != nil {
+			usage.InputTokens += res.Answer.Usage.InputTokens
+			usage.OutputTokens += res.Answer.Usage.OutputTokens
+		}
+		s.saveJobRecord(job)
+	})
+	recordTokenUsage(job.store, projectID, &usage)
+
+	if ctx.Err() != nil {
+		finish("cancelled", "")
+		log.Printf("Batch job %s cancelled", rec.ID)
+		return
+	}
+	finish("done", "")
+	log.Printf("Batch job %s finished", rec.ID)
+}
+
+// saveJobRecord persists a job's record. The caller holds job.mu.
+func (s *Server) saveJobRecord(job *batchJob) {
+	if err := saveBatchRecord(job.store, job.rec); err != nil {
+		log.Printf("Warning: failed to save batch job %s: %v", job.rec.ID, err)
+	}
+}
+
+// parseQuestionFile reads a question list from an uploaded file. CSV files use
+// the column headed "question" if there is one, otherwise the first column of
+// every row. JSON files hold an array of strings, an array of objects with a
+// "question" field, or an object with a "questions" array.
+func parseQuestionFile(name string, data []byte) ([]string, error) {
+	var questions []string
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		rows, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		col := 0
+		if len(rows) > 0 {
+			for i, cell := range rows[0] {
+				if strings.EqualFold(strings.TrimSpace(cell), "question") {
+					col = i
+					rows = rows[1:]
+					break
+				}
+			}
+		}
+		for _, row := range rows {
+			if col < len(row) {
+				questions = append(questions, row[col])
+			}
+		}
+
+	case ".json":
+		var list []string
+		var objects []struct {
+			Question string `json:"question"`
+		}
+		var wrapped struct {
+			Questions []string `json:"questions"`
+		}
+		switch {
+		case json.Unmarshal(data, &list) == nil:
+			questions = list
+		case json.Unmarshal(data, &objects) == nil:
+			for _, o := range objects {
+				questions = append(questions, o.Question)
+			}
+		case json.Unmarshal(data, &wrapped) == nil:
+			questions = wrapped.Questions
+		default:
+			return nil, fmt.Errorf("invalid JSON: expected an array of questions")
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported file type %q (use .csv or .json)", filepath.Ext(name))
+	}
+
+	// Drop blank rows so a trailing newline doesn't become a question
+	out := questions[:0]
+	for _, q := range questions {
+		if q = strings.TrimSpace(q); q != "" {
+			out = append(out, q)
+		}
+	}
+	return out, nil
+}
+
+// batchJobSummary is a job's status without its results.
+type batchJobSummary struct {
+	ID         string    `json:"id"`
+	ProjectID  string    `json:"project_id"`
+	Source     string    `json:"source"`
+	SourceFile string    `json:"source_file,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Provider   string    `json:"provider,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	Total      int       `json:"total"`
+	Done       int       `json:"done"`
+	Succeeded  int       `json:"succeeded"`
+	Failed     int       `json:"failed"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func summarizeBatch(rec *batchRecord) batchJobSummary {
+	sum := batchJobSummary{
+		ID:         rec.ID,
+		ProjectID:  rec.ProjectID,
+		Source:     rec.Source,
+		SourceFile: rec.SourceFile,
+		Status:     rec.Status,
+		Error:      rec.Error,
+		Provider:   rec.Provider,
+		Model:      rec.Model,
+		Total:      len(rec.Results),
+		CreatedAt:  rec.CreatedAt,
+		UpdatedAt:  rec.UpdatedAt,
+	}
+	for _, res := range rec.Results {
+		switch res.Status {
+		case "ok":
+			sum.Succeeded++
+		case "error":
+			sum.Failed++
+		}
+	}
+	sum.Done = sum.Succeeded + sum.Failed
+	return sum
+}
+
+// jobRecord returns the live state of a queued or running job, falling back
+// to its saved record.
+func (s *Server) jobRecord(store *chat.ProjectStore, projectID, batchID string) (*batchRecord, error) {
+	if rec := s.batchJobs.snapshot(batchID); rec != nil && rec.ProjectID == projectID {
+		return rec, nil
+	}
+	return loadBatchRecord(store, projectID, batchID)
+}
+
+// handleBatchJobs submits a job (POST, multipart with a "file" field plus
+// project_id, provider and model) or lists a project's batches (GET
+// ?project_id=), newest first.
+func (s *Server) handleBatchJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.URL.Query().Get("project_id")
+		store := s.getProjectStore(r)
+		if _, err := store.Get(projectID); err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		entries, _ := os.ReadDir(filepath.Join(store.ProjectDir(projectID), "batches"))
+		jobs := []batchJobSummary{}
+		for _, e := range entries {
+			id := strings.TrimSuffix(e.Name(), ".json")
+			if e.IsDir() || id == e.Name() {
+				continue
+			}
+			if rec, err := s.jobRecord(store, projectID, id); err == nil {
+				jobs = append(jobs, summarizeBatch(rec))
+			}
+		}
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+		jsonResp(w, map[string]interface{}{"jobs": jobs})
+
+	case http.MethodPost:
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			jsonErr(w, "Failed to parse upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		projectID := r.FormValue("project_id")
+		if projectID == "" {
+			jsonErr(w, "project_id is required", http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			jsonErr(w, "A CSV or JSON file of questions is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			jsonErr(w, "Failed to read upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		questions, err := parseQuestionFile(header.Filename, data)
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(questions) == 0 {
+			jsonErr(w, "No questions found in file", http.StatusBadRequest)
+			return
+		}
+		if len(questions) > maxJobQuestions {
+			jsonErr(w, fmt.Sprintf("Too many questions (%d, max %d)", len(questions), maxJobQuestions), http.StatusBadRequest)
+			return
+		}
+
+		proj := s.projectForQuery(w, r, projectID)
+		if proj == nil {
+			return
+		}
+		provider, model := r.FormValue("provider"), r.FormValue("model")
+		llmClient, err := s.getProvider(s.projectSettings(r, projectID), provider, model)
+		if err != nil {
+			jsonErr(w, fmt.Sprintf("Provider error: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		rec := &batchRecord{
+			ID:         newID(),
+			ProjectID:  projectID,
+			Provider:   provider,
+			Model:      model,
+			Source:     "job",
+			SourceFile: filepath.Base(header.Filename),
+			Status:     "queued",
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		for i, q := range questions {
+			rec.Results = append(rec.Results, BatchResult{Index: i, Question: q, Status: "pending"})
+		}
+		job := &batchJob{
+			rec:        rec,
+			store:      s.getProjectStore(r),
+			client:     llmClient,
+			sysPrompt:  proj.SystemPrompt,
+			basePrompt: proj.BasePrompt,
+			redact:     proj.RedactAnswers,
+
+			docTypePrompts: s.projectSettings(r, projectID).DocTypePrompts,
+		}
+		if mail, ok := mailConfigFor(s.projectSettings(r, projectID)); ok {
+			job.mail = &mail
+		}
+		if err := saveBatchRecord(job.store, rec); err != nil {
+			jsonErr(w, "Failed to save job: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		summary := summarizeBatch(rec) // before the worker can touch rec
+		if err := s.batchJobs.submit(job); err != nil {
+			rec.Status = "failed"
+			rec.Error = err.Error()
+			s.saveJobRecord(job)
+			jsonErr(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(summary)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleBatchJobStatus reports a job's progress (GET ?project_id=&job_id=).
+func (s *Server) handleBatchJobStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rec, err := s.jobRecord(s.getProjectStore(r), r.URL.Query().Get("project_id"), r.URL.Query().Get("job_id"))
+	if err != nil {
+		jsonErr(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	jsonResp(w, summarizeBatch(rec))
+}
+
+// handleBatchCancel stops a running batch job or synchronous batch, or a
+// queued job before it starts (POST {project_id, batch_id}). Questions
+// answered so far are kept; the rest stay pending and can be resumed
+// through POST /api/batch with resume_batch_id.
+func (s *Server) handleBatchCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ProjectID string `json:"project_id"`
+		BatchID   string `json:"batch_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" || req.BatchID == "" {
+		jsonErr(w, "project_id and batch_id are required", http.StatusBadRequest)
+		return
+	}
+	if !s.batchJobs.cancel(s.getProjectStore(r), req.ProjectID, req.BatchID) {
+		jsonErr(w, "No queued or running batch with that ID", http.StatusNotFound)
+		return
+	}
+	jsonResp(w, map[string]interface{}{"cancelled": true, "batch_id": req.BatchID})
+}
+
+// handleBatchJobResults downloads a job's results, one row per question with
+// its answer, citations, confidence and timing (GET ?project_id=&job_id=
+// &format=csv|json|xlsx, default csv). Questions still pending appear with
+// status "pending", so partial results can be fetched while a job runs.
+func (s *Server) handleBatchJobResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rec, err := s.jobRecord(s.getProjectStore(r), r.URL.Query().Get("project_id"), r.URL.Query().Get("job_id"))
+	if err != nil {
+		jsonErr(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	base := "batch_" + rec.ID
+	if rec.SourceFile != "" {
+		base = strings.TrimSuffix(rec.SourceFile, filepath.Ext(rec.SourceFile)) + "_results"
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, base))
+		jsonResp(w, map[string]interface{}{
+			"job":     summarizeBatch(rec),
+			"results": rec.Results,
+		})
+		return
+	}
+
+	if r.URL.Query().Get("format") == "xlsx" {
+		var buf bytes.Buffer
+		if err := writeBatchXLSX(&buf, rec); err != nil {
+			jsonErr(w, "Failed to build the spreadsheet: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, base))
+		_, _ = w.Write(buf.Bytes())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, base))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"index", "question", "status", "answer", "citations", "documents", "pages",
+		"confidence", "confidence_reason", "time_seconds", "error"})
+	for _, res := range rec.Results {
+		row := []string{strconv.Itoa(res.Index + 1), res.Question, res.Status, "", "", "", "", "", "",
+			strconv.FormatFloat(res.TimeSeconds, 'f', 2, 64), res.Error}
+		if a := res.Answer; a != nil {
+			var cites, pages []string
+			for _, fn := range a.Footnotes {
+				cites = append(cites, fmt.Sprintf("[%d] %s p.%d", fn.ID, fn.Document, fn.Page))
+			}
+			for _, p := range a.Pages {
+				pages = append(pages, strconv.Itoa(p))
+			}
+			row[3] = a.Answer
+			row[4] = strings.Join(cites, "; ")
+			row[5] = strings.Join(a.Documents, "; ")
+			row[6] = strings.Join(pages, "; ")
+			row[7] = strconv.FormatFloat(a.Confidence, 'f', 2, 64)
+			row[8] = a.ConfidenceReason
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+}
+
+// writeBatchXLSX writes a batch's results as a workbook for pasting into
+// trackers: a Results sheet with a row per question and its citations
+// listed by document, and a Citations sheet with a row per footnote.
+// Structured answers (see schema) add a Data column.
+func writeBatchXLSX(w io.Writer, rec *batchRecord) error {
+	hasData := false
+	for _, res := range rec.Results {
+		hasData = hasData || (res.Answer != nil && len(res.Answer.Data) > 0)
+	}
+	results := xlsx.Sheet{
+		Name: "Results",
+		Columns: []xlsx.Column{
+			{Header: "#", Width: 5}, {Header: "Question", Width: 45}, {Header: "Status", Width: 9},
+			{Header: "Answer", Width: 80}, {Header: "Confidence", Width: 11}, {Header: "Confidence reason", Width: 40},
+			{Header: "Citations", Width: 35}, {Header: "Time (s)", Width: 9}, {Header: "Error", Width: 30},
+		},
+	}
+	if hasData {
+		results.Columns = append(results.Columns, xlsx.Column{Header: "Data", Width: 50})
+	}
+	cites := xlsx.Sheet{
+		Name:    "Citations",
+		Columns: []xlsx.Column{{Header: "#", Width: 5}, {Header: "Question", Width: 45}, {Header: "Source", Width: 8}, {Header: "Document", Width: 40}, {Header: "Page", Width: 7}},
+	}
+
+	for _, res := range rec.Results {
+		row := []xlsx.Cell{xlsx.Int(res.Index + 1), xlsx.Text(res.Question), xlsx.Text(res.Status),
+			xlsx.Text(""), xlsx.Text(""), xlsx.Text(""), xlsx.Text(""), xlsx.Number(res.TimeSeconds), xlsx.Text(res.Error)}
+		if a := res.Answer; a != nil {
+			row[3], row[4], row[5] = xlsx.Text(a.Answer), xlsx.Number(a.Confidence), xlsx.Text(a.ConfidenceReason)
+			row[6] = xlsx.Text(citationsByDocument(a))
+			if hasData {
+				row = append(row, xlsx.Text(string(a.Data)))
+			}
+			for _, fn := range a.Footnotes {
+				cites.Rows = append(cites.Rows, []xlsx.Cell{xlsx.Int(res.Index + 1), xlsx.Text(res.Question), xlsx.Int(fn.ID), xlsx.Text(fn.Document), xlsx.Int(fn.Page)})
+			}
+		}
+		results.Rows = append(results.Rows, row)
+	}
+	return xlsx.Write(w, results, cites)
+}
+
+// citationsByDocument lists the pages an answer cites, a line per document
+// in the order first cited: "lease.pdf: p. 3, 7". An answer without
+// footnotes lists its documents.
+func citationsByDocument(a *llm.Answer) string {
+	if len(a.Footnotes) == 0 {
+		return strings.Join(a.Documents, "\n")
+	}
+	var docs []string
+	pages := map[string][]int{}
+	for _, fn := range a.Footnotes {
+		ps, ok := pages[fn.Document]
+		if !ok {
+			docs = append(docs, fn.Document)
+		}
+		if fn.Page > 0 && !slices.Contains(ps, fn.Page) {
+			ps = append(ps, fn.Page)
+		}
+		pages[fn.Document] = ps
+	}
+	lines := make([]string, len(docs))
+	for i, doc := range docs {
+		ps := pages[doc]
+		sort.Ints(ps)
+		if len(ps) == 0 {
+			lines[i] = doc
+			continue
+		}
+		nums := make([]string, len(ps))
+		for j, p := range ps {
+			nums[j] = strconv.Itoa(p)
+		}
+		prefix := "p. "
+		if len(ps) > 1 {
+			prefix = "pp. "
+		}
+		lines[i] = doc + ": " + prefix + strings.Join(nums, ", ")
+	}
+	return strings.Join(lines, "\n")
+}
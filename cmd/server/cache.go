@@ -0,0 +1,247 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Index Cache ==========
+
+const (
+	defaultCacheMaxBytes   = 2 << 30 // 2 GiB
+	defaultCacheMaxEntries = 20
+)
+
+// indexCacheLimits returns the index cache's budget: INDEX_CACHE_MAX_BYTES,
+// the estimated memory all cached indexes may hold together, and
+// INDEX_CACHE_MAX_ENTRIES, how many are kept however small they are (each
+// holds its keyword index open). Unset or invalid values use the defaults.
+func indexCacheLimits() (maxBytes int64, maxEntries int) {
+	maxBytes, _ = strconv.ParseInt(os.Getenv("INDEX_CACHE_MAX_BYTES"), 10, 64)
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheMaxBytes
+	}
+	maxEntries, _ = strconv.Atoi(os.Getenv("INDEX_CACHE_MAX_ENTRIES"))
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	return maxBytes, maxEntries
+}
+
+type cachedIndex struct {
+	idx *indexer.Index
+	ret *retriever.Retriever
+}
+
+// lruCache is a thread-safe LRU cache for loaded indexes, bounded by the
+// indexes' estimated memory and by their number.
+type lruCache struct {
+	mu         sync.Mutex
+	maxBytes   int64
+	maxEntries int
+	bytes      int64 // estimated total of the cached indexes
+	items      map[string]*list.Element
+	order      *list.List // front = most recently used
+}
+
+type lruEntry struct {
+	key      string
+	value    *cachedIndex
+	size     int64 // idx.MemoryEstimate() when cached
+	chunks   int
+	lastUsed time.Time
+}
+
+func newLRUCache(maxBytes int64, maxEntries int) *lruCache {
+	return &lruCache{
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get returns the cached index and true if found, promoting it to front.
+func (c *lruCache) get(key string) (*cachedIndex, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		e := el.Value.(*lruEntry)
+		e.lastUsed = time.Now()
+		return e.value, true
+	}
+	return nil, false
+}
+
+// put adds or updates an entry, then evicts least-recently-used entries
+// until the cache is back within budget. The entry just put is never
+// evicted, so an index larger than the whole budget is still cached, alone.
+func (c *lruCache) put(key string, value *cachedIndex) {
+	var size int64
+	var chunks int
+	if value != nil && value.idx != nil {
+		size = value.idx.MemoryEstimate()
+		value.idx.Lock()
+		chunks = len(value.idx.Chunks)
+		value.idx.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		c.order.MoveToFront(el)
+		e := el.Value.(*lruEntry)
+		c.bytes += size - e.size
+		e.value, e.size, e.chunks, e.lastUsed = value, size, chunks, time.Now()
+	} else {
+		el = c.order.PushFront(&lruEntry{key: key, value: value, size: size, chunks: chunks, lastUsed: time.Now()})
+		c.items[key] = el
+		c.bytes += size
+	}
+
+	for c.order.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		oldest := c.order.Back()
+		if oldest == el {
+			log.Printf("LRU cache: index for project %s (~%d MB) exceeds the %d MB budget on its own",
+				key, size>>20, c.maxBytes>>20)
+			break
+		}
+		e := oldest.Value.(*lruEntry)
+		c.remove(oldest)
+		log.Printf("LRU cache: evicted index for project %s (~%d MB)", e.key, e.size>>20)
+	}
+}
+
+// remove drops an element; the caller holds c.mu.
+func (c *lruCache) remove(el *list.Element) {
+	e := el.Value.(*lruEntry)
+	c.order.Remove(el)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+// delete removes an entry from the cache.
+func (c *lruCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// has returns true if the key is in the cache (without promoting).
+func (c *lruCache) has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+// cacheEntryInfo describes one cached index for GET /api/index/cache.
+type cacheEntryInfo struct {
+	ProjectID      string    `json:"project_id"`
+	EstimatedBytes int64     `json:"estimated_bytes"`
+	Chunks         int       `json:"chunks"`
+	LastUsed       time.Time `json:"last_used"`
+	Active         bool      `json:"active"` // the active project's index stays loaded after eviction
+}
+
+// entries lists the cached indexes, most recently used first.
+func (c *lruCache) entries() []cacheEntryInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := []cacheEntryInfo{}
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*lruEntry)
+		out = append(out, cacheEntryInfo{ProjectID: e.key, EstimatedBytes: e.size, Chunks: e.chunks, LastUsed: e.lastUsed})
+	}
+	return out
+}
+
+// usage returns the cache's estimated total and its limits.
+func (c *lruCache) usage() (bytes, maxBytes int64, maxEntries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes, c.maxBytes, c.maxEntries
+}
+
+// handleIndexCache lists the cached indexes and the cache's budget (admin only).
+func (s *Server) handleIndexCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	s.mu.RLock()
+	active := s.activeProjectID
+	s.mu.RUnlock()
+	entries := s.indexCache.entries()
+	for i := range entries {
+		entries[i].Active = entries[i].ProjectID == active
+	}
+	used, maxBytes, maxEntries := s.indexCache.usage()
+	jsonResp(w, map[string]interface{}{
+		"used_bytes":  used,
+		"max_bytes":   maxBytes,
+		"max_entries": maxEntries,
+		"entries":     entries,
+	})
+}
+
+// handleEvictIndexCache drops one project's index, or all of them, from the
+// cache (admin only). Evicted indexes are reloaded from disk when next
+// queried.
+func (s *Server) handleEvictIndexCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req struct {
+		ProjectID string `json:"project_id"`
+		All       bool   `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.All == (req.ProjectID != "") {
+		jsonErr(w, "Specify either project_id or all", http.StatusBadRequest)
+		return
+	}
+
+	var evicted []string
+	if req.All {
+		for _, e := range s.indexCache.entries() {
+			evicted = append(evicted, e.ProjectID)
+		}
+	} else if s.indexCache.has(req.ProjectID) {
+		evicted = append(evicted, req.ProjectID)
+	}
+	for _, id := range evicted {
+		s.indexCache.delete(id)
+	}
+	target := req.ProjectID
+	if req.All {
+		target = "all"
+	}
+	recordAudit(r, "index.cache_evict", req.ProjectID, target, map[string]string{"evicted": strconv.Itoa(len(evicted))})
+
+	used, _, _ := s.indexCache.usage()
+	jsonResp(w, map[string]interface{}{
+		"evicted":    len(evicted),
+		"used_bytes": used,
+	})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gocognigo/internal/s3"
+)
+
+// ========== Direct Uploads ==========
+
+// With an upload bucket configured, clients can send large files straight
+// to S3 (or MinIO) instead of through the server: /api/upload/presign
+// issues a pre-signed PUT URL per file, and /api/upload/complete then moves
+// the uploaded objects into the project's uploads — scanned and versioned
+// as /api/upload's are — and deletes them from the bucket. The files are
+// ingested with /api/ingest as usual. Uploads not completed within
+// presignTTL are forgotten, so the bucket wants a lifecycle rule expiring
+// objects under uploads/ after a day or so.
+
+// uploadBucketEnv names the bucket direct uploads go to; the connection
+// comes from the S3_* variables read by s3.FromEnv.
+const uploadBucketEnv = "S3_UPLOAD_BUCKET"
+
+// presignTTL is how long a pre-signed upload URL, and the upload it was
+// issued for, stay valid.
+const presignTTL = time.Hour
+
+// maxDirectUploadBytes is the largest file a single PUT can upload to S3.
+const maxDirectUploadBytes = 5 << 30
+
+// pendingUpload is a file a URL was issued for, not yet completed.
+type pendingUpload struct {
+	ID        string
+	Owner     string // storeUID of the uploader
+	ProjectID string
+	Name      string
+	Key       string // the object's key in the bucket
+	Size      int64  // the size promised when presigning
+	Expires   time.Time
+}
+
+// directUploads issues and tracks the pre-signed uploads to one bucket.
+type directUploads struct {
+	bucket *s3.Client
+
+	mu      sync.Mutex
+	pending map[string]*pendingUpload // by upload ID
+}
+
+// newDirectUploads returns the direct uploads configured in the
+// environment, or nil when they are off.
+func newDirectUploads() *directUploads {
+	bucket := s3.FromEnv(strings.TrimSpace(os.Getenv(uploadBucketEnv)))
+	if bucket == nil {
+		return nil
+	}
+	return &directUploads{bucket: bucket, pending: make(map[string]*pendingUpload)}
+}
+
+// add records an upload, forgetting those that expired.
+func (d *directUploads) add(p *pendingUpload) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for id, old := range d.pending {
+		if now.After(old.Expires) {
+			delete(d.pending, id)
+			go d.discard(old)
+		}
+	}
+	d.pending[p.ID] = p
+}
+
+// take removes and returns the owner's unexpired upload with id to the
+// project, or nil.
+func (d *directUploads) take(id, owner, projectID string) *pendingUpload {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.pending[id]
+	if !ok || p.Owner != owner || p.ProjectID != projectID || time.Now().After(p.Expires) {
+		return nil
+	}
+	delete(d.pending, id)
+	return p
+}
+
+// restore puts back an upload that couldn't be completed yet.
+func (d *directUploads) restore(p *pendingUpload) {
+	d.mu.Lock()
+	d.pending[p.ID] = p
+	d.mu.Unlock()
+}
+
+// discard deletes an upload's object from the bucket.
+func (d *directUploads) discard(p *pendingUpload) {
+	if err := d.bucket.Delete(p.Key); err != nil {
+		log.Printf("[Upload] Failed to delete %s from the upload bucket: %v", p.Key, err)
+	}
+}
+
+// handlePresignUpload issues pre-signed URLs to upload files straight to
+// the bucket (POST {project_id, files: [{name, size}]}). Each file is then
+// PUT to its URL, with its exact size, and the upload IDs returned are
+// passed to /api/upload/complete.
+func (s *Server) handlePresignUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.directUploads == nil {
+		jsonErr(w, "Direct uploads are not configured ("+uploadBucketEnv+"); use /api/upload", http.StatusNotImplemented)
+		return
+	}
+
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Files     []struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Files) == 0 {
+		jsonErr(w, "No files to upload", http.StatusBadRequest)
+		return
+	}
+
+	store := s.getProjectStore(r)
+	proj, err := store.Get(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	headers := make([]*multipart.FileHeader, 0, len(req.Files))
+	for _, f := range req.Files {
+		name := strings.TrimSpace(f.Name)
+		if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			jsonErr(w, fmt.Sprintf("Invalid file name %q", f.Name), http.StatusBadRequest)
+			return
+		}
+		if ext := strings.ToLower(filepath.Ext(name)); ext != ".pdf" && ext != ".docx" {
+			jsonErr(w, name+": only PDF and DOCX files can be uploaded", http.StatusBadRequest)
+			return
+		}
+		if f.Size <= 0 || f.Size > maxDirectUploadBytes {
+			jsonErr(w, fmt.Sprintf("%s: size must be between 1 and %d bytes", name, int64(maxDirectUploadBytes)), http.StatusBadRequest)
+			return
+		}
+		headers = append(headers, &multipart.FileHeader{Filename: name, Size: f.Size})
+	}
+
+	uploadsDir := store.UploadsDir(req.ProjectID)
+	_ = os.MkdirAll(uploadsDir, 0755)
+	if err := s.checkUploadQuota(proj, uploadsDir, headers); err != nil {
+		jsonErr(w, "Quota exceeded: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	expires := time.Now().Add(presignTTL)
+	uploads := []map[string]interface{}{}
+	for _, fh := range headers {
+		p := &pendingUpload{
+			ID:        newID(),
+			Owner:     storeUID(r),
+			ProjectID: req.ProjectID,
+			Name:      fh.Filename,
+			Size:      fh.Size,
+			Expires:   expires,
+		}
+		p.Key = fmt.Sprintf("uploads/%s/%s/%s", p.ProjectID, p.ID, p.Name)
+		url, err := s.directUploads.bucket.PresignPut(p.Key, presignTTL)
+		if err != nil {
+			jsonErr(w, "Failed to sign the upload: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.directUploads.add(p)
+		uploads = append(uploads, map[string]interface{}{
+			"upload_id":  p.ID,
+			"file":       p.Name,
+			"url":        url,
+			"method":     http.MethodPut,
+			"expires_at": expires,
+		})
+	}
+	jsonResp(w, map[string]interface{}{"uploads": uploads})
+}
+
+// handleCompleteUpload registers files uploaded to pre-signed URLs with the
+// project (POST {project_id, upload_ids}), answering as /api/upload does.
+// Uploads not found in the bucket yet are reported under "pending" and can
+// be completed again until their URLs expire.
+func (s *Server) handleCompleteUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.directUploads == nil {
+		jsonErr(w, "Direct uploads are not configured ("+uploadBucketEnv+"); use /api/upload", http.StatusNotImplemented)
+		return
+	}
+
+	var req struct {
+		ProjectID string   `json:"project_id"`
+		UploadIDs []string `json:"upload_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.UploadIDs) == 0 {
+		jsonErr(w, "upload_ids is required", http.StatusBadRequest)
+		return
+	}
+
+	store := s.getProjectStore(r)
+	proj, err := store.Get(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	var rejected []map[string]string
+	var uploads []*pendingUpload
+	for _, id := range req.UploadIDs {
+		if p := s.directUploads.take(id, storeUID(r), req.ProjectID); p != nil {
+			uploads = append(uploads, p)
+		} else {
+			rejected = append(rejected, map[string]string{"upload_id": id, "error": "unknown or expired upload"})
+		}
+	}
+
+	// The quota was checked when presigning, but other uploads may have
+	// landed since.
+	uploadsDir := store.UploadsDir(req.ProjectID)
+	_ = os.MkdirAll(uploadsDir, 0755)
+	headers := make([]*multipart.FileHeader, len(uploads))
+	for i, p := range uploads {
+		headers[i] = &multipart.FileHeader{Filename: p.Name, Size: p.Size}
+	}
+	if err := s.checkUploadQuota(proj, uploadsDir, headers); err != nil {
+		for _, p := range uploads {
+			s.directUploads.restore(p)
+		}
+		jsonErr(w, "Quota exceeded: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var saved, pending []string
+	var replaced []map[string]interface{}
+	for _, p := range uploads {
+		body, size, err := s.directUploads.bucket.Get(p.Key)
+		if err != nil {
+			if !errors.Is(err, s3.ErrNotFound) {
+				log.Printf("[Upload] Failed to read %s from the upload bucket: %v", p.Key, err)
+			}
+			s.directUploads.restore(p)
+			pending = append(pending, p.ID)
+			continue
+		}
+		if size != p.Size {
+			body.Close()
+			s.directUploads.discard(p)
+			rejected = append(rejected, map[string]string{"upload_id": p.ID, "file": p.Name,
+				"error": fmt.Sprintf("uploaded %d bytes, not the %d it was presigned for", size, p.Size)})
+			continue
+		}
+
+		version, reason, err := s.saveUpload(r, store, req.ProjectID, p.Name, body)
+		body.Close()
+		if err != nil {
+			log.Printf("[Upload] Failed to save %s: %v", p.Name, err)
+			reason = "could not be saved"
+		}
+		s.directUploads.discard(p)
+		if reason != "" {
+			rejected = append(rejected, map[string]string{"upload_id": p.ID, "file": p.Name, "error": reason})
+			continue
+		}
+		if version > 0 {
+			replaced = append(replaced, map[string]interface{}{"file": p.Name, "version": version})
+		}
+		saved = append(saved, p.Name)
+	}
+
+	proj.FileCount, _ = uploadsUsage(uploadsDir)
+	_ = store.Update(*proj)
+
+	jsonResp(w, map[string]interface{}{
+		"uploaded": saved,
+		"count":    len(saved),
+		"rejected": rejected,
+		"replaced": replaced,
+		"pending":  pending,
+	})
+}
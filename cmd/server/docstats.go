@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/extractor"
+	"gocognigo/internal/indexer"
+)
+
+// ========== Document Statistics ==========
+
+// Ingestion records what it made of each file in the project's
+// ingest_records.json: the pages extracted (and how many by OCR), the chunks,
+// and when. /api/stats?project_id= joins these records with the uploads and
+// the loaded index into a per-document breakdown, so that a document that
+// contributed nothing to the index (a scan with no OCR configured, a file over
+// the chunk quota, a failed embedding) shows up instead of silently never
+// being cited.
+
+// ingestRecord is what the latest ingestion of a document produced.
+type ingestRecord struct {
+	Pages      int       `json:"pages"` // pages with text, OCR'd ones included
+	OCRPages   int       `json:"ocr_pages"`
+	Chunks     int       `json:"chunks"`
+	Error      string    `json:"error,omitempty"`
+	IngestedAt time.Time `json:"ingested_at"`
+}
+
+// ingestRecordsMu serializes read-modify-write of ingest_records.json files.
+var ingestRecordsMu sync.Mutex
+
+func ingestRecordsPath(store *chat.ProjectStore, projectID string) string {
+	return filepath.Join(store.ProjectDir(projectID), "ingest_records.json")
+}
+
+// loadIngestRecords returns a project's ingest records by document name.
+func loadIngestRecords(store *chat.ProjectStore, projectID string) map[string]ingestRecord {
+	records := map[string]ingestRecord{}
+	data, err := os.ReadFile(ingestRecordsPath(store, projectID))
+	if err != nil {
+		return records
+	}
+	_ = json.Unmarshal(data, &records)
+	return records
+}
+
+// updateIngestRecord applies update to a document's record and saves it;
+// update returning false deletes the record instead.
+func updateIngestRecord(store *chat.ProjectStore, projectID, name string, update func(*ingestRecord) bool) {
+	ingestRecordsMu.Lock()
+	defer ingestRecordsMu.Unlock()
+	records := loadIngestRecords(store, projectID)
+	rec := records[name]
+	if update(&rec) {
+		records[name] = rec
+	} else {
+		delete(records, name)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = os.WriteFile(ingestRecordsPath(store, projectID), data, 0644)
+}
+
+// recordIngest notes a document's ingestion: its extracted pages (nil if
+// extraction failed), the chunks made of them, and the error that kept it
+// out of the index, if any.
+func recordIngest(store *chat.ProjectStore, projectID, name string, pages []extractor.DocumentChunk, chunks int, errMsg string) {
+	updateIngestRecord(store, projectID, name, func(rec *ingestRecord) bool {
+		*rec = ingestRecord{Pages: len(pages), Chunks: chunks, Error: errMsg, IngestedAt: time.Now()}
+		for _, p := range pages {
+			if p.OCR {
+				rec.OCRPages++
+			}
+		}
+		return true
+	})
+}
+
+// recordIngestError sets the error met after chunking, such as a failed
+// embedding, on a document's record; "" clears it once a retry succeeds.
+func recordIngestError(store *chat.ProjectStore, projectID, name, errMsg string) {
+	updateIngestRecord(store, projectID, name, func(rec *ingestRecord) bool {
+		rec.Error = errMsg
+		return true
+	})
+}
+
+// removeIngestRecord forgets a deleted or replaced document's record.
+func removeIngestRecord(store *chat.ProjectStore, projectID, name string) {
+	updateIngestRecord(store, projectID, name, func(*ingestRecord) bool { return false })
+}
+
+// DocumentStats is one document's part in a project's index. Embedded and
+// Summary are nil when the project's index isn't loaded; Chunks then comes
+// from the ingest record.
+type DocumentStats struct {
+	Document     string     `json:"document"`
+	Pages        int        `json:"pages"`     // pages extracted at the last ingestion
+	OCRPages     int        `json:"ocr_pages"` // of those, read by OCR
+	Chunks       int        `json:"chunks"`    // page chunks in the index
+	Embedded     *int       `json:"embedded,omitempty"`
+	SummaryNodes int        `json:"summary_nodes,omitempty"` // section and document nodes, see hierarchical_summaries
+	Summary      *bool      `json:"summary,omitempty"`
+	IngestedAt   *time.Time `json:"ingested_at,omitempty"`
+	// Problem says why the document adds nothing (or less than it should)
+	// to answers; "" if it is fully indexed.
+	Problem string `json:"problem,omitempty"`
+}
+
+// documentStats breaks a project's index down by document: every uploaded
+// file, and any document the index still holds without its upload. chunks
+// and summaries are the loaded index's, or nil with loaded false.
+func documentStats(uploads []string, records map[string]ingestRecord, chunks []indexer.Chunk, summaries []indexer.DocumentSummary, loaded bool) []DocumentStats {
+	byDoc := map[string]*DocumentStats{}
+	get := func(name string) *DocumentStats {
+		if ds, ok := byDoc[name]; ok {
+			return ds
+		}
+		ds := &DocumentStats{Document: name}
+		if loaded {
+			ds.Embedded, ds.Summary = new(int), new(bool)
+		}
+		byDoc[name] = ds
+		return ds
+	}
+	for _, name := range uploads {
+		get(name)
+	}
+	for _, c := range chunks {
+		ds := get(c.Document)
+		if c.Level != "" {
+			ds.SummaryNodes++
+			continue
+		}
+		ds.Chunks++
+		if len(c.Embedding) > 0 {
+			*ds.Embedded++
+		}
+	}
+	for _, sum := range summaries {
+		*get(sum.Document).Summary = true
+	}
+
+	out := make([]DocumentStats, 0, len(byDoc))
+	for name, ds := range byDoc {
+		rec, ingested := records[name]
+		if ingested {
+			ds.Pages, ds.OCRPages = rec.Pages, rec.OCRPages
+			at := rec.IngestedAt
+			ds.IngestedAt = &at
+			if !loaded {
+				ds.Chunks = rec.Chunks
+			}
+		}
+		switch {
+		case ingested && rec.Error != "":
+			ds.Problem = rec.Error
+		case ingested && rec.Pages == 0:
+			ds.Problem = "no text was extracted"
+		case ds.Chunks == 0 && !ingested:
+			ds.Problem = "not ingested yet"
+		case ds.Chunks == 0:
+			ds.Problem = "no chunks in the index"
+		case ds.Embedded != nil && *ds.Embedded < ds.Chunks:
+			ds.Problem = fmt.Sprintf("%d of %d chunks have no embedding", ds.Chunks-*ds.Embedded, ds.Chunks)
+		}
+		out = append(out, *ds)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Document < out[j].Document })
+	return out
+}
+
+// uploadedDocuments lists a project's uploaded PDF and DOCX files.
+func uploadedDocuments(store *chat.ProjectStore, projectID string) []string {
+	entries, _ := os.ReadDir(store.UploadsDir(projectID))
+	var names []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if !e.IsDir() && (ext == ".pdf" || ext == ".docx") {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocognigo/internal/analysis"
+	"gocognigo/internal/chat"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/llm"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Corpus Analysis Endpoints ==========
+
+// pageRef points at a page of a document.
+type pageRef struct {
+	Document string `json:"document"`
+	Page     int    `json:"page"`
+}
+
+// chunkEntities returns a chunk's entities, extracting them on the fly for
+// chunks indexed before entity extraction existed.
+func chunkEntities(c indexer.Chunk) []analysis.Entity {
+	if c.IsSummary() {
+		return nil // restates its pages' entities
+	}
+	if c.Entities != nil {
+		return c.Entities
+	}
+	return analysis.ExtractEntities(c.Text)
+}
+
+// corpusPassages rebuilds full pages from indexed chunks, sorted by
+// document and page. Each chunk carries its page as ParentText; pages of
+// chunks without one are their chunk texts joined. An empty document means
+// every document.
+func corpusPassages(chunks []indexer.Chunk, document string) []analysis.Passage {
+	type page struct {
+		text      strings.Builder
+		hasParent bool
+	}
+	pages := map[pageRef]*page{}
+	for _, c := range chunks {
+		if (document != "" && c.Document != document) || c.IsSummary() {
+			continue
+		}
+		ref := pageRef{Document: c.Document, Page: c.PageNumber}
+		p, ok := pages[ref]
+		if !ok {
+			p = &page{}
+			pages[ref] = p
+		}
+		switch {
+		case p.hasParent:
+		case c.ParentText != "":
+			p.text.Reset()
+			p.text.WriteString(c.ParentText)
+			p.hasParent = true
+		default:
+			if p.text.Len() > 0 {
+				p.text.WriteString("\n")
+			}
+			p.text.WriteString(c.Text)
+		}
+	}
+	refs := make([]pageRef, 0, len(pages))
+	for ref := range pages {
+		refs = append(refs, ref)
+	}
+	sortPageRefs(refs)
+	passages := make([]analysis.Passage, len(refs))
+	for i, ref := range refs {
+		passages[i] = analysis.Passage{Document: ref.Document, Page: ref.Page, Text: pages[ref].text.String()}
+	}
+	return passages
+}
+
+// entityListing aggregates one entity's mentions across a project.
+type entityListing struct {
+	Key          string    `json:"key"`
+	Text         string    `json:"text"`
+	Type         string    `json:"type"`
+	Pages        int       `json:"pages"` // distinct pages mentioning it
+	Documents    []string  `json:"documents"`
+	Mentions     []pageRef `json:"mentions"`
+	MoreMentions int       `json:"more_mentions,omitempty"` // mentions left out of the list
+}
+
+// handleEntities lists the people, organisations, amounts and dates found
+// across a project (GET ?project_id=, optional type=, q= substring filter,
+// limit=). Each entry lists the pages it appears on; pass key= to get one
+// entity with every mention.
+func (s *Server) handleEntities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	if _, err := s.getProjectStore(r).Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	rw, err := s.getRetrieverForProject(projectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+
+	typeFilter := q.Get("type")
+	textFilter := strings.ToLower(strings.TrimSpace(q.Get("q")))
+	keyFilter := q.Get("key")
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	const mentionsPerEntity = 20
+
+	byKey := map[string]*entityListing{}
+	seenPage := map[string]bool{} // key + page
+	seenDoc := map[string]bool{}  // key + document
+	for _, c := range rw.ret.Chunks {
+		for _, e := range chunkEntities(c) {
+			if typeFilter != "" && e.Type != typeFilter {
+				continue
+			}
+			key := e.Key()
+			if keyFilter != "" && key != keyFilter {
+				continue
+			}
+			if textFilter != "" && !strings.Contains(strings.ToLower(e.Text), textFilter) {
+				continue
+			}
+			el, ok := byKey[key]
+			if !ok {
+				el = &entityListing{Key: key, Text: e.Text, Type: e.Type}
+				byKey[key] = el
+			}
+			pageKey := key + "\x00" + c.Document + "\x00" + strconv.Itoa(c.PageNumber)
+			if seenPage[pageKey] {
+				continue
+			}
+			seenPage[pageKey] = true
+			el.Mentions = append(el.Mentions, pageRef{Document: c.Document, Page: c.PageNumber})
+			if docKey := key + "\x00" + c.Document; !seenDoc[docKey] {
+				seenDoc[docKey] = true
+				el.Documents = append(el.Documents, c.Document)
+			}
+		}
+	}
+
+	entities := make([]*entityListing, 0, len(byKey))
+	for _, el := range byKey {
+		el.Pages = len(el.Mentions)
+		sortPageRefs(el.Mentions)
+		if keyFilter == "" && len(el.Mentions) > mentionsPerEntity {
+			el.MoreMentions = len(el.Mentions) - mentionsPerEntity
+			el.Mentions = el.Mentions[:mentionsPerEntity]
+		}
+		sort.Strings(el.Documents)
+		entities = append(entities, el)
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		if entities[i].Pages != entities[j].Pages {
+			return entities[i].Pages > entities[j].Pages
+		}
+		return entities[i].Key < entities[j].Key
+	})
+
+	if keyFilter != "" {
+		if len(entities) == 0 {
+			jsonErr(w, "Entity not found", http.StatusNotFound)
+			return
+		}
+		jsonResp(w, entities[0])
+		return
+	}
+
+	total := len(entities)
+	if len(entities) > limit {
+		entities = entities[:limit]
+	}
+	jsonResp(w, map[string]interface{}{
+		"entities": entities,
+		"total":    total,
+	})
+}
+
+func sortPageRefs(refs []pageRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Document != refs[j].Document {
+			return refs[i].Document < refs[j].Document
+		}
+		return refs[i].Page < refs[j].Page
+	})
+}
+
+// handleTimeline assembles a chronological timeline of dated events across
+// a project's documents, each with citations (GET ?project_id=, optional
+// document=, from= and to= as YYYY-MM-DD, q= substring filter, limit=).
+func (s *Server) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	if _, err := s.getProjectStore(r).Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	rw, err := s.getRetrieverForProject(projectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+
+	from, to := q.Get("from"), q.Get("to")
+	for _, d := range []string{from, to} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			jsonErr(w, "from and to must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	textFilter := strings.ToLower(strings.TrimSpace(q.Get("q")))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > 5000 {
+		limit = 500
+	}
+
+	var events []analysis.TimelineEntry
+	for _, e := range analysis.BuildTimeline(corpusPassages(rw.ret.Chunks, q.Get("document"))) {
+		// Month-precision dates compare as their first day
+		day := e.Date
+		if e.Precision == analysis.PrecisionMonth {
+			day += "-01"
+		}
+		if (from != "" && day < from) || (to != "" && day > to) {
+			continue
+		}
+		if textFilter != "" && !strings.Contains(strings.ToLower(e.Event), textFilter) {
+			continue
+		}
+		events = append(events, e)
+	}
+	if events == nil {
+		events = []analysis.TimelineEntry{}
+	}
+
+	total := len(events)
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	jsonResp(w, map[string]interface{}{
+		"events": events,
+		"total":  total,
+	})
+}
+
+// ========== Entity Graph ==========
+
+// saveProjectGraph builds a project's entity graph from its chunks and
+// persists it next to the index.
+func saveProjectGraph(store *chat.ProjectStore, projectID string, chunks []indexer.Chunk) *analysis.Graph {
+	return saveGraph(store, projectID, analysis.BuildGraph(corpusPassages(chunks, "")))
+}
+
+func saveGraph(store *chat.ProjectStore, projectID string, g *analysis.Graph) *analysis.Graph {
+	if err := analysis.SaveGraph(store.GraphPath(projectID), g); err != nil {
+		log.Printf("Warning: failed to save entity graph for %s: %v", projectID, err)
+	}
+	return g
+}
+
+// handleGraph answers "how is X connected to Y?" from the entity graph
+// built at ingest (GET ?project_id=). Pass entity= for the neighbourhood of
+// one entity (depth= hops, default 1, max 3), or from= and to= for the
+// shortest chain of relations between two. Without either it returns the
+// most-mentioned nodes (limit=). Entities match by key or name.
+func (s *Server) handleGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	store := s.getProjectStore(r)
+	if _, err := store.Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	g, err := analysis.LoadGraph(store.GraphPath(projectID))
+	if err != nil {
+		// Projects ingested before the graph existed build it on first use
+		rw, err := s.getRetrieverForProject(projectID)
+		if err != nil {
+			jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+			return
+		}
+		g = saveProjectGraph(store, projectID, rw.ret.Chunks)
+	}
+
+	resolve := func(param string) (analysis.Node, bool) {
+		n, ok := g.FindNode(q.Get(param))
+		if !ok {
+			jsonErr(w, "Entity not found in graph: "+q.Get(param), http.StatusNotFound)
+		}
+		return n, ok
+	}
+
+	switch {
+	case q.Get("from") != "" || q.Get("to") != "":
+		if q.Get("from") == "" || q.Get("to") == "" {
+			jsonErr(w, "from and to are both required", http.StatusBadRequest)
+			return
+		}
+		from, ok := resolve("from")
+		if !ok {
+			return
+		}
+		to, ok := resolve("to")
+		if !ok {
+			return
+		}
+		path := g.Path(from.ID, to.ID)
+		jsonResp(w, map[string]interface{}{
+			"from":      from,
+			"to":        to,
+			"connected": path != nil,
+			"path":      path,
+		})
+
+	case q.Get("entity") != "":
+		node, ok := resolve("entity")
+		if !ok {
+			return
+		}
+		depth, _ := strconv.Atoi(q.Get("depth"))
+		if depth <= 0 {
+			depth = 1
+		}
+		if depth > 3 {
+			depth = 3
+		}
+		sub := g.Neighborhood(node.ID, depth)
+		jsonResp(w, map[string]interface{}{
+			"entity": node,
+			"depth":  depth,
+			"nodes":  sub.Nodes,
+			"edges":  sub.Edges,
+		})
+
+	default:
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		if limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+		nodes := g.Nodes
+		if len(nodes) > limit {
+			nodes = nodes[:limit]
+		}
+		jsonResp(w, map[string]interface{}{
+			"nodes":       nodes,
+			"total_nodes": len(g.Nodes),
+			"total_edges": len(g.Edges),
+		})
+	}
+}
+
+// ========== Clause Matrix ==========
+
+// handleClauses returns a clause matrix across a project's contracts (GET
+// ?project_id=): for each document, where each standard clause type
+// (indemnity, termination, governing law, change of control, ...) appears,
+// with an excerpt and citations. Optional document= limits it to one
+// document and type= to one clause type.
+func (s *Server) handleClauses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	if _, err := s.getProjectStore(r).Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	rw, err := s.getRetrieverForProject(projectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+
+	types := analysis.ClauseTypes
+	if t := q.Get("type"); t != "" {
+		types = nil
+		for _, ct := range analysis.ClauseTypes {
+			if ct.ID == t {
+				types = append(types, ct)
+			}
+		}
+		if types == nil {
+			jsonErr(w, "Unknown clause type: "+t, http.StatusBadRequest)
+			return
+		}
+	}
+
+	matrix := analysis.ClauseMatrix(corpusPassages(rw.ret.Chunks, q.Get("document")))
+	coverage := map[string]int{} // clause type -> documents containing it
+	for _, row := range matrix {
+		for id := range row.Clauses {
+			if len(types) == 1 && id != types[0].ID {
+				delete(row.Clauses, id)
+				continue
+			}
+			coverage[id]++
+		}
+	}
+
+	jsonResp(w, map[string]interface{}{
+		"clause_types": types,
+		"documents":    matrix,
+		"coverage":     coverage,
+	})
+}
+
+// ========== Glossary ==========
+
+// maxPromptDefinitions caps the defined terms added to one prompt.
+const maxPromptDefinitions = 8
+
+// withDefinitions adds the documents' definitions of any defined terms the
+// question uses to the custom system prompt, so "the Closing Date" is read
+// the way the documents define it.
+func withDefinitions(ret *retriever.Retriever, question, sysPrompt string) string {
+	used := analysis.TermsUsed(question, ret.Glossary)
+	if len(used) == 0 {
+		return sysPrompt
+	}
+	if len(used) > maxPromptDefinitions {
+		used = used[:maxPromptDefinitions]
+	}
+	var sb strings.Builder
+	if sysPrompt != "" {
+		sb.WriteString(sysPrompt)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("The question uses terms the documents define. Read them with these meanings:\n")
+	for _, d := range used {
+		sb.WriteString(fmt.Sprintf("- \"%s\" (%s, p.%d): %s\n", d.Term, d.Document, d.Page, d.Meaning))
+	}
+	return sb.String()
+}
+
+// glossaryTerm groups every definition of one term.
+type glossaryTerm struct {
+	Term        string                   `json:"term"`
+	Definitions []analysis.GlossaryEntry `json:"definitions"`
+}
+
+// handleGlossary lists the terms defined across a project's documents (GET
+// ?project_id=, optional document= and q= substring filter on the term).
+// A term defined in several documents lists each definition.
+func (s *Server) handleGlossary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	if _, err := s.getProjectStore(r).Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	rw, err := s.getRetrieverForProject(projectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+
+	document := q.Get("document")
+	textFilter := strings.ToLower(strings.TrimSpace(q.Get("q")))
+	byTerm := map[string]*glossaryTerm{}
+	for _, g := range rw.ret.Glossary {
+		if document != "" && g.Document != document {
+			continue
+		}
+		key := strings.ToLower(g.Term)
+		if textFilter != "" && !strings.Contains(key, textFilter) {
+			continue
+		}
+		t, ok := byTerm[key]
+		if !ok {
+			t = &glossaryTerm{Term: g.Term}
+			byTerm[key] = t
+		}
+		t.Definitions = append(t.Definitions, g)
+	}
+
+	terms := make([]*glossaryTerm, 0, len(byTerm))
+	for _, t := range byTerm {
+		terms = append(terms, t)
+	}
+	sort.Slice(terms, func(i, j int) bool { return strings.ToLower(terms[i].Term) < strings.ToLower(terms[j].Term) })
+	jsonResp(w, map[string]interface{}{
+		"terms": terms,
+		"total": len(terms),
+	})
+}
+
+// ========== Numeric Verification ==========
+
+// verifyAnswerNumbers checks the figures in an answer against the pages its
+// footnotes cite, reading each page from the retrieved results or, failing
+// that, the index.
+func verifyAnswerNumbers(answer *llm.Answer, results []retriever.Result, chunks []indexer.Chunk) {
+	if answer == nil || len(analysis.ParseQuantities(answer.Answer)) == 0 {
+		return
+	}
+	pageText := func(doc string, page int) string {
+		for _, r := range results {
+			if r.Document == doc && r.PageNumber == page && r.Level == "" {
+				if r.ParentText != "" {
+					return r.ParentText
+				}
+				return r.Text
+			}
+		}
+		var sb strings.Builder
+		for _, c := range chunks {
+			if c.Document == doc && c.PageNumber == page && !c.IsSummary() {
+				if c.ParentText != "" {
+					return c.ParentText
+				}
+				sb.WriteString(c.Text + "\n")
+			}
+		}
+		return sb.String()
+	}
+
+	var sources []analysis.SourcePage
+	for _, fn := range answer.Footnotes {
+		sources = append(sources, analysis.SourcePage{Marker: fn.ID, Document: fn.Document, Page: fn.Page, Text: pageText(fn.Document, fn.Page)})
+	}
+	answer.NumberChecks = analysis.VerifyNumbers(answer.Answer, sources)
+}
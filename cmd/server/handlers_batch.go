@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/llm"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Batch Queries ==========
+
+// BatchResult is the outcome of one question in a batch.
+type BatchResult struct {
+	Index       int         `json:"index"`
+	Question    string      `json:"question"`
+	Status      string      `json:"status"` // "ok", "error", or "pending" before it has run
+	Error       string      `json:"error,omitempty"`
+	Answer      *llm.Answer `json:"answer,omitempty"`
+	TimeSeconds float64     `json:"time_seconds"`
+}
+
+// batchRecord is the on-disk state of a batch, kept so a batch that partly
+// failed can be resumed without re-asking the questions that succeeded.
+type batchRecord struct {
+	ID         string        `json:"id"`
+	ProjectID  string        `json:"project_id"`
+	Provider   string        `json:"provider,omitempty"`
+	Model      string        `json:"model,omitempty"`
+	Source     string        `json:"source,omitempty"` // "request" (POST /api/batch) or "job"
+	SourceFile string        `json:"source_file,omitempty"`
+	Status     string        `json:"status,omitempty"` // jobs: queued, running, done, failed, cancelled; synchronous: done, cancelled
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Results    []BatchResult `json:"results"`
+}
+
+func batchPath(store *chat.ProjectStore, projectID, batchID string) string {
+	return filepath.Join(store.ProjectDir(projectID), "batches", batchID+".json")
+}
+
+func saveBatchRecord(store *chat.ProjectStore, rec *batchRecord) error {
+	path := batchPath(store, rec.ProjectID, rec.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func loadBatchRecord(store *chat.ProjectStore, projectID, batchID string) (*batchRecord, error) {
+	if filepath.Base(batchID) != batchID || batchID == "" {
+		return nil, fmt.Errorf("invalid batch id")
+	}
+	data, err := os.ReadFile(batchPath(store, projectID, batchID))
+	if err != nil {
+		return nil, err
+	}
+	var rec batchRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// batchRunner answers a set of questions against one project's index.
+type batchRunner struct {
+	rw              *retriever_wrapper
+	client          llm.Provider
+	customSysPrompt string
+	basePrompt      string      // the project's base prompt override, if any
+	schema          *llm.Schema // structured output for every answer, if set
+	redact          bool        // mask personal identifiers in answers
+	docTypePrompts  map[string]string
+}
+
+// run answers the questions at the given indices of results concurrently
+// (the LLM limiter bounds how many are in flight) and calls onResult as each
+// finishes. onResult calls are serialised. Once ctx is cancelled, questions
+// not yet answered are left as they were.
+func (b *batchRunner) run(ctx context.Context, results []BatchResult, indices []int, onResult func(BatchResult)) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for _, i := range indices {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res := b.answer(ctx, results[i].Index, results[i].Question)
+			if res.Status != "ok" && ctx.Err() != nil {
+				return
+			}
+			mu.Lock()
+			results[i] = res
+			if onResult != nil {
+				onResult(res)
+			}
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (b *batchRunner) answer(ctx context.Context, index int, question string) BatchResult {
+	start := time.Now()
+	res := BatchResult{Index: index, Question: question}
+
+	results, err := b.rw.ret.Search(ctx, question, defaultTopK)
+	if err != nil {
+		res.Status = "error"
+		res.Error = fmt.Sprintf("retrieval: %v", err)
+		res.TimeSeconds = time.Since(start).Seconds()
+		return res
+	}
+	sysPrompt := withDocTypePrompt(withDefinitions(b.rw.ret, question, b.customSysPrompt), results, b.rw.ret.DocSummaries, b.docTypePrompts)
+	var answer *llm.Answer
+	if b.schema != nil {
+		answer, err = llm.AnswerStructured(ctx, b.client, b.schema, question, results, b.rw.ret.DocSummaries, nil, sysPrompt, b.basePrompt)
+	} else {
+		answer, err = answerWithRetry(ctx, b.client, question, results, b.rw.ret.DocSummaries, sysPrompt, b.basePrompt)
+	}
+	res.TimeSeconds = time.Since(start).Seconds()
+	if err != nil {
+		res.Status = "error"
+		res.Error = fmt.Sprintf("LLM: %v", err)
+		return res
+	}
+	if answer.Usage == nil {
+		answer.Usage = llm.EstimateUsage(question+llm.FormatContext(results, b.rw.ret.DocSummaries), answer.Answer)
+	}
+	llm.RepairCitations(answer, results, b.rw.ret.DocSummaries)
+	res.Status = "ok"
+	if b.redact {
+		redactAnswer(answer)
+	}
+	res.Answer = answer
+	return res
+}
+
+// newBatchResponse summarises a batch's results. Answers keeps the original
+// positional shape (nil for failed questions) for existing clients.
+func newBatchResponse(rec *batchRecord, totalTime float64) BatchResponse {
+	resp := BatchResponse{
+		BatchID:   rec.ID,
+		Answers:   make([]*llm.Answer, len(rec.Results)),
+		Results:   rec.Results,
+		TotalTime: totalTime,
+		Cancelled: rec.Status == "cancelled",
+	}
+	for i, res := range rec.Results {
+		resp.Answers[i] = res.Answer
+		if res.Status == "ok" {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	return resp
+}
+
+// handleBatch answers many questions in parallel. Every question gets its
+// own status and error. With ?stream=true (or Accept: text/event-stream) a
+// "progress" SSE event is sent as each question finishes, then a "complete"
+// event carrying the full response. Passing resume_batch_id re-runs only the
+// questions of that earlier batch that did not succeed.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.ProjectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+
+	store := s.getProjectStore(r)
+
+	// Build the batch record: either a fresh batch or the one being resumed
+	var rec *batchRecord
+	if req.ResumeBatchID != "" {
+		var err error
+		rec, err = loadBatchRecord(store, req.ProjectID, req.ResumeBatchID)
+		if err != nil {
+			jsonErr(w, "Batch not found: "+req.ResumeBatchID, http.StatusNotFound)
+			return
+		}
+		if req.Provider == "" {
+			req.Provider = rec.Provider
+		}
+		if req.Model == "" {
+			req.Model = rec.Model
+		}
+	} else {
+		if len(req.Questions) == 0 {
+			jsonErr(w, "questions are required", http.StatusBadRequest)
+			return
+		}
+		rec = &batchRecord{
+			ID:        newID(),
+			ProjectID: req.ProjectID,
+			Source:    "request",
+			CreatedAt: time.Now(),
+		}
+		for i, q := range req.Questions {
+			rec.Results = append(rec.Results, BatchResult{Index: i, Question: q, Status: "pending"})
+		}
+	}
+	rec.Provider = req.Provider
+	rec.Model = req.Model
+
+	var pending []int
+	for i, res := range rec.Results {
+		if res.Status != "ok" {
+			pending = append(pending, i)
+		}
+	}
+
+	proj := s.projectForQuery(w, r, req.ProjectID)
+	if proj == nil {
+		return
+	}
+
+	rw, err := s.getRetrieverForProject(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	llmClient, err := s.getProvider(s.projectSettings(r, req.ProjectID), req.Provider, req.Model)
+	if err != nil {
+		jsonErr(w, fmt.Sprintf("Provider error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	runner := &batchRunner{rw: rw, client: llmClient, customSysPrompt: proj.SystemPrompt, basePrompt: proj.BasePrompt, redact: proj.RedactAnswers, docTypePrompts: s.projectSettings(r, req.ProjectID).DocTypePrompts}
+	if len(req.Schema) > 0 {
+		if runner.schema, err = llm.ParseSchema(req.Schema); err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	// Cancelled when the client goes away or through /api/batch/cancel
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer s.batchJobs.track(store, req.ProjectID, rec.ID, cancel)()
+	start := time.Now()
+
+	stream := r.URL.Query().Get("stream") == "true" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	var flusher http.Flusher
+	if stream {
+		var ok bool
+		if flusher, ok = w.(http.Flusher); !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		startData, _ := json.Marshal(map[string]interface{}{
+			"type":     "start",
+			"batch_id": rec.ID,
+			"total":    len(rec.Results),
+			"pending":  len(pending),
+		})
+		fmt.Fprintf(w, "data: %s\n\n", startData)
+		flusher.Flush()
+	}
+
+	done := len(rec.Results) - len(pending)
+	runner.run(ctx, rec.Results, pending, func(res BatchResult) {
+		done++
+		if !stream {
+			return
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"type":   "progress",
+			"done":   done,
+			"total":  len(rec.Results),
+			"result": res,
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	})
+
+	var batchUsage llm.Usage
+	for _, i := range pending {
+		if a := rec.Results[i].Answer; a != nil && a.Usage != nil {
+			batchUsage.InputTokens += a.Usage.InputTokens
+			batchUsage.OutputTokens += a.Usage.OutputTokens
+		}
+	}
+	recordTokenUsage(store, req.ProjectID, &batchUsage)
+
+	rec.Status = "done"
+	if ctx.Err() != nil {
+		rec.Status = "cancelled"
+	}
+	rec.UpdatedAt = time.Now()
+	if err := saveBatchRecord(store, rec); err != nil {
+		log.Printf("Warning: failed to save batch %s: %v", rec.ID, err)
+	}
+
+	resp := newBatchResponse(rec, time.Since(start).Seconds())
+	if !stream {
+		jsonResp(w, resp)
+		return
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":     "complete",
+		"response": resp,
+	})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	flusher.Flush()
+}
+
+// batchAttempts is how many times a batch question is tried before its error
+// is reported. Providers already retry 429/5xx internally; this covers the
+// remaining transient failures (timeouts, malformed JSON answers) so one bad
+// response doesn't sink a question in a long batch.
+const batchAttempts = 3
+
+// answerWithRetry calls AnswerQuestion, retrying failed attempts with a short
+// backoff. The LLM limiter queues each attempt like any other call.
+func answerWithRetry(ctx context.Context, client llm.Provider, question string, results []retriever.Result, summaries []indexer.DocumentSummary, customSysPrompt ...string) (*llm.Answer, error) {
+	var lastErr error
+	for attempt := 0; attempt < batchAttempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(time.Duration(attempt) * 2 * time.Second)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+		answer, err := client.AnswerQuestion(ctx, question, results, summaries, nil, customSysPrompt...)
+		if err == nil {
+			return answer, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if _, limited := retryAfter(err); limited {
+			return nil, err // the provider already waited out its rate limit
+		}
+		lastErr = err
+		log.Printf("Batch question failed (attempt %d/%d): %v", attempt+1, batchAttempts, err)
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"gocognigo/internal/indexer"
+)
+
+// ========== Chunk Browser ==========
+
+// chunkListing is one chunk as the browser lists it. EmbeddingNorm is 0
+// and EmbeddingDims 0 for a chunk that was never embedded; InBM25 is false
+// for one missing from the keyword index. Either means retrieval can't
+// find it by that route.
+type chunkListing struct {
+	ID            string   `json:"id"`
+	Document      string   `json:"document"`
+	Page          int      `json:"page"`
+	Section       string   `json:"section,omitempty"`
+	Text          string   `json:"text"`
+	TextLength    int      `json:"text_length"`
+	EmbeddingDims int      `json:"embedding_dims"`
+	EmbeddingNorm float64  `json:"embedding_norm"`
+	InBM25        bool     `json:"in_bm25"`
+	Language      string   `json:"language,omitempty"`
+	Multilingual  bool     `json:"multilingual,omitempty"`
+	PII           []string `json:"pii,omitempty"`
+	OCRConfidence float64  `json:"ocr_confidence,omitempty"`
+}
+
+// chunkDetail adds the full page context to a listing.
+type chunkDetail struct {
+	chunkListing
+	ParentText  string `json:"parent_text"`
+	Entities    int    `json:"entities"`
+	Definitions int    `json:"definitions"`
+}
+
+func embeddingNorm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
+
+func listChunk(idx *indexer.Index, c indexer.Chunk) chunkListing {
+	inBM25 := false
+	if idx.BM25Index != nil {
+		if doc, err := idx.BM25Index.Document(c.ID); err == nil && doc != nil {
+			inBM25 = true
+		}
+	}
+	return chunkListing{
+		ID:            c.ID,
+		Document:      c.Document,
+		Page:          c.PageNumber,
+		Section:       c.Section,
+		Text:          c.Text,
+		TextLength:    len(c.Text),
+		EmbeddingDims: len(c.Embedding),
+		EmbeddingNorm: embeddingNorm(c.Embedding),
+		InBM25:        inBM25,
+		Language:      c.Language,
+		Multilingual:  c.Multilingual,
+		PII:           c.PII,
+		OCRConfidence: c.OCRConfidence,
+	}
+}
+
+// handleChunks lists the indexed chunks of a project for auditing
+// (GET ?project_id, optional document= and page= filters, offset= and
+// limit= for paging), or returns one chunk in full with id=.
+func (s *Server) handleChunks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	if projectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.getProjectStore(r).Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	rw, err := s.getRetrieverForProject(projectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+
+	rw.idx.Lock()
+	chunks := make([]indexer.Chunk, len(rw.idx.Chunks))
+	copy(chunks, rw.idx.Chunks)
+	rw.idx.Unlock()
+
+	if id := q.Get("id"); id != "" {
+		for _, c := range chunks {
+			if c.ID == id {
+				jsonResp(w, chunkDetail{
+					chunkListing: listChunk(rw.idx, c),
+					ParentText:   c.ParentText,
+					Entities:     len(c.Entities),
+					Definitions:  len(c.Definitions),
+				})
+				return
+			}
+		}
+		jsonErr(w, "chunk not found", http.StatusNotFound)
+		return
+	}
+
+	document := q.Get("document")
+	page := 0
+	if v := q.Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			jsonErr(w, "page must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	var matched []indexer.Chunk
+	for _, c := range chunks {
+		if (document == "" || c.Document == document) && (page == 0 || c.PageNumber == page) {
+			matched = append(matched, c)
+		}
+	}
+
+	listings := []chunkListing{}
+	for i := offset; i < len(matched) && i < offset+limit; i++ {
+		listings = append(listings, listChunk(rw.idx, matched[i]))
+	}
+
+	jsonResp(w, map[string]interface{}{
+		"chunks":       listings,
+		"total":        len(matched),
+		"offset":       offset,
+		"limit":        limit,
+		"total_chunks": len(chunks),
+	})
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"gocognigo/internal/chat"
+	"gocognigo/internal/llm"
 )
 
 // ========== Community Endpoints ==========
@@ -50,6 +52,69 @@ func (s *Server) handleUpdateProjectMeta(w http.ResponseWriter, r *http.Request)
 	jsonResp(w, proj)
 }
 
+// maxPromptLen caps a project's custom and base prompts, in bytes.
+const maxPromptLen = 20000
+
+// handleProjectPrompt reads or sets a project's prompts. system_prompt is
+// prepended to the answering instructions; base_prompt replaces the
+// built-in instructions (house citation style, jurisdiction, tone) and is
+// cleared by setting it empty. The JSON response format is never
+// overridable, so answers still parse.
+func (s *Server) handleProjectPrompt(w http.ResponseWriter, r *http.Request) {
+	store := s.getProjectStore(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		proj, err := store.Get(r.URL.Query().Get("project_id"))
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		jsonResp(w, map[string]interface{}{
+			"system_prompt":       proj.SystemPrompt,
+			"base_prompt":         proj.BasePrompt,
+			"default_base_prompt": llm.DefaultBasePrompt(),
+		})
+
+	case http.MethodPost:
+		var req struct {
+			ProjectID    string  `json:"project_id"`
+			SystemPrompt *string `json:"system_prompt"`
+			BasePrompt   *string `json:"base_prompt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+			jsonErr(w, "project_id is required", http.StatusBadRequest)
+			return
+		}
+		for _, p := range []*string{req.SystemPrompt, req.BasePrompt} {
+			if p != nil && len(*p) > maxPromptLen {
+				jsonErr(w, fmt.Sprintf("prompts are limited to %d characters", maxPromptLen), http.StatusBadRequest)
+				return
+			}
+		}
+		proj, err := store.Get(req.ProjectID)
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		if req.SystemPrompt != nil {
+			proj.SystemPrompt = *req.SystemPrompt
+		}
+		if req.BasePrompt != nil {
+			proj.BasePrompt = strings.TrimSpace(*req.BasePrompt)
+		}
+		if err := store.Update(*proj); err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "project.prompt", req.ProjectID, "", nil)
+		jsonResp(w, map[string]interface{}{"system_prompt": proj.SystemPrompt, "base_prompt": proj.BasePrompt})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // handlePublishProject toggles a project's published state.
 func (s *Server) handlePublishProject(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
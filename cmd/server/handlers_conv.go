@@ -2,7 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"gocognigo/internal/chat"
 )
@@ -78,6 +81,7 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		ProjectID      string `json:"project_id"`
 		ConversationID string `json:"conversation_id"`
+		ActiveOnly     bool   `json:"active_only,omitempty"` // leave out messages superseded by edits
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
 		jsonErr(w, "project_id is required", http.StatusBadRequest)
@@ -88,6 +92,15 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		msgs = []chat.Message{}
 	}
+	if req.ActiveOnly {
+		active := []chat.Message{}
+		for _, m := range msgs {
+			if !m.Superseded() {
+				active = append(active, m)
+			}
+		}
+		msgs = active
+	}
 
 	jsonResp(w, msgs)
 }
@@ -122,3 +135,175 @@ func (s *Server) handleRenameConversation(w http.ResponseWriter, r *http.Request
 
 	jsonResp(w, conv)
 }
+
+// handleMessageFeedback records a rating on an assistant message
+// (POST {project_id, conversation_id, message_id, rating: "up"|"down",
+// reason, citations_correct}). An empty rating clears earlier feedback.
+func (s *Server) handleMessageFeedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID        string `json:"project_id"`
+		ConversationID   string `json:"conversation_id"`
+		MessageID        string `json:"message_id"`
+		Rating           string `json:"rating"`
+		Reason           string `json:"reason,omitempty"`
+		CitationsCorrect *bool  `json:"citations_correct,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" || req.ConversationID == "" || req.MessageID == "" {
+		jsonErr(w, "project_id, conversation_id and message_id are required", http.StatusBadRequest)
+		return
+	}
+
+	var fb *chat.MessageFeedback
+	switch req.Rating {
+	case "up", "down":
+		fb = &chat.MessageFeedback{
+			Rating:           req.Rating,
+			Reason:           strings.TrimSpace(req.Reason),
+			CitationsCorrect: req.CitationsCorrect,
+			UpdatedAt:        time.Now(),
+		}
+	case "":
+		// clear
+	default:
+		jsonErr(w, `rating must be "up", "down" or empty`, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.getProjectStore(r).SetMessageFeedback(req.ProjectID, req.ConversationID, req.MessageID, fb); err != nil {
+		code := http.StatusNotFound
+		if errors.Is(err, chat.ErrNotAssistantMessage) {
+			code = http.StatusBadRequest
+		}
+		jsonErr(w, err.Error(), code)
+		return
+	}
+	jsonResp(w, map[string]interface{}{"status": "ok", "feedback": fb})
+}
+
+// handleSearchConversations finds stored messages by keyword (POST {query,
+// project_id, conversation_id, role, include_superseded, limit}). Without a
+// project_id it searches every project the caller owns.
+func (s *Server) handleSearchConversations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Query             string `json:"query"`
+		ProjectID         string `json:"project_id,omitempty"`
+		ConversationID    string `json:"conversation_id,omitempty"`
+		Role              string `json:"role,omitempty"`
+		IncludeSuperseded bool   `json:"include_superseded,omitempty"`
+		Limit             int    `json:"limit,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Query) == "" {
+		jsonErr(w, "query is required", http.StatusBadRequest)
+		return
+	}
+	if req.Role != "" && req.Role != "user" && req.Role != "assistant" {
+		jsonErr(w, "role must be \"user\" or \"assistant\"", http.StatusBadRequest)
+		return
+	}
+	if req.ConversationID != "" && req.ProjectID == "" {
+		jsonErr(w, "conversation_id requires project_id", http.StatusBadRequest)
+		return
+	}
+	if req.Limit <= 0 || req.Limit > 100 {
+		req.Limit = 20
+	}
+
+	start := time.Now()
+	store := s.getProjectStore(r)
+	opts := chat.MessageSearch{
+		Query:             req.Query,
+		ConversationID:    req.ConversationID,
+		Role:              req.Role,
+		IncludeSuperseded: req.IncludeSuperseded,
+		Limit:             req.Limit,
+	}
+	var hits []chat.MessageHit
+	var total int
+	var err error
+	if req.ProjectID != "" {
+		if _, err := store.Get(req.ProjectID); err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		hits, total, err = store.SearchMessages(req.ProjectID, opts)
+	} else {
+		hits, total, err = store.SearchAllMessages(opts)
+	}
+	if err != nil {
+		jsonErr(w, "Search error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp(w, map[string]interface{}{
+		"results": hits,
+		"total":   total,
+		"time_ms": time.Since(start).Milliseconds(),
+	})
+}
+
+// handlePinConversation pins or unpins a conversation (POST {project_id,
+// conversation_id, pinned}). Pinned conversations list first.
+func (s *Server) handlePinConversation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID      string `json:"project_id"`
+		ConversationID string `json:"conversation_id"`
+		Pinned         bool   `json:"pinned"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" || req.ConversationID == "" {
+		jsonErr(w, "project_id and conversation_id are required", http.StatusBadRequest)
+		return
+	}
+
+	conv, err := s.getProjectStore(r).SetConversationPinned(req.ProjectID, req.ConversationID, req.Pinned)
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	jsonResp(w, conv)
+}
+
+// handleStarMessage stars or unstars an assistant message (POST {project_id,
+// conversation_id, message_id, starred}).
+func (s *Server) handleStarMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID      string `json:"project_id"`
+		ConversationID string `json:"conversation_id"`
+		MessageID      string `json:"message_id"`
+		Starred        bool   `json:"starred"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" || req.ConversationID == "" || req.MessageID == "" {
+		jsonErr(w, "project_id, conversation_id and message_id are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.getProjectStore(r).SetMessageStarred(req.ProjectID, req.ConversationID, req.MessageID, req.Starred); err != nil {
+		code := http.StatusNotFound
+		if errors.Is(err, chat.ErrNotAssistantMessage) {
+			code = http.StatusBadRequest
+		}
+		jsonErr(w, err.Error(), code)
+		return
+	}
+	jsonResp(w, map[string]interface{}{"status": "ok", "starred": req.Starred})
+}
@@ -0,0 +1,763 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocognigo/internal/analysis"
+	"gocognigo/internal/chat"
+	"gocognigo/internal/extractor"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/llm"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Document Endpoints ==========
+
+// cachedSummary is a long-form summary stored under <project>/summaries/.
+// Fingerprint hashes the document's indexed text, so a summary made before
+// a re-ingest that changed the text is never served.
+type cachedSummary struct {
+	Fingerprint string           `json:"fingerprint"`
+	GeneratedAt time.Time        `json:"generated_at"`
+	Summary     *llm.LongSummary `json:"summary"`
+}
+
+func summaryCachePath(store *chat.ProjectStore, projectID, docName string) string {
+	sum := sha256.Sum256([]byte(docName))
+	return filepath.Join(store.ProjectDir(projectID), "summaries", hex.EncodeToString(sum[:8])+".json")
+}
+
+// removeCachedSummary drops a document's cached summary, e.g. when the
+// document is deleted.
+func removeCachedSummary(store *chat.ProjectStore, projectID, docName string) {
+	_ = os.Remove(summaryCachePath(store, projectID, docName))
+}
+
+// documentPages returns a document's pages, in order, as summary input.
+func documentPages(chunks []indexer.Chunk, docName string) []llm.SummaryPage {
+	passages := corpusPassages(chunks, docName)
+	pages := make([]llm.SummaryPage, len(passages))
+	for i, p := range passages {
+		pages[i] = llm.SummaryPage{Number: p.Page, Text: p.Text}
+	}
+	return pages
+}
+
+func pagesFingerprint(pages []llm.SummaryPage) string {
+	h := sha256.New()
+	for _, p := range pages {
+		fmt.Fprintf(h, "%d\x00%s\x00", p.Number, p.Text)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handleSummarizeDocument returns a long-form structured summary of one
+// document (POST {project_id, document, refresh}), built by map-reduce over
+// all of its pages. Summaries are cached per document; refresh forces a new one.
+func (s *Server) handleSummarizeDocument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Document  string `json:"document"`
+		Refresh   bool   `json:"refresh,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" || req.Document == "" {
+		jsonErr(w, "project_id and document are required", http.StatusBadRequest)
+		return
+	}
+
+	store := s.getProjectStore(r)
+	if s.projectForQuery(w, r, req.ProjectID) == nil {
+		return
+	}
+	rw, err := s.getRetrieverForProject(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	pages := documentPages(rw.ret.Chunks, req.Document)
+	if len(pages) == 0 {
+		jsonErr(w, "Document not found in the index", http.StatusNotFound)
+		return
+	}
+	fingerprint := pagesFingerprint(pages)
+
+	cachePath := summaryCachePath(store, req.ProjectID, req.Document)
+	if !req.Refresh {
+		if data, err := os.ReadFile(cachePath); err == nil {
+			var cached cachedSummary
+			if json.Unmarshal(data, &cached) == nil && cached.Fingerprint == fingerprint && cached.Summary != nil {
+				jsonResp(w, map[string]interface{}{
+					"summary":      cached.Summary,
+					"generated_at": cached.GeneratedAt,
+					"cached":       true,
+				})
+				return
+			}
+		}
+	}
+
+	summarizer, ok := summaryCompleter(s.projectSettings(r, req.ProjectID))
+	if !ok {
+		jsonErr(w, "No API key configured for the summary provider", http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	summary, err := llm.SummarizeDocument(r.Context(), summarizer, req.Document, pages)
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordTokenUsage(store, req.ProjectID, summary.Usage)
+
+	cached := cachedSummary{Fingerprint: fingerprint, GeneratedAt: time.Now(), Summary: summary}
+	_ = os.MkdirAll(filepath.Dir(cachePath), 0755)
+	data, _ := json.MarshalIndent(cached, "", "  ")
+	if err := os.WriteFile(cachePath, data, 0644); err != nil {
+		log.Printf("Warning: failed to cache summary for %s: %v", req.Document, err)
+	}
+
+	jsonResp(w, map[string]interface{}{
+		"summary":      summary,
+		"generated_at": cached.GeneratedAt,
+		"cached":       false,
+		"time_seconds": time.Since(start).Seconds(),
+	})
+}
+
+// ========== Ingest Summary Regeneration ==========
+
+// handleRegenerateSummaries (re)generates the ingest summaries (title, type,
+// sections) of one document or, without document, of every indexed document
+// of a project (POST {project_id, document, provider, model}), e.g. after
+// they failed during ingest or to use a better model. provider and model
+// default to the summary settings. The vector store is re-saved and the
+// project's retriever rebuilt with the new summaries.
+func (s *Server) handleRegenerateSummaries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Document  string `json:"document,omitempty"`
+		Provider  string `json:"provider,omitempty"`
+		Model     string `json:"model,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if s.projectForQuery(w, r, req.ProjectID) == nil {
+		return
+	}
+	if s.ingestStatus.snapshot().Phase == "processing" {
+		jsonErr(w, "Ingestion already in progress", http.StatusConflict)
+		return
+	}
+	rw, err := s.getRetrieverForProject(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+
+	settings := s.projectSettings(r, req.ProjectID)
+	summarizer, ok := summaryCompleter(settings)
+	if req.Provider != "" {
+		summarizer = llm.Completer{Provider: req.Provider, APIKey: providerKey(settings, req.Provider)}
+		ok = summarizer.APIKey != ""
+	}
+	if !ok {
+		jsonErr(w, "No API key configured for the summary provider", http.StatusBadRequest)
+		return
+	}
+	if req.Model != "" {
+		summarizer.Model = req.Model
+	}
+
+	rw.idx.Lock()
+	chunks := rw.idx.Chunks
+	rw.idx.Unlock()
+	var docs []string
+	seen := map[string]bool{}
+	for _, c := range chunks {
+		if !seen[c.Document] && (req.Document == "" || c.Document == req.Document) {
+			seen[c.Document] = true
+			docs = append(docs, c.Document)
+		}
+	}
+	if len(docs) == 0 {
+		jsonErr(w, "Document not found in the index", http.StatusNotFound)
+		return
+	}
+
+	// The LLM limiter bounds how many summaries are in flight
+	start := time.Now()
+	type outcome struct {
+		Document string `json:"document"`
+		Title    string `json:"title,omitempty"`
+		Type     string `json:"type,omitempty"`
+		Sections int    `json:"sections"`
+		Error    string `json:"error,omitempty"`
+	}
+	outcomes := make([]outcome, len(docs))
+	var wg sync.WaitGroup
+	for i, doc := range docs {
+		wg.Add(1)
+		go func(i int, doc string) {
+			defer wg.Done()
+			outcomes[i].Document = doc
+			summary, err := regenerateDocSummary(r.Context(), summarizer, chunks, doc)
+			if err != nil {
+				log.Printf("Warning: failed to regenerate summary for %s: %v", doc, err)
+				outcomes[i].Error = err.Error()
+				return
+			}
+			rw.idx.SetDocSummary(*summary)
+			outcomes[i].Title, outcomes[i].Type, outcomes[i].Sections = summary.Title, summary.DocType, len(summary.Sections)
+		}(i, doc)
+	}
+	wg.Wait()
+
+	regenerated := 0
+	for _, o := range outcomes {
+		if o.Error == "" {
+			regenerated++
+		}
+	}
+	if regenerated > 0 {
+		store := s.getProjectStore(r)
+		if err := rw.idx.SaveVectors(store.VectorsPath(req.ProjectID)); err != nil {
+			log.Printf("Warning: failed to re-save vectors after regenerating summaries: %v", err)
+		}
+		s.mu.Lock()
+		ret := retriever.NewRetriever(rw.idx)
+		if s.activeProjectID == req.ProjectID {
+			s.activeIndex = rw.idx
+			s.activeRetriever = ret
+		}
+		s.indexCache.put(req.ProjectID, &cachedIndex{idx: rw.idx, ret: ret})
+		s.mu.Unlock()
+	}
+
+	recordAudit(r, "document.summaries", req.ProjectID, req.Document, map[string]string{
+		"provider":    summarizer.Provider,
+		"regenerated": strconv.Itoa(regenerated),
+		"failed":      strconv.Itoa(len(docs) - regenerated),
+	})
+	jsonResp(w, map[string]interface{}{
+		"documents":    outcomes,
+		"regenerated":  regenerated,
+		"failed":       len(docs) - regenerated,
+		"time_seconds": time.Since(start).Seconds(),
+	})
+}
+
+// errNoPageText is regenerateDocSummary's error for a document the index
+// has no page text of, only summary nodes.
+var errNoPageText = errors.New("the index holds no page text of the document to summarize")
+
+// regenerateDocSummary generates doc's summary from its indexed pages.
+func regenerateDocSummary(ctx context.Context, summarizer llm.Completer, chunks []indexer.Chunk, doc string) (*indexer.DocumentSummary, error) {
+	pages := documentPages(chunks, doc)
+	if len(pages) == 0 {
+		return nil, errNoPageText
+	}
+	texts := make([]string, len(pages))
+	for j, p := range pages {
+		texts[j] = p.Text
+	}
+	return llm.GenerateDocSummary(ctx, summarizer, doc, texts, pages[len(pages)-1].Number)
+}
+
+// ========== Document Comparison ==========
+
+// handleCompareDocuments compares two documents side by side (POST
+// {project_id, documents: [a, b], topic}). Each document is searched on its
+// own for the topic, or for every material term when topic is empty or
+// "all material terms", so neither crowds the other out of the context.
+func (s *Server) handleCompareDocuments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID string   `json:"project_id"`
+		Documents []string `json:"documents"`
+		Topic     string   `json:"topic"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Documents) != 2 || req.Documents[0] == req.Documents[1] {
+		jsonErr(w, "documents must name two different documents", http.StatusBadRequest)
+		return
+	}
+	topic := strings.TrimSpace(req.Topic)
+	if topic == "" || strings.EqualFold(topic, llm.AllMaterialTerms) {
+		topic = llm.AllMaterialTerms
+	}
+
+	store := s.getProjectStore(r)
+	if s.projectForQuery(w, r, req.ProjectID) == nil {
+		return
+	}
+	rw, err := s.getRetrieverForProject(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
+	// Comparisons run on the same cheap model as summaries
+	comparer, ok := summaryCompleter(s.projectSettings(r, req.ProjectID))
+	if !ok {
+		jsonErr(w, "No API key configured for the summary provider", http.StatusBadRequest)
+		return
+	}
+
+	probes, perProbe := []string{topic}, 6
+	if topic == llm.AllMaterialTerms {
+		probes, perProbe = llm.MaterialTermProbes, 3
+	}
+
+	start := time.Now()
+	ctx := r.Context()
+	docs := [2]string{req.Documents[0], req.Documents[1]}
+	var excerpts [2][]retriever.Result
+	for i, doc := range docs {
+		seen := map[int]bool{}
+		for _, probe := range probes {
+			results, err := rw.ret.SearchDocument(ctx, probe, doc, perProbe)
+			if err != nil {
+				jsonErr(w, fmt.Sprintf("Retrieval error: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for _, res := range results {
+				if res.Level == "" && !seen[res.PageNumber] {
+					seen[res.PageNumber] = true
+					excerpts[i] = append(excerpts[i], res)
+				}
+			}
+		}
+		if len(excerpts[i]) == 0 {
+			jsonErr(w, "Document not found in the index: "+doc, http.StatusNotFound)
+			return
+		}
+	}
+
+	cmp, err := llm.CompareDocuments(ctx, comparer, topic, docs, excerpts)
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordTokenUsage(store, req.ProjectID, cmp.Usage)
+
+	jsonResp(w, map[string]interface{}{
+		"comparison":   cmp,
+		"excerpts":     [2]int{len(excerpts[0]), len(excerpts[1])},
+		"time_seconds": time.Since(start).Seconds(),
+	})
+}
+
+// ========== Corpus Summary ==========
+
+// storedCorpusSummary is the latest executive summary of a project.
+type storedCorpusSummary struct {
+	GeneratedAt time.Time          `json:"generated_at"`
+	Summary     *llm.CorpusSummary `json:"summary"`
+}
+
+func corpusSummaryPath(store *chat.ProjectStore, projectID string) string {
+	return filepath.Join(store.ProjectDir(projectID), "reports", "corpus_summary.json")
+}
+
+// handleCorpusSummary generates a cross-document executive summary of a
+// project (POST {project_id}) from its document summaries and targeted
+// retrieval, or returns the latest one (GET ?project_id=, &format=markdown
+// for a kickoff memo).
+func (s *Server) handleCorpusSummary(w http.ResponseWriter, r *http.Request) {
+	store := s.getProjectStore(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.URL.Query().Get("project_id")
+		proj, err := store.Get(projectID)
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		data, err := os.ReadFile(corpusSummaryPath(store, projectID))
+		if err != nil {
+			jsonErr(w, "No summary yet. POST to generate one.", http.StatusNotFound)
+			return
+		}
+		var stored storedCorpusSummary
+		if err := json.Unmarshal(data, &stored); err != nil || stored.Summary == nil {
+			jsonErr(w, "failed to read summary", http.StatusInternalServerError)
+			return
+		}
+		if format := r.URL.Query().Get("format"); format == "markdown" || format == "md" {
+			filename := sanitizeFilename(proj.Name+" summary") + ".md"
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+			w.Write([]byte(corpusSummaryMarkdown(proj.Name, stored)))
+			return
+		}
+		jsonResp(w, stored)
+
+	case http.MethodPost:
+		var req struct {
+			ProjectID string `json:"project_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+			jsonErr(w, "project_id is required", http.StatusBadRequest)
+			return
+		}
+		proj := s.projectForQuery(w, r, req.ProjectID)
+		if proj == nil {
+			return
+		}
+		rw, err := s.getRetrieverForProject(req.ProjectID)
+		if err != nil {
+			jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+			return
+		}
+		if err := checkEmbedding(rw.idx); err != nil {
+			jsonErr(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		summarizer, ok := summaryCompleter(s.projectSettings(r, proj.ID))
+		if !ok {
+			jsonErr(w, "No API key configured for the summary provider", http.StatusBadRequest)
+			return
+		}
+
+		start := time.Now()
+		ctx := r.Context()
+
+		// Targeted retrieval: a few pages per probe, each page once
+		var excerpts []retriever.Result
+		seen := map[string]bool{}
+		for _, probe := range llm.CorpusProbes {
+			results, err := rw.ret.Search(ctx, probe, 6)
+			if err != nil {
+				log.Printf("Warning: corpus summary probe %q failed: %v", probe, err)
+				continue
+			}
+			for _, res := range results {
+				key := fmt.Sprintf("%s#%d", res.Document, res.PageNumber)
+				if seen[key] || res.Level != "" {
+					continue
+				}
+				seen[key] = true
+				excerpts = append(excerpts, res)
+			}
+		}
+
+		summary, err := llm.SummarizeCorpus(ctx, summarizer, proj.Name, rw.ret.DocSummaries, excerpts)
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordTokenUsage(store, req.ProjectID, summary.Usage)
+
+		stored := storedCorpusSummary{GeneratedAt: time.Now(), Summary: summary}
+		path := corpusSummaryPath(store, req.ProjectID)
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		data, _ := json.MarshalIndent(stored, "", "  ")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			log.Printf("Warning: failed to save corpus summary for %s: %v", req.ProjectID, err)
+		}
+
+		jsonResp(w, map[string]interface{}{
+			"generated_at": stored.GeneratedAt,
+			"summary":      summary,
+			"excerpts":     len(excerpts),
+			"time_seconds": time.Since(start).Seconds(),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// corpusSummaryMarkdown renders an executive summary as a memo.
+func corpusSummaryMarkdown(projectName string, stored storedCorpusSummary) string {
+	cs := stored.Summary
+	var sb strings.Builder
+
+	title := cs.Title
+	if title == "" {
+		title = projectName
+	}
+	sb.WriteString(fmt.Sprintf("# %s\n\n", title))
+	sb.WriteString(fmt.Sprintf("**Project:** %s  \n", projectName))
+	sb.WriteString(fmt.Sprintf("**Documents:** %d  \n", cs.Documents))
+	sb.WriteString(fmt.Sprintf("**Generated:** %s  \n\n", stored.GeneratedAt.Format("January 2, 2006")))
+	sb.WriteString("---\n\n")
+
+	sb.WriteString("## Executive Summary\n\n")
+	sb.WriteString(strings.TrimSpace(cs.ExecutiveSummary))
+	sb.WriteString("\n\n")
+
+	if len(cs.Themes) > 0 {
+		sb.WriteString("## Themes\n\n")
+		for _, t := range cs.Themes {
+			sb.WriteString(fmt.Sprintf("### %s\n\n%s\n", t.Theme, strings.TrimSpace(t.Summary)))
+			if len(t.Documents) > 0 {
+				sb.WriteString(fmt.Sprintf("\n*Sources: %s*\n", strings.Join(t.Documents, ", ")))
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	if len(cs.KeyParties) > 0 {
+		sb.WriteString("## Key Parties\n\n| Party | Role | Documents |\n|---|---|---|\n")
+		for _, p := range cs.KeyParties {
+			sb.WriteString(fmt.Sprintf("| %s | %s | %s |\n", mdCell(p.Name), mdCell(p.Role), mdCell(strings.Join(p.Documents, ", "))))
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(cs.Timeline) > 0 {
+		sb.WriteString("## Timeline\n\n| Date | Event | Source |\n|---|---|---|\n")
+		for _, t := range cs.Timeline {
+			source := t.Document
+			if t.Page > 0 {
+				source = fmt.Sprintf("%s — p.%d", t.Document, t.Page)
+			}
+			sb.WriteString(fmt.Sprintf("| %s | %s | %s |\n", mdCell(t.Date), mdCell(t.Event), mdCell(source)))
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(cs.OpenQuestions) > 0 {
+		sb.WriteString("## Open Questions\n\n")
+		for _, q := range cs.OpenQuestions {
+			sb.WriteString("- " + q + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// mdCell escapes text for a Markdown table cell.
+func mdCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// ========== Page Images ==========
+
+// pageImagePrefix is the start of a document's rendered page files under
+// <project>/pages/; the name is hashed like summary cache paths.
+func pageImagePrefix(store *chat.ProjectStore, projectID, docName string) string {
+	sum := sha256.Sum256([]byte(docName))
+	return filepath.Join(store.ProjectDir(projectID), "pages", hex.EncodeToString(sum[:8]))
+}
+
+// removePageImages drops a document's rendered pages.
+func removePageImages(store *chat.ProjectStore, projectID, docName string) {
+	files, _ := filepath.Glob(pageImagePrefix(store, projectID, docName) + "-*.png")
+	for _, f := range files {
+		_ = os.Remove(f)
+	}
+}
+
+// handlePageImage renders one page of an uploaded PDF to PNG
+// (GET ?project_id&name&page[&dpi]) for citation previews. Renders are
+// cached on disk and redone when the upload is newer than the cached image.
+func (s *Server) handlePageImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	name := q.Get("name")
+	if projectID == "" || name == "" {
+		jsonErr(w, "project_id and name are required", http.StatusBadRequest)
+		return
+	}
+	clean := filepath.Base(name)
+	if clean != name || clean == "." || clean == ".." || strings.HasPrefix(clean, ".") {
+		jsonErr(w, "invalid filename", http.StatusBadRequest)
+		return
+	}
+	if strings.ToLower(filepath.Ext(clean)) != ".pdf" {
+		jsonErr(w, "only PDF pages can be rendered", http.StatusBadRequest)
+		return
+	}
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 {
+		jsonErr(w, "page must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	dpi := extractor.DefaultRenderDPI
+	if v := q.Get("dpi"); v != "" {
+		dpi, err = strconv.Atoi(v)
+		if err != nil || dpi < extractor.MinRenderDPI || dpi > extractor.MaxRenderDPI {
+			jsonErr(w, fmt.Sprintf("dpi must be between %d and %d", extractor.MinRenderDPI, extractor.MaxRenderDPI), http.StatusBadRequest)
+			return
+		}
+	}
+
+	store := s.getProjectStore(r)
+	if _, err := store.Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	srcPath := filepath.Join(store.UploadsDir(projectID), clean)
+	src, err := os.Stat(srcPath)
+	if err != nil {
+		jsonErr(w, "file not found", http.StatusNotFound)
+		return
+	}
+
+	cachePath := fmt.Sprintf("%s-p%d-%d.png", pageImagePrefix(store, projectID, clean), page, dpi)
+	if cached, err := os.Stat(cachePath); err == nil && !cached.ModTime().Before(src.ModTime()) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		http.ServeFile(w, r, cachePath)
+		return
+	}
+
+	png, err := extractor.RenderPage(srcPath, page, dpi)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if !extractor.DetectPdftoppm() {
+			status = http.StatusNotImplemented
+		}
+		jsonErr(w, "Failed to render page: "+err.Error(), status)
+		return
+	}
+
+	// Write via a temp file so a concurrent request never reads half an image.
+	_ = os.MkdirAll(filepath.Dir(cachePath), 0755)
+	if tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".render-*"); err == nil {
+		_, werr := tmp.Write(png)
+		tmp.Close()
+		if werr != nil || os.Rename(tmp.Name(), cachePath) != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	_, _ = w.Write(png)
+}
+
+// ========== Extracted Text ==========
+
+// pageTextInfo describes one extracted page of a document.
+type pageTextInfo struct {
+	Page     int    `json:"page"`
+	Chars    int    `json:"chars"`
+	Words    int    `json:"words"`
+	Language string `json:"language,omitempty"`
+}
+
+// handleDocumentText returns the text extracted from a document's page
+// (GET ?project_id&name&page), after OCR and before chunking, to check
+// extraction quality. Without page= it lists the document's pages with
+// their sizes and the pages between them that yielded no text.
+func (s *Server) handleDocumentText(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	name := q.Get("name")
+	if projectID == "" || name == "" {
+		jsonErr(w, "project_id and name are required", http.StatusBadRequest)
+		return
+	}
+	page := 0
+	if v := q.Get("page"); v != "" {
+		var err error
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			jsonErr(w, "page must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := s.getProjectStore(r).Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	rw, err := s.getRetrieverForProject(projectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	passages := corpusPassages(rw.ret.Chunks, name)
+	if len(passages) == 0 {
+		jsonErr(w, "Document not found in the index", http.StatusNotFound)
+		return
+	}
+
+	if page > 0 {
+		for _, p := range passages {
+			if p.Page == page {
+				jsonResp(w, map[string]interface{}{
+					"document": name,
+					"page":     page,
+					"text":     p.Text,
+					"chars":    len(p.Text),
+					"words":    len(strings.Fields(p.Text)),
+					"language": analysis.DetectLanguage(p.Text),
+				})
+				return
+			}
+		}
+		jsonErr(w, fmt.Sprintf("No text was extracted from page %d", page), http.StatusNotFound)
+		return
+	}
+
+	pages := make([]pageTextInfo, len(passages))
+	present := map[int]bool{}
+	for i, p := range passages {
+		pages[i] = pageTextInfo{Page: p.Page, Chars: len(p.Text), Words: len(strings.Fields(p.Text)), Language: analysis.DetectLanguage(p.Text)}
+		present[p.Page] = true
+	}
+	missing := []int{}
+	for n := 1; n < passages[len(passages)-1].Page; n++ {
+		if !present[n] {
+			missing = append(missing, n)
+		}
+	}
+	jsonResp(w, map[string]interface{}{
+		"document":      name,
+		"pages":         pages,
+		"missing_pages": missing,
+	})
+}
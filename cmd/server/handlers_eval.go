@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/eval"
+	"gocognigo/internal/llm"
+)
+
+// ========== Evaluation Harness ==========
+
+// Each project can hold one gold set of questions with expected answers.
+// Running it scores retrieval, citations and answer similarity, and each run
+// is stored alongside the settings it ran with so a drop after re-chunking
+// or a model change shows up against the previous run.
+
+const maxEvalCases = 500
+
+// EvalRun is one stored run of a project's gold set.
+type EvalRun struct {
+	ID            string            `json:"id"`
+	ProjectID     string            `json:"project_id"`
+	Time          time.Time         `json:"time"`
+	Provider      string            `json:"provider,omitempty"`
+	Model         string            `json:"model,omitempty"`
+	EmbedProvider string            `json:"embed_provider,omitempty"`
+	EmbedModel    string            `json:"embed_model,omitempty"`
+	ChunkCount    int               `json:"chunk_count"`
+	Summary       eval.Summary      `json:"summary"`
+	PreviousRunID string            `json:"previous_run_id,omitempty"`
+	Regressions   []eval.Regression `json:"regressions,omitempty"`
+	TotalTime     float64           `json:"total_time_seconds"`
+	Results       []eval.CaseResult `json:"results,omitempty"`
+}
+
+func evalDir(store *chat.ProjectStore, projectID string) string {
+	return filepath.Join(store.ProjectDir(projectID), "eval")
+}
+
+func loadEvalSet(store *chat.ProjectStore, projectID string) ([]eval.Case, error) {
+	data, err := os.ReadFile(filepath.Join(evalDir(store, projectID), "set.json"))
+	if os.IsNotExist(err) {
+		return []eval.Case{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cases []eval.Case
+	if err := json.Unmarshal(data, &cases); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// loadEvalRuns returns a project's runs, newest first.
+func loadEvalRuns(store *chat.ProjectStore, projectID string) []EvalRun {
+	dir := filepath.Join(evalDir(store, projectID), "runs")
+	entries, _ := os.ReadDir(dir)
+	runs := []EvalRun{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var run EvalRun
+		if json.Unmarshal(data, &run) == nil {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Time.After(runs[j].Time) })
+	return runs
+}
+
+// handleEvalSet reads (GET ?project_id=) or replaces (POST {project_id,
+// cases}) a project's gold set.
+func (s *Server) handleEvalSet(w http.ResponseWriter, r *http.Request) {
+	store := s.getProjectStore(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.URL.Query().Get("project_id")
+		if _, err := store.Get(projectID); err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		cases, err := loadEvalSet(store, projectID)
+		if err != nil {
+			jsonErr(w, "failed to read eval set", http.StatusInternalServerError)
+			return
+		}
+		jsonResp(w, map[string]interface{}{"cases": cases})
+
+	case http.MethodPost:
+		var req struct {
+			ProjectID string      `json:"project_id"`
+			Cases     []eval.Case `json:"cases"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+			jsonErr(w, "project_id is required", http.StatusBadRequest)
+			return
+		}
+		if _, err := store.Get(req.ProjectID); err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		if len(req.Cases) > maxEvalCases {
+			jsonErr(w, fmt.Sprintf("Too many cases (%d, max %d)", len(req.Cases), maxEvalCases), http.StatusBadRequest)
+			return
+		}
+		for i, c := range req.Cases {
+			if strings.TrimSpace(c.Question) == "" || strings.TrimSpace(c.ExpectedAnswer) == "" {
+				jsonErr(w, fmt.Sprintf("case %d: question and expected_answer are required", i+1), http.StatusBadRequest)
+				return
+			}
+		}
+
+		dir := evalDir(store, req.ProjectID)
+		_ = os.MkdirAll(dir, 0755)
+		data, _ := json.MarshalIndent(req.Cases, "", "  ")
+		if err := os.WriteFile(filepath.Join(dir, "set.json"), data, 0644); err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonResp(w, map[string]interface{}{"cases": len(req.Cases)})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleEvalRun runs a project's gold set through retrieval and the LLM
+// (POST {project_id, provider, model}) and stores the scored run.
+func (s *Server) handleEvalRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Provider  string `json:"provider,omitempty"`
+		Model     string `json:"model,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+
+	store := s.getProjectStore(r)
+	proj := s.projectForQuery(w, r, req.ProjectID)
+	if proj == nil {
+		return
+	}
+	cases, err := loadEvalSet(store, req.ProjectID)
+	if err != nil || len(cases) == 0 {
+		jsonErr(w, "No eval set for this project. POST one to /api/eval/set first.", http.StatusBadRequest)
+		return
+	}
+
+	rw, err := s.getRetrieverForProject(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	settings := s.projectSettings(r, req.ProjectID)
+	llmClient, err := s.getProvider(settings, req.Provider, req.Model)
+	if err != nil {
+		jsonErr(w, fmt.Sprintf("Provider error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	start := time.Now()
+	results := make([]eval.CaseResult, len(cases))
+	var usage llm.Usage
+	var usageMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, c := range cases {
+		wg.Add(1)
+		go func(i int, c eval.Case) {
+			defer wg.Done()
+			t := time.Now()
+			retrieved, err := rw.ret.Search(ctx, c.Question, defaultTopK)
+			if err != nil {
+				results[i] = eval.CaseResult{Case: c, Error: fmt.Sprintf("retrieval: %v", err)}
+				return
+			}
+			answer, err := answerWithRetry(ctx, llmClient, c.Question, retrieved, rw.ret.DocSummaries, withDocTypePrompt(withDefinitions(rw.ret, c.Question, proj.SystemPrompt), retrieved, rw.ret.DocSummaries, settings.DocTypePrompts), proj.BasePrompt)
+			results[i] = eval.ScoreCase(c, retrieved, answer)
+			results[i].TimeSeconds = time.Since(t).Seconds()
+			if err != nil {
+				results[i].Error = fmt.Sprintf("LLM: %v", err)
+				return
+			}
+			if answer.Usage == nil {
+				answer.Usage = llm.EstimateUsage(c.Question+llm.FormatContext(retrieved, rw.ret.DocSummaries), answer.Answer)
+			}
+			usageMu.Lock()
+			usage.InputTokens += answer.Usage.InputTokens
+			usage.OutputTokens += answer.Usage.OutputTokens
+			usageMu.Unlock()
+		}(i, c)
+	}
+	wg.Wait()
+	recordTokenUsage(store, req.ProjectID, &usage)
+
+	run := EvalRun{
+		ID:            newID(),
+		ProjectID:     req.ProjectID,
+		Time:          start,
+		Provider:      req.Provider,
+		Model:         req.Model,
+		EmbedProvider: settings.EmbedProvider,
+		EmbedModel:    settings.EmbedModel,
+		ChunkCount:    len(rw.ret.Chunks),
+		Summary:       eval.Summarize(results),
+		TotalTime:     time.Since(start).Seconds(),
+		Results:       results,
+	}
+	if prev := loadEvalRuns(store, req.ProjectID); len(prev) > 0 {
+		run.PreviousRunID = prev[0].ID
+		run.Regressions = eval.Compare(prev[0].Summary, run.Summary)
+	}
+
+	dir := filepath.Join(evalDir(store, req.ProjectID), "runs")
+	_ = os.MkdirAll(dir, 0755)
+	data, _ := json.MarshalIndent(run, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, run.ID+".json"), data, 0644); err != nil {
+		log.Printf("Warning: failed to save eval run %s: %v", run.ID, err)
+	}
+	if len(run.Regressions) > 0 {
+		log.Printf("Eval run %s for project %s: %d metric(s) regressed", run.ID, req.ProjectID, len(run.Regressions))
+	}
+
+	jsonResp(w, run)
+}
+
+// handleEvalRuns lists a project's runs newest first without per-case
+// results (GET ?project_id=), or returns one run in full (&run_id=).
+func (s *Server) handleEvalRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	store := s.getProjectStore(r)
+	projectID := r.URL.Query().Get("project_id")
+	if _, err := store.Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	runs := loadEvalRuns(store, projectID)
+	if runID := r.URL.Query().Get("run_id"); runID != "" {
+		for _, run := range runs {
+			if run.ID == runID {
+				jsonResp(w, run)
+				return
+			}
+		}
+		jsonErr(w, "Run not found", http.StatusNotFound)
+		return
+	}
+
+	for i := range runs {
+		runs[i].Results = nil
+	}
+	jsonResp(w, map[string]interface{}{"runs": runs})
+}
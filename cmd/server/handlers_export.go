@@ -99,6 +99,60 @@ func (s *Server) handleExportConversation(w http.ResponseWriter, r *http.Request
 	w.Write([]byte(sb.String()))
 }
 
+// handleStarred lists a project's starred answers with their citations
+// (GET ?project_id=), or downloads them as a Markdown "key findings"
+// document (&format=markdown).
+func (s *Server) handleStarred(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := r.URL.Query().Get("project_id")
+	proj, err := s.getProjectStore(r).Get(projectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	starred := s.getProjectStore(r).StarredAnswers(projectID)
+
+	if format := r.URL.Query().Get("format"); format != "markdown" && format != "md" {
+		jsonResp(w, map[string]interface{}{"starred": starred})
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Key Findings — %s\n\n", proj.Name))
+	sb.WriteString(fmt.Sprintf("**Exported:** %s  \n", time.Now().Format("January 2, 2006")))
+	sb.WriteString(fmt.Sprintf("**Starred answers:** %d  \n\n", len(starred)))
+	sb.WriteString("---\n\n")
+
+	for i, sa := range starred {
+		heading := strings.TrimSpace(sa.Question)
+		if heading == "" {
+			heading = "Finding"
+		}
+		sb.WriteString(fmt.Sprintf("## %d. %s\n\n", i+1, heading))
+		if sa.ConversationName != "" {
+			sb.WriteString(fmt.Sprintf("*From \"%s\"*\n\n", sa.ConversationName))
+		}
+		sb.WriteString(strings.TrimSpace(sa.Message.Content))
+		sb.WriteString("\n\n")
+		if sa.Message.Metadata != nil {
+			writeSourcesSection(&sb, sa.Message.Metadata)
+			writeConfidenceSection(&sb, sa.Message.Metadata)
+		}
+		if i < len(starred)-1 {
+			sb.WriteString("---\n\n")
+		}
+	}
+
+	filename := sanitizeFilename(proj.Name+" key findings") + ".md"
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Write([]byte(sb.String()))
+}
+
 // writeSourcesSection writes the source documents as a bullet list.
 func writeSourcesSection(sb *strings.Builder, meta map[string]interface{}) {
 	// Try footnotes first (richer data)
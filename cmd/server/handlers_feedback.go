@@ -195,14 +195,7 @@ func (s *Server) submitFeedback(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) listFeedback(w http.ResponseWriter, r *http.Request) {
 	// Only the configured admin UID can list feedback.
-	adminUID := os.Getenv("ADMIN_UID")
-	if adminUID == "" {
-		jsonErr(w, "admin access not configured", http.StatusForbidden)
-		return
-	}
-	uid := getUserUID(r)
-	if uid != adminUID {
-		jsonErr(w, "forbidden", http.StatusForbidden)
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -1,19 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"gocognigo/internal/analysis"
 	"gocognigo/internal/chat"
 	"gocognigo/internal/extractor"
 	"gocognigo/internal/indexer"
@@ -67,7 +71,14 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	uploadsDir := s.getProjectStore(r).UploadsDir(projectID)
 	_ = os.MkdirAll(uploadsDir, 0755)
 
+	if err := s.checkUploadQuota(proj, uploadsDir, files); err != nil {
+		jsonErr(w, "Quota exceeded: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
 	var saved []string
+	var rejected []map[string]string
+	var replaced []map[string]interface{}
 	for _, fh := range files {
 		// Only allow PDF and DOCX
 		ext := strings.ToLower(filepath.Ext(fh.Filename))
@@ -79,16 +90,18 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			continue
 		}
-
-		dstPath := filepath.Join(uploadsDir, fh.Filename)
-		dst, err := os.Create(dstPath)
+		version, reason, err := s.saveUpload(r, s.getProjectStore(r), projectID, fh.Filename, src)
+		src.Close()
 		if err != nil {
-			src.Close()
 			continue
 		}
-		_, _ = io.Copy(dst, src)
-		src.Close()
-		dst.Close()
+		if reason != "" {
+			rejected = append(rejected, map[string]string{"file": fh.Filename, "error": reason})
+			continue
+		}
+		if version > 0 {
+			replaced = append(replaced, map[string]interface{}{"file": fh.Filename, "version": version})
+		}
 		saved = append(saved, fh.Filename)
 	}
 
@@ -106,9 +119,71 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	jsonResp(w, map[string]interface{}{
 		"uploaded": saved,
 		"count":    len(saved),
+		"rejected": rejected,
+		"replaced": replaced,
 	})
 }
 
+// saveUpload writes an upload into the project's uploads directory under
+// name, scanning it first when a scanner is configured and keeping the file
+// it replaces as an earlier version. It returns that version's number (0 if
+// nothing was replaced), or the reason the upload was rejected.
+func (s *Server) saveUpload(r *http.Request, store *chat.ProjectStore, projectID, name string, src io.Reader) (version int, reason string, err error) {
+	uploadsDir := store.UploadsDir(projectID)
+
+	// Write to a temp file first so nothing unscanned is ever listed
+	// or ingested under its real name.
+	dstPath := filepath.Join(uploadsDir, name)
+	tmp, err := os.CreateTemp(uploadsDir, ".upload-*")
+	if err != nil {
+		return 0, "", err
+	}
+	sum, err := hashingCopy(tmp, src)
+	tmp.Close()
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, "", err
+	}
+
+	if s.scanner != nil {
+		verdict, err := s.scanner.Scan(r.Context(), tmp.Name(), sum)
+		switch {
+		case err != nil:
+			log.Printf("[Upload] Scan failed for %s: %v", name, err)
+			reason = "could not be scanned for malware; try again later"
+		case verdict.Flagged:
+			log.Printf("[Upload] Rejected %s: %s", name, verdict.Signature)
+			reason = "flagged by malware scan: " + verdict.Signature
+			recordAudit(r, "file.rejected", projectID, name, map[string]string{"signature": verdict.Signature, "sha256": sum})
+		}
+		if reason != "" {
+			os.Remove(tmp.Name())
+			return 0, reason, nil
+		}
+	}
+
+	// Keep the file this replaces as an earlier version
+	if _, err := os.Stat(dstPath); err == nil {
+		v, err := s.archiveVersion(store, projectID, name)
+		if err != nil {
+			log.Printf("[Upload] Failed to archive previous %s: %v", name, err)
+			os.Remove(tmp.Name())
+			return 0, "could not keep the previous version", nil
+		}
+		version = v.Version
+		recordAudit(r, "file.version", projectID, name, map[string]string{"version": strconv.Itoa(v.Version)})
+	}
+
+	if err := os.Rename(tmp.Name(), dstPath); err != nil {
+		os.Remove(tmp.Name())
+		return 0, "", err
+	}
+	return version, "", nil
+}
+
 func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -167,6 +242,8 @@ func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
 		_ = os.MkdirAll(uploadsDir, 0755)
 		_ = os.RemoveAll(bm25Dir)
 		_ = os.Remove(vectorsPath)
+		_ = os.RemoveAll(filepath.Join(s.getProjectStore(r).ProjectDir(req.ProjectID), "summaries"))
+		_ = os.Remove(ingestRecordsPath(s.getProjectStore(r), req.ProjectID))
 
 		sess, _ := s.getProjectStore(r).Get(req.ProjectID)
 		if sess != nil {
@@ -177,6 +254,7 @@ func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
 		}
 
 		s.ingestStatus.reset()
+		recordAudit(r, "files.delete_all", req.ProjectID, "", nil)
 		jsonResp(w, map[string]string{"status": "cleared"})
 
 	default:
@@ -218,6 +296,10 @@ func (s *Server) handleDeleteSingleFile(w http.ResponseWriter, r *http.Request)
 		jsonErr(w, "failed to delete file: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	removeCachedSummary(s.getProjectStore(r), req.ProjectID, clean)
+	removePageImages(s.getProjectStore(r), req.ProjectID, clean)
+	removeDocumentTags(s.getProjectStore(r), req.ProjectID, clean)
+	removeIngestRecord(s.getProjectStore(r), req.ProjectID, clean)
 
 	// Update file count
 	entries, _ := os.ReadDir(uploadsDir)
@@ -234,6 +316,7 @@ func (s *Server) handleDeleteSingleFile(w http.ResponseWriter, r *http.Request)
 
 	// Remove document chunks from the active index (if loaded for this project)
 	chunksRemoved := 0
+	var remaining []analysis.Passage
 	s.mu.Lock()
 	if s.activeProjectID == req.ProjectID && s.activeIndex != nil {
 		chunksRemoved = s.activeIndex.RemoveDocument(clean)
@@ -249,9 +332,17 @@ func (s *Server) handleDeleteSingleFile(w http.ResponseWriter, r *http.Request)
 
 			// Update cache
 			s.indexCache.put(req.ProjectID, &cachedIndex{idx: s.activeIndex, ret: s.activeRetriever})
+			remaining = corpusPassages(s.activeIndex.Chunks, "")
 		}
 	}
 	s.mu.Unlock()
+	if chunksRemoved > 0 {
+		// Rebuilt outside the lock; extraction scans the whole corpus
+		saveGraph(s.getProjectStore(r), req.ProjectID, analysis.BuildGraph(remaining))
+	} else {
+		// Index not loaded: drop the graph so it is rebuilt on next use
+		_ = os.Remove(s.getProjectStore(r).GraphPath(req.ProjectID))
+	}
 
 	sess, _ := s.getProjectStore(r).Get(req.ProjectID)
 	if sess != nil {
@@ -267,6 +358,7 @@ func (s *Server) handleDeleteSingleFile(w http.ResponseWriter, r *http.Request)
 
 	log.Printf("Deleted file %q from project %s: %d chunks removed, %d files remaining",
 		clean, req.ProjectID, chunksRemoved, fileCount)
+	recordAudit(r, "file.delete", req.ProjectID, clean, map[string]string{"chunks_removed": strconv.Itoa(chunksRemoved)})
 
 	jsonResp(w, map[string]interface{}{
 		"status":         "deleted",
@@ -275,6 +367,127 @@ func (s *Server) handleDeleteSingleFile(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// handleReprocessFile re-extracts, re-chunks and re-embeds one uploaded
+// file (POST {project_id, name}), e.g. after installing OCR or replacing a
+// corrupted upload. Its old chunks are removed first; the rest of the
+// project's index is untouched. Progress is reported like any ingestion.
+func (s *Server) handleReprocessFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Name      string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.ProjectID == "" {
+		jsonErr(w, "project_id and name are required", http.StatusBadRequest)
+		return
+	}
+
+	// Prevent path traversal
+	clean := filepath.Base(req.Name)
+	if clean != req.Name || clean == "." || clean == ".." {
+		jsonErr(w, "invalid filename", http.StatusBadRequest)
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(clean))
+	if ext != ".pdf" && ext != ".docx" {
+		jsonErr(w, "only PDF and DOCX files can be processed", http.StatusBadRequest)
+		return
+	}
+
+	store := s.getProjectStore(r)
+	if _, err := store.Get(req.ProjectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(store.UploadsDir(req.ProjectID), clean)); err != nil {
+		jsonErr(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if s.ingestStatus.snapshot().Phase == "processing" {
+		jsonErr(w, "Ingestion already in progress", http.StatusConflict)
+		return
+	}
+	if embedAPIKey(s.projectSettings(r, req.ProjectID)) == "" {
+		jsonErr(w, "No API key configured for the embedding provider. Please open Settings (⚙ icon) and add your API key before processing.", http.StatusBadRequest)
+		return
+	}
+
+	// Without the loaded index, ingestion would rebuild the whole project
+	rw, err := s.getRetrieverForProject(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "Project index is not loaded; open the project and try again", http.StatusConflict)
+		return
+	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	removed := s.removeFromIndex(store, req.ProjectID, rw, clean)
+
+	log.Printf("Reprocessing %q in project %s: %d old chunks removed", clean, req.ProjectID, removed)
+	recordAudit(r, "file.reprocess", req.ProjectID, clean, map[string]string{"chunks_removed": strconv.Itoa(removed)})
+
+	s.startIngestion(w, r, req.ProjectID, []string{clean})
+}
+
+// indexSummaryNodes summarizes each section of a freshly summarized
+// document and embeds the section and document summary nodes into idx, for
+// the hierarchical_summaries setting. Failures are logged: the document's
+// page chunks are indexed either way.
+func (s *Server) indexSummaryNodes(ctx context.Context, idx *indexer.Index, store *chat.ProjectStore, projectID string, summarizer llm.Completer, summary indexer.DocumentSummary, dc []extractor.DocumentChunk) {
+	pages := make([]llm.SummaryPage, 0, len(dc))
+	for _, c := range dc {
+		pages = append(pages, llm.SummaryPage{Number: c.PageNumber, Text: c.Text})
+	}
+	sections, usage, err := llm.SummarizeSections(ctx, summarizer, summary.Document, pages, summary.Sections)
+	if usage != nil {
+		recordTokenUsage(store, projectID, usage)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to summarize sections of %s: %v", summary.Document, err)
+	}
+	nodes := indexer.SummaryNodes(summary, sections, len(dc))
+	if err := idx.EmbedAndIndex(ctx, nodes, nil, 0); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Warning: failed to index summary nodes for %s: %v", summary.Document, err)
+		}
+		return
+	}
+	log.Printf("Indexed %d summary nodes for %s", len(nodes), summary.Document)
+}
+
+// removeFromIndex drops a document's chunks from a project's loaded index,
+// persists the index and makes it the active one (which ingestion then adds
+// to). It returns the number of chunks removed.
+func (s *Server) removeFromIndex(store *chat.ProjectStore, projectID string, rw *retriever_wrapper, name string) int {
+	removed := rw.idx.RemoveDocument(name)
+	if err := rw.idx.SaveVectors(store.VectorsPath(projectID)); err != nil {
+		log.Printf("Warning: failed to re-save vectors after removing %s: %v", name, err)
+	}
+	s.mu.Lock()
+	ret := retriever.NewRetriever(rw.idx)
+	s.activeIndex = rw.idx
+	s.activeRetriever = ret
+	s.activeProjectID = projectID
+	s.indexCache.put(projectID, &cachedIndex{idx: rw.idx, ret: ret})
+	s.mu.Unlock()
+	removeCachedSummary(store, projectID, name)
+
+	if sess, _ := store.Get(projectID); sess != nil {
+		sess.ChunkCount -= removed
+		if sess.ChunkCount < 0 {
+			sess.ChunkCount = 0
+		}
+		_ = store.Update(*sess)
+	}
+	return removed
+}
+
 func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -283,11 +496,16 @@ func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		ProjectID string `json:"project_id"`
+		DryRun    bool   `json:"dry_run,omitempty"` // extract and chunk only; report pages, OCR needs and estimated cost
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
 		jsonErr(w, "project_id is required", http.StatusBadRequest)
 		return
 	}
+	if req.DryRun {
+		s.handleIngestDryRun(w, r, req.ProjectID)
+		return
+	}
 
 	// Don't start if already running
 	snap := s.ingestStatus.snapshot()
@@ -298,8 +516,6 @@ func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 
 	projectID := req.ProjectID
 	uploadsDir := s.getProjectStore(r).UploadsDir(projectID)
-	bm25Dir := s.getProjectStore(r).BM25Dir(projectID)
-	vectorsPath := s.getProjectStore(r).VectorsPath(projectID)
 
 	// Gather files
 	entries, _ := os.ReadDir(uploadsDir)
@@ -319,8 +535,19 @@ func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.startIngestion(w, r, projectID, uploadedFiles)
+}
+
+// startIngestion validates the embedding setup and quota, then ingests
+// files (those not yet indexed) in the background and writes the start
+// response with the run's estimate.
+func (s *Server) startIngestion(w http.ResponseWriter, r *http.Request, projectID string, uploadedFiles []string) {
+	uploadsDir := s.getProjectStore(r).UploadsDir(projectID)
+	bm25Dir := s.getProjectStore(r).BM25Dir(projectID)
+	vectorsPath := s.getProjectStore(r).VectorsPath(projectID)
+
 	// Validate that an embedding API key is configured before starting
-	settings := s.getUserSettings(r)
+	settings := s.projectSettings(r, projectID)
 	embedProvider := settings.EmbedProvider
 	if embedProvider == "" {
 		embedProvider = "openai"
@@ -337,8 +564,29 @@ func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Chunks embedded now wouldn't be comparable with the loaded ones
+	if rw, err := s.getRetrieverForProject(projectID); err == nil {
+		if err := checkEmbedding(rw.idx); err != nil {
+			jsonErr(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
 	// Update session status
 	sess, _ := s.getProjectStore(r).Get(projectID)
+	var piiMode string
+	if sess != nil {
+		if q := s.effectiveQuotas(sess); q.MaxChunks > 0 && sess.ChunkCount >= q.MaxChunks {
+			jsonErr(w, fmt.Sprintf("Quota exceeded: project already has %d of %d allowed chunks", sess.ChunkCount, q.MaxChunks), http.StatusForbidden)
+			return
+		}
+		piiMode = sess.PIIMode
+	}
+
+	// Estimate cost and duration from the files' text layer before spending anything
+	preview := s.previewIngestion(settings, uploadsDir, uploadedFiles, s.indexedDocuments(projectID), piiMode)
+	estimate := s.estimate(settings, preview)
+
 	if sess != nil {
 		sess.Status = "processing"
 		_ = s.getProjectStore(r).Update(*sess)
@@ -352,6 +600,8 @@ func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 	s.ingestStatus.ChunksTotal = 0
 	s.ingestStatus.ChunksDone = 0
 	s.ingestStatus.Error = ""
+	s.ingestStatus.Estimate = estimate
+	s.ingestStatus.ETASeconds = estimate.Seconds
 	s.ingestStatus.mu.Unlock()
 
 	// Create cancellable context for this ingestion run
@@ -365,7 +615,50 @@ func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 	store := s.getProjectStore(r)
 	go s.runIngestion(ctx, store, settings, projectID, uploadsDir, bm25Dir, vectorsPath, uploadedFiles)
 
-	jsonResp(w, map[string]string{"status": "started"})
+	jsonResp(w, map[string]interface{}{"status": "started", "estimate": estimate})
+}
+
+// extractResult is one file's extracted pages.
+type extractResult struct {
+	chunks  []extractor.DocumentChunk
+	err     error
+	file    string
+	elapsed time.Duration
+}
+
+// extractFile extracts the pages of an uploaded file, with OCR as configured.
+func (s *Server) extractFile(settings *SavedSettings, uploadsDir, fname string) extractResult {
+	filePath := filepath.Join(uploadsDir, fname)
+	ext := strings.ToLower(filepath.Ext(fname))
+
+	start := time.Now()
+	log.Printf("Extracting %s...", fname)
+
+	s.mu.RLock()
+	ocrCfg := &extractor.OCRConfig{
+		Provider:      settings.OCRProvider,
+		SarvamKey:     settings.SarvamKey,
+		TesseractLang: settings.TesseractLang,
+		TesseractOk:   s.tesseractOk,
+	}
+	s.mu.RUnlock()
+
+	var docChunks []extractor.DocumentChunk
+	var extractErr error
+	switch ext {
+	case ".pdf":
+		docChunks, extractErr = extractor.ExtractPDF(filePath, ocrCfg)
+	case ".docx":
+		docChunks, extractErr = extractor.ExtractDOCX(filePath)
+	}
+
+	elapsed := time.Since(start)
+	if extractErr != nil {
+		log.Printf("Failed to extract %s after %v: %v", fname, elapsed, extractErr)
+		return extractResult{nil, extractErr, fname, elapsed}
+	}
+	log.Printf("Extracted %s: %d pages in %v", fname, len(docChunks), elapsed)
+	return extractResult{docChunks, nil, fname, elapsed}
 }
 
 func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, settings *SavedSettings, ProjectID, uploadsDir, bm25Dir, vectorsPath string, files []string) {
@@ -374,6 +667,8 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 		s.mu.Lock()
 		s.ingestCancel = nil
 		s.mu.Unlock()
+		// Reload recently used indexes this one pushed out of the cache
+		go s.prewarmIndexes(prewarmCount())
 	}()
 
 	// Try to reuse existing index for incremental ingestion
@@ -429,6 +724,28 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 		return
 	}
 
+	// Report the run, and email the outcome of a large one unless it was
+	// cancelled
+	ingestStart := time.Now()
+	report := &ingestReport{ID: newID(), ProjectID: ProjectID, StartedAt: ingestStart, Settings: runSettings(settings, "")}
+	var reportMu sync.Mutex
+	defer func() {
+		s.ingestStatus.mu.RLock()
+		phase, errMsg := s.ingestStatus.Phase, s.ingestStatus.Error
+		results := append([]FileResult(nil), s.ingestStatus.FileResults...)
+		report.Estimate = s.ingestStatus.Estimate
+		s.ingestStatus.mu.RUnlock()
+		reportMu.Lock()
+		report.finish(phase, errMsg)
+		if err := saveIngestReport(store, report); err != nil {
+			log.Printf("Warning: failed to save ingestion report: %v", err)
+		}
+		reportMu.Unlock()
+		if phase == "done" || phase == "error" {
+			notifyIngestDone(settings, store, ProjectID, phase, errMsg, results, time.Since(ingestStart))
+		}
+	}()
+
 	log.Printf("Incremental ingestion: %d new files, %d already indexed", len(newFiles), len(files)-len(newFiles))
 
 	// Create fresh index only if we don't have one
@@ -446,7 +763,7 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 		_ = os.RemoveAll(bm25Dir)
 
 		var err error
-		idx, err = indexer.NewIndex(settings.EmbedProvider, settings.OpenAIKey, settings.EmbedModel, bm25Dir)
+		idx, err = newProjectIndex(settings, bm25Dir)
 		if err != nil {
 			s.ingestStatus.mu.Lock()
 			s.ingestStatus.Phase = "error"
@@ -456,6 +773,25 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 		}
 	}
 
+	// A cached index keeps the chunk shape of the settings it was opened with
+	idx.ChunkWords, idx.ChunkOverlap = settings.ChunkWords, settings.ChunkOverlap
+
+	// Chunk quota: files that would push the project over it are skipped
+	var maxChunks int
+	var piiMode string
+	var ocrCorrect bool
+	if proj, err := store.Get(ProjectID); err == nil {
+		maxChunks = s.effectiveQuotas(proj).MaxChunks
+		piiMode = proj.PIIMode
+		ocrCorrect = proj.OCRCorrect
+		idx.Fuzziness = proj.Fuzziness
+		idx.MinOCRConfidence = proj.MinOCRConfidence
+	}
+	report.Settings.PIIMode = piiMode
+	report.Settings.OCRCorrect = ocrCorrect
+	baseChunks := len(idx.Chunks)
+	live := s.newLiveIndex(ProjectID, idx)
+
 	// Update ingest status for new files only
 	s.ingestStatus.mu.Lock()
 	s.ingestStatus.FilesTotal = len(newFiles)
@@ -463,72 +799,53 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 	s.ingestStatus.mu.Unlock()
 
 	// ===== STREAMED PIPELINE =====
-	type extractResult struct {
-		chunks []extractor.DocumentChunk
-		err    error
-		file   string
-	}
 
 	var (
 		filesDone   int32
 		chunksTotal int64
 	)
 
+	// Workers take files from a queue that can be paused and reordered
+	ctl := newIngestControl(newFiles, s.ingestStatus)
+	s.mu.Lock()
+	s.ingestCtl = ctl
+	s.mu.Unlock()
+	idx.Gate = ctl.wait
+	defer func() {
+		idx.Gate = nil
+		s.mu.Lock()
+		s.ingestCtl = nil
+		s.mu.Unlock()
+		s.ingestStatus.mu.Lock()
+		s.ingestStatus.Paused = false
+		s.ingestStatus.Queued = nil
+		s.ingestStatus.mu.Unlock()
+	}()
+
 	resultsCh := make(chan extractResult, len(newFiles))
-	extractSem := make(chan struct{}, 4)
+	workers := settings.extractWorkers()
+	if workers > len(newFiles) {
+		workers = len(newFiles)
+	}
 	var extractWg sync.WaitGroup
 
-	for _, filename := range newFiles {
+	for w := 0; w < workers; w++ {
 		extractWg.Add(1)
-		go func(fname string) {
+		go func() {
 			defer extractWg.Done()
+			for {
+				fname, ok := ctl.next(ctx)
+				if !ok {
+					return
+				}
+				resultsCh <- s.extractFile(settings, uploadsDir, fname)
 
-			select {
-			case <-ctx.Done():
-				resultsCh <- extractResult{nil, ctx.Err(), fname}
-				return
-			case extractSem <- struct{}{}:
-			}
-			defer func() { <-extractSem }()
-
-			filePath := filepath.Join(uploadsDir, fname)
-			ext := strings.ToLower(filepath.Ext(fname))
-
-			start := time.Now()
-			log.Printf("Extracting %s...", fname)
-
-			s.mu.RLock()
-			ocrCfg := &extractor.OCRConfig{
-				Provider:      settings.OCRProvider,
-				SarvamKey:     settings.SarvamKey,
-				TesseractLang: settings.TesseractLang,
-				TesseractOk:   s.tesseractOk,
-			}
-			s.mu.RUnlock()
-
-			var docChunks []extractor.DocumentChunk
-			var extractErr error
-			switch ext {
-			case ".pdf":
-				docChunks, extractErr = extractor.ExtractPDF(filePath, ocrCfg)
-			case ".docx":
-				docChunks, extractErr = extractor.ExtractDOCX(filePath)
-			}
-
-			elapsed := time.Since(start)
-			if extractErr != nil {
-				log.Printf("Failed to extract %s after %v: %v", fname, elapsed, extractErr)
-				resultsCh <- extractResult{nil, extractErr, fname}
-			} else {
-				log.Printf("Extracted %s: %d pages in %v", fname, len(docChunks), elapsed)
-				resultsCh <- extractResult{docChunks, nil, fname}
+				newDone := int(atomic.AddInt32(&filesDone, 1))
+				s.ingestStatus.mu.Lock()
+				s.ingestStatus.FilesDone = newDone
+				s.ingestStatus.mu.Unlock()
 			}
-
-			newDone := int(atomic.AddInt32(&filesDone, 1))
-			s.ingestStatus.mu.Lock()
-			s.ingestStatus.FilesDone = newDone
-			s.ingestStatus.mu.Unlock()
-		}(filename)
+		}()
 	}
 
 	go func() {
@@ -537,8 +854,9 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 	}()
 
 	s.mu.RLock()
-	openAIKey := settings.OpenAIKey
+	summarizer, summarize := summaryCompleter(settings)
 	s.mu.RUnlock()
+	hierarchical := settings.HierarchicalSummaries
 
 	var fileResults []FileResult
 	var fileResultsMu sync.Mutex
@@ -548,6 +866,23 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 	var firstErr error
 	var errOnce sync.Once
 	var anyFileOk bool
+	var quotaSkipped bool
+	var embedStart time.Time // when the first file went to the embedder
+	var embedOnce sync.Once
+
+	// reportFile adds a file's outcome to the report, returning its index
+	reportFile := func(fr FileResult, res extractResult, chunks []indexer.Chunk) int {
+		rep := fileReport{FileResult: fr, Pages: len(res.chunks), ExtractSeconds: res.elapsed.Seconds(), Tokens: indexer.EstimateEmbeddingTokens(chunks)}
+		for _, p := range res.chunks {
+			if p.OCR {
+				rep.OCRPages++
+			}
+		}
+		reportMu.Lock()
+		defer reportMu.Unlock()
+		report.Files = append(report.Files, rep)
+		return len(report.Files) - 1
+	}
 
 	for res := range resultsCh {
 		if ctx.Err() != nil {
@@ -559,21 +894,55 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 			if res.err != nil {
 				errMsg = res.err.Error()
 			}
-			fileResultsMu.Lock()
-			fileResults = append(fileResults, FileResult{
+			fr := FileResult{
 				Name:   res.file,
 				Status: "failed",
 				Error:  errMsg,
-			})
+			}
+			fileResultsMu.Lock()
+			fileResults = append(fileResults, fr)
 			fileResultsMu.Unlock()
+			reportFile(fr, res, nil)
+			recordIngest(store, ProjectID, res.file, nil, 0, errMsg)
 			continue
 		}
 
-		anyFileOk = true
 		docChunks := res.chunks
 		fileName := res.file
 
-		if openAIKey != "" {
+		if ocrCorrect {
+			correctOCR(docChunks)
+		}
+		if piiMode == piiMask {
+			maskPII(docChunks) // before chunking, so neither the index nor the summary sees them
+		}
+		fileChunks := idx.ChunkPages(docChunks)
+		if piiMode == piiTag {
+			tagPII(fileChunks)
+		}
+		numChunks := len(fileChunks)
+		log.Printf("Chunked %s: %d pages → %d chunks", fileName, len(docChunks), numChunks)
+
+		if maxChunks > 0 && baseChunks+int(atomic.LoadInt64(&chunksTotal))+numChunks > maxChunks {
+			log.Printf("Skipping %s: %d chunks would exceed project quota of %d", fileName, numChunks, maxChunks)
+			errMsg := fmt.Sprintf("chunk quota exceeded (%d chunks, limit %d per project)", numChunks, maxChunks)
+			fr := FileResult{
+				Name:   fileName,
+				Status: "failed",
+				Error:  errMsg,
+			}
+			fileResultsMu.Lock()
+			fileResults = append(fileResults, fr)
+			fileResultsMu.Unlock()
+			reportFile(fr, res, nil)
+			recordIngest(store, ProjectID, fileName, docChunks, 0, errMsg)
+			quotaSkipped = true
+			continue
+		}
+
+		anyFileOk = true
+
+		if summarize {
 			summaryWg.Add(1)
 			go func(dc []extractor.DocumentChunk, fname string) {
 				defer summaryWg.Done()
@@ -581,20 +950,19 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 				for _, c := range dc {
 					pages = append(pages, c.Text)
 				}
-				summary, err := llm.GenerateDocSummary(ctx, openAIKey, fname, pages, len(pages))
+				summary, err := llm.GenerateDocSummary(ctx, summarizer, fname, pages, len(pages))
 				if err != nil {
 					log.Printf("Warning: failed to generate summary for %s: %v", fname, err)
 					return
 				}
 				idx.AddDocSummary(*summary)
 				log.Printf("Generated summary for %s: %s (%s)", fname, summary.Title, summary.DocType)
+				if hierarchical {
+					s.indexSummaryNodes(ctx, idx, store, ProjectID, summarizer, *summary, dc)
+				}
 			}(docChunks, fileName)
 		}
 
-		fileChunks := idx.ChunkPages(docChunks)
-		numChunks := len(fileChunks)
-		log.Printf("Chunked %s: %d pages → %d chunks", fileName, len(docChunks), numChunks)
-
 		// Persist chunks to disk so embedding can be retried if it fails
 		chunksDir := store.ChunksDir(ProjectID)
 		_ = os.MkdirAll(chunksDir, 0755)
@@ -608,22 +976,35 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 		s.ingestStatus.ChunksTotal = int(atomic.LoadInt64(&chunksTotal))
 		s.ingestStatus.mu.Unlock()
 
-		fileResultsMu.Lock()
-		fileResults = append(fileResults, FileResult{
+		fr := FileResult{
 			Name:   fileName,
 			Status: "ok",
 			Chunks: numChunks,
-		})
+		}
+		fileResultsMu.Lock()
+		fileResults = append(fileResults, fr)
 		fileResultsMu.Unlock()
+		reportIdx := reportFile(fr, res, fileChunks)
+		recordIngest(store, ProjectID, fileName, docChunks, numChunks, "")
 
+		embedOnce.Do(func() { embedStart = time.Now() })
 		embedWg.Add(1)
 		go func(chunks []indexer.Chunk, fname string) {
 			defer embedWg.Done()
+			fileStart := time.Now()
 
 			embedProgress := func(total, done int) {
 				s.ingestStatus.mu.Lock()
 				s.ingestStatus.ChunksTotal = int(atomic.LoadInt64(&chunksTotal))
-				s.ingestStatus.ChunksDone = len(idx.Chunks)
+				s.ingestStatus.ChunksDone = idx.ChunkCount()
+				if embedded := s.ingestStatus.ChunksDone - baseChunks; embedded > 0 {
+					expected := s.ingestStatus.ChunksTotal - baseChunks
+					if est := s.ingestStatus.Estimate; est != nil && est.Chunks > expected {
+						expected = est.Chunks
+					}
+					rate := float64(embedded) / time.Since(embedStart).Seconds()
+					s.ingestStatus.ETASeconds = math.Max(0, float64(expected-embedded)/rate)
+				}
 				s.ingestStatus.mu.Unlock()
 			}
 
@@ -631,12 +1012,25 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 				if ctx.Err() == nil {
 					errOnce.Do(func() { firstErr = err })
 					log.Printf("Embedding error for %s: %v", fname, err)
+					recordIngestError(store, ProjectID, fname, fmt.Sprintf("embedding failed: %v", err))
+					reportMu.Lock()
+					report.Files[reportIdx].Status = "failed"
+					report.Files[reportIdx].Error = fmt.Sprintf("embedding failed: %v", err)
+					reportMu.Unlock()
 				}
+				return
 			}
+			reportMu.Lock()
+			report.Files[reportIdx].EmbedSeconds = time.Since(fileStart).Seconds()
+			reportMu.Unlock()
+			live.refresh()
 		}(fileChunks, fileName)
 	}
 
 	embedWg.Wait()
+	if firstErr == nil && ctx.Err() == nil && !embedStart.IsZero() {
+		s.throughput.observe(indexer.EmbedModelName(settings.EmbedProvider, settings.EmbedModel), len(idx.Chunks)-baseChunks, time.Since(embedStart))
+	}
 	summaryWg.Wait()
 
 	s.ingestStatus.mu.Lock()
@@ -658,6 +1052,9 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 		s.ingestStatus.mu.Lock()
 		s.ingestStatus.Phase = "error"
 		s.ingestStatus.Error = "No text could be extracted from any uploaded file. If your PDFs are scanned images, configure an OCR provider in Settings (Tesseract or Sarvam Vision)."
+		if quotaSkipped {
+			s.ingestStatus.Error = fmt.Sprintf("Quota exceeded: no file fits within the project's limit of %d chunks.", maxChunks)
+		}
 		s.ingestStatus.mu.Unlock()
 		_ = idx.Close()
 		return
@@ -693,6 +1090,7 @@ func (s *Server) runIngestion(ctx context.Context, store *chat.ProjectStore, set
 	if err := idx.SaveVectors(vectorsPath); err != nil {
 		log.Printf("Failed to save vectors: %v", err)
 	}
+	saveProjectGraph(store, ProjectID, idx.Chunks)
 
 	s.ingestStatus.mu.Lock()
 	s.ingestStatus.Phase = "done"
@@ -798,6 +1196,7 @@ func (s *Server) handleCancelIngest(w http.ResponseWriter, r *http.Request) {
 	s.ingestStatus.RetryProjectID = ""
 	s.ingestStatus.mu.Unlock()
 
+	recordAudit(r, "ingest.cancel", req.ProjectID, "", nil)
 	jsonResp(w, map[string]string{"status": "cancelled"})
 }
 
@@ -861,6 +1260,12 @@ func (s *Server) handleRetryIngest(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	idx := s.activeIndex
 	s.mu.RUnlock()
+	if idx != nil {
+		if err := checkEmbedding(idx); err != nil {
+			jsonErr(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
 
 	var unembed []indexer.Chunk
 	if idx != nil {
@@ -918,7 +1323,7 @@ func (s *Server) handleRetryIngest(w http.ResponseWriter, r *http.Request) {
 
 	// Run embedding in background
 	store := s.getProjectStore(r)
-	settings := s.getUserSettings(r)
+	settings := s.projectSettings(r, projectID)
 	go s.runRetryEmbedding(ctx, store, settings, projectID, vectorsPath, idx, unembed)
 
 	jsonResp(w, map[string]string{"status": "retrying"})
@@ -930,6 +1335,8 @@ func (s *Server) runRetryEmbedding(ctx context.Context, store *chat.ProjectStore
 		s.mu.Lock()
 		s.ingestCancel = nil
 		s.mu.Unlock()
+		// Reload recently used indexes this one pushed out of the cache
+		go s.prewarmIndexes(prewarmCount())
 	}()
 
 	bm25Dir := store.BM25Dir(projectID)
@@ -938,7 +1345,7 @@ func (s *Server) runRetryEmbedding(ctx context.Context, store *chat.ProjectStore
 	if idx == nil {
 		_ = os.RemoveAll(bm25Dir)
 		var err error
-		idx, err = indexer.NewIndex(settings.EmbedProvider, settings.OpenAIKey, settings.EmbedModel, bm25Dir)
+		idx, err = newProjectIndex(settings, bm25Dir)
 		if err != nil {
 			s.ingestStatus.mu.Lock()
 			s.ingestStatus.Phase = "error"
@@ -947,6 +1354,7 @@ func (s *Server) runRetryEmbedding(ctx context.Context, store *chat.ProjectStore
 			return
 		}
 	}
+	applyOCRSettings(store, projectID, idx)
 
 	// Embed with progress
 	embedProgress := func(total, done int) {
@@ -987,6 +1395,15 @@ func (s *Server) runRetryEmbedding(ctx context.Context, store *chat.ProjectStore
 	if err := idx.SaveVectors(vectorsPath); err != nil {
 		log.Printf("Failed to save vectors: %v", err)
 	}
+	idx.SetReuse(nil) // a re-chunk's old embeddings are no longer needed
+	saveProjectGraph(store, projectID, idx.Chunks)
+	retried := map[string]bool{}
+	for _, c := range chunks {
+		if !retried[c.Document] {
+			retried[c.Document] = true
+			recordIngestError(store, projectID, c.Document, "")
+		}
+	}
 
 	s.ingestStatus.mu.Lock()
 	s.ingestStatus.Phase = "done"
@@ -1060,3 +1477,114 @@ func (s *Server) handleFileView(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", clean))
 	http.ServeFile(w, r, filePath)
 }
+
+// uploadContentTypes maps the upload formats to their media types;
+// DetectContentType would call a DOCX a ZIP.
+var uploadContentTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+}
+
+// handleFileRaw serves an uploaded document as stored, with Range support
+// so PDF viewers can fetch pages on demand. page=N redirects to the same
+// file with a #page=N fragment, which browser PDF viewers open at that page;
+// download=1 asks for an attachment instead of inline display. Naming an
+// answer (conversation_id&message_id, or batch_id&index) serves a PDF with
+// the passages of the pages the answer cites from it highlighted.
+func (s *Server) handleFileRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	projectID := q.Get("project_id")
+	name := q.Get("name")
+	if projectID == "" || name == "" {
+		jsonErr(w, "project_id and name are required", http.StatusBadRequest)
+		return
+	}
+
+	// Prevent path traversal
+	clean := filepath.Base(name)
+	if clean != name || clean == "." || clean == ".." || strings.HasPrefix(clean, ".") {
+		jsonErr(w, "invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	// The project store is per user, so this is also the access check.
+	store := s.getProjectStore(r)
+	if _, err := store.Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	if page := q.Get("page"); page != "" {
+		n, err := strconv.Atoi(page)
+		if err != nil || n < 1 {
+			jsonErr(w, "page must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		q.Del("page")
+		target := r.URL.Path + "?" + q.Encode()
+		if strings.EqualFold(filepath.Ext(clean), ".pdf") {
+			target += fmt.Sprintf("#page=%d", n)
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(store.UploadsDir(projectID), clean))
+	if err != nil {
+		jsonErr(w, "file not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		jsonErr(w, "file not found", http.StatusNotFound)
+		return
+	}
+
+	answer, err := s.citingAnswer(store, projectID, q)
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if answer != nil && strings.EqualFold(filepath.Ext(clean), ".pdf") {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			jsonErr(w, "failed to read file", http.StatusInternalServerError)
+			return
+		}
+		highlighted, err := highlightCitations(data, clean, answer)
+		if err != nil {
+			log.Printf("[Files] Couldn't highlight citations in %s: %v", clean, err)
+		}
+		w.Header().Set("Content-Type", uploadContentTypes[".pdf"])
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", clean))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, clean, info.ModTime(), bytes.NewReader(highlighted))
+		return
+	}
+
+	ctype, ok := uploadContentTypes[strings.ToLower(filepath.Ext(clean))]
+	if !ok {
+		var head [512]byte
+		n, _ := io.ReadFull(f, head[:])
+		ctype = http.DetectContentType(head[:n])
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			jsonErr(w, "failed to read file", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	disposition := "inline"
+	if q.Get("download") == "1" || q.Get("download") == "true" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, clean))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, clean, info.ModTime(), f)
+}
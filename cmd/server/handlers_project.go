@@ -45,6 +45,9 @@ func (s *Server) handleActivateProject(w http.ResponseWriter, r *http.Request) {
 		jsonErr(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if err := s.getProjectStore(r).MarkUsed(sess.ID); err != nil {
+		log.Printf("Warning: could not record use of project %s: %v", sess.ID, err)
+	}
 
 	s.mu.Lock()
 	// If re-activating the same project with a loaded index, skip clearing
@@ -74,7 +77,7 @@ func (s *Server) handleActivateProject(w http.ResponseWriter, r *http.Request) {
 			s.mu.Unlock()
 			// Load in background
 			store := s.getProjectStore(r)
-			settings := s.getUserSettings(r)
+			settings := s.projectSettings(r, sess.ID)
 			go func(projectID string) {
 				if err := s.loadChatIndexes(store, settings, projectID); err != nil {
 					log.Printf("Warning: could not load indexes for project %s: %v", projectID, err)
@@ -123,13 +126,25 @@ func (s *Server) handleDeleteProject(w http.ResponseWriter, r *http.Request) {
 	}
 	// Remove from cache
 	s.indexCache.delete(req.ProjectID)
+	delete(s.projectKeys, req.ProjectID)
 	s.mu.Unlock()
 
-	if err := s.getProjectStore(r).Delete(req.ProjectID); err != nil {
+	// Listed rather than looked up, which would fetch its files from storage
+	store := s.getProjectStore(r)
+	var name string
+	for _, p := range store.List() {
+		if p.ID == req.ProjectID {
+			name = p.Name
+		}
+	}
+	if err := store.Delete(req.ProjectID); err != nil {
 		jsonErr(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	forgetStoredDir(store.ProjectDir(req.ProjectID))
 
+	deleteProjectSchedules(req.ProjectID)
+	recordAudit(r, "project.delete", req.ProjectID, name, nil)
 	jsonResp(w, map[string]string{"status": "deleted"})
 }
 
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -37,6 +43,302 @@ func (s *Server) getRetrieverForProject(projectID string) (*retriever_wrapper, e
 type retriever_wrapper struct {
 	ret *retriever.Retriever
 	idx *indexer.Index
+
+	// partial is set when ret searches only the part of the index loaded
+	// so far, loadedPercent of it (see getQueryRetriever).
+	partial       bool
+	loadedPercent int
+}
+
+// checkEmbedding refuses a project index whose vectors were embedded with
+// other models than its queries, and new chunks, would be (see
+// storedEmbeddingSettings), saying how to fix it.
+func checkEmbedding(idx *indexer.Index) error {
+	if err := idx.CheckEmbedding(); err != nil {
+		return fmt.Errorf("The project's index doesn't match the embedding settings: %v. Switch the embedding settings back, or re-embed the project with the current ones (POST /api/index/reembed)", err)
+	}
+	return nil
+}
+
+// defaultTopK is how many deduplicated chunks are retrieved where the query
+// type doesn't decide it (see llm.StrategyFor).
+const defaultTopK = 20
+
+// chatHistory converts stored messages into LLM conversation history,
+// leaving out messages superseded by an edit.
+func chatHistory(msgs []chat.Message) []llm.ChatMessage {
+	var history []llm.ChatMessage
+	for _, m := range msgs {
+		if m.Superseded() {
+			continue
+		}
+		history = append(history, llm.ChatMessage{Role: m.Role, Content: m.Content})
+	}
+	return history
+}
+
+// answerMetadata is the answer data stored with an assistant message.
+func answerMetadata(answer *llm.Answer, elapsed float64, provider, model string) map[string]interface{} {
+	meta := map[string]interface{}{
+		"thinking":          answer.Thinking,
+		"documents":         answer.Documents,
+		"pages":             answer.Pages,
+		"footnotes":         answer.Footnotes,
+		"confidence":        answer.Confidence,
+		"confidence_reason": answer.ConfidenceReason,
+		"number_checks":     answer.NumberChecks,
+		"time_seconds":      elapsed,
+		"provider":          provider,
+		"model":             model,
+	}
+	if len(answer.Data) > 0 {
+		meta["data"] = answer.Data
+		meta["schema_errors"] = answer.SchemaErrors
+	}
+	if len(answer.ToolSteps) > 0 {
+		meta["tool_steps"] = answer.ToolSteps
+	}
+	if answer.Verification != nil {
+		meta["verification"] = answer.Verification
+	}
+	return meta
+}
+
+// maxToolSteps is the most tool-calling turns a query may ask for.
+const maxToolSteps = 10
+
+// queryTools wraps client so its answers may call the named tools:
+// search_again retrieves from the project's index, calculator does
+// arithmetic.
+func queryTools(client llm.Provider, rw *retriever_wrapper, names []string, steps int) (llm.Provider, error) {
+	tp, ok := client.(llm.ToolProvider)
+	if !ok {
+		return nil, fmt.Errorf("the selected provider does not support tool calling")
+	}
+	if steps > maxToolSteps {
+		steps = maxToolSteps
+	}
+	var tools []llm.Tool
+	for _, name := range names {
+		switch name {
+		case "search_again":
+			tools = append(tools, llm.SearchTool(func(ctx context.Context, query string) ([]retriever.Result, error) {
+				return rw.ret.Search(ctx, query, defaultTopK)
+			}))
+		case "calculator":
+			tools = append(tools, llm.CalculatorTool())
+		default:
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+	}
+	return llm.WithTools(tp, tools, steps), nil
+}
+
+// queryResult is the outcome of answerWithHistory.
+type queryResult struct {
+	answer           *llm.Answer
+	enhancedQuestion string // the question as rewritten for retrieval
+	results          []retriever.Result
+	translations     []sourceTranslation
+	routed           []string // documents the router restricted retrieval to
+	queryType        string   // see llm.ClassifyQuery
+}
+
+// answerOptions are the per-request choices of answerWithHistory.
+type answerOptions struct {
+	schema    *llm.Schema // structured output, if set
+	language  string      // the answer language, if set
+	translate bool        // translate retrieved snippets into language
+	redact    bool        // mask personal identifiers in the answer
+	filters   retriever.Filters
+	bySection bool           // retrieve whole sections rather than pages
+	queryType string         // overrides the classified query type, if set
+	verifier  *llm.Completer // checks the answer against its sources, if set
+}
+
+// search retrieves topK results by page, or by section when bySection.
+func search(ctx context.Context, ret *retriever.Retriever, query string, topK int, f retriever.Filters, bySection bool) ([]retriever.Result, error) {
+	if bySection {
+		return ret.SearchSections(ctx, query, topK, f)
+	}
+	return ret.SearchFiltered(ctx, query, topK, f)
+}
+
+// queryStrategy classifies question unless the request named its query
+// type, and returns the type with its retrieval strategy.
+func queryStrategy(question, queryType string) (string, llm.QueryStrategy) {
+	if queryType == "" {
+		queryType = llm.ClassifyQuery(question)
+	}
+	return queryType, llm.StrategyFor(queryType)
+}
+
+// validQueryType reports whether a requested query type is known.
+func validQueryType(t string) bool {
+	if t == "" {
+		return true
+	}
+	for _, known := range llm.QueryTypes() {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// withStrategyPrompt adds the query type's answering instructions to the
+// custom system prompt.
+func withStrategyPrompt(sysPrompt string, st llm.QueryStrategy) string {
+	if st.Prompt == "" {
+		return sysPrompt
+	}
+	if sysPrompt != "" {
+		sysPrompt += "\n\n"
+	}
+	return sysPrompt + st.Prompt
+}
+
+// validGranularity reports whether a query's granularity is known.
+func validGranularity(g string) bool {
+	return g == "" || g == "page" || g == "section"
+}
+
+// withDocTypePrompt adds the instructions for the retrieved documents'
+// types (see llm.DocTypeGuidance) to the custom system prompt.
+func withDocTypePrompt(sysPrompt string, results []retriever.Result, summaries []indexer.DocumentSummary, overrides map[string]string) string {
+	guidance := llm.DocTypeGuidance(results, summaries, overrides)
+	if guidance == "" {
+		return sysPrompt
+	}
+	if sysPrompt != "" {
+		sysPrompt += "\n\n"
+	}
+	return sysPrompt + guidance
+}
+
+// sourceTranslation is a retrieved snippet translated into the answer
+// language.
+type sourceTranslation struct {
+	Document    string `json:"document"`
+	Page        int    `json:"page"`
+	Translation string `json:"translation"`
+}
+
+// applyLanguage adds the answer-language instruction to sysPrompt and, if
+// translate is set, adds translations of the retrieved snippets to results.
+// A failed translation is logged and the originals are used.
+func (s *Server) applyLanguage(ctx context.Context, r *http.Request, projectID, lang string, translate bool, results []retriever.Result, sysPrompt string) ([]retriever.Result, []sourceTranslation, string) {
+	if lang == "" {
+		return results, nil, sysPrompt
+	}
+	if sysPrompt != "" {
+		sysPrompt += "\n\n"
+	}
+	sysPrompt += llm.LanguageInstruction(lang)
+	if !translate {
+		return results, nil, sysPrompt
+	}
+
+	// Translations run on the same cheap model as summaries
+	translator, _ := summaryCompleter(s.projectSettings(r, projectID))
+	translations, usage, err := llm.TranslateResults(ctx, translator, results, lang)
+	if usage != nil {
+		recordTokenUsage(s.getProjectStore(r), projectID, usage)
+	}
+	if err != nil {
+		log.Printf("Warning: translating sources into %s: %v", lang, err)
+		return results, nil, sysPrompt
+	}
+	var out []sourceTranslation
+	for i, t := range translations {
+		if t != "" {
+			out = append(out, sourceTranslation{Document: results[i].Document, Page: results[i].PageNumber, Translation: t})
+		}
+	}
+	return llm.WithTranslations(results, translations, lang), out, sysPrompt
+}
+
+// answerWithHistory runs the non-streaming query pipeline: rewrite the
+// question using the conversation history, classify it, retrieve topK
+// chunks (0 lets the query type decide) and answer with the project's
+// prompts, in opts.language and as structured data when opts.schema is
+// set. The answer always carries Usage, estimated if the provider didn't
+// report it.
+func (s *Server) answerWithHistory(ctx context.Context, r *http.Request, rw *retriever_wrapper, client llm.Provider,
+	question string, history []llm.ChatMessage, topK int, proj *chat.Project, opts answerOptions) (*queryResult, error) {
+	// Enhance the query using history + document context
+	enhancedQuestion := question
+	if len(history) > 0 {
+		if enhanced, err := llm.EnhanceQuery(ctx, s.projectSettings(r, proj.ID).OpenAIKey, question, history, rw.ret.DocSummaries); err == nil && enhanced != "" {
+			enhancedQuestion = enhanced
+		}
+	}
+
+	queryType, strategy := queryStrategy(enhancedQuestion, opts.queryType)
+	if topK <= 0 {
+		topK = s.projectSettings(r, proj.ID).TopK
+	}
+	if topK <= 0 {
+		topK = strategy.TopK
+	}
+	filters, routed := s.routeQuestion(ctx, r, proj.ID, rw, enhancedQuestion, opts.filters)
+	results, err := search(ctx, rw.ret, enhancedQuestion, topK, filters, opts.bySection)
+	if err != nil {
+		return nil, fmt.Errorf("Retrieval error: %v", err)
+	}
+	summaries := strategy.Summaries(results, rw.ret.DocSummaries)
+
+	customSysPrompt := withDefinitions(rw.ret, question+"\n"+enhancedQuestion, proj.SystemPrompt)
+	customSysPrompt = withDocTypePrompt(customSysPrompt, results, rw.ret.DocSummaries, s.projectSettings(r, proj.ID).DocTypePrompts)
+	customSysPrompt = withStrategyPrompt(customSysPrompt, strategy)
+	var translations []sourceTranslation
+	results, translations, customSysPrompt = s.applyLanguage(ctx, r, proj.ID, opts.language, opts.translate, results, customSysPrompt)
+	var answer *llm.Answer
+	if opts.schema != nil {
+		answer, err = llm.AnswerStructured(ctx, client, opts.schema, question, results, summaries, history, customSysPrompt, proj.BasePrompt)
+	} else {
+		answer, err = client.AnswerQuestion(ctx, question, results, summaries, history, customSysPrompt, proj.BasePrompt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LLM error: %w", err)
+	}
+	if answer.Usage == nil {
+		answer.Usage = llm.EstimateUsage(question+llm.FormatContext(results, summaries), answer.Answer)
+	}
+	llm.RepairCitations(answer, results, summaries)
+	verifyAnswerNumbers(answer, results, rw.ret.Chunks)
+	if opts.verifier != nil {
+		s.verifyAnswer(ctx, r, proj.ID, *opts.verifier, question, answer, results)
+	}
+	if opts.redact || proj.RedactAnswers {
+		redactAnswer(answer)
+		redactTranslations(translations)
+	}
+	return &queryResult{answer: answer, enhancedQuestion: enhancedQuestion, results: results, translations: translations, routed: routed, queryType: queryType}, nil
+}
+
+// retryAfter reports whether err is a provider rate limit and, if it said,
+// how many whole seconds to wait before asking again.
+func retryAfter(err error) (secs int, limited bool) {
+	var rl *llm.RateLimitError
+	if !errors.As(err, &rl) {
+		return 0, false
+	}
+	return int(math.Ceil(rl.RetryAfter.Seconds())), true
+}
+
+// answerErr reports a failed answerWithHistory: 429 with Retry-After when
+// the provider is rate-limiting us, so clients back off, else 500.
+func answerErr(w http.ResponseWriter, err error) {
+	secs, limited := retryAfter(err)
+	if !limited {
+		jsonErr(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if secs > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+	}
+	jsonErr(w, err.Error(), http.StatusTooManyRequests)
 }
 
 func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
@@ -55,18 +357,81 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 		jsonErr(w, "project_id is required", http.StatusBadRequest)
 		return
 	}
+	var schema *llm.Schema
+	if len(req.Schema) > 0 {
+		var err error
+		if schema, err = llm.ParseSchema(req.Schema); err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	proj := s.projectForQuery(w, r, req.ProjectID)
+	if proj == nil {
+		return
+	}
+	filters, err := tagFilters(proj, req.Tags)
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validGranularity(req.Granularity) {
+		jsonErr(w, `granularity must be "page" or "section"`, http.StatusBadRequest)
+		return
+	}
+	if !validQueryType(req.QueryType) {
+		jsonErr(w, "query_type must be one of: "+strings.Join(llm.QueryTypes(), ", "), http.StatusBadRequest)
+		return
+	}
+	if req.TopK < 0 || req.TopK > maxTopK {
+		jsonErr(w, fmt.Sprintf("top_k must be between 0 and %d", maxTopK), http.StatusBadRequest)
+		return
+	}
+	var verifier *llm.Completer
+	if req.Verify {
+		if verifier, err = verifyCompleter(s.projectSettings(r, req.ProjectID), req.Provider, req.VerifyProvider, req.VerifyModel); err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	rw, err := s.getRetrieverForProject(req.ProjectID)
+	rw, err := s.getQueryRetriever(req.ProjectID)
 	if err != nil {
 		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
 		return
 	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
+	var asOf []asOfDocument
+	if req.AsOf != "" {
+		snap, set, release, err := s.retrieverAsOf(s.getProjectStore(r), req.ProjectID, rw, req.AsOf)
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer release()
+		rw, asOf = snap, set
+	}
 
-	llmClient, err := s.getProvider(s.getUserSettings(r), req.Provider, req.Model)
+	llmClient, err := s.getProvider(s.projectSettings(r, req.ProjectID), req.Provider, req.Model)
 	if err != nil {
 		jsonErr(w, fmt.Sprintf("Provider error: %v", err), http.StatusBadRequest)
 		return
 	}
+	reasoning, err := llm.ParseReasoning(req.Reasoning)
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	llmClient = llm.WithReasoning(llmClient, reasoning)
+	if len(req.Tools) > 0 {
+		if llmClient, err = queryTools(llmClient, rw, req.Tools, req.MaxToolSteps); err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	ctx := r.Context()
 	start := time.Now()
@@ -75,41 +440,22 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 	var history []llm.ChatMessage
 	if req.ConversationID != "" {
 		if msgs, err := s.getProjectStore(r).LoadMessages(req.ProjectID, req.ConversationID); err == nil {
-			for _, m := range msgs {
-				history = append(history, llm.ChatMessage{Role: m.Role, Content: m.Content})
-			}
+			history = chatHistory(msgs)
 		}
 	}
 
-	// Enhance the query using history + document context
-	enhancedQuestion := req.Question
-	if len(history) > 0 {
-		if enhanced, err := llm.EnhanceQuery(ctx, s.getUserSettings(r).OpenAIKey, req.Question, history, rw.ret.DocSummaries); err == nil && enhanced != "" {
-			enhancedQuestion = enhanced
-		}
-	}
-
-	results, err := rw.ret.Search(ctx, enhancedQuestion, 20)
-	if err != nil {
-		jsonErr(w, fmt.Sprintf("Retrieval error: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	// Look up project's custom system prompt
-	var customSysPrompt string
-	if proj, err := s.getProjectStore(r).Get(req.ProjectID); err == nil {
-		customSysPrompt = proj.SystemPrompt
-	}
-
-	answer, err := llmClient.AnswerQuestion(ctx, req.Question, results, rw.ret.DocSummaries, history, customSysPrompt)
+	qr, err := s.answerWithHistory(ctx, r, rw, llmClient, req.Question, history, req.TopK, proj, answerOptions{schema: schema, language: req.Language, translate: req.TranslateSources, redact: req.RedactPII, filters: filters, bySection: req.Granularity == "section", queryType: req.QueryType, verifier: verifier})
 	if err != nil {
-		jsonErr(w, fmt.Sprintf("LLM error: %v", err), http.StatusInternalServerError)
+		answerErr(w, err)
 		return
 	}
+	answer, enhancedQuestion := qr.answer, qr.enhancedQuestion
+	recordTokenUsage(s.getProjectStore(r), req.ProjectID, answer.Usage)
 
 	elapsed := time.Since(start).Seconds()
 
 	// Persist messages to conversation if IDs are provided
+	var assistantMsgID string
 	if req.ConversationID != "" {
 		userMsg := chat.Message{
 			Role:      "user",
@@ -117,24 +463,18 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 			Timestamp: start,
 		}
 		assistantMsg := chat.Message{
-			Role:    "assistant",
-			Content: answer.Answer,
-			Metadata: map[string]interface{}{
-				"thinking":          answer.Thinking,
-				"documents":         answer.Documents,
-				"pages":             answer.Pages,
-				"footnotes":         answer.Footnotes,
-				"confidence":        answer.Confidence,
-				"confidence_reason": answer.ConfidenceReason,
-				"time_seconds":      elapsed,
-				"provider":          req.Provider,
-				"model":             req.Model,
-			},
+			ID:        newID(),
+			Role:      "assistant",
+			Content:   answer.Answer,
+			Metadata:  answerMetadata(answer, elapsed, req.Provider, req.Model),
 			Timestamp: time.Now(),
 		}
+		assistantMsgID = assistantMsg.ID
+		store := s.getProjectStore(r)
 		go func() {
-			_ = s.getProjectStore(r).SaveMessage(req.ProjectID, req.ConversationID, userMsg)
-			_ = s.getProjectStore(r).SaveMessage(req.ProjectID, req.ConversationID, assistantMsg)
+			if err := store.SaveMessages(req.ProjectID, req.ConversationID, userMsg, assistantMsg); err != nil {
+				log.Printf("Warning: failed to save messages for conversation %s: %v", req.ConversationID, err)
+			}
 		}()
 	}
 
@@ -142,9 +482,23 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 		"answer":       answer,
 		"time_seconds": elapsed,
 	}
+	if assistantMsgID != "" {
+		resp["message_id"] = assistantMsgID // for /api/conversations/feedback
+	}
 	if enhancedQuestion != req.Question {
 		resp["enhanced_question"] = enhancedQuestion
 	}
+	if len(qr.translations) > 0 {
+		resp["translated_sources"] = qr.translations
+	}
+	if asOf != nil {
+		resp["as_of"] = asOf
+	}
+	if len(qr.routed) > 0 {
+		resp["routed_documents"] = qr.routed
+	}
+	resp["query_type"] = qr.queryType
+	rw.markPartial(resp)
 	jsonResp(w, resp)
 }
 
@@ -164,18 +518,75 @@ func (s *Server) handleStreamQuery(w http.ResponseWriter, r *http.Request) {
 		jsonErr(w, "project_id is required", http.StatusBadRequest)
 		return
 	}
+	if len(req.Schema) > 0 {
+		jsonErr(w, "schema is not supported for streaming; use /api/query", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tools) > 0 {
+		jsonErr(w, "tools are not supported for streaming; use /api/query", http.StatusBadRequest)
+		return
+	}
 
-	rw, err := s.getRetrieverForProject(req.ProjectID)
+	proj := s.projectForQuery(w, r, req.ProjectID)
+	if proj == nil {
+		return
+	}
+	filters, err := tagFilters(proj, req.Tags)
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validGranularity(req.Granularity) {
+		jsonErr(w, `granularity must be "page" or "section"`, http.StatusBadRequest)
+		return
+	}
+	if !validQueryType(req.QueryType) {
+		jsonErr(w, "query_type must be one of: "+strings.Join(llm.QueryTypes(), ", "), http.StatusBadRequest)
+		return
+	}
+	if req.TopK < 0 || req.TopK > maxTopK {
+		jsonErr(w, fmt.Sprintf("top_k must be between 0 and %d", maxTopK), http.StatusBadRequest)
+		return
+	}
+	var verifier *llm.Completer
+	if req.Verify {
+		if verifier, err = verifyCompleter(s.projectSettings(r, req.ProjectID), req.Provider, req.VerifyProvider, req.VerifyModel); err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rw, err := s.getQueryRetriever(req.ProjectID)
 	if err != nil {
 		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
 		return
 	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
+	var asOf []asOfDocument
+	if req.AsOf != "" {
+		snap, set, release, err := s.retrieverAsOf(s.getProjectStore(r), req.ProjectID, rw, req.AsOf)
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer release()
+		rw, asOf = snap, set
+	}
 
-	llmClient, err := s.getProvider(s.getUserSettings(r), req.Provider, req.Model)
+	llmClient, err := s.getProvider(s.projectSettings(r, req.ProjectID), req.Provider, req.Model)
 	if err != nil {
 		jsonErr(w, fmt.Sprintf("Provider error: %v", err), http.StatusBadRequest)
 		return
 	}
+	reasoning, err := llm.ParseReasoning(req.Reasoning)
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	llmClient = llm.WithReasoning(llmClient, reasoning)
 
 	// Check that the provider supports streaming
 	streamClient, ok := llmClient.(llm.StreamProvider)
@@ -191,25 +602,41 @@ func (s *Server) handleStreamQuery(w http.ResponseWriter, r *http.Request) {
 	var history []llm.ChatMessage
 	if req.ConversationID != "" {
 		if msgs, err := s.getProjectStore(r).LoadMessages(req.ProjectID, req.ConversationID); err == nil {
-			for _, m := range msgs {
-				history = append(history, llm.ChatMessage{Role: m.Role, Content: m.Content})
-			}
+			history = chatHistory(msgs)
 		}
 	}
 
 	// Enhance the query using history + document context
 	enhancedQuestion := req.Question
 	if len(history) > 0 {
-		if enhanced, err := llm.EnhanceQuery(ctx, s.getUserSettings(r).OpenAIKey, req.Question, history, rw.ret.DocSummaries); err == nil && enhanced != "" {
+		if enhanced, err := llm.EnhanceQuery(ctx, s.projectSettings(r, req.ProjectID).OpenAIKey, req.Question, history, rw.ret.DocSummaries); err == nil && enhanced != "" {
 			enhancedQuestion = enhanced
 		}
 	}
 
-	results, err := rw.ret.Search(ctx, enhancedQuestion, 20)
+	queryType, strategy := queryStrategy(enhancedQuestion, req.QueryType)
+	topK := req.TopK
+	if topK == 0 {
+		topK = s.projectSettings(r, req.ProjectID).TopK
+	}
+	if topK == 0 {
+		topK = strategy.TopK
+	}
+	filters, routed := s.routeQuestion(ctx, r, req.ProjectID, rw, enhancedQuestion, filters)
+	results, err := search(ctx, rw.ret, enhancedQuestion, topK, filters, req.Granularity == "section")
 	if err != nil {
 		jsonErr(w, fmt.Sprintf("Retrieval error: %v", err), http.StatusInternalServerError)
 		return
 	}
+	summaries := strategy.Summaries(results, rw.ret.DocSummaries)
+
+	// Project's custom system prompt, plus definitions of terms the question
+	// uses and the requested answer language
+	customSysPrompt := withDefinitions(rw.ret, req.Question+"\n"+enhancedQuestion, proj.SystemPrompt)
+	customSysPrompt = withDocTypePrompt(customSysPrompt, results, rw.ret.DocSummaries, s.projectSettings(r, req.ProjectID).DocTypePrompts)
+	customSysPrompt = withStrategyPrompt(customSysPrompt, strategy)
+	var translations []sourceTranslation
+	results, translations, customSysPrompt = s.applyLanguage(ctx, r, req.ProjectID, req.Language, req.TranslateSources, results, customSysPrompt)
 
 	// Set SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -233,19 +660,30 @@ func (s *Server) handleStreamQuery(w http.ResponseWriter, r *http.Request) {
 		flusher.Flush()
 	}
 
-	// Look up project's custom system prompt
-	var customSysPrompt string
-	if proj, err := s.getProjectStore(r).Get(req.ProjectID); err == nil {
-		customSysPrompt = proj.SystemPrompt
-	}
-
 	// Start streaming
 	tokenCh := make(chan llm.StreamToken, 100)
-	go streamClient.StreamAnswer(ctx, req.Question, results, rw.ret.DocSummaries, history, tokenCh, customSysPrompt)
+	go streamClient.StreamAnswer(ctx, req.Question, results, summaries, history, tokenCh, customSysPrompt, proj.BasePrompt)
 
 	var finalAnswer *llm.Answer
 
+	// With redaction the text can't be streamed as it arrives: only the
+	// final, redacted answer is sent
+	redact := req.RedactPII || proj.RedactAnswers
+	if redact {
+		redactTranslations(translations)
+	}
 	for tok := range tokenCh {
+		if tok.Final != nil {
+			llm.RepairCitations(tok.Final, results, summaries)
+		}
+		if redact {
+			if tok.Type == "text" || tok.Type == "thinking" {
+				continue
+			}
+			if tok.Final != nil {
+				redactAnswer(tok.Final)
+			}
+		}
 		data, _ := json.Marshal(tok)
 		fmt.Fprintf(w, "data: %s\n\n", data)
 		flusher.Flush()
@@ -257,11 +695,47 @@ func (s *Server) handleStreamQuery(w http.ResponseWriter, r *http.Request) {
 
 	elapsed := time.Since(start).Seconds()
 
+	// Streaming APIs don't report usage, so estimate it from the text
+	if finalAnswer != nil {
+		usage := llm.EstimateUsage(req.Question+llm.FormatContext(results, summaries), finalAnswer.Thinking+finalAnswer.Answer)
+		recordTokenUsage(s.getProjectStore(r), req.ProjectID, usage)
+		verifyAnswerNumbers(finalAnswer, results, rw.ret.Chunks)
+		if verifier != nil {
+			s.verifyAnswer(ctx, r, req.ProjectID, *verifier, req.Question, finalAnswer, results)
+			if redact {
+				redactAnswer(finalAnswer)
+			}
+		}
+	}
+
 	// Send timing info as final event
-	doneData, _ := json.Marshal(map[string]interface{}{
+	complete := map[string]interface{}{
 		"type":         "complete",
 		"time_seconds": elapsed,
-	})
+	}
+	if finalAnswer != nil && len(finalAnswer.NumberChecks) > 0 {
+		complete["number_checks"] = finalAnswer.NumberChecks
+	}
+	if finalAnswer != nil && finalAnswer.Verification != nil {
+		complete["verification"] = finalAnswer.Verification
+	}
+	if len(translations) > 0 {
+		complete["translated_sources"] = translations
+	}
+	if asOf != nil {
+		complete["as_of"] = asOf
+	}
+	if len(routed) > 0 {
+		complete["routed_documents"] = routed
+	}
+	complete["query_type"] = queryType
+	rw.markPartial(complete)
+	var assistantMsgID string
+	if req.ConversationID != "" && finalAnswer != nil {
+		assistantMsgID = newID()
+		complete["message_id"] = assistantMsgID // for /api/conversations/feedback
+	}
+	doneData, _ := json.Marshal(complete)
 	fmt.Fprintf(w, "data: %s\n\n", doneData)
 	flusher.Flush()
 
@@ -273,100 +747,370 @@ func (s *Server) handleStreamQuery(w http.ResponseWriter, r *http.Request) {
 			Timestamp: start,
 		}
 		assistantMsg := chat.Message{
-			Role:    "assistant",
-			Content: finalAnswer.Answer,
-			Metadata: map[string]interface{}{
-				"thinking":          finalAnswer.Thinking,
-				"documents":         finalAnswer.Documents,
-				"pages":             finalAnswer.Pages,
-				"footnotes":         finalAnswer.Footnotes,
-				"confidence":        finalAnswer.Confidence,
-				"confidence_reason": finalAnswer.ConfidenceReason,
-				"time_seconds":      elapsed,
-				"provider":          req.Provider,
-				"model":             req.Model,
-			},
+			ID:        assistantMsgID,
+			Role:      "assistant",
+			Content:   finalAnswer.Answer,
+			Metadata:  answerMetadata(finalAnswer, elapsed, req.Provider, req.Model),
 			Timestamp: time.Now(),
 		}
+		store := s.getProjectStore(r)
 		go func() {
-			_ = s.getProjectStore(r).SaveMessage(req.ProjectID, req.ConversationID, userMsg)
-			_ = s.getProjectStore(r).SaveMessage(req.ProjectID, req.ConversationID, assistantMsg)
+			if err := store.SaveMessages(req.ProjectID, req.ConversationID, userMsg, assistantMsg); err != nil {
+				log.Printf("Warning: failed to save messages for conversation %s: %v", req.ConversationID, err)
+			}
 		}()
 	}
 }
 
-func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+// maxTopK caps the top_k a client may ask for.
+const maxTopK = 100
+
+// handleRegenerate re-answers an earlier question in a conversation
+// (POST {project_id, conversation_id, message_id, provider, model, top_k}).
+// message_id may be the user question or an answer to it. The history is cut
+// at the question, and the new answer is appended to the conversation with
+// regenerated_from pointing at the answer it replaces.
+func (s *Server) handleRegenerate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req BatchRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonErr(w, "Invalid request body", http.StatusBadRequest)
+	var req struct {
+		ProjectID      string `json:"project_id"`
+		ConversationID string `json:"conversation_id"`
+		MessageID      string `json:"message_id"`
+		Provider       string `json:"provider,omitempty"`
+		Model          string `json:"model,omitempty"`
+		TopK           int    `json:"top_k,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" || req.ConversationID == "" || req.MessageID == "" {
+		jsonErr(w, "project_id, conversation_id and message_id are required", http.StatusBadRequest)
 		return
 	}
+	if req.TopK <= 0 {
+		req.TopK = defaultTopK
+	}
+	if req.TopK > maxTopK {
+		req.TopK = maxTopK
+	}
 
-	if req.ProjectID == "" {
-		jsonErr(w, "project_id is required", http.StatusBadRequest)
+	store := s.getProjectStore(r)
+	msgs, err := store.LoadMessages(req.ProjectID, req.ConversationID)
+	if err != nil {
+		jsonErr(w, "Conversation not found", http.StatusNotFound)
 		return
 	}
 
-	rw, err := s.getRetrieverForProject(req.ProjectID)
+	// Find the question being re-asked and the answer it replaces
+	target := -1
+	for i, m := range msgs {
+		if m.ID == req.MessageID {
+			target = i
+			break
+		}
+	}
+	if target < 0 {
+		jsonErr(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	question := target
+	for question >= 0 && msgs[question].Role != "user" {
+		question--
+	}
+	if question < 0 {
+		jsonErr(w, "No user question precedes this message", http.StatusBadRequest)
+		return
+	}
+	original := ""
+	if msgs[target].Role == "assistant" {
+		original = msgs[target].ID
+	} else if question+1 < len(msgs) && msgs[question+1].Role == "assistant" {
+		original = msgs[question+1].ID
+	}
+
+	proj := s.projectForQuery(w, r, req.ProjectID)
+	if proj == nil {
+		return
+	}
+	rw, err := s.getQueryRetriever(req.ProjectID)
 	if err != nil {
 		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
 		return
 	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
+	llmClient, err := s.getProvider(s.projectSettings(r, req.ProjectID), req.Provider, req.Model)
+	if err != nil {
+		jsonErr(w, fmt.Sprintf("Provider error: %v", err), http.StatusBadRequest)
+		return
+	}
 
-	llmClient, err := s.getProvider(s.getUserSettings(r), req.Provider, req.Model)
+	start := time.Now()
+	q := msgs[question].Content
+	qr, err := s.answerWithHistory(r.Context(), r, rw, llmClient, q, chatHistory(msgs[:question]), req.TopK, proj, answerOptions{})
+	if err != nil {
+		answerErr(w, err)
+		return
+	}
+	recordTokenUsage(store, req.ProjectID, qr.answer.Usage)
+	elapsed := time.Since(start).Seconds()
+
+	meta := answerMetadata(qr.answer, elapsed, req.Provider, req.Model)
+	meta["top_k"] = req.TopK
+	meta["question_id"] = msgs[question].ID
+	msg := chat.Message{
+		ID:              newID(),
+		Role:            "assistant",
+		Content:         qr.answer.Answer,
+		Metadata:        meta,
+		RegeneratedFrom: original,
+		Timestamp:       time.Now(),
+	}
+	if err := store.SaveMessage(req.ProjectID, req.ConversationID, msg); err != nil {
+		jsonErr(w, "Failed to save message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"message":      msg,
+		"answer":       qr.answer,
+		"time_seconds": elapsed,
+	}
+	rw.markPartial(resp)
+	jsonResp(w, resp)
+}
+
+// handleEditMessage edits an earlier user message and answers it again
+// (POST {project_id, conversation_id, message_id, content, provider, model}).
+// The original message and everything after it are kept but marked
+// superseded; the edit and its new answer are appended to the conversation.
+func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID      string `json:"project_id"`
+		ConversationID string `json:"conversation_id"`
+		MessageID      string `json:"message_id"`
+		Content        string `json:"content"`
+		Provider       string `json:"provider,omitempty"`
+		Model          string `json:"model,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" || req.ConversationID == "" || req.MessageID == "" {
+		jsonErr(w, "project_id, conversation_id and message_id are required", http.StatusBadRequest)
+		return
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" {
+		jsonErr(w, "content is required", http.StatusBadRequest)
+		return
+	}
+
+	// Check everything needed to answer before touching the conversation
+	proj := s.projectForQuery(w, r, req.ProjectID)
+	if proj == nil {
+		return
+	}
+	rw, err := s.getQueryRetriever(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
+	llmClient, err := s.getProvider(s.projectSettings(r, req.ProjectID), req.Provider, req.Model)
 	if err != nil {
 		jsonErr(w, fmt.Sprintf("Provider error: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	ctx := r.Context()
+	store := s.getProjectStore(r)
+	edit, err := store.EditMessage(req.ProjectID, req.ConversationID, req.MessageID, req.Content)
+	if err != nil {
+		code := http.StatusNotFound
+		if errors.Is(err, chat.ErrNotUserMessage) || errors.Is(err, chat.ErrMessageSuperseded) {
+			code = http.StatusBadRequest
+		}
+		jsonErr(w, err.Error(), code)
+		return
+	}
+	msgs, err := store.LoadMessages(req.ProjectID, req.ConversationID)
+	if err != nil {
+		jsonErr(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	// All active messages except the edit itself, which is the question
+	history := chatHistory(msgs[:len(msgs)-1])
+
 	start := time.Now()
+	qr, err := s.answerWithHistory(r.Context(), r, rw, llmClient, edit.Content, history, 0, proj, answerOptions{})
+	if err != nil {
+		// The edit is saved; the client can retry with /api/conversations/regenerate
+		answerErr(w, err)
+		return
+	}
+	recordTokenUsage(store, req.ProjectID, qr.answer.Usage)
+	elapsed := time.Since(start).Seconds()
+
+	answerMsg := chat.Message{
+		ID:        newID(),
+		Role:      "assistant",
+		Content:   qr.answer.Answer,
+		Metadata:  answerMetadata(qr.answer, elapsed, req.Provider, req.Model),
+		Timestamp: time.Now(),
+	}
+	if err := store.SaveMessage(req.ProjectID, req.ConversationID, answerMsg); err != nil {
+		jsonErr(w, "Failed to save message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"message":        edit,
+		"answer_message": answerMsg,
+		"answer":         qr.answer,
+		"time_seconds":   elapsed,
+	}
+	rw.markPartial(resp)
+	jsonResp(w, resp)
+}
+
+// ModelChoice names a provider and model for a comparison run.
+type ModelChoice struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model,omitempty"`
+}
+
+// CompareResult is one model's side of a comparison.
+type CompareResult struct {
+	Provider    string      `json:"provider"`
+	Model       string      `json:"model"`
+	Answer      *llm.Answer `json:"answer,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	TimeSeconds float64     `json:"time_seconds"`
+	Usage       *llm.Usage  `json:"usage,omitempty"`
+	CostUSD     *float64    `json:"cost_usd,omitempty"` // omitted when the model's price is unknown
+}
+
+// handleCompareQuery answers one question with two provider/model pairs from
+// the same retrieved context, so the answers differ only by model.
+func (s *Server) handleCompareQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Question  string        `json:"question"`
+		ProjectID string        `json:"project_id"`
+		Models    []ModelChoice `json:"models"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ProjectID == "" || req.Question == "" {
+		jsonErr(w, "project_id and question are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Models) != 2 {
+		jsonErr(w, "models must list exactly two provider/model pairs", http.StatusBadRequest)
+		return
+	}
+
+	proj := s.projectForQuery(w, r, req.ProjectID)
+	if proj == nil {
+		return
+	}
+
+	rw, err := s.getQueryRetriever(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
 
-	// Look up project's custom system prompt
-	var customSysPrompt string
-	if proj, err := s.getProjectStore(r).Get(req.ProjectID); err == nil {
-		customSysPrompt = proj.SystemPrompt
+	// Resolve both providers before spending anything on retrieval
+	settings := s.projectSettings(r, req.ProjectID)
+	clients := make([]llm.Provider, len(req.Models))
+	for i, m := range req.Models {
+		clients[i], err = s.getProvider(settings, m.Provider, m.Model)
+		if err != nil {
+			jsonErr(w, fmt.Sprintf("Provider error (%s): %v", m.Provider, err), http.StatusBadRequest)
+			return
+		}
 	}
 
-	answers := make([]*llm.Answer, len(req.Questions))
+	ctx := r.Context()
+	start := time.Now()
+	results, err := rw.ret.Search(ctx, req.Question, defaultTopK)
+	if err != nil {
+		jsonErr(w, fmt.Sprintf("Retrieval error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	retrievalTime := time.Since(start).Seconds()
+	promptText := req.Question + llm.FormatContext(results, rw.ret.DocSummaries)
+	sysPrompt := withDocTypePrompt(withDefinitions(rw.ret, req.Question, proj.SystemPrompt), results, rw.ret.DocSummaries, s.projectSettings(r, req.ProjectID).DocTypePrompts)
+
+	out := make([]CompareResult, len(req.Models))
 	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var errors []string
+	for i, m := range req.Models {
+		model := m.Model
+		if model == "" {
+			model = llm.DefaultModel(m.Provider)
+		}
+		out[i] = CompareResult{Provider: m.Provider, Model: model}
 
-	for i, q := range req.Questions {
 		wg.Add(1)
-		go func(idx int, question string) {
+		go func(i int) {
 			defer wg.Done()
-			results, err := rw.ret.Search(ctx, question, 20)
+			t := time.Now()
+			answer, err := clients[i].AnswerQuestion(ctx, req.Question, results, rw.ret.DocSummaries, nil, sysPrompt, proj.BasePrompt)
+			out[i].TimeSeconds = time.Since(t).Seconds()
 			if err != nil {
-				mu.Lock()
-				errors = append(errors, fmt.Sprintf("Q%d retrieval: %v", idx, err))
-				mu.Unlock()
+				out[i].Error = err.Error()
 				return
 			}
-			answer, err := llmClient.AnswerQuestion(ctx, question, results, rw.ret.DocSummaries, nil, customSysPrompt)
-			if err != nil {
-				mu.Lock()
-				errors = append(errors, fmt.Sprintf("Q%d LLM: %v", idx, err))
-				mu.Unlock()
-				return
+			if answer.Usage == nil {
+				answer.Usage = llm.EstimateUsage(promptText, answer.Answer)
 			}
-			mu.Lock()
-			answers[idx] = answer
-			mu.Unlock()
-		}(i, q)
+			llm.RepairCitations(answer, results, rw.ret.DocSummaries)
+			out[i].Answer = answer
+			out[i].Usage = answer.Usage
+			if cost, ok := llm.EstimateCost(out[i].Model, answer.Usage); ok {
+				out[i].CostUSD = &cost
+			}
+		}(i)
 	}
 	wg.Wait()
 
-	jsonResp(w, BatchResponse{
-		Answers:   answers,
-		TotalTime: time.Since(start).Seconds(),
-	})
+	var total llm.Usage
+	for _, res := range out {
+		if res.Usage != nil {
+			total.InputTokens += res.Usage.InputTokens
+			total.OutputTokens += res.Usage.OutputTokens
+		}
+	}
+	recordTokenUsage(s.getProjectStore(r), req.ProjectID, &total)
+
+	resp := map[string]interface{}{
+		"question":               req.Question,
+		"chunks_retrieved":       len(results),
+		"retrieval_time_seconds": retrievalTime,
+		"results":                out,
+		"total_time_seconds":     time.Since(start).Seconds(),
+	}
+	rw.markPartial(resp)
+	jsonResp(w, resp)
 }
 
 // ========== Stats & Providers ==========
@@ -393,13 +1137,16 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 
 	docs := 0
 	chunks := 0
+	var view []indexer.Chunk
+	var summaries []indexer.DocumentSummary
 	if idx != nil {
+		view, summaries = idx.View()
 		docSet := make(map[string]bool)
-		for _, c := range idx.Chunks {
+		for _, c := range view {
 			docSet[c.Document] = true
 		}
 		docs = len(docSet)
-		chunks = len(idx.Chunks)
+		chunks = len(view)
 	}
 
 	var available []string
@@ -420,6 +1167,17 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 		IndexReady: chunks > 0,
 		Providers:  available,
 		DefaultLLM: s.getUserSettings(r).DefaultLLM,
+		LLMQueue:   llm.LimiterStats(),
+		Parsing:    llm.ParseStats(),
+		ReadOnly:   readOnly,
+	}
+	if projectID != "" {
+		if _, err := s.getProjectStore(r).Get(projectID); err == nil {
+			fb := s.getProjectStore(r).ProjectFeedback(projectID)
+			resp.Feedback = &fb
+			store := s.getProjectStore(r)
+			resp.ByDocument = documentStats(uploadedDocuments(store, projectID), loadIngestRecords(store, projectID), view, summaries, idx != nil)
+		}
 	}
 
 	jsonResp(w, resp)
@@ -9,10 +9,16 @@ import (
 	"time"
 
 	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
 )
 
+// exactMatchBoost weighs an exact identifier or phrase match in keyword
+// search against the query's matching words.
+const exactMatchBoost = 5
+
 // handleSearch performs BM25 keyword search across indexed document chunks.
 // No LLM or embedding API calls needed — pure keyword matching, instant results.
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
@@ -36,13 +42,16 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	var bm25Index bleve.Index
 	var chunks []indexer.Chunk
+	var fuzziness int
 
 	if s.activeProjectID == req.ProjectID && s.activeIndex != nil {
 		bm25Index = s.activeIndex.BM25Index
-		chunks = s.activeIndex.Chunks
+		chunks, _ = s.activeIndex.View()
+		fuzziness = s.activeIndex.Fuzziness
 	} else if cached, ok := s.indexCache.get(req.ProjectID); ok {
 		bm25Index = cached.idx.BM25Index
-		chunks = cached.idx.Chunks
+		chunks, _ = cached.idx.View()
+		fuzziness = cached.idx.Fuzziness
 	}
 	s.mu.RUnlock()
 
@@ -57,8 +66,31 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		chunkMap[c.ID] = c
 	}
 
-	// Perform BM25 search
-	q := bleve.NewMatchQuery(req.Query)
+	// Perform BM25 search; chunks citing an identifier the query names,
+	// like Section 14.2(b), or quoting its phrases verbatim rank first
+	phrases, keywords := retriever.ParsePhrases(req.Query)
+	match := bleve.NewMatchQuery(keywords)
+	match.SetFuzziness(fuzziness)
+	var q query.Query = match
+	clauses := []query.Query{q}
+	for _, id := range indexer.Identifiers(keywords) {
+		exact := bleve.NewTermQuery(id)
+		exact.SetField(indexer.IdentifiersField)
+		exact.SetBoost(exactMatchBoost)
+		clauses = append(clauses, exact)
+	}
+	for _, p := range phrases {
+		if p.Slop > 0 {
+			continue // proximity needs the term positions; the hybrid search checks them
+		}
+		phrase := bleve.NewMatchPhraseQuery(p.Text)
+		phrase.SetField("text")
+		phrase.SetBoost(exactMatchBoost)
+		clauses = append(clauses, phrase)
+	}
+	if len(clauses) > 1 {
+		q = bleve.NewDisjunctionQuery(clauses...)
+	}
 	searchReq := bleve.NewSearchRequest(q)
 	searchReq.Size = 30
 
@@ -69,7 +101,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build results with highlighted text
-	queryTerms := extractTerms(req.Query)
+	queryTerms := extractTerms(keywords)
 	var results []searchResult
 	for _, hit := range bm25Results.Hits {
 		chunk, ok := chunkMap[hit.ID]
@@ -85,6 +117,8 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 			Text:       highlighted,
 			Section:    chunk.Section,
 			Score:      hit.Score,
+
+			OCRConfidence: chunk.OCRConfidence,
 		})
 	}
 
@@ -103,6 +137,8 @@ type searchResult struct {
 	Text       string  `json:"text"`
 	Section    string  `json:"section,omitempty"`
 	Score      float64 `json:"score"`
+
+	OCRConfidence float64 `json:"ocr_confidence,omitempty"` // see retriever.Result
 }
 
 // extractTerms splits a query into individual search terms (lowercase).
@@ -129,3 +165,58 @@ func highlightTerms(text string, terms []string) string {
 	}
 	return text
 }
+
+// handleDebugRetrieval explains a hybrid search for a question: each
+// candidate's vector and BM25 ranks and scores, its fused score, and whether
+// it was selected, deduplicated against another chunk of the same page, or
+// cut off past top_k. "find" lists every chunk containing that text with its
+// rank, to trace a passage the search missed. Nothing is sent to the LLM.
+func (s *Server) handleDebugRetrieval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Question  string `json:"question"`
+		ProjectID string `json:"project_id"`
+		TopK      int    `json:"top_k,omitempty"`
+		Find      string `json:"find,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Question == "" || req.ProjectID == "" {
+		jsonErr(w, "question and project_id are required", http.StatusBadRequest)
+		return
+	}
+	if req.TopK <= 0 {
+		req.TopK = defaultTopK
+	}
+
+	if _, err := s.getProjectStore(r).Get(req.ProjectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	rw, err := s.getQueryRetriever(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		jsonErr(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	start := time.Now()
+	ex, err := rw.ret.Explain(r.Context(), req.Question, req.TopK, req.Find)
+	if err != nil {
+		jsonErr(w, fmt.Sprintf("Retrieval error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"explanation":  ex,
+		"total_chunks": len(rw.ret.Chunks),
+		"time_ms":      time.Since(start).Milliseconds(),
+	}
+	rw.markPartial(resp)
+	jsonResp(w, resp)
+}
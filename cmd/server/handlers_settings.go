@@ -9,31 +9,68 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gocognigo/internal/chat"
+	"gocognigo/internal/crypto"
+	"gocognigo/internal/extractor"
+	"gocognigo/internal/httpclient"
 	"gocognigo/internal/indexer"
-	"gocognigo/internal/retriever"
+	"gocognigo/internal/llm"
 )
 
 // ========== Settings Endpoint ==========
 
+// Upper bounds for the ingestion throughput and chunking settings. OpenAI
+// accepts at most 2048 inputs per embedding request.
+const (
+	maxExtractWorkers   = 32
+	maxEmbedConcurrency = 64
+	maxEmbedBatchSize   = 2048
+	maxChunkWords       = 2000
+)
+
 func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		settings := s.getUserSettings(r)
 		resp := map[string]interface{}{
-			"default_llm":         settings.DefaultLLM,
-			"embed_provider":      settings.EmbedProvider,
-			"embed_model":         settings.EmbedModel,
-			"openai_key":          maskKey(settings.OpenAIKey),
-			"anthropic_key":       maskKey(settings.AnthropicKey),
-			"huggingface_key":     maskKey(settings.HuggingFaceKey),
-			"ocr_provider":        settings.OCRProvider,
-			"sarvam_key":          maskKey(settings.SarvamKey),
-			"tesseract_available": s.tesseractOk, // immutable after startup
-			"tesseract_lang":      settings.TesseractLang,
+			"default_llm":              settings.DefaultLLM,
+			"default_model":            settings.DefaultModel,
+			"embed_provider":           settings.EmbedProvider,
+			"embed_model":              settings.EmbedModel,
+			"multilingual_embed_model": settings.MultilingualEmbedModel,
+			"openai_key":               maskKey(settings.OpenAIKey),
+			"anthropic_key":            maskKey(settings.AnthropicKey),
+			"huggingface_key":          maskKey(settings.HuggingFaceKey),
+			"ocr_provider":             settings.OCRProvider,
+			"sarvam_key":               maskKey(settings.SarvamKey),
+			"tesseract_available":      s.tesseractOk, // immutable after startup
+			"tesseract_lang":           settings.TesseractLang,
+			"env_only_keys":            envOnlyKeys, // keys saved here last until restart
+			"extract_workers":          settings.ExtractWorkers,
+			"embed_concurrency":        settings.EmbedConcurrency,
+			"embed_batch_size":         settings.EmbedBatchSize,
+			"chunk_words":              settings.ChunkWords,
+			"chunk_overlap":            settings.ChunkOverlap,
+			"top_k":                    settings.TopK,
+			"active_profile":           activeProfile(settings),
+			"doc_type_prompts":         settings.DocTypePrompts,
+			"default_doc_type_prompts": llm.DocTypePrompts,
+			"summary_provider":         settings.SummaryProvider,
+			"summary_model":            settings.SummaryModel,
+			"route_min_documents":      settings.RouteMinDocuments,
+			"hierarchical_summaries":   settings.HierarchicalSummaries,
+			"smtp_host":                settings.SMTPHost,
+			"smtp_port":                settings.SMTPPort,
+			"smtp_username":            settings.SMTPUsername,
+			"smtp_password":            maskKey(settings.SMTPPassword),
+			"smtp_from":                settings.SMTPFrom,
+			"notify_email":             settings.NotifyEmail,
+			"notify_min_files":         settings.NotifyMinFiles,
 		}
 		jsonResp(w, resp)
 
@@ -48,12 +85,97 @@ func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 			OCRProvider    string `json:"ocr_provider"`
 			SarvamKey      string `json:"sarvam_key"`
 			TesseractLang  string `json:"tesseract_lang"`
+
+			DefaultModel *string `json:"default_model"` // nil keeps, "" is the provider's default
+
+			MultilingualEmbedModel *string `json:"multilingual_embed_model"` // nil keeps, "" turns routing off
+
+			// Ingestion throughput: nil keeps, 0 restores the default
+			ExtractWorkers   *int `json:"extract_workers"`
+			EmbedConcurrency *int `json:"embed_concurrency"`
+			EmbedBatchSize   *int `json:"embed_batch_size"`
+
+			// Chunking and retrieval: nil keeps, 0 restores the default
+			ChunkWords   *int `json:"chunk_words"`
+			ChunkOverlap *int `json:"chunk_overlap"`
+			TopK         *int `json:"top_k"`
+
+			RouteMinDocuments     *int  `json:"route_min_documents"` // nil keeps, 0 turns routing off
+			HierarchicalSummaries *bool `json:"hierarchical_summaries"`
+
+			// Per-type answer prompts: nil keeps, {} restores the defaults
+			DocTypePrompts *map[string]string `json:"doc_type_prompts"`
+
+			// Ingest summary LLM: nil keeps, "" follows default_llm
+			SummaryProvider *string `json:"summary_provider"`
+			SummaryModel    *string `json:"summary_model"`
+
+			// Job notifications: nil keeps; the password is kept when empty
+			// or masked, like the API keys
+			SMTPHost       *string `json:"smtp_host"`
+			SMTPPort       *int    `json:"smtp_port"`
+			SMTPUsername   *string `json:"smtp_username"`
+			SMTPPassword   string  `json:"smtp_password"`
+			SMTPFrom       *string `json:"smtp_from"`
+			NotifyEmail    *string `json:"notify_email"`
+			NotifyMinFiles *int    `json:"notify_min_files"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			jsonErr(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
 
+		for _, l := range []struct {
+			name  string
+			value *int
+			max   int
+		}{
+			{"extract_workers", req.ExtractWorkers, maxExtractWorkers},
+			{"embed_concurrency", req.EmbedConcurrency, maxEmbedConcurrency},
+			{"embed_batch_size", req.EmbedBatchSize, maxEmbedBatchSize},
+			{"chunk_words", req.ChunkWords, maxChunkWords},
+			{"chunk_overlap", req.ChunkOverlap, maxChunkWords},
+			{"top_k", req.TopK, maxTopK},
+		} {
+			if l.value != nil && (*l.value < 0 || *l.value > l.max) {
+				jsonErr(w, fmt.Sprintf("%s must be between 0 (default) and %d", l.name, l.max), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if req.SMTPPort != nil && (*req.SMTPPort < 0 || *req.SMTPPort > 65535) {
+			jsonErr(w, "smtp_port must be between 0 (587) and 65535", http.StatusBadRequest)
+			return
+		}
+		if req.NotifyMinFiles != nil && *req.NotifyMinFiles < 0 {
+			jsonErr(w, "notify_min_files must be 0 (default) or more", http.StatusBadRequest)
+			return
+		}
+		if req.RouteMinDocuments != nil && *req.RouteMinDocuments < 0 {
+			jsonErr(w, "route_min_documents must be 0 (off) or more", http.StatusBadRequest)
+			return
+		}
+		if req.SummaryProvider != nil {
+			switch *req.SummaryProvider {
+			case "", "openai", "anthropic", "huggingface":
+			default:
+				jsonErr(w, `summary_provider must be "", "openai", "anthropic" or "huggingface"`, http.StatusBadRequest)
+				return
+			}
+		}
+		if req.DocTypePrompts != nil {
+			known := map[string]bool{}
+			for _, t := range llm.DocTypes() {
+				known[t] = true
+			}
+			for t := range *req.DocTypePrompts {
+				if !known[t] {
+					jsonErr(w, fmt.Sprintf("doc_type_prompts: unknown document type %q (known: %s)", t, strings.Join(llm.DocTypes(), ", ")), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
 		settings := s.getUserSettings(r)
 
 		// Create a copy to update
@@ -79,6 +201,71 @@ func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 		} else {
 			newSettings.EmbedModel = "" // support clearing
 		}
+		if req.MultilingualEmbedModel != nil {
+			newSettings.MultilingualEmbedModel = strings.TrimSpace(*req.MultilingualEmbedModel)
+		}
+		if req.DefaultModel != nil {
+			newSettings.DefaultModel = strings.TrimSpace(*req.DefaultModel)
+		}
+
+		if req.ExtractWorkers != nil {
+			newSettings.ExtractWorkers = *req.ExtractWorkers
+		}
+		if req.EmbedConcurrency != nil {
+			newSettings.EmbedConcurrency = *req.EmbedConcurrency
+		}
+		if req.EmbedBatchSize != nil {
+			newSettings.EmbedBatchSize = *req.EmbedBatchSize
+		}
+		if req.ChunkWords != nil {
+			newSettings.ChunkWords = *req.ChunkWords
+		}
+		if req.ChunkOverlap != nil {
+			newSettings.ChunkOverlap = *req.ChunkOverlap
+		}
+		if req.TopK != nil {
+			newSettings.TopK = *req.TopK
+		}
+		if req.RouteMinDocuments != nil {
+			newSettings.RouteMinDocuments = *req.RouteMinDocuments
+		}
+		if req.HierarchicalSummaries != nil {
+			newSettings.HierarchicalSummaries = *req.HierarchicalSummaries
+		}
+		for _, f := range []struct {
+			value *string
+			dst   *string
+		}{
+			{req.SMTPHost, &newSettings.SMTPHost},
+			{req.SMTPUsername, &newSettings.SMTPUsername},
+			{req.SMTPFrom, &newSettings.SMTPFrom},
+			{req.NotifyEmail, &newSettings.NotifyEmail},
+		} {
+			if f.value != nil {
+				*f.dst = strings.TrimSpace(*f.value)
+			}
+		}
+		if req.SMTPPort != nil {
+			newSettings.SMTPPort = *req.SMTPPort
+		}
+		if req.NotifyMinFiles != nil {
+			newSettings.NotifyMinFiles = *req.NotifyMinFiles
+		}
+		if req.SMTPPassword != "" && !strings.Contains(req.SMTPPassword, "...") {
+			newSettings.SMTPPassword = req.SMTPPassword
+		}
+		if req.SummaryProvider != nil {
+			newSettings.SummaryProvider = *req.SummaryProvider
+		}
+		if req.SummaryModel != nil {
+			newSettings.SummaryModel = strings.TrimSpace(*req.SummaryModel)
+		}
+		if req.DocTypePrompts != nil {
+			newSettings.DocTypePrompts = nil
+			if len(*req.DocTypePrompts) > 0 {
+				newSettings.DocTypePrompts = *req.DocTypePrompts
+			}
+		}
 
 		newSettings.OCRProvider = req.OCRProvider
 		if req.SarvamKey != "" && !strings.Contains(req.SarvamKey, "...") {
@@ -94,6 +281,10 @@ func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if changed := changedSettings(*settings, newSettings); len(changed) > 0 {
+			recordAudit(r, "settings.update", "", "", map[string]string{"changed": strings.Join(changed, ", ")})
+		}
+
 		log.Printf("Settings updated: LLM=%s, Embed=%s", req.DefaultLLM, req.EmbedProvider)
 		jsonResp(w, map[string]string{"status": "saved"})
 
@@ -102,7 +293,100 @@ func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleIndexStatus returns whether the vector index is loaded for a given project.
+// handleRotateKey re-encrypts all stored API keys with new key material.
+// Admin only. An optional passphrase switches to (or changes) passphrase mode;
+// otherwise a fresh random key replaces the keychain entry or key file.
+func (s *Server) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	n, err := rotateSettingsKey(req.Passphrase)
+	if err != nil {
+		log.Printf("Key rotation failed: %v", err)
+		jsonErr(w, "Key rotation failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "settings.rotate_key", "", "", map[string]string{
+		"key_source":      crypto.Source(),
+		"files_rewritten": strconv.Itoa(n),
+	})
+
+	resp := map[string]interface{}{
+		"status":          "rotated",
+		"files_rewritten": n,
+		"key_source":      crypto.Source(),
+	}
+	if req.Passphrase != "" {
+		resp["note"] = "Update " + crypto.PassphraseEnv + " to the new passphrase before the next restart"
+	}
+	jsonResp(w, resp)
+}
+
+// changedSettings lists the JSON names of fields that differ between before and
+// after. Values are deliberately omitted so keys never reach the audit log.
+func changedSettings(before, after SavedSettings) []string {
+	fields := []struct {
+		name          string
+		before, after string
+	}{
+		{"openai_key", before.OpenAIKey, after.OpenAIKey},
+		{"anthropic_key", before.AnthropicKey, after.AnthropicKey},
+		{"huggingface_key", before.HuggingFaceKey, after.HuggingFaceKey},
+		{"sarvam_key", before.SarvamKey, after.SarvamKey},
+		{"default_llm", before.DefaultLLM, after.DefaultLLM},
+		{"default_model", before.DefaultModel, after.DefaultModel},
+		{"embed_provider", before.EmbedProvider, after.EmbedProvider},
+		{"embed_model", before.EmbedModel, after.EmbedModel},
+		{"multilingual_embed_model", before.MultilingualEmbedModel, after.MultilingualEmbedModel},
+		{"ocr_provider", before.OCRProvider, after.OCRProvider},
+		{"tesseract_lang", before.TesseractLang, after.TesseractLang},
+		{"extract_workers", strconv.Itoa(before.ExtractWorkers), strconv.Itoa(after.ExtractWorkers)},
+		{"embed_concurrency", strconv.Itoa(before.EmbedConcurrency), strconv.Itoa(after.EmbedConcurrency)},
+		{"embed_batch_size", strconv.Itoa(before.EmbedBatchSize), strconv.Itoa(after.EmbedBatchSize)},
+		{"chunk_words", strconv.Itoa(before.ChunkWords), strconv.Itoa(after.ChunkWords)},
+		{"chunk_overlap", strconv.Itoa(before.ChunkOverlap), strconv.Itoa(after.ChunkOverlap)},
+		{"top_k", strconv.Itoa(before.TopK), strconv.Itoa(after.TopK)},
+		{"doc_type_prompts", fmt.Sprint(before.DocTypePrompts), fmt.Sprint(after.DocTypePrompts)},
+		{"summary_provider", before.SummaryProvider, after.SummaryProvider},
+		{"summary_model", before.SummaryModel, after.SummaryModel},
+		{"route_min_documents", strconv.Itoa(before.RouteMinDocuments), strconv.Itoa(after.RouteMinDocuments)},
+		{"hierarchical_summaries", strconv.FormatBool(before.HierarchicalSummaries), strconv.FormatBool(after.HierarchicalSummaries)},
+		{"smtp_host", before.SMTPHost, after.SMTPHost},
+		{"smtp_port", strconv.Itoa(before.SMTPPort), strconv.Itoa(after.SMTPPort)},
+		{"smtp_username", before.SMTPUsername, after.SMTPUsername},
+		{"smtp_password", before.SMTPPassword, after.SMTPPassword},
+		{"smtp_from", before.SMTPFrom, after.SMTPFrom},
+		{"notify_email", before.NotifyEmail, after.NotifyEmail},
+		{"notify_min_files", strconv.Itoa(before.NotifyMinFiles), strconv.Itoa(after.NotifyMinFiles)},
+	}
+	var changed []string
+	for _, f := range fields {
+		if f.before != f.after {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
+
+// handleIndexStatus returns whether the vector index is loaded for a given
+// project and, while it loads, how far along it is (partial, percent). A
+// loaded index that queries can't search because it was embedded with other
+// models has an embedding_error.
 func (s *Server) handleIndexStatus(w http.ResponseWriter, r *http.Request) {
 	projectID := r.URL.Query().Get("project_id")
 
@@ -122,6 +406,17 @@ func (s *Server) handleIndexStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	s.mu.RUnlock()
 
+	// While a load runs, queries can already search the part loaded so far
+	resp := map[string]interface{}{}
+	if projectID != "" && !ready {
+		if partLoaded, percent, queryable := s.indexLoadProgress(projectID); partLoaded {
+			loading = true
+			ready = queryable
+			resp["partial"] = true
+			resp["percent"] = percent
+		}
+	}
+
 	status := "ready"
 	if loading {
 		status = "loading"
@@ -129,44 +424,74 @@ func (s *Server) handleIndexStatus(w http.ResponseWriter, r *http.Request) {
 		status = "not_loaded"
 	}
 
-	jsonResp(w, map[string]interface{}{
-		"status": status,
-		"ready":  ready,
-	})
+	resp["status"] = status
+	resp["ready"] = ready
+	if rw, err := s.getRetrieverForProject(projectID); projectID != "" && err == nil {
+		if err := checkEmbedding(rw.idx); err != nil {
+			resp["embedding_error"] = err.Error()
+		}
+	}
+	jsonResp(w, resp)
 }
 
-// loadChatIndexes loads a project's pre-built indexes from disk.
+// loadChatIndexes loads a project's pre-built indexes and makes them active.
 func (s *Server) loadChatIndexes(store *chat.ProjectStore, settings *SavedSettings, ProjectID string) error {
-	bm25Dir := store.BM25Dir(ProjectID)
-	vectorsPath := store.VectorsPath(ProjectID)
-
-	if _, err := os.Stat(vectorsPath); os.IsNotExist(err) {
-		return fmt.Errorf("no vectors file for project %s", ProjectID)
-	}
-
-	idx, err := indexer.NewIndex(settings.EmbedProvider, settings.OpenAIKey, settings.EmbedModel, bm25Dir)
+	cached, err := s.loadProjectIndex(store, settings, ProjectID)
 	if err != nil {
-		return fmt.Errorf("failed to open BM25 index: %w", err)
+		return err
 	}
 
-	if err := idx.LoadVectors(vectorsPath); err != nil {
-		_ = idx.Close()
-		return fmt.Errorf("failed to load vectors: %w", err)
-	}
-
-	ret := retriever.NewRetriever(idx)
-
 	s.mu.Lock()
-	s.activeIndex = idx
-	s.activeRetriever = ret
+	s.activeIndex = cached.idx
+	s.activeRetriever = cached.ret
 	s.activeProjectID = ProjectID
-	s.indexCache.put(ProjectID, &cachedIndex{idx: idx, ret: ret})
 	s.mu.Unlock()
-
-	log.Printf("Loaded %d chunks for project %s (cached)", len(idx.Chunks), ProjectID)
 	return nil
 }
 
+// loadProjectIndex returns a project's index from the cache or else loads
+// it from disk and caches it. Concurrent calls for one project share a
+// single load, so its keyword index is never opened twice.
+func (s *Server) loadProjectIndex(store *chat.ProjectStore, settings *SavedSettings, ProjectID string) (*cachedIndex, error) {
+	v, err, _ := s.indexLoads.Do(ProjectID, func() (interface{}, error) {
+		if cached, ok := s.indexCache.get(ProjectID); ok {
+			return cached, nil
+		}
+
+		if err := store.Fetch(ProjectID); err != nil {
+			return nil, err
+		}
+		bm25Dir := store.BM25Dir(ProjectID)
+		vectorsPath := store.VectorsPath(ProjectID)
+
+		if _, err := os.Stat(vectorsPath); os.IsNotExist(err) {
+			return nil, fmt.Errorf("no vectors file for project %s", ProjectID)
+		}
+
+		idx, err := newProjectIndex(storedEmbeddingSettings(settings, vectorsPath, ProjectID), bm25Dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open BM25 index: %w", err)
+		}
+		applyOCRSettings(store, ProjectID, idx)
+
+		load := s.trackIndexLoad(ProjectID, idx)
+		defer load.done()
+		if err := idx.LoadVectorsProgressive(vectorsPath, load.progress); err != nil {
+			_ = idx.Close()
+			return nil, fmt.Errorf("failed to load vectors: %w", err)
+		}
+
+		cached := &cachedIndex{idx: idx, ret: load.retriever()}
+		s.indexCache.put(ProjectID, cached)
+		log.Printf("Loaded %d chunks for project %s (cached)", len(idx.Chunks), ProjectID)
+		return cached, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*cachedIndex), nil
+}
+
 // handleValidateKey tests an API key with a minimal API call.
 func (s *Server) handleValidateKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -212,11 +537,11 @@ func (s *Server) handleValidateKey(w http.ResponseWriter, r *http.Request) {
 
 	switch strings.ToLower(req.Provider) {
 	case "openai":
-		valid, errMsg = validateOpenAIKey(ctx, apiKey)
+		valid, errMsg = eachKey(apiKey, func(key string) (bool, string) { return validateOpenAIKey(ctx, key) })
 	case "anthropic":
-		valid, errMsg = validateAnthropicKey(ctx, apiKey)
+		valid, errMsg = eachKey(apiKey, func(key string) (bool, string) { return validateAnthropicKey(ctx, key) })
 	case "huggingface":
-		valid, errMsg = validateHuggingFaceKey(ctx, apiKey)
+		valid, errMsg = eachKey(apiKey, func(key string) (bool, string) { return validateHuggingFaceKey(ctx, key) })
 	case "sarvam":
 		valid, errMsg = validateSarvamKey(ctx, apiKey)
 	default:
@@ -230,12 +555,239 @@ func (s *Server) handleValidateKey(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// eachKey validates every key of a key setting (see httpclient.SplitKeys),
+// naming the first that fails by its masked value when there are several.
+func eachKey(setting string, validate func(key string) (bool, string)) (bool, string) {
+	keys := httpclient.SplitKeys(setting)
+	for _, key := range keys {
+		if ok, errMsg := validate(key); !ok {
+			if len(keys) > 1 {
+				errMsg = maskKey(key) + ": " + errMsg
+			}
+			return false, errMsg
+		}
+	}
+	return true, ""
+}
+
+// providerCheck is one row of the /api/settings/test report.
+type providerCheck struct {
+	Provider   string `json:"provider"`
+	Capability string `json:"capability"`    // "chat", "embeddings" or "ocr"
+	Key        string `json:"key,omitempty"` // masked, when the provider has several keys
+	Model      string `json:"model,omitempty"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+}
+
+// handleTestSettings makes a minimal live call against every configured
+// provider — a one-token chat per LLM key, a one-string embedding with the
+// configured embedder, and an OCR availability check — so bad keys or models
+// show up before a long ingestion fails. Checks run in parallel.
+func (s *Server) handleTestSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings := *s.getUserSettings(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var checks []func() providerCheck
+
+	chatKeys := []struct{ provider, key string }{
+		{"openai", settings.OpenAIKey},
+		{"anthropic", settings.AnthropicKey},
+		{"huggingface", settings.HuggingFaceKey},
+	}
+	for _, ck := range chatKeys {
+		keys := httpclient.SplitKeys(ck.key)
+		for _, key := range keys {
+			row := providerCheck{Provider: ck.provider, Capability: "chat"}
+			if len(keys) > 1 {
+				row.Key = maskKey(key)
+			}
+			checks = append(checks, func() providerCheck {
+				return timedCheck(row, func() error {
+					return llm.Ping(ctx, ck.provider, key, "")
+				})
+			})
+		}
+	}
+
+	checks = append(checks, func() providerCheck {
+		return testEmbeddings(ctx, &settings)
+	})
+
+	switch settings.OCRProvider {
+	case "sarvam":
+		checks = append(checks, func() providerCheck {
+			return timedCheck(providerCheck{Provider: "sarvam", Capability: "ocr"}, func() error {
+				if settings.SarvamKey == "" {
+					return fmt.Errorf("no API key configured")
+				}
+				if ok, errMsg := validateSarvamKey(ctx, settings.SarvamKey); !ok {
+					return fmt.Errorf("%s", errMsg)
+				}
+				return nil
+			})
+		})
+	case "tesseract":
+		checks = append(checks, func() providerCheck {
+			return timedCheck(providerCheck{Provider: "tesseract", Capability: "ocr"}, func() error {
+				if !s.tesseractOk {
+					return fmt.Errorf("tesseract is not installed")
+				}
+				if !extractor.DetectPdftoppm() {
+					return fmt.Errorf("pdftoppm (Poppler) is not installed — cannot convert PDFs to images")
+				}
+				return nil
+			})
+		})
+	}
+
+	if mail, ok := mailConfigFor(&settings); ok {
+		checks = append(checks, func() providerCheck {
+			return timedCheck(providerCheck{Provider: "smtp", Capability: "notifications"}, mail.check)
+		})
+	}
+
+	results := make([]providerCheck, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func() providerCheck) {
+			defer wg.Done()
+			results[i] = check()
+		}(i, check)
+	}
+	wg.Wait()
+
+	allOK := true
+	for _, res := range results {
+		if !res.OK {
+			allOK = false
+		}
+	}
+
+	jsonResp(w, map[string]interface{}{
+		"results": results,
+		"all_ok":  allOK,
+	})
+}
+
+// embedAPIKey is the API key of the configured embedding provider.
+func embedAPIKey(settings *SavedSettings) string {
+	if settings.EmbedProvider == "huggingface" {
+		return settings.HuggingFaceKey
+	}
+	return settings.OpenAIKey
+}
+
+// newProjectIndex opens (or creates) a project index at bm25Dir with the
+// configured embedders, routing non-English pages to the multilingual model
+// when one is set.
+func newProjectIndex(settings *SavedSettings, bm25Dir string) (*indexer.Index, error) {
+	idx, err := indexer.NewIndex(settings.EmbedProvider, embedAPIKey(settings), settings.EmbedModel, bm25Dir)
+	if err != nil {
+		return nil, err
+	}
+	idx.BatchSize = settings.EmbedBatchSize
+	idx.Concurrency = settings.EmbedConcurrency
+	idx.ChunkWords = settings.ChunkWords
+	idx.ChunkOverlap = settings.ChunkOverlap
+	if settings.MultilingualEmbedModel != "" {
+		if err := idx.SetMultilingual(settings.EmbedProvider, embedAPIKey(settings), settings.MultilingualEmbedModel); err != nil {
+			_ = idx.Close()
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// storedEmbeddingSettings returns the settings to open a project's index
+// with: settings, or if the vectors at vectorsPath were embedded with other
+// models and there is a key for their provider, a copy that embeds with
+// those, so that queries stay comparable with the vectors until the project
+// is re-embedded. Without a key the index opens with settings and
+// CheckEmbedding refuses its queries.
+func storedEmbeddingSettings(settings *SavedSettings, vectorsPath, projectID string) *SavedSettings {
+	stored, ok := indexer.ReadEmbeddingInfo(vectorsPath)
+	if !ok {
+		return settings
+	}
+	routed := *settings
+	routed.EmbedProvider, routed.EmbedModel = stored.Provider, stored.Model
+	if stored.Multilingual != "" {
+		routed.MultilingualEmbedModel = stored.Multilingual
+	}
+	if embedding(routed) == embedding(*settings) {
+		return settings
+	}
+	if embedAPIKey(&routed) == "" {
+		log.Printf("Project %s was embedded with %s, not the configured model, and there is no %s key to query it with", projectID, stored, stored.Provider)
+		return settings
+	}
+	log.Printf("Project %s was embedded with %s, not the configured model; its queries use that until it is re-embedded", projectID, stored)
+	return &routed
+}
+
+// testEmbeddings embeds a single short string with the configured embedder.
+func testEmbeddings(ctx context.Context, settings *SavedSettings) providerCheck {
+	provider := settings.EmbedProvider
+	if provider == "" {
+		provider = "openai"
+	}
+	check := providerCheck{Provider: provider, Capability: "embeddings", Model: settings.EmbedModel}
+
+	return timedCheck(check, func() error {
+		var key string
+		switch provider {
+		case "openai":
+			key = settings.OpenAIKey
+		case "huggingface":
+			key = settings.HuggingFaceKey
+		}
+		if key == "" {
+			return fmt.Errorf("no API key configured")
+		}
+		embedder, err := indexer.NewEmbedder(provider, key, settings.EmbedModel)
+		if err != nil {
+			return err
+		}
+		vecs, err := embedder.Embed(ctx, []string{"connection test"})
+		if err != nil {
+			return err
+		}
+		if len(vecs) != 1 || len(vecs[0]) == 0 {
+			return fmt.Errorf("unexpected embedding response (%d vectors)", len(vecs))
+		}
+		return nil
+	})
+}
+
+// timedCheck runs fn and fills in the outcome and latency of check.
+func timedCheck(check providerCheck, fn func() error) providerCheck {
+	start := time.Now()
+	err := fn()
+	check.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		check.Error = truncateStr(err.Error(), 300)
+		return check
+	}
+	check.OK = true
+	return check
+}
+
 // validateOpenAIKey uses the models list endpoint — cheapest possible call.
 func validateOpenAIKey(ctx context.Context, apiKey string) (bool, string) {
 	req, _ := http.NewRequestWithContext(ctx, "GET", "https://api.openai.com/v1/models", nil)
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpclient.Default.Do(req)
 	if err != nil {
 		return false, "Connection error: " + err.Error()
 	}
@@ -266,7 +818,7 @@ func validateAnthropicKey(ctx context.Context, apiKey string) (bool, string) {
 	req.Header.Set("anthropic-version", "2023-06-01")
 	req.Header.Set("content-type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpclient.Default.Do(req)
 	if err != nil {
 		return false, "Connection error: " + err.Error()
 	}
@@ -287,7 +839,7 @@ func validateHuggingFaceKey(ctx context.Context, apiKey string) (bool, string) {
 	req, _ := http.NewRequestWithContext(ctx, "GET", "https://huggingface.co/api/whoami-v2", nil)
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpclient.Default.Do(req)
 	if err != nil {
 		return false, "Connection error: " + err.Error()
 	}
@@ -310,7 +862,7 @@ func validateSarvamKey(ctx context.Context, apiKey string) (bool, string) {
 	req, _ := http.NewRequestWithContext(ctx, "GET", "https://api.sarvam.ai/v1/models", nil)
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpclient.Default.Do(req)
 	if err != nil {
 		return false, "Connection error: " + err.Error()
 	}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gocognigo/internal/analysis"
+	"gocognigo/internal/chat"
+	"gocognigo/internal/pdfdoc"
+)
+
+// ========== Highlighted Sources ==========
+
+// citingAnswer returns the answer whose citations a served PDF highlights:
+// a conversation's message (conversation_id&message_id) or a batch's
+// question (batch_id&index). It returns nil if the query names neither.
+func (s *Server) citingAnswer(store *chat.ProjectStore, projectID string, q url.Values) (*citedAnswer, error) {
+	switch {
+	case q.Get("conversation_id") != "":
+		msgs, err := store.LoadMessages(projectID, q.Get("conversation_id"))
+		if err != nil {
+			return nil, fmt.Errorf("conversation not found")
+		}
+		question := ""
+		for _, m := range msgs {
+			if m.Role == "user" {
+				question = strings.TrimSpace(m.Content)
+			}
+			if m.ID == q.Get("message_id") && m.Role == "assistant" {
+				return &citedAnswer{Question: question, Answer: m.Content, Footnotes: messageFootnotes(m)}, nil
+			}
+		}
+		return nil, fmt.Errorf("answer %q not found in the conversation", q.Get("message_id"))
+
+	case q.Get("batch_id") != "":
+		rec, err := s.jobRecord(store, projectID, q.Get("batch_id"))
+		if err != nil {
+			return nil, fmt.Errorf("batch not found")
+		}
+		index, err := strconv.Atoi(q.Get("index"))
+		for _, res := range rec.Results {
+			if err == nil && res.Index == index && res.Answer != nil {
+				return &citedAnswer{Question: res.Question, Answer: res.Answer.Answer, Footnotes: res.Answer.Footnotes}, nil
+			}
+		}
+		return nil, fmt.Errorf("the batch has no answer at index %q", q.Get("index"))
+	}
+	return nil, nil
+}
+
+// highlightCitations highlights, in an uploaded PDF, the passages of the
+// pages the answer cites from it that support the answer. Without any, or
+// on error, it returns the PDF as it was.
+func highlightCitations(data []byte, document string, a *citedAnswer) ([]byte, error) {
+	h, err := pdfdoc.NewHighlighter(data)
+	if err != nil {
+		return data, err
+	}
+	seen := map[pdfdoc.Mark]bool{}
+	for _, fn := range a.Footnotes {
+		if fn.Document != document || fn.Page < 1 {
+			continue
+		}
+		text, err := h.PageText(fn.Page)
+		if err != nil {
+			continue
+		}
+		for _, sp := range analysis.CitedSpans(a.Answer, analysis.SourcePage{Marker: fn.ID, Document: document, Page: fn.Page, Text: text}) {
+			key := pdfdoc.Mark{Page: fn.Page, Start: sp.Start, End: sp.End}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			m := key
+			m.Note = fmt.Sprintf("Cited as [%d] in the answer to: %s", fn.ID, a.Question)
+			_ = h.Add(m) // a span the layout can't place stays unhighlighted
+		}
+	}
+	if h.Marks() == 0 {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if err := h.Write(&buf); err != nil {
+		return data, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// ========== Ingestion Pause/Resume & Queue Order ==========
+
+// An ingestion run extracts files from a queue, so a paused run stops
+// taking files and embedding batches (work already under way finishes),
+// and queued files can be moved ahead of the rest.
+
+// ingestControl is the queue and pause switch of the running ingestion.
+type ingestControl struct {
+	mu      sync.Mutex
+	queue   []string
+	paused  bool
+	resumed chan struct{} // closed when a pause ends
+	status  *IngestStatus
+}
+
+func newIngestControl(files []string, status *IngestStatus) *ingestControl {
+	c := &ingestControl{queue: append([]string(nil), files...), status: status}
+	c.publish()
+	return c
+}
+
+// publish copies the queue and pause state into the polled status.
+// Callers hold c.mu (or own c exclusively).
+func (c *ingestControl) publish() {
+	c.status.mu.Lock()
+	c.status.Paused = c.paused
+	c.status.Queued = append([]string(nil), c.queue...)
+	c.status.mu.Unlock()
+}
+
+// wait blocks while the run is paused.
+func (c *ingestControl) wait(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if !c.paused {
+			c.mu.Unlock()
+			return ctx.Err()
+		}
+		ch := c.resumed
+		c.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// next takes the first queued file, waiting out a pause. ok is false when
+// the queue is empty or the run was cancelled.
+func (c *ingestControl) next(ctx context.Context) (file string, ok bool) {
+	for {
+		if c.wait(ctx) != nil {
+			return "", false
+		}
+		c.mu.Lock()
+		if c.paused { // paused again before we got the lock
+			c.mu.Unlock()
+			continue
+		}
+		if len(c.queue) == 0 {
+			c.mu.Unlock()
+			return "", false
+		}
+		file = c.queue[0]
+		c.queue = c.queue[1:]
+		c.publish()
+		c.mu.Unlock()
+		return file, true
+	}
+}
+
+func (c *ingestControl) setPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if paused == c.paused {
+		return
+	}
+	c.paused = paused
+	if paused {
+		c.resumed = make(chan struct{})
+	} else {
+		close(c.resumed)
+	}
+	c.publish()
+}
+
+// prioritize moves the named queued files to the front, in the given
+// order, and returns the new queue. Names not in the queue are ignored.
+func (c *ingestControl) prioritize(files []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queued := make(map[string]bool, len(c.queue))
+	for _, f := range c.queue {
+		queued[f] = true
+	}
+	var front []string
+	moved := map[string]bool{}
+	for _, f := range files {
+		if queued[f] && !moved[f] {
+			front = append(front, f)
+			moved[f] = true
+		}
+	}
+	rest := make([]string, 0, len(c.queue))
+	for _, f := range c.queue {
+		if !moved[f] {
+			rest = append(rest, f)
+		}
+	}
+	c.queue = append(front, rest...)
+	c.publish()
+	return append([]string(nil), c.queue...)
+}
+
+// activeIngestControl returns the running ingestion's control, or writes
+// a 409 and returns nil when nothing is running.
+func (s *Server) activeIngestControl(w http.ResponseWriter) *ingestControl {
+	s.mu.RLock()
+	ctl := s.ingestCtl
+	s.mu.RUnlock()
+	if ctl == nil {
+		jsonErr(w, "No ingestion in progress", http.StatusConflict)
+	}
+	return ctl
+}
+
+// handlePauseIngest pauses the running ingestion (POST).
+func (s *Server) handlePauseIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctl := s.activeIngestControl(w)
+	if ctl == nil {
+		return
+	}
+	ctl.setPaused(true)
+	log.Printf("Ingestion paused by user")
+	jsonResp(w, map[string]string{"status": "paused"})
+}
+
+// handleResumeIngest resumes a paused ingestion (POST).
+func (s *Server) handleResumeIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctl := s.activeIngestControl(w)
+	if ctl == nil {
+		return
+	}
+	ctl.setPaused(false)
+	log.Printf("Ingestion resumed by user")
+	jsonResp(w, map[string]string{"status": "resumed"})
+}
+
+// handleReorderIngest moves queued files to the front of the running
+// ingestion (POST {files: [...]}, first listed goes first).
+func (s *Server) handleReorderIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Files []string `json:"files"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Files) == 0 {
+		jsonErr(w, "files is required", http.StatusBadRequest)
+		return
+	}
+	ctl := s.activeIngestControl(w)
+	if ctl == nil {
+		return
+	}
+	jsonResp(w, map[string]interface{}{"queued": ctl.prioritize(req.Files)})
+}
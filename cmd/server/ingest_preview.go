@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gocognigo/internal/extractor"
+	"gocognigo/internal/indexer"
+)
+
+// ========== Ingestion Dry Run ==========
+
+// A dry run reads each file's text layer and chunks it as ingestion would,
+// without OCR or embedding, so it costs nothing. Pages without text are
+// reported as needing OCR, and their chunks are extrapolated from the
+// text pages when estimating the embedding bill.
+
+// assumedChunksPerPage estimates a scanned page's chunks when no file in
+// the run has a text page to go by (a ~350-word page at 150-word chunks
+// with 30 words of overlap).
+const assumedChunksPerPage = 3
+
+// assumedTokensPerChunk is a 150-word chunk's tokens, for the same case.
+const assumedTokensPerChunk = 200
+
+// filePreview is one file's dry-run result.
+type filePreview struct {
+	Name            string `json:"name"`
+	Status          string `json:"status"` // "ok", "failed" or "indexed" (already in the index; skipped)
+	Error           string `json:"error,omitempty"`
+	Pages           int    `json:"pages"`
+	TextPages       int    `json:"text_pages"`
+	EmptyPages      []int  `json:"empty_pages,omitempty"`
+	NeedsOCR        bool   `json:"needs_ocr"`
+	Chunks          int    `json:"chunks"`
+	EstimatedChunks int    `json:"estimated_chunks"` // Chunks plus extrapolated chunks of pages needing OCR
+	Tokens          int    `json:"tokens"`
+}
+
+// ingestPreview is the dry-run response.
+type ingestPreview struct {
+	DryRun          bool          `json:"dry_run"`
+	Files           []filePreview `json:"files"`
+	Pages           int           `json:"pages"`
+	OCRPages        int           `json:"ocr_pages"`
+	OCRAvailable    bool          `json:"ocr_available"`
+	Chunks          int           `json:"chunks"`
+	EstimatedChunks int           `json:"estimated_chunks"`
+	EstimatedTokens int           `json:"estimated_tokens"`
+	EmbedModel      string        `json:"embed_model"`
+	EstimatedCost   *float64      `json:"estimated_cost_usd"` // nil when the model has no known price
+	Warnings        []string      `json:"warnings,omitempty"`
+}
+
+// previewIngestion dry-runs ingestion of files in uploadsDir. Files in
+// indexed are reported but not counted, as ingestion would skip them.
+func (s *Server) previewIngestion(settings *SavedSettings, uploadsDir string, files []string, indexed map[string]bool, piiMode string) *ingestPreview {
+	s.mu.RLock()
+	ocrCfg := &extractor.OCRConfig{
+		Provider:      settings.OCRProvider,
+		SarvamKey:     settings.SarvamKey,
+		TesseractLang: settings.TesseractLang,
+		TesseractOk:   s.tesseractOk,
+	}
+	s.mu.RUnlock()
+
+	previews := make([]filePreview, len(files))
+	sem := make(chan struct{}, settings.extractWorkers())
+	var wg sync.WaitGroup
+	for i, fname := range files {
+		if indexed[fname] {
+			previews[i] = filePreview{Name: fname, Status: "indexed"}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, fname string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			previews[i] = previewFile(filepath.Join(uploadsDir, fname), fname, piiMode, settings)
+		}(i, fname)
+	}
+	wg.Wait()
+
+	// Extrapolate scanned pages from the corpus's own chunks-per-page.
+	textPages, textChunks := 0, 0
+	for _, p := range previews {
+		textPages += p.TextPages
+		textChunks += p.Chunks
+	}
+	out := &ingestPreview{
+		DryRun:       true,
+		Files:        previews,
+		OCRAvailable: ocrCfg.Enabled(),
+		EmbedModel:   indexer.EmbedModelName(settings.EmbedProvider, settings.EmbedModel),
+	}
+	textTokens := 0
+	for i := range out.Files {
+		p := &out.Files[i]
+		if p.Status != "ok" {
+			continue
+		}
+		ocrPages := p.Pages - p.TextPages
+		extra := ocrPages * assumedChunksPerPage
+		if textPages > 0 {
+			extra = (ocrPages*textChunks + textPages/2) / textPages
+		}
+		p.EstimatedChunks = p.Chunks + extra
+		out.Pages += p.Pages
+		out.OCRPages += ocrPages
+		out.Chunks += p.Chunks
+		out.EstimatedChunks += p.EstimatedChunks
+		textTokens += p.Tokens
+	}
+	perChunk := assumedTokensPerChunk
+	if out.Chunks > 0 {
+		perChunk = textTokens / out.Chunks
+	}
+	out.EstimatedTokens = textTokens + (out.EstimatedChunks-out.Chunks)*perChunk
+	if cost, ok := indexer.EmbeddingCost(out.EmbedModel, out.EstimatedTokens); ok {
+		out.EstimatedCost = &cost
+	}
+
+	if out.OCRPages > 0 && !out.OCRAvailable {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("%d pages have no text layer and OCR is not configured; they will be skipped", out.OCRPages))
+	}
+	if embedAPIKey(settings) == "" {
+		out.Warnings = append(out.Warnings, "No API key is configured for the embedding provider")
+	}
+	return out
+}
+
+// previewFile reads one file's text and chunks it as settings would.
+func previewFile(path, fname, piiMode string, settings *SavedSettings) filePreview {
+	p := filePreview{Name: fname, Status: "ok"}
+	var pages []extractor.DocumentChunk
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".pdf":
+		text, err := extractor.ExtractPDFText(path)
+		if err != nil {
+			// ExtractPDF would try OCR on a PDF the library can't open.
+			p.Status, p.Error, p.NeedsOCR = "failed", err.Error(), true
+			return p
+		}
+		pages = text.Pages
+		p.Pages = text.NumPages
+		p.EmptyPages = text.EmptyPages
+		p.NeedsOCR = len(text.EmptyPages) > 0
+	case ".docx":
+		var err error
+		pages, err = extractor.ExtractDOCX(path)
+		if err != nil {
+			p.Status, p.Error = "failed", err.Error()
+			return p
+		}
+		p.Pages = len(pages)
+	}
+	p.TextPages = len(pages)
+
+	if piiMode == piiMask {
+		maskPII(pages)
+	}
+	chunks := (&indexer.Index{ChunkWords: settings.ChunkWords, ChunkOverlap: settings.ChunkOverlap}).ChunkPages(pages)
+	p.Chunks = len(chunks)
+	p.Tokens = indexer.EstimateEmbeddingTokens(chunks)
+	return p
+}
+
+// handleIngestDryRun answers POST /api/ingest with dry_run set.
+func (s *Server) handleIngestDryRun(w http.ResponseWriter, r *http.Request, projectID string) {
+	store := s.getProjectStore(r)
+	proj, err := store.Get(projectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	uploadsDir := store.UploadsDir(projectID)
+	entries, _ := os.ReadDir(uploadsDir)
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if ext == ".pdf" || ext == ".docx" {
+			files = append(files, e.Name())
+		}
+	}
+	if len(files) == 0 {
+		jsonErr(w, "No files to process", http.StatusBadRequest)
+		return
+	}
+
+	jsonResp(w, s.previewIngestion(s.projectSettings(r, projectID), uploadsDir, files, s.indexedDocuments(projectID), proj.PIIMode))
+}
+
+// indexedDocuments lists the documents in a project's loaded index, which
+// incremental ingestion skips.
+func (s *Server) indexedDocuments(projectID string) map[string]bool {
+	indexed := map[string]bool{}
+	if rw, err := s.getRetrieverForProject(projectID); err == nil {
+		for _, c := range rw.ret.Chunks {
+			indexed[c.Document] = true
+		}
+	}
+	return indexed
+}
+
+// ========== Cost & Time Estimate ==========
+
+// ingestEstimate is what an ingestion run is expected to cost and take,
+// returned when it starts and kept in IngestStatus.
+type ingestEstimate struct {
+	Chunks          int      `json:"chunks"`
+	Tokens          int      `json:"tokens"`
+	EmbedModel      string   `json:"embed_model"`
+	CostUSD         *float64 `json:"cost_usd"` // nil when the model has no known price
+	OCRPages        int      `json:"ocr_pages"`
+	Seconds         float64  `json:"seconds"`
+	ChunksPerSecond float64  `json:"chunks_per_second"`
+	Measured        bool     `json:"measured"` // throughput comes from a previous run with this model
+}
+
+// Throughput assumed before a model has been measured, and per OCR'd page.
+const (
+	defaultOpenAIChunksPerSecond = 300
+	defaultHFChunksPerSecond     = 60
+	ocrSecondsPerPage            = 1.5 // tesseract at 200 dpi, 4 files at a time
+)
+
+// throughputTracker remembers the embedding throughput measured per model,
+// smoothed across runs.
+type throughputTracker struct {
+	mu    sync.Mutex
+	rates map[string]float64 // chunks per second
+}
+
+func newThroughputTracker() *throughputTracker {
+	return &throughputTracker{rates: make(map[string]float64)}
+}
+
+// observe records a run that embedded chunks in elapsed.
+func (t *throughputTracker) observe(model string, chunks int, elapsed time.Duration) {
+	if chunks < 50 || elapsed <= 0 {
+		return // too small to say anything about throughput
+	}
+	rate := float64(chunks) / elapsed.Seconds()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.rates[model]; ok {
+		rate = 0.7*prev + 0.3*rate
+	}
+	t.rates[model] = rate
+}
+
+// rate returns model's chunks per second and whether it was measured.
+func (t *throughputTracker) rate(provider, model string) (float64, bool) {
+	t.mu.Lock()
+	r, ok := t.rates[model]
+	t.mu.Unlock()
+	if ok {
+		return r, true
+	}
+	if strings.EqualFold(provider, "huggingface") {
+		return defaultHFChunksPerSecond, false
+	}
+	return defaultOpenAIChunksPerSecond, false
+}
+
+// estimate turns a dry run into a cost and time estimate.
+func (s *Server) estimate(settings *SavedSettings, p *ingestPreview) *ingestEstimate {
+	rate, measured := s.throughput.rate(settings.EmbedProvider, p.EmbedModel)
+	est := &ingestEstimate{
+		Chunks:          p.EstimatedChunks,
+		Tokens:          p.EstimatedTokens,
+		EmbedModel:      p.EmbedModel,
+		CostUSD:         p.EstimatedCost,
+		ChunksPerSecond: rate,
+		Measured:        measured,
+		Seconds:         float64(p.EstimatedChunks) / rate,
+	}
+	if p.OCRAvailable {
+		est.OCRPages = p.OCRPages
+		est.Seconds += float64(p.OCRPages) * ocrSecondsPerPage
+	}
+	return est
+}
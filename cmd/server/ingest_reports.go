@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/indexer"
+)
+
+// ========== Ingestion Reports ==========
+
+// The ingest status keeps only the current run, so each run that gets as
+// far as processing files also writes a report under
+// <project>/ingest_runs/: when it ran, the settings it ran with, each
+// file's outcome and timings, and the embedding tokens it used.
+
+// ingestRunSettings are the settings an ingestion run used.
+type ingestRunSettings struct {
+	EmbedProvider          string `json:"embed_provider"`
+	EmbedModel             string `json:"embed_model"`
+	MultilingualEmbedModel string `json:"multilingual_embed_model,omitempty"`
+	OCRProvider            string `json:"ocr_provider,omitempty"`
+	TesseractLang          string `json:"tesseract_lang,omitempty"`
+	PIIMode                string `json:"pii_mode,omitempty"`
+	OCRCorrect             bool   `json:"ocr_correct,omitempty"`
+	SummaryProvider        string `json:"summary_provider,omitempty"` // "" when no summaries were made
+	SummaryModel           string `json:"summary_model,omitempty"`
+	HierarchicalSummaries  bool   `json:"hierarchical_summaries,omitempty"`
+	ExtractWorkers         int    `json:"extract_workers"`
+	EmbedBatchSize         int    `json:"embed_batch_size,omitempty"`
+	EmbedConcurrency       int    `json:"embed_concurrency,omitempty"`
+}
+
+// fileReport is one file's part in an ingestion run.
+type fileReport struct {
+	FileResult
+	Pages          int     `json:"pages"`
+	OCRPages       int     `json:"ocr_pages"`
+	Tokens         int     `json:"embed_tokens"` // estimated as for the run's estimate
+	ExtractSeconds float64 `json:"extract_seconds"`
+	EmbedSeconds   float64 `json:"embed_seconds,omitempty"`
+}
+
+// ingestReport is the record of one ingestion run.
+type ingestReport struct {
+	ID         string            `json:"id"`
+	ProjectID  string            `json:"project_id"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Seconds    float64           `json:"seconds"`
+	Status     string            `json:"status"` // done, error or cancelled
+	Error      string            `json:"error,omitempty"`
+	Settings   ingestRunSettings `json:"settings"`
+	Estimate   *ingestEstimate   `json:"estimate,omitempty"`
+	FilesOK    int               `json:"files_ok"`
+	FilesFail  int               `json:"files_failed"`
+	Chunks     int               `json:"chunks"` // chunks the run added
+	Tokens     int               `json:"embed_tokens"`
+	CostUSD    *float64          `json:"cost_usd,omitempty"` // omitted when the model's price is unknown
+	Files      []fileReport      `json:"files,omitempty"`
+}
+
+// runSettings is what an ingestion with settings and piiMode runs with.
+func runSettings(settings *SavedSettings, piiMode string) ingestRunSettings {
+	rs := ingestRunSettings{
+		EmbedProvider:          settings.EmbedProvider,
+		EmbedModel:             indexer.EmbedModelName(settings.EmbedProvider, settings.EmbedModel),
+		MultilingualEmbedModel: settings.MultilingualEmbedModel,
+		OCRProvider:            settings.OCRProvider,
+		TesseractLang:          settings.TesseractLang,
+		PIIMode:                piiMode,
+		ExtractWorkers:         settings.extractWorkers(),
+		EmbedBatchSize:         settings.EmbedBatchSize,
+		EmbedConcurrency:       settings.EmbedConcurrency,
+	}
+	if rs.EmbedProvider == "" {
+		rs.EmbedProvider = "openai"
+	}
+	if c, ok := summaryCompleter(settings); ok {
+		rs.SummaryProvider, rs.SummaryModel = c.Provider, c.Model
+		rs.HierarchicalSummaries = settings.HierarchicalSummaries
+	}
+	return rs
+}
+
+// finish totals a report's files and notes how the run ended.
+func (rep *ingestReport) finish(status, errMsg string) {
+	rep.FinishedAt = time.Now()
+	rep.Seconds = rep.FinishedAt.Sub(rep.StartedAt).Seconds()
+	rep.Status, rep.Error = status, errMsg
+	rep.FilesOK, rep.FilesFail, rep.Chunks, rep.Tokens = 0, 0, 0, 0
+	for _, f := range rep.Files {
+		if f.Status != "ok" {
+			rep.FilesFail++
+			continue
+		}
+		rep.FilesOK++
+		rep.Chunks += f.Chunks
+		rep.Tokens += f.Tokens
+	}
+	if cost, ok := indexer.EmbeddingCost(rep.Settings.EmbedModel, rep.Tokens); ok {
+		rep.CostUSD = &cost
+	}
+	sort.Slice(rep.Files, func(i, j int) bool { return rep.Files[i].Name < rep.Files[j].Name })
+}
+
+func ingestRunsDir(store *chat.ProjectStore, projectID string) string {
+	return filepath.Join(store.ProjectDir(projectID), "ingest_runs")
+}
+
+func saveIngestReport(store *chat.ProjectStore, rep *ingestReport) error {
+	dir := ingestRunsDir(store, rep.ProjectID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, rep.ID+".json"), data, 0644)
+}
+
+// loadIngestReports returns a project's ingestion reports, newest first.
+func loadIngestReports(store *chat.ProjectStore, projectID string) []ingestReport {
+	dir := ingestRunsDir(store, projectID)
+	entries, _ := os.ReadDir(dir)
+	reports := []ingestReport{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var rep ingestReport
+		if json.Unmarshal(data, &rep) == nil {
+			reports = append(reports, rep)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].StartedAt.After(reports[j].StartedAt) })
+	return reports
+}
+
+// handleIngestRuns lists a project's ingestion runs newest first without
+// per-file outcomes (GET ?project_id=), or returns one run in full
+// (&run_id=).
+func (s *Server) handleIngestRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	store := s.getProjectStore(r)
+	projectID := r.URL.Query().Get("project_id")
+	if _, err := store.Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	reports := loadIngestReports(store, projectID)
+	if runID := r.URL.Query().Get("run_id"); runID != "" {
+		for _, rep := range reports {
+			if rep.ID == runID {
+				jsonResp(w, rep)
+				return
+			}
+		}
+		jsonErr(w, "Run not found", http.StatusNotFound)
+		return
+	}
+
+	for i := range reports {
+		reports[i].Files = nil
+	}
+	jsonResp(w, map[string]interface{}{"runs": reports})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Live Querying During Ingestion ==========
+
+// An incremental ingestion appends to the project's active index while it
+// keeps answering queries. Retrievers search a View of the index, so that
+// is safe, but a retriever only sees the chunks there were when it was
+// built; liveIndex rebuilds the active one as files finish embedding, at
+// most every liveRefreshInterval, so new documents become searchable
+// before the whole ingestion is done.
+
+const liveRefreshInterval = 10 * time.Second
+
+type liveIndex struct {
+	s         *Server
+	projectID string
+	idx       *indexer.Index
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (s *Server) newLiveIndex(projectID string, idx *indexer.Index) *liveIndex {
+	return &liveIndex{s: s, projectID: projectID, idx: idx, last: time.Now()}
+}
+
+// active reports whether the index is still the project's active one; a
+// fresh ingestion's index only becomes active once it is complete.
+func (l *liveIndex) active() bool {
+	l.s.mu.RLock()
+	defer l.s.mu.RUnlock()
+	return l.s.activeProjectID == l.projectID && l.s.activeIndex == l.idx
+}
+
+// refresh republishes the active retriever with the chunks embedded so far,
+// unless it was done within liveRefreshInterval.
+func (l *liveIndex) refresh() {
+	l.mu.Lock()
+	if time.Since(l.last) < liveRefreshInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.last = time.Now()
+	l.mu.Unlock()
+
+	if !l.active() {
+		return
+	}
+	ret := retriever.NewRetriever(l.idx)
+	l.s.mu.Lock()
+	if l.s.activeProjectID == l.projectID && l.s.activeIndex == l.idx {
+		l.s.activeRetriever = ret
+		if l.s.indexCache.has(l.projectID) {
+			l.s.indexCache.put(l.projectID, &cachedIndex{idx: l.idx, ret: ret})
+		}
+	}
+	l.s.mu.Unlock()
+}
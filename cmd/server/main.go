@@ -1,15 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"gocognigo/internal/chat"
+	"gocognigo/internal/crypto"
 	"gocognigo/internal/extractor"
 
 	"github.com/joho/godotenv"
@@ -20,6 +26,50 @@ import (
 func main() {
 	_ = godotenv.Load()
 
+	// One instance per data directory: a second would overwrite this one's
+	// projects.json and corrupt its indexes
+	dataLock, err := chat.LockDataDir("data", "server")
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	defer dataLock.Release()
+
+	// Before anything reads the data it replaces
+	if len(os.Args) > 1 && os.Args[1] == "restore-backup" {
+		runRestoreBackup(os.Args[2:])
+		return
+	}
+
+	// With durable storage, data/ starts as a copy of what it holds
+	if err := openDataStorage(); err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	if err := crypto.Init("data"); err != nil {
+		if errors.Is(err, crypto.ErrKeyMismatch) {
+			log.Fatalf("FATAL: %v. Set %s to the passphrase used when the keys were saved, "+
+				"or delete data/.keycheck and re-enter your API keys in Settings.", err, crypto.PassphraseEnv)
+		}
+		log.Fatalf("FATAL: could not initialise settings encryption: %v", err)
+	}
+	envOnlyKeys, _ = strconv.ParseBool(os.Getenv(envOnlyKeysEnv))
+	if envOnlyKeys {
+		log.Printf("Environment-only key mode: API keys are never written to disk")
+	}
+	migrateSettingsFiles()
+
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		runRotateKey(os.Args[2:])
+		return
+	}
+
+	readOnly, _ = strconv.ParseBool(os.Getenv(readOnlyEnv))
+	flag.BoolVar(&readOnly, "read-only", readOnly, "serve queries over existing projects only; reject uploads, ingestion, settings changes and deletes")
+	flag.Parse()
+	if readOnly {
+		log.Printf("Read-only mode: uploads, ingestion, settings changes and deletes are disabled")
+	}
+
 	tesseractOk := extractor.DetectTesseract()
 	hasPdftoppm := extractor.DetectPdftoppm()
 	
@@ -27,12 +77,44 @@ func main() {
 		log.Printf("OCR WARNING: Tesseract found but Poppler (pdftoppm) is missing — cannot convert PDFs to images")
 	}
 
+	scanner, err := newUploadScanner()
+	if err != nil {
+		log.Fatalf("Upload scanning: %v", err)
+	}
+	if scanner != nil {
+		log.Printf("Upload scanning enabled (%s)", scanner.engine.name())
+	}
+
+	directUploads := newDirectUploads()
+	if directUploads != nil {
+		log.Printf("Direct uploads enabled (bucket %s at %s)", directUploads.bucket.Bucket, directUploads.bucket.Endpoint)
+	}
+
+	workspaces, err := chat.NewWorkspaceStore("data/workspaces.json")
+	if err != nil {
+		log.Fatalf("Workspaces: %v", err)
+	}
+
 	srv := &Server{
 		userProjects:  make(map[string]*chat.ProjectStore),
 		userSettings:  make(map[string]*SavedSettings),
 		ingestStatus:  &IngestStatus{Phase: "idle"},
 		tesseractOk:   tesseractOk,
-		indexCache:    newLRUCache(maxCacheSize),
+		indexCache:    newLRUCache(indexCacheLimits()),
+		scanner:       scanner,
+		directUploads: directUploads,
+		throughput:    newThroughputTracker(),
+		workspaces:    workspaces,
+	}
+	srv.batchJobs = newJobQueue(srv)
+	srv.startScheduler()
+	startStorageSync()
+	if err := startBackups(); err != nil {
+		log.Fatalf("Backups: %v", err)
+	}
+	if n := prewarmCount(); n > 0 {
+		log.Printf("Pre-warming the indexes of the %d most recently used projects", n)
+		go srv.prewarmIndexes(n)
 	}
 
 	mux := http.NewServeMux()
@@ -40,25 +122,70 @@ func main() {
 	// Existing API endpoints
 	mux.HandleFunc("/api/query", srv.authMiddleware(srv.handleQuery))
 	mux.HandleFunc("/api/query/stream", srv.authMiddleware(srv.handleStreamQuery))
+	mux.HandleFunc("/api/query/compare", srv.authMiddleware(srv.handleCompareQuery))
+	mux.HandleFunc("/v1/chat/completions", srv.authMiddleware(srv.handleOpenAIChatCompletions))
+	mux.HandleFunc("/v1/models", srv.authMiddleware(srv.handleOpenAIModels))
 	mux.HandleFunc("/api/batch", srv.authMiddleware(srv.handleBatch))
+	mux.HandleFunc("/api/batch/jobs", srv.authMiddleware(srv.handleBatchJobs))
+	mux.HandleFunc("/api/batch/jobs/status", srv.authMiddleware(srv.handleBatchJobStatus))
+	mux.HandleFunc("/api/batch/jobs/results", srv.authMiddleware(srv.handleBatchJobResults))
+	mux.HandleFunc("/api/batch/cancel", srv.authMiddleware(srv.handleBatchCancel))
+	mux.HandleFunc("/api/schedules", srv.authMiddleware(srv.handleSchedules))
+	mux.HandleFunc("/api/schedules/run", srv.authMiddleware(srv.handleScheduleRun))
+	mux.HandleFunc("/api/schedules/runs", srv.authMiddleware(srv.handleScheduleRuns))
+	mux.HandleFunc("/api/eval/set", srv.authMiddleware(srv.handleEvalSet))
+	mux.HandleFunc("/api/eval/run", srv.authMiddleware(srv.handleEvalRun))
+	mux.HandleFunc("/api/eval/runs", srv.authMiddleware(srv.handleEvalRuns))
 	mux.HandleFunc("/api/stats", srv.authMiddleware(srv.handleStats))
 	mux.HandleFunc("/api/providers", srv.authMiddleware(srv.handleProviders))
 
 	// Upload & ingestion endpoints
 	mux.HandleFunc("/api/upload", srv.authMiddleware(srv.handleUpload))
+	mux.HandleFunc("/api/upload/presign", srv.authMiddleware(srv.handlePresignUpload))
+	mux.HandleFunc("/api/upload/complete", srv.authMiddleware(srv.handleCompleteUpload))
 	mux.HandleFunc("/api/ingest", srv.authMiddleware(srv.handleIngest))
 	mux.HandleFunc("/api/ingest/status", srv.authMiddleware(srv.handleIngestStatus))
 	mux.HandleFunc("/api/ingest/ws", srv.authMiddleware(srv.handleIngestWS))
 	mux.HandleFunc("/api/files", srv.authMiddleware(srv.handleFiles))
 	mux.HandleFunc("/api/file/view", srv.authMiddleware(srv.handleFileView))
+	mux.HandleFunc("/api/files/raw", srv.authMiddleware(srv.handleFileRaw))
+	mux.HandleFunc("/api/files/page", srv.authMiddleware(srv.handlePageImage))
 	mux.HandleFunc("/api/ingest/cancel", srv.authMiddleware(srv.handleCancelIngest))
+	mux.HandleFunc("/api/ingest/pause", srv.authMiddleware(srv.handlePauseIngest))
+	mux.HandleFunc("/api/ingest/resume", srv.authMiddleware(srv.handleResumeIngest))
+	mux.HandleFunc("/api/ingest/reorder", srv.authMiddleware(srv.handleReorderIngest))
 	mux.HandleFunc("/api/ingest/retry", srv.authMiddleware(srv.handleRetryIngest))
+	mux.HandleFunc("/api/ingest/runs", srv.authMiddleware(srv.handleIngestRuns))
 	mux.HandleFunc("/api/files/delete", srv.authMiddleware(srv.handleDeleteSingleFile))
+	mux.HandleFunc("/api/files/reprocess", srv.authMiddleware(srv.handleReprocessFile))
+	mux.HandleFunc("/api/files/versions", srv.authMiddleware(srv.handleFileVersions))
+	mux.HandleFunc("/api/documents/summarize", srv.authMiddleware(srv.handleSummarizeDocument))
+	mux.HandleFunc("/api/documents/summaries/regenerate", srv.authMiddleware(srv.handleRegenerateSummaries))
+	mux.HandleFunc("/api/documents/text", srv.authMiddleware(srv.handleDocumentText))
+	mux.HandleFunc("/api/documents/tags", srv.authMiddleware(srv.handleDocumentTags))
+	mux.HandleFunc("/api/compare", srv.authMiddleware(srv.handleCompareDocuments))
+	mux.HandleFunc("/api/entities", srv.authMiddleware(srv.handleEntities))
+	mux.HandleFunc("/api/timeline", srv.authMiddleware(srv.handleTimeline))
+	mux.HandleFunc("/api/graph", srv.authMiddleware(srv.handleGraph))
+	mux.HandleFunc("/api/clauses", srv.authMiddleware(srv.handleClauses))
+	mux.HandleFunc("/api/glossary", srv.authMiddleware(srv.handleGlossary))
 	mux.HandleFunc("/api/settings", srv.authMiddleware(srv.handleSettings))
 	mux.HandleFunc("/api/settings/validate", srv.authMiddleware(srv.handleValidateKey))
+	mux.HandleFunc("/api/settings/test", srv.authMiddleware(srv.handleTestSettings))
+	mux.HandleFunc("/api/settings/profiles", srv.authMiddleware(srv.handleProfiles))
+	mux.HandleFunc("/api/settings/profiles/apply", srv.authMiddleware(srv.handleApplyProfile))
+	mux.HandleFunc("/api/admin/rotate-key", srv.authMiddleware(srv.handleRotateKey))
 	mux.HandleFunc("/api/search", srv.authMiddleware(srv.handleSearch))
+	mux.HandleFunc("/api/debug/retrieval", srv.authMiddleware(srv.handleDebugRetrieval))
+	mux.HandleFunc("/api/chunks", srv.authMiddleware(srv.handleChunks))
 	mux.HandleFunc("/api/conversations/export", srv.authMiddleware(srv.handleExportConversation))
 	mux.HandleFunc("/api/index-status", srv.authMiddleware(srv.handleIndexStatus))
+	mux.HandleFunc("/api/index/verify", srv.authMiddleware(srv.handleVerifyIndex))
+	mux.HandleFunc("/api/index/rebuild", srv.authMiddleware(srv.handleRebuildIndex))
+	mux.HandleFunc("/api/index/reembed", srv.authMiddleware(srv.handleReembedIndex))
+	mux.HandleFunc("/api/index/rechunk", srv.authMiddleware(srv.handleRechunkIndex))
+	mux.HandleFunc("/api/index/cache", srv.authMiddleware(srv.handleIndexCache))
+	mux.HandleFunc("/api/index/cache/evict", srv.authMiddleware(srv.handleEvictIndexCache))
 
 	// Project endpoints
 	mux.HandleFunc("/api/chats", srv.authMiddleware(srv.handleProjects))
@@ -71,17 +198,44 @@ func main() {
 	mux.HandleFunc("/api/conversations/delete", srv.authMiddleware(srv.handleDeleteConversation))
 	mux.HandleFunc("/api/conversations/messages", srv.authMiddleware(srv.handleMessages))
 	mux.HandleFunc("/api/conversations/rename", srv.authMiddleware(srv.handleRenameConversation))
+	mux.HandleFunc("/api/conversations/pin", srv.authMiddleware(srv.handlePinConversation))
+	mux.HandleFunc("/api/conversations/feedback", srv.authMiddleware(srv.handleMessageFeedback))
+	mux.HandleFunc("/api/conversations/star", srv.authMiddleware(srv.handleStarMessage))
+	mux.HandleFunc("/api/starred", srv.authMiddleware(srv.handleStarred))
+	mux.HandleFunc("/api/citations/appendix", srv.authMiddleware(srv.handleCitationAppendix))
+	mux.HandleFunc("/api/conversations/regenerate", srv.authMiddleware(srv.handleRegenerate))
+	mux.HandleFunc("/api/conversations/edit", srv.authMiddleware(srv.handleEditMessage))
+	mux.HandleFunc("/api/conversations/search", srv.authMiddleware(srv.handleSearchConversations))
 
 	// Community endpoints
 	mux.HandleFunc("/api/projects/meta", srv.authMiddleware(srv.handleUpdateProjectMeta))
+	mux.HandleFunc("/api/projects/quotas", srv.authMiddleware(srv.handleProjectQuotas))
+	mux.HandleFunc("/api/projects/keys", srv.authMiddleware(srv.handleProjectKeys))
+	mux.HandleFunc("/api/projects/export", srv.authMiddleware(srv.handleExportProject))
+	mux.HandleFunc("/api/projects/import", srv.authMiddleware(srv.handleImportProject))
+	mux.HandleFunc("/api/usage", srv.authMiddleware(srv.handleUsageReport))
+	mux.HandleFunc("/api/projects/prompt", srv.authMiddleware(srv.handleProjectPrompt))
+	mux.HandleFunc("/api/projects/privacy", srv.authMiddleware(srv.handleProjectPrivacy))
+	mux.HandleFunc("/api/projects/ocr", srv.authMiddleware(srv.handleProjectOCR))
+	mux.HandleFunc("/api/projects/summary", srv.authMiddleware(srv.handleCorpusSummary))
 	mux.HandleFunc("/api/projects/publish", srv.authMiddleware(srv.handlePublishProject))
 	mux.HandleFunc("/api/community", srv.authMiddleware(srv.handleCommunityHub))
 	mux.HandleFunc("/api/community/clone", srv.authMiddleware(srv.handleCloneProject))
 	mux.HandleFunc("/api/community/tags", srv.authMiddleware(srv.handleCommunityTags))
 
+	// Workspace endpoints
+	mux.HandleFunc("/api/workspaces", srv.authMiddleware(srv.handleWorkspaces))
+	mux.HandleFunc("/api/workspaces/delete", srv.authMiddleware(srv.handleDeleteWorkspace))
+	mux.HandleFunc("/api/workspaces/members", srv.authMiddleware(srv.handleWorkspaceMembers))
+	mux.HandleFunc("/api/workspaces/members/remove", srv.authMiddleware(srv.handleRemoveWorkspaceMember))
+	mux.HandleFunc("/api/workspaces/quotas", srv.authMiddleware(srv.handleWorkspaceQuotas))
+
 	// Feedback / feature requests
 	mux.HandleFunc("/api/feedback", srv.authMiddleware(srv.handleFeedback))
 
+	// Audit log
+	mux.HandleFunc("/api/audit", srv.authMiddleware(srv.handleAudit))
+
 	// Auth endpoints (public)
 	mux.HandleFunc("/api/auth/config", srv.handleAuthConfig)
 
@@ -94,7 +248,7 @@ func main() {
 	}
 	httpSrv := &http.Server{
 		Addr:              ":" + port,
-		Handler:           corsMiddleware(mux),
+		Handler:           corsMiddleware(readOnlyMiddleware(mux)),
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       120 * time.Second,
 	}
@@ -132,4 +286,40 @@ func main() {
 	} else {
 		log.Printf("Server stopped gracefully")
 	}
+
+	// Save the last changes before the container goes
+	pushDataStorage()
+}
+
+// runRotateKey implements the "rotate-key" subcommand: it re-encrypts all
+// stored API keys with new key material and exits. Run it with the server
+// stopped.
+//
+//	server rotate-key                       # new random key in keychain / key file
+//	echo "new pass" | server rotate-key -passphrase-stdin
+func runRotateKey(args []string) {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	fromStdin := fs.Bool("passphrase-stdin", false, "read the new passphrase from stdin")
+	_ = fs.Parse(args)
+
+	var passphrase string
+	if *fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("FATAL: could not read passphrase from stdin: %v", err)
+		}
+		passphrase = strings.TrimRight(line, "\r\n")
+		if passphrase == "" {
+			log.Fatalf("FATAL: empty passphrase")
+		}
+	}
+
+	n, err := rotateSettingsKey(passphrase)
+	if err != nil {
+		log.Fatalf("FATAL: key rotation failed: %v", err)
+	}
+	log.Printf("Re-encrypted %d settings file(s); key now from %s", n, crypto.Source())
+	if passphrase != "" {
+		log.Printf("Update %s to the new passphrase before starting the server", crypto.PassphraseEnv)
+	}
 }
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Project Archives & Index Maintenance ==========
+
+// Operators move projects between instances as zip archives, and check or
+// repair a project's index without re-ingesting: verify compares the
+// vectors, keyword index and uploads; rebuild recreates the keyword index
+// from the vectors; reembed embeds every chunk again with the current
+// embedding settings, e.g. after switching models; rechunk cuts the pages
+// again with the current chunk settings, embedding only the chunks whose
+// text changed. Index operations refuse to run alongside an ingestion,
+// which owns the index it is writing.
+
+// ingestRunning reports whether an ingestion or embedding retry is running.
+func (s *Server) ingestRunning() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ingestCancel != nil
+}
+
+// evictProjectIndex closes a project's loaded index, if any, so its keyword
+// index can be replaced on disk. The project stays active.
+func (s *Server) evictProjectIndex(projectID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var closed *indexer.Index
+	if s.activeProjectID == projectID && s.activeIndex != nil {
+		closed = s.activeIndex
+		_ = closed.Close()
+		s.activeIndex = nil
+		s.activeRetriever = nil
+	}
+	if cached, ok := s.indexCache.get(projectID); ok && cached.idx != closed {
+		_ = cached.idx.Close()
+	}
+	s.indexCache.delete(projectID)
+}
+
+// installProjectIndex caches a freshly built index, and makes it the active
+// one when its project is active.
+func (s *Server) installProjectIndex(projectID string, idx *indexer.Index) {
+	ret := retriever.NewRetriever(idx)
+	s.mu.Lock()
+	if s.activeProjectID == projectID {
+		s.activeIndex = idx
+		s.activeRetriever = ret
+	}
+	s.indexCache.put(projectID, &cachedIndex{idx: idx, ret: ret})
+	s.mu.Unlock()
+}
+
+// rebuildKeywordIndex recreates a project's keyword index from its vectors
+// file and returns the index, loaded and ready to search.
+func rebuildKeywordIndex(store *chat.ProjectStore, settings *SavedSettings, projectID string) (*indexer.Index, error) {
+	bm25Dir := store.BM25Dir(projectID)
+	if err := os.RemoveAll(bm25Dir); err != nil {
+		return nil, fmt.Errorf("failed to remove the keyword index: %w", err)
+	}
+	idx, err := newProjectIndex(storedEmbeddingSettings(settings, store.VectorsPath(projectID), projectID), bm25Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the keyword index: %w", err)
+	}
+	applyOCRSettings(store, projectID, idx)
+	if err := idx.LoadVectors(store.VectorsPath(projectID)); err != nil {
+		_ = idx.Close()
+		return nil, fmt.Errorf("failed to load vectors: %w", err)
+	}
+	if err := idx.ReindexBM25(); err != nil {
+		_ = idx.Close()
+		return nil, fmt.Errorf("failed to index chunks: %w", err)
+	}
+	return idx, nil
+}
+
+// handleExportProject downloads a project as a zip archive
+// (GET ?project_id=): its metadata, uploads, vectors and conversations.
+func (s *Server) handleExportProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := r.URL.Query().Get("project_id")
+	store := s.getProjectStore(r)
+	proj, err := store.Get(projectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sanitizeFilename(proj.Name)+".zip"))
+	if err := store.ExportProject(projectID, w); err != nil {
+		// Headers are sent; the truncated archive won't open
+		log.Printf("Export of project %s failed: %v", projectID, err)
+		return
+	}
+	recordAudit(r, "project.export", projectID, proj.Name, nil)
+}
+
+// handleImportProject creates a project from an archive made by export
+// (POST, the zip as the body, ?name= to rename it) and rebuilds its keyword
+// index, so it can be queried right away.
+func (s *Server) handleImportProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// zip needs random access, so spool the body to disk
+	tmp, err := os.CreateTemp("", "gocognigo-import-*.zip")
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, r.Body)
+	if err != nil {
+		jsonErr(w, "Failed to read the archive: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	store := s.getProjectStore(r)
+	proj, err := store.ImportProject(tmp, size, r.URL.Query().Get("name"))
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if proj.Status == "ready" {
+		idx, err := rebuildKeywordIndex(store, s.projectSettings(r, proj.ID), proj.ID)
+		if err != nil {
+			log.Printf("Warning: imported project %s has no usable index: %v", proj.ID, err)
+			proj.Status = "upload"
+		} else {
+			proj.ChunkCount = len(idx.Chunks)
+			s.installProjectIndex(proj.ID, idx)
+		}
+		_ = store.Update(*proj)
+	}
+	recordAudit(r, "project.import", proj.ID, proj.Name, nil)
+	jsonResp(w, proj)
+}
+
+// handleVerifyIndex checks a project's index for problems (GET
+// ?project_id=): chunks without or with mismatched embeddings, a keyword
+// index out of step with the vectors, uploads never indexed and indexed
+// documents whose file is gone. ok is false when problems is non-empty.
+func (s *Server) handleVerifyIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := r.URL.Query().Get("project_id")
+	store := s.getProjectStore(r)
+	proj, err := store.Get(projectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	settings := s.projectSettings(r, projectID)
+	var idx *indexer.Index
+	if rw, err := s.getRetrieverForProject(projectID); err == nil {
+		idx = rw.idx
+	} else {
+		if s.ingestRunning() {
+			jsonErr(w, "An ingestion is running; verify the index when it finishes", http.StatusConflict)
+			return
+		}
+		if _, err := os.Stat(store.VectorsPath(projectID)); err != nil {
+			jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+			return
+		}
+		idx, err = newProjectIndex(storedEmbeddingSettings(settings, store.VectorsPath(projectID), projectID), store.BM25Dir(projectID))
+		if err != nil {
+			jsonErr(w, "Failed to open the keyword index: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer idx.Close()
+		if err := idx.LoadVectors(store.VectorsPath(projectID)); err != nil {
+			jsonErr(w, "Failed to load vectors: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	health := idx.Check()
+	indexed := map[string]bool{}
+	idx.Lock()
+	for _, c := range idx.Chunks {
+		indexed[c.Document] = true
+	}
+	idx.Unlock()
+	uploads := map[string]bool{}
+	notIndexed := []string{}
+	entries, _ := os.ReadDir(store.UploadsDir(projectID))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		uploads[e.Name()] = true
+		if !indexed[e.Name()] {
+			notIndexed = append(notIndexed, e.Name())
+		}
+	}
+	missingFiles := []string{}
+	for doc := range indexed {
+		if !uploads[doc] {
+			missingFiles = append(missingFiles, doc)
+		}
+	}
+	sort.Strings(missingFiles)
+
+	problems := health.Problems
+	if len(notIndexed) > 0 {
+		problems = append(problems, fmt.Sprintf("%d uploaded files are not indexed", len(notIndexed)))
+	}
+	if len(missingFiles) > 0 {
+		problems = append(problems, fmt.Sprintf("%d indexed documents have no uploaded file", len(missingFiles)))
+	}
+	if proj.ChunkCount != health.Chunks {
+		problems = append(problems, fmt.Sprintf("project records %d chunks, the index has %d", proj.ChunkCount, health.Chunks))
+	}
+	jsonResp(w, map[string]interface{}{
+		"ok":                     len(problems) == 0,
+		"problems":               problems,
+		"index":                  health,
+		"files_not_indexed":      notIndexed,
+		"documents_without_file": missingFiles,
+		"project_chunk_count":    proj.ChunkCount,
+		"embed_model":            indexer.EmbedModelName(settings.EmbedProvider, settings.EmbedModel),
+	})
+}
+
+// handleRebuildIndex recreates a project's keyword index from its vectors
+// (POST {project_id}), without calling the embedding API.
+func (s *Server) handleRebuildIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	store := s.getProjectStore(r)
+	proj, err := store.Get(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if s.ingestRunning() {
+		jsonErr(w, "An ingestion is running; rebuild the index when it finishes", http.StatusConflict)
+		return
+	}
+	if _, err := os.Stat(store.VectorsPath(req.ProjectID)); err != nil {
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+
+	s.evictProjectIndex(req.ProjectID)
+	idx, err := rebuildKeywordIndex(store, s.projectSettings(r, req.ProjectID), req.ProjectID)
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.installProjectIndex(req.ProjectID, idx)
+
+	proj.ChunkCount = len(idx.Chunks)
+	proj.Status = "ready"
+	_ = store.Update(*proj)
+	recordAudit(r, "index.rebuild", req.ProjectID, proj.Name, nil)
+	jsonResp(w, map[string]interface{}{"status": "rebuilt", "chunks": len(idx.Chunks)})
+}
+
+// handleReembedIndex embeds every chunk of a project again with the current
+// embedding settings (POST {project_id}), keeping the chunks and summaries.
+// It runs in the background like an ingestion: progress is reported by
+// /api/ingest/status, and a failure can be resumed with /api/ingest/retry.
+func (s *Server) handleReembedIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	store := s.getProjectStore(r)
+	proj, err := store.Get(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if s.ingestRunning() {
+		jsonErr(w, "An ingestion is running; re-embed when it finishes", http.StatusConflict)
+		return
+	}
+	settings := s.projectSettings(r, req.ProjectID)
+	if embedAPIKey(settings) == "" {
+		jsonErr(w, "No API key configured for the embedding provider", http.StatusBadRequest)
+		return
+	}
+
+	// Start over with an empty keyword index and no chunks; both fill up
+	// as the chunks are embedded again
+	s.evictProjectIndex(req.ProjectID)
+	bm25Dir := store.BM25Dir(req.ProjectID)
+	_ = os.RemoveAll(bm25Dir)
+	idx, err := newProjectIndex(settings, bm25Dir)
+	if err != nil {
+		jsonErr(w, "Failed to create index: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := idx.LoadVectors(store.VectorsPath(req.ProjectID)); err != nil {
+		_ = idx.Close()
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	chunks := idx.StripEmbeddings()
+	// Saved like ingestion's, so an interrupted re-embed can be retried
+	chunksDir := store.ChunksDir(req.ProjectID)
+	_ = os.MkdirAll(chunksDir, 0755)
+	if err := indexer.SaveChunks(filepath.Join(chunksDir, "reembed.chunks.json"), chunks); err != nil {
+		log.Printf("Warning: failed to save chunks for re-embedding: %v", err)
+	}
+
+	s.ingestStatus.reset()
+	s.ingestStatus.mu.Lock()
+	s.ingestStatus.Phase = "processing"
+	s.ingestStatus.ChunksTotal = len(chunks)
+	s.ingestStatus.mu.Unlock()
+
+	proj.Status = "processing"
+	_ = store.Update(*proj)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.ingestCancel = cancel
+	s.activeProjectID = req.ProjectID
+	s.activeIndex = idx
+	s.mu.Unlock()
+	go s.runRetryEmbedding(ctx, store, settings, req.ProjectID, store.VectorsPath(req.ProjectID), idx, chunks)
+
+	recordAudit(r, "index.reembed", req.ProjectID, proj.Name, map[string]string{
+		"embed_model": indexer.EmbedModelName(settings.EmbedProvider, settings.EmbedModel),
+	})
+	jsonResp(w, map[string]interface{}{"status": "reembedding", "chunks": len(chunks)})
+}
+
+// handleRechunkIndex cuts a project's pages again with its current chunk
+// settings, without extracting the documents again. Chunks whose text is
+// unchanged keep their embeddings; only the new chunk boundaries are
+// embedded, unless the vectors come from other models than the project's,
+// in which case everything is (as reembed would).
+func (s *Server) handleRechunkIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	store := s.getProjectStore(r)
+	proj, err := store.Get(req.ProjectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if s.ingestRunning() {
+		jsonErr(w, "An ingestion is running; re-chunk when it finishes", http.StatusConflict)
+		return
+	}
+	settings := s.projectSettings(r, req.ProjectID)
+	if embedAPIKey(settings) == "" {
+		jsonErr(w, "No API key configured for the embedding provider", http.StatusBadRequest)
+		return
+	}
+
+	s.evictProjectIndex(req.ProjectID)
+	bm25Dir := store.BM25Dir(req.ProjectID)
+	_ = os.RemoveAll(bm25Dir)
+	idx, err := newProjectIndex(settings, bm25Dir)
+	if err != nil {
+		jsonErr(w, "Failed to create index: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := idx.LoadVectors(store.VectorsPath(req.ProjectID)); err != nil {
+		_ = idx.Close()
+		jsonErr(w, "No documents indexed. Upload and process documents first.", http.StatusBadRequest)
+		return
+	}
+	reuse := idx.CheckEmbedding() == nil
+	chunks, reusable := idx.Rechunk(reuse)
+	if proj.PIIMode == piiTag {
+		pages := 0 // the page chunks come first, then the summary nodes
+		for pages < len(chunks) && !chunks[pages].IsSummary() {
+			pages++
+		}
+		tagPII(chunks[:pages])
+	}
+	// Saved like ingestion's, so an interrupted re-chunk can be retried; the
+	// retry embeds the chunks whose embeddings were reused too
+	chunksDir := store.ChunksDir(req.ProjectID)
+	_ = os.MkdirAll(chunksDir, 0755)
+	if err := indexer.SaveChunks(filepath.Join(chunksDir, "rechunk.chunks.json"), chunks); err != nil {
+		log.Printf("Warning: failed to save chunks for re-chunking: %v", err)
+	}
+	log.Printf("Re-chunking project %s: %d chunks, %d with reusable embeddings", req.ProjectID, len(chunks), reusable)
+
+	s.ingestStatus.reset()
+	s.ingestStatus.mu.Lock()
+	s.ingestStatus.Phase = "processing"
+	s.ingestStatus.ChunksTotal = len(chunks)
+	s.ingestStatus.mu.Unlock()
+
+	proj.Status = "processing"
+	_ = store.Update(*proj)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.ingestCancel = cancel
+	s.activeProjectID = req.ProjectID
+	s.activeIndex = idx
+	s.mu.Unlock()
+	go s.runRetryEmbedding(ctx, store, settings, req.ProjectID, store.VectorsPath(req.ProjectID), idx, chunks)
+
+	recordAudit(r, "index.rechunk", req.ProjectID, proj.Name, map[string]string{
+		"chunk_words":   fmt.Sprint(idx.ChunkWords),
+		"chunk_overlap": fmt.Sprint(idx.ChunkOverlap),
+	})
+	jsonResp(w, map[string]interface{}{"status": "rechunking", "chunks": len(chunks), "reusable": reusable})
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocognigo/internal/chat"
+)
+
+// ========== Email Notifications ==========
+
+// Users who start an overnight ingestion or batch job can be emailed when it
+// finishes: set the SMTP server and notify_email in settings. Ingestions
+// notify only when they processed at least notify_min_files files; batch
+// jobs always do. The message inlines the per-file or per-question results.
+
+// defaultNotifyMinFiles is the smallest ingestion that sends an email when
+// notify_min_files is 0.
+const defaultNotifyMinFiles = 10
+
+// maxNotifyRows caps the rows of the results table in an email.
+const maxNotifyRows = 500
+
+// mailConfig is where and how to send notifications, resolved from a user's
+// settings while they are at hand.
+type mailConfig struct {
+	host, username, password, from, to string
+	port                               int
+}
+
+// mailConfigFor returns the notification settings, ok only when both an SMTP
+// host and a recipient are set.
+func mailConfigFor(settings *SavedSettings) (mailConfig, bool) {
+	if settings == nil || settings.SMTPHost == "" || settings.NotifyEmail == "" {
+		return mailConfig{}, false
+	}
+	c := mailConfig{
+		host:     settings.SMTPHost,
+		port:     settings.SMTPPort,
+		username: settings.SMTPUsername,
+		password: settings.SMTPPassword,
+		from:     settings.SMTPFrom,
+		to:       settings.NotifyEmail,
+	}
+	if c.port == 0 {
+		c.port = 587
+	}
+	if c.from == "" {
+		c.from = c.username
+	}
+	return c, true
+}
+
+// recipients splits the comma-separated recipient list.
+func (c mailConfig) recipients() []string {
+	var to []string
+	for _, addr := range strings.Split(c.to, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+// dial connects and authenticates to the SMTP server. Port 465 uses
+// implicit TLS; other ports upgrade with STARTTLS when the server offers it.
+func (c mailConfig) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(c.host, strconv.Itoa(c.port))
+	var client *smtp.Client
+	if c.port == 465 {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 15 * time.Second}, "tcp", addr, &tls.Config{ServerName: c.host})
+		if err != nil {
+			return nil, err
+		}
+		if client, err = smtp.NewClient(conn, c.host); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else {
+		conn, err := net.DialTimeout("tcp", addr, 15*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		if client, err = smtp.NewClient(conn, c.host); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+	}
+	if c.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.username, c.password, c.host)); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// check connects and logs in without sending anything, for the settings test.
+func (c mailConfig) check() error {
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Quit()
+}
+
+// send delivers an HTML email.
+func (c mailConfig) send(subject, body string) error {
+	subject = mime.QEncoding.Encode("utf-8", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)) // names can't add headers
+	msg := "From: " + c.from + "\r\n" +
+		"To: " + c.to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n\r\n" + body
+
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	for _, to := range c.recipients() {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	wc, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// notify sends a notification in the background, logging a failure.
+func (c mailConfig) notify(subject, body string) {
+	go func() {
+		if err := c.send(subject, body); err != nil {
+			log.Printf("Warning: failed to send notification email to %s: %v", c.to, err)
+		}
+	}()
+}
+
+// projectName returns a project's name for a notification, or its ID.
+func projectName(store *chat.ProjectStore, projectID string) string {
+	if proj, err := store.Get(projectID); err == nil && proj.Name != "" {
+		return proj.Name
+	}
+	return projectID
+}
+
+// resultsTable renders rows as an HTML table under header, escaping every
+// cell and eliding rows past maxNotifyRows.
+func resultsTable(header []string, rows [][]string) string {
+	var sb strings.Builder
+	sb.WriteString(`<table border="1" cellpadding="4" cellspacing="0" style="border-collapse:collapse;font-family:sans-serif;font-size:13px"><tr>`)
+	for _, h := range header {
+		sb.WriteString("<th align=\"left\">" + html.EscapeString(h) + "</th>")
+	}
+	sb.WriteString("</tr>")
+	for i, row := range rows {
+		if i == maxNotifyRows {
+			sb.WriteString(fmt.Sprintf(`<tr><td colspan="%d">… and %d more</td></tr>`, len(header), len(rows)-maxNotifyRows))
+			break
+		}
+		sb.WriteString("<tr>")
+		for _, cell := range row {
+			sb.WriteString("<td>" + html.EscapeString(cell) + "</td>")
+		}
+		sb.WriteString("</tr>")
+	}
+	sb.WriteString("</table>")
+	return sb.String()
+}
+
+// notifyIngestDone emails the outcome of an ingestion that processed at
+// least the configured number of files.
+func notifyIngestDone(settings *SavedSettings, store *chat.ProjectStore, projectID, phase, errMsg string, results []FileResult, elapsed time.Duration) {
+	mail, ok := mailConfigFor(settings)
+	if !ok {
+		return
+	}
+	min := settings.NotifyMinFiles
+	if min <= 0 {
+		min = defaultNotifyMinFiles
+	}
+	if len(results) < min {
+		return
+	}
+
+	failed := 0
+	rows := make([][]string, 0, len(results))
+	for _, fr := range results {
+		if fr.Status != "ok" {
+			failed++
+		}
+		rows = append(rows, []string{fr.Name, fr.Status, strconv.Itoa(fr.Chunks), fr.Error})
+	}
+	name := projectName(store, projectID)
+	subject := fmt.Sprintf("[gocognigo] Ingestion of %s finished: %d of %d files indexed", name, len(results)-failed, len(results))
+	if phase != "done" {
+		subject = fmt.Sprintf("[gocognigo] Ingestion of %s failed", name)
+	}
+
+	var body strings.Builder
+	body.WriteString(fmt.Sprintf("<p>Ingestion of <b>%s</b> finished with status <b>%s</b> after %s.</p>",
+		html.EscapeString(name), html.EscapeString(phase), elapsed.Round(time.Second)))
+	if errMsg != "" {
+		body.WriteString("<p>Error: " + html.EscapeString(errMsg) + "</p>")
+	}
+	body.WriteString(resultsTable([]string{"File", "Status", "Chunks", "Error"}, rows))
+	mail.notify(subject, body.String())
+}
+
+// notifyBatchJobDone emails the outcome of a background batch job.
+func notifyBatchJobDone(mail mailConfig, store *chat.ProjectStore, rec *batchRecord) {
+	succeeded := 0
+	rows := make([][]string, 0, len(rec.Results))
+	for _, res := range rec.Results {
+		answer := res.Error
+		if res.Status == "ok" {
+			succeeded++
+			if res.Answer != nil {
+				answer = res.Answer.Answer
+			}
+		}
+		rows = append(rows, []string{strconv.Itoa(res.Index + 1), res.Question, res.Status, answer})
+	}
+	name := projectName(store, rec.ProjectID)
+	subject := fmt.Sprintf("[gocognigo] Batch job on %s finished: %d of %d answered", name, succeeded, len(rec.Results))
+	if rec.Status != "done" {
+		subject = fmt.Sprintf("[gocognigo] Batch job on %s failed", name)
+	}
+
+	var body strings.Builder
+	body.WriteString(fmt.Sprintf("<p>Batch job <b>%s</b> on <b>%s</b> finished with status <b>%s</b>.</p>",
+		html.EscapeString(rec.ID), html.EscapeString(name), html.EscapeString(rec.Status)))
+	if rec.Error != "" {
+		body.WriteString("<p>Error: " + html.EscapeString(rec.Error) + "</p>")
+	}
+	body.WriteString(resultsTable([]string{"#", "Question", "Status", "Answer or error"}, rows))
+	mail.notify(subject, body.String())
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"gocognigo/internal/analysis"
+	"gocognigo/internal/chat"
+	"gocognigo/internal/extractor"
+	"gocognigo/internal/indexer"
+)
+
+// ========== OCR Noise ==========
+
+// OCR misreads words ("Ievenue" for "revenue"), and keyword search then
+// misses them. A project of scanned documents can let BM25 terms match
+// within an edit distance (fuzziness), and correct the misread words of
+// OCR'd pages at ingest against the words of the rest of the document.
+// Pages the OCR was unsure of can rank lower in hybrid search, below a
+// minimum OCR confidence.
+
+// maxFuzziness is the largest edit distance bleve matches terms within.
+const maxFuzziness = 2
+
+// applyOCRSettings sets on idx the fuzziness and minimum OCR confidence
+// the project searches with.
+func applyOCRSettings(store *chat.ProjectStore, projectID string, idx *indexer.Index) {
+	if proj, err := store.Get(projectID); err == nil {
+		idx.Fuzziness = proj.Fuzziness
+		idx.MinOCRConfidence = proj.MinOCRConfidence
+	}
+}
+
+// correctOCR corrects the misread words of a document's OCR'd pages, with
+// all of its pages as the vocabulary.
+func correctOCR(pages []extractor.DocumentChunk) {
+	texts := make([]string, len(pages))
+	for i, p := range pages {
+		texts[i] = p.Text
+	}
+	corrector := analysis.NewOCRCorrector(texts)
+	total := 0
+	for i := range pages {
+		if !pages[i].OCR {
+			continue
+		}
+		var n int
+		pages[i].Text, n = corrector.Correct(pages[i].Text)
+		total += n
+	}
+	if total > 0 {
+		log.Printf("Corrected %d misread words in %s", total, pages[0].Document)
+	}
+}
+
+// handleProjectOCR reads (GET ?project_id=) or sets (POST) how a project
+// copes with OCR noise: fuzziness (0 to 2 edits) and min_ocr_confidence
+// (0 to 1), for queries from then on, and ocr_correct, for files ingested
+// from then on.
+func (s *Server) handleProjectOCR(w http.ResponseWriter, r *http.Request) {
+	store := s.getProjectStore(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		proj, err := store.Get(r.URL.Query().Get("project_id"))
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		jsonResp(w, ocrSettings(proj))
+
+	case http.MethodPost:
+		var req struct {
+			ProjectID        string  `json:"project_id"`
+			Fuzziness        int     `json:"fuzziness"`
+			OCRCorrect       bool    `json:"ocr_correct"`
+			MinOCRConfidence float64 `json:"min_ocr_confidence"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+			jsonErr(w, "project_id is required", http.StatusBadRequest)
+			return
+		}
+		if req.Fuzziness < 0 || req.Fuzziness > maxFuzziness {
+			jsonErr(w, fmt.Sprintf("fuzziness must be between 0 and %d", maxFuzziness), http.StatusBadRequest)
+			return
+		}
+		if req.MinOCRConfidence < 0 || req.MinOCRConfidence > 1 {
+			jsonErr(w, "min_ocr_confidence must be between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		proj, err := store.Get(req.ProjectID)
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		changed := proj.Fuzziness != req.Fuzziness || proj.MinOCRConfidence != req.MinOCRConfidence
+		if changed && s.ingestRunning() {
+			jsonErr(w, "An ingestion is running; change the search settings when it finishes", http.StatusConflict)
+			return
+		}
+		proj.Fuzziness = req.Fuzziness
+		proj.OCRCorrect = req.OCRCorrect
+		proj.MinOCRConfidence = req.MinOCRConfidence
+		if err := store.Update(*proj); err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Loaded retrievers search with the settings they were made with
+		if changed {
+			s.mu.RLock()
+			active := s.activeProjectID == req.ProjectID
+			s.mu.RUnlock()
+			s.evictProjectIndex(req.ProjectID)
+			if active {
+				settings := s.projectSettings(r, req.ProjectID)
+				go func() {
+					if err := s.loadChatIndexes(store, settings, req.ProjectID); err != nil {
+						log.Printf("Warning: could not reload indexes for project %s: %v", req.ProjectID, err)
+					}
+				}()
+			}
+		}
+		recordAudit(r, "project.ocr", req.ProjectID, "", map[string]string{
+			"fuzziness":          fmt.Sprint(req.Fuzziness),
+			"ocr_correct":        fmt.Sprint(req.OCRCorrect),
+			"min_ocr_confidence": fmt.Sprint(req.MinOCRConfidence),
+		})
+		jsonResp(w, ocrSettings(proj))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ocrSettings is the response of handleProjectOCR.
+func ocrSettings(proj *chat.Project) map[string]interface{} {
+	return map[string]interface{}{
+		"fuzziness":          proj.Fuzziness,
+		"ocr_correct":        proj.OCRCorrect,
+		"min_ocr_confidence": proj.MinOCRConfidence,
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/llm"
+)
+
+// ========== OpenAI-Compatible API ==========
+
+// /v1/chat/completions answers with the same RAG pipeline as /api/query, in
+// OpenAI's request and response shapes, so existing chat clients and SDKs
+// can use gocognigo by changing their base URL. The model name picks the
+// project: its ID or name, or "gocognigo" (or nothing) for the active one.
+// The last user message is the question, earlier turns its history, and
+// system messages are added to the project's prompt. The answering LLM is
+// the default from settings.
+
+// openAIModelActive is the model name that maps to the active project.
+const openAIModelActive = "gocognigo"
+
+type openAIChatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // a string, or an array of content parts
+}
+
+// text returns the message's text, joining the text parts of array content.
+func (m openAIChatMessage) text() string {
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return ""
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+type openAIChatRequest struct {
+	Model    string              `json:"model"`
+	Messages []openAIChatMessage `json:"messages"`
+	Stream   bool                `json:"stream,omitempty"`
+}
+
+// openAIErr writes an error in OpenAI's shape, which SDKs parse.
+func openAIErr(w http.ResponseWriter, msg, errType string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"message": msg, "type": errType, "code": nil},
+	})
+}
+
+// openAIProject resolves a model name to a project: the active project,
+// else a project ID, else a project name (ignoring case).
+func (s *Server) openAIProject(r *http.Request, model string) (*chat.Project, error) {
+	store := s.getProjectStore(r)
+	if model == "" || model == openAIModelActive {
+		s.mu.RLock()
+		active := s.activeProjectID
+		s.mu.RUnlock()
+		if active == "" {
+			return nil, fmt.Errorf("no active project; pass a project ID or name as the model")
+		}
+		return store.Get(active)
+	}
+	if proj, err := store.Get(model); err == nil {
+		return proj, nil
+	}
+	for _, p := range store.List() {
+		if strings.EqualFold(p.Name, model) {
+			return store.Get(p.ID)
+		}
+	}
+	return nil, fmt.Errorf("the model %q does not name a project", model)
+}
+
+// handleOpenAIModels lists the projects as models (GET /v1/models).
+func (s *Server) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data := []map[string]interface{}{}
+	for _, p := range s.getProjectStore(r).List() {
+		data = append(data, map[string]interface{}{
+			"id":       p.ID,
+			"object":   "model",
+			"created":  p.CreatedAt.Unix(),
+			"owned_by": "gocognigo",
+			"name":     p.Name,
+		})
+	}
+	jsonResp(w, map[string]interface{}{"object": "list", "data": data})
+}
+
+// handleOpenAIChatCompletions answers a chat completion request from a
+// project's documents (POST /v1/chat/completions). With stream set the
+// answer is sent as server-sent chunks, once it is complete.
+func (s *Server) handleOpenAIChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req openAIChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		openAIErr(w, "Invalid request body", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	question := ""
+	var history []llm.ChatMessage
+	var system []string
+	for i, m := range req.Messages {
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, m.text())
+		case "user", "assistant":
+			if i == len(req.Messages)-1 && m.Role == "user" {
+				question = m.text()
+			} else {
+				history = append(history, llm.ChatMessage{Role: m.Role, Content: m.text()})
+			}
+		}
+	}
+	if strings.TrimSpace(question) == "" {
+		openAIErr(w, "the last message must be a non-empty user message", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	proj, err := s.openAIProject(r, req.Model)
+	if err != nil {
+		openAIErr(w, err.Error(), "invalid_request_error", http.StatusNotFound)
+		return
+	}
+	if err := s.checkTokenBudget(proj); err != nil {
+		openAIErr(w, "Quota exceeded: "+err.Error(), "insufficient_quota", http.StatusTooManyRequests)
+		return
+	}
+	rw, err := s.getRetrieverForProject(proj.ID)
+	if err != nil {
+		openAIErr(w, "No documents indexed. Upload and process documents first.", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+	if err := checkEmbedding(rw.idx); err != nil {
+		openAIErr(w, err.Error(), "invalid_request_error", http.StatusConflict)
+		return
+	}
+	llmClient, err := s.getProvider(s.projectSettings(r, proj.ID), "", "")
+	if err != nil {
+		openAIErr(w, fmt.Sprintf("Provider error: %v", err), "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	if len(system) > 0 {
+		p := *proj // the stored project keeps its own prompt
+		p.SystemPrompt = strings.TrimSpace(strings.Join(append([]string{p.SystemPrompt}, system...), "\n\n"))
+		proj = &p
+	}
+	qr, err := s.answerWithHistory(r.Context(), r, rw, llmClient, question, history, 0, proj, answerOptions{})
+	if secs, limited := retryAfter(err); limited {
+		if secs > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+		}
+		openAIErr(w, err.Error(), "rate_limit_exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		openAIErr(w, err.Error(), "api_error", http.StatusInternalServerError)
+		return
+	}
+	answer := qr.answer
+	recordTokenUsage(s.getProjectStore(r), proj.ID, answer.Usage)
+
+	content := answer.Answer
+	if len(answer.Footnotes) > 0 {
+		var sb strings.Builder
+		sb.WriteString("\n\nSources:")
+		for _, fn := range answer.Footnotes {
+			sb.WriteString(fmt.Sprintf("\n[%d] %s, p. %d", fn.ID, fn.Document, fn.Page))
+		}
+		content += sb.String()
+	}
+	id := "chatcmpl-" + newID()
+	created := time.Now().Unix()
+	model := req.Model
+	if model == "" {
+		model = openAIModelActive
+	}
+
+	if !req.Stream {
+		jsonResp(w, map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   model,
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": content},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{
+				"prompt_tokens":     answer.Usage.InputTokens,
+				"completion_tokens": answer.Usage.OutputTokens,
+				"total_tokens":      answer.Usage.Total(),
+			},
+			"sources": answer.Footnotes, // not in OpenAI's schema; clients ignore it
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	for _, delta := range []map[string]interface{}{
+		{"delta": map[string]string{"role": "assistant", "content": content}, "finish_reason": nil},
+		{"delta": map[string]string{}, "finish_reason": "stop"},
+	} {
+		delta["index"] = 0
+		data, _ := json.Marshal(map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []map[string]interface{}{delta},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Progressive Index Loading ==========
+
+// A large project's vectors take a while to load, so queries don't wait for
+// all of them: while a load runs they search the chunks loaded so far, and
+// their responses say the index was partial. Everything that changes or
+// saves an index still needs the complete one (getRetrieverForProject), so
+// a partial index is never written back to disk.
+
+// indexLoad is a project index part-way through loading.
+type indexLoad struct {
+	snapshot *cachedIndex // the chunks loaded so far; nil before the first batch
+	percent  int          // of the vectors file read
+}
+
+// partialStep is how many percent of the file a load reads between
+// snapshots for queries.
+const partialStep = 5
+
+// progressiveLoad follows one load of a project's index, publishing
+// snapshots of it in s.loadingIndexes.
+type progressiveLoad struct {
+	s         *Server
+	projectID string
+	idx       *indexer.Index
+	state     *indexLoad
+
+	// The glossary is built a batch at a time as chunks load, rather than
+	// for every snapshot and again at the end (see retriever.NewRetriever)
+	glossary  indexer.GlossaryBuilder
+	added     int // chunks in glossary
+	published int // percent at the last snapshot
+}
+
+// trackIndexLoad registers a load of a project's index. The caller passes
+// its progress to idx.LoadVectorsProgressive, takes the complete index's
+// retriever from its retriever, and calls done when the load is over.
+func (s *Server) trackIndexLoad(projectID string, idx *indexer.Index) *progressiveLoad {
+	l := &progressiveLoad{s: s, projectID: projectID, idx: idx, state: &indexLoad{}, published: -partialStep}
+	s.mu.Lock()
+	if s.loadingIndexes == nil {
+		s.loadingIndexes = make(map[string]*indexLoad)
+	}
+	s.loadingIndexes[projectID] = l.state
+	s.mu.Unlock()
+	return l
+}
+
+// progress is the load's indexer.LoadProgress. It runs on the loading
+// goroutine, so the chunks loaded so far are stable while it reads them.
+func (l *progressiveLoad) progress(loaded int, fraction float64) {
+	percent := int(fraction * 100)
+	var snap *cachedIndex
+	if percent >= l.published+partialStep {
+		l.published = percent
+		ret := l.retriever()
+		snap = &cachedIndex{idx: l.idx, ret: ret}
+	}
+	l.s.mu.Lock()
+	l.state.percent = percent
+	if snap != nil {
+		l.state.snapshot = snap
+	}
+	l.s.mu.Unlock()
+}
+
+// retriever returns a retriever over the chunks loaded so far.
+func (l *progressiveLoad) retriever() *retriever.Retriever {
+	chunks, summaries := l.idx.View()
+	if len(chunks) < l.added {
+		// The load started over, e.g. from JSON after a bad binary file
+		l.glossary, l.added = indexer.GlossaryBuilder{}, 0
+	}
+	l.glossary.Add(chunks[l.added:])
+	l.added = len(chunks)
+	glossary := l.glossary.Entries
+	return &retriever.Retriever{
+		Chunks:       chunks,
+		DocSummaries: summaries,
+		Glossary:     glossary[:len(glossary):len(glossary)],
+		BM25Index:    l.idx.BM25Index,
+		Embedder:     l.idx.Embedder,
+		Multilingual: l.idx.Multilingual,
+	}
+}
+
+// done ends the load's snapshots.
+func (l *progressiveLoad) done() {
+	l.s.mu.Lock()
+	if l.s.loadingIndexes[l.projectID] == l.state {
+		delete(l.s.loadingIndexes, l.projectID)
+	}
+	l.s.mu.Unlock()
+}
+
+// indexLoadProgress reports whether a project's index is loading, how far
+// along it is, and whether its first part can be queried yet.
+func (s *Server) indexLoadProgress(projectID string) (loading bool, percent int, queryable bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	load, ok := s.loadingIndexes[projectID]
+	if !ok {
+		return false, 0, false
+	}
+	return true, load.percent, load.snapshot != nil
+}
+
+// getQueryRetriever is getRetrieverForProject for answering questions:
+// while the project's index is loading it returns a retriever over the part
+// loaded so far, marked partial.
+func (s *Server) getQueryRetriever(projectID string) (*retriever_wrapper, error) {
+	if rw, err := s.getRetrieverForProject(projectID); err == nil {
+		return rw, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if load, ok := s.loadingIndexes[projectID]; ok && load.snapshot != nil {
+		return &retriever_wrapper{ret: load.snapshot.ret, idx: load.snapshot.idx, partial: true, loadedPercent: load.percent}, nil
+	}
+	return nil, fmt.Errorf("no index loaded for project %s", projectID)
+}
+
+// markPartial flags a response answered from a partly loaded index.
+func (rw *retriever_wrapper) markPartial(resp map[string]interface{}) {
+	if rw.partial {
+		resp["index_partial"] = true
+		resp["index_loaded_percent"] = rw.loadedPercent
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocognigo/internal/chat"
+)
+
+// ========== Index Pre-Warming ==========
+
+// Loading a large project's index takes seconds, which the first query
+// after a restart would otherwise wait out. With PREWARM_INDEXES set, the
+// server loads the indexes of the most recently used ready projects, across
+// all users, into the cache in the background: at startup, and after each
+// ingestion to reload any that it pushed out of the cache.
+
+// prewarmCount is how many recently used projects to keep warm, from
+// PREWARM_INDEXES; 0, the default, turns pre-warming off.
+func prewarmCount() int {
+	n, _ := strconv.Atoi(os.Getenv("PREWARM_INDEXES"))
+	return max(n, 0)
+}
+
+type warmCandidate struct {
+	uid     string
+	project chat.Project
+}
+
+// lastUsed is when a project was last opened, or created if it never was.
+func lastUsed(p chat.Project) time.Time {
+	if p.LastUsedAt != nil {
+		return *p.LastUsedAt
+	}
+	return p.CreatedAt
+}
+
+// recentProjects returns the n most recently used ready projects of all
+// users, most recent first.
+func (s *Server) recentProjects(n int) []warmCandidate {
+	dirs, _ := filepath.Glob("data/users/*_projects")
+	var candidates []warmCandidate
+	for _, dir := range dirs {
+		uid := strings.TrimSuffix(filepath.Base(dir), "_projects")
+		store := s.projectStoreFor(uid)
+		if store == nil {
+			continue
+		}
+		for _, p := range store.List() {
+			if p.Status == "ready" {
+				candidates = append(candidates, warmCandidate{uid: uid, project: p})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return lastUsed(candidates[i].project).After(lastUsed(candidates[j].project))
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// prewarmIndexes loads the indexes of the n most recently used projects
+// that aren't in memory yet. It goes from the least recent to the most, so
+// if they don't all fit the cache's budget the most recent ones stay, and
+// stops if an ingestion starts, leaving it the disk and embedder.
+func (s *Server) prewarmIndexes(n int) {
+	if n <= 0 {
+		return
+	}
+	candidates := s.recentProjects(n)
+	start := time.Now()
+	warmed := 0
+	for i := len(candidates) - 1; i >= 0; i-- {
+		c := candidates[i]
+		if s.ingestRunning() {
+			log.Printf("Pre-warming stopped: an ingestion started")
+			break
+		}
+		if s.indexInMemory(c.project.ID) {
+			continue
+		}
+		store := s.projectStoreFor(c.uid)
+		if _, err := s.loadProjectIndex(store, s.projectSettingsFor(c.uid, c.project.ID), c.project.ID); err != nil {
+			log.Printf("Pre-warming: could not load project %s: %v", c.project.ID, err)
+			continue
+		}
+		warmed++
+	}
+	if warmed > 0 {
+		log.Printf("Pre-warmed %d project indexes in %v", warmed, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// indexInMemory reports whether a project's index is cached or active. An
+// active index can be uncached, e.g. after a failed ingestion kept it for a
+// retry; loading it again would open its keyword index twice.
+func (s *Server) indexInMemory(projectID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return (s.activeProjectID == projectID && s.activeIndex != nil) || s.indexCache.has(projectID)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gocognigo/internal/analysis"
+	"gocognigo/internal/extractor"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/llm"
+)
+
+// ========== PII Handling ==========
+
+// A project may tag or mask personal identifiers (Aadhaar, PAN, SSN, email,
+// phone) at ingest, and redact them from answers. Masking rewrites the
+// extracted text before it is chunked, embedded or summarized; the uploaded
+// originals are kept as they are.
+
+// Project PII modes.
+const (
+	piiTag  = "tag"
+	piiMask = "mask"
+)
+
+// maskPII replaces identifiers in extracted pages with placeholders.
+func maskPII(pages []extractor.DocumentChunk) {
+	for i := range pages {
+		pages[i].Text = analysis.RedactPII(pages[i].Text)
+	}
+}
+
+// tagPII records the kinds of identifier in each chunk.
+func tagPII(chunks []indexer.Chunk) {
+	for i := range chunks {
+		chunks[i].PII = analysis.PIITypes(chunks[i].Text)
+	}
+}
+
+// redactAnswer masks identifiers everywhere an answer shows text.
+func redactAnswer(a *llm.Answer) {
+	a.Answer = analysis.RedactPII(a.Answer)
+	a.Thinking = analysis.RedactPII(a.Thinking)
+	a.ConfidenceReason = analysis.RedactPII(a.ConfidenceReason)
+	if len(a.Data) > 0 {
+		a.Data = json.RawMessage(analysis.RedactPII(string(a.Data))) // placeholders keep the JSON valid
+	}
+	for i := range a.NumberChecks {
+		a.NumberChecks[i].Sentence = analysis.RedactPII(a.NumberChecks[i].Sentence)
+	}
+	for i := range a.ToolSteps {
+		a.ToolSteps[i].Result = analysis.RedactPII(a.ToolSteps[i].Result)
+	}
+	if v := a.Verification; v != nil {
+		for i := range v.Discrepancies {
+			v.Discrepancies[i].Claim = analysis.RedactPII(v.Discrepancies[i].Claim)
+			v.Discrepancies[i].Issue = analysis.RedactPII(v.Discrepancies[i].Issue)
+		}
+	}
+}
+
+// redactTranslations masks identifiers in translated snippets.
+func redactTranslations(ts []sourceTranslation) {
+	for i := range ts {
+		ts[i].Translation = analysis.RedactPII(ts[i].Translation)
+	}
+}
+
+// handleProjectPrivacy reads (GET ?project_id=) or sets (POST) a project's
+// PII settings: pii_mode ("", "tag" or "mask", applied to files ingested
+// from then on) and redact_answers.
+func (s *Server) handleProjectPrivacy(w http.ResponseWriter, r *http.Request) {
+	store := s.getProjectStore(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		proj, err := store.Get(r.URL.Query().Get("project_id"))
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		jsonResp(w, map[string]interface{}{"pii_mode": proj.PIIMode, "redact_answers": proj.RedactAnswers})
+
+	case http.MethodPost:
+		var req struct {
+			ProjectID     string `json:"project_id"`
+			PIIMode       string `json:"pii_mode"`
+			RedactAnswers bool   `json:"redact_answers"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+			jsonErr(w, "project_id is required", http.StatusBadRequest)
+			return
+		}
+		if req.PIIMode != "" && req.PIIMode != piiTag && req.PIIMode != piiMask {
+			jsonErr(w, `pii_mode must be "", "tag" or "mask"`, http.StatusBadRequest)
+			return
+		}
+		proj, err := store.Get(req.ProjectID)
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		proj.PIIMode = req.PIIMode
+		proj.RedactAnswers = req.RedactAnswers
+		if err := store.Update(*proj); err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "project.privacy", req.ProjectID, "", map[string]string{"pii_mode": req.PIIMode})
+		jsonResp(w, map[string]interface{}{"pii_mode": proj.PIIMode, "redact_answers": proj.RedactAnswers})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gocognigo/internal/indexer"
+)
+
+// ========== Settings Profiles ==========
+
+// A profile is a named preset of the settings that shape answers and the
+// index — LLM provider and model, embedding, OCR, summaries, chunking and
+// retrieval — such as "offline", "fast-cheap" or "max-quality". Applying
+// one switches them all at once; API keys and notification settings are
+// not part of a profile and stay as they are.
+
+// Profile limits.
+const (
+	maxProfiles          = 32
+	maxProfileNameLength = 64
+)
+
+// SettingsProfile is one named preset. Its fields mean what the
+// SavedSettings fields of the same name do.
+type SettingsProfile struct {
+	DefaultLLM             string `json:"default_llm"`
+	DefaultModel           string `json:"default_model,omitempty"`
+	EmbedProvider          string `json:"embed_provider"`
+	EmbedModel             string `json:"embed_model,omitempty"`
+	MultilingualEmbedModel string `json:"multilingual_embed_model,omitempty"`
+	OCRProvider            string `json:"ocr_provider,omitempty"`
+	TesseractLang          string `json:"tesseract_lang,omitempty"`
+	SummaryProvider        string `json:"summary_provider,omitempty"`
+	SummaryModel           string `json:"summary_model,omitempty"`
+	HierarchicalSummaries  bool   `json:"hierarchical_summaries,omitempty"`
+	ExtractWorkers         int    `json:"extract_workers,omitempty"`
+	EmbedConcurrency       int    `json:"embed_concurrency,omitempty"`
+	EmbedBatchSize         int    `json:"embed_batch_size,omitempty"`
+	ChunkWords             int    `json:"chunk_words,omitempty"`
+	ChunkOverlap           int    `json:"chunk_overlap,omitempty"`
+	TopK                   int    `json:"top_k,omitempty"`
+	RouteMinDocuments      int    `json:"route_min_documents,omitempty"`
+}
+
+// profileOf captures the profile settings of s.
+func profileOf(s *SavedSettings) SettingsProfile {
+	return SettingsProfile{
+		DefaultLLM:             s.DefaultLLM,
+		DefaultModel:           s.DefaultModel,
+		EmbedProvider:          s.EmbedProvider,
+		EmbedModel:             s.EmbedModel,
+		MultilingualEmbedModel: s.MultilingualEmbedModel,
+		OCRProvider:            s.OCRProvider,
+		TesseractLang:          s.TesseractLang,
+		SummaryProvider:        s.SummaryProvider,
+		SummaryModel:           s.SummaryModel,
+		HierarchicalSummaries:  s.HierarchicalSummaries,
+		ExtractWorkers:         s.ExtractWorkers,
+		EmbedConcurrency:       s.EmbedConcurrency,
+		EmbedBatchSize:         s.EmbedBatchSize,
+		ChunkWords:             s.ChunkWords,
+		ChunkOverlap:           s.ChunkOverlap,
+		TopK:                   s.TopK,
+		RouteMinDocuments:      s.RouteMinDocuments,
+	}
+}
+
+// applyTo sets the profile settings of s to p.
+func (p SettingsProfile) applyTo(s *SavedSettings) {
+	s.DefaultLLM = p.DefaultLLM
+	s.DefaultModel = p.DefaultModel
+	s.EmbedProvider = p.EmbedProvider
+	s.EmbedModel = p.EmbedModel
+	s.MultilingualEmbedModel = p.MultilingualEmbedModel
+	s.OCRProvider = p.OCRProvider
+	s.TesseractLang = p.TesseractLang
+	s.SummaryProvider = p.SummaryProvider
+	s.SummaryModel = p.SummaryModel
+	s.HierarchicalSummaries = p.HierarchicalSummaries
+	s.ExtractWorkers = p.ExtractWorkers
+	s.EmbedConcurrency = p.EmbedConcurrency
+	s.EmbedBatchSize = p.EmbedBatchSize
+	s.ChunkWords = p.ChunkWords
+	s.ChunkOverlap = p.ChunkOverlap
+	s.TopK = p.TopK
+	s.RouteMinDocuments = p.RouteMinDocuments
+}
+
+// validate checks p's values against the bounds the settings endpoint
+// enforces.
+func (p SettingsProfile) validate() error {
+	for _, c := range []struct {
+		name  string
+		value string
+		allow []string
+	}{
+		{"default_llm", p.DefaultLLM, []string{"", "openai", "anthropic", "huggingface"}},
+		{"embed_provider", p.EmbedProvider, []string{"", "openai", "huggingface"}},
+		{"ocr_provider", p.OCRProvider, []string{"", "tesseract", "sarvam"}},
+		{"summary_provider", p.SummaryProvider, []string{"", "openai", "anthropic", "huggingface"}},
+	} {
+		ok := false
+		for _, a := range c.allow {
+			ok = ok || c.value == a
+		}
+		if !ok {
+			return fmt.Errorf("%s must be one of %q", c.name, c.allow)
+		}
+	}
+	for _, l := range []struct {
+		name  string
+		value int
+		max   int
+	}{
+		{"extract_workers", p.ExtractWorkers, maxExtractWorkers},
+		{"embed_concurrency", p.EmbedConcurrency, maxEmbedConcurrency},
+		{"embed_batch_size", p.EmbedBatchSize, maxEmbedBatchSize},
+		{"chunk_words", p.ChunkWords, maxChunkWords},
+		{"chunk_overlap", p.ChunkOverlap, maxChunkWords},
+		{"top_k", p.TopK, maxTopK},
+		{"route_min_documents", p.RouteMinDocuments, 1 << 30},
+	} {
+		if l.value < 0 || l.value > l.max {
+			return fmt.Errorf("%s must be between 0 (default) and %d", l.name, l.max)
+		}
+	}
+	return nil
+}
+
+// activeProfile names the profile the current settings match, "" if none.
+func activeProfile(s *SavedSettings) string {
+	current := profileOf(s)
+	names := make([]string, 0, len(s.Profiles))
+	for name := range s.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if s.Profiles[name] == current {
+			return name
+		}
+	}
+	return ""
+}
+
+// handleProfiles lists the settings profiles (GET), saves one (POST {name,
+// profile}, where a missing profile captures the current settings) or
+// deletes one (DELETE ?name=).
+func (s *Server) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	settings := s.getUserSettings(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		profiles := settings.Profiles
+		if profiles == nil {
+			profiles = map[string]SettingsProfile{}
+		}
+		jsonResp(w, map[string]interface{}{"profiles": profiles, "active": activeProfile(settings)})
+
+	case http.MethodPost:
+		var req struct {
+			Name    string           `json:"name"`
+			Profile *SettingsProfile `json:"profile"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" || len(name) > maxProfileNameLength {
+			jsonErr(w, fmt.Sprintf("name is required (at most %d characters)", maxProfileNameLength), http.StatusBadRequest)
+			return
+		}
+		profile := profileOf(settings)
+		if req.Profile != nil {
+			profile = *req.Profile
+		}
+		if err := profile.validate(); err != nil {
+			jsonErr(w, "profile: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, exists := settings.Profiles[name]; !exists && len(settings.Profiles) >= maxProfiles {
+			jsonErr(w, fmt.Sprintf("at most %d profiles can be saved", maxProfiles), http.StatusBadRequest)
+			return
+		}
+
+		newSettings := *settings
+		newSettings.Profiles = make(map[string]SettingsProfile, len(settings.Profiles)+1)
+		for k, v := range settings.Profiles {
+			newSettings.Profiles[k] = v
+		}
+		newSettings.Profiles[name] = profile
+		if err := s.saveUserSettings(r, &newSettings); err != nil {
+			log.Printf("Failed to persist settings: %v", err)
+			jsonErr(w, "Failed to persist settings", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "settings.profile_save", "", name, nil)
+		jsonResp(w, map[string]interface{}{"status": "saved", "name": name, "profile": profile})
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if _, ok := settings.Profiles[name]; !ok {
+			jsonErr(w, "Profile not found", http.StatusNotFound)
+			return
+		}
+		newSettings := *settings
+		newSettings.Profiles = make(map[string]SettingsProfile, len(settings.Profiles))
+		for k, v := range settings.Profiles {
+			if k != name {
+				newSettings.Profiles[k] = v
+			}
+		}
+		if err := s.saveUserSettings(r, &newSettings); err != nil {
+			log.Printf("Failed to persist settings: %v", err)
+			jsonErr(w, "Failed to persist settings", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "settings.profile_delete", "", name, nil)
+		jsonResp(w, map[string]string{"status": "deleted"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleApplyProfile switches the settings to a saved profile (POST
+// {name}). The response lists the settings that changed, and warns when
+// the embedding did: indexes built before need re-embedding to be searched
+// with the new model.
+func (s *Server) handleApplyProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	settings := s.getUserSettings(r)
+	profile, ok := settings.Profiles[req.Name]
+	if !ok {
+		jsonErr(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+
+	newSettings := *settings
+	profile.applyTo(&newSettings)
+	if err := s.saveUserSettings(r, &newSettings); err != nil {
+		log.Printf("Failed to persist settings: %v", err)
+		jsonErr(w, "Failed to persist settings", http.StatusInternalServerError)
+		return
+	}
+	changed := changedSettings(*settings, newSettings)
+	recordAudit(r, "settings.profile_apply", "", req.Name, map[string]string{"changed": strings.Join(changed, ", ")})
+	log.Printf("Settings profile %q applied: %d settings changed", req.Name, len(changed))
+
+	resp := map[string]interface{}{"status": "applied", "name": req.Name, "changed": changed}
+	if embedding(*settings) != embedding(newSettings) {
+		resp["warning"] = "The embedding settings changed; existing project indexes keep querying with the models they were embedded with while that provider's key is configured, and need re-embedding (POST /api/index/reembed) to use the new ones"
+	}
+	jsonResp(w, resp)
+}
+
+// embedding identifies how s embeds: provider, model and multilingual model.
+func embedding(s SavedSettings) string {
+	provider := strings.ToLower(s.EmbedProvider)
+	if provider == "" {
+		provider = "openai"
+	}
+	return provider + "\x00" + indexer.EmbedModelName(s.EmbedProvider, s.EmbedModel) + "\x00" + s.MultilingualEmbedModel
+}
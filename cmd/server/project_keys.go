@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gocognigo/internal/crypto"
+)
+
+// ========== Per-Project Provider Keys ==========
+
+// A project can carry its own provider keys, so that different clients'
+// documents are embedded, OCR'd and answered under different billing
+// accounts. Each key a project sets replaces the one in the user's (or
+// workspace's) settings for work on that project; the others fall back to
+// the settings. The keys are kept in the project's directory (see
+// chat.ProjectStore.KeysPath), encrypted like the settings files, and are
+// left out of project archives.
+
+// ProjectKeys are a project's own provider keys; "" uses the settings' key.
+type ProjectKeys struct {
+	OpenAIKey      string `json:"openai_key,omitempty"`
+	AnthropicKey   string `json:"anthropic_key,omitempty"`
+	HuggingFaceKey string `json:"huggingface_key,omitempty"`
+	SarvamKey      string `json:"sarvam_key,omitempty"`
+}
+
+func (k *ProjectKeys) fields() []*string {
+	return []*string{&k.OpenAIKey, &k.AnthropicKey, &k.HuggingFaceKey, &k.SarvamKey}
+}
+
+func (k *ProjectKeys) empty() bool {
+	for _, f := range k.fields() {
+		if *f != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns settings with the project's keys in place of theirs.
+func (k ProjectKeys) apply(settings *SavedSettings) *SavedSettings {
+	if k.empty() {
+		return settings
+	}
+	merged := *settings
+	for _, f := range []struct{ key, dst *string }{
+		{&k.OpenAIKey, &merged.OpenAIKey},
+		{&k.AnthropicKey, &merged.AnthropicKey},
+		{&k.HuggingFaceKey, &merged.HuggingFaceKey},
+		{&k.SarvamKey, &merged.SarvamKey},
+	} {
+		if *f.key != "" {
+			*f.dst = *f.key
+		}
+	}
+	return &merged
+}
+
+// readProjectKeys reads and decrypts a project's key file. needsMigration
+// is as for decryptSecrets.
+func readProjectKeys(path string) (keys ProjectKeys, needsMigration bool, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return keys, false, err
+	}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return keys, false, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, field := range keys.fields() {
+		val, current := decryptOrPassthrough(*field)
+		if !current {
+			needsMigration = true
+		}
+		*field = val
+	}
+	return keys, needsMigration, nil
+}
+
+// writeProjectKeys encrypts keys and writes them to path, or removes the
+// file when the project sets no keys. In environment-only mode it leaves
+// the file as it is: keys set then last until restart.
+func writeProjectKeys(path string, keys ProjectKeys) error {
+	if envOnlyKeys {
+		return nil
+	}
+	if keys.empty() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	for _, field := range keys.fields() {
+		enc, err := crypto.Encrypt(*field)
+		if err != nil {
+			return fmt.Errorf("encrypt key: %w", err)
+		}
+		*field = enc
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// projectKeyFilePaths lists every project's key file.
+func projectKeyFilePaths() []string {
+	paths, _ := filepath.Glob("data/users/*_projects/*/keys.json")
+	return paths
+}
+
+// projectKeysFor returns the keys of the project stored under uid, loading
+// them on first use.
+func (s *Server) projectKeysFor(uid, projectID string) ProjectKeys {
+	s.mu.RLock()
+	keys, ok := s.projectKeys[projectID]
+	s.mu.RUnlock()
+	if ok {
+		return keys
+	}
+
+	var err error
+	if keys, _, err = readProjectKeys(s.projectStoreFor(uid).KeysPath(projectID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: could not read the provider keys of project %s: %v", projectID, err)
+	}
+	s.mu.Lock()
+	if s.projectKeys == nil {
+		s.projectKeys = make(map[string]ProjectKeys)
+	}
+	s.projectKeys[projectID] = keys
+	s.mu.Unlock()
+	return keys
+}
+
+// projectSettings returns the settings to work on a project with: the
+// current user's, with the provider keys the project sets in their place.
+func (s *Server) projectSettings(r *http.Request, projectID string) *SavedSettings {
+	return s.projectSettingsFor(storeUID(r), projectID)
+}
+
+// projectSettingsFor is projectSettings for the user stored under uid.
+func (s *Server) projectSettingsFor(uid, projectID string) *SavedSettings {
+	return s.projectKeysFor(uid, projectID).apply(s.userSettingsFor(uid))
+}
+
+// handleProjectKeys reads (GET ?project_id=) or sets (POST) a project's own
+// provider keys. GET returns them masked, with the providers whose keys
+// the project overrides. POST sets the keys given: "" clears one, so the
+// settings' key is used again, and a masked value leaves it as it is. The
+// project's index is reloaded to embed queries with the new keys.
+func (s *Server) handleProjectKeys(w http.ResponseWriter, r *http.Request) {
+	store := s.getProjectStore(r)
+	uid := storeUID(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.URL.Query().Get("project_id")
+		if _, err := store.Get(projectID); err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		jsonResp(w, projectKeysView(s.projectKeysFor(uid, projectID)))
+
+	case http.MethodPost:
+		var req struct {
+			ProjectID      string  `json:"project_id"`
+			OpenAIKey      *string `json:"openai_key"`
+			AnthropicKey   *string `json:"anthropic_key"`
+			HuggingFaceKey *string `json:"huggingface_key"`
+			SarvamKey      *string `json:"sarvam_key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+			jsonErr(w, "project_id is required", http.StatusBadRequest)
+			return
+		}
+		if _, err := store.Get(req.ProjectID); err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+
+		before := s.projectKeysFor(uid, req.ProjectID)
+		keys := before
+		var changed []string
+		for _, f := range []struct {
+			name  string
+			value *string
+			dst   *string
+		}{
+			{"openai_key", req.OpenAIKey, &keys.OpenAIKey},
+			{"anthropic_key", req.AnthropicKey, &keys.AnthropicKey},
+			{"huggingface_key", req.HuggingFaceKey, &keys.HuggingFaceKey},
+			{"sarvam_key", req.SarvamKey, &keys.SarvamKey},
+		} {
+			if f.value == nil || strings.Contains(*f.value, "...") {
+				continue
+			}
+			if v := strings.TrimSpace(*f.value); v != *f.dst {
+				*f.dst = v
+				changed = append(changed, f.name)
+			}
+		}
+
+		if len(changed) > 0 {
+			settingsWriteMu.Lock()
+			err := writeProjectKeys(store.KeysPath(req.ProjectID), keys)
+			settingsWriteMu.Unlock()
+			if err != nil {
+				log.Printf("Failed to persist the keys of project %s: %v", req.ProjectID, err)
+				jsonErr(w, "Failed to persist the project's keys", http.StatusInternalServerError)
+				return
+			}
+			s.mu.Lock()
+			s.projectKeys[req.ProjectID] = keys
+			active := s.activeProjectID == req.ProjectID
+			s.mu.Unlock()
+
+			s.evictProjectIndex(req.ProjectID)
+			if active {
+				settings := s.projectSettings(r, req.ProjectID)
+				go func() {
+					if err := s.loadChatIndexes(store, settings, req.ProjectID); err != nil {
+						log.Printf("Warning: could not reload indexes for project %s: %v", req.ProjectID, err)
+					}
+				}()
+			}
+			recordAudit(r, "project.keys", req.ProjectID, "", map[string]string{"changed": strings.Join(changed, ", ")})
+		}
+		jsonResp(w, projectKeysView(keys))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// projectKeysView is a project's keys as shown to clients.
+func projectKeysView(keys ProjectKeys) map[string]interface{} {
+	overrides := []string{}
+	for _, p := range []struct{ name, key string }{
+		{"openai", keys.OpenAIKey},
+		{"anthropic", keys.AnthropicKey},
+		{"huggingface", keys.HuggingFaceKey},
+		{"sarvam", keys.SarvamKey},
+	} {
+		if p.key != "" {
+			overrides = append(overrides, p.name)
+		}
+	}
+	return map[string]interface{}{
+		"openai_key":      maskKey(keys.OpenAIKey),
+		"anthropic_key":   maskKey(keys.AnthropicKey),
+		"huggingface_key": maskKey(keys.HuggingFaceKey),
+		"sarvam_key":      maskKey(keys.SarvamKey),
+		"overrides":       overrides,
+		"env_only_keys":   envOnlyKeys, // keys saved here last until restart
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/llm"
+)
+
+// ========== Per-Project Quotas ==========
+
+// defaultQuotas returns the instance-wide limits from the environment, used
+// for any quota a project leaves at zero. Unset variables mean no limit.
+func defaultQuotas() chat.ProjectQuotas {
+	envInt := func(name string) int64 {
+		v, _ := strconv.ParseInt(os.Getenv(name), 10, 64)
+		return v
+	}
+	return chat.ProjectQuotas{
+		MaxFiles:           int(envInt("QUOTA_MAX_FILES")),
+		MaxUploadBytes:     envInt("QUOTA_MAX_UPLOAD_BYTES"),
+		MaxChunks:          int(envInt("QUOTA_MAX_CHUNKS")),
+		MonthlyTokenBudget: envInt("QUOTA_MONTHLY_TOKENS"),
+	}
+}
+
+// effectiveQuotas merges a project's own limits over its workspace's, and
+// those over the instance defaults.
+func (s *Server) effectiveQuotas(p *chat.Project) chat.ProjectQuotas {
+	q := defaultQuotas()
+	if p == nil {
+		return q
+	}
+	if p.Workspace != "" {
+		if ws, err := s.workspaces.Get(p.Workspace); err == nil {
+			q = overrideQuotas(q, ws.Quotas)
+		}
+	}
+	return overrideQuotas(q, p.Quotas)
+}
+
+// overrideQuotas returns q with the limits over sets in their place. They
+// can only tighten q: a limit past one q sets is held to it, so that project
+// owners can't raise their own limits past the operator's.
+func overrideQuotas(q, over chat.ProjectQuotas) chat.ProjectQuotas {
+	q.MaxFiles = tighterLimit(q.MaxFiles, over.MaxFiles)
+	q.MaxUploadBytes = tighterLimit(q.MaxUploadBytes, over.MaxUploadBytes)
+	q.MaxChunks = tighterLimit(q.MaxChunks, over.MaxChunks)
+	q.MonthlyTokenBudget = tighterLimit(q.MonthlyTokenBudget, over.MonthlyTokenBudget)
+	return q
+}
+
+// clampQuotas returns the limits q sets held to those of limits, leaving
+// those it doesn't set unset.
+func clampQuotas(q, limits chat.ProjectQuotas) chat.ProjectQuotas {
+	if q.MaxFiles > 0 {
+		q.MaxFiles = tighterLimit(limits.MaxFiles, q.MaxFiles)
+	}
+	if q.MaxUploadBytes > 0 {
+		q.MaxUploadBytes = tighterLimit(limits.MaxUploadBytes, q.MaxUploadBytes)
+	}
+	if q.MaxChunks > 0 {
+		q.MaxChunks = tighterLimit(limits.MaxChunks, q.MaxChunks)
+	}
+	if q.MonthlyTokenBudget > 0 {
+		q.MonthlyTokenBudget = tighterLimit(limits.MonthlyTokenBudget, q.MonthlyTokenBudget)
+	}
+	return q
+}
+
+// tighterLimit returns the lower of two limits, where 0 is no limit.
+func tighterLimit[T int | int64](limit, over T) T {
+	if over > 0 && (limit == 0 || over < limit) {
+		return over
+	}
+	return limit
+}
+
+// uploadsUsage returns the number of files and total bytes in an uploads dir.
+func uploadsUsage(uploadsDir string) (files int, bytes int64) {
+	entries, _ := os.ReadDir(uploadsDir)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		files++
+		if info, err := e.Info(); err == nil {
+			bytes += info.Size()
+		}
+	}
+	return files, bytes
+}
+
+// checkTokenBudget returns an error once the project has used its monthly
+// LLM token budget.
+func (s *Server) checkTokenBudget(p *chat.Project) error {
+	q := s.effectiveQuotas(p)
+	if q.MonthlyTokenBudget > 0 && p.TokensThisMonth() >= q.MonthlyTokenBudget {
+		return fmt.Errorf("monthly token budget exhausted (%d of %d tokens used this month)", p.TokensThisMonth(), q.MonthlyTokenBudget)
+	}
+	return nil
+}
+
+// projectForQuery loads the project and enforces its token budget, writing
+// the error response (404 or 429) itself. Returns nil if the query must stop.
+func (s *Server) projectForQuery(w http.ResponseWriter, r *http.Request, projectID string) *chat.Project {
+	proj, err := s.getProjectStore(r).Get(projectID)
+	if err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return nil
+	}
+	if err := s.checkTokenBudget(proj); err != nil {
+		jsonErr(w, "Quota exceeded: "+err.Error(), http.StatusTooManyRequests)
+		return nil
+	}
+	return proj
+}
+
+// recordTokenUsage adds an answer's tokens to the project's monthly count.
+func recordTokenUsage(store *chat.ProjectStore, projectID string, usage *llm.Usage) {
+	if err := store.AddTokenUsage(projectID, int64(usage.Total())); err != nil {
+		log.Printf("Warning: failed to record token usage for %s: %v", projectID, err)
+	}
+}
+
+// handleProjectQuotas reads (GET ?project_id=) or sets (POST) a project's
+// limits. GET also reports current usage against the effective limits.
+func (s *Server) handleProjectQuotas(w http.ResponseWriter, r *http.Request) {
+	store := s.getProjectStore(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.URL.Query().Get("project_id")
+		proj, err := store.Get(projectID)
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		files, bytes := uploadsUsage(store.UploadsDir(projectID))
+		jsonResp(w, map[string]interface{}{
+			"quotas":    proj.Quotas,
+			"effective": s.effectiveQuotas(proj),
+			"usage": map[string]interface{}{
+				"files":             files,
+				"upload_bytes":      bytes,
+				"chunks":            proj.ChunkCount,
+				"tokens_this_month": proj.TokensThisMonth(),
+			},
+		})
+
+	case http.MethodPost:
+		var req struct {
+			ProjectID string             `json:"project_id"`
+			Quotas    chat.ProjectQuotas `json:"quotas"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" {
+			jsonErr(w, "project_id is required", http.StatusBadRequest)
+			return
+		}
+		q := req.Quotas
+		if q.MaxFiles < 0 || q.MaxUploadBytes < 0 || q.MaxChunks < 0 || q.MonthlyTokenBudget < 0 {
+			jsonErr(w, "quotas must be zero (unlimited) or positive", http.StatusBadRequest)
+			return
+		}
+		proj, err := store.Get(req.ProjectID)
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		proj.Quotas = q
+		if err := store.Update(*proj); err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "project.quotas", req.ProjectID, "", nil)
+		jsonResp(w, map[string]interface{}{"quotas": proj.Quotas, "effective": s.effectiveQuotas(proj)})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUsageReport lists every project's usage for a month (GET
+// ?month=2006-01, default the current one): files, upload bytes, chunks
+// and LLM tokens against the project's effective limits, with totals.
+func (s *Server) handleUsageReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		jsonErr(w, "month must be YYYY-MM", http.StatusBadRequest)
+		return
+	}
+
+	store := s.getProjectStore(r)
+	type projectUsage struct {
+		ProjectID   string             `json:"project_id"`
+		Name        string             `json:"name"`
+		Files       int                `json:"files"`
+		UploadBytes int64              `json:"upload_bytes"`
+		Chunks      int                `json:"chunks"`
+		Tokens      int64              `json:"tokens"`
+		Limits      chat.ProjectQuotas `json:"limits"`
+	}
+	projects := []projectUsage{}
+	var total projectUsage
+	for _, p := range store.List() {
+		files, bytes := uploadsUsage(store.UploadsDir(p.ID))
+		u := projectUsage{
+			ProjectID:   p.ID,
+			Name:        p.Name,
+			Files:       files,
+			UploadBytes: bytes,
+			Chunks:      p.ChunkCount,
+			Tokens:      p.TokenUsage[month],
+			Limits:      s.effectiveQuotas(&p),
+		}
+		projects = append(projects, u)
+		total.Files += u.Files
+		total.UploadBytes += u.UploadBytes
+		total.Chunks += u.Chunks
+		total.Tokens += u.Tokens
+	}
+	jsonResp(w, map[string]interface{}{
+		"month":    month,
+		"projects": projects,
+		"total": map[string]interface{}{
+			"files":        total.Files,
+			"upload_bytes": total.UploadBytes,
+			"chunks":       total.Chunks,
+			"tokens":       total.Tokens,
+		},
+	})
+}
+
+// checkUploadQuota verifies that accepting files keeps the project within
+// its file-count and upload-size limits. Files replacing an upload of the
+// same name count only their size difference.
+func (s *Server) checkUploadQuota(p *chat.Project, uploadsDir string, files []*multipart.FileHeader) error {
+	q := s.effectiveQuotas(p)
+	if q.MaxFiles == 0 && q.MaxUploadBytes == 0 {
+		return nil
+	}
+	fileCount, totalBytes := uploadsUsage(uploadsDir)
+	for _, fh := range files {
+		ext := strings.ToLower(filepath.Ext(fh.Filename))
+		if ext != ".pdf" && ext != ".docx" {
+			continue // skipped by the upload handler anyway
+		}
+		if info, err := os.Stat(filepath.Join(uploadsDir, fh.Filename)); err == nil {
+			totalBytes -= info.Size()
+		} else {
+			fileCount++
+		}
+		totalBytes += fh.Size
+	}
+	if q.MaxFiles > 0 && fileCount > q.MaxFiles {
+		return fmt.Errorf("project would have %d files, limit is %d", fileCount, q.MaxFiles)
+	}
+	if q.MaxUploadBytes > 0 && totalBytes > q.MaxUploadBytes {
+		return fmt.Errorf("project uploads would total %d bytes, limit is %d", totalBytes, q.MaxUploadBytes)
+	}
+	return nil
+}
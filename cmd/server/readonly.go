@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ========== Read-Only Mode ==========
+
+// A read-only server publishes a curated set of pre-built projects: people
+// can query them, but nothing that changes the projects or the server's
+// configuration is accepted — no uploads, ingestion, settings changes,
+// project or file deletes, or index maintenance. Reads always pass; of the
+// other requests only those on readOnlyAllowed do.
+
+// readOnlyEnv enables read-only mode, like the -read-only flag.
+const readOnlyEnv = "GOCOGNIGO_READ_ONLY"
+
+// readOnly is set once at startup from readOnlyEnv or -read-only.
+var readOnly bool
+
+// readOnlyAllowed are the endpoints that may be POSTed to in read-only
+// mode: answering questions, switching projects, and users' own
+// conversations and feedback.
+var readOnlyAllowed = map[string]bool{
+	"/api/query":               true,
+	"/api/query/stream":        true,
+	"/api/query/compare":       true,
+	"/v1/chat/completions":     true,
+	"/api/batch":               true,
+	"/api/batch/jobs":          true,
+	"/api/batch/cancel":        true,
+	"/api/search":              true,
+	"/api/debug/retrieval":     true,
+	"/api/compare":             true,
+	"/api/documents/summarize": true,
+	"/api/chats/activate":      true,
+	"/api/feedback":            true,
+}
+
+// readOnlyMiddleware rejects mutating requests with 403 when the server is
+// read-only.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnly && !readOnlyPermits(r) {
+			jsonErr(w, "This server is read-only: uploads, ingestion, settings and deletions are disabled", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func readOnlyPermits(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return readOnlyAllowed[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/api/conversations")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/llm"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Summary-Guided Routing ==========
+
+// In projects with many documents, most chunks are noise for any one
+// question. With route_min_documents set, a cheap model first reads the
+// document summaries and picks the plausibly relevant documents; chunk
+// retrieval then searches only those, plus any document without a summary
+// it couldn't judge. If routing fails or picks nothing, every document is
+// searched as usual.
+
+// maxRoutedDocuments caps how many documents the router may pick.
+const maxRoutedDocuments = 10
+
+// routeQuestion narrows f to the documents the router picks for question.
+// It returns f unchanged, and no routed documents, when routing is off or
+// doesn't apply: the query already names its documents, or fewer documents
+// than the threshold are in play.
+func (s *Server) routeQuestion(ctx context.Context, r *http.Request, projectID string, rw *retriever_wrapper, question string, f retriever.Filters) (retriever.Filters, []string) {
+	settings := s.projectSettings(r, projectID)
+	if settings.RouteMinDocuments <= 0 || len(f.Documents) > 0 {
+		return f, nil
+	}
+
+	var summarized []indexer.DocumentSummary
+	hasSummary := map[string]bool{}
+	for _, sum := range rw.ret.DocSummaries {
+		if f.Allows(sum.Document) && !hasSummary[sum.Document] {
+			hasSummary[sum.Document] = true
+			summarized = append(summarized, sum)
+		}
+	}
+	if len(summarized) < settings.RouteMinDocuments {
+		return f, nil
+	}
+	summarizer, ok := summaryCompleter(settings)
+	if !ok {
+		return f, nil
+	}
+
+	routed, usage, err := llm.RouteDocuments(ctx, summarizer, question, summarized, maxRoutedDocuments)
+	if usage != nil {
+		recordTokenUsage(s.getProjectStore(r), projectID, usage)
+	}
+	if err != nil {
+		log.Printf("Warning: document routing failed, searching all documents: %v", err)
+		return f, nil
+	}
+	if len(routed) == 0 {
+		return f, nil
+	}
+
+	docs := append([]string(nil), routed...)
+	seen := map[string]bool{}
+	for _, c := range rw.ret.Chunks {
+		if !seen[c.Document] {
+			seen[c.Document] = true
+			if !hasSummary[c.Document] && f.Allows(c.Document) {
+				docs = append(docs, c.Document)
+			}
+		}
+	}
+	f.Documents = docs
+	return f, routed
+}
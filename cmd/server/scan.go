@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========== Upload Scanning ==========
+
+// Uploaded files can be passed through a malware scanner before they are
+// stored: a clamd daemon over its socket (UPLOAD_SCAN_CLAMAV, e.g.
+// "unix:/var/run/clamav/clamd.ctl" or "tcp:127.0.0.1:3310") or an external
+// command given the file's path (UPLOAD_SCAN_COMMAND; exit 0 is clean, 1 is
+// flagged, anything else is a failure). Verdicts are cached by SHA-256 of the
+// content, so re-uploading the same file doesn't scan it again. A scan that
+// fails rejects the file rather than letting it through.
+
+// scanTimeout bounds a single scan.
+const scanTimeout = 2 * time.Minute
+
+// maxScanCache caps the number of cached verdicts.
+const maxScanCache = 10000
+
+// scanVerdict is the outcome of scanning one file.
+type scanVerdict struct {
+	Flagged   bool
+	Signature string // what the scanner reported, when flagged
+}
+
+// scanEngine scans the file at path.
+type scanEngine interface {
+	scan(ctx context.Context, path string) (scanVerdict, error)
+	name() string
+}
+
+// uploadScanner runs an engine with a content-hash verdict cache.
+type uploadScanner struct {
+	engine scanEngine
+
+	mu    sync.Mutex
+	cache map[string]scanVerdict
+}
+
+// newUploadScanner builds the scanner configured in the environment, or
+// returns nil when scanning is off.
+func newUploadScanner() (*uploadScanner, error) {
+	var engine scanEngine
+	if addr := strings.TrimSpace(os.Getenv("UPLOAD_SCAN_CLAMAV")); addr != "" {
+		network, address, ok := strings.Cut(addr, ":")
+		if !ok || (network != "unix" && network != "tcp") {
+			return nil, fmt.Errorf("UPLOAD_SCAN_CLAMAV must be unix:<path> or tcp:<host:port>, got %q", addr)
+		}
+		engine = &clamdEngine{network: network, address: address}
+	} else if cmd := strings.TrimSpace(os.Getenv("UPLOAD_SCAN_COMMAND")); cmd != "" {
+		engine = &commandEngine{args: strings.Fields(cmd)}
+	}
+	if engine == nil {
+		return nil, nil
+	}
+	return &uploadScanner{engine: engine, cache: make(map[string]scanVerdict)}, nil
+}
+
+// Scan checks the file at path, whose content hash is sum.
+func (u *uploadScanner) Scan(ctx context.Context, path, sum string) (scanVerdict, error) {
+	u.mu.Lock()
+	v, ok := u.cache[sum]
+	u.mu.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+	v, err := u.engine.scan(ctx, path)
+	if err != nil {
+		return v, fmt.Errorf("%s: %w", u.engine.name(), err)
+	}
+
+	u.mu.Lock()
+	if len(u.cache) >= maxScanCache {
+		u.cache = make(map[string]scanVerdict)
+	}
+	u.cache[sum] = v
+	u.mu.Unlock()
+	return v, nil
+}
+
+// hashingCopy copies src to dst and returns the hex SHA-256 of the bytes.
+func hashingCopy(dst io.Writer, src io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, h), src); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// clamdEngine streams files to clamd with the INSTREAM command.
+type clamdEngine struct {
+	network string
+	address string
+}
+
+func (c *clamdEngine) name() string { return "clamd" }
+
+func (c *clamdEngine) scan(ctx context.Context, path string) (scanVerdict, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return scanVerdict{}, err
+	}
+	defer f.Close()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return scanVerdict{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return scanVerdict{}, err
+	}
+	buf := make([]byte, 64<<10)
+	var size [4]byte
+	for {
+		n, rerr := f.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := conn.Write(size[:]); err != nil {
+				return scanVerdict{}, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return scanVerdict{}, err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return scanVerdict{}, rerr
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return scanVerdict{}, err
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return scanVerdict{}, err
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply reads "stream: OK" or "stream: <signature> FOUND".
+func parseClamdReply(reply string) (scanVerdict, error) {
+	_, result, _ := strings.Cut(reply, ": ")
+	switch {
+	case result == "OK":
+		return scanVerdict{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return scanVerdict{Flagged: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	default:
+		return scanVerdict{}, fmt.Errorf("unexpected reply %q", reply)
+	}
+}
+
+// commandEngine runs an external scanner with the file path appended.
+type commandEngine struct {
+	args []string
+}
+
+func (c *commandEngine) name() string { return c.args[0] }
+
+func (c *commandEngine) scan(ctx context.Context, path string) (scanVerdict, error) {
+	args := append(append([]string{}, c.args[1:]...), path)
+	out, err := exec.CommandContext(ctx, c.args[0], args...).CombinedOutput()
+	if err == nil {
+		return scanVerdict{}, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		sig := strings.TrimSpace(string(out))
+		if i := strings.IndexByte(sig, '\n'); i >= 0 {
+			sig = sig[:i]
+		}
+		// clamscan-style "path: Signature FOUND" lines name the file.
+		sig = strings.TrimSuffix(strings.TrimPrefix(sig, path+": "), " FOUND")
+		return scanVerdict{Flagged: true, Signature: sig}, nil
+	}
+	return scanVerdict{}, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+}
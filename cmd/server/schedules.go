@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocognigo/internal/llm"
+)
+
+// ========== Scheduled Queries ==========
+
+// A scheduled query is a saved question that runs once a day against a
+// project, so a user can monitor how the answer changes as documents are
+// added or replaced. Each run is stored with a diff against the previous
+// run's answer.
+
+// ScheduledQuery is a saved question and when it runs.
+type ScheduledQuery struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"` // store UID of the user who created it
+	ProjectID string    `json:"project_id"`
+	Question  string    `json:"question"`
+	Provider  string    `json:"provider,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Hour      int       `json:"hour"` // local hour of day (0-23) to run at
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	LastRunAt time.Time `json:"last_run_at,omitempty"`
+	LastRunID string    `json:"last_run_id,omitempty"`
+}
+
+// ScheduledRun is the result of one run of a scheduled query.
+type ScheduledRun struct {
+	ID          string      `json:"id"`
+	ScheduleID  string      `json:"schedule_id"`
+	Time        time.Time   `json:"time"`
+	Trigger     string      `json:"trigger"` // "schedule" or "manual"
+	Status      string      `json:"status"`  // "ok" or "error"
+	Error       string      `json:"error,omitempty"`
+	Answer      *llm.Answer `json:"answer,omitempty"`
+	TimeSeconds float64     `json:"time_seconds"`
+	Changed     bool        `json:"changed"` // answer differs from the previous successful run
+	Diff        *AnswerDiff `json:"diff,omitempty"`
+}
+
+// AnswerDiff describes how an answer changed since the previous run.
+type AnswerDiff struct {
+	PreviousRunID    string   `json:"previous_run_id"`
+	AddedSentences   []string `json:"added_sentences,omitempty"`
+	RemovedSentences []string `json:"removed_sentences,omitempty"`
+	AddedDocuments   []string `json:"added_documents,omitempty"`
+	RemovedDocuments []string `json:"removed_documents,omitempty"`
+	ConfidenceDelta  float64  `json:"confidence_delta"`
+}
+
+// Schedules of every user live in one file so the scheduler can find them
+// without walking each user's project store. Runs are kept with the project.
+const schedulesPath = "data/schedules.json"
+
+var schedulesMu sync.Mutex
+
+func loadSchedules() ([]ScheduledQuery, error) {
+	data, err := os.ReadFile(schedulesPath)
+	if os.IsNotExist(err) {
+		return []ScheduledQuery{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []ScheduledQuery
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func saveSchedules(list []ScheduledQuery) error {
+	_ = os.MkdirAll(filepath.Dir(schedulesPath), 0755)
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := schedulesPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, schedulesPath)
+}
+
+// updateSchedules applies fn to the schedule list and saves the result.
+func updateSchedules(fn func([]ScheduledQuery) ([]ScheduledQuery, error)) error {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	list, err := loadSchedules()
+	if err != nil {
+		return err
+	}
+	list, err = fn(list)
+	if err != nil {
+		return err
+	}
+	return saveSchedules(list)
+}
+
+func (s *Server) runsDir(sq *ScheduledQuery) string {
+	return filepath.Join(s.projectStoreFor(sq.Owner).ProjectDir(sq.ProjectID), "schedules", sq.ID)
+}
+
+// loadRuns returns a schedule's runs, newest first.
+func (s *Server) loadRuns(sq *ScheduledQuery) []ScheduledRun {
+	dir := s.runsDir(sq)
+	entries, _ := os.ReadDir(dir)
+	runs := []ScheduledRun{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var run ScheduledRun
+		if json.Unmarshal(data, &run) == nil {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Time.After(runs[j].Time) })
+	return runs
+}
+
+// nextRunAfter returns the first time at hour:00 strictly after t.
+func nextRunAfter(t time.Time, hour int) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day(), hour, 0, 0, 0, t.Location())
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// startScheduler checks once a minute for scheduled queries that are due.
+// A query that was missed while the server was down runs once on startup.
+func (s *Server) startScheduler() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			s.runDueSchedules(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *Server) runDueSchedules(now time.Time) {
+	schedulesMu.Lock()
+	list, err := loadSchedules()
+	schedulesMu.Unlock()
+	if err != nil {
+		log.Printf("Warning: could not load schedules: %v", err)
+		return
+	}
+	for i := range list {
+		sq := list[i]
+		last := sq.LastRunAt
+		if last.IsZero() {
+			last = sq.CreatedAt
+		}
+		if !sq.Enabled || now.Before(nextRunAfter(last, sq.Hour)) {
+			continue
+		}
+		if _, err := s.runScheduledQuery(context.Background(), &sq, "schedule"); err != nil {
+			log.Printf("Scheduled query %s: %v", sq.ID, err)
+		}
+	}
+}
+
+// runScheduledQuery answers a scheduled question, diffs the answer against
+// the previous successful run and stores the run. Failed answers are stored
+// too, as runs with status "error".
+func (s *Server) runScheduledQuery(ctx context.Context, sq *ScheduledQuery, trigger string) (*ScheduledRun, error) {
+	store := s.projectStoreFor(sq.Owner)
+	run := &ScheduledRun{ID: newID(), ScheduleID: sq.ID, Time: time.Now(), Trigger: trigger}
+
+	res := func() BatchResult {
+		proj, err := store.Get(sq.ProjectID)
+		if err != nil {
+			return BatchResult{Status: "error", Error: "project not found"}
+		}
+		if err := s.checkTokenBudget(proj); err != nil {
+			return BatchResult{Status: "error", Error: "quota exceeded: " + err.Error()}
+		}
+		rw, err := s.getRetrieverForProject(sq.ProjectID)
+		if err != nil {
+			return BatchResult{Status: "error", Error: "no documents indexed"}
+		}
+		if err := checkEmbedding(rw.idx); err != nil {
+			return BatchResult{Status: "error", Error: err.Error()}
+		}
+		settings := s.projectSettingsFor(sq.Owner, sq.ProjectID)
+		client, err := s.getProvider(settings, sq.Provider, sq.Model)
+		if err != nil {
+			return BatchResult{Status: "error", Error: fmt.Sprintf("provider error: %v", err)}
+		}
+		runner := &batchRunner{rw: rw, client: client, customSysPrompt: proj.SystemPrompt, basePrompt: proj.BasePrompt, redact: proj.RedactAnswers, docTypePrompts: settings.DocTypePrompts}
+		return runner.answer(ctx, 0, sq.Question)
+	}()
+
+	run.Status = res.Status
+	run.Error = res.Error
+	run.Answer = res.Answer
+	run.TimeSeconds = res.TimeSeconds
+	if run.Answer != nil {
+		recordTokenUsage(store, sq.ProjectID, run.Answer.Usage)
+		for _, prev := range s.loadRuns(sq) {
+			if prev.Status == "ok" && prev.Answer != nil {
+				run.Diff = diffAnswers(prev.Answer, run.Answer)
+				run.Diff.PreviousRunID = prev.ID
+				run.Changed = run.Diff.changed()
+				break
+			}
+		}
+	}
+
+	dir := s.runsDir(sq)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	data, _ := json.MarshalIndent(run, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, run.ID+".json"), data, 0644); err != nil {
+		return nil, err
+	}
+
+	err := updateSchedules(func(list []ScheduledQuery) ([]ScheduledQuery, error) {
+		for i := range list {
+			if list[i].ID == sq.ID {
+				list[i].LastRunAt = run.Time
+				list[i].LastRunID = run.ID
+			}
+		}
+		return list, nil
+	})
+	if err != nil {
+		log.Printf("Warning: could not update schedule %s: %v", sq.ID, err)
+	}
+	if run.Changed {
+		log.Printf("Scheduled query %s: answer changed since run %s", sq.ID, run.Diff.PreviousRunID)
+	}
+	return run, nil
+}
+
+var sentenceEnd = regexp.MustCompile(`[.!?]+\s+|\n+`)
+
+var footnoteMarker = regexp.MustCompile(`\[\d+\]`)
+
+// normalizeSentence strips footnote markers and spacing so renumbered
+// citations or reflowed text don't count as a change.
+func normalizeSentence(s string) string {
+	s = footnoteMarker.ReplaceAllString(s, "")
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func splitSentences(text string) []string {
+	var out []string
+	for _, s := range sentenceEnd.Split(text, -1) {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// setDiff returns items of a not in b, comparing with key.
+func setDiff(a, b []string, key func(string) string) []string {
+	seen := make(map[string]bool, len(b))
+	for _, x := range b {
+		seen[key(x)] = true
+	}
+	var out []string
+	for _, x := range a {
+		if !seen[key(x)] {
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+// diffAnswers compares two answers sentence by sentence and by cited documents.
+func diffAnswers(prev, cur *llm.Answer) *AnswerDiff {
+	prevSentences, curSentences := splitSentences(prev.Answer), splitSentences(cur.Answer)
+	same := func(s string) string { return s }
+	return &AnswerDiff{
+		AddedSentences:   setDiff(curSentences, prevSentences, normalizeSentence),
+		RemovedSentences: setDiff(prevSentences, curSentences, normalizeSentence),
+		AddedDocuments:   setDiff(cur.Documents, prev.Documents, same),
+		RemovedDocuments: setDiff(prev.Documents, cur.Documents, same),
+		ConfidenceDelta:  cur.Confidence - prev.Confidence,
+	}
+}
+
+func (d *AnswerDiff) changed() bool {
+	return len(d.AddedSentences) > 0 || len(d.RemovedSentences) > 0 ||
+		len(d.AddedDocuments) > 0 || len(d.RemovedDocuments) > 0
+}
+
+// deleteProjectSchedules drops the schedules of a deleted project. Their runs
+// went with the project directory.
+func deleteProjectSchedules(projectID string) {
+	err := updateSchedules(func(list []ScheduledQuery) ([]ScheduledQuery, error) {
+		out := list[:0]
+		for _, sq := range list {
+			if sq.ProjectID != projectID {
+				out = append(out, sq)
+			}
+		}
+		return out, nil
+	})
+	if err != nil {
+		log.Printf("Warning: could not remove schedules of project %s: %v", projectID, err)
+	}
+}
+
+// findSchedule returns the caller's schedule with the given ID.
+func findSchedule(r *http.Request, id string) (*ScheduledQuery, error) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	list, err := loadSchedules()
+	if err != nil {
+		return nil, err
+	}
+	owner := storeUID(r)
+	for i := range list {
+		if list[i].ID == id && list[i].Owner == owner {
+			return &list[i], nil
+		}
+	}
+	return nil, fmt.Errorf("schedule not found")
+}
+
+// handleSchedules lists (GET ?project_id=), creates (POST) or deletes
+// (DELETE ?id=) the caller's scheduled queries. POST with an id updates
+// that schedule instead.
+func (s *Server) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	owner := storeUID(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.URL.Query().Get("project_id")
+		schedulesMu.Lock()
+		list, err := loadSchedules()
+		schedulesMu.Unlock()
+		if err != nil {
+			jsonErr(w, "failed to load schedules", http.StatusInternalServerError)
+			return
+		}
+		out := []ScheduledQuery{}
+		for _, sq := range list {
+			if sq.Owner == owner && (projectID == "" || sq.ProjectID == projectID) {
+				out = append(out, sq)
+			}
+		}
+		jsonResp(w, map[string]interface{}{"schedules": out})
+
+	case http.MethodPost:
+		var req struct {
+			ID        string `json:"id,omitempty"`
+			ProjectID string `json:"project_id"`
+			Question  string `json:"question"`
+			Provider  string `json:"provider,omitempty"`
+			Model     string `json:"model,omitempty"`
+			Hour      *int   `json:"hour,omitempty"`
+			Enabled   *bool  `json:"enabled,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Hour != nil && (*req.Hour < 0 || *req.Hour > 23) {
+			jsonErr(w, "hour must be between 0 and 23", http.StatusBadRequest)
+			return
+		}
+
+		var saved ScheduledQuery
+		err := updateSchedules(func(list []ScheduledQuery) ([]ScheduledQuery, error) {
+			if req.ID != "" {
+				for i := range list {
+					if list[i].ID != req.ID || list[i].Owner != owner {
+						continue
+					}
+					if q := strings.TrimSpace(req.Question); q != "" {
+						list[i].Question = q
+					}
+					if req.Provider != "" {
+						list[i].Provider = req.Provider
+					}
+					if req.Model != "" {
+						list[i].Model = req.Model
+					}
+					if req.Hour != nil {
+						list[i].Hour = *req.Hour
+					}
+					if req.Enabled != nil {
+						list[i].Enabled = *req.Enabled
+					}
+					saved = list[i]
+					return list, nil
+				}
+				return nil, fmt.Errorf("schedule not found")
+			}
+
+			if _, err := s.getProjectStore(r).Get(req.ProjectID); err != nil {
+				return nil, fmt.Errorf("project not found")
+			}
+			if strings.TrimSpace(req.Question) == "" {
+				return nil, fmt.Errorf("question is required")
+			}
+			saved = ScheduledQuery{
+				ID:        newID(),
+				Owner:     owner,
+				ProjectID: req.ProjectID,
+				Question:  strings.TrimSpace(req.Question),
+				Provider:  req.Provider,
+				Model:     req.Model,
+				Hour:      2,
+				Enabled:   true,
+				CreatedAt: time.Now(),
+			}
+			if req.Hour != nil {
+				saved.Hour = *req.Hour
+			}
+			if req.Enabled != nil {
+				saved.Enabled = *req.Enabled
+			}
+			return append(list, saved), nil
+		})
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jsonResp(w, saved)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		sq, err := findSchedule(r, id)
+		if err != nil {
+			jsonErr(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		err = updateSchedules(func(list []ScheduledQuery) ([]ScheduledQuery, error) {
+			out := list[:0]
+			for _, x := range list {
+				if x.ID != id {
+					out = append(out, x)
+				}
+			}
+			return out, nil
+		})
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = os.RemoveAll(s.runsDir(sq))
+		recordAudit(r, "schedule.delete", sq.ProjectID, sq.ID, nil)
+		jsonResp(w, map[string]string{"status": "deleted"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleScheduleRun runs a scheduled query immediately (POST {"id": ...}).
+func (s *Server) handleScheduleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	sq, err := findSchedule(r, req.ID)
+	if err != nil {
+		jsonErr(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+	run, err := s.runScheduledQuery(r.Context(), sq, "manual")
+	if err != nil {
+		jsonErr(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResp(w, run)
+}
+
+// handleScheduleRuns returns a schedule's runs, newest first
+// (GET ?id=&limit=, default 20). ?changed=true keeps only runs whose answer
+// changed.
+func (s *Server) handleScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sq, err := findSchedule(r, r.URL.Query().Get("id"))
+	if err != nil {
+		jsonErr(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 20
+	}
+	onlyChanged := r.URL.Query().Get("changed") == "true"
+
+	runs := []ScheduledRun{}
+	for _, run := range s.loadRuns(sq) {
+		if onlyChanged && !run.Changed {
+			continue
+		}
+		runs = append(runs, run)
+		if len(runs) == limit {
+			break
+		}
+	}
+	jsonResp(w, map[string]interface{}{"schedule": sq, "runs": runs})
+}
@@ -1,22 +1,25 @@
 package main
 
 import (
-	"container/list"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 
 	"gocognigo/internal/chat"
 	"gocognigo/internal/crypto"
+	"gocognigo/internal/httpclient"
 	"gocognigo/internal/indexer"
 	"gocognigo/internal/llm"
 	"gocognigo/internal/retriever"
 
 	"context"
+
+	"golang.org/x/sync/singleflight"
 )
 
 // Server holds all shared state.
@@ -27,96 +30,36 @@ type Server struct {
 	activeRetriever *retriever.Retriever
 	indexLoading    bool // true while background index load is in progress
 
-	// Index cache: LRU cache of loaded indexes keyed by project ID.
-	// Keeps up to maxCacheSize entries; least-recently-used is evicted.
+	// Index cache: LRU cache of loaded indexes keyed by project ID, within
+	// the memory budget of indexCacheLimits; least-recently-used is evicted.
 	indexCache *lruCache
+	// indexLoads shares one load between concurrent requests for the same
+	// project's index (activation and pre-warming), see loadProjectIndex.
+	indexLoads singleflight.Group
+	// loadingIndexes are the loads in progress by project ID, with the part
+	// loaded so far for queries to search (guarded by mu).
+	loadingIndexes map[string]*indexLoad
 
 	userProjects map[string]*chat.ProjectStore
 	userSettings map[string]*SavedSettings
 
 	ingestStatus *IngestStatus
 	ingestCancel context.CancelFunc // cancels the active ingestion goroutine
+	ingestCtl    *ingestControl     // pauses and reorders the active ingestion
 
-	tesseractOk bool // true if tesseract CLI is on PATH
-}
+	batchJobs *jobQueue // background batch jobs (POST /api/batch/jobs)
 
-const maxCacheSize = 5
+	throughput *throughputTracker // measured embedding throughput, for ingest estimates
 
-type cachedIndex struct {
-	idx *indexer.Index
-	ret *retriever.Retriever
-}
+	tesseractOk bool // true if tesseract CLI is on PATH
 
-// lruCache is a thread-safe LRU cache for loaded indexes.
-type lruCache struct {
-	mu      sync.Mutex
-	maxSize int
-	items   map[string]*list.Element
-	order   *list.List // front = most recently used
-}
+	scanner *uploadScanner // nil unless upload scanning is configured
 
-type lruEntry struct {
-	key   string
-	value *cachedIndex
-}
+	directUploads *directUploads // nil unless an upload bucket is configured
 
-func newLRUCache(maxSize int) *lruCache {
-	return &lruCache{
-		maxSize: maxSize,
-		items:   make(map[string]*list.Element),
-		order:   list.New(),
-	}
-}
+	workspaces *chat.WorkspaceStore
 
-// get returns the cached index and true if found, promoting it to front.
-func (c *lruCache) get(key string) (*cachedIndex, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if el, ok := c.items[key]; ok {
-		c.order.MoveToFront(el)
-		return el.Value.(*lruEntry).value, true
-	}
-	return nil, false
-}
-
-// put adds or updates an entry. If the cache is full, the LRU entry is evicted.
-func (c *lruCache) put(key string, value *cachedIndex) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if el, ok := c.items[key]; ok {
-		c.order.MoveToFront(el)
-		el.Value.(*lruEntry).value = value
-		return
-	}
-	// Evict if at capacity
-	if c.order.Len() >= c.maxSize {
-		oldest := c.order.Back()
-		if oldest != nil {
-			c.order.Remove(oldest)
-			delete(c.items, oldest.Value.(*lruEntry).key)
-			log.Printf("LRU cache: evicted index for project %s", oldest.Value.(*lruEntry).key)
-		}
-	}
-	el := c.order.PushFront(&lruEntry{key: key, value: value})
-	c.items[key] = el
-}
-
-// delete removes an entry from the cache.
-func (c *lruCache) delete(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if el, ok := c.items[key]; ok {
-		c.order.Remove(el)
-		delete(c.items, key)
-	}
-}
-
-// has returns true if the key is in the cache (without promoting).
-func (c *lruCache) has(key string) bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	_, ok := c.items[key]
-	return ok
+	projectKeys map[string]ProjectKeys // projects' own provider keys by project ID, loaded on first use (guarded by mu)
 }
 
 // IngestStatus is polled by the frontend to show progress.
@@ -131,6 +74,12 @@ type IngestStatus struct {
 	FileResults    []FileResult `json:"file_results,omitempty"`
 	CanRetry       bool         `json:"can_retry,omitempty"`        // true when extraction succeeded but embedding failed
 	RetryProjectID string       `json:"retry_project_id,omitempty"` // project ID that can be retried
+
+	Estimate   *ingestEstimate `json:"estimate,omitempty"`    // expected cost and duration, set at start
+	ETASeconds float64         `json:"eta_seconds,omitempty"` // remaining time at the rate measured so far
+
+	Paused bool     `json:"paused,omitempty"`
+	Queued []string `json:"queued,omitempty"` // files not yet picked up for extraction, in order
 }
 
 // FileResult tracks per-file processing outcome.
@@ -152,12 +101,22 @@ type IngestStatusSnapshot struct {
 	FileResults    []FileResult `json:"file_results,omitempty"`
 	CanRetry       bool         `json:"can_retry,omitempty"`
 	RetryProjectID string       `json:"retry_project_id,omitempty"`
+
+	Estimate   *ingestEstimate `json:"estimate,omitempty"`
+	ETASeconds float64         `json:"eta_seconds,omitempty"`
+
+	Paused bool     `json:"paused,omitempty"`
+	Queued []string `json:"queued,omitempty"`
+
+	// Throttled lists the provider hosts rate-limiting us while processing,
+	// and when each said to try again.
+	Throttled []httpclient.Throttle `json:"throttled,omitempty"`
 }
 
 func (s *IngestStatus) snapshot() IngestStatusSnapshot {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return IngestStatusSnapshot{
+	snap := IngestStatusSnapshot{
 		Phase:          s.Phase,
 		FilesTotal:     s.FilesTotal,
 		FilesDone:      s.FilesDone,
@@ -167,7 +126,15 @@ func (s *IngestStatus) snapshot() IngestStatusSnapshot {
 		FileResults:    s.FileResults,
 		CanRetry:       s.CanRetry,
 		RetryProjectID: s.RetryProjectID,
+		Estimate:       s.Estimate,
+		ETASeconds:     s.ETASeconds,
+		Paused:         s.Paused,
+		Queued:         s.Queued,
+	}
+	if s.Phase == "processing" {
+		snap.Throttled = httpclient.Throttled()
 	}
+	return snap
 }
 
 func (s *IngestStatus) reset() {
@@ -182,6 +149,10 @@ func (s *IngestStatus) reset() {
 	s.FileResults = nil
 	s.CanRetry = false
 	s.RetryProjectID = ""
+	s.Estimate = nil
+	s.ETASeconds = 0
+	s.Paused = false
+	s.Queued = nil
 }
 
 // ----- Request / Response types -----
@@ -192,26 +163,81 @@ type QueryRequest struct {
 	Model          string `json:"model,omitempty"`
 	ProjectID      string `json:"project_id"`
 	ConversationID string `json:"conversation_id,omitempty"`
+	// Schema, if set, is a JSON schema the answer's "data" field must
+	// conform to (see llm.AnswerStructured). Not supported when streaming.
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// Tools lets the model call tools before answering: "search_again"
+	// and "calculator" (see queryTools). MaxToolSteps caps the tool-calling
+	// turns (default llm.DefaultMaxToolSteps). Not supported when streaming.
+	Tools        []string `json:"tools,omitempty"`
+	MaxToolSteps int      `json:"max_tool_steps,omitempty"`
+	// Language is the language to answer in ("hi", "Tamil"), whatever the
+	// sources' language. TranslateSources also translates the retrieved
+	// snippets into it (see llm.TranslateResults).
+	Language         string `json:"language,omitempty"`
+	TranslateSources bool   `json:"translate_sources,omitempty"`
+	// RedactPII masks personal identifiers in the answer, as the project's
+	// redact_answers setting does for every query.
+	RedactPII bool `json:"redact_pii,omitempty"`
+	// AsOf searches the document versions current at that time (RFC 3339,
+	// or a YYYY-MM-DD date meaning the end of that day) instead of the
+	// latest uploads. See retrieverAsOf.
+	AsOf string `json:"as_of,omitempty"`
+	// Tags scopes retrieval to documents carrying all of these tags (see
+	// handleDocumentTags).
+	Tags []string `json:"tags,omitempty"`
+	// Granularity "section" retrieves whole sections (the summaries' page
+	// ranges) instead of single pages; "" or "page" is the default.
+	Granularity string `json:"granularity,omitempty"`
+	// QueryType overrides the classified query type ("lookup",
+	// "enumeration", "comparison" or "summarization"), which picks top-k,
+	// the document overviews included and extra answering instructions.
+	QueryType string `json:"query_type,omitempty"`
+	// TopK overrides how many chunks are retrieved (up to maxTopK); 0 lets
+	// the query type decide.
+	TopK int `json:"top_k,omitempty"`
+	// Verify has a second model check the answer against its cited
+	// excerpts (see verifyCompleter for which model); the result is the
+	// answer's verification field.
+	Verify         bool   `json:"verify,omitempty"`
+	VerifyProvider string `json:"verify_provider,omitempty"`
+	VerifyModel    string `json:"verify_model,omitempty"`
+	// Reasoning sets a reasoning model's native reasoning: "off", or an
+	// effort of "low", "medium" or "high" (see llm.WithReasoning). ""
+	// leaves it to the model.
+	Reasoning string `json:"reasoning,omitempty"`
 }
 
 type BatchRequest struct {
-	Questions []string `json:"questions"`
-	Provider  string   `json:"provider,omitempty"`
-	Model     string   `json:"model,omitempty"`
-	ProjectID string   `json:"project_id"`
+	Questions     []string        `json:"questions"`
+	Provider      string          `json:"provider,omitempty"`
+	Model         string          `json:"model,omitempty"`
+	ProjectID     string          `json:"project_id"`
+	ResumeBatchID string          `json:"resume_batch_id,omitempty"` // re-run only the failed questions of this batch
+	Schema        json.RawMessage `json:"schema,omitempty"`          // structured output for every answer, as in QueryRequest
 }
 
 type BatchResponse struct {
-	Answers   []*llm.Answer `json:"answers"`
+	BatchID   string        `json:"batch_id"`
+	Answers   []*llm.Answer `json:"answers"` // positional; nil where a question failed
+	Results   []BatchResult `json:"results"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
 	TotalTime float64       `json:"total_time_seconds"`
+	Cancelled bool          `json:"cancelled,omitempty"` // stopped before every question was answered
 }
 
 type StatsResponse struct {
-	Documents  int      `json:"documents"`
-	Chunks     int      `json:"chunks"`
-	IndexReady bool     `json:"index_ready"`
-	Providers  []string `json:"providers"`
-	DefaultLLM string   `json:"default_llm"`
+	Documents  int                        `json:"documents"`
+	Chunks     int                        `json:"chunks"`
+	IndexReady bool                       `json:"index_ready"`
+	Providers  []string                   `json:"providers"`
+	DefaultLLM string                     `json:"default_llm"`
+	LLMQueue   map[string]llm.QueueStats  `json:"llm_queue,omitempty"`
+	Parsing    map[string]llm.ParseCounts `json:"answer_parsing,omitempty"` // how answers' JSON parsed, by provider
+	Feedback   *chat.FeedbackSummary      `json:"feedback,omitempty"`       // answer ratings, when project_id is given
+	ByDocument []DocumentStats            `json:"by_document,omitempty"`    // per-document breakdown, when project_id is given
+	ReadOnly   bool                       `json:"read_only,omitempty"`
 }
 
 type ProjectIDRequest struct {
@@ -227,11 +253,67 @@ type SavedSettings struct {
 	AnthropicKey   string `json:"anthropic_key"`
 	HuggingFaceKey string `json:"huggingface_key"`
 	DefaultLLM     string `json:"default_llm"`
-	EmbedProvider  string `json:"embed_provider"`
-	EmbedModel     string `json:"embed_model"`
-	OCRProvider    string `json:"ocr_provider"`
-	SarvamKey      string `json:"sarvam_key"`
-	TesseractLang  string `json:"tesseract_lang"`
+	// DefaultModel is DefaultLLM's model for queries that name none; ""
+	// is the provider's own default.
+	DefaultModel  string `json:"default_model,omitempty"`
+	EmbedProvider string `json:"embed_provider"`
+	EmbedModel    string `json:"embed_model"`
+	OCRProvider   string `json:"ocr_provider"`
+	SarvamKey     string `json:"sarvam_key"`
+	TesseractLang string `json:"tesseract_lang"`
+	// MultilingualEmbedModel, if set, embeds non-English pages on the same
+	// provider (e.g. "BAAI/bge-m3" with an English-only HuggingFace model).
+	MultilingualEmbedModel string `json:"multilingual_embed_model,omitempty"`
+	// Ingestion throughput; 0 uses the defaults (4 files extracted at a
+	// time, and each embedding provider's own batch size and concurrency).
+	ExtractWorkers   int `json:"extract_workers,omitempty"`
+	EmbedConcurrency int `json:"embed_concurrency,omitempty"`
+	EmbedBatchSize   int `json:"embed_batch_size,omitempty"`
+	// ChunkWords and ChunkOverlap shape the chunks of later ingestions; 0
+	// is 150 words overlapping by 30.
+	ChunkWords   int `json:"chunk_words,omitempty"`
+	ChunkOverlap int `json:"chunk_overlap,omitempty"`
+	// TopK is how many chunks a query retrieves when it doesn't say; 0
+	// picks by question type.
+	TopK int `json:"top_k,omitempty"`
+	// DocTypePrompts overrides the answering instructions added for a
+	// document type (see llm.DocTypePrompts); "" turns a type's off.
+	DocTypePrompts map[string]string `json:"doc_type_prompts,omitempty"`
+	// SummaryProvider and SummaryModel pick the LLM for ingest summaries;
+	// "" follows DefaultLLM and that provider's cheap default model.
+	SummaryProvider string `json:"summary_provider,omitempty"`
+	SummaryModel    string `json:"summary_model,omitempty"`
+	// RouteMinDocuments turns on summary-guided routing for projects with
+	// at least this many summarized documents (see routeQuestion); 0 is off.
+	RouteMinDocuments int `json:"route_min_documents,omitempty"`
+	// HierarchicalSummaries adds section and document summary nodes to the
+	// index at ingest, alongside the page chunks.
+	HierarchicalSummaries bool `json:"hierarchical_summaries,omitempty"`
+	// SMTP server for job notifications (see notify.go); SMTPPort 0 is
+	// 587. The password is encrypted at rest like the API keys.
+	SMTPHost     string `json:"smtp_host,omitempty"`
+	SMTPPort     int    `json:"smtp_port,omitempty"`
+	SMTPUsername string `json:"smtp_username,omitempty"`
+	SMTPPassword string `json:"smtp_password,omitempty"`
+	SMTPFrom     string `json:"smtp_from,omitempty"`
+	// NotifyEmail (comma-separated) is emailed when a batch job, or an
+	// ingestion of at least NotifyMinFiles files (0 = 10), finishes.
+	NotifyEmail    string `json:"notify_email,omitempty"`
+	NotifyMinFiles int    `json:"notify_min_files,omitempty"`
+	// Profiles are named presets of the provider, model, embedding,
+	// chunking and retrieval settings (see profiles.go).
+	Profiles map[string]SettingsProfile `json:"profiles,omitempty"`
+}
+
+// defaultExtractWorkers is how many files ingestion extracts at once.
+const defaultExtractWorkers = 4
+
+// extractWorkers returns the configured extraction parallelism.
+func (s *SavedSettings) extractWorkers() int {
+	if s.ExtractWorkers > 0 {
+		return s.ExtractWorkers
+	}
+	return defaultExtractWorkers
 }
 
 func loadSavedSettings() *SavedSettings {
@@ -246,60 +328,251 @@ func loadSavedSettings() *SavedSettings {
 	}
 
 	// Decrypt API key fields (backward-compatible: if decryption fails, use raw value)
-	s.OpenAIKey = decryptOrPassthrough(s.OpenAIKey)
-	s.AnthropicKey = decryptOrPassthrough(s.AnthropicKey)
-	s.HuggingFaceKey = decryptOrPassthrough(s.HuggingFaceKey)
-	s.SarvamKey = decryptOrPassthrough(s.SarvamKey)
+	decryptSecrets(&s)
 
 	return &s
 }
 
-// decryptOrPassthrough tries to decrypt a value; if it fails (e.g. legacy
-// plaintext), returns the original value unchanged. This provides backward
-// compatibility with existing unencrypted settings.json files.
-func decryptOrPassthrough(val string) string {
+// envOnlyKeysEnv enables environment-only mode: API keys are read from
+// OPENAI_API_KEY, ANTHROPIC_API_KEY, HUGGINGFACE_API_KEY and SARVAM_API_KEY
+// (and the SMTP password from SMTP_PASSWORD), settings POSTs change them in
+// memory only, and key fields are always written to settings files as
+// empty strings. Non-secret preferences still persist.
+const envOnlyKeysEnv = "GOCOGNIGO_ENV_ONLY_KEYS"
+
+// envOnlyKeys is set once at startup from envOnlyKeysEnv.
+var envOnlyKeys bool
+
+// applyEnvKeys replaces the API key fields with the values from the environment.
+func applyEnvKeys(s *SavedSettings) {
+	s.OpenAIKey = os.Getenv("OPENAI_API_KEY")
+	s.AnthropicKey = os.Getenv("ANTHROPIC_API_KEY")
+	s.HuggingFaceKey = os.Getenv("HUGGINGFACE_API_KEY")
+	s.SarvamKey = os.Getenv("SARVAM_API_KEY")
+	s.SMTPPassword = os.Getenv("SMTP_PASSWORD")
+}
+
+// secretFields returns pointers to the API key fields that are encrypted at rest.
+func secretFields(s *SavedSettings) []*string {
+	return []*string{&s.OpenAIKey, &s.AnthropicKey, &s.HuggingFaceKey, &s.SarvamKey, &s.SMTPPassword}
+}
+
+// decryptSecrets decrypts the API key fields in place. It reports whether any
+// value was only readable with the legacy machine-derived key (or was stored
+// as plaintext), meaning the file should be re-encrypted with the current key.
+func decryptSecrets(s *SavedSettings) (needsMigration bool) {
+	for _, field := range secretFields(s) {
+		val, current := decryptOrPassthrough(*field)
+		if !current {
+			needsMigration = true
+		}
+		*field = val
+	}
+	return needsMigration
+}
+
+// encryptSecrets returns a copy of s with the API key fields encrypted, or
+// blanked in environment-only mode (see keepStoredSecrets).
+func encryptSecrets(s SavedSettings) SavedSettings {
+	toSave := s
+	for _, field := range secretFields(&toSave) {
+		if envOnlyKeys {
+			*field = ""
+			continue
+		}
+		enc, err := crypto.Encrypt(*field)
+		if err != nil {
+			log.Printf("Warning: failed to encrypt API key: %v", err)
+			continue // fall back to plaintext
+		}
+		*field = enc
+	}
+	return toSave
+}
+
+// decryptOrPassthrough tries to decrypt a value with the current key, then the
+// legacy machine-derived key; if both fail (e.g. legacy plaintext), returns the
+// original value unchanged. current is false when the value was not encrypted
+// with the current key.
+func decryptOrPassthrough(val string) (plain string, current bool) {
 	if val == "" {
-		return ""
+		return "", true
 	}
-	decrypted, err := crypto.Decrypt(val)
-	if err != nil {
-		// Not encrypted (legacy plaintext) — use as-is
-		return val
+	if decrypted, err := crypto.Decrypt(val); err == nil {
+		return decrypted, true
 	}
-	return decrypted
+	if decrypted, err := crypto.DecryptLegacy(val); err == nil {
+		return decrypted, false
+	}
+	// Not encrypted (legacy plaintext) — use as-is
+	return val, false
 }
 
+// settingsWriteMu serialises settings file writes so key rotation cannot
+// interleave with a concurrent save.
+var settingsWriteMu sync.Mutex
+
 func persistSettings(s SavedSettings) error {
 	_ = os.MkdirAll("data", 0755)
+	settingsWriteMu.Lock()
+	defer settingsWriteMu.Unlock()
+	return writeSettingsFile(settingsFile, s)
+}
 
-	// Encrypt API key fields before writing to disk
-	toSave := s
-	var err error
-	if toSave.OpenAIKey, err = crypto.Encrypt(s.OpenAIKey); err != nil {
-		log.Printf("Warning: failed to encrypt OpenAI key: %v", err)
-		toSave.OpenAIKey = s.OpenAIKey // fall back to plaintext
+// writeSettingsFile encrypts the API key fields and writes s to path. In
+// environment-only mode the file keeps the keys it already stores.
+func writeSettingsFile(path string, s SavedSettings) error {
+	toSave := encryptSecrets(s)
+	if envOnlyKeys {
+		keepStoredSecrets(path, &toSave)
 	}
-	if toSave.AnthropicKey, err = crypto.Encrypt(s.AnthropicKey); err != nil {
-		log.Printf("Warning: failed to encrypt Anthropic key: %v", err)
-		toSave.AnthropicKey = s.AnthropicKey
+	data, err := json.MarshalIndent(toSave, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// keepStoredSecrets sets the API key fields of s to those of the settings
+// file at path, still encrypted, so that saving in environment-only mode
+// leaves the stored keys as they were.
+func keepStoredSecrets(path string, s *SavedSettings) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
 	}
-	if toSave.HuggingFaceKey, err = crypto.Encrypt(s.HuggingFaceKey); err != nil {
-		log.Printf("Warning: failed to encrypt HuggingFace key: %v", err)
-		toSave.HuggingFaceKey = s.HuggingFaceKey
+	var stored SavedSettings
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return
 	}
-	if toSave.SarvamKey, err = crypto.Encrypt(s.SarvamKey); err != nil {
-		log.Printf("Warning: failed to encrypt Sarvam key: %v", err)
-		toSave.SarvamKey = s.SarvamKey
+	fields := secretFields(s)
+	for i, field := range secretFields(&stored) {
+		*fields[i] = *field
 	}
+}
 
-	data, err := json.MarshalIndent(toSave, "", "  ")
-	if err != nil {
-		return err
+// settingsFilePaths lists the global settings file and every per-user one.
+func settingsFilePaths() []string {
+	paths, _ := filepath.Glob("data/users/*_settings.json")
+	return append(paths, settingsFile)
+}
+
+// rotateSettingsKey decrypts every stored settings file, and every project's
+// key file, with the current key, rotates to new key material (see
+// crypto.Rotate) and re-encrypts the files with it. Returns the number of
+// files rewritten. It refuses in environment-only mode, where the files
+// would be rewritten with their keys blanked.
+func rotateSettingsKey(passphrase string) (int, error) {
+	if envOnlyKeys {
+		return 0, fmt.Errorf("stored keys can't be re-encrypted in environment-only mode (%s); rotate without it", envOnlyKeysEnv)
+	}
+	settingsWriteMu.Lock()
+	defer settingsWriteMu.Unlock()
+
+	decrypted := make(map[string]SavedSettings)
+	for _, path := range settingsFilePaths() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var s SavedSettings
+		if err := json.Unmarshal(b, &s); err != nil {
+			return 0, fmt.Errorf("parse %s: %w", path, err)
+		}
+		decryptSecrets(&s)
+		decrypted[path] = s
+	}
+	decryptedKeys := make(map[string]ProjectKeys)
+	for _, path := range projectKeyFilePaths() {
+		keys, _, err := readProjectKeys(path)
+		if err != nil {
+			return 0, err
+		}
+		decryptedKeys[path] = keys
+	}
+
+	if err := crypto.Rotate("data", passphrase); err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	var firstErr error
+	for path, s := range decrypted {
+		if err := writeSettingsFile(path, s); err != nil {
+			log.Printf("ERROR: failed to re-encrypt %s after key rotation: %v", path, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("re-encrypt %s: %w", path, err)
+			}
+			continue
+		}
+		rewritten++
+	}
+	for path, keys := range decryptedKeys {
+		if err := writeProjectKeys(path, keys); err != nil {
+			log.Printf("ERROR: failed to re-encrypt %s after key rotation: %v", path, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("re-encrypt %s: %w", path, err)
+			}
+			continue
+		}
+		rewritten++
+	}
+	return rewritten, firstErr
+}
+
+// migrateSettingsFiles re-encrypts settings files still holding values
+// encrypted with the legacy machine-derived key (or plaintext) with the
+// current key. In environment-only mode it leaves the files alone: writing
+// them would blank their keys, and stored keys must survive a run with the
+// mode on. Called once at startup after crypto.Init.
+func migrateSettingsFiles() {
+	if envOnlyKeys {
+		return
+	}
+	for _, path := range settingsFilePaths() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var s SavedSettings
+		if err := json.Unmarshal(b, &s); err != nil {
+			log.Printf("Warning: could not parse %s: %v", path, err)
+			continue
+		}
+		if !decryptSecrets(&s) {
+			continue
+		}
+		if err := writeSettingsFile(path, s); err != nil {
+			log.Printf("Warning: failed to migrate %s: %v", path, err)
+			continue
+		}
+		log.Printf("Re-encrypted API keys in %s with the %s key", path, crypto.Source())
+	}
+
+	for _, path := range projectKeyFilePaths() {
+		keys, needsMigration, err := readProjectKeys(path)
+		if err != nil {
+			log.Printf("Warning: could not read %s: %v", path, err)
+			continue
+		}
+		if !needsMigration {
+			continue
+		}
+		if err := writeProjectKeys(path, keys); err != nil {
+			log.Printf("Warning: failed to migrate %s: %v", path, err)
+			continue
+		}
+		log.Printf("Re-encrypted API keys in %s with the %s key", path, crypto.Source())
 	}
-	return os.WriteFile(settingsFile, data, 0644)
 }
 
 func maskKey(key string) string {
+	if keys := httpclient.SplitKeys(key); len(keys) > 1 {
+		for i, k := range keys {
+			keys[i] = maskKey(k)
+		}
+		return strings.Join(keys, ", ")
+	}
 	if len(key) <= 8 {
 		if key == "" {
 			return ""
@@ -315,7 +588,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+workspaceHeader)
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -340,13 +613,54 @@ func (s *Server) getProvider(settings *SavedSettings, requestedProvider, request
 	case "huggingface":
 		apiKey = settings.HuggingFaceKey
 	}
-	
+
 	if apiKey == "" || strings.Contains(apiKey, "your_") {
 		return nil, fmt.Errorf("no API key configured for provider: %s", provider)
 	}
+	if requestedModel == "" && provider == settings.DefaultLLM {
+		requestedModel = settings.DefaultModel
+	}
 	return llm.NewProvider(provider, apiKey, requestedModel)
 }
 
+// providerKey returns the configured API key for an LLM provider.
+func providerKey(settings *SavedSettings, provider string) string {
+	var key string
+	switch provider {
+	case "openai":
+		key = settings.OpenAIKey
+	case "anthropic":
+		key = settings.AnthropicKey
+	case "huggingface":
+		key = settings.HuggingFaceKey
+	}
+	if strings.Contains(key, "your_") {
+		return ""
+	}
+	return key
+}
+
+// summaryCompleter picks the LLM for ingest summaries: the summary provider,
+// else the default LLM, else the first provider with a key. The model
+// override applies only to the provider it was chosen for. ok is false
+// when no provider has a key.
+func summaryCompleter(settings *SavedSettings) (c llm.Completer, ok bool) {
+	preferred := settings.SummaryProvider
+	if preferred == "" {
+		preferred = settings.DefaultLLM
+	}
+	for _, p := range []string{preferred, "openai", "anthropic", "huggingface"} {
+		if key := providerKey(settings, p); key != "" {
+			c = llm.Completer{Provider: p, APIKey: key}
+			if p == preferred {
+				c.Model = settings.SummaryModel
+			}
+			return c, true
+		}
+	}
+	return llm.Completer{}, false
+}
+
 func jsonResp(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(v)
@@ -358,14 +672,23 @@ func jsonErr(w http.ResponseWriter, msg string, code int) {
 	json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
+// storeUID returns the key a user's projects and settings are stored under:
+// within a workspace, the workspace's.
+func storeUID(r *http.Request) string {
+	if id := getWorkspaceID(r); id != "" {
+		return workspaceKeyPrefix + id
+	}
+	return requestUID(r)
+}
+
 // getProjectStore returns a chat.ProjectStore tied to the current user.
-// Uses read-lock for the common case, upgrading to write-lock only on first access.
 func (s *Server) getProjectStore(r *http.Request) *chat.ProjectStore {
-	uid := getUserUID(r)
-	if uid == "" {
-		uid = "local_dev_user" // Fallback if auth is disabled
-	}
+	return s.projectStoreFor(storeUID(r))
+}
 
+// projectStoreFor returns the chat.ProjectStore of the user stored under uid.
+// Uses read-lock for the common case, upgrading to write-lock only on first access.
+func (s *Server) projectStoreFor(uid string) *chat.ProjectStore {
 	// Fast path: read-lock check
 	s.mu.RLock()
 	if s.userProjects != nil {
@@ -397,20 +720,26 @@ func (s *Server) getProjectStore(r *http.Request) *chat.ProjectStore {
 		log.Printf("Error creating project store for user %s: %v", uid, err)
 		return nil
 	}
+	if id, ok := strings.CutPrefix(uid, workspaceKeyPrefix); ok {
+		sStore.SetWorkspace(id)
+	}
+	if dataMirror != nil {
+		sStore.SetFetch(fetchStoredDir)
+	}
 	s.userProjects[uid] = sStore
 
 	return sStore
 }
 
 // getUserSettings returns the SavedSettings tied to the current user.
-// Uses read-lock for the common case (settings already loaded), upgrading to
-// write-lock only on first access to avoid serializing all requests.
 func (s *Server) getUserSettings(r *http.Request) *SavedSettings {
-	uid := getUserUID(r)
-	if uid == "" {
-		uid = "local_dev_user"
-	}
+	return s.userSettingsFor(storeUID(r))
+}
 
+// userSettingsFor returns the SavedSettings of the user stored under uid.
+// Uses read-lock for the common case (settings already loaded), upgrading to
+// write-lock only on first access to avoid serializing all requests.
+func (s *Server) userSettingsFor(uid string) *SavedSettings {
 	// Fast path: read-lock check
 	s.mu.RLock()
 	if s.userSettings != nil {
@@ -449,16 +778,16 @@ func (s *Server) getUserSettings(r *http.Request) *SavedSettings {
 			OCRProvider:   "tesseract",
 		}
 	}
+	if envOnlyKeys {
+		applyEnvKeys(settings)
+	}
 	s.userSettings[uid] = settings
 	return settings
 }
 
 // saveUserSettings persists the settings to disk for a given user
 func (s *Server) saveUserSettings(r *http.Request, settings *SavedSettings) error {
-	uid := getUserUID(r)
-	if uid == "" {
-		uid = "local_dev_user"
-	}
+	uid := storeUID(r)
 
 	s.mu.Lock()
 	if s.userSettings == nil {
@@ -470,31 +799,9 @@ func (s *Server) saveUserSettings(r *http.Request, settings *SavedSettings) erro
 	_ = os.MkdirAll("data/users", 0755)
 	path := fmt.Sprintf("data/users/%s_settings.json", uid)
 
-	// Encrypt API key fields before writing to disk
-	toSave := *settings
-	var encErr error
-	if toSave.OpenAIKey, encErr = crypto.Encrypt(settings.OpenAIKey); encErr != nil {
-		log.Printf("Warning: failed to encrypt OpenAI key: %v", encErr)
-		toSave.OpenAIKey = settings.OpenAIKey
-	}
-	if toSave.AnthropicKey, encErr = crypto.Encrypt(settings.AnthropicKey); encErr != nil {
-		log.Printf("Warning: failed to encrypt Anthropic key: %v", encErr)
-		toSave.AnthropicKey = settings.AnthropicKey
-	}
-	if toSave.HuggingFaceKey, encErr = crypto.Encrypt(settings.HuggingFaceKey); encErr != nil {
-		log.Printf("Warning: failed to encrypt HuggingFace key: %v", encErr)
-		toSave.HuggingFaceKey = settings.HuggingFaceKey
-	}
-	if toSave.SarvamKey, encErr = crypto.Encrypt(settings.SarvamKey); encErr != nil {
-		log.Printf("Warning: failed to encrypt Sarvam key: %v", encErr)
-		toSave.SarvamKey = settings.SarvamKey
-	}
-
-	b, err := json.MarshalIndent(toSave, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(path, b, 0644)
+	settingsWriteMu.Lock()
+	defer settingsWriteMu.Unlock()
+	return writeSettingsFile(path, *settings)
 }
 
 // getAllPublishedProjects returns all published projects from every user's store.
@@ -542,9 +849,6 @@ func loadSettingsFromPath(path string) (SavedSettings, error) {
 	}
 
 	// Decrypt API key fields (backward-compatible: if decryption fails, use raw value)
-	s.OpenAIKey = decryptOrPassthrough(s.OpenAIKey)
-	s.AnthropicKey = decryptOrPassthrough(s.AnthropicKey)
-	s.HuggingFaceKey = decryptOrPassthrough(s.HuggingFaceKey)
-	s.SarvamKey = decryptOrPassthrough(s.SarvamKey)
+	decryptSecrets(&s)
 	return s, nil
 }
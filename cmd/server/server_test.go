@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/llm"
+)
+
+// ========== Summaries ==========
+
+func TestRegenerateDocSummary_SummaryOnlyDocument(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "a.pdf_doc", Document: "a.pdf", PageNumber: 1, PageEnd: 3, Level: indexer.LevelDocument, Text: "A summary of a.pdf"},
+		{ID: "b.pdf_p1_c0", Document: "b.pdf", PageNumber: 1, Text: "Page text", ParentText: "Page text"},
+	}
+	// No page text to summarize: fails before calling the LLM
+	_, err := regenerateDocSummary(context.Background(), llm.Completer{Provider: "openai"}, chunks, "a.pdf")
+	if !errors.Is(err, errNoPageText) {
+		t.Errorf("summary-only document: got %v, want errNoPageText", err)
+	}
+}
+
+// ========== Environment-only keys ==========
+
+func TestWriteSettingsFile_EnvOnlyKeepsStoredKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	if err := writeSettingsFile(path, SavedSettings{OpenAIKey: "sk-stored", SMTPPassword: "smtp-stored", DefaultLLM: "openai"}); err != nil {
+		t.Fatal(err)
+	}
+
+	envOnlyKeys = true
+	t.Cleanup(func() { envOnlyKeys = false })
+	if err := writeSettingsFile(path, SavedSettings{OpenAIKey: "sk-from-env", DefaultLLM: "anthropic"}); err != nil {
+		t.Fatal(err)
+	}
+	envOnlyKeys = false
+
+	s := loadSettingsFrom(t, path)
+	if s.DefaultLLM != "anthropic" {
+		t.Errorf("DefaultLLM = %q, want the new preference", s.DefaultLLM)
+	}
+	if s.OpenAIKey != "sk-stored" || s.SMTPPassword != "smtp-stored" {
+		t.Errorf("keys after an env-only save: %q, %q; want the stored ones", s.OpenAIKey, s.SMTPPassword)
+	}
+}
+
+func TestWriteProjectKeys_EnvOnlyLeavesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := writeProjectKeys(path, ProjectKeys{OpenAIKey: "sk-project"}); err != nil {
+		t.Fatal(err)
+	}
+
+	envOnlyKeys = true
+	t.Cleanup(func() { envOnlyKeys = false })
+	if err := writeProjectKeys(path, ProjectKeys{}); err != nil {
+		t.Fatal(err)
+	}
+	envOnlyKeys = false
+
+	keys, _, err := readProjectKeys(path)
+	if err != nil || keys.OpenAIKey != "sk-project" {
+		t.Errorf("project keys after an env-only save: %+v, %v; want the stored ones", keys, err)
+	}
+}
+
+// loadSettingsFrom reads and decrypts the settings file at path.
+func loadSettingsFrom(t *testing.T, path string) SavedSettings {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s SavedSettings
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	decryptSecrets(&s)
+	return s
+}
+
+// ========== Quotas ==========
+
+func TestOverrideQuotas_OnlyTightens(t *testing.T) {
+	defaults := chat.ProjectQuotas{MaxFiles: 100, MonthlyTokenBudget: 1000}
+	got := overrideQuotas(defaults, chat.ProjectQuotas{MaxFiles: 500, MaxChunks: 50, MonthlyTokenBudget: 10})
+	want := chat.ProjectQuotas{MaxFiles: 100, MaxChunks: 50, MonthlyTokenBudget: 10}
+	if got != want {
+		t.Errorf("overrideQuotas = %+v, want %+v", got, want)
+	}
+}
+
+func TestClampQuotas(t *testing.T) {
+	limits := chat.ProjectQuotas{MaxFiles: 100, MaxChunks: 1000}
+	got := clampQuotas(chat.ProjectQuotas{MaxFiles: 500, MaxUploadBytes: 1 << 20, MaxChunks: 10}, limits)
+	want := chat.ProjectQuotas{MaxFiles: 100, MaxUploadBytes: 1 << 20, MaxChunks: 10}
+	if got != want {
+		t.Errorf("clampQuotas = %+v, want %+v", got, want)
+	}
+}
+
+// ========== Workspaces ==========
+
+func TestGetVerifiedEmail(t *testing.T) {
+	for _, verified := range []bool{false, true} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		ctx := context.WithValue(r.Context(), userEmailKey, "invited@example.com")
+		ctx = context.WithValue(ctx, userEmailVerifiedKey, verified)
+		want := ""
+		if verified {
+			want = "invited@example.com"
+		}
+		if got := getVerifiedEmail(r.WithContext(ctx)); got != want {
+			t.Errorf("verified %v: got %q, want %q", verified, got, want)
+		}
+	}
+}
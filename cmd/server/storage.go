@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocognigo/internal/backup"
+	"gocognigo/internal/storage"
+)
+
+// ========== Durable Storage ==========
+
+// With storageEnv set, data/ is a working copy of a bucket (or of a
+// directory on a mounted volume), so the server can run in a container
+// whose own disk doesn't outlive it. At startup it pulls everything but the
+// projects' files, which each project pulls when first used; changes are
+// pushed every storageSyncEnv seconds and on shutdown. Only one server may
+// use a storage location at a time: the data directory lock is local.
+
+const (
+	storageEnv     = "GOCOGNIGO_STORAGE"
+	storageSyncEnv = "GOCOGNIGO_STORAGE_SYNC_SECONDS"
+)
+
+const defaultStorageSync = 30 * time.Second
+
+// dataMirror mirrors data/ in durable storage; nil unless storageEnv is set.
+var dataMirror *storage.Mirror
+
+// mirrorSkip names the files never mirrored (nor backed up): the instance
+// lock, key material (use GOCOGNIGO_PASSPHRASE with durable storage), temp
+// files and backups kept in the same place.
+func mirrorSkip(key string) bool {
+	name := path.Base(key)
+	return name == ".instance.lock" || name == ".secret.key" ||
+		strings.HasPrefix(name, ".upload-") || strings.HasPrefix(name, ".render-") ||
+		backup.IsName(name)
+}
+
+// inProjectDir reports whether key is a file in a project's directory,
+// pulled when the project is first used. Projects' key files are pulled at
+// startup, when they are migrated or re-encrypted.
+func inProjectDir(key string) bool {
+	parts := strings.Split(key, "/")
+	if len(parts) < 4 || parts[0] != "users" || !strings.HasSuffix(parts[1], "_projects") {
+		return false
+	}
+	return len(parts) > 4 || parts[3] != "keys.json"
+}
+
+// openDataStorage restores data/ from the storage at storageEnv, if set.
+// It runs before anything reads the data directory.
+func openDataStorage() error {
+	location := strings.TrimSpace(os.Getenv(storageEnv))
+	if location == "" {
+		return nil
+	}
+	st, err := storage.Open(location)
+	if err != nil {
+		return err
+	}
+	m := storage.NewMirror("data", st, mirrorSkip)
+	start := time.Now()
+	n, err := m.Pull("", inProjectDir)
+	if err != nil {
+		return fmt.Errorf("could not restore data from %s: %w", st, err)
+	}
+	log.Printf("Durable storage at %s: restored %d files in %v; projects are fetched when first used",
+		st, n, time.Since(start).Round(time.Millisecond))
+	dataMirror = m
+	return nil
+}
+
+// fetchStoredDir pulls a project directory from durable storage; it is
+// the projects stores' fetch function (see chat.ProjectStore.SetFetch).
+func fetchStoredDir(dir string) error {
+	key, err := dataMirror.Key(dir)
+	if err != nil {
+		return err
+	}
+	n, err := dataMirror.Pull(key+"/", nil)
+	if n > 0 {
+		log.Printf("Durable storage: fetched %d files of %s", n, key)
+	}
+	return err
+}
+
+// forgetStoredDir deletes a removed directory's files from durable
+// storage, those never fetched included.
+func forgetStoredDir(dir string) {
+	if dataMirror == nil {
+		return
+	}
+	key, err := dataMirror.Key(dir)
+	if err != nil {
+		return
+	}
+	go func() {
+		if _, err := dataMirror.Remove(key + "/"); err != nil {
+			log.Printf("Durable storage: could not delete %s: %v", key, err)
+		}
+	}()
+}
+
+// pushDataStorage sends durable storage the changes to data/ since the last
+// push.
+func pushDataStorage() {
+	if dataMirror == nil {
+		return
+	}
+	stored, deleted, err := dataMirror.Push("")
+	if err != nil {
+		log.Printf("Durable storage: push failed, will retry: %v", err)
+	}
+	if stored > 0 || deleted > 0 {
+		log.Printf("Durable storage: stored %d files, deleted %d", stored, deleted)
+	}
+}
+
+// startStorageSync pushes data/ to durable storage periodically.
+func startStorageSync() {
+	if dataMirror == nil {
+		return
+	}
+	every := defaultStorageSync
+	if n, err := strconv.Atoi(os.Getenv(storageSyncEnv)); err == nil && n > 0 {
+		every = time.Duration(n) * time.Second
+	}
+	go func() {
+		for range time.Tick(every) {
+			pushDataStorage()
+		}
+	}()
+}
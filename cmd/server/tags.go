@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Document Tags ==========
+
+// Documents can carry user-assigned tags ("contract", "board-minutes",
+// "2023"), kept in the project's DocumentTags. A query passing tags searches
+// only documents that carry all of them.
+
+// Tag limits.
+const (
+	maxTagLength = 64
+	maxDocTags   = 32
+)
+
+// normalizeTags trims, lower-cases and de-duplicates tags, in sorted order.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if len(t) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) > maxDocTags {
+		return nil, fmt.Errorf("a document can have at most %d tags", maxDocTags)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// tagFilters resolves a query's tags against the project's assignments. It
+// fails when no document carries them all, rather than answering from
+// nothing.
+func tagFilters(proj *chat.Project, tags []string) (retriever.Filters, error) {
+	norm, err := normalizeTags(tags)
+	if err != nil || len(norm) == 0 {
+		return retriever.Filters{}, err
+	}
+	f := retriever.Filters{Tags: norm, DocumentTags: proj.DocumentTags}
+	for doc := range proj.DocumentTags {
+		if f.Allows(doc) {
+			return f, nil
+		}
+	}
+	return retriever.Filters{}, fmt.Errorf("no document is tagged %s", strings.Join(norm, " and "))
+}
+
+// tagCounts counts the documents carrying each tag.
+func tagCounts(proj *chat.Project) map[string]int {
+	counts := map[string]int{}
+	for _, tags := range proj.DocumentTags {
+		for _, t := range tags {
+			counts[t]++
+		}
+	}
+	return counts
+}
+
+// removeDocumentTags forgets a deleted document's tags.
+func removeDocumentTags(store *chat.ProjectStore, projectID, name string) {
+	proj, err := store.Get(projectID)
+	if err != nil || proj.DocumentTags[name] == nil {
+		return
+	}
+	delete(proj.DocumentTags, name)
+	_ = store.Update(*proj)
+}
+
+// handleDocumentTags lists a project's document tags (GET ?project_id=) or
+// replaces one document's tags (POST {project_id, document, tags}); an empty
+// tags list clears them.
+func (s *Server) handleDocumentTags(w http.ResponseWriter, r *http.Request) {
+	store := s.getProjectStore(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		proj, err := store.Get(r.URL.Query().Get("project_id"))
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		docs := proj.DocumentTags
+		if docs == nil {
+			docs = map[string][]string{}
+		}
+		jsonResp(w, map[string]interface{}{"documents": docs, "tags": tagCounts(proj)})
+
+	case http.MethodPost:
+		var req struct {
+			ProjectID string   `json:"project_id"`
+			Document  string   `json:"document"`
+			Tags      []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProjectID == "" || req.Document == "" {
+			jsonErr(w, "project_id and document are required", http.StatusBadRequest)
+			return
+		}
+		clean := filepath.Base(req.Document)
+		if clean != req.Document || clean == "." || clean == ".." {
+			jsonErr(w, "invalid filename", http.StatusBadRequest)
+			return
+		}
+		tags, err := normalizeTags(req.Tags)
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		proj, err := store.Get(req.ProjectID)
+		if err != nil {
+			jsonErr(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(store.UploadsDir(req.ProjectID), clean)); err != nil {
+			jsonErr(w, "file not found", http.StatusNotFound)
+			return
+		}
+
+		if len(tags) == 0 {
+			delete(proj.DocumentTags, clean)
+		} else {
+			if proj.DocumentTags == nil {
+				proj.DocumentTags = map[string][]string{}
+			}
+			proj.DocumentTags[clean] = tags
+		}
+		if err := store.Update(*proj); err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "document.tags", req.ProjectID, clean, map[string]string{"tags": strings.Join(tags, ",")})
+		jsonResp(w, map[string]interface{}{"document": clean, "tags": tags})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
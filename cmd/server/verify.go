@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"gocognigo/internal/llm"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Second-Model Verification ==========
+
+// A query with "verify" set has its draft answer checked by a second model
+// against the excerpts it cites before it is returned, for answers headed
+// into filings. The check's agreement status and discrepancies come back
+// in the answer's verification field; they don't change the answer.
+
+// verifyCompleter picks the verifying model. An explicit provider must have
+// a key, and model applies only to it. Otherwise it prefers a provider
+// other than the one answering, so the check isn't the same model marking
+// its own work, and falls back to the answering provider; either way with
+// that provider's cheap default model.
+func verifyCompleter(settings *SavedSettings, answering, provider, model string) (*llm.Completer, error) {
+	if provider != "" {
+		key := providerKey(settings, provider)
+		if key == "" {
+			return nil, fmt.Errorf("no API key configured for verify_provider %s", provider)
+		}
+		return &llm.Completer{Provider: provider, APIKey: key, Model: model}, nil
+	}
+	if answering == "" {
+		answering = settings.DefaultLLM
+	}
+	for _, p := range []string{"anthropic", "openai", "huggingface"} {
+		if p == answering {
+			continue
+		}
+		if key := providerKey(settings, p); key != "" {
+			return &llm.Completer{Provider: p, APIKey: key}, nil
+		}
+	}
+	if key := providerKey(settings, answering); key != "" {
+		return &llm.Completer{Provider: answering, APIKey: key}, nil
+	}
+	return nil, fmt.Errorf("no LLM provider configured to verify answers")
+}
+
+// verifyAnswer runs the second-model check of answer and attaches it.
+func (s *Server) verifyAnswer(ctx context.Context, r *http.Request, projectID string, c llm.Completer, question string, answer *llm.Answer, results []retriever.Result) {
+	v, usage := llm.VerifyAnswer(ctx, c, question, answer, results)
+	if usage != nil {
+		recordTokenUsage(s.getProjectStore(r), projectID, usage)
+	}
+	answer.Verification = v
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gocognigo/internal/chat"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ========== Document Versions ==========
+
+// Uploading a file under an existing name keeps the old file as a version
+// under <project>/versions/, together with its indexed chunks when the
+// project's index is loaded. The old chunks leave the live index, so the
+// next ingestion indexes only the new version. Queries may pass as_of to
+// search the set of versions that was current at a point in time.
+
+// docVersion is one superseded version of a document.
+type docVersion struct {
+	Document   string    `json:"document"`
+	Version    int       `json:"version"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+	Size       int64     `json:"size"`
+	File       string    `json:"file"`             // archived upload, relative to the versions directory
+	ChunksFile string    `json:"chunks,omitempty"` // archived chunks with embeddings; "" if the index wasn't loaded
+}
+
+// versionsMu serializes read-modify-write of versions.json files.
+var versionsMu sync.Mutex
+
+func versionsDir(store *chat.ProjectStore, projectID string) string {
+	return filepath.Join(store.ProjectDir(projectID), "versions")
+}
+
+func loadVersions(store *chat.ProjectStore, projectID string) []docVersion {
+	data, err := os.ReadFile(filepath.Join(versionsDir(store, projectID), "versions.json"))
+	if err != nil {
+		return nil
+	}
+	var versions []docVersion
+	_ = json.Unmarshal(data, &versions)
+	return versions
+}
+
+func saveVersions(store *chat.ProjectStore, projectID string, versions []docVersion) error {
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(versionsDir(store, projectID), "versions.json"), data, 0644)
+}
+
+// archiveVersion moves the current upload of name into the version history
+// and takes its chunks out of the loaded index.
+func (s *Server) archiveVersion(store *chat.ProjectStore, projectID, name string) (*docVersion, error) {
+	current := filepath.Join(store.UploadsDir(projectID), name)
+	info, err := os.Stat(current)
+	if err != nil {
+		return nil, err
+	}
+
+	versionsMu.Lock()
+	defer versionsMu.Unlock()
+	versions := loadVersions(store, projectID)
+	v := docVersion{Document: name, Version: 1, UploadedAt: info.ModTime(), ReplacedAt: time.Now(), Size: info.Size()}
+	for _, old := range versions {
+		if old.Document == name && old.Version >= v.Version {
+			v.Version = old.Version + 1
+		}
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	sub := hex.EncodeToString(sum[:8])
+	if err := os.MkdirAll(filepath.Join(versionsDir(store, projectID), sub), 0755); err != nil {
+		return nil, err
+	}
+	v.File = filepath.Join(sub, fmt.Sprintf("v%d%s", v.Version, filepath.Ext(name)))
+	if err := os.Rename(current, filepath.Join(versionsDir(store, projectID), v.File)); err != nil {
+		return nil, err
+	}
+
+	if rw, err := s.getRetrieverForProject(projectID); err == nil {
+		var chunks []indexer.Chunk
+		rw.idx.Lock()
+		for _, c := range rw.idx.Chunks {
+			if c.Document == name {
+				chunks = append(chunks, c)
+			}
+		}
+		rw.idx.Unlock()
+		if len(chunks) > 0 {
+			chunksFile := filepath.Join(sub, fmt.Sprintf("v%d.chunks.json", v.Version))
+			if err := indexer.SaveChunks(filepath.Join(versionsDir(store, projectID), chunksFile), chunks); err != nil {
+				log.Printf("Warning: failed to archive chunks of %s v%d: %v", name, v.Version, err)
+			} else {
+				v.ChunksFile = chunksFile
+			}
+			s.removeFromIndex(store, projectID, rw, name)
+		}
+	}
+	removeIngestRecord(store, projectID, name)
+
+	versions = append(versions, v)
+	if err := saveVersions(store, projectID, versions); err != nil {
+		return nil, err
+	}
+	log.Printf("Archived %s as version %d in project %s", name, v.Version, projectID)
+	return &v, nil
+}
+
+// asOfDocument is the version of a document an as-of query searched.
+type asOfDocument struct {
+	Document string `json:"document"`
+	Version  int    `json:"version"` // 0 for the current upload
+	Missing  bool   `json:"missing,omitempty"`
+}
+
+// retrieverAsOf builds a retriever over the document versions that were
+// current at asOf: current uploads made before then, and archived versions
+// live at the time. Archived versions without saved chunks are reported
+// missing. The returned func releases the snapshot.
+func (s *Server) retrieverAsOf(store *chat.ProjectStore, projectID string, rw *retriever_wrapper, asOf string) (*retriever_wrapper, []asOfDocument, func(), error) {
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", asOf); err != nil {
+			return nil, nil, nil, fmt.Errorf("as_of must be an RFC 3339 time or YYYY-MM-DD date")
+		}
+		t = t.Add(24*time.Hour - time.Nanosecond) // the end of that day
+	}
+
+	rw.idx.Lock()
+	current := make([]indexer.Chunk, len(rw.idx.Chunks))
+	copy(current, rw.idx.Chunks)
+	rw.idx.Unlock()
+
+	uploadedAt := map[string]time.Time{}
+	for _, c := range current {
+		if _, ok := uploadedAt[c.Document]; !ok {
+			if info, err := os.Stat(filepath.Join(store.UploadsDir(projectID), c.Document)); err == nil {
+				uploadedAt[c.Document] = info.ModTime()
+			}
+		}
+	}
+
+	var chunks []indexer.Chunk
+	docs := map[string]asOfDocument{}
+	for _, c := range current {
+		if up, ok := uploadedAt[c.Document]; ok && !up.After(t) {
+			chunks = append(chunks, c)
+			docs[c.Document] = asOfDocument{Document: c.Document}
+		}
+	}
+	for _, v := range loadVersions(store, projectID) {
+		if v.UploadedAt.After(t) || !v.ReplacedAt.After(t) {
+			continue
+		}
+		d := asOfDocument{Document: v.Document, Version: v.Version, Missing: v.ChunksFile == ""}
+		if v.ChunksFile != "" {
+			archived, err := indexer.LoadChunks(filepath.Join(versionsDir(store, projectID), v.ChunksFile))
+			if err != nil {
+				log.Printf("Warning: failed to load chunks of %s v%d: %v", v.Document, v.Version, err)
+				d.Missing = true
+			}
+			chunks = append(chunks, archived...)
+		}
+		docs[v.Document] = d
+	}
+	if len(chunks) == 0 {
+		return nil, nil, nil, fmt.Errorf("no indexed documents as of %s", t.Format(time.RFC3339))
+	}
+
+	snap, err := rw.idx.Snapshot(chunks)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	set := make([]asOfDocument, 0, len(docs))
+	for _, d := range docs {
+		set = append(set, d)
+	}
+	sort.Slice(set, func(i, j int) bool { return set[i].Document < set[j].Document })
+	release := func() { _ = snap.Close() }
+	return &retriever_wrapper{ret: retriever.NewRetriever(snap), idx: snap, partial: rw.partial, loadedPercent: rw.loadedPercent}, set, release, nil
+}
+
+// handleFileVersions lists the earlier versions of an uploaded document
+// (GET ?project_id&name), or of every document without name.
+func (s *Server) handleFileVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := r.URL.Query().Get("project_id")
+	name := r.URL.Query().Get("name")
+	if projectID == "" {
+		jsonErr(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	store := s.getProjectStore(r)
+	if _, err := store.Get(projectID); err != nil {
+		jsonErr(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	versionsMu.Lock()
+	all := loadVersions(store, projectID)
+	versionsMu.Unlock()
+	versions := []docVersion{}
+	for _, v := range all {
+		if name == "" || v.Document == name {
+			versions = append(versions, v)
+		}
+	}
+
+	resp := map[string]interface{}{"versions": versions}
+	if name != "" {
+		if info, err := os.Stat(filepath.Join(store.UploadsDir(projectID), filepath.Base(name))); err == nil {
+			resp["current"] = map[string]interface{}{"uploaded_at": info.ModTime(), "size": info.Size()}
+		}
+	}
+	jsonResp(w, resp)
+}
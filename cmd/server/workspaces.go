@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"gocognigo/internal/chat"
+)
+
+// ========== Workspaces ==========
+
+// A workspace is a team sharing the deployment. Requests name one with the
+// X-Workspace-ID header or a workspace_id parameter; within it, projects,
+// settings (provider keys included), schedules and usage are the
+// workspace's rather than the user's, stored under workspaceKeyPrefix+ID
+// the way a user's are under their UID. Any member can use the projects;
+// changing the settings, quotas or membership takes an owner or admin.
+// Requests naming no workspace work on the user's own projects as before.
+
+const (
+	workspaceHeader    = "X-Workspace-ID"
+	workspaceKeyPrefix = "ws-"
+)
+
+const workspaceIDKey contextKey = "workspaceID"
+
+// workspaceManagerOnly are the endpoints that, other than to read, only a
+// workspace's owners and admins may call within it.
+var workspaceManagerOnly = map[string]bool{
+	"/api/settings":                true,
+	"/api/settings/profiles":       true,
+	"/api/settings/profiles/apply": true,
+	"/api/projects/quotas":         true,
+	"/api/projects/keys":           true,
+	"/api/chats/delete":            true,
+	"/api/workspaces/members":      true,
+	"/api/workspaces/quotas":       true,
+	"/api/workspaces/delete":       true,
+}
+
+// getWorkspaceID returns the ID of the workspace the request works in, or
+// "" for the user's own projects.
+func getWorkspaceID(r *http.Request) string {
+	id, _ := r.Context().Value(workspaceIDKey).(string)
+	return id
+}
+
+// requestUID returns the requesting user's UID, "local_dev_user" if auth is
+// disabled.
+func requestUID(r *http.Request) string {
+	uid := getUserUID(r)
+	if uid == "" {
+		uid = "local_dev_user" // Fallback if auth is disabled
+	}
+	return uid
+}
+
+// serveInWorkspace resolves the workspace a request names and serves it
+// there, after checking the user belongs to it and may make the change.
+func (s *Server) serveInWorkspace(next http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.Header.Get(workspaceHeader))
+	if id == "" {
+		id = r.URL.Query().Get("workspace_id")
+	}
+	if id == "" {
+		next(w, r)
+		return
+	}
+	ws, err := s.workspaces.Get(id)
+	if err != nil {
+		jsonErr(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+	uid, email := requestUID(r), getVerifiedEmail(r)
+	m := ws.Member(uid, email)
+	if m == nil {
+		jsonErr(w, "You are not a member of this workspace", http.StatusForbidden)
+		return
+	}
+	if m.UID == "" && uid != "" {
+		// An invite by email: it belongs to this account from now on
+		if _, err := s.workspaces.BindMember(ws.ID, uid, email); err != nil {
+			log.Printf("Warning: could not bind %s to their invite to workspace %s: %v", uid, ws.ID, err)
+		}
+	}
+	if workspaceManagerOnly[r.URL.Path] && r.Method != http.MethodGet && r.Method != http.MethodHead && !ws.CanManage(uid, email) {
+		jsonErr(w, "Only the workspace's owners and admins can change this", http.StatusForbidden)
+		return
+	}
+	next(w, r.WithContext(context.WithValue(r.Context(), workspaceIDKey, id)))
+}
+
+// requestWorkspace returns the workspace the request works in, writing a
+// 400 response if it names none.
+func (s *Server) requestWorkspace(w http.ResponseWriter, r *http.Request) *chat.Workspace {
+	id := getWorkspaceID(r)
+	if id == "" {
+		jsonErr(w, "workspace_id is required", http.StatusBadRequest)
+		return nil
+	}
+	ws, err := s.workspaces.Get(id)
+	if err != nil {
+		jsonErr(w, "Workspace not found", http.StatusNotFound)
+		return nil
+	}
+	return ws
+}
+
+// workspaceView is a workspace as listed to one of its members.
+type workspaceView struct {
+	chat.Workspace
+	Role string `json:"role"` // the member's role
+}
+
+// handleWorkspaces lists the user's workspaces (GET) or creates one owned
+// by them (POST {name}).
+func (s *Server) handleWorkspaces(w http.ResponseWriter, r *http.Request) {
+	uid, email := requestUID(r), getVerifiedEmail(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		views := []workspaceView{}
+		for _, ws := range s.workspaces.ForUser(uid, email) {
+			views = append(views, workspaceView{Workspace: ws, Role: ws.Member(uid, email).Role})
+		}
+		jsonResp(w, map[string]interface{}{"workspaces": views})
+
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ws, err := s.workspaces.Create(strings.TrimSpace(req.Name), uid, email)
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "workspace.create", "", ws.ID, map[string]string{"name": ws.Name})
+		jsonResp(w, workspaceView{Workspace: *ws, Role: chat.RoleOwner})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDeleteWorkspace deletes the workspace (POST), with its settings.
+// Only an owner can, and only once its projects are deleted.
+func (s *Server) handleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ws := s.requestWorkspace(w, r)
+	if ws == nil {
+		return
+	}
+	if m := ws.Member(requestUID(r), getVerifiedEmail(r)); m.Role != chat.RoleOwner {
+		jsonErr(w, "Only the workspace's owners can delete it", http.StatusForbidden)
+		return
+	}
+	key := storeUID(r)
+	if n := len(s.getProjectStore(r).List()); n > 0 {
+		jsonErr(w, fmt.Sprintf("The workspace still has %d projects; delete them first", n), http.StatusConflict)
+		return
+	}
+	if err := s.workspaces.Delete(ws.ID); err != nil {
+		jsonErr(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.userProjects, key)
+	delete(s.userSettings, key)
+	s.mu.Unlock()
+	_ = os.RemoveAll(fmt.Sprintf("data/users/%s_projects", key))
+	_ = os.Remove(fmt.Sprintf("data/users/%s_settings.json", key))
+
+	recordAudit(r, "workspace.delete", "", ws.ID, map[string]string{"name": ws.Name})
+	jsonResp(w, map[string]string{"status": "deleted"})
+}
+
+// memberRequest names a workspace member by UID or email.
+type memberRequest struct {
+	UID   string `json:"uid"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// handleWorkspaceMembers lists the workspace's members (GET) or adds one or
+// changes their role (POST {uid|email, role}). Only owners can make or
+// change owners.
+func (s *Server) handleWorkspaceMembers(w http.ResponseWriter, r *http.Request) {
+	ws := s.requestWorkspace(w, r)
+	if ws == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		jsonResp(w, map[string]interface{}{"members": ws.Members})
+
+	case http.MethodPost:
+		var req memberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.UID, req.Email = strings.TrimSpace(req.UID), strings.TrimSpace(req.Email)
+		if req.Role == "" {
+			req.Role = chat.RoleMember
+		}
+		existing := ws.Member(req.UID, req.Email)
+		if (req.Role == chat.RoleOwner || (existing != nil && existing.Role == chat.RoleOwner)) &&
+			ws.Member(requestUID(r), getVerifiedEmail(r)).Role != chat.RoleOwner {
+			jsonErr(w, "Only the workspace's owners can make or change owners", http.StatusForbidden)
+			return
+		}
+		updated, err := s.workspaces.SetMember(ws.ID, req.UID, req.Email, req.Role)
+		if err != nil {
+			code := http.StatusBadRequest
+			if errors.Is(err, chat.ErrLastOwner) {
+				code = http.StatusConflict
+			}
+			jsonErr(w, err.Error(), code)
+			return
+		}
+		recordAudit(r, "workspace.member", "", ws.ID, map[string]string{"uid": req.UID, "email": req.Email, "role": req.Role})
+		jsonResp(w, map[string]interface{}{"members": updated.Members})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRemoveWorkspaceMember takes a member out of the workspace (POST
+// {uid|email}). Owners and admins can remove others, and anyone can leave;
+// only owners can remove an owner.
+func (s *Server) handleRemoveWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ws := s.requestWorkspace(w, r)
+	if ws == nil {
+		return
+	}
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.UID == "" && req.Email == "") {
+		jsonErr(w, "uid or email is required", http.StatusBadRequest)
+		return
+	}
+	target := ws.Member(req.UID, req.Email)
+	if target == nil {
+		jsonErr(w, "Not a member of the workspace", http.StatusNotFound)
+		return
+	}
+	uid, email := requestUID(r), getVerifiedEmail(r)
+	self := ws.Member(uid, email)
+	switch {
+	case target == self:
+	case target.Role == chat.RoleOwner && self.Role != chat.RoleOwner:
+		jsonErr(w, "Only the workspace's owners can remove an owner", http.StatusForbidden)
+		return
+	case !ws.CanManage(uid, email):
+		jsonErr(w, "Only the workspace's owners and admins can remove members", http.StatusForbidden)
+		return
+	}
+
+	updated, err := s.workspaces.RemoveMember(ws.ID, target.UID, target.Email)
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, chat.ErrLastOwner) {
+			code = http.StatusConflict
+		}
+		jsonErr(w, err.Error(), code)
+		return
+	}
+	recordAudit(r, "workspace.member_remove", "", ws.ID, map[string]string{"uid": target.UID, "email": target.Email})
+	jsonResp(w, map[string]interface{}{"members": updated.Members})
+}
+
+// handleWorkspaceQuotas reads (GET) or sets (POST {quotas}) the limits of
+// the workspace's projects that set none of their own.
+func (s *Server) handleWorkspaceQuotas(w http.ResponseWriter, r *http.Request) {
+	ws := s.requestWorkspace(w, r)
+	if ws == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		jsonResp(w, map[string]interface{}{"quotas": ws.Quotas, "defaults": defaultQuotas()})
+
+	case http.MethodPost:
+		var req struct {
+			Quotas chat.ProjectQuotas `json:"quotas"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		q := req.Quotas
+		if q.MaxFiles < 0 || q.MaxUploadBytes < 0 || q.MaxChunks < 0 || q.MonthlyTokenBudget < 0 {
+			jsonErr(w, "quotas must be zero (unlimited) or positive", http.StatusBadRequest)
+			return
+		}
+		// Managers can lower the instance's QUOTA_* limits, never raise them
+		updated, err := s.workspaces.SetQuotas(ws.ID, clampQuotas(q, defaultQuotas()))
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "workspace.quotas", "", ws.ID, nil)
+		jsonResp(w, map[string]interface{}{"quotas": updated.Quotas, "defaults": defaultQuotas()})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -11,6 +11,9 @@ require (
 	github.com/ledongthuc/pdf v0.0.0-20250511090121-5959a4027728
 	github.com/nguyenthenguyen/docx v0.0.0-20230621112118-9c8e795a11db
 	github.com/sashabaranov/go-openai v1.41.2
+	golang.org/x/crypto v0.47.0
+	golang.org/x/sync v0.10.0
+	golang.org/x/sys v0.41.0
 )
 
 require (
@@ -39,6 +42,5 @@ require (
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/mschoch/smat v0.2.0 // indirect
 	go.etcd.io/bbolt v1.4.3 // indirect
-	golang.org/x/sys v0.41.0 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
 )
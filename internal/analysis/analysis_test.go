@@ -0,0 +1,317 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+)
+
+// ========== ExtractEntities ==========
+
+func TestExtractEntities(t *testing.T) {
+	text := `This Agreement is made on 4th March, 2021 between Acme Holdings Ltd. and the Reserve Bank of India.
+Mr. John Smith shall pay Rs. 4,50,000 to Acme Holdings Ltd by March 2022. The Court noted a penalty of $2.5 million.`
+
+	got := map[string]string{}
+	for _, e := range ExtractEntities(text) {
+		if prev, dup := got[e.Key()]; dup {
+			t.Errorf("duplicate entity %q (already %q)", e.Text, prev)
+		}
+		got[e.Key()] = e.Text
+	}
+
+	want := []Entity{
+		{"4th March, 2021", EntityDate},
+		{"March 2022", EntityDate},
+		{"Acme Holdings Ltd", EntityOrganization},
+		{"Reserve Bank of India", EntityOrganization},
+		{"Mr. John Smith", EntityPerson},
+		{"Rs. 4,50,000", EntityAmount},
+		{"$2.5 million", EntityAmount},
+	}
+	for _, w := range want {
+		if _, ok := got[w.Key()]; !ok {
+			t.Errorf("missing %s %q; got %v", w.Type, w.Text, got)
+		}
+	}
+	if _, ok := got[Entity{"Court", EntityOrganization}.Key()]; ok {
+		t.Error("a bare \"The Court\" should not be an organisation")
+	}
+	if _, ok := got[Entity{"March, 2021", EntityDate}.Key()]; ok {
+		t.Error("partial date inside a full date should not be extracted")
+	}
+}
+
+// ========== Timeline ==========
+
+func TestParseDate(t *testing.T) {
+	cases := []struct {
+		in, want, precision string
+	}{
+		{"4th March, 2021", "2021-03-04", PrecisionDay},
+		{"4th day of March, 2021", "2021-03-04", PrecisionDay},
+		{"Sept. 30, 2019", "2019-09-30", PrecisionDay},
+		{"2020-12-01", "2020-12-01", PrecisionDay},
+		{"04/03/2021", "2021-03-04", PrecisionDay}, // day-first
+		{"12/31/2021", "2021-12-31", PrecisionDay}, // month-first when day-first is impossible
+		{"March 2022", "2022-03-01", PrecisionMonth},
+	}
+	for _, c := range cases {
+		got, precision, ok := ParseDate(c.in)
+		if !ok || got.Format("2006-01-02") != c.want || precision != c.precision {
+			t.Errorf("ParseDate(%q) = %v, %q, %v; want %s (%s)", c.in, got.Format("2006-01-02"), precision, ok, c.want, c.precision)
+		}
+	}
+	if _, _, ok := ParseDate("31/31/2021"); ok {
+		t.Error("expected invalid numeric date to fail")
+	}
+}
+
+func TestBuildTimeline(t *testing.T) {
+	passages := []Passage{
+		{"b.pdf", 2, "The notice was served on 10 June 2021. Payment fell due in May 2021."},
+		{"a.pdf", 7, "The agreement was signed on 4 March 2021. The notice was served on 10 June 2021."},
+	}
+	timeline := BuildTimeline(passages)
+	if len(timeline) != 3 {
+		t.Fatalf("expected 3 merged events, got %d: %+v", len(timeline), timeline)
+	}
+	var dates []string
+	for _, e := range timeline {
+		dates = append(dates, e.Date)
+	}
+	if dates[0] != "2021-03-04" || dates[1] != "2021-05" || dates[2] != "2021-06-10" {
+		t.Errorf("not chronological: %v", dates)
+	}
+	if len(timeline[2].Citations) != 2 {
+		t.Errorf("expected the repeated event to cite both documents, got %v", timeline[2].Citations)
+	}
+}
+
+// ========== Graph ==========
+
+func TestBuildGraph(t *testing.T) {
+	passages := []Passage{
+		{"a.pdf", 1, "Acme Holdings Ltd entered into an agreement with Zenith Bank. Mr. John Smith is a director of Acme Holdings Ltd."},
+		{"b.pdf", 3, "Zenith Bank lent funds to Orion Capital Partners."},
+	}
+	g := BuildGraph(passages)
+
+	acme, ok := g.FindNode("acme holdings ltd")
+	if !ok {
+		t.Fatalf("Acme not in graph: %+v", g.Nodes)
+	}
+	if acme.Mentions != 1 {
+		t.Errorf("expected Acme mentioned in 1 passage, got %d", acme.Mentions)
+	}
+
+	var agreement *Edge
+	for i, e := range g.Edges {
+		if e.From == acme.ID && e.Relation == "entered into an agreement with" {
+			agreement = &g.Edges[i]
+		}
+	}
+	if agreement == nil {
+		t.Fatalf("missing agreement edge: %+v", g.Edges)
+	}
+	if len(agreement.Citations) != 1 || agreement.Citations[0] != (Citation{"a.pdf", 1}) {
+		t.Errorf("unexpected citations: %v", agreement.Citations)
+	}
+
+	smith, _ := g.FindNode("John Smith")
+	orion, ok := g.FindNode("Orion Capital Partners")
+	if !ok {
+		t.Fatalf("Orion not in graph: %+v", g.Nodes)
+	}
+	path := g.Path(smith.ID, orion.ID)
+	if len(path) != 3 {
+		t.Fatalf("expected a 3-hop path from Smith to Orion, got %+v", path)
+	}
+
+	near := g.Neighborhood(smith.ID, 1)
+	if len(near.Nodes) != 2 || len(near.Edges) != 1 {
+		t.Errorf("expected Smith and Acme one hop out, got %+v", near)
+	}
+}
+
+// ========== Clauses ==========
+
+func TestClauseMatrix(t *testing.T) {
+	passages := []Passage{
+		{"msa.pdf", 4, "11. Indemnification\nThe Supplier shall indemnify and hold harmless the Customer against all losses."},
+		{"msa.pdf", 9, "As set out in clause 11, the Supplier shall indemnify the Customer. This Agreement shall be governed by the laws of England."},
+		{"nda.pdf", 1, "Each party shall keep the Confidential Information strictly confidential."},
+	}
+	matrix := ClauseMatrix(passages)
+	if len(matrix) != 2 || matrix[0].Document != "msa.pdf" {
+		t.Fatalf("unexpected rows: %+v", matrix)
+	}
+
+	indemnity := matrix[0].Clauses["indemnity"]
+	if indemnity == nil || !indemnity.Heading || indemnity.Page != 4 {
+		t.Fatalf("expected indemnity under its heading on page 4, got %+v", indemnity)
+	}
+	if len(indemnity.Citations) != 2 {
+		t.Errorf("expected citations for both pages, got %v", indemnity.Citations)
+	}
+	if law := matrix[0].Clauses["governing_law"]; law == nil || law.Page != 9 {
+		t.Errorf("expected governing law on page 9, got %+v", law)
+	}
+	if _, ok := matrix[1].Clauses["confidentiality"]; !ok {
+		t.Error("expected confidentiality in the NDA")
+	}
+	if _, ok := matrix[1].Clauses["indemnity"]; ok {
+		t.Error("NDA has no indemnity clause")
+	}
+}
+
+// ========== Definitions ==========
+
+func TestExtractDefinitions(t *testing.T) {
+	text := `"Closing Date" means the date on which Completion occurs. This share purchase agreement (the "Agreement") is made between the parties. ‘Business Day’ shall mean a day on which banks are open in London.`
+	defs := ExtractDefinitions(text)
+	want := map[string]string{
+		"Closing Date": "the date on which Completion occurs.",
+		"Agreement":    "This share purchase agreement",
+		"Business Day": "a day on which banks are open in London.",
+	}
+	if len(defs) != len(want) {
+		t.Fatalf("expected %d definitions, got %+v", len(want), defs)
+	}
+	for _, d := range defs {
+		if want[d.Term] != d.Meaning {
+			t.Errorf("%q: got meaning %q, want %q", d.Term, d.Meaning, want[d.Term])
+		}
+	}
+
+	glossary := []GlossaryEntry{{Term: "Closing Date"}, {Term: "Agreement"}, {Term: "Date"}}
+	used := TermsUsed("When is the closing date under the agreement?", glossary)
+	if len(used) != 3 || used[0].Term != "Closing Date" {
+		t.Errorf("unexpected terms used: %+v", used)
+	}
+	if got := TermsUsed("What are the agreements?", glossary); len(got) != 0 {
+		t.Errorf("expected whole-word matches only, got %+v", got)
+	}
+}
+
+// ========== Numbers ==========
+
+func TestParseQuantities(t *testing.T) {
+	qs := ParseQuantities("A fee of Rs. 2.5 crore [1] and 12.5 per cent interest from 4 March 2021, see p.7 and clause 2019.")
+	if len(qs) != 2 {
+		t.Fatalf("expected 2 quantities, got %+v", qs)
+	}
+	if qs[0].Currency != "INR" || qs[0].Value != 25000000 {
+		t.Errorf("fee = %+v", qs[0])
+	}
+	if !qs[1].Percent || qs[1].Value != 12.5 {
+		t.Errorf("rate = %+v", qs[1])
+	}
+}
+
+func TestVerifyNumbers(t *testing.T) {
+	sources := []SourcePage{
+		{Marker: 1, Document: "a.pdf", Page: 3, Text: "The purchase price is INR 25,000,000 payable on Closing."},
+		{Marker: 2, Document: "b.pdf", Page: 8, Text: "Interest accrues at 9% per annum."},
+	}
+	answer := "The price is ₹2.5 crore[1]. Interest is 10%[2]. The penalty is $40 million[1]."
+	checks := VerifyNumbers(answer, sources)
+	if len(checks) != 3 {
+		t.Fatalf("expected 3 checks, got %+v", checks)
+	}
+	if checks[0].Status != NumberVerified || checks[0].Page != 3 {
+		t.Errorf("price: %+v", checks[0])
+	}
+	if checks[1].Status != NumberMismatch || checks[1].Source != "9%" {
+		t.Errorf("interest: %+v", checks[1])
+	}
+	if checks[2].Status != NumberNotFound {
+		t.Errorf("penalty in USD should find no comparable INR figure: %+v", checks[2])
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	cases := map[string]string{
+		"The Company shall indemnify the Purchaser against all losses.": "en",
+		"कंपनी सभी नुकसानों के लिए खरीदार को क्षतिपूर्ति करेगी। धारा 12 देखें।": "hi",
+		"நிறுவனம் அனைத்து இழப்புகளுக்கும் வாங்குபவருக்கு ஈடு செய்யும்.":         "ta",
+		"Page 12 of 40": "",
+		"Schedule III — भुगतान की शर्तें और समय-सीमा इस अनुबंध के अनुसार होंगी।": "hi",
+	}
+	for text, want := range cases {
+		if got := DetectLanguage(text); got != want {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestFindPII(t *testing.T) {
+	text := "Contact R. Kumar (PAN ABCPK1234F, Aadhaar 2341 2341 2346) at r.kumar@example.com or +91 98765 43210. " +
+		"Invoice 2341 2341 2345 and SSN 123-45-6789 (not 000-12-3456)."
+	var got []string
+	for _, m := range FindPII(text) {
+		got = append(got, m.Type+":"+m.Text)
+	}
+	want := []string{
+		"pan:ABCPK1234F", "aadhaar:2341 2341 2346", "email:r.kumar@example.com",
+		"phone:+91 98765 43210", "ssn:123-45-6789",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("FindPII =\n %v\nwant\n %v", got, want)
+	}
+
+	red := RedactPII("PAN ABCPK1234F, mail r.kumar@example.com")
+	if red != "PAN [REDACTED PAN], mail [REDACTED EMAIL]" {
+		t.Errorf("RedactPII = %q", red)
+	}
+	if types := PIITypes(text); strings.Join(types, ",") != "aadhaar,email,pan,phone,ssn" {
+		t.Errorf("PIITypes = %v", types)
+	}
+}
+
+// ========== CitedSpans ==========
+
+func TestCitedSpans(t *testing.T) {
+	page := "LEASE AGREEMENT\n\nThe Tenant shall pay a monthly rent of Rs. 45,000 on the first day of each month. " +
+		"The Landlord may revise the rent once a year. Either party may terminate on 90 days' written notice."
+	source := SourcePage{Marker: 2, Document: "lease.pdf", Page: 4, Text: page}
+	span := func(s Span) string { return page[s.Start:s.End] }
+
+	spans := CitedSpans("The notice period is 30 days[1]. The monthly rent is Rs. 45,000, paid on the first day[2].", source)
+	if len(spans) != 1 || !strings.HasPrefix(span(spans[0]), "The Tenant shall pay") || !strings.HasSuffix(span(spans[0]), "each month.") {
+		t.Fatalf("spans for [2] = %q", spans)
+	}
+
+	// Markers listed together count for each of them
+	if spans := CitedSpans("Either party may terminate on 90 days' notice [1, 2].", source); len(spans) != 1 || !strings.HasPrefix(span(spans[0]), "Either party") {
+		t.Errorf("spans for [1, 2] = %v", spans)
+	}
+	// A page no sentence cites has nothing to highlight
+	if spans := CitedSpans("The deposit is three months' rent[1].", source); spans != nil {
+		t.Errorf("page not cited by [2] got %v", spans)
+	}
+	// Without markers every sentence counts, and unrelated ones match nothing
+	if spans := CitedSpans("The court dismissed the appeal.", source); len(spans) != 0 {
+		t.Errorf("unrelated claim matched %v", spans)
+	}
+}
+
+// ========== OCR Correction ==========
+
+func TestOCRCorrector(t *testing.T) {
+	pages := []string{
+		strings.Repeat("The monthly rent under this clause is due. ", 6),
+		"Rent is due rnonthly under CLAUSE 4; this c1ause and the Iease survive. The lease, the mouth of the river.",
+		strings.Repeat("The lease is renewed and the lease is signed. ", 3),
+	}
+	c := NewOCRCorrector(pages)
+	got, n := c.Correct(pages[1])
+	want := "Rent is due monthly under CLAUSE 4; this clause and the Lease survive. The lease, the mouth of the river."
+	if got != want || n != 3 {
+		t.Errorf("Correct = %q (%d), want %q (3)", got, n, want)
+	}
+
+	// A word read as often as its look-alike is left alone
+	if got, n := NewOCRCorrector([]string{"clause cause clause cause clause cause"}).Correct("cause"); got != "cause" || n != 0 {
+		t.Errorf("Correct = %q (%d)", got, n)
+	}
+}
@@ -0,0 +1,189 @@
+package analysis
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ==================== Contract Clauses ====================
+
+// A clause is found either by a heading ("12. Indemnification") or by the
+// language that makes one up ("shall indemnify and hold harmless"). Headings
+// score higher, so a document's section on a topic is preferred over a
+// passing cross-reference to it.
+
+// ClauseType is a standard contract clause the clause matrix looks for.
+type ClauseType struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+
+	heading *regexp.Regexp
+	phrases []*regexp.Regexp
+}
+
+// ClauseTypes are the clause types detected, in matrix column order.
+var ClauseTypes = []ClauseType{
+	clauseType("indemnity", "Indemnity",
+		`indemnit(?:y|ies)|indemnification|hold harmless`,
+		`\bindemnif(?:y|ies|ied)\b`, `\bhold(?:s)?\s+harmless\b`),
+	clauseType("limitation_of_liability", "Limitation of Liability",
+		`limitation\s+(?:of|on)\s+liability|liability\s+cap`,
+		`\b(?:aggregate|total)\s+liability\b`, `\bin\s+no\s+event\s+shall\b.{0,80}\bliable\b`, `\bconsequential\s+(?:or\s+\w+\s+)?damages\b`),
+	clauseType("termination", "Termination",
+		`termination|term\s+and\s+termination`,
+		`\bmay\s+terminate\b`, `\bright\s+to\s+terminate\b`, `\bterminat(?:e|ed|ion)\b.{0,60}\b(?:notice|breach|convenience)\b`),
+	clauseType("change_of_control", "Change of Control",
+		`change\s+(?:of|in)\s+control`,
+		`\bchange\s+(?:of|in)\s+control\b`, `\b(?:merger|acquisition|consolidation)\b.{0,80}\b(?:control|voting)\b`),
+	clauseType("assignment", "Assignment",
+		`assignment|assignability`,
+		`\bmay\s+not\s+(?:be\s+)?assign(?:ed)?\b`, `\bshall\s+not\s+assign\b`, `\bassign\b.{0,40}\bwithout\s+the\s+prior\s+(?:written\s+)?consent\b`),
+	clauseType("governing_law", "Governing Law",
+		`governing\s+law|applicable\s+law|choice\s+of\s+law`,
+		`\bgoverned\s+by\b.{0,40}\blaws?\s+of\b`, `\bconstrued\s+in\s+accordance\s+with\b.{0,40}\blaws?\b`),
+	clauseType("dispute_resolution", "Dispute Resolution",
+		`dispute\s+resolution|arbitration|jurisdiction`,
+		`\barbitrat(?:ion|or|ors)\b`, `\bexclusive\s+jurisdiction\b`, `\bsubmit\s+to\s+the\s+jurisdiction\b`),
+	clauseType("confidentiality", "Confidentiality",
+		`confidentiality|non-disclosure|confidential\s+information`,
+		`\bconfidential\s+information\b`, `\bshall\s+(?:keep|hold|maintain)\b.{0,40}\bconfiden`),
+	clauseType("force_majeure", "Force Majeure",
+		`force\s+majeure`,
+		`\bforce\s+majeure\b`, `\bacts?\s+of\s+god\b`),
+	clauseType("non_compete", "Non-Compete / Non-Solicit",
+		`non-?compet(?:e|ition)|non-?solicitation|restrictive\s+covenants?`,
+		`\bshall\s+not\b.{0,60}\b(?:compete|solicit)\b`, `\bnon-?compet(?:e|ition)\b`),
+}
+
+// headingPrefix allows a clause number ("12.", "4.2", "Article IV",
+// "Section 9") before a heading.
+const headingPrefix = `^\s*(?:(?:article|section|clause)\s+[\divxlc]+[.:]?\s*|\d+(?:\.\d+)*\.?\s*|\([a-z0-9]+\)\s*)?`
+
+func clauseType(id, label, heading string, phrases ...string) ClauseType {
+	ct := ClauseType{
+		ID:      id,
+		Label:   label,
+		heading: regexp.MustCompile(`(?i)` + headingPrefix + `(?:` + heading + `)\b`),
+	}
+	for _, p := range phrases {
+		ct.phrases = append(ct.phrases, regexp.MustCompile(`(?i)`+p))
+	}
+	return ct
+}
+
+const (
+	maxHeadingLen     = 80
+	headingScore      = 3
+	phraseScore       = 1
+	clauseExcerptLen  = 500
+	maxClauseCitation = 10
+)
+
+// ClauseFinding is the best match for one clause type in one document.
+type ClauseFinding struct {
+	Type      string     `json:"type"`
+	Page      int        `json:"page"`
+	Heading   bool       `json:"heading"` // found under a clause heading
+	Excerpt   string     `json:"excerpt"`
+	Mentions  int        `json:"mentions"`  // matches across the document
+	Citations []Citation `json:"citations"` // pages with a match
+}
+
+// DocumentClauses is one row of the clause matrix.
+type DocumentClauses struct {
+	Document string                    `json:"document"`
+	Clauses  map[string]*ClauseFinding `json:"clauses"` // keyed by ClauseType.ID; absent when not found
+}
+
+// ClauseMatrix finds each clause type in each document of passages. Rows are
+// sorted by document name.
+func ClauseMatrix(passages []Passage) []DocumentClauses {
+	rows := map[string]*DocumentClauses{}
+	bestScore := map[string]int{} // document + type
+
+	for _, p := range passages {
+		row, ok := rows[p.Document]
+		if !ok {
+			row = &DocumentClauses{Document: p.Document, Clauses: map[string]*ClauseFinding{}}
+			rows[p.Document] = row
+		}
+		lineStarts := lineOffsets(p.Text)
+		for _, ct := range ClauseTypes {
+			consider := func(score, at int) {
+				f := row.Clauses[ct.ID]
+				if f == nil {
+					f = &ClauseFinding{Type: ct.ID}
+					row.Clauses[ct.ID] = f
+				}
+				f.Mentions++
+				cite := Citation{Document: p.Document, Page: p.Page}
+				if len(f.Citations) < maxClauseCitation && !hasCitation(f.Citations, cite) {
+					f.Citations = append(f.Citations, cite)
+				}
+				key := p.Document + "\x00" + ct.ID
+				if b, seen := bestScore[key]; seen && b >= score {
+					return
+				}
+				bestScore[key] = score
+				f.Page = p.Page
+				f.Heading = score == headingScore
+				f.Excerpt = clauseExcerpt(p.Text, at)
+			}
+
+			for _, start := range lineStarts {
+				line := p.Text[start:]
+				if end := strings.IndexByte(line, '\n'); end >= 0 {
+					line = line[:end]
+				}
+				if len(strings.TrimSpace(line)) <= maxHeadingLen && ct.heading.MatchString(line) {
+					consider(headingScore, start)
+				}
+			}
+			for _, re := range ct.phrases {
+				for _, loc := range re.FindAllStringIndex(p.Text, -1) {
+					consider(phraseScore, sentenceStart(p.Text, loc[0]))
+				}
+			}
+		}
+	}
+
+	matrix := make([]DocumentClauses, 0, len(rows))
+	for _, row := range rows {
+		matrix = append(matrix, *row)
+	}
+	sort.Slice(matrix, func(i, j int) bool { return matrix[i].Document < matrix[j].Document })
+	return matrix
+}
+
+func lineOffsets(text string) []int {
+	offsets := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' && i+1 < len(text) {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// sentenceStart walks back from i to the start of its sentence or line.
+func sentenceStart(text string, i int) int {
+	for j := i - 1; j > 0; j-- {
+		if text[j] == '\n' || (text[j] == ' ' && (text[j-1] == '.' || text[j-1] == ';')) {
+			return j + 1
+		}
+	}
+	return 0
+}
+
+func clauseExcerpt(text string, at int) string {
+	s := text[at:]
+	if len(s) > clauseExcerptLen {
+		cut := strings.LastIndexByte(s[:clauseExcerptLen], ' ')
+		if cut <= 0 {
+			cut = clauseExcerptLen
+		}
+		s = s[:cut] + "…"
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,107 @@
+package analysis
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ==================== Defined Terms ====================
+
+// Definition is a term a document defines, either explicitly
+// ("'Closing Date' means ...") or in a parenthetical
+// (... the share purchase agreement (the "Agreement")).
+type Definition struct {
+	Term    string `json:"term"`
+	Meaning string `json:"meaning"`
+}
+
+// GlossaryEntry is a definition with the page that states it.
+type GlossaryEntry struct {
+	Term     string `json:"term"`
+	Meaning  string `json:"meaning"`
+	Document string `json:"document"`
+	Page     int    `json:"page"`
+}
+
+const (
+	quotedTerm      = `["“'‘]([A-Z][^"”'’\n]{0,60}?)["”'’]`
+	maxMeaningLen   = 300
+	maxParenMeaning = 200
+)
+
+var (
+	explicitDefinition = regexp.MustCompile(quotedTerm + `\s*,?\s+(?:shall\s+mean|means|shall\s+have\s+the\s+meaning|has\s+the\s+meaning|shall\s+refer\s+to|refers\s+to|is\s+defined\s+as|includes)\s+(.+)`)
+	parenDefinition    = regexp.MustCompile(`\((?:the\s+|each\s+a\s+|together\s+the\s+|hereinafter\s+(?:referred\s+to\s+as\s+|called\s+)?(?:the\s+)?)?` + quotedTerm + `\)`)
+)
+
+// ExtractDefinitions finds the terms defined in text. Each term is returned
+// once, with its first definition.
+func ExtractDefinitions(text string) []Definition {
+	var defs []Definition
+	seen := map[string]bool{}
+	add := func(term, meaning string) {
+		term = strings.TrimSpace(term)
+		meaning = strings.Trim(strings.TrimSpace(meaning), ",;:")
+		key := strings.ToLower(term)
+		if term == "" || meaning == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		defs = append(defs, Definition{Term: term, Meaning: meaning})
+	}
+
+	for _, sentence := range splitSentences(text) {
+		if m := explicitDefinition.FindStringSubmatch(sentence); m != nil {
+			meaning := m[2]
+			if len(meaning) > maxMeaningLen {
+				meaning = meaning[:maxMeaningLen] + "…"
+			}
+			add(m[1], meaning)
+			continue
+		}
+		for _, loc := range parenDefinition.FindAllStringSubmatchIndex(sentence, -1) {
+			meaning := sentence[:loc[0]]
+			if len(meaning) > maxParenMeaning {
+				meaning = meaning[len(meaning)-maxParenMeaning:]
+				if i := strings.IndexByte(meaning, ' '); i >= 0 {
+					meaning = "…" + meaning[i+1:]
+				}
+			}
+			add(sentence[loc[2]:loc[3]], meaning)
+		}
+	}
+	return defs
+}
+
+// TermsUsed returns the glossary entries whose term appears in text as a
+// whole word, ignoring case, longest terms first.
+func TermsUsed(text string, glossary []GlossaryEntry) []GlossaryEntry {
+	lower := strings.ToLower(text)
+	var used []GlossaryEntry
+	for _, g := range glossary {
+		if containsWord(lower, strings.ToLower(g.Term)) {
+			used = append(used, g)
+		}
+	}
+	sort.SliceStable(used, func(i, j int) bool { return len(used[i].Term) > len(used[j].Term) })
+	return used
+}
+
+func containsWord(text, word string) bool {
+	for from := 0; ; {
+		i := strings.Index(text[from:], word)
+		if i < 0 {
+			return false
+		}
+		start, end := from+i, from+i+len(word)
+		if (start == 0 || !isWordByte(text[start-1])) && (end == len(text) || !isWordByte(text[end])) {
+			return true
+		}
+		from = start + 1
+	}
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
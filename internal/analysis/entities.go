@@ -0,0 +1,137 @@
+// Package analysis extracts structured facts (entities, dates, definitions)
+// from document text with deterministic rules. It runs at ingest on every
+// chunk, so it makes no API calls and is cheap enough for large corpora.
+package analysis
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Entity types.
+const (
+	EntityPerson       = "person"
+	EntityOrganization = "organization"
+	EntityAmount       = "amount"
+	EntityDate         = "date"
+)
+
+// Entity is a named thing mentioned in a piece of text.
+type Entity struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// Key identifies an entity independently of case, spacing and trailing
+// punctuation, so mentions of the same entity aggregate together.
+func (e Entity) Key() string {
+	return e.Type + ":" + normalizeName(e.Text)
+}
+
+func normalizeName(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.TrimRight(s, ".,;:")
+	return strings.ToLower(s)
+}
+
+const monthNames = `(?:January|February|March|April|May|June|July|August|September|October|November|December|Jan|Feb|Mar|Apr|Jun|Jul|Aug|Sep|Sept|Oct|Nov|Dec)\.?`
+
+var (
+	datePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\b\d{1,2}(?:st|nd|rd|th)?(?:\s+day\s+of)?\s+` + monthNames + `,?\s+\d{4}\b`), // 4 March 2021, 4th day of March, 2021
+		regexp.MustCompile(`\b` + monthNames + `\s+\d{1,2}(?:st|nd|rd|th)?,?\s+\d{4}\b`),                 // March 4, 2021
+		regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}\b`),                                                      // 2021-03-04
+		regexp.MustCompile(`\b\d{1,2}[/.]\d{1,2}[/.]\d{4}\b`),                                            // 04/03/2021
+		regexp.MustCompile(`\b` + monthNames + `\s+\d{4}\b`),                                             // March 2021
+	}
+
+	amountPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?:Rs\.?|INR|USD|US\$|EUR|GBP|\$|€|£|₹)\s?\d[\d,]*(?:\.\d+)?(?:\s?(?:million|billion|trillion|crores?|lakhs?|thousand|mn|bn|cr)\b)?`),
+		regexp.MustCompile(`\b\d[\d,]*(?:\.\d+)?\s?(?:million|billion|crores?|lakhs?)?\s?(?:dollars|rupees|euros|pounds)\b`),
+	}
+
+	// Capitalised words, optionally joined by "of", "and", "for" or "&",
+	// ending in a word that marks an organisation.
+	orgPattern = regexp.MustCompile(`\b(?:[A-Z][\w&'.-]*\s+(?:(?:of|and|for|&)\s+)?){0,6}(?:Ltd\.?|Limited|Inc\.?|Incorporated|Corp\.?|Corporation|LLP|LLC|PLC|plc|Pvt\.?\s+Ltd\.?|Company|Co\.|Bank|Board|Authority|Commission|Ministry|Tribunal|Court|Trust|Group|Holdings|Partners|Association|University|Exchange|Agency|Council|Department)\b(?:\s+of\s+[A-Z][a-z]+)?`)
+
+	// Honorific followed by up to four name parts (initials allowed).
+	personPattern = regexp.MustCompile(`\b(?:Mr|Mrs|Ms|Dr|Shri|Smt|Prof|Justice|Hon'ble\s+Justice|Sir|Dame)\.?\s+(?:[A-Z]\.\s*){0,3}[A-Z][a-zA-Z'-]+(?:\s+(?:[A-Z]\.\s*)?[A-Z][a-zA-Z'-]+){0,3}`)
+)
+
+// ExtractEntities finds people, organisations, amounts and dates in text.
+// Each distinct entity is returned once, in order of first appearance.
+func ExtractEntities(text string) []Entity {
+	type match struct {
+		start int
+		e     Entity
+	}
+	var matches []match
+	taken := make([]bool, len(text)) // dates claim their span so "March 2021" inside "4 March 2021" isn't repeated
+
+	add := func(re *regexp.Regexp, typ string, exclusive bool) {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if exclusive {
+				overlap := false
+				for i := loc[0]; i < loc[1]; i++ {
+					if taken[i] {
+						overlap = true
+						break
+					}
+				}
+				if overlap {
+					continue
+				}
+				for i := loc[0]; i < loc[1]; i++ {
+					taken[i] = true
+				}
+			}
+			s := strings.TrimSpace(text[loc[0]:loc[1]])
+			if typ == EntityOrganization {
+				s = trimOrgPrefix(s)
+			}
+			if s == "" {
+				continue
+			}
+			matches = append(matches, match{loc[0], Entity{Text: strings.Join(strings.Fields(s), " "), Type: typ}})
+		}
+	}
+	for _, re := range datePatterns {
+		add(re, EntityDate, true)
+	}
+	for _, re := range amountPatterns {
+		add(re, EntityAmount, true)
+	}
+	add(orgPattern, EntityOrganization, false)
+	add(personPattern, EntityPerson, false)
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	seen := map[string]bool{}
+	var entities []Entity
+	for _, m := range matches {
+		if k := m.e.Key(); !seen[k] {
+			seen[k] = true
+			entities = append(entities, m.e)
+		}
+	}
+	return entities
+}
+
+// sentenceStarters are capitalised words that open sentences rather than
+// names, stripped from the front of organisation matches.
+var sentenceStarters = map[string]bool{
+	"The": true, "This": true, "That": true, "These": true, "Those": true, "A": true, "An": true,
+	"In": true, "On": true, "By": true, "Under": true, "Whereas": true, "If": true, "For": true,
+	"Each": true, "Any": true, "Such": true, "Said": true, "Our": true, "Its": true, "Their": true,
+}
+
+func trimOrgPrefix(s string) string {
+	words := strings.Fields(s)
+	for len(words) > 1 && sentenceStarters[words[0]] {
+		words = words[1:]
+	}
+	if len(words) == 1 {
+		return "" // a bare "Bank" or "Court" is not a name
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,273 @@
+package analysis
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ==================== Knowledge Graph ====================
+
+// People and organisations become nodes. Two of them named in the same
+// sentence are joined by an edge labelled with the words between them
+// ("entered into an agreement with"), or "mentioned with" when they are too
+// far apart for that to read as a relation. Every edge keeps citations to
+// the pages that state it.
+
+const (
+	maxRelationWords  = 8
+	maxEdgeCitations  = 10
+	coMentionRelation = "mentioned with"
+)
+
+// Node is an entity in the graph.
+type Node struct {
+	ID       string `json:"id"` // Entity.Key()
+	Label    string `json:"label"`
+	Type     string `json:"type"`
+	Mentions int    `json:"mentions"` // passages naming it
+}
+
+// Edge is a relation between two nodes, directed as stated in the text.
+type Edge struct {
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Relation  string     `json:"relation"`
+	Weight    int        `json:"weight"` // sentences stating it
+	Citations []Citation `json:"citations"`
+}
+
+// Graph is a project's entity graph.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Relation is one subject–relation–object statement from a sentence.
+type Relation struct {
+	Subject  Entity
+	Relation string
+	Object   Entity
+}
+
+func isGraphEntity(e Entity) bool {
+	return e.Type == EntityPerson || e.Type == EntityOrganization
+}
+
+// ExtractRelations finds relations between consecutive people and
+// organisations named in each sentence of text.
+func ExtractRelations(text string) []Relation {
+	var rels []Relation
+	for _, sentence := range splitSentences(text) {
+		var ents []Entity
+		var spans [][2]int
+		for _, e := range ExtractEntities(sentence) {
+			if !isGraphEntity(e) {
+				continue
+			}
+			i := strings.Index(sentence, e.Text)
+			if i < 0 {
+				continue
+			}
+			ents = append(ents, e)
+			spans = append(spans, [2]int{i, i + len(e.Text)})
+		}
+		for i := 0; i+1 < len(ents); i++ {
+			if ents[i].Key() == ents[i+1].Key() || spans[i][1] > spans[i+1][0] {
+				continue
+			}
+			between := strings.Fields(strings.Trim(sentence[spans[i][1]:spans[i+1][0]], " ,;:()"))
+			relation := coMentionRelation
+			if len(between) > 0 && len(between) <= maxRelationWords {
+				relation = strings.ToLower(strings.Join(between, " "))
+			}
+			rels = append(rels, Relation{Subject: ents[i], Relation: relation, Object: ents[i+1]})
+		}
+	}
+	return rels
+}
+
+// BuildGraph extracts the entity graph of a set of passages.
+func BuildGraph(passages []Passage) *Graph {
+	nodes := map[string]*Node{}
+	edges := map[string]*Edge{}
+	var edgeOrder []string
+
+	for _, p := range passages {
+		for _, e := range ExtractEntities(p.Text) {
+			if !isGraphEntity(e) {
+				continue
+			}
+			n, ok := nodes[e.Key()]
+			if !ok {
+				n = &Node{ID: e.Key(), Label: e.Text, Type: e.Type}
+				nodes[e.Key()] = n
+			}
+			n.Mentions++
+		}
+		for _, rel := range ExtractRelations(p.Text) {
+			from, to := rel.Subject.Key(), rel.Object.Key()
+			key := from + "\x00" + rel.Relation + "\x00" + to
+			edge, ok := edges[key]
+			if !ok {
+				edge = &Edge{From: from, To: to, Relation: rel.Relation}
+				edges[key] = edge
+				edgeOrder = append(edgeOrder, key)
+			}
+			edge.Weight++
+			cite := Citation{Document: p.Document, Page: p.Page}
+			if len(edge.Citations) < maxEdgeCitations && !hasCitation(edge.Citations, cite) {
+				edge.Citations = append(edge.Citations, cite)
+			}
+		}
+	}
+
+	g := &Graph{Nodes: make([]Node, 0, len(nodes)), Edges: make([]Edge, 0, len(edges))}
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, *n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		if g.Nodes[i].Mentions != g.Nodes[j].Mentions {
+			return g.Nodes[i].Mentions > g.Nodes[j].Mentions
+		}
+		return g.Nodes[i].ID < g.Nodes[j].ID
+	})
+	for _, k := range edgeOrder {
+		g.Edges = append(g.Edges, *edges[k])
+	}
+	return g
+}
+
+// FindNode resolves a node by ID, then by label ignoring case, then by the
+// shortest label containing the text.
+func (g *Graph) FindNode(text string) (Node, bool) {
+	want := normalizeName(text)
+	var best *Node
+	for i := range g.Nodes {
+		n := &g.Nodes[i]
+		if n.ID == text || normalizeName(n.Label) == want {
+			return *n, true
+		}
+		if strings.Contains(normalizeName(n.Label), want) && (best == nil || len(n.Label) < len(best.Label)) {
+			best = n
+		}
+	}
+	if best != nil {
+		return *best, true
+	}
+	return Node{}, false
+}
+
+// Neighborhood returns the subgraph of nodes within depth hops of id,
+// following edges in either direction.
+func (g *Graph) Neighborhood(id string, depth int) *Graph {
+	adj := g.adjacency()
+	dist := map[string]int{id: 0}
+	queue := []string{id}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if dist[cur] == depth {
+			continue
+		}
+		for _, ei := range adj[cur] {
+			next := g.Edges[ei].To
+			if next == cur {
+				next = g.Edges[ei].From
+			}
+			if _, seen := dist[next]; !seen {
+				dist[next] = dist[cur] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	sub := &Graph{Nodes: []Node{}, Edges: []Edge{}}
+	for _, n := range g.Nodes {
+		if _, ok := dist[n.ID]; ok {
+			sub.Nodes = append(sub.Nodes, n)
+		}
+	}
+	for _, e := range g.Edges {
+		_, fromIn := dist[e.From]
+		_, toIn := dist[e.To]
+		if fromIn && toIn {
+			sub.Edges = append(sub.Edges, e)
+		}
+	}
+	return sub
+}
+
+// Path returns the edges of a shortest connection between two nodes, in
+// order from the first node, or nil if they are not connected.
+func (g *Graph) Path(from, to string) []Edge {
+	if from == to {
+		return []Edge{}
+	}
+	adj := g.adjacency()
+	via := map[string]int{from: -1} // node -> edge used to reach it
+	queue := []string{from}
+	for len(queue) > 0 && via[to] == 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, ei := range adj[cur] {
+			next := g.Edges[ei].To
+			if next == cur {
+				next = g.Edges[ei].From
+			}
+			if _, seen := via[next]; !seen {
+				via[next] = ei + 1 // +1 so zero means unreached
+				queue = append(queue, next)
+			}
+		}
+	}
+	if _, ok := via[to]; !ok {
+		return nil
+	}
+	var path []Edge
+	for cur := to; cur != from; {
+		e := g.Edges[via[cur]-1]
+		path = append([]Edge{e}, path...)
+		if e.To == cur {
+			cur = e.From
+		} else {
+			cur = e.To
+		}
+	}
+	return path
+}
+
+// adjacency maps each node to the indexes of the edges touching it.
+func (g *Graph) adjacency() map[string][]int {
+	adj := map[string][]int{}
+	for i, e := range g.Edges {
+		adj[e.From] = append(adj[e.From], i)
+		if e.To != e.From {
+			adj[e.To] = append(adj[e.To], i)
+		}
+	}
+	return adj
+}
+
+// SaveGraph writes a graph to path as JSON.
+func SaveGraph(path string, g *Graph) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadGraph reads a graph written by SaveGraph.
+func LoadGraph(path string) (*Graph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var g Graph
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
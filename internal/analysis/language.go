@@ -0,0 +1,59 @@
+package analysis
+
+import "unicode"
+
+// ==================== Language Detection ====================
+
+// Pages are tagged with their language by script: Indian scripts map to
+// one language each (Devanagari is read as Hindi, Arabic script as Urdu,
+// Latin as English). That is enough to route Sarvam-OCR'd regional pages
+// away from English-only embedding models, which is what it is for.
+
+// minLanguageLetters is the fewest letters a text needs for a verdict.
+const minLanguageLetters = 20
+
+var languageScripts = []struct {
+	code  string
+	table *unicode.RangeTable
+}{
+	{"en", unicode.Latin},
+	{"hi", unicode.Devanagari},
+	{"bn", unicode.Bengali},
+	{"ta", unicode.Tamil},
+	{"te", unicode.Telugu},
+	{"gu", unicode.Gujarati},
+	{"kn", unicode.Kannada},
+	{"ml", unicode.Malayalam},
+	{"pa", unicode.Gurmukhi},
+	{"or", unicode.Oriya},
+	{"ur", unicode.Arabic},
+}
+
+// DetectLanguage returns the ISO 639-1 code of the dominant script's
+// language in text, or "" when text has too few letters to tell.
+func DetectLanguage(text string) string {
+	counts := make([]int, len(languageScripts))
+	total := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.Is(unicode.Mn, r) && !unicode.Is(unicode.Mc, r) {
+			continue // Indic vowel signs are marks, not letters
+		}
+		for i, s := range languageScripts {
+			if unicode.Is(s.table, r) {
+				counts[i]++
+				total++
+				break
+			}
+		}
+	}
+	if total < minLanguageLetters {
+		return ""
+	}
+	best := 0
+	for i, n := range counts {
+		if n > counts[best] {
+			best = i
+		}
+	}
+	return languageScripts[best].code
+}
@@ -0,0 +1,214 @@
+package analysis
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ==================== Numeric Verification ====================
+
+// A figure in an answer is checked against the pages its sentence cites.
+// Both sides are normalised first, so "₹2.5 crore" in an answer matches
+// "Rs. 25,000,000" in the source, and a rounded figure matches within half
+// a unit of its last written digit ("₹25 crore" covers 24.5–25.5 crore).
+
+// Quantity is a number found in text, normalised to base units.
+type Quantity struct {
+	Text     string  `json:"text"`
+	Value    float64 `json:"value"`              // 2.5 crore = 25000000
+	Currency string  `json:"currency,omitempty"` // ISO code
+	Percent  bool    `json:"percent,omitempty"`
+
+	tolerance float64 // half a unit of the last written digit, scaled
+	scaled    bool    // written with a scale word such as crore or million
+}
+
+var (
+	quantityPattern = regexp.MustCompile(`(?:(Rs\.?|INR|USD|US\$|EUR|GBP|\$|€|£|₹)\s?)?` +
+		`(\d{1,3}(?:,\d{2,3})+(?:\.\d+)?|\d+(?:\.\d+)?)` +
+		`(?:\s?(%|(?i:per\s?cent|percent|thousand|lakhs?|lacs?|crores?|million|billion|trillion|mn|bn|cr)\b))?` +
+		`(?:\s?((?i:rupees|dollars|euros|pounds)\b|INR\b|USD\b|EUR\b|GBP\b))?`)
+	footnoteMarker = regexp.MustCompile(`\[(\d+)\]`)
+)
+
+var currencyCodes = map[string]string{
+	"rs": "INR", "rs.": "INR", "inr": "INR", "₹": "INR", "rupees": "INR",
+	"$": "USD", "us$": "USD", "usd": "USD", "dollars": "USD",
+	"€": "EUR", "eur": "EUR", "euros": "EUR",
+	"£": "GBP", "gbp": "GBP", "pounds": "GBP",
+}
+
+var scales = map[string]float64{
+	"thousand": 1e3, "lakh": 1e5, "lakhs": 1e5, "lac": 1e5, "lacs": 1e5,
+	"crore": 1e7, "crores": 1e7, "cr": 1e7, "million": 1e6, "mn": 1e6,
+	"billion": 1e9, "bn": 1e9, "trillion": 1e12,
+}
+
+// ParseQuantities finds the numbers in text. Dates, years and footnote
+// markers like [2] are skipped.
+func ParseQuantities(text string) []Quantity {
+	// Blank out dates so their day and year aren't read as figures
+	masked := []byte(text)
+	for _, re := range datePatterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			for i := loc[0]; i < loc[1]; i++ {
+				masked[i] = ' '
+			}
+		}
+	}
+	text = string(masked)
+
+	var out []Quantity
+	for _, m := range quantityPattern.FindAllStringSubmatchIndex(text, -1) {
+		numStart, numEnd := m[4], m[5]
+		if numStart > 0 && isWordByte(text[numStart-1]) && m[2] < 0 {
+			continue // part of a word or code, like "A4"
+		}
+		if numStart > 0 && text[numStart-1] == '[' && numEnd < len(text) && text[numEnd] == ']' {
+			continue
+		}
+		if before := strings.ToLower(text[:numStart]); strings.HasSuffix(before, "p.") || strings.HasSuffix(before, "page ") {
+			continue // a page reference
+		}
+		num := text[numStart:numEnd]
+		value, err := strconv.ParseFloat(strings.ReplaceAll(num, ",", ""), 64)
+		if err != nil {
+			continue
+		}
+
+		q := Quantity{Text: strings.TrimSpace(text[m[0]:m[1]]), Value: value}
+		unit := 1.0
+		if dot := strings.IndexByte(num, '.'); dot >= 0 {
+			unit = math.Pow(10, -float64(len(num)-dot-1))
+		}
+		if m[2] >= 0 {
+			q.Currency = currencyCodes[strings.ToLower(text[m[2]:m[3]])]
+		}
+		if m[6] >= 0 {
+			word := strings.ToLower(text[m[6]:m[7]])
+			if word == "%" || strings.HasPrefix(word, "per") {
+				q.Percent = true
+			} else if f, ok := scales[word]; ok {
+				q.Value *= f
+				unit *= f
+				q.scaled = true
+			}
+		}
+		if m[8] >= 0 {
+			q.Currency = currencyCodes[strings.ToLower(text[m[8]:m[9]])]
+		}
+		if q.Currency == "" && !q.Percent && unit == 1 && !strings.Contains(num, ",") && value >= 1800 && value <= 2200 {
+			continue // a bare year
+		}
+		q.tolerance = unit / 2
+		out = append(out, q)
+	}
+	return out
+}
+
+// comparable reports whether two quantities measure the same kind of thing.
+func (q Quantity) comparable(o Quantity) bool {
+	if q.Percent != o.Percent {
+		return false
+	}
+	return q.Currency == "" || o.Currency == "" || q.Currency == o.Currency
+}
+
+// isFigure reports whether q is an amount, percentage or scaled figure
+// rather than a bare count, which is too ambiguous to call a mismatch.
+func (q Quantity) isFigure() bool {
+	return q.Currency != "" || q.Percent || q.scaled
+}
+
+// matches reports whether source figure o supports q as written.
+func (q Quantity) matches(o Quantity) bool {
+	return q.comparable(o) && math.Abs(q.Value-o.Value) <= q.tolerance+1e-9*math.Abs(o.Value)
+}
+
+// SourcePage is a page an answer cites, by footnote marker.
+type SourcePage struct {
+	Marker   int
+	Document string
+	Page     int
+	Text     string
+}
+
+// Number check statuses.
+const (
+	NumberVerified = "verified"  // the cited page states the figure
+	NumberMismatch = "mismatch"  // the cited page states a different amount or percentage
+	NumberNotFound = "not_found" // the cited page has no comparable figure
+	NumberUncited  = "uncited"   // the answer cites no pages
+)
+
+// NumberCheck is the verification of one figure in an answer.
+type NumberCheck struct {
+	Quantity
+	Sentence string `json:"sentence"`
+	Status   string `json:"status"`
+	Document string `json:"document,omitempty"`
+	Page     int    `json:"page,omitempty"`
+	Source   string `json:"source,omitempty"` // the matching or, for a mismatch, closest figure on the page
+}
+
+// VerifyNumbers checks every figure in answer against the pages cited by
+// its sentence's footnote markers, or against every cited page when its
+// sentence has none.
+func VerifyNumbers(answer string, sources []SourcePage) []NumberCheck {
+	pageQuantities := map[int][]Quantity{} // index into sources
+	quantitiesOf := func(i int) []Quantity {
+		if q, ok := pageQuantities[i]; ok {
+			return q
+		}
+		pageQuantities[i] = ParseQuantities(sources[i].Text)
+		return pageQuantities[i]
+	}
+
+	var checks []NumberCheck
+	for _, sentence := range splitSentences(answer) {
+		var cited []int
+		for _, m := range footnoteMarker.FindAllStringSubmatch(sentence, -1) {
+			n, _ := strconv.Atoi(m[1])
+			for i, s := range sources {
+				if s.Marker == n {
+					cited = append(cited, i)
+				}
+			}
+		}
+		if len(cited) == 0 {
+			for i := range sources {
+				cited = append(cited, i)
+			}
+		}
+
+		for _, q := range ParseQuantities(sentence) {
+			check := NumberCheck{Quantity: q, Sentence: sentence, Status: NumberUncited}
+			if len(cited) > 0 {
+				check.Status = NumberNotFound
+			}
+			closest := math.Inf(1)
+			for _, i := range cited {
+				for _, src := range quantitiesOf(i) {
+					if q.matches(src) {
+						check.Status, check.Document, check.Page, check.Source = NumberVerified, sources[i].Document, sources[i].Page, src.Text
+						break
+					}
+					if !q.isFigure() || !q.comparable(src) {
+						continue
+					}
+					if d := math.Abs(q.Value - src.Value); d < closest {
+						closest = d
+						check.Status, check.Document, check.Page, check.Source = NumberMismatch, sources[i].Document, sources[i].Page, src.Text
+					}
+				}
+				if check.Status == NumberVerified {
+					break
+				}
+			}
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
@@ -0,0 +1,126 @@
+package analysis
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ==================== OCR Correction ====================
+
+// OCR misreads characters that look alike — "rn" for "m", "1" for "l" — and
+// keyword search then misses the word. Without a dictionary, a corpus is its
+// own: a word OCR read rarely that one misreading away from a word read
+// often is taken to be that word misread.
+
+// ocrConfusions are pairs of character sequences OCR mistakes for each
+// other, in either direction.
+var ocrConfusions = [][2]string{
+	{"rn", "m"}, {"cl", "d"}, {"vv", "w"}, {"li", "h"}, {"ii", "u"}, {"in", "m"},
+	{"1", "l"}, {"1", "i"}, {"i", "l"}, {"l", "t"}, {"f", "t"},
+	{"0", "o"}, {"o", "c"}, {"e", "c"}, {"a", "o"},
+	{"5", "s"}, {"8", "b"}, {"6", "b"}, {"h", "b"}, {"n", "h"}, {"u", "v"},
+}
+
+const (
+	ocrMinWordLen = 5 // shorter words are too often each other's misreading
+	ocrMaxRare    = 2 // a misreading is seen at most this often...
+	ocrMinCommon  = 5 // ...and the word it misreads at least this often
+	ocrRatio      = 5 // and this many times as often
+)
+
+var ocrWordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// OCRCorrector corrects misread words against the words of a corpus.
+type OCRCorrector struct {
+	counts map[string]int // lowercased word → occurrences
+}
+
+// NewOCRCorrector counts the words of texts, e.g. a document's pages.
+func NewOCRCorrector(texts []string) *OCRCorrector {
+	c := &OCRCorrector{counts: make(map[string]int)}
+	for _, text := range texts {
+		for _, w := range ocrWordPattern.FindAllString(text, -1) {
+			c.counts[strings.ToLower(w)]++
+		}
+	}
+	return c
+}
+
+// Correct returns text with its misread words replaced by the words they
+// misread, in the same case, and how many it replaced.
+func (c *OCRCorrector) Correct(text string) (string, int) {
+	n := 0
+	corrected := ocrWordPattern.ReplaceAllStringFunc(text, func(w string) string {
+		fix, ok := c.correction(strings.ToLower(w))
+		if !ok {
+			return w
+		}
+		n++
+		return matchCase(fix, w)
+	})
+	return corrected, n
+}
+
+// correction returns the word w (lowercase) misreads, if one word is
+// clearly it.
+func (c *OCRCorrector) correction(w string) (string, bool) {
+	count := c.counts[w]
+	if len(w) < ocrMinWordLen || count > ocrMaxRare {
+		return "", false
+	}
+	best, bestCount, tied := "", 0, false
+	for _, pair := range ocrConfusions {
+		for _, p := range [][2]string{pair, {pair[1], pair[0]}} {
+			for i := 0; i+len(p[0]) <= len(w); i++ {
+				if w[i:i+len(p[0])] != p[0] {
+					continue
+				}
+				cand := w[:i] + p[1] + w[i+len(p[0]):]
+				n := c.counts[cand]
+				if n < ocrMinCommon || n < ocrRatio*max(count, 1) || !isLetters(cand) || cand == best {
+					continue
+				}
+				switch {
+				case n > bestCount:
+					best, bestCount, tied = cand, n, false
+				case n == bestCount:
+					tied = true
+				}
+			}
+		}
+	}
+	return best, best != "" && !tied
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCase returns word (lowercase) capitalized like like: all upper, an
+// initial capital, or lower.
+func matchCase(word, like string) string {
+	letters, upper := 0, 0
+	for _, r := range like {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	switch {
+	case letters > 1 && upper == letters:
+		return strings.ToUpper(word)
+	case unicode.IsUpper([]rune(like)[0]):
+		r := []rune(word)
+		r[0] = unicode.ToUpper(r[0])
+		return string(r)
+	}
+	return word
+}
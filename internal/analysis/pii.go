@@ -0,0 +1,140 @@
+package analysis
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ==================== PII Detection ====================
+
+// Personal identifiers are found by pattern: Aadhaar and PAN numbers (with
+// their checksum and structure rules, so invoice and account numbers aren't
+// caught), US SSNs, email addresses and phone numbers.
+
+// PII types.
+const (
+	PIIAadhaar = "aadhaar"
+	PIIPAN     = "pan"
+	PIISSN     = "ssn"
+	PIIEmail   = "email"
+	PIIPhone   = "phone"
+)
+
+var piiPatterns = []struct {
+	kind  string
+	re    *regexp.Regexp
+	valid func(string) bool
+}{
+	{PIIEmail, regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`), nil},
+	{PIIAadhaar, regexp.MustCompile(`\b[2-9]\d{3}[ -]?\d{4}[ -]?\d{4}\b`), validAadhaar},
+	{PIIPAN, regexp.MustCompile(`\b[A-Z]{3}[PCHFATBLJG][A-Z]\d{4}[A-Z]\b`), nil},
+	{PIISSN, regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), validSSN},
+	{PIIPhone, regexp.MustCompile(`(?:\+91[ -]?|\b)[6-9]\d{4}[ -]?\d{5}\b|\+\d{1,3}[ -]?\(?\d{2,4}\)?[ -]?\d{3,4}[ -]?\d{3,4}\b|\(\d{3}\) ?\d{3}-\d{4}\b`), nil},
+}
+
+// PIIMatch is one personal identifier found in text.
+type PIIMatch struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// FindPII returns the identifiers in text in order. Where patterns overlap
+// the earlier-listed type wins, so an Aadhaar number isn't also a phone.
+func FindPII(text string) []PIIMatch {
+	var found []PIIMatch
+	taken := func(start, end int) bool {
+		for _, m := range found {
+			if start < m.End && end > m.Start {
+				return true
+			}
+		}
+		return false
+	}
+	for _, p := range piiPatterns {
+		for _, loc := range p.re.FindAllStringIndex(text, -1) {
+			s := text[loc[0]:loc[1]]
+			if (p.valid != nil && !p.valid(s)) || taken(loc[0], loc[1]) {
+				continue
+			}
+			found = append(found, PIIMatch{Type: p.kind, Text: s, Start: loc[0], End: loc[1]})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Start < found[j].Start })
+	return found
+}
+
+// PIITypes lists the distinct kinds of identifier in text, sorted.
+func PIITypes(text string) []string {
+	seen := map[string]bool{}
+	var types []string
+	for _, m := range FindPII(text) {
+		if !seen[m.Type] {
+			seen[m.Type] = true
+			types = append(types, m.Type)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// RedactPII replaces every identifier in text with a placeholder such as
+// [REDACTED PAN].
+func RedactPII(text string) string {
+	matches := FindPII(text)
+	if len(matches) == 0 {
+		return text
+	}
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		sb.WriteString(text[last:m.Start])
+		sb.WriteString("[REDACTED " + strings.ToUpper(m.Type) + "]")
+		last = m.End
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// validSSN rejects numbers the SSA never issues.
+func validSSN(s string) bool {
+	area, group, serial := s[:3], s[4:6], s[7:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// Verhoeff checksum tables, used by Aadhaar numbers.
+var (
+	verhoeffD = [10][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, {1, 2, 3, 4, 0, 6, 7, 8, 9, 5},
+		{2, 3, 4, 0, 1, 7, 8, 9, 5, 6}, {3, 4, 0, 1, 2, 8, 9, 5, 6, 7},
+		{4, 0, 1, 2, 3, 9, 5, 6, 7, 8}, {5, 9, 8, 7, 6, 0, 4, 3, 2, 1},
+		{6, 5, 9, 8, 7, 1, 0, 4, 3, 2}, {7, 6, 5, 9, 8, 2, 1, 0, 4, 3},
+		{8, 7, 6, 5, 9, 3, 2, 1, 0, 4}, {9, 8, 7, 6, 5, 4, 3, 2, 1, 0},
+	}
+	verhoeffP = [8][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, {1, 5, 7, 6, 2, 8, 3, 0, 9, 4},
+		{5, 8, 0, 3, 7, 9, 6, 1, 4, 2}, {8, 9, 1, 6, 0, 4, 3, 5, 2, 7},
+		{9, 4, 5, 3, 1, 2, 6, 8, 7, 0}, {4, 2, 8, 6, 5, 7, 3, 9, 0, 1},
+		{2, 7, 9, 3, 8, 0, 6, 4, 1, 5}, {7, 0, 4, 6, 9, 1, 3, 2, 5, 8},
+	}
+)
+
+// validAadhaar checks an Aadhaar number's Verhoeff check digit.
+func validAadhaar(s string) bool {
+	var digits []int
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, int(r-'0'))
+		}
+	}
+	if len(digits) != 12 {
+		return false
+	}
+	c := 0
+	for i := range digits {
+		c = verhoeffD[c][verhoeffP[i%8][digits[len(digits)-1-i]]]
+	}
+	return c == 0
+}
@@ -0,0 +1,151 @@
+package analysis
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ==================== Cited Passages ====================
+
+// A footnote cites a page, not the words on it. CitedSpans finds the
+// sentences of the page the answer most likely draws on, so that exports
+// and page views can highlight them.
+
+// Span is a range of bytes in a text.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// markerList matches footnote markers, including several at once as [1, 2].
+var markerList = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// stopwords are left out when comparing a claim with a page's sentences.
+var stopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "was": true, "were": true, "with": true, "that": true,
+	"this": true, "from": true, "has": true, "have": true, "had": true, "not": true, "but": true, "its": true,
+	"which": true, "their": true, "they": true, "been": true, "will": true, "shall": true, "any": true,
+	"all": true, "such": true, "under": true, "into": true, "also": true, "than": true, "there": true,
+	"these": true, "those": true, "per": true, "may": true, "can": true, "other": true, "each": true,
+}
+
+// Limits of CitedSpans.
+const (
+	maxCitedSpans  = 3
+	minSharedWords = 2
+)
+
+// CitedSpans returns the spans of source.Text, in order, of up to three
+// sentences that support the sentences of answer carrying source.Marker
+// (every sentence, if none carries a marker): those sharing at least two
+// content words, and a third of a cited sentence's, with one of them.
+func CitedSpans(answer string, source SourcePage) []Span {
+	var claims []map[string]bool
+	marked := false
+	for _, s := range sentenceSpans(answer) {
+		sentence := answer[s.Start:s.End]
+		ids := markerList.FindAllStringSubmatch(sentence, -1)
+		marked = marked || len(ids) > 0
+		if len(ids) > 0 && !citesMarker(ids, source.Marker) {
+			continue
+		}
+		if words := contentWords(markerList.ReplaceAllString(sentence, " ")); len(words) > 0 {
+			claims = append(claims, words)
+		}
+	}
+	if marked && len(claims) == 0 {
+		return nil
+	}
+
+	type scored struct {
+		span  Span
+		score float64
+	}
+	var found []scored
+	for _, s := range sentenceSpans(source.Text) {
+		words := contentWords(source.Text[s.Start:s.End])
+		best := 0.0
+		for _, claim := range claims {
+			shared := 0
+			for w := range claim {
+				if words[w] {
+					shared++
+				}
+			}
+			if score := float64(shared) / float64(len(claim)); shared >= min(minSharedWords, len(claim)) && score >= 1.0/3 && score > best {
+				best = score
+			}
+		}
+		if best > 0 {
+			found = append(found, scored{s, best})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].score > found[j].score })
+	if len(found) > maxCitedSpans {
+		found = found[:maxCitedSpans]
+	}
+	spans := make([]Span, len(found))
+	for i, f := range found {
+		spans[i] = f.span
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	return spans
+}
+
+func citesMarker(lists [][]string, marker int) bool {
+	for _, m := range lists {
+		for _, id := range strings.Split(m[1], ",") {
+			if n, _ := strconv.Atoi(strings.TrimSpace(id)); n == marker {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contentWords is the set of text's lowercased words of three letters or
+// more, and its numbers, without stopwords.
+func contentWords(text string) map[string]bool {
+	words := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if (len([]rune(w)) >= 3 || unicode.IsDigit([]rune(w)[0])) && !stopwords[w] {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// sentenceSpans splits text into sentences as splitSentences does, as spans
+// of text trimmed of surrounding space.
+func sentenceSpans(text string) []Span {
+	var out []Span
+	last := 0
+	add := func(start, end int) {
+		for start < end && unicode.IsSpace(rune(text[start])) {
+			start++
+		}
+		for end > start && unicode.IsSpace(rune(text[end-1])) {
+			end--
+		}
+		if start < end {
+			out = append(out, Span{start, end})
+		}
+	}
+	for _, loc := range sentenceEnd.FindAllStringIndex(text, -1) {
+		if text[loc[0]] == '.' {
+			words := strings.Fields(text[last:loc[0]])
+			if n := len(words); n > 0 && (abbreviations[words[n-1]] || isInitial(words[n-1])) {
+				continue
+			}
+		}
+		add(last, loc[0]+1)
+		last = loc[1]
+	}
+	add(last, len(text))
+	return out
+}
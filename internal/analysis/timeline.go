@@ -0,0 +1,212 @@
+package analysis
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Passage is a page of document text handed to the corpus-level builders.
+type Passage struct {
+	Document string
+	Page     int
+	Text     string
+}
+
+// Citation points at the passage a fact came from.
+type Citation struct {
+	Document string `json:"document"`
+	Page     int    `json:"page"`
+}
+
+// Date precisions.
+const (
+	PrecisionDay   = "day"
+	PrecisionMonth = "month"
+)
+
+// Event is a dated statement found in a passage.
+type Event struct {
+	Date      time.Time
+	Precision string
+	DateText  string // the date as written
+	Sentence  string
+}
+
+// TimelineEntry is one event on a merged timeline. The same event stated in
+// several places appears once, with a citation for each place.
+type TimelineEntry struct {
+	Date      string     `json:"date"` // 2006-01-02, or 2006-01 for month precision
+	Precision string     `json:"precision"`
+	DateText  string     `json:"date_text"`
+	Event     string     `json:"event"`
+	Citations []Citation `json:"citations"`
+
+	sortKey time.Time
+}
+
+var (
+	sentenceEnd = regexp.MustCompile(`[.!?;]\s+|\n{2,}`)
+	ordinal     = regexp.MustCompile(`(\d)(?:st|nd|rd|th)\b`)
+	numericDate = regexp.MustCompile(`^(\d{1,2})[/.](\d{1,2})[/.](\d{4})$`)
+)
+
+// maxEventLen caps the sentence kept as an event description.
+const maxEventLen = 300
+
+// ParseDate parses a date in any of the forms ExtractEntities recognises.
+// Numeric dates are read day-first unless that is impossible.
+func ParseDate(s string) (time.Time, string, bool) {
+	s = strings.Join(strings.Fields(s), " ")
+	s = ordinal.ReplaceAllString(s, "$1")
+	s = strings.ReplaceAll(s, " day of", "")
+	s = strings.ReplaceAll(s, ",", "")
+	s = strings.ReplaceAll(s, ".", " ")
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.Replace(s, "Sept ", "Sep ", 1)
+
+	if m := numericDate.FindStringSubmatch(strings.ReplaceAll(strings.TrimSpace(s), " ", ".")); m != nil {
+		a, _ := strconv.Atoi(m[1])
+		b, _ := strconv.Atoi(m[2])
+		y, _ := strconv.Atoi(m[3])
+		day, month := a, b
+		if b > 12 && a <= 12 {
+			day, month = b, a
+		}
+		t := time.Date(y, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		if month < 1 || month > 12 || t.Day() != day {
+			return time.Time{}, "", false
+		}
+		return t, PrecisionDay, plausibleYear(y)
+	}
+
+	for _, layout := range []string{"2 January 2006", "2 Jan 2006", "January 2 2006", "Jan 2 2006", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, PrecisionDay, plausibleYear(t.Year())
+		}
+	}
+	for _, layout := range []string{"January 2006", "Jan 2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, PrecisionMonth, plausibleYear(t.Year())
+		}
+	}
+	return time.Time{}, "", false
+}
+
+func plausibleYear(y int) bool {
+	return y >= 1800 && y <= 2200
+}
+
+// ExtractEvents returns every sentence in text that contains a parseable
+// date, once per date it mentions.
+func ExtractEvents(text string) []Event {
+	var events []Event
+	for _, sentence := range splitSentences(text) {
+		for _, e := range ExtractEntities(sentence) {
+			if e.Type != EntityDate {
+				continue
+			}
+			t, precision, ok := ParseDate(e.Text)
+			if !ok {
+				continue
+			}
+			events = append(events, Event{Date: t, Precision: precision, DateText: e.Text, Sentence: sentence})
+		}
+	}
+	return events
+}
+
+// abbreviations end in a full stop without ending the sentence.
+var abbreviations = map[string]bool{
+	"Mr": true, "Mrs": true, "Ms": true, "Dr": true, "Prof": true, "Smt": true, "Sr": true, "Jr": true,
+	"St": true, "No": true, "Nos": true, "Co": true, "Rs": true, "vs": true, "v": true, "viz": true, "i.e": true, "e.g": true,
+}
+
+func splitSentences(text string) []string {
+	var out []string
+	last := 0
+	for _, loc := range sentenceEnd.FindAllStringIndex(text, -1) {
+		if text[loc[0]] == '.' {
+			words := strings.Fields(text[last:loc[0]])
+			if n := len(words); n > 0 && (abbreviations[words[n-1]] || isInitial(words[n-1])) {
+				continue
+			}
+		}
+		out = appendSentence(out, text[last:loc[0]+1])
+		last = loc[1]
+	}
+	return appendSentence(out, text[last:])
+}
+
+// isInitial reports whether w is a single capital letter, as in "J. Smith".
+func isInitial(w string) bool {
+	return len(w) == 1 && w[0] >= 'A' && w[0] <= 'Z'
+}
+
+func appendSentence(out []string, s string) []string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > maxEventLen {
+		s = s[:maxEventLen] + "…"
+	}
+	if s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// BuildTimeline extracts events from passages and merges them into one
+// chronological timeline. Events with the same date and wording are merged,
+// keeping a citation for every passage that states them.
+func BuildTimeline(passages []Passage) []TimelineEntry {
+	byKey := map[string]*TimelineEntry{}
+	var order []string
+	for _, p := range passages {
+		for _, ev := range ExtractEvents(p.Text) {
+			date := ev.Date.Format("2006-01-02")
+			if ev.Precision == PrecisionMonth {
+				date = ev.Date.Format("2006-01")
+			}
+			key := date + "|" + strings.ToLower(ev.Sentence)
+			entry, ok := byKey[key]
+			if !ok {
+				entry = &TimelineEntry{
+					Date:      date,
+					Precision: ev.Precision,
+					DateText:  ev.DateText,
+					Event:     ev.Sentence,
+					sortKey:   ev.Date,
+				}
+				byKey[key] = entry
+				order = append(order, key)
+			}
+			cite := Citation{Document: p.Document, Page: p.Page}
+			if !hasCitation(entry.Citations, cite) {
+				entry.Citations = append(entry.Citations, cite)
+			}
+		}
+	}
+
+	timeline := make([]TimelineEntry, 0, len(order))
+	for _, k := range order {
+		timeline = append(timeline, *byKey[k])
+	}
+	sort.SliceStable(timeline, func(i, j int) bool {
+		if !timeline[i].sortKey.Equal(timeline[j].sortKey) {
+			return timeline[i].sortKey.Before(timeline[j].sortKey)
+		}
+		// A month-precision event sorts before the days of that month
+		return timeline[i].Precision == PrecisionMonth && timeline[j].Precision == PrecisionDay
+	})
+	return timeline
+}
+
+func hasCitation(cites []Citation, c Citation) bool {
+	for _, x := range cites {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
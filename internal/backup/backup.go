@@ -0,0 +1,298 @@
+// Package backup snapshots the server's data into a storage location (see
+// package storage) as zip archives, keeps the newest of them and restores
+// one into a data directory. Schedule parses the cron expressions backups
+// run on.
+package backup
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gocognigo/internal/storage"
+)
+
+// Backups are named namePrefix + their UTC time + nameSuffix, so they sort
+// by age.
+const (
+	namePrefix = "gocognigo-backup-"
+	nameSuffix = ".zip"
+	timeFormat = "20060102T150405Z"
+)
+
+// manifestName is the backup's description inside the archive; the files
+// are under "data/".
+const manifestName = "backup.json"
+
+// Manifest describes a backup.
+type Manifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Uploads   bool      `json:"uploads"` // whether the projects' raw uploads are included
+	Files     int       `json:"files"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// Options are what a backup leaves out.
+type Options struct {
+	ExcludeUploads bool                  // leave out the projects' raw uploads
+	Skip           func(key string) bool // data files never backed up, e.g. locks
+}
+
+// Info is a backup as listed.
+type Info struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+}
+
+// IsName reports whether name is a backup's.
+func IsName(name string) bool {
+	_, ok := parseName(name)
+	return ok
+}
+
+func parseName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, nameSuffix)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timeFormat, stamp)
+	return t, err == nil
+}
+
+// IsUpload reports whether a data key is a project's raw upload:
+// users/<store>_projects/<project>/uploads/<file>.
+func IsUpload(key string) bool {
+	parts := strings.Split(key, "/")
+	return len(parts) >= 5 && parts[0] == "users" && strings.HasSuffix(parts[1], "_projects") && parts[3] == "uploads"
+}
+
+// Create snapshots the data in src into a new backup in dst, named for
+// now. The archive is built in a temp file, as storing it needs its size.
+func Create(src, dst storage.Storage, opts Options, now time.Time) (*Info, *Manifest, error) {
+	objects, err := src.List("")
+	if err != nil {
+		return nil, nil, fmt.Errorf("list %s: %w", src, err)
+	}
+
+	tmp, err := os.CreateTemp("", namePrefix+"*"+nameSuffix)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	m := &Manifest{CreatedAt: now.UTC(), Uploads: !opts.ExcludeUploads}
+	zw := zip.NewWriter(tmp)
+	for _, o := range objects {
+		if IsName(path.Base(o.Key)) || (opts.Skip != nil && opts.Skip(o.Key)) || (opts.ExcludeUploads && IsUpload(o.Key)) {
+			continue
+		}
+		n, err := addFile(zw, src, o)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // deleted since listed
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		m.Files++
+		m.Bytes += n
+	}
+	mw, err := zw.Create(manifestName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.NewEncoder(mw).Encode(m); err != nil {
+		return nil, nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	info := &Info{Name: namePrefix + now.UTC().Format(timeFormat) + nameSuffix, CreatedAt: m.CreatedAt}
+	if info.Size, err = tmp.Seek(0, io.SeekEnd); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	if err := dst.Put(info.Name, tmp, info.Size); err != nil {
+		return nil, nil, fmt.Errorf("store %s in %s: %w", info.Name, dst, err)
+	}
+	return info, m, nil
+}
+
+// addFile copies a stored file into the archive, returning its size.
+func addFile(zw *zip.Writer, src storage.Storage, o storage.Object) (int64, error) {
+	body, err := src.Get(o.Key)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	hdr := &zip.FileHeader{Name: "data/" + o.Key, Method: zip.Deflate, Modified: o.Modified}
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return n, fmt.Errorf("back up %s: %w", o.Key, err)
+	}
+	return n, nil
+}
+
+// List returns the backups in dst, newest first.
+func List(dst storage.Storage) ([]Info, error) {
+	objects, err := dst.List(namePrefix)
+	if err != nil {
+		return nil, err
+	}
+	var backups []Info
+	for _, o := range objects {
+		if t, ok := parseName(o.Key); ok {
+			backups = append(backups, Info{Name: o.Key, CreatedAt: t, Size: o.Size})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// Rotate deletes all but the keep newest backups in dst, returning those
+// deleted.
+func Rotate(dst storage.Storage, keep int) ([]string, error) {
+	backups, err := List(dst)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for i := keep; i < len(backups); i++ {
+		if err := dst.Delete(backups[i].Name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, backups[i].Name)
+	}
+	return deleted, nil
+}
+
+// Restore replaces the data in dir with the backup name in dst: its files
+// are written and files it doesn't hold are deleted, except those keep
+// reports true for and, if the backup has no uploads, the uploads.
+func Restore(dst storage.Storage, name, dir string, keep func(key string) bool) (*Manifest, error) {
+	body, err := dst.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", namePrefix+"*"+nameSuffix)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, body)
+	body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", name, err)
+	}
+
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a backup: %w", name, err)
+	}
+	m, err := readManifest(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a backup: %w", name, err)
+	}
+	restored := make(map[string]bool)
+	for _, f := range zr.File {
+		key, ok := strings.CutPrefix(f.Name, "data/")
+		if !ok || f.FileInfo().IsDir() {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(key)) {
+			return nil, fmt.Errorf("backup entry %q escapes the data directory", f.Name)
+		}
+		if err := extractFile(f, filepath.Join(dir, filepath.FromSlash(key))); err != nil {
+			return nil, err
+		}
+		restored[key] = true
+	}
+
+	existing, err := (&storage.Local{Root: dir}).List("")
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range existing {
+		if restored[o.Key] || (keep != nil && keep(o.Key)) || (!m.Uploads && IsUpload(o.Key)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(o.Key))); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func readManifest(zr *zip.Reader) (*Manifest, error) {
+	for _, f := range zr.File {
+		if f.Name != manifestName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		var m Manifest
+		if err := json.NewDecoder(rc).Decode(&m); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", manifestName, err)
+		}
+		return &m, nil
+	}
+	return nil, fmt.Errorf("%s is missing", manifestName)
+}
+
+// extractFile writes an archived file to path through a temp file, with its
+// modification time.
+func extractFile(f *zip.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, rc)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), path)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return fmt.Errorf("restore %s: %w", f.Name, err)
+	}
+	if !f.Modified.IsZero() {
+		_ = os.Chtimes(path, f.Modified, f.Modified)
+	}
+	return nil
+}
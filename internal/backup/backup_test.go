@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gocognigo/internal/storage"
+)
+
+func put(t *testing.T, st storage.Storage, key, content string) {
+	t.Helper()
+	if err := st.Put(key, strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Put %s: %v", key, err)
+	}
+}
+
+// ========== Schedules ==========
+
+func TestParseSchedule_Next(t *testing.T) {
+	from := time.Date(2026, 3, 14, 10, 30, 0, 0, time.UTC) // a Saturday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 3 * * *", time.Date(2026, 3, 15, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 3, 14, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2026, 3, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2026, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 20 * 1", time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)}, // day 20 or a Monday
+		{"@monthly", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 14, 11, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.expr)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("ParseSchedule(%q) should fail", bad)
+		}
+	}
+}
+
+// ========== Backups ==========
+
+func TestCreateAndRestore(t *testing.T) {
+	src := &storage.Local{Root: t.TempDir()}
+	put(t, src, "workspaces.json", "[]")
+	put(t, src, "users/u1_projects/projects.json", `[{"id":"p1"}]`)
+	put(t, src, "users/u1_projects/p1/vectors.json", "{}")
+	put(t, src, "users/u1_projects/p1/conversations/c1.json", `{"id":"c1"}`)
+	put(t, src, "users/u1_projects/p1/uploads/a.pdf", "%PDF")
+	put(t, src, ".instance.lock", "pid")
+
+	dst := &storage.Local{Root: t.TempDir()}
+	now := time.Date(2026, 3, 14, 3, 0, 0, 0, time.UTC)
+	skip := func(key string) bool { return key == ".instance.lock" }
+
+	info, m, err := Create(src, dst, Options{Skip: skip}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "gocognigo-backup-20260314T030000Z.zip" || info.Size == 0 {
+		t.Errorf("Create = %+v", info)
+	}
+	if m.Files != 5 || !m.Uploads {
+		t.Errorf("manifest = %+v", m)
+	}
+
+	// Restoring replaces what is there, but for the files kept
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "users/u1_projects/p2"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "users/u1_projects/p2/vectors.json"), []byte("{}"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "users/u1_projects/projects.json"), []byte("[]"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, ".instance.lock"), []byte("me"), 0644)
+	restored, err := Restore(dst, info.Name, dir, skip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Files != 5 || !restored.CreatedAt.Equal(now) {
+		t.Errorf("restored manifest = %+v", restored)
+	}
+	for _, rel := range []string{"workspaces.json", "users/u1_projects/p1/conversations/c1.json", "users/u1_projects/p1/uploads/a.pdf"} {
+		if _, err := os.Stat(filepath.Join(dir, rel)); err != nil {
+			t.Errorf("%s not restored: %v", rel, err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "users/u1_projects/projects.json")); string(data) != `[{"id":"p1"}]` {
+		t.Errorf("projects.json = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users/u1_projects/p2/vectors.json")); err == nil {
+		t.Error("file not in the backup survived the restore")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, ".instance.lock")); string(data) != "me" {
+		t.Errorf("kept file = %q, want it untouched", data)
+	}
+
+	if _, err := Restore(dst, "gocognigo-backup-20200101T000000Z.zip", dir, nil); err == nil {
+		t.Error("expected an error restoring a missing backup")
+	}
+}
+
+func TestCreate_ExcludeUploads(t *testing.T) {
+	src := &storage.Local{Root: t.TempDir()}
+	put(t, src, "users/u1_projects/p1/vectors.json", "{}")
+	put(t, src, "users/u1_projects/p1/uploads/a.pdf", "%PDF")
+	put(t, src, "users/u1_projects/uploads.json", "{}") // not a project's upload
+
+	// The backups may share the data's storage
+	now := time.Date(2026, 3, 14, 3, 0, 0, 0, time.UTC)
+	if _, _, err := Create(src, src, Options{}, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	info, m, err := Create(src, src, Options{ExcludeUploads: true}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Files != 2 || m.Uploads {
+		t.Errorf("manifest = %+v, want 2 files without uploads", m)
+	}
+
+	// The uploads already there are kept
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "users/u1_projects/p1/uploads"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "users/u1_projects/p1/uploads/b.pdf"), []byte("%PDF-b"), 0644)
+	if _, err := Restore(src, info.Name, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users/u1_projects/p1/uploads/a.pdf")); err == nil {
+		t.Error("upload was backed up")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users/u1_projects/p1/uploads/b.pdf")); err != nil {
+		t.Errorf("existing upload not kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users/u1_projects/uploads.json")); err != nil {
+		t.Errorf("uploads.json not backed up: %v", err)
+	}
+}
+
+func TestListAndRotate(t *testing.T) {
+	dst := &storage.Local{Root: t.TempDir()}
+	src := &storage.Local{Root: t.TempDir()}
+	put(t, src, "workspaces.json", "[]")
+	put(t, dst, "gocognigo-backup-notes.txt", "not a backup")
+
+	start := time.Date(2026, 3, 10, 3, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		if _, _, err := Create(src, dst, Options{}, start.AddDate(0, 0, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := List(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 4 || !backups[0].CreatedAt.Equal(start.AddDate(0, 0, 3)) {
+		t.Fatalf("List = %+v", backups)
+	}
+
+	deleted, err := Rotate(dst, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deleted, ",") != "gocognigo-backup-20260311T030000Z.zip,gocognigo-backup-20260310T030000Z.zip" {
+		t.Errorf("Rotate deleted %v", deleted)
+	}
+	if backups, _ := List(dst); len(backups) != 2 || backups[1].Name != "gocognigo-backup-20260312T030000Z.zip" {
+		t.Errorf("List after Rotate = %+v", backups)
+	}
+	if objs, _ := dst.List("gocognigo-backup-notes"); len(objs) != 1 {
+		t.Error("Rotate deleted a file that isn't a backup")
+	}
+}
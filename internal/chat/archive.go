@@ -0,0 +1,209 @@
+package chat
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ==================== Project Archives ====================
+
+// archiveManifest is the project's metadata inside an archive.
+const archiveManifest = "project.json"
+
+// projectKeysFile holds a project's own provider keys (see KeysPath).
+const projectKeysFile = "keys.json"
+
+// archiveSkipped reports whether a project file is left out of archives:
+// the keyword index and binary vectors are rebuilt from vectors.json, and
+// the project's provider keys stay on the server.
+func archiveSkipped(rel string) bool {
+	return rel == "bm25.index" || strings.HasPrefix(rel, "bm25.index/") || rel == "vectors.gob" || rel == projectKeysFile
+}
+
+// ExportProject writes a zip archive of a project to w: its metadata and
+// everything in its directory (uploads, vectors, saved chunks, summaries,
+// conversations), except the indexes ImportProject rebuilds.
+func (s *ProjectStore) ExportProject(id string, w io.Writer) error {
+	project, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+
+	manifest, _ := json.MarshalIndent(project, "", "  ")
+	mw, err := zw.Create(archiveManifest)
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(manifest); err != nil {
+		return err
+	}
+
+	projDir := s.ProjectDir(id)
+	err = filepath.WalkDir(projDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(projDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if archiveSkipped(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fw, err := zw.Create("files/" + rel)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive project %s: %w", id, err)
+	}
+	return zw.Close()
+}
+
+// ImportProject creates a project from an archive written by ExportProject,
+// named name or, if empty, as the archived project. It gets a new ID, so an
+// archive can be imported next to its original; it is never published. The
+// caller rebuilds its keyword index from vectors.json before querying it.
+func (s *ProjectStore) ImportProject(r io.ReaderAt, size int64, name string) (*Project, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a zip archive: %w", err)
+	}
+
+	var source Project
+	found := false
+	for _, f := range zr.File {
+		if f.Name != archiveManifest {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(rc).Decode(&source)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", archiveManifest, err)
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("not a project archive: %s is missing", archiveManifest)
+	}
+	if name == "" {
+		name = source.Name
+	}
+
+	project, err := s.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.extractArchive(zr, project.ID); err != nil {
+		_ = s.Delete(project.ID)
+		return nil, err
+	}
+
+	project.Description = source.Description
+	project.Tags = source.Tags
+	project.SystemPrompt = source.SystemPrompt
+	project.BasePrompt = source.BasePrompt
+	project.Author = source.Author
+	project.PIIMode = source.PIIMode
+	project.RedactAnswers = source.RedactAnswers
+	project.DocumentTags = source.DocumentTags
+	if entries, err := os.ReadDir(s.UploadsDir(project.ID)); err == nil {
+		for _, e := range entries {
+			if !e.IsDir() {
+				project.FileCount++
+			}
+		}
+	}
+	if _, err := os.Stat(s.VectorsPath(project.ID)); err == nil {
+		project.ChunkCount = source.ChunkCount
+		project.Status = "ready"
+	}
+	if err := s.Update(*project); err != nil {
+		return nil, err
+	}
+	// The archive's files are as old as the project they came from
+	if err := s.runMigrations(project.ID, source.SchemaVersion); err != nil {
+		_ = s.Delete(project.ID)
+		return nil, err
+	}
+	return project, nil
+}
+
+// extractArchive writes an archive's files into a project's directory,
+// pointing its conversations at the project.
+func (s *ProjectStore) extractArchive(zr *zip.Reader, id string) error {
+	projDir := s.ProjectDir(id)
+	for _, f := range zr.File {
+		rel, ok := strings.CutPrefix(f.Name, "files/")
+		if !ok || f.FileInfo().IsDir() || archiveSkipped(rel) {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return fmt.Errorf("archive entry %q escapes the project directory", f.Name)
+		}
+		dst := filepath.Join(projDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		if path.Dir(rel) == "conversations" && strings.HasSuffix(rel, ".meta.json") {
+			var conv Conversation
+			err = json.NewDecoder(rc).Decode(&conv)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("invalid conversation %s: %w", rel, err)
+			}
+			conv.ProjectID = id
+			data, _ := json.MarshalIndent(conv, "", "  ")
+			if err := os.WriteFile(dst, data, 0644); err != nil {
+				return err
+			}
+			continue
+		}
+		out, err := os.Create(dst)
+		if err != nil {
+			rc.Close()
+			return err
+		}
+		_, err = io.Copy(out, rc)
+		rc.Close()
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ==================== Data Directory Lock ====================
+
+// Instances sharing a data directory would overwrite each other's
+// projects.json and corrupt the bleve indexes, so each takes an exclusive
+// lock on it first. The lock is a file rather than an OS lock so it works
+// the same everywhere, including shared volumes: its holder touches it
+// every lockHeartbeat, and a lock left untouched for lockStaleAfter (its
+// holder crashed or was killed) is taken over.
+
+const (
+	lockFileName   = ".instance.lock"
+	lockHeartbeat  = 10 * time.Second
+	lockStaleAfter = 30 * time.Second
+)
+
+// ErrDataDirLocked is returned by LockDataDir when another live instance
+// holds the lock.
+var ErrDataDirLocked = errors.New("data directory is in use by another instance")
+
+// LockInfo identifies the holder of a data directory lock.
+type LockInfo struct {
+	Owner   string    `json:"owner"` // e.g. "server", "ingest"
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	Token   string    `json:"token"`
+}
+
+// DataDirLock is a held data directory lock.
+type DataDirLock struct {
+	path string
+	info LockInfo
+	stop chan struct{}
+	once sync.Once
+}
+
+// LockDataDir takes the lock on dir for owner, failing with
+// ErrDataDirLocked while another instance holds it. Release it on exit.
+func LockDataDir(dir, owner string) (*DataDirLock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data dir: %w", err)
+	}
+	host, _ := os.Hostname()
+	l := &DataDirLock{
+		path: filepath.Join(dir, lockFileName),
+		info: LockInfo{Owner: owner, PID: os.Getpid(), Host: host, Started: time.Now(), Token: generateUUID()},
+		stop: make(chan struct{}),
+	}
+	data, _ := json.Marshal(l.info)
+
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(l.path)
+				return nil, fmt.Errorf("failed to write %s: %w", l.path, err)
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create %s: %w", l.path, err)
+		}
+
+		holder, stale := readLock(l.path)
+		if !stale || attempt > 0 {
+			return nil, fmt.Errorf("%w: %s (pid %d on %s, since %s); stop it or wait %s after it exits",
+				ErrDataDirLocked, holder.Owner, holder.PID, holder.Host,
+				holder.Started.Format(time.RFC3339), lockStaleAfter)
+		}
+		log.Printf("Taking over stale lock on %s from %s (pid %d on %s)", dir, holder.Owner, holder.PID, holder.Host)
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale %s: %w", l.path, err)
+		}
+	}
+
+	go l.heartbeat()
+	return l, nil
+}
+
+// readLock reads a lock file, reporting it stale when its holder stopped
+// touching it. An unreadable lock file counts as stale once it is old.
+func readLock(path string) (LockInfo, bool) {
+	var info LockInfo
+	fi, err := os.Stat(path)
+	if err != nil {
+		return info, true
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(data, &info)
+	}
+	return info, time.Since(fi.ModTime()) > lockStaleAfter
+}
+
+// heartbeat keeps the lock fresh until Release, warning if another
+// instance took it over while this one was stalled.
+func (l *DataDirLock) heartbeat() {
+	ticker := time.NewTicker(lockHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if holder, _ := readLock(l.path); holder.Token != l.info.Token {
+				log.Printf("WARNING: lost the lock on %s to %s (pid %d on %s); two instances may now be writing to it",
+					filepath.Dir(l.path), holder.Owner, holder.PID, holder.Host)
+				return
+			}
+			now := time.Now()
+			_ = os.Chtimes(l.path, now, now)
+		}
+	}
+}
+
+// Release gives up the lock. It is safe to call more than once.
+func (l *DataDirLock) Release() {
+	l.once.Do(func() {
+		close(l.stop)
+		if holder, _ := readLock(l.path); holder.Token == l.info.Token {
+			_ = os.Remove(l.path)
+		}
+	})
+}
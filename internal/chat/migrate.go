@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ==================== Schema Migrations ====================
+
+// ProjectSchemaVersion is the layout of a project's metadata and files this
+// build writes, recorded as each project's SchemaVersion. A project from an
+// older version is upgraded the first time it is used (see Get), so
+// upgrading the server never needs a manual step:
+//
+//	0: before versions were recorded
+//	1: every conversation's metadata records when it was last active
+//
+// The project's vectors carry a format version of their own, upgraded as
+// they load (see indexer.VectorsVersion).
+const ProjectSchemaVersion = 1
+
+// projectMigrations[v] upgrades a project's files from version v to v+1.
+// Each must be safe to run again: an older build saving projects.json drops
+// the version, and the project is upgraded once more.
+var projectMigrations = []func(s *ProjectStore, id string) error{
+	migrateConversationTimes,
+}
+
+// migrate upgrades a project recorded at version to ProjectSchemaVersion,
+// unless another call already has.
+func (s *ProjectStore) migrate(id string, version int) error {
+	if version >= ProjectSchemaVersion {
+		return nil
+	}
+	unlock := s.lockKey("migrate/" + id)
+	defer unlock()
+
+	s.mu.RLock()
+	for _, p := range s.projects {
+		if p.ID == id {
+			version = p.SchemaVersion
+		}
+	}
+	s.mu.RUnlock()
+	return s.runMigrations(id, version)
+}
+
+// runMigrations upgrades a project's files from version and records the
+// project at ProjectSchemaVersion.
+func (s *ProjectStore) runMigrations(id string, version int) error {
+	if version >= ProjectSchemaVersion {
+		return nil
+	}
+	for v := version; v < ProjectSchemaVersion; v++ {
+		if err := projectMigrations[v](s, id); err != nil {
+			return fmt.Errorf("failed to upgrade project %s from schema version %d: %w", id, v, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.projects {
+		if s.projects[i].ID == id {
+			s.projects[i].SchemaVersion = ProjectSchemaVersion
+			if err := s.save(); err != nil {
+				return err
+			}
+			log.Printf("Upgraded project %s from schema version %d to %d", id, version, ProjectSchemaVersion)
+			return nil
+		}
+	}
+	return fmt.Errorf("project not found: %s", id)
+}
+
+// migrateConversationTimes (0 → 1) records the creation time as the last
+// activity of conversations saved before activity was tracked, as they
+// were read until then.
+func migrateConversationTimes(s *ProjectStore, id string) error {
+	entries, err := os.ReadDir(filepath.Join(s.ProjectDir(id), "conversations"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		convID, ok := strings.CutSuffix(e.Name(), ".meta.json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.ProjectDir(id), "conversations", e.Name()))
+		if err != nil {
+			return err
+		}
+		var stored struct {
+			UpdatedAt time.Time `json:"updated_at"`
+		}
+		if json.Unmarshal(data, &stored) != nil || !stored.UpdatedAt.IsZero() {
+			continue // unreadable conversations are skipped when listed too
+		}
+		// GetConversation fills in the creation time; writing it back keeps it
+		if _, err := s.modifyConversation(id, convID, func(*Conversation) {}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package chat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// ==================== Message Search ====================
+
+// Each project gets an in-memory bleve index over its stored messages. It is
+// built from disk the first time the project is searched and then kept in
+// step by the message write paths, so searches never rescan the files.
+
+// MessageHit is a stored message matching a conversation search.
+type MessageHit struct {
+	ProjectID        string    `json:"project_id"`
+	ConversationID   string    `json:"conversation_id"`
+	ConversationName string    `json:"conversation_name,omitempty"`
+	MessageID        string    `json:"message_id"`
+	Role             string    `json:"role"`
+	Snippet          string    `json:"snippet"` // matching fragment, terms wrapped in <mark>
+	Superseded       bool      `json:"superseded,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+	Score            float64   `json:"score"`
+}
+
+// messageDoc is what gets indexed for each message.
+type messageDoc struct {
+	ConversationID string    `json:"conversation_id"`
+	Role           string    `json:"role"`
+	Content        string    `json:"content"`
+	Superseded     bool      `json:"superseded"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+func messageDocID(convID, msgID string) string {
+	return convID + "/" + msgID
+}
+
+func newMessageIndex() (bleve.Index, error) {
+	m := bleve.NewIndexMapping()
+	doc := bleve.NewDocumentMapping()
+	keyword := bleve.NewKeywordFieldMapping()
+	doc.AddFieldMappingsAt("conversation_id", keyword)
+	doc.AddFieldMappingsAt("role", keyword)
+	doc.AddFieldMappingsAt("content", bleve.NewTextFieldMapping())
+	doc.AddFieldMappingsAt("superseded", bleve.NewBooleanFieldMapping())
+	doc.AddFieldMappingsAt("timestamp", bleve.NewDateTimeFieldMapping())
+	m.DefaultMapping = doc
+	return bleve.NewMemOnly(m)
+}
+
+// messageIndex returns the project's message index, building it from the
+// stored conversations on first use.
+func (s *ProjectStore) messageIndex(projectID string) (bleve.Index, error) {
+	s.msgIndexMu.Lock()
+	defer s.msgIndexMu.Unlock()
+
+	if idx, ok := s.msgIndexes[projectID]; ok {
+		return idx, nil
+	}
+	idx, err := newMessageIndex()
+	if err != nil {
+		return nil, err
+	}
+	batch := idx.NewBatch()
+	for _, conv := range s.ListConversations(projectID) {
+		msgs, err := s.LoadMessages(projectID, conv.ID)
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			_ = batch.Index(messageDocID(conv.ID, m.ID), toMessageDoc(conv.ID, m))
+		}
+	}
+	if err := idx.Batch(batch); err != nil {
+		idx.Close()
+		return nil, fmt.Errorf("failed to index messages: %w", err)
+	}
+	if s.msgIndexes == nil {
+		s.msgIndexes = make(map[string]bleve.Index)
+	}
+	s.msgIndexes[projectID] = idx
+	return idx, nil
+}
+
+func toMessageDoc(convID string, m Message) messageDoc {
+	return messageDoc{
+		ConversationID: convID,
+		Role:           m.Role,
+		Content:        m.Content,
+		Superseded:     m.Superseded(),
+		Timestamp:      m.Timestamp,
+	}
+}
+
+// builtMessageIndex returns the project's index only if a search already
+// built it; writes to projects nobody has searched don't need indexing.
+func (s *ProjectStore) builtMessageIndex(projectID string) bleve.Index {
+	s.msgIndexMu.Lock()
+	defer s.msgIndexMu.Unlock()
+	return s.msgIndexes[projectID]
+}
+
+// indexMessages adds or replaces messages in the project's index.
+func (s *ProjectStore) indexMessages(projectID, convID string, msgs ...Message) {
+	idx := s.builtMessageIndex(projectID)
+	if idx == nil {
+		return
+	}
+	batch := idx.NewBatch()
+	for _, m := range msgs {
+		_ = batch.Index(messageDocID(convID, m.ID), toMessageDoc(convID, m))
+	}
+	_ = idx.Batch(batch)
+}
+
+// unindexConversation removes a deleted conversation's messages.
+func (s *ProjectStore) unindexConversation(projectID, convID string, msgs []Message) {
+	idx := s.builtMessageIndex(projectID)
+	if idx == nil {
+		return
+	}
+	batch := idx.NewBatch()
+	for _, m := range msgs {
+		batch.Delete(messageDocID(convID, m.ID))
+	}
+	_ = idx.Batch(batch)
+}
+
+// dropMessageIndex discards a deleted project's index.
+func (s *ProjectStore) dropMessageIndex(projectID string) {
+	s.msgIndexMu.Lock()
+	defer s.msgIndexMu.Unlock()
+	if idx, ok := s.msgIndexes[projectID]; ok {
+		idx.Close()
+		delete(s.msgIndexes, projectID)
+	}
+}
+
+// MessageSearch narrows a conversation search.
+type MessageSearch struct {
+	Query             string
+	ConversationID    string // only this conversation
+	Role              string // "user" or "assistant"
+	IncludeSuperseded bool
+	Limit             int
+}
+
+// SearchMessages runs a keyword search over a project's messages and
+// returns the best matches along with the total number of matches.
+func (s *ProjectStore) SearchMessages(projectID string, opts MessageSearch) ([]MessageHit, int, error) {
+	if _, err := s.Get(projectID); err != nil {
+		return nil, 0, err
+	}
+	idx, err := s.messageIndex(projectID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	match := bleve.NewMatchQuery(opts.Query)
+	match.SetField("content")
+	clauses := []query.Query{match}
+	if opts.ConversationID != "" {
+		q := bleve.NewTermQuery(opts.ConversationID)
+		q.SetField("conversation_id")
+		clauses = append(clauses, q)
+	}
+	if opts.Role != "" {
+		q := bleve.NewTermQuery(opts.Role)
+		q.SetField("role")
+		clauses = append(clauses, q)
+	}
+	if !opts.IncludeSuperseded {
+		q := bleve.NewBoolFieldQuery(false)
+		q.SetField("superseded")
+		clauses = append(clauses, q)
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	req := bleve.NewSearchRequest(bleve.NewConjunctionQuery(clauses...))
+	req.Size = limit
+	req.Fields = []string{"role", "content", "superseded", "timestamp"}
+	req.Highlight = bleve.NewHighlightWithStyle("html")
+	req.Highlight.AddField("content")
+
+	res, err := idx.Search(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	names := map[string]string{}
+	for _, conv := range s.ListConversations(projectID) {
+		names[conv.ID] = conv.Name
+	}
+
+	hits := make([]MessageHit, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		convID, msgID, _ := strings.Cut(h.ID, "/")
+		hit := MessageHit{
+			ProjectID:        projectID,
+			ConversationID:   convID,
+			ConversationName: names[convID],
+			MessageID:        msgID,
+			Score:            h.Score,
+		}
+		hit.Role, _ = h.Fields["role"].(string)
+		hit.Superseded, _ = h.Fields["superseded"].(bool)
+		if ts, ok := h.Fields["timestamp"].(string); ok {
+			hit.Timestamp, _ = time.Parse(time.RFC3339, ts)
+		}
+		if frags := h.Fragments["content"]; len(frags) > 0 {
+			hit.Snippet = frags[0]
+		} else {
+			hit.Snippet, _ = h.Fields["content"].(string)
+		}
+		hits = append(hits, hit)
+	}
+	return hits, int(res.Total), nil
+}
+
+// SearchAllMessages searches every project in the store and merges the
+// best matches by score.
+func (s *ProjectStore) SearchAllMessages(opts MessageSearch) ([]MessageHit, int, error) {
+	opts.ConversationID = ""
+	var all []MessageHit
+	total := 0
+	for _, p := range s.List() {
+		hits, n, err := s.SearchMessages(p.ID, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		all = append(all, hits...)
+		total += n
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Score > all[j].Score })
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	return all, total, nil
+}
@@ -3,11 +3,15 @@ package chat
 import (
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/blevesearch/bleve/v2"
 )
 
 // ==================== Project ====================
@@ -20,14 +24,56 @@ type Project struct {
 	FileCount  int       `json:"file_count"`
 	ChunkCount int       `json:"chunk_count"`
 	Status     string    `json:"status"` // "upload", "processing", "ready"
+	Workspace  string    `json:"workspace_id,omitempty"`
+
+	SchemaVersion int `json:"schema_version,omitempty"` // see ProjectSchemaVersion
 
 	// Community fields
 	Description  string     `json:"description,omitempty"`
 	Tags         []string   `json:"tags,omitempty"`
 	SystemPrompt string     `json:"system_prompt,omitempty"`
+	BasePrompt   string     `json:"base_prompt,omitempty"` // replaces the built-in answering instructions
 	Author       string     `json:"author,omitempty"`
 	Published    bool       `json:"published,omitempty"`
 	PublishedAt  *time.Time `json:"published_at,omitempty"`
+
+	// Usage and limits
+	Quotas     ProjectQuotas    `json:"quotas"`
+	TokenUsage map[string]int64 `json:"token_usage,omitempty"`  // LLM tokens used, keyed by month ("2006-01")
+	LastUsedAt *time.Time       `json:"last_used_at,omitempty"` // when last activated, see MarkUsed
+
+	// Privacy
+	PIIMode       string `json:"pii_mode,omitempty"`       // "tag" or "mask" personal identifiers at ingest; "" leaves them
+	RedactAnswers bool   `json:"redact_answers,omitempty"` // mask personal identifiers in every answer
+
+	// OCR noise
+	Fuzziness  int  `json:"fuzziness,omitempty"`   // edits (1 or 2) a keyword may be off by and still match, for OCR'd documents
+	OCRCorrect bool `json:"ocr_correct,omitempty"` // correct misread words in OCR'd pages at ingest
+	// MinOCRConfidence ranks chunks OCR read with less confidence (0 to 1)
+	// lower in hybrid search; 0 leaves them be.
+	MinOCRConfidence float64 `json:"min_ocr_confidence,omitempty"`
+
+	// DocumentTags are user-assigned tags by document filename, used to
+	// scope queries to a tagged subset.
+	DocumentTags map[string][]string `json:"document_tags,omitempty"`
+}
+
+// ProjectQuotas caps a project's resource use. Zero means no limit.
+type ProjectQuotas struct {
+	MaxFiles           int   `json:"max_files,omitempty"`
+	MaxUploadBytes     int64 `json:"max_upload_bytes,omitempty"`
+	MaxChunks          int   `json:"max_chunks,omitempty"`
+	MonthlyTokenBudget int64 `json:"monthly_token_budget,omitempty"`
+}
+
+// usageMonth is the TokenUsage key for t.
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// TokensThisMonth returns the LLM tokens recorded for the current month.
+func (p *Project) TokensThisMonth() int64 {
+	return p.TokenUsage[usageMonth(time.Now())]
 }
 
 // ==================== Conversation ====================
@@ -38,14 +84,47 @@ type Conversation struct {
 	ProjectID string    `json:"project_id"`
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`       // last message activity
+	Pinned    bool      `json:"pinned,omitempty"` // listed ahead of unpinned conversations
 }
 
 // Message represents a single message in a conversation.
 type Message struct {
-	Role      string                 `json:"role"` // "user" or "assistant"
-	Content   string                 `json:"content"`
-	Metadata  map[string]interface{} `json:"metadata,omitempty"` // answer data for assistant messages
-	Timestamp time.Time              `json:"timestamp"`
+	ID              string                 `json:"id,omitempty"`
+	Role            string                 `json:"role"` // "user" or "assistant"
+	Content         string                 `json:"content"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`         // answer data for assistant messages
+	Feedback        *MessageFeedback       `json:"feedback,omitempty"`         // assistant messages only
+	RegeneratedFrom string                 `json:"regenerated_from,omitempty"` // ID of the answer this one replaces
+	EditOf          string                 `json:"edit_of,omitempty"`          // ID of the user message this edits
+	SupersededBy    string                 `json:"superseded_by,omitempty"`    // ID of the edit that invalidated this message
+	StarredAt       *time.Time             `json:"starred_at,omitempty"`       // set while the answer is starred
+	Timestamp       time.Time              `json:"timestamp"`
+}
+
+// Superseded reports whether a later edit invalidated this message. Superseded
+// messages stay in the conversation for audit but are left out of history.
+func (m Message) Superseded() bool {
+	return m.SupersededBy != ""
+}
+
+// MessageFeedback is a user's rating of an assistant answer.
+type MessageFeedback struct {
+	Rating           string    `json:"rating"` // "up" or "down"
+	Reason           string    `json:"reason,omitempty"`
+	CitationsCorrect *bool     `json:"citations_correct,omitempty"` // nil if the user didn't say
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// FeedbackSummary aggregates answer feedback across a project's conversations.
+type FeedbackSummary struct {
+	Rated              int      `json:"rated"`
+	Up                 int      `json:"up"`
+	Down               int      `json:"down"`
+	SatisfactionRate   float64  `json:"satisfaction_rate"` // up / rated
+	CitationsCorrect   int      `json:"citations_correct"`
+	CitationsIncorrect int      `json:"citations_incorrect"`
+	RecentReasons      []string `json:"recent_reasons,omitempty"` // newest first, from thumbs-down ratings
 }
 
 // ==================== ProjectStore ====================
@@ -54,8 +133,19 @@ type Message struct {
 type ProjectStore struct {
 	mu       sync.RWMutex
 	projects []Project
-	dataDir  string // e.g. "data/projects"
-	filePath string // e.g. "data/projects/projects.json"
+	dataDir  string      // e.g. "data/projects"
+	filePath string      // e.g. "data/projects/projects.json"
+	onDisk   os.FileInfo // projects.json as last loaded or saved, to detect other writers
+
+	workspace string // the workspace whose projects these are, see SetWorkspace
+
+	fetch   func(dir string) error // brings a project directory from durable storage, see SetFetch
+	fetched sync.Map               // project ID -> true once fetched
+
+	convLocks sync.Map // "projectID/convID[.meta]" -> *sync.Mutex serializing file writes
+
+	msgIndexMu sync.Mutex
+	msgIndexes map[string]bleve.Index // message search indexes by project ID, built on first search
 }
 
 // NewProjectStore initialises the store, creating directories and loading any existing projects.
@@ -70,9 +160,7 @@ func NewProjectStore(dataDir string) (*ProjectStore, error) {
 	}
 
 	// Load existing projects
-	if data, err := os.ReadFile(store.filePath); err == nil {
-		_ = json.Unmarshal(data, &store.projects)
-	}
+	store.load()
 
 	// Migrate: try loading legacy sessions.json
 	if len(store.projects) == 0 {
@@ -88,12 +176,82 @@ func NewProjectStore(dataDir string) (*ProjectStore, error) {
 	return store, nil
 }
 
+// SetWorkspace makes the store a workspace's: the projects it creates belong
+// to the workspace with id.
+func (s *ProjectStore) SetWorkspace(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workspace = id
+}
+
+// SetFetch has the store call fetch with a project's directory before the
+// project is first used, to bring its files from durable storage when the
+// local data directory is only a working copy. A project whose fetch fails
+// is fetched again the next time.
+func (s *ProjectStore) SetFetch(fetch func(dir string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetch = fetch
+}
+
+// Fetch brings the project's files from durable storage if SetFetch was
+// called, once. Get calls it, so a project's files are in place once it has
+// been looked up.
+func (s *ProjectStore) Fetch(id string) error {
+	s.mu.RLock()
+	fetch := s.fetch
+	s.mu.RUnlock()
+	if fetch == nil {
+		return nil
+	}
+	if _, ok := s.fetched.Load(id); ok {
+		return nil
+	}
+	unlock := s.lockKey("fetch/" + id)
+	defer unlock()
+	if _, ok := s.fetched.Load(id); ok {
+		return nil
+	}
+	if err := fetch(s.ProjectDir(id)); err != nil {
+		return fmt.Errorf("failed to fetch the files of project %s: %w", id, err)
+	}
+	s.fetched.Store(id, true)
+	return nil
+}
+
+// ErrConcurrentModification is returned when projects.json was changed by
+// another process since this store last read it. The store reloads it, so
+// the rejected change can be retried against the other writer's version.
+var ErrConcurrentModification = errors.New("projects were changed by another process")
+
+// load reads projects.json, if any, into the store.
+func (s *ProjectStore) load() {
+	s.projects = nil
+	s.onDisk = nil
+	if data, err := os.ReadFile(s.filePath); err == nil {
+		_ = json.Unmarshal(data, &s.projects)
+	}
+	s.onDisk, _ = os.Stat(s.filePath)
+}
+
+// save writes projects.json, refusing to overwrite another process's changes.
 func (s *ProjectStore) save() error {
+	current, err := os.Stat(s.filePath)
+	changed := err == nil && (s.onDisk == nil || !current.ModTime().Equal(s.onDisk.ModTime()) || current.Size() != s.onDisk.Size())
+	if changed {
+		s.load()
+		return fmt.Errorf("%w; reloaded %s, try again", ErrConcurrentModification, s.filePath)
+	}
+
 	data, err := json.MarshalIndent(s.projects, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.filePath, data, 0644)
+	if err := writeFileAtomic(s.filePath, data, 0644); err != nil {
+		return err
+	}
+	s.onDisk, _ = os.Stat(s.filePath)
+	return nil
 }
 
 // ==================== Project CRUD ====================
@@ -108,10 +266,12 @@ func (s *ProjectStore) Create(name string) (*Project, error) {
 	}
 
 	project := Project{
-		ID:        id,
-		Name:      name,
-		CreatedAt: time.Now(),
-		Status:    "upload",
+		ID:            id,
+		Name:          name,
+		CreatedAt:     time.Now(),
+		Status:        "upload",
+		Workspace:     s.workspace,
+		SchemaVersion: ProjectSchemaVersion,
 	}
 
 	// Create per-project directories
@@ -145,23 +305,42 @@ func (s *ProjectStore) List() []Project {
 
 func (s *ProjectStore) Get(id string) (*Project, error) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
+	var found *Project
 	for i := range s.projects {
 		if s.projects[i].ID == id {
 			p := s.projects[i]
-			return &p, nil
+			found = &p
+			break
 		}
 	}
-	return nil, fmt.Errorf("project not found: %s", id)
+	s.mu.RUnlock()
+
+	if found == nil {
+		return nil, fmt.Errorf("project not found: %s", id)
+	}
+	if err := s.Fetch(id); err != nil {
+		return nil, err
+	}
+	if err := s.migrate(id, found.SchemaVersion); err != nil {
+		return nil, err
+	}
+	found.SchemaVersion = max(found.SchemaVersion, ProjectSchemaVersion)
+	return found, nil
 }
 
+// Update replaces a project's fields. TokenUsage and LastUsedAt are owned by
+// AddTokenUsage and MarkUsed, Workspace and SchemaVersion by the store; they
+// are kept as stored, so a stale copy from Get cannot roll them back.
 func (s *ProjectStore) Update(project Project) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	for i := range s.projects {
 		if s.projects[i].ID == project.ID {
+			project.TokenUsage = s.projects[i].TokenUsage
+			project.LastUsedAt = s.projects[i].LastUsedAt
+			project.Workspace = s.projects[i].Workspace
+			project.SchemaVersion = s.projects[i].SchemaVersion
 			s.projects[i] = project
 			return s.save()
 		}
@@ -169,6 +348,44 @@ func (s *ProjectStore) Update(project Project) error {
 	return fmt.Errorf("project not found: %s", project.ID)
 }
 
+// AddTokenUsage adds tokens to the project's count for the current month.
+// The read-modify-write happens under the store lock so concurrent queries
+// never lose each other's updates.
+func (s *ProjectStore) AddTokenUsage(id string, tokens int64) error {
+	if tokens <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.projects {
+		if s.projects[i].ID == id {
+			if s.projects[i].TokenUsage == nil {
+				s.projects[i].TokenUsage = make(map[string]int64)
+			}
+			s.projects[i].TokenUsage[usageMonth(time.Now())] += tokens
+			return s.save()
+		}
+	}
+	return fmt.Errorf("project not found: %s", id)
+}
+
+// MarkUsed records that the project was just opened, for picking the
+// recently used projects whose indexes the server pre-loads.
+func (s *ProjectStore) MarkUsed(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.projects {
+		if s.projects[i].ID == id {
+			now := time.Now()
+			s.projects[i].LastUsedAt = &now
+			return s.save()
+		}
+	}
+	return fmt.Errorf("project not found: %s", id)
+}
+
 func (s *ProjectStore) Delete(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -187,10 +404,13 @@ func (s *ProjectStore) Delete(id string) error {
 	}
 
 	s.projects = updated
+	if err := s.save(); err != nil {
+		return err
+	}
+	s.dropMessageIndex(id)
 	projDir := filepath.Join(s.dataDir, id)
 	_ = os.RemoveAll(projDir)
-
-	return s.save()
+	return nil
 }
 
 // ==================== Community ====================
@@ -244,6 +464,9 @@ func (s *ProjectStore) cloneFrom(source *Project, srcUploadsDir, newName, author
 	newProj.Description = source.Description
 	newProj.Tags = source.Tags
 	newProj.SystemPrompt = source.SystemPrompt
+	newProj.BasePrompt = source.BasePrompt
+	newProj.PIIMode = source.PIIMode
+	newProj.RedactAnswers = source.RedactAnswers
 	if author != "" {
 		newProj.Author = author
 	}
@@ -292,11 +515,13 @@ func (s *ProjectStore) CreateConversation(projectID, name string) (*Conversation
 		name = "Chat " + id[:8]
 	}
 
+	now := time.Now()
 	conv := Conversation{
 		ID:        id,
 		ProjectID: projectID,
 		Name:      name,
-		CreatedAt: time.Now(),
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 
 	// Save conversation metadata
@@ -339,9 +564,19 @@ func (s *ProjectStore) ListConversations(projectID string) []Conversation {
 		}
 		var conv Conversation
 		if err := json.Unmarshal(data, &conv); err == nil {
+			if conv.UpdatedAt.IsZero() {
+				conv.UpdatedAt = conv.CreatedAt
+			}
 			convs = append(convs, conv)
 		}
 	}
+	// Pinned first, then most recently active
+	sort.SliceStable(convs, func(i, j int) bool {
+		if convs[i].Pinned != convs[j].Pinned {
+			return convs[i].Pinned
+		}
+		return convs[i].UpdatedAt.After(convs[j].UpdatedAt)
+	})
 	return convs
 }
 
@@ -355,16 +590,62 @@ func (s *ProjectStore) GetConversation(projectID, convID string) (*Conversation,
 	if err := json.Unmarshal(data, &conv); err != nil {
 		return nil, err
 	}
+	if conv.UpdatedAt.IsZero() {
+		conv.UpdatedAt = conv.CreatedAt
+	}
 	return &conv, nil
 }
 
 func (s *ProjectStore) UpdateConversation(conv Conversation) error {
+	unlock := s.lockConversationMeta(conv.ProjectID, conv.ID)
+	defer unlock()
+	return s.writeConversation(conv)
+}
+
+func (s *ProjectStore) writeConversation(conv Conversation) error {
 	metaPath := filepath.Join(s.dataDir, conv.ProjectID, "conversations", conv.ID+".meta.json")
 	data, _ := json.MarshalIndent(conv, "", "  ")
-	return os.WriteFile(metaPath, data, 0644)
+	return writeFileAtomic(metaPath, data, 0644)
+}
+
+// modifyConversation applies fn to a conversation's metadata and saves it,
+// holding the metadata lock across the read and the write.
+func (s *ProjectStore) modifyConversation(projectID, convID string, fn func(*Conversation)) (*Conversation, error) {
+	unlock := s.lockConversationMeta(projectID, convID)
+	defer unlock()
+
+	conv, err := s.GetConversation(projectID, convID)
+	if err != nil {
+		return nil, err
+	}
+	fn(conv)
+	if err := s.writeConversation(*conv); err != nil {
+		return nil, err
+	}
+	return conv, nil
+}
+
+// SetConversationPinned pins or unpins a conversation.
+func (s *ProjectStore) SetConversationPinned(projectID, convID string, pinned bool) (*Conversation, error) {
+	return s.modifyConversation(projectID, convID, func(c *Conversation) { c.Pinned = pinned })
+}
+
+// touchConversation records message activity at t.
+func (s *ProjectStore) touchConversation(projectID, convID string, t time.Time) {
+	_, _ = s.modifyConversation(projectID, convID, func(c *Conversation) {
+		if t.After(c.UpdatedAt) {
+			c.UpdatedAt = t
+		}
+	})
 }
 
 func (s *ProjectStore) DeleteConversation(projectID, convID string) error {
+	unlock := s.lockConversation(projectID, convID)
+	defer unlock()
+
+	if msgs, err := s.LoadMessages(projectID, convID); err == nil {
+		s.unindexConversation(projectID, convID, msgs)
+	}
 	convDir := filepath.Join(s.dataDir, projectID, "conversations")
 	_ = os.Remove(filepath.Join(convDir, convID+".meta.json"))
 	_ = os.Remove(filepath.Join(convDir, convID+".json"))
@@ -383,13 +664,245 @@ func (s *ProjectStore) LoadMessages(projectID, convID string) ([]Message, error)
 	if err := json.Unmarshal(data, &msgs); err != nil {
 		return nil, err
 	}
+	// Messages saved before IDs existed get their position as a stable ID;
+	// messages are only ever appended, so positions don't shift.
+	for i := range msgs {
+		if msgs[i].ID == "" {
+			msgs[i].ID = fmt.Sprintf("msg-%d", i)
+		}
+	}
 	return msgs, nil
 }
 
 func (s *ProjectStore) SaveMessage(projectID, convID string, msg Message) error {
+	return s.SaveMessages(projectID, convID, msg)
+}
+
+// SaveMessages appends messages to a conversation in one write, so a
+// question and its answer always land next to each other.
+func (s *ProjectStore) SaveMessages(projectID, convID string, newMsgs ...Message) error {
+	unlock := s.lockConversation(projectID, convID)
+	defer unlock()
+
 	msgs, _ := s.LoadMessages(projectID, convID)
-	msgs = append(msgs, msg)
-	return s.saveMessages(projectID, convID, msgs)
+	for _, msg := range newMsgs {
+		if msg.ID == "" {
+			msg.ID = generateUUID()
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := s.saveMessages(projectID, convID, msgs); err != nil {
+		return err
+	}
+	s.touchConversation(projectID, convID, time.Now())
+	return nil
+}
+
+// lockConversation serializes read-modify-write cycles on one
+// conversation's messages file. Call the returned func to unlock.
+func (s *ProjectStore) lockConversation(projectID, convID string) func() {
+	return s.lockKey(projectID + "/" + convID)
+}
+
+// lockConversationMeta does the same for the conversation's metadata file.
+// It is a separate lock so message writes can update activity times.
+func (s *ProjectStore) lockConversationMeta(projectID, convID string) func() {
+	return s.lockKey(projectID + "/" + convID + ".meta")
+}
+
+func (s *ProjectStore) lockKey(key string) func() {
+	v, _ := s.convLocks.LoadOrStore(key, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// EditMessage replaces a user message with edited content. The original and
+// every message after it are marked superseded rather than removed, and the
+// edit is appended as a new user message, which is returned.
+func (s *ProjectStore) EditMessage(projectID, convID, msgID, content string) (*Message, error) {
+	unlock := s.lockConversation(projectID, convID)
+	defer unlock()
+
+	msgs, err := s.LoadMessages(projectID, convID)
+	if err != nil {
+		return nil, fmt.Errorf("conversation not found: %s", convID)
+	}
+	target := -1
+	for i := range msgs {
+		if msgs[i].ID == msgID {
+			target = i
+			break
+		}
+	}
+	if target < 0 {
+		return nil, fmt.Errorf("message not found: %s", msgID)
+	}
+	if msgs[target].Role != "user" {
+		return nil, ErrNotUserMessage
+	}
+	if msgs[target].Superseded() {
+		return nil, fmt.Errorf("%w: %s by %s", ErrMessageSuperseded, msgID, msgs[target].SupersededBy)
+	}
+
+	edit := Message{
+		ID:        generateUUID(),
+		Role:      "user",
+		Content:   content,
+		EditOf:    msgID,
+		Timestamp: time.Now(),
+	}
+	for i := target; i < len(msgs); i++ {
+		if !msgs[i].Superseded() {
+			msgs[i].SupersededBy = edit.ID
+		}
+	}
+	msgs = append(msgs, edit)
+	if err := s.saveMessages(projectID, convID, msgs); err != nil {
+		return nil, err
+	}
+	s.touchConversation(projectID, convID, edit.Timestamp)
+	return &edit, nil
+}
+
+// ErrNotUserMessage is returned when an edit targets an assistant message.
+var ErrNotUserMessage = errors.New("only user messages can be edited")
+
+// ErrMessageSuperseded is returned when an edit targets a message that an
+// earlier edit already replaced.
+var ErrMessageSuperseded = errors.New("message was already superseded")
+
+// ErrNotAssistantMessage is returned when feedback or a star targets a user
+// message.
+var ErrNotAssistantMessage = errors.New("only assistant messages can be rated or starred")
+
+// SetMessageFeedback attaches feedback to an assistant message, replacing any
+// earlier rating. A nil fb clears it.
+func (s *ProjectStore) SetMessageFeedback(projectID, convID, msgID string, fb *MessageFeedback) error {
+	return s.updateAssistantMessage(projectID, convID, msgID, func(m *Message) { m.Feedback = fb })
+}
+
+// SetMessageStarred adds an assistant message to the project's starred
+// answers, or removes it.
+func (s *ProjectStore) SetMessageStarred(projectID, convID, msgID string, starred bool) error {
+	return s.updateAssistantMessage(projectID, convID, msgID, func(m *Message) {
+		if !starred {
+			m.StarredAt = nil
+		} else if m.StarredAt == nil {
+			now := time.Now()
+			m.StarredAt = &now
+		}
+	})
+}
+
+// updateAssistantMessage applies fn to one assistant message and saves the
+// conversation.
+func (s *ProjectStore) updateAssistantMessage(projectID, convID, msgID string, fn func(*Message)) error {
+	unlock := s.lockConversation(projectID, convID)
+	defer unlock()
+
+	msgs, err := s.LoadMessages(projectID, convID)
+	if err != nil {
+		return fmt.Errorf("conversation not found: %s", convID)
+	}
+	for i := range msgs {
+		if msgs[i].ID != msgID {
+			continue
+		}
+		if msgs[i].Role != "assistant" {
+			return ErrNotAssistantMessage
+		}
+		fn(&msgs[i])
+		return s.saveMessages(projectID, convID, msgs)
+	}
+	return fmt.Errorf("message not found: %s", msgID)
+}
+
+// StarredAnswer is a starred assistant message with the question it answers.
+type StarredAnswer struct {
+	ConversationID   string  `json:"conversation_id"`
+	ConversationName string  `json:"conversation_name"`
+	Question         string  `json:"question,omitempty"`
+	Message          Message `json:"message"`
+}
+
+// StarredAnswers collects a project's starred answers, most recently starred
+// first.
+func (s *ProjectStore) StarredAnswers(projectID string) []StarredAnswer {
+	starred := []StarredAnswer{}
+	for _, conv := range s.ListConversations(projectID) {
+		msgs, err := s.LoadMessages(projectID, conv.ID)
+		if err != nil {
+			continue
+		}
+		question := ""
+		for _, m := range msgs {
+			if m.Role == "user" {
+				question = m.Content
+				continue
+			}
+			if m.StarredAt == nil {
+				continue
+			}
+			starred = append(starred, StarredAnswer{
+				ConversationID:   conv.ID,
+				ConversationName: conv.Name,
+				Question:         question,
+				Message:          m,
+			})
+		}
+	}
+	sort.SliceStable(starred, func(i, j int) bool {
+		return starred[i].Message.StarredAt.After(*starred[j].Message.StarredAt)
+	})
+	return starred
+}
+
+// ProjectFeedback aggregates the feedback on every conversation in a project.
+func (s *ProjectStore) ProjectFeedback(projectID string) FeedbackSummary {
+	var sum FeedbackSummary
+	type reason struct {
+		text string
+		at   time.Time
+	}
+	var reasons []reason
+	for _, conv := range s.ListConversations(projectID) {
+		msgs, err := s.LoadMessages(projectID, conv.ID)
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			fb := m.Feedback
+			if fb == nil {
+				continue
+			}
+			sum.Rated++
+			switch fb.Rating {
+			case "up":
+				sum.Up++
+			case "down":
+				sum.Down++
+				if fb.Reason != "" {
+					reasons = append(reasons, reason{fb.Reason, fb.UpdatedAt})
+				}
+			}
+			if fb.CitationsCorrect != nil {
+				if *fb.CitationsCorrect {
+					sum.CitationsCorrect++
+				} else {
+					sum.CitationsIncorrect++
+				}
+			}
+		}
+	}
+	if sum.Rated > 0 {
+		sum.SatisfactionRate = float64(sum.Up) / float64(sum.Rated)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i].at.After(reasons[j].at) })
+	for i := 0; i < len(reasons) && i < 10; i++ {
+		sum.RecentReasons = append(sum.RecentReasons, reasons[i].text)
+	}
+	return sum
 }
 
 func (s *ProjectStore) saveMessages(projectID, convID string, msgs []Message) error {
@@ -398,7 +911,50 @@ func (s *ProjectStore) saveMessages(projectID, convID string, msgs []Message) er
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(msgsPath, data, 0644)
+	if err := writeFileAtomic(msgsPath, data, 0644); err != nil {
+		return err
+	}
+	s.indexMessages(projectID, convID, msgs...)
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file beside path, fsyncs it and
+// renames it into place, so a crash mid-write leaves the old file intact
+// rather than a truncated one.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// Persist the rename itself; not every platform can fsync a directory
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
 }
 
 // ==================== Path Helpers ====================
@@ -423,6 +979,16 @@ func (s *ProjectStore) ChunksDir(id string) string {
 	return filepath.Join(s.dataDir, id, "chunks")
 }
 
+func (s *ProjectStore) GraphPath(id string) string {
+	return filepath.Join(s.dataDir, id, "graph.json")
+}
+
+// KeysPath is where the project's own provider keys are kept; the server
+// encrypts them, and archives leave them out.
+func (s *ProjectStore) KeysPath(id string) string {
+	return filepath.Join(s.dataDir, id, projectKeysFile)
+}
+
 // ==================== UUID ====================
 
 func generateUUID() string {
@@ -1,6 +1,9 @@
 package chat
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"sync"
@@ -90,6 +93,69 @@ func TestGetProject_NotFound(t *testing.T) {
 	}
 }
 
+func TestGetProject_FetchesOnce(t *testing.T) {
+	store, _ := tempStore(t)
+	proj, _ := store.Create("Remote")
+
+	var fetched []string
+	fail := true
+	store.SetFetch(func(dir string) error {
+		fetched = append(fetched, dir)
+		if fail {
+			return errors.New("bucket unreachable")
+		}
+		return nil
+	})
+	if _, err := store.Get(proj.ID); err == nil {
+		t.Fatal("expected the failed fetch to fail Get")
+	}
+	fail = false
+	for i := 0; i < 3; i++ {
+		if _, err := store.Get(proj.ID); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+	}
+	if len(fetched) != 2 || fetched[1] != store.ProjectDir(proj.ID) {
+		t.Errorf("fetched %v, want the project's directory twice (failed, then once)", fetched)
+	}
+}
+
+func TestGetProject_MigratesOlderSchema(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "projects")
+	created := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	_ = os.MkdirAll(filepath.Join(dir, "p1", "conversations"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "projects.json"), []byte(`[{"id":"p1","name":"Old","status":"ready"}]`), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "p1", "conversations", "c1.meta.json"),
+		[]byte(`{"id":"c1","project_id":"p1","name":"Chat","created_at":"2024-05-01T09:00:00Z"}`), 0644)
+
+	store, err := NewProjectStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := store.Get("p1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if p.SchemaVersion != ProjectSchemaVersion {
+		t.Errorf("SchemaVersion = %d, want %d", p.SchemaVersion, ProjectSchemaVersion)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "p1", "conversations", "c1.meta.json"))
+	var conv Conversation
+	if err := json.Unmarshal(data, &conv); err != nil || !conv.UpdatedAt.Equal(created) {
+		t.Errorf("stored updated_at = %v, want the creation time (%v)", conv.UpdatedAt, err)
+	}
+
+	// Recorded, and kept by an update from a copy that predates it
+	_ = store.Update(Project{ID: "p1", Name: "Renamed"})
+	reopened, _ := NewProjectStore(dir)
+	if got := reopened.List()[0]; got.SchemaVersion != ProjectSchemaVersion || got.Name != "Renamed" {
+		t.Errorf("stored project = %+v", got)
+	}
+	if created, _ := store.Create("New"); created.SchemaVersion != ProjectSchemaVersion {
+		t.Errorf("new project at schema version %d", created.SchemaVersion)
+	}
+}
+
 func TestUpdateProject(t *testing.T) {
 	store, _ := tempStore(t)
 	proj, _ := store.Create("Original")
@@ -113,6 +179,58 @@ func TestUpdateProject(t *testing.T) {
 	}
 }
 
+func TestAddTokenUsage(t *testing.T) {
+	store, _ := tempStore(t)
+	proj, _ := store.Create("Usage")
+
+	// A stale copy taken before usage is recorded must not reset it on Update
+	stale, _ := store.Get(proj.ID)
+
+	if err := store.AddTokenUsage(proj.ID, 100); err != nil {
+		t.Fatalf("AddTokenUsage failed: %v", err)
+	}
+	_ = store.AddTokenUsage(proj.ID, 50)
+
+	stale.Name = "Renamed"
+	if err := store.Update(*stale); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	got, _ := store.Get(proj.ID)
+	if got.TokensThisMonth() != 150 {
+		t.Errorf("tokens this month = %d, want 150", got.TokensThisMonth())
+	}
+	if got.Name != "Renamed" {
+		t.Errorf("name = %q, want 'Renamed'", got.Name)
+	}
+
+	if err := store.AddTokenUsage("missing", 10); err == nil {
+		t.Error("expected error for unknown project")
+	}
+}
+
+func TestMarkUsed(t *testing.T) {
+	store, _ := tempStore(t)
+	proj, _ := store.Create("Recent")
+	stale, _ := store.Get(proj.ID)
+
+	if err := store.MarkUsed(proj.ID); err != nil {
+		t.Fatalf("MarkUsed failed: %v", err)
+	}
+	stale.Name = "Renamed"
+	if err := store.Update(*stale); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	got, _ := store.Get(proj.ID)
+	if got.LastUsedAt == nil || time.Since(*got.LastUsedAt) > time.Minute {
+		t.Errorf("last used = %v, want just now", got.LastUsedAt)
+	}
+	if err := store.MarkUsed("missing"); err == nil {
+		t.Error("expected error for unknown project")
+	}
+}
+
 func TestDeleteProject(t *testing.T) {
 	store, _ := tempStore(t)
 	proj, _ := store.Create("To Delete")
@@ -176,6 +294,35 @@ func TestListConversations(t *testing.T) {
 	}
 }
 
+func TestListConversations_PinnedThenRecent(t *testing.T) {
+	store, _ := tempStore(t)
+	proj, _ := store.Create("Project")
+	a, _ := store.CreateConversation(proj.ID, "A")
+	_, _ = store.CreateConversation(proj.ID, "B")
+	c, _ := store.CreateConversation(proj.ID, "C")
+
+	time.Sleep(10 * time.Millisecond)
+	_ = store.SaveMessage(proj.ID, a.ID, Message{Role: "user", Content: "hi"})
+	if _, err := store.SetConversationPinned(proj.ID, c.ID, true); err != nil {
+		t.Fatalf("SetConversationPinned failed: %v", err)
+	}
+
+	convs := store.ListConversations(proj.ID)
+	var order []string
+	for _, conv := range convs {
+		order = append(order, conv.Name)
+	}
+	if len(order) != 3 || order[0] != "C" || order[1] != "A" || order[2] != "B" {
+		t.Errorf("order = %v, want [C A B]", order)
+	}
+	if !convs[1].UpdatedAt.After(convs[1].CreatedAt) {
+		t.Error("expected a message to bump updated_at")
+	}
+	if _, err := store.SetConversationPinned(proj.ID, "missing", true); err == nil {
+		t.Error("expected error pinning a missing conversation")
+	}
+}
+
 func TestDeleteConversation(t *testing.T) {
 	store, _ := tempStore(t)
 	proj, _ := store.Create("Project")
@@ -224,6 +371,209 @@ func TestSaveAndLoadMessages(t *testing.T) {
 	}
 }
 
+func TestSaveMessages_Concurrent(t *testing.T) {
+	store, _ := tempStore(t)
+	proj, _ := store.Create("Project")
+	conv, _ := store.CreateConversation(proj.ID, "Busy")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = store.SaveMessages(proj.ID, conv.ID,
+				Message{Role: "user", Content: "Q"},
+				Message{Role: "assistant", Content: "A"})
+		}()
+	}
+	wg.Wait()
+
+	msgs, err := store.LoadMessages(proj.ID, conv.ID)
+	if err != nil {
+		t.Fatalf("LoadMessages failed: %v", err)
+	}
+	if len(msgs) != 40 {
+		t.Fatalf("expected 40 messages, got %d (writes were lost)", len(msgs))
+	}
+	for i := 0; i < len(msgs); i += 2 {
+		if msgs[i].Role != "user" || msgs[i+1].Role != "assistant" {
+			t.Fatalf("question/answer pair split at %d", i)
+		}
+	}
+
+	// No temp files left behind
+	entries, _ := os.ReadDir(filepath.Join(store.ProjectDir(proj.ID), "conversations"))
+	if len(entries) != 2 {
+		t.Errorf("expected only the meta and messages files, got %d entries", len(entries))
+	}
+}
+
+func TestSetMessageFeedback(t *testing.T) {
+	store, _ := tempStore(t)
+	proj, _ := store.Create("Project")
+	conv, _ := store.CreateConversation(proj.ID, "Conv")
+	_ = store.SaveMessage(proj.ID, conv.ID, Message{Role: "user", Content: "Q"})
+	_ = store.SaveMessage(proj.ID, conv.ID, Message{Role: "assistant", Content: "A"})
+
+	msgs, _ := store.LoadMessages(proj.ID, conv.ID)
+	if msgs[0].ID == "" || msgs[0].ID == msgs[1].ID {
+		t.Fatalf("messages need distinct IDs, got %q and %q", msgs[0].ID, msgs[1].ID)
+	}
+	if err := store.SetMessageFeedback(proj.ID, conv.ID, msgs[0].ID, &MessageFeedback{Rating: "up"}); err == nil {
+		t.Error("expected error rating a user message")
+	}
+
+	wrong := false
+	fb := &MessageFeedback{Rating: "down", Reason: "cited the wrong clause", CitationsCorrect: &wrong, UpdatedAt: time.Now()}
+	if err := store.SetMessageFeedback(proj.ID, conv.ID, msgs[1].ID, fb); err != nil {
+		t.Fatalf("SetMessageFeedback failed: %v", err)
+	}
+
+	sum := store.ProjectFeedback(proj.ID)
+	if sum.Rated != 1 || sum.Down != 1 || sum.CitationsIncorrect != 1 || sum.SatisfactionRate != 0 {
+		t.Errorf("summary = %+v", sum)
+	}
+	if len(sum.RecentReasons) != 1 || sum.RecentReasons[0] != "cited the wrong clause" {
+		t.Errorf("reasons = %v", sum.RecentReasons)
+	}
+
+	// Clearing removes it from the aggregate
+	_ = store.SetMessageFeedback(proj.ID, conv.ID, msgs[1].ID, nil)
+	if sum := store.ProjectFeedback(proj.ID); sum.Rated != 0 {
+		t.Errorf("rated after clear = %d, want 0", sum.Rated)
+	}
+}
+
+func TestStarredAnswers(t *testing.T) {
+	store, _ := tempStore(t)
+	proj, _ := store.Create("Project")
+	conv, _ := store.CreateConversation(proj.ID, "Findings")
+	_ = store.SaveMessages(proj.ID, conv.ID,
+		Message{Role: "user", Content: "Who are the parties?"},
+		Message{Role: "assistant", Content: "Acme and Beta."},
+		Message{Role: "user", Content: "When does it expire?"},
+		Message{Role: "assistant", Content: "In 2030."})
+	msgs, _ := store.LoadMessages(proj.ID, conv.ID)
+
+	if err := store.SetMessageStarred(proj.ID, conv.ID, msgs[0].ID, true); err != ErrNotAssistantMessage {
+		t.Errorf("starring a question: err = %v, want ErrNotAssistantMessage", err)
+	}
+	_ = store.SetMessageStarred(proj.ID, conv.ID, msgs[1].ID, true)
+	time.Sleep(5 * time.Millisecond)
+	_ = store.SetMessageStarred(proj.ID, conv.ID, msgs[3].ID, true)
+
+	starred := store.StarredAnswers(proj.ID)
+	if len(starred) != 2 {
+		t.Fatalf("expected 2 starred answers, got %d", len(starred))
+	}
+	if starred[0].Message.ID != msgs[3].ID || starred[0].Question != "When does it expire?" {
+		t.Errorf("newest star first: got %q for %q", starred[0].Message.Content, starred[0].Question)
+	}
+	if starred[1].ConversationName != "Findings" {
+		t.Errorf("conversation name = %q", starred[1].ConversationName)
+	}
+
+	_ = store.SetMessageStarred(proj.ID, conv.ID, msgs[1].ID, false)
+	if got := len(store.StarredAnswers(proj.ID)); got != 1 {
+		t.Errorf("expected 1 starred answer after unstarring, got %d", got)
+	}
+}
+
+func TestEditMessage_SupersedesDownstream(t *testing.T) {
+	store, _ := tempStore(t)
+	proj, _ := store.Create("Project")
+	conv, _ := store.CreateConversation(proj.ID, "Conv")
+	for _, m := range []Message{
+		{Role: "user", Content: "Q1"},
+		{Role: "assistant", Content: "A1"},
+		{Role: "user", Content: "Q2"},
+		{Role: "assistant", Content: "A2"},
+	} {
+		_ = store.SaveMessage(proj.ID, conv.ID, m)
+	}
+	msgs, _ := store.LoadMessages(proj.ID, conv.ID)
+
+	if _, err := store.EditMessage(proj.ID, conv.ID, msgs[1].ID, "x"); err != ErrNotUserMessage {
+		t.Errorf("editing an answer: err = %v, want ErrNotUserMessage", err)
+	}
+
+	edit, err := store.EditMessage(proj.ID, conv.ID, msgs[2].ID, "Q2 edited")
+	if err != nil {
+		t.Fatalf("EditMessage failed: %v", err)
+	}
+	if edit.EditOf != msgs[2].ID {
+		t.Errorf("edit_of = %q, want %q", edit.EditOf, msgs[2].ID)
+	}
+
+	msgs, _ = store.LoadMessages(proj.ID, conv.ID)
+	if len(msgs) != 5 {
+		t.Fatalf("expected original history kept plus the edit (5), got %d", len(msgs))
+	}
+	for i, want := range []bool{false, false, true, true, false} {
+		if msgs[i].Superseded() != want {
+			t.Errorf("msg %d (%s) superseded = %v, want %v", i, msgs[i].Content, msgs[i].Superseded(), want)
+		}
+	}
+
+	if _, err := store.EditMessage(proj.ID, conv.ID, msgs[2].ID, "again"); err == nil {
+		t.Error("expected error editing an already superseded message")
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	store, _ := tempStore(t)
+	proj, _ := store.Create("Project")
+	conv, _ := store.CreateConversation(proj.ID, "Leases")
+	_ = store.SaveMessage(proj.ID, conv.ID, Message{Role: "user", Content: "What is the termination notice period?"})
+	_ = store.SaveMessage(proj.ID, conv.ID, Message{Role: "assistant", Content: "Ninety days written notice."})
+
+	hits, total, err := store.SearchMessages(proj.ID, MessageSearch{Query: "notice"})
+	if err != nil {
+		t.Fatalf("SearchMessages failed: %v", err)
+	}
+	if total != 2 || len(hits) != 2 {
+		t.Fatalf("expected 2 hits, got %d (total %d)", len(hits), total)
+	}
+	if hits[0].ConversationID != conv.ID || hits[0].ConversationName != "Leases" {
+		t.Errorf("hit conversation = %q/%q", hits[0].ConversationID, hits[0].ConversationName)
+	}
+
+	// Writes after the index is built are searchable
+	_ = store.SaveMessage(proj.ID, conv.ID, Message{Role: "user", Content: "Who pays the rent escalation?", Timestamp: time.Now()})
+	if hits, _, _ = store.SearchMessages(proj.ID, MessageSearch{Query: "escalation"}); len(hits) != 1 {
+		t.Fatalf("expected new message to be indexed, got %d hits", len(hits))
+	}
+	if hits[0].Timestamp.IsZero() || hits[0].Role != "user" {
+		t.Errorf("hit missing fields: %+v", hits[0])
+	}
+
+	if hits, _, _ = store.SearchMessages(proj.ID, MessageSearch{Query: "notice", Role: "assistant"}); len(hits) != 1 {
+		t.Errorf("role filter: expected 1 hit, got %d", len(hits))
+	}
+
+	// Superseded messages are hidden unless asked for
+	msgs, _ := store.LoadMessages(proj.ID, conv.ID)
+	_, _ = store.EditMessage(proj.ID, conv.ID, msgs[2].ID, "Who pays for repairs?")
+	if hits, _, _ = store.SearchMessages(proj.ID, MessageSearch{Query: "escalation"}); len(hits) != 0 {
+		t.Errorf("expected superseded message hidden, got %d hits", len(hits))
+	}
+	if hits, _, _ = store.SearchMessages(proj.ID, MessageSearch{Query: "escalation", IncludeSuperseded: true}); len(hits) != 1 || !hits[0].Superseded {
+		t.Errorf("expected superseded hit when included, got %+v", hits)
+	}
+
+	other, _ := store.Create("Other")
+	otherConv, _ := store.CreateConversation(other.ID, "Misc")
+	_ = store.SaveMessage(other.ID, otherConv.ID, Message{Role: "user", Content: "Is notice required for renewal?"})
+	if hits, total, _ = store.SearchAllMessages(MessageSearch{Query: "notice"}); total != 3 {
+		t.Errorf("global search: expected 3 matches, got %d", total)
+	}
+
+	_ = store.DeleteConversation(proj.ID, conv.ID)
+	if hits, _, _ = store.SearchMessages(proj.ID, MessageSearch{Query: "notice"}); len(hits) != 0 {
+		t.Errorf("expected deleted conversation unindexed, got %d hits", len(hits))
+	}
+}
+
 func TestLoadMessages_NoMessages(t *testing.T) {
 	store, _ := tempStore(t)
 	proj, _ := store.Create("Project")
@@ -238,6 +588,163 @@ func TestLoadMessages_NoMessages(t *testing.T) {
 	}
 }
 
+// ========== Project Archives ==========
+
+func TestExportImportProject_RoundTrip(t *testing.T) {
+	store, _ := tempStore(t)
+	src, _ := store.Create("Case 42")
+	src.SystemPrompt = "Cite clause numbers."
+	src.ChunkCount = 7
+	src.Status = "ready"
+	_ = store.Update(*src)
+	_ = os.WriteFile(filepath.Join(store.UploadsDir(src.ID), "lease.pdf"), []byte("%PDF"), 0644)
+	_ = os.WriteFile(store.VectorsPath(src.ID), []byte(`{"chunks":[]}`), 0644)
+	_ = os.MkdirAll(filepath.Join(store.BM25Dir(src.ID), "store"), 0755)
+	_ = os.WriteFile(filepath.Join(store.BM25Dir(src.ID), "store", "root.bolt"), []byte("x"), 0644)
+	_ = os.WriteFile(store.KeysPath(src.ID), []byte(`{"openai_key":"enc"}`), 0644)
+	conv, _ := store.CreateConversation(src.ID, "Review")
+	_ = store.SaveMessage(src.ID, conv.ID, Message{Role: "user", Content: "hello", Timestamp: time.Now()})
+
+	archive := filepath.Join(t.TempDir(), "case.zip")
+	f, _ := os.Create(archive)
+	if err := store.ExportProject(src.ID, f); err != nil {
+		t.Fatalf("ExportProject: %v", err)
+	}
+	f.Close()
+
+	f, _ = os.Open(archive)
+	defer f.Close()
+	info, _ := f.Stat()
+	imported, err := store.ImportProject(f, info.Size(), "")
+	if err != nil {
+		t.Fatalf("ImportProject: %v", err)
+	}
+	if imported.ID == src.ID || imported.Name != "Case 42" || imported.SystemPrompt != "Cite clause numbers." {
+		t.Errorf("imported = %+v", imported)
+	}
+	if imported.FileCount != 1 || imported.ChunkCount != 7 || imported.Status != "ready" {
+		t.Errorf("files %d, chunks %d, status %q", imported.FileCount, imported.ChunkCount, imported.Status)
+	}
+	if _, err := os.Stat(store.BM25Dir(imported.ID)); !os.IsNotExist(err) {
+		t.Error("the keyword index should not be archived")
+	}
+	if _, err := os.Stat(store.KeysPath(imported.ID)); !os.IsNotExist(err) {
+		t.Error("the project's provider keys should not be archived")
+	}
+	convs := store.ListConversations(imported.ID)
+	if len(convs) != 1 || convs[0].ProjectID != imported.ID {
+		t.Fatalf("conversations = %+v", convs)
+	}
+	if msgs, _ := store.LoadMessages(imported.ID, convs[0].ID); len(msgs) != 1 || msgs[0].Content != "hello" {
+		t.Errorf("messages = %+v", msgs)
+	}
+}
+
+func TestImportProject_RejectsNonArchive(t *testing.T) {
+	store, _ := tempStore(t)
+	data := []byte("not a zip")
+	if _, err := store.ImportProject(bytes.NewReader(data), int64(len(data)), "x"); err == nil {
+		t.Error("expected an error")
+	}
+	if n := len(store.List()); n != 0 {
+		t.Errorf("%d projects created, want 0", n)
+	}
+}
+
+// ========== Workspaces ==========
+
+func TestWorkspaceStore_Membership(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workspaces.json")
+	store, err := NewWorkspaceStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := store.Create("Legal", "u1", "owner@example.com")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := store.SetMember(ws.ID, "", "Analyst@Example.com", RoleMember); err != nil {
+		t.Fatalf("SetMember failed: %v", err)
+	}
+
+	// A member added by email is found by it, in any case
+	if got := store.ForUser("u2", "analyst@example.com"); len(got) != 1 || got[0].ID != ws.ID {
+		t.Errorf("ForUser = %v, want the workspace", got)
+	}
+	if got := store.ForUser("u3", "other@example.com"); len(got) != 0 {
+		t.Errorf("ForUser for a stranger = %v, want none", got)
+	}
+	got, _ := store.Get(ws.ID)
+	if !got.CanManage("u1", "") || got.CanManage("", "analyst@example.com") {
+		t.Error("only the owner should manage the workspace")
+	}
+
+	if _, err := store.SetMember(ws.ID, "u1", "", RoleMember); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("demoting the last owner: got %v, want ErrLastOwner", err)
+	}
+	if _, err := store.RemoveMember(ws.ID, "u1", ""); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("removing the last owner: got %v, want ErrLastOwner", err)
+	}
+	if _, err := store.SetMember(ws.ID, "", "x@example.com", "superuser"); err == nil {
+		t.Error("SetMember accepted an invalid role")
+	}
+	if _, err := store.RemoveMember(ws.ID, "", "analyst@example.com"); err != nil {
+		t.Fatalf("RemoveMember failed: %v", err)
+	}
+	if _, err := store.SetQuotas(ws.ID, ProjectQuotas{MaxFiles: 10}); err != nil {
+		t.Fatalf("SetQuotas failed: %v", err)
+	}
+
+	// Everything survives a reload
+	reloaded, err := NewWorkspaceStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = reloaded.Get(ws.ID)
+	if err != nil {
+		t.Fatalf("Get after reload failed: %v", err)
+	}
+	if len(got.Members) != 1 || got.Quotas.MaxFiles != 10 {
+		t.Errorf("reloaded workspace = %+v", got)
+	}
+	if err := reloaded.Delete(ws.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := reloaded.Get(ws.ID); err == nil {
+		t.Error("workspace still there after Delete")
+	}
+}
+
+func TestWorkspaceStore_BindMember(t *testing.T) {
+	store, err := NewWorkspaceStore(filepath.Join(t.TempDir(), "workspaces.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := store.Create("Team", "u1", "owner@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetMember(ws.ID, "", "invited@example.com", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err = store.BindMember(ws.ID, "u2", "Invited@example.com")
+	if err != nil {
+		t.Fatalf("BindMember failed: %v", err)
+	}
+	if m := ws.Member("u2", ""); m == nil || m.Email != "invited@example.com" {
+		t.Errorf("after binding, u2 = %+v, want the invited member", m)
+	}
+	// Once bound, another account with the same email doesn't match
+	if m := ws.Member("u3", "invited@example.com"); m != nil {
+		t.Errorf("another account matched the bound invite: %+v", m)
+	}
+	// A lookup by email alone, as managing members does, still does
+	if m := ws.Member("", "invited@example.com"); m == nil || m.UID != "u2" {
+		t.Errorf("lookup by email = %+v, want the bound member", m)
+	}
+}
+
 // ========== Path Helpers ==========
 
 func TestPathHelpers(t *testing.T) {
@@ -303,3 +810,76 @@ func TestConcurrentProjectCreation(t *testing.T) {
 		t.Errorf("expected 10 projects after concurrent creation, got %d", got)
 	}
 }
+
+func TestSave_RejectsOtherWritersChanges(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewProjectStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewProjectStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Create("From A"); err != nil {
+		t.Fatalf("Create on A failed: %v", err)
+	}
+
+	// B's view predates A's write; saving it would drop A's project
+	if _, err := b.Create("From B"); !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("expected ErrConcurrentModification, got %v", err)
+	}
+	if got := len(b.List()); got != 1 {
+		t.Fatalf("expected B to reload A's project, got %d projects", got)
+	}
+	if _, err := b.Create("From B"); err != nil {
+		t.Fatalf("retry after reload failed: %v", err)
+	}
+
+	c, _ := NewProjectStore(dir)
+	if got := len(c.List()); got != 2 {
+		t.Errorf("expected both projects on disk, got %d", got)
+	}
+}
+
+// ========== Data Directory Lock ==========
+
+func TestLockDataDir_Exclusive(t *testing.T) {
+	dir := t.TempDir()
+	l, err := LockDataDir(dir, "server")
+	if err != nil {
+		t.Fatalf("LockDataDir failed: %v", err)
+	}
+	if _, err := LockDataDir(dir, "ingest"); !errors.Is(err, ErrDataDirLocked) {
+		t.Fatalf("expected ErrDataDirLocked, got %v", err)
+	}
+
+	l.Release()
+	l.Release()
+	l2, err := LockDataDir(dir, "ingest")
+	if err != nil {
+		t.Fatalf("LockDataDir after Release failed: %v", err)
+	}
+	l2.Release()
+}
+
+func TestLockDataDir_TakesOverStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, lockFileName)
+	if err := os.WriteFile(path, []byte(`{"owner":"server","pid":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockStaleAfter)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := LockDataDir(dir, "server")
+	if err != nil {
+		t.Fatalf("expected the stale lock to be taken over, got %v", err)
+	}
+	defer l.Release()
+	if holder, stale := readLock(path); stale || holder.Token != l.info.Token {
+		t.Errorf("lock file not rewritten: %+v (stale %v)", holder, stale)
+	}
+}
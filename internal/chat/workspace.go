@@ -0,0 +1,289 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== Workspaces ====================
+
+// Workspace is a team sharing a deployment: its members work on the same
+// projects, under the same provider keys and default quotas.
+type Workspace struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	CreatedAt time.Time         `json:"created_at"`
+	Members   []WorkspaceMember `json:"members"`
+
+	// Quotas are the limits of each of the workspace's projects that sets
+	// none of its own. Zero means no limit.
+	Quotas ProjectQuotas `json:"quotas"`
+}
+
+// WorkspaceMember is a user in a workspace, known by UID or by the email
+// they were invited with until they sign in with it (see BindMember).
+type WorkspaceMember struct {
+	UID     string    `json:"uid,omitempty"`
+	Email   string    `json:"email,omitempty"`
+	Role    string    `json:"role"` // see the Role constants
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Workspace roles. Owners and admins manage the workspace's members,
+// settings and quotas; members use its projects.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// ValidRole reports whether role is one of the workspace roles.
+func ValidRole(role string) bool {
+	return role == RoleOwner || role == RoleAdmin || role == RoleMember
+}
+
+// Member returns the membership of the user with uid or email, or nil. An
+// email matches only a member no account is bound to yet, unless uid is ""
+// (a lookup by email alone); callers authenticating a user must pass only
+// an email the user verified.
+func (ws *Workspace) Member(uid, email string) *WorkspaceMember {
+	if i := ws.memberIndex(uid, email); i >= 0 {
+		return &ws.Members[i]
+	}
+	return nil
+}
+
+func (ws *Workspace) memberIndex(uid, email string) int {
+	for i, m := range ws.Members {
+		byEmail := email != "" && strings.EqualFold(m.Email, email) && (uid == "" || m.UID == "")
+		if (uid != "" && m.UID == uid) || byEmail {
+			return i
+		}
+	}
+	return -1
+}
+
+// CanManage reports whether the user with uid or email is an owner or admin.
+func (ws *Workspace) CanManage(uid, email string) bool {
+	m := ws.Member(uid, email)
+	return m != nil && (m.Role == RoleOwner || m.Role == RoleAdmin)
+}
+
+func (ws *Workspace) owners() int {
+	n := 0
+	for _, m := range ws.Members {
+		if m.Role == RoleOwner {
+			n++
+		}
+	}
+	return n
+}
+
+// ErrLastOwner is returned when a change would leave a workspace without an
+// owner.
+var ErrLastOwner = errors.New("a workspace must keep at least one owner")
+
+// ==================== WorkspaceStore ====================
+
+// WorkspaceStore persists workspaces in a single JSON file.
+type WorkspaceStore struct {
+	mu         sync.RWMutex
+	workspaces []Workspace
+	filePath   string // e.g. "data/workspaces.json"
+}
+
+// NewWorkspaceStore loads the workspaces saved at path, if any.
+func NewWorkspaceStore(path string) (*WorkspaceStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data dir: %w", err)
+	}
+	store := &WorkspaceStore{filePath: path}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &store.workspaces); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	return store, nil
+}
+
+func (s *WorkspaceStore) save() error {
+	data, err := json.MarshalIndent(s.workspaces, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filePath, data, 0644)
+}
+
+// Create adds a workspace owned by the user with ownerUID.
+func (s *WorkspaceStore) Create(name, ownerUID, ownerEmail string) (*Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := generateUUID()
+	if name == "" {
+		name = "Workspace " + id[:8]
+	}
+	now := time.Now()
+	ws := Workspace{
+		ID:        id,
+		Name:      name,
+		CreatedAt: now,
+		Members:   []WorkspaceMember{{UID: ownerUID, Email: ownerEmail, Role: RoleOwner, AddedAt: now}},
+	}
+	s.workspaces = append(s.workspaces, ws)
+	if err := s.save(); err != nil {
+		s.workspaces = s.workspaces[:len(s.workspaces)-1]
+		return nil, err
+	}
+	return &ws, nil
+}
+
+// Get returns a copy of the workspace with id.
+func (s *WorkspaceStore) Get(id string) (*Workspace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.workspaces {
+		if s.workspaces[i].ID == id {
+			ws := s.workspaces[i]
+			ws.Members = append([]WorkspaceMember(nil), ws.Members...)
+			return &ws, nil
+		}
+	}
+	return nil, fmt.Errorf("workspace not found: %s", id)
+}
+
+// ForUser lists the workspaces the user with uid or email belongs to.
+func (s *WorkspaceStore) ForUser(uid, email string) []Workspace {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []Workspace{}
+	for i := range s.workspaces {
+		if s.workspaces[i].Member(uid, email) != nil {
+			ws := s.workspaces[i]
+			ws.Members = append([]WorkspaceMember(nil), ws.Members...)
+			result = append(result, ws)
+		}
+	}
+	return result
+}
+
+// modify applies fn to the workspace with id and saves the change.
+func (s *WorkspaceStore) modify(id string, fn func(*Workspace) error) (*Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.workspaces {
+		if s.workspaces[i].ID != id {
+			continue
+		}
+		before := s.workspaces[i]
+		before.Members = append([]WorkspaceMember(nil), before.Members...)
+		if err := fn(&s.workspaces[i]); err != nil {
+			s.workspaces[i] = before
+			return nil, err
+		}
+		if err := s.save(); err != nil {
+			s.workspaces[i] = before
+			return nil, err
+		}
+		ws := s.workspaces[i]
+		ws.Members = append([]WorkspaceMember(nil), ws.Members...)
+		return &ws, nil
+	}
+	return nil, fmt.Errorf("workspace not found: %s", id)
+}
+
+// SetMember adds the user with uid or email to a workspace, or changes
+// their role if they already belong to it.
+func (s *WorkspaceStore) SetMember(id, uid, email, role string) (*Workspace, error) {
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
+	if uid == "" && email == "" {
+		return nil, fmt.Errorf("a member needs a uid or an email")
+	}
+	return s.modify(id, func(ws *Workspace) error {
+		if m := ws.Member(uid, email); m != nil {
+			m.Role = role
+			if uid != "" {
+				m.UID = uid
+			}
+			if email != "" {
+				m.Email = email
+			}
+		} else {
+			ws.Members = append(ws.Members, WorkspaceMember{UID: uid, Email: email, Role: role, AddedAt: time.Now()})
+		}
+		if ws.owners() == 0 {
+			return ErrLastOwner
+		}
+		return nil
+	})
+}
+
+// BindMember binds the account uid to the member of a workspace invited as
+// email, once its user signs in with that email verified, so that the
+// membership follows the account from then on.
+func (s *WorkspaceStore) BindMember(id, uid, email string) (*Workspace, error) {
+	return s.modify(id, func(ws *Workspace) error {
+		i := ws.memberIndex("", email)
+		if i < 0 {
+			return fmt.Errorf("not a member of the workspace")
+		}
+		if ws.Members[i].UID == "" {
+			ws.Members[i].UID = uid
+		}
+		return nil
+	})
+}
+
+// RemoveMember takes the user with uid or email out of a workspace.
+func (s *WorkspaceStore) RemoveMember(id, uid, email string) (*Workspace, error) {
+	return s.modify(id, func(ws *Workspace) error {
+		i := ws.memberIndex(uid, email)
+		if i < 0 {
+			return fmt.Errorf("not a member of the workspace")
+		}
+		ws.Members = append(ws.Members[:i], ws.Members[i+1:]...)
+		if ws.owners() == 0 {
+			return ErrLastOwner
+		}
+		return nil
+	})
+}
+
+// SetQuotas sets the default limits of a workspace's projects.
+func (s *WorkspaceStore) SetQuotas(id string, q ProjectQuotas) (*Workspace, error) {
+	return s.modify(id, func(ws *Workspace) error {
+		ws.Quotas = q
+		return nil
+	})
+}
+
+// Delete removes a workspace. Its projects and settings are the caller's
+// to remove.
+func (s *WorkspaceStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.workspaces {
+		if s.workspaces[i].ID == id {
+			before := s.workspaces
+			s.workspaces = append(append([]Workspace(nil), s.workspaces[:i]...), s.workspaces[i+1:]...)
+			if err := s.save(); err != nil {
+				s.workspaces = before
+				return err
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("workspace not found: %s", id)
+}
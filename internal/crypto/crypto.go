@@ -12,8 +12,9 @@ import (
 )
 
 // deriveKey produces a deterministic 32-byte AES-256 key from machine-specific
-// attributes (hostname + working directory). This prevents casual reading of
-// API keys from settings.json without requiring a user-provided passphrase.
+// attributes (hostname + working directory). This was the only key source
+// before Init existed; it is kept so settings written by older versions can
+// still be decrypted and migrated to the new key.
 func deriveKey() []byte {
 	hostname, _ := os.Hostname()
 	cwd, _ := os.Getwd()
@@ -25,11 +26,28 @@ func deriveKey() []byte {
 // Encrypt encrypts plaintext using AES-256-GCM and returns a base64-encoded string.
 // Returns empty string for empty input (no encryption needed).
 func Encrypt(plaintext string) (string, error) {
+	return EncryptWithKey(currentKey(), plaintext)
+}
+
+// Decrypt decodes a base64 string and decrypts it using AES-256-GCM.
+// Returns empty string for empty input.
+func Decrypt(encoded string) (string, error) {
+	return DecryptWithKey(currentKey(), encoded)
+}
+
+// DecryptLegacy decrypts a value that was encrypted with the old
+// machine-derived key (hostname + working directory). Used to migrate
+// settings files written before the keychain/passphrase key existed.
+func DecryptLegacy(encoded string) (string, error) {
+	return DecryptWithKey(deriveKey(), encoded)
+}
+
+// EncryptWithKey encrypts plaintext with an explicit 32-byte key.
+func EncryptWithKey(key []byte, plaintext string) (string, error) {
 	if plaintext == "" {
 		return "", nil
 	}
 
-	key := deriveKey()
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", fmt.Errorf("cipher error: %w", err)
@@ -49,9 +67,8 @@ func Encrypt(plaintext string) (string, error) {
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-// Decrypt decodes a base64 string and decrypts it using AES-256-GCM.
-// Returns empty string for empty input.
-func Decrypt(encoded string) (string, error) {
+// DecryptWithKey decrypts a base64 AES-256-GCM value with an explicit key.
+func DecryptWithKey(key []byte, encoded string) (string, error) {
 	if encoded == "" {
 		return "", nil
 	}
@@ -61,7 +78,6 @@ func Decrypt(encoded string) (string, error) {
 		return "", fmt.Errorf("base64 decode error: %w", err)
 	}
 
-	key := deriveKey()
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", fmt.Errorf("cipher error: %w", err)
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -120,3 +121,155 @@ func TestEncryptDecrypt_SpecialCharacters(t *testing.T) {
 		t.Errorf("roundtrip failed: got %q, want %q", decrypted, original)
 	}
 }
+
+// ========== Key management ==========
+
+func TestKeyFromPassphrase_Deterministic(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	k1, err := KeyFromPassphrase("correct horse", salt)
+	if err != nil {
+		t.Fatalf("KeyFromPassphrase error: %v", err)
+	}
+	k2, _ := KeyFromPassphrase("correct horse", salt)
+	k3, _ := KeyFromPassphrase("wrong horse", salt)
+
+	if len(k1) != 32 {
+		t.Errorf("key length: got %d, want 32", len(k1))
+	}
+	if string(k1) != string(k2) {
+		t.Error("same passphrase and salt should produce the same key")
+	}
+	if string(k1) == string(k3) {
+		t.Error("different passphrases should produce different keys")
+	}
+}
+
+func TestInit_PassphraseRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(PassphraseEnv, "test-passphrase")
+	t.Cleanup(func() { setKey(nil, "") })
+
+	if err := Init(dir); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	if Source() != SourcePassphrase {
+		t.Errorf("Source: got %q, want %q", Source(), SourcePassphrase)
+	}
+
+	enc, err := Encrypt("sk-secret")
+	if err != nil {
+		t.Fatalf("Encrypt error: %v", err)
+	}
+
+	// Re-initialising with the same passphrase reuses the stored salt
+	if err := Init(dir); err != nil {
+		t.Fatalf("second Init error: %v", err)
+	}
+	dec, err := Decrypt(enc)
+	if err != nil || dec != "sk-secret" {
+		t.Errorf("roundtrip after re-init: got %q, %v", dec, err)
+	}
+
+	if _, err := DecryptLegacy(enc); err == nil {
+		t.Error("legacy key should not decrypt values written with the passphrase key")
+	}
+}
+
+func TestInit_WrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { setKey(nil, "") })
+
+	t.Setenv(PassphraseEnv, "first")
+	if err := Init(dir); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+
+	t.Setenv(PassphraseEnv, "second")
+	if err := Init(dir); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("expected ErrKeyMismatch, got %v", err)
+	}
+}
+
+func TestInit_PrefersDataDirKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(PassphraseEnv, "")
+	t.Cleanup(func() { setKey(nil, "") })
+
+	key, _ := randomKey()
+	if err := writeKeyFile(dir, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKeyCheck(dir, key); err != nil {
+		t.Fatal(err)
+	}
+	// Whatever the host's keychain holds, the key file the check verifies wins
+	if err := Init(dir); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	if Source() != SourceKeyFile {
+		t.Errorf("Source: got %q, want %q", Source(), SourceKeyFile)
+	}
+
+	other, _ := randomKey()
+	if err := writeKeyCheck(dir, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(dir); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("key file not matching the check: expected ErrKeyMismatch, got %v", err)
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	enc, err := EncryptWithKey(deriveKey(), "sk-old")
+	if err != nil {
+		t.Fatalf("EncryptWithKey error: %v", err)
+	}
+	dec, err := DecryptLegacy(enc)
+	if err != nil || dec != "sk-old" {
+		t.Errorf("DecryptLegacy: got %q, %v", dec, err)
+	}
+}
+
+func TestDecryptWithKey_WrongKey(t *testing.T) {
+	k1 := make([]byte, 32)
+	k2 := make([]byte, 32)
+	k2[0] = 1
+	enc, _ := EncryptWithKey(k1, "sk-abc")
+	if _, err := DecryptWithKey(k2, enc); err == nil {
+		t.Error("expected error decrypting with the wrong key")
+	}
+}
+
+func TestRotate_NewPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { setKey(nil, "") })
+
+	t.Setenv(PassphraseEnv, "old-pass")
+	if err := Init(dir); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	oldEnc, _ := Encrypt("sk-rotate")
+
+	if err := Rotate(dir, "new-pass"); err != nil {
+		t.Fatalf("Rotate error: %v", err)
+	}
+	if _, err := Decrypt(oldEnc); err == nil {
+		t.Error("values encrypted with the old key should not decrypt after rotation")
+	}
+
+	// Restart with the old passphrase must be rejected, the new one accepted
+	if err := Init(dir); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("old passphrase after rotation: expected ErrKeyMismatch, got %v", err)
+	}
+	t.Setenv(PassphraseEnv, "new-pass")
+	if err := Init(dir); err != nil {
+		t.Errorf("new passphrase after rotation: %v", err)
+	}
+}
+
+func TestRotate_RequiresPassphraseWhenEnvSet(t *testing.T) {
+	t.Setenv(PassphraseEnv, "pass")
+	if err := Rotate(t.TempDir(), ""); err == nil {
+		t.Error("expected error rotating to keychain while passphrase env is set")
+	}
+}
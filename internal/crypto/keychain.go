@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The OS keychain is reached through the platform's own CLI tools
+// (security on macOS, secret-tool on Linux) so no cgo or extra
+// dependencies are needed. Hosts without either tool fall back to a key file.
+
+const (
+	keychainService = "gocognigo"
+	keychainAccount = "settings-encryption-key"
+	keychainTimeout = 5 * time.Second
+)
+
+// keychainGet reads the stored key from the OS keychain.
+func keychainGet() ([]byte, error) {
+	var out []byte
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		out, err = runKeychainCmd(nil, "security", "find-generic-password",
+			"-s", keychainService, "-a", keychainAccount, "-w")
+	case "linux":
+		out, err = runKeychainCmd(nil, "secret-tool", "lookup",
+			"service", keychainService, "account", keychainAccount)
+	default:
+		return nil, fmt.Errorf("no keychain support on %s", runtime.GOOS)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid key in keychain")
+	}
+	return key, nil
+}
+
+// keychainSet stores (or replaces) the key in the OS keychain.
+func keychainSet(key []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(key)
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		// The key goes in on stdin, through security's interactive mode, so
+		// it never shows on the command line. That mode doesn't fail the
+		// process when a command does, so the key is read back to check
+		cmd := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", keychainService, keychainAccount, encoded)
+		if _, err = runKeychainCmd([]byte(cmd), "security", "-i"); err != nil {
+			return err
+		}
+		if stored, getErr := keychainGet(); getErr != nil || !bytes.Equal(stored, key) {
+			return fmt.Errorf("security: key not stored")
+		}
+	case "linux":
+		_, err = runKeychainCmd([]byte(encoded), "secret-tool", "store",
+			"--label=GoCognigo settings encryption key",
+			"service", keychainService, "account", keychainAccount)
+	default:
+		return fmt.Errorf("no keychain support on %s", runtime.GOOS)
+	}
+	return err
+}
+
+func runKeychainCmd(stdin []byte, name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	// Keychain daemons can block waiting for an unlock prompt on headless hosts
+	ctx, cancel := context.WithTimeout(context.Background(), keychainTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v (stderr: %s)", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
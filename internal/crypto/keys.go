@@ -0,0 +1,307 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseEnv names the environment variable holding an optional
+// passphrase. When set, the AES key is derived from it with scrypt instead
+// of being stored in the OS keychain.
+const PassphraseEnv = "GOCOGNIGO_PASSPHRASE"
+
+const (
+	saltFile   = ".keysalt"    // random scrypt salt (passphrase mode)
+	keyFile    = ".secret.key" // fallback key storage when no keychain is available
+	checkFile  = ".keycheck"   // known value encrypted with the active key
+	checkValue = "gocognigo-key-check"
+)
+
+// Key sources reported by Source.
+const (
+	SourcePassphrase = "passphrase"
+	SourceKeychain   = "keychain"
+	SourceKeyFile    = "keyfile"
+	SourceLegacy     = "legacy"
+)
+
+// ErrKeyMismatch means the resolved key cannot decrypt the key-check file, i.e.
+// stored secrets were encrypted with different key material (wrong passphrase,
+// wiped keychain entry, or a data directory copied from another machine).
+var ErrKeyMismatch = errors.New("encryption key does not match the key used for stored settings")
+
+var (
+	keyMu     sync.RWMutex
+	activeKey []byte
+	keySource = SourceLegacy
+)
+
+// currentKey returns the key set by Init, or the legacy machine-derived key
+// if Init has not been called (e.g. in tests or one-off tools).
+func currentKey() []byte {
+	keyMu.RLock()
+	defer keyMu.RUnlock()
+	if activeKey == nil {
+		return deriveKey()
+	}
+	return activeKey
+}
+
+func setKey(key []byte, source string) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	activeKey = key
+	keySource = source
+	if key == nil {
+		keySource = SourceLegacy
+	}
+}
+
+// Source reports where the active key came from: "passphrase", "keychain",
+// "keyfile", or "legacy" when Init has not been called.
+func Source() string {
+	keyMu.RLock()
+	defer keyMu.RUnlock()
+	return keySource
+}
+
+// Init resolves the AES key used for settings encryption and makes it active.
+// Resolution order:
+//  1. GOCOGNIGO_PASSPHRASE, stretched with scrypt and a per-data-dir salt
+//  2. an existing key file in dataDir (for hosts without a keychain) or key
+//     in the OS keychain, the first that the key-check file verifies; the
+//     key file first, as the keychain entry may be another data dir's
+//  3. a newly generated random key, stored in the keychain or the key file
+//
+// Unlike the legacy hostname+cwd key, none of these change when the data
+// directory is moved or the host is renamed. Returns ErrKeyMismatch if the
+// resolved key does not match the one stored secrets were written with.
+func Init(dataDir string) error {
+	key, source, err := resolveKey(dataDir)
+	if err != nil {
+		return err
+	}
+	if err := verifyKeyCheck(dataDir, key); err != nil {
+		return err
+	}
+	setKey(key, source)
+	log.Printf("Settings encryption key loaded from %s", source)
+	return nil
+}
+
+func resolveKey(dataDir string) ([]byte, string, error) {
+	if pass := os.Getenv(PassphraseEnv); pass != "" {
+		salt, err := loadOrCreateSalt(dataDir)
+		if err != nil {
+			return nil, "", err
+		}
+		key, err := KeyFromPassphrase(pass, salt)
+		if err != nil {
+			return nil, "", err
+		}
+		return key, SourcePassphrase, nil
+	}
+
+	type candidate struct {
+		key    []byte
+		source string
+	}
+	var candidates []candidate
+	if key, err := readKeyFile(dataDir); err == nil {
+		candidates = append(candidates, candidate{key, SourceKeyFile})
+	}
+	if key, err := keychainGet(); err == nil {
+		candidates = append(candidates, candidate{key, SourceKeychain})
+	}
+	for _, c := range candidates {
+		if keyCheckMatches(dataDir, c.key) {
+			return c.key, c.source, nil
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0].key, candidates[0].source, nil // Init reports the mismatch
+	}
+
+	key, err := randomKey()
+	if err != nil {
+		return nil, "", err
+	}
+	source, err := storeKey(dataDir, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, source, nil
+}
+
+// storeKey saves a random key in the OS keychain, falling back to a
+// permission-restricted key file in dataDir.
+func storeKey(dataDir string, key []byte) (string, error) {
+	err := keychainSet(key)
+	if err == nil {
+		return SourceKeychain, nil
+	}
+	log.Printf("OS keychain unavailable (%v), storing encryption key in %s", err, filepath.Join(dataDir, keyFile))
+	if err := writeKeyFile(dataDir, key); err != nil {
+		return "", fmt.Errorf("store encryption key: %w", err)
+	}
+	return SourceKeyFile, nil
+}
+
+// KeyFromPassphrase derives a 32-byte AES key from a passphrase using scrypt.
+func KeyFromPassphrase(passphrase string, salt []byte) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("scrypt: %w", err)
+	}
+	return key, nil
+}
+
+func randomKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	return key, nil
+}
+
+func loadOrCreateSalt(dataDir string) ([]byte, error) {
+	path := filepath.Join(dataDir, saltFile)
+	if data, err := os.ReadFile(path); err == nil {
+		salt, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("corrupt salt file %s: %w", path, err)
+		}
+		return salt, nil
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+	if err := writeSecretFile(path, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func readKeyFile(dataDir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, keyFile))
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid key file")
+	}
+	return key, nil
+}
+
+func writeKeyFile(dataDir string, key []byte) error {
+	return writeSecretFile(filepath.Join(dataDir, keyFile), key)
+}
+
+func writeSecretFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(data)), 0600)
+}
+
+// verifyKeyCheck confirms key matches the key-check file in dataDir,
+// creating the file on first use.
+func verifyKeyCheck(dataDir string, key []byte) error {
+	path := filepath.Join(dataDir, checkFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return writeKeyCheck(dataDir, key)
+	}
+	if err != nil {
+		return err
+	}
+	plain, err := DecryptWithKey(key, strings.TrimSpace(string(data)))
+	if err != nil || plain != checkValue {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// keyCheckMatches reports whether key decrypts the key-check file in
+// dataDir, or there is none yet.
+func keyCheckMatches(dataDir string, key []byte) bool {
+	data, err := os.ReadFile(filepath.Join(dataDir, checkFile))
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	plain, err := DecryptWithKey(key, strings.TrimSpace(string(data)))
+	return err == nil && plain == checkValue
+}
+
+func writeKeyCheck(dataDir string, key []byte) error {
+	enc, err := EncryptWithKey(key, checkValue)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dataDir, checkFile), []byte(enc), 0600)
+}
+
+// Rotate generates new key material, persists it and makes it active. With a
+// non-empty passphrase the key is derived from it using a fresh salt (the
+// caller must update GOCOGNIGO_PASSPHRASE to match before the next start);
+// otherwise a new random key replaces the keychain entry or key file.
+//
+// Rotate only swaps the key: callers must decrypt stored secrets before
+// calling it and re-encrypt them afterwards.
+func Rotate(dataDir, passphrase string) error {
+	if passphrase == "" && os.Getenv(PassphraseEnv) != "" {
+		return fmt.Errorf("%s is set; rotate to a new passphrase or unset it first", PassphraseEnv)
+	}
+
+	var key []byte
+	var source string
+	var err error
+	if passphrase != "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("generate salt: %w", err)
+		}
+		if key, err = KeyFromPassphrase(passphrase, salt); err != nil {
+			return err
+		}
+		if err := writeSecretFile(filepath.Join(dataDir, saltFile), salt); err != nil {
+			return err
+		}
+		source = SourcePassphrase
+	} else {
+		if key, err = randomKey(); err != nil {
+			return err
+		}
+		if source, err = storeKey(dataDir, key); err != nil {
+			return err
+		}
+		if source == SourceKeychain {
+			// Drop any stale key file so it can never shadow the keychain entry
+			_ = os.Remove(filepath.Join(dataDir, keyFile))
+		}
+	}
+
+	if err := writeKeyCheck(dataDir, key); err != nil {
+		return err
+	}
+	setKey(key, source)
+	log.Printf("Settings encryption key rotated (now from %s)", source)
+	return nil
+}
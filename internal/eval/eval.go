@@ -0,0 +1,257 @@
+// Package eval scores pipeline answers against gold question/answer sets so
+// regressions after re-chunking, re-embedding or model changes are visible.
+package eval
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gocognigo/internal/llm"
+	"gocognigo/internal/retriever"
+)
+
+// Case is one gold question with its expected answer and, optionally, the
+// sources a correct answer must come from. Without expected documents only
+// answer similarity is scored.
+type Case struct {
+	Question          string   `json:"question"`
+	ExpectedAnswer    string   `json:"expected_answer"`
+	ExpectedDocuments []string `json:"expected_documents,omitempty"`
+	ExpectedPages     []int    `json:"expected_pages,omitempty"` // restricts matches to these pages
+}
+
+// CaseResult holds the scores for one case.
+type CaseResult struct {
+	Case
+	Answer      string  `json:"answer"`
+	Error       string  `json:"error,omitempty"`
+	TimeSeconds float64 `json:"time_seconds"`
+
+	HasSources        bool    `json:"has_sources"`    // retrieval and citation metrics were scored
+	RetrievalHit      bool    `json:"retrieval_hit"`  // an expected source was retrieved
+	RetrievalRank     int     `json:"retrieval_rank"` // 1-based rank of the first hit, 0 if none
+	CitationPrecision float64 `json:"citation_precision"`
+	CitationRecall    float64 `json:"citation_recall"`
+	AnswerSimilarity  float64 `json:"answer_similarity"` // token F1 against the expected answer
+}
+
+// Summary averages a run's scores. Retrieval and citation metrics only count
+// cases with expected sources; failed cases count towards Errors only.
+type Summary struct {
+	Cases              int     `json:"cases"`
+	Errors             int     `json:"errors"`
+	RetrievalHitRate   float64 `json:"retrieval_hit_rate"`
+	MeanReciprocalRank float64 `json:"mean_reciprocal_rank"`
+	CitationPrecision  float64 `json:"citation_precision"`
+	CitationRecall     float64 `json:"citation_recall"`
+	AnswerSimilarity   float64 `json:"answer_similarity"`
+}
+
+// Regression is a metric that dropped between two runs.
+type Regression struct {
+	Metric   string  `json:"metric"`
+	Previous float64 `json:"previous"`
+	Current  float64 `json:"current"`
+}
+
+// RegressionThreshold is how far a metric must fall to count as a regression,
+// so run-to-run LLM noise isn't flagged.
+const RegressionThreshold = 0.05
+
+// sameDocument compares document names ignoring case and any directory.
+func sameDocument(a, b string) bool {
+	return strings.EqualFold(filepath.Base(a), filepath.Base(b))
+}
+
+// matchesSource reports whether doc/page is one of the case's expected sources.
+func (c Case) matchesSource(doc string, page int) bool {
+	for _, d := range c.ExpectedDocuments {
+		if !sameDocument(d, doc) {
+			continue
+		}
+		if len(c.ExpectedPages) == 0 {
+			return true
+		}
+		for _, p := range c.ExpectedPages {
+			if p == page {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ScoreCase scores one answered case. results are the retrieved chunks in
+// rank order; answer may be nil if the LLM call failed.
+func ScoreCase(c Case, results []retriever.Result, answer *llm.Answer) CaseResult {
+	res := CaseResult{Case: c, HasSources: len(c.ExpectedDocuments) > 0}
+
+	if res.HasSources {
+		for i, r := range results {
+			if c.matchesSource(r.Document, r.PageNumber) {
+				res.RetrievalHit = true
+				res.RetrievalRank = i + 1
+				break
+			}
+		}
+	}
+
+	if answer == nil {
+		return res
+	}
+	res.Answer = answer.Answer
+	res.AnswerSimilarity = TokenF1(answer.Answer, c.ExpectedAnswer)
+
+	if res.HasSources {
+		cited := citedSources(answer)
+		correct := 0
+		for _, s := range cited {
+			if c.matchesSource(s.doc, s.page) {
+				correct++
+			}
+		}
+		if len(cited) > 0 {
+			res.CitationPrecision = float64(correct) / float64(len(cited))
+		}
+		found := 0
+		for _, d := range c.ExpectedDocuments {
+			for _, s := range cited {
+				if sameDocument(d, s.doc) {
+					found++
+					break
+				}
+			}
+		}
+		res.CitationRecall = float64(found) / float64(len(c.ExpectedDocuments))
+	}
+	return res
+}
+
+type source struct {
+	doc  string
+	page int
+}
+
+// citedSources lists the answer's footnoted sources, falling back to its
+// parallel documents/pages lists for answers without footnotes.
+func citedSources(a *llm.Answer) []source {
+	var out []source
+	for _, fn := range a.Footnotes {
+		out = append(out, source{fn.Document, fn.Page})
+	}
+	if len(out) > 0 {
+		return out
+	}
+	for i, d := range a.Documents {
+		page := 0
+		if i < len(a.Pages) {
+			page = a.Pages[i]
+		}
+		out = append(out, source{d, page})
+	}
+	return out
+}
+
+// Summarize averages case results into a run summary.
+func Summarize(results []CaseResult) Summary {
+	sum := Summary{Cases: len(results)}
+	var sourced, answered int
+	for _, r := range results {
+		if r.Error != "" {
+			sum.Errors++
+			continue
+		}
+		answered++
+		sum.AnswerSimilarity += r.AnswerSimilarity
+		if !r.HasSources {
+			continue
+		}
+		sourced++
+		if r.RetrievalHit {
+			sum.RetrievalHitRate++
+			sum.MeanReciprocalRank += 1 / float64(r.RetrievalRank)
+		}
+		sum.CitationPrecision += r.CitationPrecision
+		sum.CitationRecall += r.CitationRecall
+	}
+	if answered > 0 {
+		sum.AnswerSimilarity /= float64(answered)
+	}
+	if sourced > 0 {
+		sum.RetrievalHitRate /= float64(sourced)
+		sum.MeanReciprocalRank /= float64(sourced)
+		sum.CitationPrecision /= float64(sourced)
+		sum.CitationRecall /= float64(sourced)
+	}
+	return sum
+}
+
+// Compare returns the metrics that fell by more than RegressionThreshold from
+// prev to cur.
+func Compare(prev, cur Summary) []Regression {
+	metrics := []struct {
+		name      string
+		prev, cur float64
+	}{
+		{"retrieval_hit_rate", prev.RetrievalHitRate, cur.RetrievalHitRate},
+		{"mean_reciprocal_rank", prev.MeanReciprocalRank, cur.MeanReciprocalRank},
+		{"citation_precision", prev.CitationPrecision, cur.CitationPrecision},
+		{"citation_recall", prev.CitationRecall, cur.CitationRecall},
+		{"answer_similarity", prev.AnswerSimilarity, cur.AnswerSimilarity},
+	}
+	var out []Regression
+	for _, m := range metrics {
+		if m.prev-m.cur > RegressionThreshold {
+			out = append(out, Regression{Metric: m.name, Previous: m.prev, Current: m.cur})
+		}
+	}
+	return out
+}
+
+// TokenF1 is the harmonic mean of token precision and recall between two
+// texts after lowercasing and dropping punctuation, articles and footnote
+// markers.
+func TokenF1(candidate, reference string) float64 {
+	cand, ref := normalizeTokens(candidate), normalizeTokens(reference)
+	if len(cand) == 0 || len(ref) == 0 {
+		if len(cand) == len(ref) {
+			return 1
+		}
+		return 0
+	}
+	counts := make(map[string]int, len(ref))
+	for _, t := range ref {
+		counts[t]++
+	}
+	common := 0
+	for _, t := range cand {
+		if counts[t] > 0 {
+			counts[t]--
+			common++
+		}
+	}
+	if common == 0 {
+		return 0
+	}
+	precision := float64(common) / float64(len(cand))
+	recall := float64(common) / float64(len(ref))
+	return 2 * precision * recall / (precision + recall)
+}
+
+var footnoteMarker = regexp.MustCompile(`\[\d+\]`)
+
+func normalizeTokens(s string) []string {
+	s = footnoteMarker.ReplaceAllString(s, " ")
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := fields[:0]
+	for _, f := range fields {
+		if f != "a" && f != "an" && f != "the" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package eval
+
+import (
+	"math"
+	"testing"
+
+	"gocognigo/internal/llm"
+	"gocognigo/internal/retriever"
+)
+
+func approx(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+// ========== TokenF1 ==========
+
+func TestTokenF1(t *testing.T) {
+	if f := TokenF1("The revenue was $10M.", "revenue was $10M"); !approx(f, 1) {
+		t.Errorf("articles/punctuation should not matter, got %v", f)
+	}
+	if f := TokenF1("Revenue grew [1].", "Revenue grew"); !approx(f, 1) {
+		t.Errorf("footnote markers should not matter, got %v", f)
+	}
+	if f := TokenF1("alpha beta", "gamma delta"); f != 0 {
+		t.Errorf("disjoint texts = %v, want 0", f)
+	}
+	// 2 common tokens: precision 2/4, recall 2/2 → F1 = 2/3
+	if f := TokenF1("alpha beta gamma delta", "alpha beta"); !approx(f, 2.0/3) {
+		t.Errorf("partial overlap = %v, want 0.667", f)
+	}
+}
+
+// ========== ScoreCase ==========
+
+func TestScoreCase_RetrievalAndCitations(t *testing.T) {
+	c := Case{
+		Question:          "Who signed?",
+		ExpectedAnswer:    "Alice signed the agreement",
+		ExpectedDocuments: []string{"contract.pdf"},
+		ExpectedPages:     []int{3},
+	}
+	results := []retriever.Result{
+		{Document: "other.pdf", PageNumber: 1},
+		{Document: "Contract.PDF", PageNumber: 2}, // right doc, wrong page
+		{Document: "contract.pdf", PageNumber: 3},
+	}
+	answer := &llm.Answer{
+		Answer: "Alice signed the agreement [1].",
+		Footnotes: []llm.Footnote{
+			{ID: 1, Document: "contract.pdf", Page: 3},
+			{ID: 2, Document: "other.pdf", Page: 1},
+		},
+	}
+
+	res := ScoreCase(c, results, answer)
+	if !res.RetrievalHit || res.RetrievalRank != 3 {
+		t.Errorf("hit=%v rank=%d, want hit at rank 3", res.RetrievalHit, res.RetrievalRank)
+	}
+	if !approx(res.CitationPrecision, 0.5) {
+		t.Errorf("citation precision = %v, want 0.5", res.CitationPrecision)
+	}
+	if !approx(res.CitationRecall, 1) {
+		t.Errorf("citation recall = %v, want 1", res.CitationRecall)
+	}
+	if !approx(res.AnswerSimilarity, 1) {
+		t.Errorf("similarity = %v, want 1", res.AnswerSimilarity)
+	}
+}
+
+func TestScoreCase_NoExpectedSources(t *testing.T) {
+	res := ScoreCase(Case{ExpectedAnswer: "yes"}, nil, &llm.Answer{Answer: "yes"})
+	if res.HasSources {
+		t.Error("case without expected documents should not score sources")
+	}
+	sum := Summarize([]CaseResult{res})
+	if sum.RetrievalHitRate != 0 || !approx(sum.AnswerSimilarity, 1) {
+		t.Errorf("summary = %+v", sum)
+	}
+}
+
+// ========== Summarize / Compare ==========
+
+func TestSummarizeAndCompare(t *testing.T) {
+	results := []CaseResult{
+		{HasSources: true, RetrievalHit: true, RetrievalRank: 1, AnswerSimilarity: 1},
+		{HasSources: true, RetrievalHit: true, RetrievalRank: 2, AnswerSimilarity: 0.5},
+		{HasSources: true, Error: "LLM: timeout"},
+	}
+	sum := Summarize(results)
+	if sum.Cases != 3 || sum.Errors != 1 {
+		t.Errorf("cases=%d errors=%d, want 3 and 1", sum.Cases, sum.Errors)
+	}
+	if !approx(sum.RetrievalHitRate, 1) || !approx(sum.MeanReciprocalRank, 0.75) {
+		t.Errorf("hit rate=%v mrr=%v, want 1 and 0.75", sum.RetrievalHitRate, sum.MeanReciprocalRank)
+	}
+
+	worse := sum
+	worse.RetrievalHitRate = 0.5
+	worse.AnswerSimilarity -= 0.01 // within noise threshold
+	regs := Compare(sum, worse)
+	if len(regs) != 1 || regs[0].Metric != "retrieval_hit_rate" {
+		t.Errorf("regressions = %+v, want only retrieval_hit_rate", regs)
+	}
+}
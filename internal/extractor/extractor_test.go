@@ -1,6 +1,9 @@
 package extractor
 
 import (
+	"archive/zip"
+	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -91,3 +94,133 @@ func TestStripTags_SelfClosingTags(t *testing.T) {
 		t.Errorf("stripTags = %q, want 'TextMore'", got)
 	}
 }
+
+// ========== OCR confidence ==========
+
+func TestTesseractConfidence(t *testing.T) {
+	tsv := "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n" +
+		"1\t1\t0\t0\t0\t0\t0\t0\t100\t100\t-1\t\n" +
+		"5\t1\t1\t1\t1\t1\t10\t10\t20\t10\t96.5\tThe\n" +
+		"5\t1\t1\t1\t1\t2\t40\t10\t20\t10\t51.5\tIevenue\n" +
+		"5\t1\t1\t1\t1\t3\t70\t10\t20\t10\t95\t \n"
+	if got := tesseractConfidence([]byte(tsv)); got != 0.74 {
+		t.Errorf("tesseractConfidence = %v, want 0.74", got)
+	}
+	if got := tesseractConfidence(nil); got != 0 {
+		t.Errorf("tesseractConfidence(nil) = %v, want 0", got)
+	}
+}
+
+func TestTextQuality(t *testing.T) {
+	if got := textQuality("Revenue grew by 10.5% in 2023, per Section 4."); got != 1 {
+		t.Errorf("clean text: quality %v, want 1", got)
+	}
+	if got := textQuality("Rev3nue gr|w by c1ause"); got != 0.25 {
+		t.Errorf("garbled text: quality %v, want 0.25", got)
+	}
+	if got := textQuality("  "); got != 0 {
+		t.Errorf("empty text: quality %v, want 0", got)
+	}
+}
+
+func TestRenderPage_RejectsBadArguments(t *testing.T) {
+	if _, err := RenderPage("missing.pdf", 0, DefaultRenderDPI); err == nil {
+		t.Error("expected an error for page 0")
+	}
+	if _, err := RenderPage("missing.pdf", 1, MaxRenderDPI+1); err == nil {
+		t.Error("expected an error for an out-of-range dpi")
+	}
+}
+
+// ========== Sarvam pages ==========
+
+// sarvamZip builds a job output ZIP of files (name → content).
+func sarvamZip(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestParseSarvamOutput_PageMetadata(t *testing.T) {
+	zr := sarvamZip(t, map[string]string{
+		"doc.md": "Everything merged, with no page breaks at all in it",
+		// Zero-based, and page 1 (the second) is blank
+		"metadata/page_000.json": `{"page_num": 0, "blocks": [
+			{"text": "second paragraph of the cover", "reading_order": 2, "confidence": 0.8},
+			{"text": "# Annual Report", "reading_order": 1, "confidence": 0.9}]}`,
+		"metadata/page_001.json": `{"page_num": 1, "blocks": []}`,
+		"metadata/page_002.json": `{"page_num": 2, "blocks": [{"text": "Revenue grew by ten percent this year"}]}`,
+	})
+	chunks, err := parseSarvamOutput(zr, "report.pdf", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || chunks[0].PageNumber != 1 || chunks[1].PageNumber != 3 {
+		t.Fatalf("pages %+v, want pages 1 and 3", chunks)
+	}
+	if chunks[0].Text != "Annual Report\n\nsecond paragraph of the cover" {
+		t.Errorf("page 1 text %q, want its blocks in reading order", chunks[0].Text)
+	}
+	if chunks[0].OCRConfidence != 0.85 || chunks[1].OCRConfidence != 0 {
+		t.Errorf("confidences %v and %v, want 0.85 and 0", chunks[0].OCRConfidence, chunks[1].OCRConfidence)
+	}
+
+	// Metadata numbering pages the PDF doesn't have isn't trusted
+	if chunks, _ := parseSarvamOutput(zr, "report.pdf", 2); len(chunks) == 0 || !strings.HasPrefix(chunks[0].Text, "Everything merged") {
+		t.Errorf("with 2 pages: %+v, want the merged file split", chunks)
+	}
+}
+
+func TestParseSarvamOutput_PerPageFiles(t *testing.T) {
+	zr := sarvamZip(t, map[string]string{
+		"page_2.md": "The second page of the document, in full",
+		"page_1.md": "The first page of the document, in full",
+	})
+	chunks, err := parseSarvamOutput(zr, "doc.pdf", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || chunks[0].PageNumber != 1 || !strings.HasPrefix(chunks[0].Text, "The first") {
+		t.Errorf("pages %+v, want page 1 first", chunks)
+	}
+}
+
+func TestSplitMergedMarkdownIntoPages_PageMarkers(t *testing.T) {
+	text := "<!-- page 2 -->\nThe text of the second page of the scan\n---\n" +
+		"<!-- Page 4 -->\nThe text of the fourth page of the scan"
+	chunks := splitMergedMarkdownIntoPages(text, "doc.pdf", 4)
+	if len(chunks) != 2 || chunks[0].PageNumber != 2 || chunks[1].PageNumber != 4 {
+		t.Fatalf("pages %+v, want pages 2 and 4", chunks)
+	}
+	if chunks[0].Text != "The text of the second page of the scan" {
+		t.Errorf("page 2 text %q", chunks[0].Text)
+	}
+}
+
+func TestSplitMergedMarkdownIntoPages_SpreadsOverPageCount(t *testing.T) {
+	text := strings.Repeat("word ", 90)
+	chunks := splitMergedMarkdownIntoPages(text, "doc.pdf", 3)
+	if len(chunks) != 3 || chunks[2].PageNumber != 3 || len(strings.Fields(chunks[2].Text)) != 30 {
+		t.Errorf("got %d pages, want 3 of 30 words", len(chunks))
+	}
+	if chunks := splitMergedMarkdownIntoPages(text, "doc.pdf", 0); len(chunks) != 1 {
+		t.Errorf("unknown page count: %d pages, want 1 of up to 500 words", len(chunks))
+	}
+}
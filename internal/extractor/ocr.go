@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
@@ -18,6 +19,9 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+
+	"gocognigo/internal/httpclient"
 
 	"github.com/ledongthuc/pdf"
 )
@@ -133,7 +137,16 @@ func DetectPdftoppm() bool {
 
 // RunOCR attempts OCR on a PDF that yielded no extractable text.
 // It tries the configured provider first, then falls back to the other.
+// The pages it returns are marked OCR.
 func RunOCR(cfg OCRConfig, pdfPath string) ([]DocumentChunk, error) {
+	chunks, err := runOCR(cfg, pdfPath)
+	for i := range chunks {
+		chunks[i].OCR = true
+	}
+	return chunks, err
+}
+
+func runOCR(cfg OCRConfig, pdfPath string) ([]DocumentChunk, error) {
 	fileName := filepath.Base(pdfPath)
 
 	switch strings.ToLower(cfg.Provider) {
@@ -386,30 +399,37 @@ func tesseractOCRRange(pdfPath, fileName, bin, tessDataPrefix, lang string, firs
 			defer func() { <-tesseractSem }()
 
 			pageNum := basePageNum + idx
-			cmd := exec.Command(bin, file, "stdout", "-l", lang, "--psm", "6")
+			// One run writes the text, and the words with their confidence
+			outBase := strings.TrimSuffix(file, filepath.Ext(file))
+			cmd := exec.Command(bin, file, outBase, "-l", lang, "--psm", "6", "txt", "tsv")
 			cmd.Env = append(os.Environ(),
 				"TESSDATA_PREFIX="+tessDataPrefix,
 				"OMP_THREAD_LIMIT=1", // disable Tesseract internal multithreading to avoid CPU thrashing
 			)
-			var out bytes.Buffer
 			var stderr bytes.Buffer
-			cmd.Stdout = &out
 			cmd.Stderr = &stderr
 
-			if err := cmd.Run(); err != nil {
+			err := cmd.Run()
+			var out []byte
+			if err == nil {
+				out, err = os.ReadFile(outBase + ".txt")
+			}
+			if err != nil {
 				firstErrLogged.Do(func() {
 					log.Printf("Tesseract failed on page %d of %s: %v | stderr: %s", pageNum, fileName, err, strings.TrimSpace(stderr.String()))
 				})
 				return
 			}
+			tsv, _ := os.ReadFile(outBase + ".tsv") // no confidence without it
 
-			text := strings.TrimSpace(out.String())
+			text := strings.TrimSpace(string(out))
 			if len(text) > 20 { // skip near-empty pages
 				chunkMu.Lock()
 				chunks = append(chunks, DocumentChunk{
-					PageNumber: pageNum,
-					Document:   fileName,
-					Text:       text,
+					PageNumber:    pageNum,
+					Document:      fileName,
+					Text:          text,
+					OCRConfidence: tesseractConfidence(tsv),
 				})
 				chunkMu.Unlock()
 			}
@@ -433,6 +453,69 @@ func tesseractOCRRange(pdfPath, fileName, bin, tessDataPrefix, lang string, firs
 	return chunks, nil
 }
 
+// tesseractConfidence returns the mean confidence, from 0 to 1, Tesseract
+// gave the words of a page in its TSV output; 0 if it lists none.
+func tesseractConfidence(tsv []byte) float64 {
+	var sum float64
+	words := 0
+	for _, line := range strings.Split(string(tsv), "\n") {
+		// level page block par line word left top width height conf text
+		f := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(f) < 12 || f[0] != "5" || strings.TrimSpace(f[11]) == "" {
+			continue
+		}
+		conf, err := strconv.ParseFloat(f[10], 64)
+		if err != nil || conf < 0 {
+			continue
+		}
+		sum += conf
+		words++
+	}
+	if words == 0 {
+		return 0
+	}
+	return roundConfidence(sum / float64(words) / 100)
+}
+
+// textQuality estimates how well a page was read where the OCR reports no
+// confidence, as the share of its words that look like words: letters
+// alone, or numbers. Misreadings mix letters with digits and symbols
+// ("c1ause", "rn0nth", "l|I"). 0 for a page without words.
+func textQuality(text string) float64 {
+	words, good := 0, 0
+	for _, tok := range strings.Fields(text) {
+		tok = strings.Trim(tok, ".,;:!?\"'()[]{}“”‘’-")
+		if tok == "" {
+			continue
+		}
+		words++
+		letters, digits, other := 0, 0, 0
+		for _, r := range tok {
+			switch {
+			case unicode.IsLetter(r) || unicode.IsMark(r):
+				letters++
+			case unicode.IsDigit(r):
+				digits++
+			case strings.ContainsRune(".,/-:%'’", r):
+				// inside numbers, dates and compounds
+			default:
+				other++
+			}
+		}
+		if other == 0 && (letters == 0 || digits == 0) {
+			good++
+		}
+	}
+	if words == 0 {
+		return 0
+	}
+	return roundConfidence(float64(good) / float64(words))
+}
+
+func roundConfidence(c float64) float64 {
+	return math.Round(c*100) / 100
+}
+
 // sortImageFiles sorts image file paths by the page number embedded in the filename.
 func sortImageFiles(files []string) {
 	re := regexp.MustCompile(`(\d+)\.png$`)
@@ -504,6 +587,13 @@ func sarvamOCR(pdfPath, fileName, apiKey string) ([]DocumentChunk, error) {
 			}
 			return nil, err
 		}
+		// Unless its page metadata gave a confidence, judge the pages by
+		// their text
+		for i := range chunks {
+			if chunks[i].OCRConfidence == 0 {
+				chunks[i].OCRConfidence = textQuality(chunks[i].Text)
+			}
+		}
 		return chunks, nil
 	}
 	return nil, fmt.Errorf("sarvam: exhausted retries for %s", fileName)
@@ -554,8 +644,9 @@ func sarvamOCROnce(pdfPath, fileName, apiKey string) ([]DocumentChunk, error) {
 		return nil, fmt.Errorf("sarvam download URL: %w", err)
 	}
 
-	// Step 7: Download and parse output
-	chunks, err := sarvamDownloadAndParse(downloadURL, fileName)
+	// Step 7: Download and parse output, placing its text on the PDF's pages
+	pageCount, _ := pdfPageCount(pdfPath) // 0 if unknown
+	chunks, err := sarvamDownloadAndParse(downloadURL, fileName, pageCount)
 	if err != nil {
 		return nil, fmt.Errorf("sarvam parse output: %w", err)
 	}
@@ -585,8 +676,7 @@ func sarvamRequest(method, url string, body interface{}, apiKey string) (*http.R
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	client := &http.Client{Timeout: 60 * time.Second}
-	return client.Do(req)
+	return httpclient.WithTimeout(60 * time.Second).Do(req)
 }
 
 func sarvamCreateJob(apiKey string) (string, error) {
@@ -691,7 +781,7 @@ func sarvamUploadFile(uploadURL, filePath string) error {
 	req.Header.Set("x-ms-blob-type", "BlockBlob")
 	req.Header.Set("Content-Type", "application/pdf")
 
-	client := &http.Client{Timeout: 120 * time.Second}
+	client := httpclient.WithTimeout(120 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -843,8 +933,8 @@ func sarvamGetDownloadURL(apiKey, jobID string) (string, error) {
 	return "", fmt.Errorf("could not extract download URL from response")
 }
 
-func sarvamDownloadAndParse(downloadURL, fileName string) ([]DocumentChunk, error) {
-	client := &http.Client{Timeout: 120 * time.Second}
+func sarvamDownloadAndParse(downloadURL, fileName string, pageCount int) ([]DocumentChunk, error) {
+	client := httpclient.WithTimeout(120 * time.Second)
 	resp, err := client.Get(downloadURL)
 	if err != nil {
 		return nil, err
@@ -877,13 +967,27 @@ func sarvamDownloadAndParse(downloadURL, fileName string) ([]DocumentChunk, erro
 	}
 	defer zipReader.Close()
 
+	return parseSarvamOutput(&zipReader.Reader, fileName, pageCount)
+}
+
+// parseSarvamOutput reads the pages of a Sarvam job's output ZIP. Its page
+// metadata, a JSON file per page with the page's number and text blocks,
+// places every page exactly; without it, per-page text files are numbered
+// by their names, and a single merged file is split (see
+// splitMergedMarkdownIntoPages). pageCount is the PDF's, 0 if unknown.
+func parseSarvamOutput(zr *zip.Reader, fileName string, pageCount int) ([]DocumentChunk, error) {
+	if chunks := sarvamMetadataPages(zr, fileName, pageCount); len(chunks) > 0 {
+		log.Printf("Sarvam: read %d pages of %s from the page metadata", len(chunks), fileName)
+		return chunks, nil
+	}
+
 	// Collect all text files from the ZIP
 	var allTexts []struct {
 		name string
 		text string
 	}
 
-	for _, f := range zipReader.File {
+	for _, f := range zr.File {
 		if f.FileInfo().IsDir() {
 			continue
 		}
@@ -921,11 +1025,24 @@ func sarvamDownloadAndParse(downloadURL, fileName string) ([]DocumentChunk, erro
 	var chunks []DocumentChunk
 
 	if len(allTexts) > 1 {
-		// Multiple files in ZIP — treat each as a page
+		// Multiple files in ZIP — treat each as a page, numbered by its name
+		// when every name has a number (page_000.md counts from 0)
+		nums := make([]int, len(allTexts))
+		numbered, fromZero := true, false
+		for i, t := range allTexts {
+			nums[i] = extractPageNum(t.name)
+			if !hasPageNum(t.name) {
+				numbered = false
+			}
+			fromZero = fromZero || nums[i] == 0
+		}
 		for i, t := range allTexts {
 			pageNum := i + 1
-			if num := extractPageNum(t.name); num > 0 {
-				pageNum = num
+			switch {
+			case numbered && fromZero:
+				pageNum = nums[i] + 1
+			case numbered:
+				pageNum = nums[i]
 			}
 			chunks = append(chunks, DocumentChunk{
 				PageNumber: pageNum,
@@ -933,10 +1050,10 @@ func sarvamDownloadAndParse(downloadURL, fileName string) ([]DocumentChunk, erro
 				Text:       stripMarkdownFormatting(t.text),
 			})
 		}
+		sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].PageNumber < chunks[j].PageNumber })
 	} else {
-		// Single merged file — split into pages by separators
-		// Sarvam outputs page breaks as "---" (horizontal rules) or "<!-- page X -->" markers
-		chunks = splitMergedMarkdownIntoPages(allTexts[0].text, fileName)
+		// Single merged file — split into pages by markers or separators
+		chunks = splitMergedMarkdownIntoPages(allTexts[0].text, fileName, pageCount)
 	}
 
 	if len(chunks) == 0 {
@@ -946,13 +1063,128 @@ func sarvamDownloadAndParse(downloadURL, fileName string) ([]DocumentChunk, erro
 	return chunks, nil
 }
 
+// sarvamPage is a page of a Sarvam job's page metadata. Which of the page
+// number fields is set varies; a file may also hold several pages.
+type sarvamPage struct {
+	PageNum    *int `json:"page_num"`
+	PageNumber *int `json:"page_number"`
+	Blocks     []struct {
+		Text         string  `json:"text"`
+		ReadingOrder int     `json:"reading_order"`
+		Confidence   float64 `json:"confidence"`
+	} `json:"blocks"`
+	Pages []sarvamPage `json:"pages"`
+}
+
+// sarvamMetadataPages returns the pages the output's JSON page metadata
+// describes, by their real page numbers, with the OCR confidence of their
+// blocks where it's given; nil if the output has none, or it numbers pages
+// past the PDF's pageCount.
+func sarvamMetadataPages(zr *zip.Reader, fileName string, pageCount int) []DocumentChunk {
+	type page struct {
+		num  int
+		meta sarvamPage
+	}
+	var pages []page
+	fromZero := false
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.ToLower(filepath.Ext(f.Name)) != ".json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			continue
+		}
+		var meta sarvamPage
+		err = json.NewDecoder(rc).Decode(&meta)
+		rc.Close()
+		if err != nil {
+			continue
+		}
+		metas := meta.Pages
+		if len(meta.Blocks) > 0 {
+			metas = append(metas, meta)
+		}
+		for _, m := range metas {
+			num := -1
+			switch {
+			case m.PageNum != nil:
+				num = *m.PageNum
+			case m.PageNumber != nil:
+				num = *m.PageNumber
+			case len(meta.Pages) == 0 && hasPageNum(f.Name):
+				num = extractPageNum(f.Name)
+			}
+			if num < 0 {
+				continue
+			}
+			fromZero = fromZero || num == 0
+			pages = append(pages, page{num, m})
+		}
+	}
+
+	var chunks []DocumentChunk
+	seen := make(map[int]bool)
+	for _, p := range pages {
+		num := p.num
+		if fromZero {
+			num++
+		}
+		if pageCount > 0 && num > pageCount {
+			log.Printf("Sarvam: page metadata of %s numbers page %d of %d; ignoring it", fileName, num, pageCount)
+			return nil
+		}
+		blocks := p.meta.Blocks
+		sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].ReadingOrder < blocks[j].ReadingOrder })
+		var texts []string
+		var confSum float64
+		confs := 0
+		for _, b := range blocks {
+			if t := strings.TrimSpace(b.Text); t != "" {
+				texts = append(texts, t)
+			}
+			if b.Confidence > 0 {
+				confSum += b.Confidence
+				confs++
+			}
+		}
+		text := stripMarkdownFormatting(strings.Join(texts, "\n\n"))
+		if len(text) <= 20 || seen[num] {
+			continue
+		}
+		seen[num] = true
+		chunk := DocumentChunk{PageNumber: num, Document: fileName, Text: text}
+		if confs > 0 {
+			conf := confSum / float64(confs)
+			if conf > 1 {
+				conf /= 100 // given as a percentage
+			}
+			chunk.OCRConfidence = roundConfidence(conf)
+		}
+		chunks = append(chunks, chunk)
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].PageNumber < chunks[j].PageNumber })
+	return chunks
+}
+
+// pageMarkerPattern matches the page markers a merged file may carry, a
+// comment like <!-- page 3 --> or a heading like ## Page 3, with the number.
+var pageMarkerPattern = regexp.MustCompile(`(?mi)<!--\s*page[\s:_-]*(\d+)\s*-->|^#{1,2}\s+page\s+(\d+)\b`)
+
 // splitMergedMarkdownIntoPages splits a single merged markdown document into pages.
-// It tries multiple separator strategies:
-// 1. Horizontal rules (---) that act as page breaks
-// 2. Page header patterns (## Page X, # Page X)
+// It tries, in order:
+// 1. Page markers (<!-- page X -->, ## Page X), numbering pages by them
+// 2. Horizontal rules (---) that act as page breaks
 // 3. Form feed characters (\f)
-// 4. Falls back to splitting by word count if no separators found
-func splitMergedMarkdownIntoPages(text, fileName string) []DocumentChunk {
+// 4. Falls back to splitting by word count if no separators found: into
+// pageCount pages of equal length when the PDF's page count is known, so
+// citations land near the right page, else ~500 words a page
+func splitMergedMarkdownIntoPages(text, fileName string, pageCount int) []DocumentChunk {
+	if chunks := splitAtPageMarkers(text, fileName, pageCount); len(chunks) > 0 {
+		log.Printf("Sarvam: split merged output into %d pages at page markers for %s", len(chunks), fileName)
+		return chunks
+	}
+
 	text = stripMarkdownFormatting(text)
 
 	var sections []string
@@ -972,28 +1204,18 @@ func splitMergedMarkdownIntoPages(text, fileName string) []DocumentChunk {
 		}
 	}
 
-	// Try page header patterns
-	if len(sections) <= 1 {
-		pageHeaderRe := regexp.MustCompile(`(?m)^#{1,2}\s+[Pp]age\s+\d+`)
-		indices := pageHeaderRe.FindAllStringIndex(text, -1)
-		if len(indices) > 1 {
-			sections = nil
-			for i, idx := range indices {
-				var end int
-				if i+1 < len(indices) {
-					end = indices[i+1][0]
-				} else {
-					end = len(text)
-				}
-				sections = append(sections, text[idx[0]:end])
-			}
-		}
+	if len(sections) > 1 && pageCount > 0 && len(sections) != pageCount {
+		log.Printf("Sarvam: %s split into %d sections but has %d pages; its page citations may be off", fileName, len(sections), pageCount)
 	}
 
-	// Fallback: split into ~500 word chunks (synthetic pages)
+	// Fallback: split by word count (synthetic pages)
 	if len(sections) <= 1 {
 		words := strings.Fields(text)
 		wordsPerPage := 500
+		if pageCount > 0 {
+			wordsPerPage = max((len(words)+pageCount-1)/pageCount, 1)
+			log.Printf("Sarvam: no page breaks in the output for %s; spreading its text over its %d pages", fileName, pageCount)
+		}
 		for i := 0; i < len(words); i += wordsPerPage {
 			end := i + wordsPerPage
 			if end > len(words) {
@@ -1019,6 +1241,47 @@ func splitMergedMarkdownIntoPages(text, fileName string) []DocumentChunk {
 	return chunks
 }
 
+// splitAtPageMarkers splits text at its page markers (see
+// pageMarkerPattern), each page taking the marker's number; nil if it has
+// fewer than two, or numbers pages past pageCount.
+func splitAtPageMarkers(text, fileName string, pageCount int) []DocumentChunk {
+	marks := pageMarkerPattern.FindAllStringSubmatchIndex(text, -1)
+	if len(marks) < 2 {
+		return nil
+	}
+	var chunks []DocumentChunk
+	for i, m := range marks {
+		numAt := m[2:4]
+		if numAt[0] < 0 {
+			numAt = m[4:6]
+		}
+		num, _ := strconv.Atoi(text[numAt[0]:numAt[1]])
+		if num < 1 || (pageCount > 0 && num > pageCount) {
+			return nil
+		}
+		end := len(text)
+		if i+1 < len(marks) {
+			end = marks[i+1][0]
+		}
+		// A heading marker stays in the page, as the page's own heading
+		start := m[1]
+		if !strings.HasPrefix(text[m[0]:m[1]], "<!--") {
+			start = m[0]
+		}
+		page := stripMarkdownFormatting(text[start:end])
+		page = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(page), "---"))
+		if len(page) > 20 {
+			chunks = append(chunks, DocumentChunk{PageNumber: num, Document: fileName, Text: page})
+		}
+	}
+	return chunks
+}
+
+// hasPageNum reports whether a file name has a number for extractPageNum.
+func hasPageNum(name string) bool {
+	return strings.ContainsAny(filepath.Base(name), "0123456789")
+}
+
 // extractPageNum tries to extract a page number from a filename like "page_001.md" or "1.md"
 func extractPageNum(name string) int {
 	base := filepath.Base(name)
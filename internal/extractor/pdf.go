@@ -14,57 +14,26 @@ type DocumentChunk struct {
 	PageNumber int
 	Text       string
 	Document   string
+	OCR        bool // the text was read by OCR rather than from the text layer
+	// OCRConfidence is how sure the OCR was of the page's text, from 0 to
+	// 1; 0 if it wasn't OCR'd or the OCR couldn't say.
+	OCRConfidence float64
 }
 
 // ExtractPDF extracts text from a PDF, chunked by page.
 // If some or all pages yield no extractable text (scanned PDF), it falls back
 // to OCR using the provided OCRConfig — merging OCR'd pages with text pages.
 func ExtractPDF(filePath string, ocrCfg *OCRConfig) ([]DocumentChunk, error) {
-	f, r, err := pdf.Open(filePath)
+	text, err := ExtractPDFText(filePath)
 	if err != nil {
 		// If the Go library can't open the PDF at all, try OCR directly
 		if ocrCfg != nil && canRunOCR(ocrCfg) {
 			log.Printf("PDF library failed to open %s, attempting OCR fallback: %v", filePath, err)
 			return RunOCR(*ocrCfg, filePath)
 		}
-		return nil, fmt.Errorf("failed to open pdf: %w", err)
-	}
-	defer f.Close()
-
-	// Get filename
-	parts := strings.Split(strings.ReplaceAll(filePath, "\\", "/"), "/")
-	fileName := parts[len(parts)-1]
-
-	var chunks []DocumentChunk
-	var emptyPages []int // pages that yielded no text (might be scanned)
-	numPages := r.NumPage()
-
-	for pageIndex := 1; pageIndex <= numPages; pageIndex++ {
-		p := r.Page(pageIndex)
-		if p.V.IsNull() {
-			emptyPages = append(emptyPages, pageIndex)
-			continue
-		}
-
-		var buf bytes.Buffer
-		str, err := p.GetPlainText(nil)
-		if err != nil {
-			str = ""
-		}
-
-		buf.WriteString(str)
-		text := strings.TrimSpace(buf.String())
-
-		if len(text) > 20 { // meaningful text threshold (skip near-empty pages)
-			chunks = append(chunks, DocumentChunk{
-				PageNumber: pageIndex,
-				Document:   fileName,
-				Text:       text,
-			})
-		} else {
-			emptyPages = append(emptyPages, pageIndex)
-		}
+		return nil, err
 	}
+	fileName, chunks, emptyPages, numPages := text.Document, text.Pages, text.EmptyPages, text.NumPages
 
 	// Decide whether to run OCR
 	if len(chunks) == 0 && numPages > 0 {
@@ -110,6 +79,59 @@ func ExtractPDF(filePath string, ocrCfg *OCRConfig) ([]DocumentChunk, error) {
 	return chunks, nil
 }
 
+// PDFText is the text layer of a PDF, read without OCR.
+type PDFText struct {
+	Document   string
+	NumPages   int
+	Pages      []DocumentChunk // pages with meaningful text
+	EmptyPages []int           // pages that yielded no text (might be scanned)
+}
+
+// ExtractPDFText reads the text layer of a PDF page by page. It never runs
+// OCR, so it is cheap enough to preview what ingestion would need.
+func ExtractPDFText(filePath string) (*PDFText, error) {
+	f, r, err := pdf.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pdf: %w", err)
+	}
+	defer f.Close()
+
+	// Get filename
+	parts := strings.Split(strings.ReplaceAll(filePath, "\\", "/"), "/")
+	out := &PDFText{Document: parts[len(parts)-1], NumPages: r.NumPage()}
+
+	for pageIndex := 1; pageIndex <= out.NumPages; pageIndex++ {
+		p := r.Page(pageIndex)
+		if p.V.IsNull() {
+			out.EmptyPages = append(out.EmptyPages, pageIndex)
+			continue
+		}
+
+		var buf bytes.Buffer
+		str, err := p.GetPlainText(nil)
+		if err != nil {
+			str = ""
+		}
+
+		buf.WriteString(str)
+		text := strings.TrimSpace(buf.String())
+
+		if len(text) > 20 { // meaningful text threshold (skip near-empty pages)
+			out.Pages = append(out.Pages, DocumentChunk{
+				PageNumber: pageIndex,
+				Document:   out.Document,
+				Text:       text,
+			})
+		} else {
+			out.EmptyPages = append(out.EmptyPages, pageIndex)
+		}
+	}
+	return out, nil
+}
+
+// Enabled reports whether OCR can be attempted with cfg.
+func (cfg *OCRConfig) Enabled() bool { return canRunOCR(cfg) }
+
 // canRunOCR checks if OCR can be attempted with the given config.
 // Returns true if an explicit provider is set, OR if Tesseract is available
 // (auto-detect mode), OR if a Sarvam key is configured.
@@ -0,0 +1,76 @@
+package extractor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// Page rendering limits, in dots per inch.
+const (
+	MinRenderDPI     = 50
+	MaxRenderDPI     = 300
+	DefaultRenderDPI = 110
+)
+
+// RenderPage renders one page (1-based) of a PDF to PNG, with the same
+// converters OCR uses: pdftoppm (Poppler) first, then ImageMagick.
+func RenderPage(pdfPath string, page, dpi int) ([]byte, error) {
+	if page < 1 {
+		return nil, fmt.Errorf("invalid page %d", page)
+	}
+	if dpi < MinRenderDPI || dpi > MaxRenderDPI {
+		return nil, fmt.Errorf("dpi must be between %d and %d", MinRenderDPI, MaxRenderDPI)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gocognigo-render-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	out := filepath.Join(tmpDir, "page")
+
+	var convertErr error
+	if pdftoppmPath, lookErr := exec.LookPath("pdftoppm"); lookErr == nil {
+		p := strconv.Itoa(page)
+		cmd := exec.Command(pdftoppmPath, "-png", "-r", strconv.Itoa(dpi), "-f", p, "-l", p, "-singlefile", pdfPath, out)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			convertErr = fmt.Errorf("pdftoppm: %v (stderr: %s)", err, stderr.String())
+		} else {
+			return readRendered(out + ".png")
+		}
+	}
+
+	if magickPath, lookErr := exec.LookPath("magick"); lookErr == nil {
+		// ImageMagick uses 0-based page indices
+		pdfArg := fmt.Sprintf("%s[%d]", pdfPath, page-1)
+		cmd := exec.Command(magickPath, "convert", "-density", strconv.Itoa(dpi), pdfArg, out+".png")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			convertErr = fmt.Errorf("magick: %v (stderr: %s)", err, stderr.String())
+		} else {
+			return readRendered(out + ".png")
+		}
+	}
+
+	if convertErr != nil {
+		return nil, fmt.Errorf("cannot render page %d: %w", page, convertErr)
+	}
+	return nil, fmt.Errorf("cannot render pages: install Poppler (pdftoppm) or ImageMagick (magick)")
+}
+
+// readRendered reads a converter's output, which is missing when the page
+// is past the end of the document.
+func readRendered(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("page not found in document")
+	}
+	return data, err
+}
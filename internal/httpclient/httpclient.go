@@ -0,0 +1,57 @@
+// Package httpclient is the outbound HTTP client shared by the LLM
+// providers, embedders and OCR services: one connection pool, the proxy
+// from the environment, and timeouts so a provider that stops responding
+// fails the call instead of blocking it forever. It also tracks providers'
+// rate limits and rotates requests across multi-key settings.
+package httpclient
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// TimeoutEnv sets how many seconds a provider may take to start answering a
+// request before it fails. Streamed answers start at once; others answer
+// whole, so it bounds the longest non-streamed completion.
+const TimeoutEnv = "PROVIDER_TIMEOUT_SECONDS"
+
+const defaultTimeout = 5 * time.Minute
+
+// responseTimeout is TimeoutEnv, or defaultTimeout if unset or invalid.
+func responseTimeout() time.Duration {
+	if n, err := strconv.Atoi(os.Getenv(TimeoutEnv)); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return defaultTimeout
+}
+
+// Transport is the connection pool every client here shares. It uses
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY from the environment.
+var Transport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+	ResponseHeaderTimeout: responseTimeout(),
+}
+
+// Default has no overall deadline, so a streamed answer can run as long as
+// its request's context allows; Transport bounds the wait for a response.
+// Its 429s are recorded for ThrottledFor.
+var Default = &http.Client{Transport: throttleTransport{Transport}}
+
+// WithTimeout returns a client on the shared pool whose requests, body
+// included, must finish within timeout.
+func WithTimeout(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: throttleTransport{Transport}, Timeout: timeout}
+}
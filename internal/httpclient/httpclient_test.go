@@ -0,0 +1,155 @@
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResponseTimeout(t *testing.T) {
+	t.Setenv(TimeoutEnv, "")
+	if got := responseTimeout(); got != defaultTimeout {
+		t.Errorf("unset: %v, want %v", got, defaultTimeout)
+	}
+	t.Setenv(TimeoutEnv, "90")
+	if got := responseTimeout(); got != 90*time.Second {
+		t.Errorf("90: %v", got)
+	}
+	t.Setenv(TimeoutEnv, "soon")
+	if got := responseTimeout(); got != defaultTimeout {
+		t.Errorf("invalid: %v, want the default", got)
+	}
+}
+
+func TestWithTimeout_FailsAHungRequest(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	if _, err := WithTimeout(50 * time.Millisecond).Get(srv.URL); err == nil {
+		t.Fatal("request to a server that never answers succeeded")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("gave up after %v", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+	}{
+		{"none", nil, 0},
+		{"seconds", map[string]string{"Retry-After": "7"}, 7 * time.Second},
+		{"date", map[string]string{"Retry-After": now.Add(30 * time.Second).Format(http.TimeFormat)}, 30 * time.Second},
+		{"milliseconds win", map[string]string{"retry-after-ms": "1500", "Retry-After": "2"}, 1500 * time.Millisecond},
+		{"openai exhausted tokens", map[string]string{
+			"x-ratelimit-remaining-requests": "12", "x-ratelimit-reset-requests": "1s",
+			"x-ratelimit-remaining-tokens": "0", "x-ratelimit-reset-tokens": "6m0s",
+		}, 6 * time.Minute},
+		{"openai not exhausted", map[string]string{"x-ratelimit-remaining-requests": "3", "x-ratelimit-reset-requests": "20ms"}, 0},
+		{"anthropic", map[string]string{
+			"anthropic-ratelimit-input-tokens-remaining": "0",
+			"anthropic-ratelimit-input-tokens-reset":     now.Add(12 * time.Second).Format(time.RFC3339),
+		}, 12 * time.Second},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		for k, v := range c.header {
+			h.Set(k, v)
+		}
+		if got := RetryAfter(h, now); got != c.want {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDefault_RecordsThrottledHosts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	resp, err := Default.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	host := resp.Request.URL.Host
+	if wait := ThrottledFor(host, ""); wait <= 25*time.Second || wait > 30*time.Second {
+		t.Errorf("ThrottledFor = %v, want about 30s", wait)
+	}
+	var found bool
+	for _, th := range Throttled() {
+		found = found || th.Host == host
+	}
+	if !found {
+		t.Errorf("%s missing from Throttled()", host)
+	}
+	if ThrottledFor("elsewhere.example", "") != 0 {
+		t.Error("an unrelated host is throttled")
+	}
+}
+
+func TestDefault_RotatesAcrossKeys(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mu.Lock()
+		seen = append(seen, key+":"+string(body))
+		mu.Unlock()
+		switch key {
+		case "key-limited":
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "key-revoked":
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	const setting = "key-limited, key-revoked,key-good"
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("POST", srv.URL, strings.NewReader("q"))
+		req.Header.Set("Authorization", "Bearer "+setting)
+		resp, err := Default.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, resp.StatusCode)
+		}
+	}
+
+	// The first request works through all three keys, resending its body;
+	// after that only the good key is used
+	want := []string{"key-limited:q", "key-revoked:q", "key-good:q", "key-good:q", "key-good:q"}
+	if strings.Join(seen, " ") != strings.Join(want, " ") {
+		t.Errorf("requests = %v, want %v", seen, want)
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if ThrottledFor(host, setting) != 0 {
+		t.Error("setting reported throttled while one of its keys is free")
+	}
+	if ThrottledFor(host, "key-limited") == 0 {
+		t.Error("limited key not reported throttled")
+	}
+}
@@ -0,0 +1,170 @@
+package httpclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A key setting may hold several API keys for one provider, separated by
+// commas or whitespace. Requests sent with such a setting as their
+// credential (an "Authorization: Bearer" or "x-api-key" header) are spread
+// across its keys round-robin. A key that is rate-limited or rejected is
+// skipped while another is usable, and a request it fails is sent again at
+// once on the next key, so one exhausted key doesn't stall the rest.
+
+// rejectedFor is how long a key the provider refused (401/403) is left out.
+const rejectedFor = 10 * time.Minute
+
+// SplitKeys returns the keys in a key setting.
+func SplitKeys(setting string) []string {
+	return strings.FieldsFunc(setting, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// KeyCount is the number of keys in a key setting, at least 1.
+func KeyCount(setting string) int {
+	return max(len(SplitKeys(setting)), 1)
+}
+
+// fingerprint identifies a key in memory without holding on to it.
+func fingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// maskKey shows a key's last four characters.
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return "..." + key[len(key)-4:]
+}
+
+// keyPool is the rotation state of one key setting.
+type keyPool struct {
+	mu       sync.Mutex
+	keys     []string
+	next     int
+	rejected map[string]time.Time // by key, until when it is left out
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = map[string]*keyPool{}
+)
+
+// poolFor returns the shared pool of a multi-key setting, so every client
+// using it rotates and tracks health together.
+func poolFor(setting string) *keyPool {
+	id := fingerprint(setting)
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	p, ok := pools[id]
+	if !ok {
+		p = &keyPool{keys: SplitKeys(setting), rejected: map[string]time.Time{}}
+		pools[id] = p
+	}
+	return p
+}
+
+// pick returns the next usable key for host in round-robin order, skipping
+// keys in tried, rate-limited or rejected. ok is false when none is usable;
+// key is then the one that becomes usable soonest, so the request is still
+// sent and the provider decides.
+func (p *keyPool) pick(host string, tried map[string]bool) (key string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var fallback string
+	var soonest time.Time
+	for i := range p.keys {
+		k := p.keys[(p.next+i)%len(p.keys)]
+		if tried[k] {
+			continue
+		}
+		until := throttledUntil(host, k)
+		if r := p.rejected[k]; r.After(until) {
+			until = r
+		}
+		if !until.After(now) {
+			p.next = (p.next + i + 1) % len(p.keys)
+			return k, true
+		}
+		if fallback == "" || until.Before(soonest) {
+			fallback, soonest = k, until
+		}
+	}
+	if fallback == "" {
+		fallback = p.keys[p.next%len(p.keys)]
+	}
+	p.next = (p.next + 1) % len(p.keys)
+	return fallback, false
+}
+
+func (p *keyPool) reject(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rejected[key] = time.Now().Add(rejectedFor)
+}
+
+// credential finds the API key header of req: its name, the value prefix
+// before the key, and the key (or key setting) itself.
+func credential(req *http.Request) (header, prefix, value string) {
+	if v := req.Header.Get("x-api-key"); v != "" {
+		return "x-api-key", "", v
+	}
+	if v, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		return "Authorization", "Bearer ", v
+	}
+	return "", "", ""
+}
+
+// rotate sends req on the keys of a multi-key setting: on the next usable
+// key, then again on a fresh key for as long as the answer is a rate limit
+// or a rejected key and a usable key remains.
+func rotate(base http.RoundTripper, req *http.Request, header, prefix, setting string) (*http.Response, error) {
+	pool := poolFor(setting)
+	host := req.URL.Host
+	tried := map[string]bool{}
+	var last *http.Response
+	for {
+		key, ok := pool.pick(host, tried)
+		attempt := req.Clone(req.Context())
+		if last != nil {
+			// Only send again if there is a fresh key and a body to resend
+			if !ok || (req.Body != nil && req.GetBody == nil) {
+				return last, nil
+			}
+			io.Copy(io.Discard, last.Body)
+			last.Body.Close()
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attempt.Body = body
+			}
+		}
+		tried[key] = true
+		attempt.Header.Set(header, prefix+key)
+
+		resp, err := roundTrip(base, attempt, key)
+		if err != nil {
+			return resp, err
+		}
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			pool.reject(key)
+		case http.StatusTooManyRequests:
+		default:
+			return resp, nil
+		}
+		last = resp
+	}
+}
@@ -0,0 +1,161 @@
+package httpclient
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Throttle is an API key that a host rate-limited, and when the host said
+// to try again.
+type Throttle struct {
+	Host  string    `json:"host"`
+	Key   string    `json:"key,omitempty"` // masked
+	Until time.Time `json:"until"`
+}
+
+var (
+	throttleMu sync.Mutex
+	throttled  = map[string]Throttle{} // by host and key fingerprint
+)
+
+func throttleID(host, key string) string {
+	return host + " " + fingerprint(key)
+}
+
+// throttledUntil is when host's rate limit on key resets, if it has one.
+func throttledUntil(host, key string) time.Time {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	return throttled[throttleID(host, key)].Until
+}
+
+// ThrottledFor returns how long host asked callers using keys (a key
+// setting, see SplitKeys) to hold off in its last 429: 0 once any of the
+// keys is free again. Every caller sharing the pool sees it, so concurrent
+// requests to a limited provider wait instead of piling on.
+func ThrottledFor(host, keys string) time.Duration {
+	split := SplitKeys(keys)
+	if len(split) == 0 {
+		split = []string{""}
+	}
+	var wait time.Duration
+	for i, key := range split {
+		d := time.Until(throttledUntil(host, key))
+		if d <= 0 {
+			return 0
+		}
+		if i == 0 || d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// Throttled lists the keys currently rate-limited, by host.
+func Throttled() []Throttle {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	now := time.Now()
+	var out []Throttle
+	for id, t := range throttled {
+		if !t.Until.After(now) {
+			delete(throttled, id)
+			continue
+		}
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Host != out[j].Host {
+			return out[i].Host < out[j].Host
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func noteThrottle(host, key string, until time.Time) {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	id := throttleID(host, key)
+	if until.After(throttled[id].Until) {
+		t := Throttle{Host: host, Until: until}
+		if key != "" {
+			t.Key = maskKey(key)
+		}
+		throttled[id] = t
+	}
+}
+
+// RetryAfter reads how long a response asks the caller to wait before
+// retrying: retry-after-ms or Retry-After (seconds or a date) if present,
+// otherwise the latest reset among the OpenAI (x-ratelimit-*) and Anthropic
+// (anthropic-ratelimit-*) limits that are used up. It returns 0 when the
+// response says nothing.
+func RetryAfter(h http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(h.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			if secs > 0 {
+				return time.Duration(secs * float64(time.Second))
+			}
+		} else if at, err := http.ParseTime(v); err == nil && at.After(now) {
+			return at.Sub(now)
+		}
+	}
+
+	var wait time.Duration
+	for _, limit := range []string{"requests", "tokens"} {
+		if h.Get("x-ratelimit-remaining-"+limit) != "0" {
+			continue
+		}
+		if d, err := time.ParseDuration(h.Get("x-ratelimit-reset-" + limit)); err == nil && d > wait {
+			wait = d
+		}
+	}
+	for _, limit := range []string{"requests", "tokens", "input-tokens", "output-tokens"} {
+		if h.Get("anthropic-ratelimit-"+limit+"-remaining") != "0" {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339, h.Get("anthropic-ratelimit-"+limit+"-reset")); err == nil && at.Sub(now) > wait {
+			wait = at.Sub(now)
+		}
+	}
+	return wait
+}
+
+// throttleTransport records the wait a 429 (or an overloaded 503/529 that
+// says when to come back) asks for against the request's host and API key,
+// and spreads requests whose credential is a multi-key setting across its
+// keys (see rotate).
+type throttleTransport struct {
+	base http.RoundTripper
+}
+
+func (t throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header, prefix, key := credential(req)
+	if len(SplitKeys(key)) > 1 {
+		return rotate(t.base, req, header, prefix, key)
+	}
+	return roundTrip(t.base, req, key)
+}
+
+// roundTrip sends req, which carries key, noting any rate limit it hits.
+func roundTrip(base http.RoundTripper, req *http.Request, key string) (*http.Response, error) {
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, 529:
+		now := time.Now()
+		if wait := RetryAfter(resp.Header, now); wait > 0 {
+			noteThrottle(req.URL.Host, key, now.Add(wait))
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,49 @@
+package indexer
+
+import "strings"
+
+// ==================== Embedding Cost ====================
+
+// DefaultOpenAIEmbedModel is the OpenAI embedding model used when none is
+// configured.
+const DefaultOpenAIEmbedModel = "text-embedding-3-small"
+
+// embeddingPrices are list prices in USD per million tokens. Hugging Face
+// Inference models are billed by compute time, not tokens, so have none.
+var embeddingPrices = map[string]float64{
+	"text-embedding-3-small": 0.02,
+	"text-embedding-3-large": 0.13,
+	"text-embedding-ada-002": 0.10,
+}
+
+// EmbedModelName resolves the model a provider embeds with when model is
+// empty.
+func EmbedModelName(provider, model string) string {
+	if model != "" {
+		return model
+	}
+	if strings.EqualFold(provider, "huggingface") {
+		return DefaultHuggingFaceEmbedModel
+	}
+	return DefaultOpenAIEmbedModel
+}
+
+// EstimateEmbeddingTokens approximates the tokens sent to embed chunks, at
+// ~4 characters per token.
+func EstimateEmbeddingTokens(chunks []Chunk) int {
+	n := 0
+	for _, c := range chunks {
+		n += len(c.Text) / 4
+	}
+	return n
+}
+
+// EmbeddingCost returns the approximate USD cost of embedding tokens with
+// model. ok is false for models without a known price.
+func EmbeddingCost(model string, tokens int) (usd float64, ok bool) {
+	price, ok := embeddingPrices[model]
+	if !ok {
+		return 0, false
+	}
+	return float64(tokens) * price / 1e6, true
+}
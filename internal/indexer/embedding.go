@@ -0,0 +1,184 @@
+package indexer
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ==================== Embedding Provenance ====================
+
+// Vectors are only comparable with queries embedded by the same model, so
+// the vectors file records the models its chunks were embedded with. An
+// index opened with other embedders (say, after the embedding provider was
+// changed in settings) would otherwise rank chunks by noise.
+
+// EmbeddingInfo identifies the embedding models of an index.
+type EmbeddingInfo struct {
+	Provider     string `json:"provider"`
+	Model        string `json:"model"`
+	Multilingual string `json:"multilingual_model,omitempty"` // "" if non-English pages use Model too
+}
+
+func (e EmbeddingInfo) String() string {
+	s := e.Provider + "/" + e.Model
+	if e.Multilingual != "" {
+		s += " (multilingual: " + e.Multilingual + ")"
+	}
+	return s
+}
+
+// embeddingDims are the dimensions of known models' embeddings, to tell
+// from the vectors themselves which files written before EmbeddingInfo was
+// recorded can't have come from the current model.
+var embeddingDims = map[string]int{
+	"text-embedding-3-small":                  1536,
+	"text-embedding-3-large":                  3072,
+	"text-embedding-ada-002":                  1536,
+	"BAAI/bge-small-en-v1.5":                  384,
+	"BAAI/bge-base-en-v1.5":                   768,
+	"BAAI/bge-large-en-v1.5":                  1024,
+	"BAAI/bge-m3":                             1024,
+	"sentence-transformers/all-MiniLM-L6-v2":  384,
+	"sentence-transformers/all-mpnet-base-v2": 768,
+	"intfloat/multilingual-e5-large":          1024,
+}
+
+// EmbeddingMismatchError says an index's vectors came from other embedding
+// models than the ones its queries would be embedded with.
+type EmbeddingMismatchError struct {
+	Stored  *EmbeddingInfo // nil if the vectors file predates recording it
+	Current EmbeddingInfo
+	// Dimensions and Want are set when only the vectors' dimensions tell
+	Dimensions, Want int
+}
+
+func (e *EmbeddingMismatchError) Error() string {
+	if e.Stored == nil {
+		return fmt.Sprintf("the index's vectors have %d dimensions but %s embeds queries with %d, so they come from another embedding model",
+			e.Dimensions, e.Current, e.Want)
+	}
+	return fmt.Sprintf("the index's vectors were embedded with %s but queries would be embedded with %s", e.Stored, e.Current)
+}
+
+// Embedding returns the models the index embeds chunks and queries with.
+func (idx *Index) Embedding() EmbeddingInfo {
+	return EmbeddingInfo{Provider: idx.embedProvider, Model: idx.modelName(), Multilingual: idx.multilingualModel}
+}
+
+// savedEmbedding is the EmbeddingInfo SaveVectors records, nil for an
+// index not made by NewIndex. Chunks a previous multilingual model embedded
+// keep it on record while no multilingual model is set.
+func (idx *Index) savedEmbedding() *EmbeddingInfo {
+	if idx.embedProvider == "" {
+		return nil
+	}
+	info := idx.Embedding()
+	if info.Multilingual == "" && idx.stored != nil {
+		info.Multilingual = idx.stored.Multilingual
+	}
+	return &info
+}
+
+// CheckEmbedding reports an *EmbeddingMismatchError if the loaded vectors
+// were embedded with other models than the index's embedders: by the
+// models the vectors file records or, for older files, by the dimensions
+// of the vectors when those of the current model are known.
+func (idx *Index) CheckEmbedding() error {
+	chunks, _ := idx.View()
+	idx.mu.RLock()
+	stored := idx.stored
+	idx.mu.RUnlock()
+	return idx.embeddingMismatch(stored, chunks)
+}
+
+func (idx *Index) embeddingMismatch(stored *EmbeddingInfo, chunks []Chunk) error {
+	if idx.embedProvider == "" {
+		return nil
+	}
+	current := idx.Embedding()
+	if stored != nil {
+		multilingualChanged := stored.Multilingual != "" && current.Multilingual != "" && stored.Multilingual != current.Multilingual
+		if !strings.EqualFold(stored.Provider, current.Provider) || stored.Model != current.Model || multilingualChanged {
+			return &EmbeddingMismatchError{Stored: stored, Current: current}
+		}
+		return nil
+	}
+	want, ok := embeddingDims[current.Model]
+	if !ok {
+		return nil
+	}
+	for _, c := range chunks {
+		if len(c.Embedding) == 0 || c.Multilingual {
+			continue
+		}
+		if len(c.Embedding) != want {
+			return &EmbeddingMismatchError{Current: current, Dimensions: len(c.Embedding), Want: want}
+		}
+		return nil
+	}
+	return nil
+}
+
+// ReadEmbeddingInfo reads which models the vectors at path (as passed to
+// LoadVectors) were embedded with, without loading them. ok is false if
+// the file doesn't record it.
+func ReadEmbeddingInfo(path string) (info EmbeddingInfo, ok bool) {
+	gobPath := strings.TrimSuffix(path, ".json") + ".gob"
+	if f, err := os.Open(gobPath); err == nil {
+		defer f.Close()
+		var header vectorHeader
+		if gob.NewDecoder(f).Decode(&header) == nil && header.StreamVersion > 0 {
+			if header.Embedding == nil {
+				return EmbeddingInfo{}, false
+			}
+			return *header.Embedding, true
+		}
+	}
+
+	// The JSON records it ahead of the chunks, so reading stops there
+	f, err := os.Open(path)
+	if err != nil {
+		return EmbeddingInfo{}, false
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return EmbeddingInfo{}, false
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil || key == "chunks" {
+			return EmbeddingInfo{}, false
+		}
+		if key == "embedding" {
+			var stored EmbeddingInfo
+			if dec.Decode(&stored) != nil {
+				return EmbeddingInfo{}, false
+			}
+			return stored, true
+		}
+		var skip json.RawMessage
+		if dec.Decode(&skip) != nil {
+			return EmbeddingInfo{}, false
+		}
+	}
+	return EmbeddingInfo{}, false
+}
+
+// StripEmbeddings takes the index's chunks for embedding again with its
+// current embedders: it empties the index and returns the chunks without
+// their embeddings, and forgets the models they were embedded with.
+func (idx *Index) StripEmbeddings() []Chunk {
+	idx.mu.Lock()
+	chunks := idx.Chunks
+	idx.Chunks = nil
+	idx.stored = nil
+	idx.mu.Unlock()
+	for i := range chunks {
+		chunks[i].Embedding = nil
+	}
+	return chunks
+}
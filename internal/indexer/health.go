@@ -0,0 +1,134 @@
+package indexer
+
+import "fmt"
+
+// ==================== Index Health ====================
+
+// Health is a consistency check of an index: its vectors against each
+// other and against the keyword index.
+type Health struct {
+	Chunks              int      `json:"chunks"`
+	Documents           int      `json:"documents"`
+	DocSummaries        int      `json:"doc_summaries"`
+	BM25Docs            uint64   `json:"bm25_docs"`
+	Dimensions          int      `json:"dimensions"` // of the primary embedder's vectors
+	MissingEmbeddings   int      `json:"missing_embeddings"`
+	DimensionMismatches int      `json:"dimension_mismatches"`
+	DuplicateIDs        int      `json:"duplicate_ids"`
+	Problems            []string `json:"problems"`
+}
+
+// Check inspects the index for chunks without embeddings, embeddings whose
+// dimensions differ from the rest of their embedder's, vectors from other
+// models than the index embeds queries with (see CheckEmbedding), duplicate
+// chunk IDs and a keyword index out of step with the vectors or older than
+// its current mapping (see IdentifiersField). Problems lists what a
+// rebuild or re-embed would fix; it is empty for a healthy index.
+func (idx *Index) Check() Health {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	h := Health{Chunks: len(idx.Chunks), DocSummaries: len(idx.DocSummaries), Problems: []string{}}
+	docs := make(map[string]bool)
+	ids := make(map[string]bool)
+	// The usual dimension per embedder: the primary, and the multilingual one
+	dims := map[bool]map[int]int{false: {}, true: {}}
+	for _, c := range idx.Chunks {
+		docs[c.Document] = true
+		if ids[c.ID] {
+			h.DuplicateIDs++
+		}
+		ids[c.ID] = true
+		if len(c.Embedding) == 0 {
+			h.MissingEmbeddings++
+			continue
+		}
+		dims[c.Multilingual][len(c.Embedding)]++
+	}
+	h.Documents = len(docs)
+
+	usual := map[bool]int{}
+	for group, counts := range dims {
+		for dim, n := range counts {
+			if n > counts[usual[group]] || (n == counts[usual[group]] && dim > usual[group]) {
+				usual[group] = dim
+			}
+		}
+		for dim, n := range counts {
+			if dim != usual[group] {
+				h.DimensionMismatches += n
+			}
+		}
+	}
+	h.Dimensions = usual[false]
+
+	if idx.BM25Index != nil {
+		if n, err := idx.BM25Index.DocCount(); err == nil {
+			h.BM25Docs = n
+		} else {
+			h.Problems = append(h.Problems, fmt.Sprintf("keyword index unreadable: %v", err))
+		}
+	}
+
+	if h.MissingEmbeddings > 0 {
+		h.Problems = append(h.Problems, fmt.Sprintf("%d chunks have no embedding", h.MissingEmbeddings))
+	}
+	if h.DimensionMismatches > 0 {
+		h.Problems = append(h.Problems, fmt.Sprintf("%d embeddings differ in dimensions from the rest (mixed embedding models)", h.DimensionMismatches))
+	}
+	if err := idx.embeddingMismatch(idx.stored, idx.Chunks); err != nil {
+		h.Problems = append(h.Problems, err.Error())
+	}
+	if h.DuplicateIDs > 0 {
+		h.Problems = append(h.Problems, fmt.Sprintf("%d chunks have duplicate IDs", h.DuplicateIDs))
+	}
+	if idx.BM25Index != nil && h.BM25Docs != uint64(len(ids)) {
+		h.Problems = append(h.Problems, fmt.Sprintf("keyword index has %d entries for %d chunks", h.BM25Docs, len(ids)))
+	}
+	if idx.BM25Index != nil && !hasIdentifiers(idx.BM25Index) {
+		h.Problems = append(h.Problems, "keyword index predates exact identifier matching")
+	}
+	return h
+}
+
+// ==================== Memory Footprint ====================
+
+// chunkOverhead approximates what a chunk costs in memory beyond its text
+// and embedding: the Chunk struct itself, string and slice headers, and the
+// allocator's slack.
+const chunkOverhead = 256
+
+// MemoryEstimate approximates the bytes the index holds in memory: chunk
+// text, embeddings and document summaries. The keyword index lives on disk
+// and is not counted.
+func (idx *Index) MemoryEstimate() int64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var n int64
+	for i := range idx.Chunks {
+		c := &idx.Chunks[i]
+		n += chunkOverhead
+		n += int64(len(c.ID) + len(c.Document) + len(c.Text) + len(c.ParentText) + len(c.Section) + len(c.Language) + len(c.Level))
+		n += 4 * int64(len(c.Embedding))
+		for _, e := range c.Entities {
+			n += 32 + int64(len(e.Text)+len(e.Type))
+		}
+		for _, d := range c.Definitions {
+			n += 32 + int64(len(d.Term)+len(d.Meaning))
+		}
+		for _, p := range c.PII {
+			n += 16 + int64(len(p))
+		}
+	}
+	for _, s := range idx.DocSummaries {
+		n += chunkOverhead + int64(len(s.Document)+len(s.Title)+len(s.DocType)+len(s.Summary))
+		for _, sec := range s.Sections {
+			n += 64 + int64(len(sec.Name))
+		}
+		for _, e := range s.KeyEntities {
+			n += 16 + int64(len(e))
+		}
+	}
+	return n
+}
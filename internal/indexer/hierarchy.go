@@ -0,0 +1,90 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==================== Summary Nodes ====================
+
+// Besides page chunks, an index can hold summary nodes: one per section and
+// one per document, embedded and searched like any chunk. A broad question
+// ("what are the main risks across the portfolio?") matches a document's
+// overview better than any single page. Nodes carry a Level; leaf chunks
+// have none.
+
+// Summary node levels.
+const (
+	LevelSection  = "section"
+	LevelDocument = "document"
+)
+
+// IsSummary reports whether c is a summary node rather than page text.
+func (c Chunk) IsSummary() bool {
+	return c.Level != ""
+}
+
+// SectionSummary is the summary of one section of a document.
+type SectionSummary struct {
+	Name      string `json:"name"`
+	PageStart int    `json:"page_start"`
+	PageEnd   int    `json:"page_end"`
+	Summary   string `json:"summary"`
+}
+
+// SummaryNodes builds the summary node chunks of a document: one per
+// summarized section and one for the whole document, whose text combines
+// the document summary with its sections'. Their embeddings are left empty.
+func SummaryNodes(doc DocumentSummary, sections []SectionSummary, totalPages int) []Chunk {
+	title := doc.Title
+	if title == "" {
+		title = doc.Document
+	}
+
+	var nodes []Chunk
+	var outline strings.Builder
+	for i, s := range sections {
+		if strings.TrimSpace(s.Summary) == "" {
+			continue
+		}
+		text := fmt.Sprintf("Summary of section %q (pages %d-%d) of %s: %s", s.Name, s.PageStart, s.PageEnd, title, s.Summary)
+		nodes = append(nodes, Chunk{
+			ID:         fmt.Sprintf("%s_sec%d", doc.Document, i),
+			Document:   doc.Document,
+			PageNumber: s.PageStart,
+			PageEnd:    s.PageEnd,
+			Text:       text,
+			ParentText: text,
+			Section:    s.Name,
+			Level:      LevelSection,
+		})
+		outline.WriteString(fmt.Sprintf("\n- %s (pp.%d-%d): %s", s.Name, s.PageStart, s.PageEnd, s.Summary))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Summary of %s", title))
+	if doc.DocType != "" {
+		sb.WriteString(fmt.Sprintf(" (%s)", doc.DocType))
+	}
+	sb.WriteString(": " + doc.Summary)
+	if len(doc.KeyEntities) > 0 {
+		sb.WriteString("\nKey entities: " + strings.Join(doc.KeyEntities, ", "))
+	}
+	if outline.Len() > 0 {
+		sb.WriteString("\nSections:" + outline.String())
+	}
+	text := sb.String()
+	if totalPages < 1 {
+		totalPages = 1
+	}
+	nodes = append(nodes, Chunk{
+		ID:         doc.Document + "_doc",
+		Document:   doc.Document,
+		PageNumber: 1,
+		PageEnd:    totalPages,
+		Text:       text,
+		ParentText: text,
+		Level:      LevelDocument,
+	})
+	return nodes
+}
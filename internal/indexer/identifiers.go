@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+// ==================== Exact Identifiers ====================
+
+// IdentifiersField is the keyword-index field that holds a chunk's
+// identifiers (see Identifiers) untokenized, so that a query for one
+// matches exactly the chunks that cite it.
+const IdentifiersField = "ids"
+
+// bm25Mapping is the keyword index's mapping: chunk text goes through the
+// standard analyzer, identifiers through the keyword analyzer.
+func bm25Mapping() mapping.IndexMapping {
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping.AddFieldMappingsAt(IdentifiersField, bleve.NewKeywordFieldMapping())
+	return m
+}
+
+// hasIdentifiers reports whether bm indexes identifiers untokenized. Keyword
+// indexes created before IdentifiersField existed don't, until rebuilt.
+func hasIdentifiers(bm bleve.Index) bool {
+	m, ok := bm.Mapping().(*mapping.IndexMappingImpl)
+	if !ok || m.DefaultMapping == nil {
+		return false
+	}
+	fields := m.DefaultMapping.Properties[IdentifiersField]
+	return fields != nil && len(fields.Fields) > 0 && fields.Fields[0].Analyzer == keyword.Name
+}
+
+// Identifiers returns the tokens of text that look like identifiers —
+// section numbers like 14.2(b), case numbers like 2019-CV-0042, invoice
+// numbers — lowercased, without the punctuation around them, each once. A
+// token qualifies if it has a digit and either another character or at
+// least five digits. The standard analyzer splits these apart and drops
+// their punctuation, so "text" alone can't tell 14.2(b) from 14.2 or 2(b).
+func Identifiers(text string) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, tok := range strings.Fields(text) {
+		id := trimIdentifier(strings.ToLower(tok))
+		if !isIdentifier(id) || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func isIdentifier(tok string) bool {
+	digits, others := 0, 0
+	for _, r := range tok {
+		if unicode.IsDigit(r) {
+			digits++
+		} else {
+			others++
+		}
+	}
+	return digits > 0 && (others > 0 || digits >= 5)
+}
+
+// trimIdentifier strips sentence punctuation, quotes and section signs off
+// tok, and brackets that are unbalanced or enclose all of it, keeping those
+// that belong to it, as in 14.2(b).
+func trimIdentifier(tok string) string {
+	for {
+		before := tok
+		tok = strings.TrimRight(tok, ".,;:!?'\"”’")
+		tok = strings.TrimLeft(tok, "'\"“‘§¶#")
+		for _, p := range [][2]string{{"(", ")"}, {"[", "]"}} {
+			open, closed := strings.Count(tok, p[0]), strings.Count(tok, p[1])
+			switch {
+			case strings.HasPrefix(tok, p[0]) && strings.HasSuffix(tok, p[1]) && open == closed && balancedWithin(tok[1:len(tok)-1], p):
+				tok = tok[1 : len(tok)-1]
+			case strings.HasPrefix(tok, p[0]) && open > closed:
+				tok = tok[1:]
+			case strings.HasSuffix(tok, p[1]) && closed > open:
+				tok = tok[:len(tok)-1]
+			}
+		}
+		if tok == before {
+			return tok
+		}
+	}
+}
+
+// balancedWithin reports whether s never closes a bracket pair p it didn't
+// open, i.e. whether brackets around s enclose all of it rather than, as in
+// (a)(b), opening one group and closing another.
+func balancedWithin(s string, p [2]string) bool {
+	depth := 0
+	for _, r := range s {
+		switch string(r) {
+		case p[0]:
+			depth++
+		case p[1]:
+			if depth--; depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
@@ -3,18 +3,23 @@ package indexer
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
+	"gocognigo/internal/analysis"
 	"gocognigo/internal/extractor"
+	"gocognigo/internal/httpclient"
 
 	"github.com/blevesearch/bleve/v2"
 	"github.com/sashabaranov/go-openai"
@@ -40,13 +45,28 @@ type DocumentSummary struct {
 // Chunk represents a piece of text to be embedded and indexed.
 // Text is a small search chunk (~150 words); ParentText is the full page for LLM context.
 type Chunk struct {
-	ID         string    `json:"id"`
+	ID         string    `json:"id"` // see chunkID
 	Document   string    `json:"document"`
 	PageNumber int       `json:"page_number"`
 	Text       string    `json:"text"`        // small search chunk
 	ParentText string    `json:"parent_text"` // full page text (sent to LLM)
 	Section    string    `json:"section"`     // section name from doc summary
-	Embedding  []float32 `json:"embedding"`
+	Embedding  []float32 `json:"embedding"`   // unit length (see Normalize), so cosine similarity is a dot product
+
+	Entities    []analysis.Entity     `json:"entities,omitempty"`    // extracted from Text at chunking time
+	Definitions []analysis.Definition `json:"definitions,omitempty"` // terms defined on the page; set on its first chunk only
+
+	Language     string `json:"language,omitempty"`     // ISO 639-1 code of the page's language, "" if undetermined
+	Multilingual bool   `json:"multilingual,omitempty"` // embedded with the index's Multilingual embedder
+
+	PII []string `json:"pii,omitempty"` // kinds of personal identifier in Text, when the project tags them
+
+	OCRConfidence float64 `json:"ocr_confidence,omitempty"` // how sure the OCR was of the page, 0 to 1; 0 if not OCR'd or unknown
+
+	// Level is set on summary nodes (see SummaryNodes), which span pages
+	// PageNumber to PageEnd; page chunks have neither.
+	Level   string `json:"level,omitempty"`
+	PageEnd int    `json:"page_end,omitempty"`
 }
 
 // EmbeddingProvider defines the interface for embeddings
@@ -64,20 +84,69 @@ type Index struct {
 	DocSummaries []DocumentSummary
 	BM25Index    bleve.Index
 	Embedder     EmbeddingProvider
-	mu           sync.Mutex // protects Chunks during concurrent writes
+	// Multilingual, if set, embeds non-English chunks instead of Embedder
+	// (see SetMultilingual).
+	Multilingual EmbeddingProvider
+	// BatchSize and Concurrency, when > 0, override the embedders' own
+	// texts per call and parallel calls (e.g. to throttle a rate-limited key).
+	BatchSize   int
+	Concurrency int
+	// ChunkWords and ChunkOverlap, when > 0, replace ChunkPages' default
+	// chunks of 150 words overlapping by 30.
+	ChunkWords   int
+	ChunkOverlap int
+	// Fuzziness is how many edits (at most 2) a keyword of a query may be
+	// off by and still match in BM25 search, for text OCR misread;
+	// retrievers over the index search with it.
+	Fuzziness int
+	// MinOCRConfidence, when > 0, is the OCR confidence below which
+	// retrievers over the index rank a chunk lower.
+	MinOCRConfidence float64
+	// Gate, if set, is called before each embedding batch and may block,
+	// e.g. while ingestion is paused; an error abandons the batch.
+	Gate func(ctx context.Context) error
+	// mu guards Chunks and DocSummaries. Both are only ever appended to or
+	// replaced, never edited in place, so a View stays valid while the
+	// index changes.
+	mu sync.RWMutex
+
+	embedProvider     string // "openai" or "huggingface"; "" if not made by NewIndex
+	embedModel        string
+	multilingualModel string
+	// stored is what the loaded vectors file records of the models its
+	// chunks were embedded with, nil if nothing (see CheckEmbedding).
+	stored *EmbeddingInfo
+	// reuse supplies embeddings in place of the embedders (see SetReuse).
+	reuse *EmbeddingCache
 }
 
 // Lock acquires the index mutex. Use when reading Chunks from outside the package.
 func (idx *Index) Lock()   { idx.mu.Lock() }
 func (idx *Index) Unlock() { idx.mu.Unlock() }
 
+// View returns the index's chunks and summaries as of now, for searching
+// while ingestion appends to it. Its capacity is clipped, so appending to
+// the view copies rather than writing into the index's backing array.
+func (idx *Index) View() ([]Chunk, []DocumentSummary) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	n, m := len(idx.Chunks), len(idx.DocSummaries)
+	return idx.Chunks[:n:n], idx.DocSummaries[:m:m]
+}
+
+// ChunkCount returns how many chunks the index holds.
+func (idx *Index) ChunkCount() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.Chunks)
+}
+
 func NewIndex(providerName, apiKey, modelName, bm25Path string) (*Index, error) {
 	var bmIndex bleve.Index
 	var err error
 
 	if _, statErr := os.Stat(bm25Path); os.IsNotExist(statErr) {
-		mapping := bleve.NewIndexMapping()
-		bmIndex, err = bleve.New(bm25Path, mapping)
+		bmIndex, err = bleve.New(bm25Path, bm25Mapping())
 		if err != nil {
 			return nil, err
 		}
@@ -88,28 +157,71 @@ func NewIndex(providerName, apiKey, modelName, bm25Path string) (*Index, error)
 		}
 	}
 
-	var embedder EmbeddingProvider
-	providerName = strings.ToLower(providerName)
-	switch providerName {
+	embedder, err := NewEmbedder(providerName, apiKey, modelName)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := strings.ToLower(providerName)
+	if provider == "" {
+		provider = "openai"
+	}
+	return &Index{
+		Chunks:        []Chunk{},
+		BM25Index:     bmIndex,
+		Embedder:      embedder,
+		embedProvider: provider,
+		embedModel:    modelName,
+	}, nil
+}
+
+// SetMultilingual routes chunks of non-English pages to a multilingual
+// embedding model on the same provider. Queries are then embedded with both
+// models and each chunk is scored against the query embedding of its own
+// model.
+func (idx *Index) SetMultilingual(providerName, apiKey, modelName string) error {
+	embedder, err := NewEmbedder(providerName, apiKey, modelName)
+	if err != nil {
+		return err
+	}
+	idx.Multilingual = embedder
+	idx.multilingualModel = modelName
+	return nil
+}
+
+// englishOnlyModel reports whether an embedding model is trained on
+// English text only, judging by its name. OpenAI's models are multilingual.
+func englishOnlyModel(model string) bool {
+	m := strings.ToLower(model)
+	if strings.Contains(m, "multilingual") || strings.Contains(m, "m3") {
+		return false
+	}
+	return strings.Contains(m, "-en") || strings.Contains(m, "minilm") || strings.Contains(m, "mpnet")
+}
+
+// DefaultHuggingFaceEmbedModel is the HuggingFace embedding model used when
+// none is configured. It is English-only.
+const DefaultHuggingFaceEmbedModel = "BAAI/bge-small-en-v1.5"
+
+// NewEmbedder creates the embedding provider for providerName ("openai" or
+// "huggingface"), defaulting modelName per provider when empty.
+func NewEmbedder(providerName, apiKey, modelName string) (EmbeddingProvider, error) {
+	switch strings.ToLower(providerName) {
 	case "huggingface":
 		if modelName == "" {
-			modelName = "BAAI/bge-small-en-v1.5"
+			modelName = DefaultHuggingFaceEmbedModel
 		}
-		embedder = newHuggingFaceEmbedder(apiKey, modelName)
+		return newHuggingFaceEmbedder(apiKey, modelName), nil
 	case "openai", "":
 		if modelName == "" {
-			modelName = "text-embedding-3-small"
+			modelName = DefaultOpenAIEmbedModel
 		}
-		embedder = &OpenAIEmbedder{client: openai.NewClient(apiKey), model: modelName}
+		cfg := openai.DefaultConfig(apiKey)
+		cfg.HTTPClient = httpclient.Default
+		return &OpenAIEmbedder{client: openai.NewClientWithConfig(cfg), apiKey: apiKey, model: modelName}, nil
 	default:
 		return nil, fmt.Errorf("unknown embedding provider: %s", providerName)
 	}
-
-	return &Index{
-		Chunks:    []Chunk{},
-		BM25Index: bmIndex,
-		Embedder:  embedder,
-	}, nil
 }
 
 // AddDocument is the backward-compatible wrapper (no progress callback).
@@ -117,8 +229,8 @@ func (idx *Index) AddDocument(ctx context.Context, docChunks []extractor.Documen
 	return idx.AddDocumentWithProgress(ctx, docChunks, nil)
 }
 
-// ChunkPages splits extracted document pages into small (~150-word) search chunks
-// linked to their full-page parent text. This is a pure function on the Index
+// ChunkPages splits extracted document pages into small (~150-word, see
+// ChunkWords) search chunks linked to their full-page parent text. This is a pure function on the Index
 // (only reads DocSummaries for section lookup) and is safe to call concurrently.
 func (idx *Index) ChunkPages(docChunks []extractor.DocumentChunk) []Chunk {
 	var indexChunks []Chunk
@@ -130,8 +242,9 @@ func (idx *Index) ChunkPages(docChunks []extractor.DocumentChunk) []Chunk {
 		parentText := page.Text
 		section := sectionMap.lookup(page.Document, page.PageNumber)
 		words := strings.Fields(page.Text)
-		chunkSize := 150
-		overlap := 30
+		chunkSize, overlap := idx.chunkShape()
+		definitions := analysis.ExtractDefinitions(page.Text)
+		language := analysis.DetectLanguage(page.Text)
 
 		for i := 0; i < len(words); i += (chunkSize - overlap) {
 			end := i + chunkSize
@@ -140,16 +253,21 @@ func (idx *Index) ChunkPages(docChunks []extractor.DocumentChunk) []Chunk {
 			}
 			textChunk := strings.Join(words[i:end], " ")
 
-			id := fmt.Sprintf("%s_p%d_c%d", page.Document, page.PageNumber, len(indexChunks))
-
 			indexChunks = append(indexChunks, Chunk{
-				ID:         id,
+				ID:         chunkID(page.Document, page.PageNumber, i, textChunk),
 				Document:   page.Document,
 				PageNumber: page.PageNumber,
 				Text:       textChunk,
 				ParentText: parentText,
 				Section:    section,
+				Entities:   analysis.ExtractEntities(textChunk),
+				Language:   language,
+
+				OCRConfidence: page.OCRConfidence,
 			})
+			if i == 0 {
+				indexChunks[len(indexChunks)-1].Definitions = definitions
+			}
 
 			if end == len(words) {
 				break
@@ -160,6 +278,99 @@ func (idx *Index) ChunkPages(docChunks []extractor.DocumentChunk) []Chunk {
 	return indexChunks
 }
 
+// Default chunk shape, in words.
+const (
+	defaultChunkWords   = 150
+	defaultChunkOverlap = 30
+)
+
+// chunkShape returns the words per chunk and the overlap between chunks.
+// An overlap that would leave a chunk no new words is cut to a fifth of it.
+func (idx *Index) chunkShape() (size, overlap int) {
+	size, overlap = defaultChunkWords, defaultChunkOverlap
+	if idx.ChunkWords > 0 {
+		size = idx.ChunkWords
+	}
+	if idx.ChunkOverlap > 0 {
+		overlap = idx.ChunkOverlap
+	}
+	if overlap >= size {
+		overlap = size / 5
+	}
+	return size, overlap
+}
+
+// chunkID identifies a chunk by where it is and what it says: its document,
+// page and starting word on the page, and a hash of its text. Chunking the
+// same pages again, in any order or batch, gives the same IDs, so caches,
+// feedback and links that name a chunk survive re-ingestion while a chunk
+// whose text changed gets a new one.
+func chunkID(document string, page, offset int, text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return fmt.Sprintf("%s_p%d_w%d_%x", document, page, offset, sum[:6])
+}
+
+// Glossary collects the terms defined across chunks, one entry per page
+// defining a term. Pages chunked before definitions were extracted are
+// scanned from their ParentText.
+func Glossary(chunks []Chunk) []analysis.GlossaryEntry {
+	var g GlossaryBuilder
+	g.Add(chunks)
+	return g.Entries
+}
+
+// GlossaryBuilder collects Glossary over chunks added a batch at a time,
+// e.g. as an index loads.
+type GlossaryBuilder struct {
+	Entries  []analysis.GlossaryEntry
+	seenPage map[string]bool
+}
+
+// Add adds the terms defined in chunks.
+func (g *GlossaryBuilder) Add(chunks []Chunk) {
+	if g.seenPage == nil {
+		g.seenPage = map[string]bool{}
+	}
+	for _, c := range chunks {
+		if c.IsSummary() {
+			continue
+		}
+		page := fmt.Sprintf("%s\x00%d", c.Document, c.PageNumber)
+		defs := c.Definitions
+		if defs == nil {
+			if g.seenPage[page] {
+				continue
+			}
+			text := c.ParentText
+			if text == "" {
+				text = c.Text
+			}
+			defs = analysis.ExtractDefinitions(text)
+		}
+		g.seenPage[page] = true
+		for _, d := range defs {
+			g.Entries = append(g.Entries, analysis.GlossaryEntry{Term: d.Term, Meaning: d.Meaning, Document: c.Document, Page: c.PageNumber})
+		}
+	}
+}
+
+// embedRetryWait is how long to wait before retrying a failed batch: until
+// the first of the embedder's keys is free of its API's rate limit (at most
+// two minutes, so a long quota reset still fails the ingestion in
+// reasonable time), else exponential backoff from 3s to 20s.
+func embedRetryWait(embedder EmbeddingProvider, attempt int) time.Duration {
+	if e, ok := embedder.(interface{ endpoint() (host, key string) }); ok {
+		if limited := httpclient.ThrottledFor(e.endpoint()); limited > 0 {
+			return min(limited, 2*time.Minute)
+		}
+	}
+	wait := time.Duration(3*(1<<uint(attempt))) * time.Second
+	if wait > 20*time.Second {
+		wait = 20 * time.Second
+	}
+	return wait
+}
+
 // EmbedAndIndex embeds a slice of chunks and adds them to both the vector and BM25 indexes.
 // It processes in batches of 200 with up to 6 concurrent API calls, with retry logic.
 // Thread-safe: multiple goroutines can call this on the same Index.
@@ -168,10 +379,69 @@ func (idx *Index) EmbedAndIndex(ctx context.Context, chunks []Chunk, progress Pr
 		return nil
 	}
 
-	totalChunks := len(chunks)
+	// Route non-English chunks to the multilingual embedder, if there is one
+	var primary, multilingual []Chunk
+	foreign := 0
+	for _, c := range chunks {
+		nonEnglish := c.Language != "" && c.Language != "en"
+		c.Multilingual = nonEnglish && idx.Multilingual != nil
+		if c.Multilingual {
+			multilingual = append(multilingual, c)
+		} else {
+			primary = append(primary, c)
+		}
+		if nonEnglish {
+			foreign++
+		}
+	}
+	if foreign > 0 && idx.Multilingual == nil && englishOnlyModel(idx.modelName()) {
+		log.Printf("Warning: embedding %d non-English chunks with English-only model %s; configure a multilingual embedding model to route them", foreign, idx.modelName())
+	}
+
+	if err := idx.embedChunks(ctx, idx.Embedder, primary, progress, len(chunks), progressOffset); err != nil {
+		return err
+	}
+	return idx.embedChunks(ctx, idx.Multilingual, multilingual, progress, len(chunks), progressOffset+len(primary))
+}
 
-	// Use provider-specific batch size and concurrency
-	batchSize := idx.Embedder.BatchSize()
+// modelName is the primary embedding model's name, resolving the default.
+func (idx *Index) modelName() string {
+	if idx.embedModel == "" {
+		switch idx.Embedder.(type) {
+		case *HuggingFaceEmbedder:
+			return DefaultHuggingFaceEmbedModel
+		case *OpenAIEmbedder:
+			return DefaultOpenAIEmbedModel
+		}
+	}
+	return idx.embedModel
+}
+
+// embedChunks embeds chunks with embedder in concurrent batches and adds
+// them to the index, reporting progress out of totalChunks.
+func (idx *Index) embedChunks(ctx context.Context, embedder EmbeddingProvider, chunks []Chunk, progress ProgressFunc, totalChunks, progressOffset int) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	// Chunks whose text was embedded before take that embedding
+	if rest := idx.addReused(chunks); len(rest) < len(chunks) {
+		reused := len(chunks) - len(rest)
+		progressOffset += reused
+		if progress != nil {
+			progress(totalChunks, progressOffset)
+		}
+		log.Printf("Reused the embeddings of %d / %d chunks", reused, len(chunks))
+		if chunks = rest; len(chunks) == 0 {
+			return nil
+		}
+	}
+
+	// Use provider-specific batch size and concurrency unless overridden
+	batchSize := embedder.BatchSize()
+	if idx.BatchSize > 0 {
+		batchSize = idx.BatchSize
+	}
 	type batchJob struct {
 		start int
 		end   int
@@ -186,7 +456,10 @@ func (idx *Index) EmbedAndIndex(ctx context.Context, chunks []Chunk, progress Pr
 	}
 
 	// Run embedding batches with provider-specific concurrency
-	concurrency := idx.Embedder.MaxConcurrency()
+	concurrency := embedder.MaxConcurrency()
+	if idx.Concurrency > 0 {
+		concurrency = idx.Concurrency
+	}
 	sem := make(chan struct{}, concurrency)
 	var wg sync.WaitGroup
 	var firstErr error
@@ -207,6 +480,12 @@ func (idx *Index) EmbedAndIndex(ctx context.Context, chunks []Chunk, progress Pr
 			defer wg.Done()
 			defer func() { <-sem }()
 
+			if idx.Gate != nil {
+				if err := idx.Gate(ctx); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					return
+				}
+			}
 			if ctx.Err() != nil {
 				errOnce.Do(func() { firstErr = ctx.Err() })
 				return
@@ -226,15 +505,12 @@ func (idx *Index) EmbedAndIndex(ctx context.Context, chunks []Chunk, progress Pr
 					errOnce.Do(func() { firstErr = ctx.Err() })
 					return
 				}
-				embeddings, err = idx.Embedder.Embed(ctx, inputs)
+				embeddings, err = embedder.Embed(ctx, inputs)
 				if err == nil {
 					break
 				}
 				if attempt < 4 {
-					wait := time.Duration(3*(1<<uint(attempt))) * time.Second
-					if wait > 20*time.Second {
-						wait = 20 * time.Second
-					}
+					wait := embedRetryWait(embedder, attempt)
 					log.Printf("Embedding batch retry %d after %v: %v", attempt+1, wait, err)
 					timer := time.NewTimer(wait)
 					select {
@@ -256,15 +532,10 @@ func (idx *Index) EmbedAndIndex(ctx context.Context, chunks []Chunk, progress Pr
 			// Write results (thread-safe)
 			idx.mu.Lock()
 			for k, emb := range embeddings {
-				batch[k].Embedding = emb
+				batch[k].Embedding = Normalize(emb)
 				idx.Chunks = append(idx.Chunks, batch[k])
 
-				bm25Err := idx.BM25Index.Index(batch[k].ID, map[string]interface{}{
-					"id":   batch[k].ID,
-					"text": batch[k].Text,
-					"doc":  batch[k].Document,
-					"page": batch[k].PageNumber,
-				})
+				bm25Err := idx.BM25Index.Index(batch[k].ID, bm25Fields(batch[k]))
 				if bm25Err != nil {
 					log.Printf("Failed to index BM25 for %s: %v", batch[k].ID, bm25Err)
 				}
@@ -304,6 +575,72 @@ func (idx *Index) AddDocumentWithProgress(ctx context.Context, docChunks []extra
 	return idx.EmbedAndIndex(ctx, indexChunks, progress, 0)
 }
 
+// bm25Fields is the keyword-index document for a chunk.
+func bm25Fields(c Chunk) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   c.ID,
+		"text": c.Text,
+		"doc":  c.Document,
+		"page": c.PageNumber,
+
+		IdentifiersField: Identifiers(c.Text),
+	}
+}
+
+// Snapshot returns an in-memory index over already-embedded chunks that
+// shares idx's embedders and document summaries, e.g. to search an earlier
+// version of a corpus. Close it when done.
+func (idx *Index) Snapshot(chunks []Chunk) (*Index, error) {
+	bm, err := bleve.NewMemOnly(bm25Mapping())
+	if err != nil {
+		return nil, err
+	}
+	if err := indexBM25(bm, chunks); err != nil {
+		return nil, err
+	}
+	return &Index{
+		Chunks:       chunks,
+		DocSummaries: idx.DocSummaries,
+		BM25Index:    bm,
+		Embedder:     idx.Embedder,
+		Multilingual: idx.Multilingual,
+		Fuzziness:    idx.Fuzziness,
+
+		MinOCRConfidence: idx.MinOCRConfidence,
+
+		embedProvider:     idx.embedProvider,
+		embedModel:        idx.embedModel,
+		multilingualModel: idx.multilingualModel,
+		stored:            idx.stored,
+	}, nil
+}
+
+// ReindexBM25 adds every chunk to the keyword index, to rebuild it from the
+// vectors after it was lost or corrupted. It doesn't embed anything.
+func (idx *Index) ReindexBM25() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return indexBM25(idx.BM25Index, idx.Chunks)
+}
+
+// indexBM25 adds chunks to bm in batches.
+func indexBM25(bm bleve.Index, chunks []Chunk) error {
+	const batchSize = 1000
+	batch := bm.NewBatch()
+	for i, c := range chunks {
+		if err := batch.Index(c.ID, bm25Fields(c)); err != nil {
+			return err
+		}
+		if (i+1)%batchSize == 0 {
+			if err := bm.Batch(batch); err != nil {
+				return err
+			}
+			batch = bm.NewBatch()
+		}
+	}
+	return bm.Batch(batch)
+}
+
 // sectionLookup maps document+page to section names.
 type sectionLookup struct {
 	summaries []DocumentSummary
@@ -327,19 +664,77 @@ func (sl sectionLookup) lookup(doc string, page int) string {
 	return ""
 }
 
+// VectorsVersion is the format SaveVectors writes. Files of every earlier
+// version still load, and loading one rewrites it in this version (see
+// migrateVectors), so upgrading never needs re-ingestion:
+//
+//	0: before versions were recorded: vectors.json alone, as a vectorStore
+//	   or just the chunks array, or vectors.gob as a single vectorStore
+//	1: vectors.gob streamed a batch of chunks at a time (see vectorHeader),
+//	   each chunk carrying its page's text
+//	2: vectors.gob with a page table (see vectorBatch): each page's text is
+//	   stored once, and the chunks loaded from it share one copy
+//
+// vectors.json keeps each chunk's page text whatever the version, so older
+// builds and project archives can read it.
+const VectorsVersion = 2
+
 // vectorStore wraps chunks and summaries for serialization.
 type vectorStore struct {
+	Version int `json:"version,omitempty"` // see VectorsVersion
+
+	// Normalized is set once every embedding is unit length; files written
+	// before it are normalized as they load. It and Embedding (nil in files
+	// written before it) precede chunks in the JSON so a streaming load, and
+	// ReadEmbeddingInfo, know them before reading the chunks.
+	Normalized   bool              `json:"normalized,omitempty"`
+	Embedding    *EmbeddingInfo    `json:"embedding,omitempty"`
 	Chunks       []Chunk           `json:"chunks"`
 	DocSummaries []DocumentSummary `json:"doc_summaries,omitempty"`
 }
 
+// vectorHeader opens the binary vectors file, which is followed by the
+// chunks in batches of up to vectorBatchSize — each a vectorBatch or, in
+// version 1, a []Chunk — so that large indexes can be loaded, and searched,
+// a batch at a time.
+type vectorHeader struct {
+	StreamVersion int // the file's VectorsVersion; zero when decoding a file written as one vectorStore
+	ChunkCount    int
+	DocSummaries  []DocumentSummary
+	Normalized    bool
+	Embedding     *EmbeddingInfo
+}
+
+// vectorBatch is a batch of chunks in a binary vectors file, stored without
+// their ParentText: Parents holds each chunk's index in the page table,
+// which every batch extends with Pages, the texts it is the first to use.
+type vectorBatch struct {
+	Pages   []string
+	Parents []int32
+	Chunks  []Chunk
+}
+
+const vectorBatchSize = 2000
+
 // Save Vector index to disk in both binary (fast) and JSON (fallback) formats.
 func (idx *Index) SaveVectors(path string) error {
 	store := vectorStore{
+		Version:      VectorsVersion,
+		Normalized:   true,
+		Embedding:    idx.savedEmbedding(),
 		Chunks:       idx.Chunks,
 		DocSummaries: idx.DocSummaries,
 	}
 
+	// The file now records the index's own models
+	idx.mu.Lock()
+	idx.stored = store.Embedding
+	idx.mu.Unlock()
+
+	return idx.writeVectors(path, store)
+}
+
+func (idx *Index) writeVectors(path string, store vectorStore) error {
 	// Save binary format (primary — 5-10x faster to load)
 	gobPath := strings.TrimSuffix(path, ".json") + ".gob"
 	if err := idx.saveVectorsBinary(gobPath, store); err != nil {
@@ -362,45 +757,229 @@ func (idx *Index) saveVectorsBinary(path string, store vectorStore) error {
 		return err
 	}
 	defer f.Close()
-	return gob.NewEncoder(f).Encode(store)
+	enc := gob.NewEncoder(f)
+	if err := enc.Encode(vectorHeader{StreamVersion: VectorsVersion, ChunkCount: len(store.Chunks), DocSummaries: store.DocSummaries, Normalized: store.Normalized, Embedding: store.Embedding}); err != nil {
+		return err
+	}
+	pages := make(map[string]int32)
+	for i := 0; i < len(store.Chunks); i += vectorBatchSize {
+		chunks := store.Chunks[i:min(i+vectorBatchSize, len(store.Chunks))]
+		// Copied, not edited in place: the index keeps its page texts
+		batch := vectorBatch{Parents: make([]int32, len(chunks)), Chunks: make([]Chunk, len(chunks))}
+		copy(batch.Chunks, chunks)
+		for j := range batch.Chunks {
+			text := batch.Chunks[j].ParentText
+			n, ok := pages[text]
+			if !ok {
+				n = int32(len(pages))
+				pages[text] = n
+				batch.Pages = append(batch.Pages, text)
+			}
+			batch.Parents[j] = n
+			batch.Chunks[j].ParentText = ""
+		}
+		if err := enc.Encode(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateVectors rewrites the vectors at path, just loaded from a file of
+// the given version, in the current one; vectors loaded from a current
+// vectors.json only get their binary file back. The chunks were upgraded as
+// they loaded (their embeddings normalized, their page texts shared), and
+// the models they were embedded with stay on record. A failed rewrite
+// leaves the old files, which load as before.
+func (idx *Index) migrateVectors(path string, version int) {
+	idx.mu.RLock()
+	store := vectorStore{
+		Version:      VectorsVersion,
+		Normalized:   true,
+		Embedding:    idx.stored,
+		Chunks:       idx.Chunks,
+		DocSummaries: idx.DocSummaries,
+	}
+	idx.mu.RUnlock()
+	if version == VectorsVersion {
+		gobPath := strings.TrimSuffix(path, ".json") + ".gob"
+		if err := idx.saveVectorsBinary(gobPath, store); err != nil {
+			log.Printf("Warning: failed to save binary vectors: %v", err)
+		} else {
+			log.Printf("Rebuilt binary vectors %s from JSON", gobPath)
+		}
+		return
+	}
+	if err := idx.writeVectors(path, store); err != nil {
+		log.Printf("Warning: failed to upgrade %s from format %d: %v", path, version, err)
+		return
+	}
+	log.Printf("Upgraded %s from format %d to %d", path, version, VectorsVersion)
 }
 
+// LoadProgress is told, after each batch of a progressive load, how many
+// chunks are loaded and what fraction of the file has been read.
+type LoadProgress func(loaded int, fraction float64)
+
 // Load Vectors from disk — tries binary (fast) first, falls back to JSON.
 func (idx *Index) LoadVectors(path string) error {
+	return idx.LoadVectorsProgressive(path, nil)
+}
+
+// LoadVectorsProgressive loads vectors like LoadVectors, a batch at a time:
+// idx.Chunks grows as the file is read, and progress, if set, is called
+// after each batch on the loading goroutine, where the chunks loaded so far
+// can safely be snapshot (e.g. with retriever.NewRetriever) and searched
+// while the rest loads. Vectors loaded from JSON or from a binary file of
+// an older VectorsVersion are then saved again in the current one.
+func (idx *Index) LoadVectorsProgressive(path string, progress LoadProgress) error {
 	start := time.Now()
+	idx.resetLoaded()
 
 	// Try binary format first (5-10x faster)
 	gobPath := strings.TrimSuffix(path, ".json") + ".gob"
 	if _, err := os.Stat(gobPath); err == nil {
-		if err := idx.loadVectorsBinary(gobPath); err == nil {
+		version, err := idx.loadVectorsBinary(gobPath, progress)
+		if err == nil {
 			log.Printf("Loaded %d chunks from binary in %v", len(idx.Chunks), time.Since(start))
+			if version < VectorsVersion {
+				idx.migrateVectors(path, version)
+			}
 			return nil
 		}
 		log.Printf("Binary load failed, falling back to JSON: %v", err)
+		idx.resetLoaded()
 	}
 
-	// Fallback: JSON format
-	data, err := os.ReadFile(path)
+	// Fallback: JSON format, then the binary file is written from it
+	version, err := idx.loadVectorsJSON(path, progress)
 	if err != nil {
+		idx.resetLoaded()
 		return err
 	}
-	// Try new format (with summaries) first
-	var store vectorStore
-	if err := json.Unmarshal(data, &store); err == nil && len(store.Chunks) > 0 {
-		idx.Chunks = store.Chunks
-		idx.DocSummaries = store.DocSummaries
-		log.Printf("Loaded %d chunks from JSON in %v", len(idx.Chunks), time.Since(start))
-		return nil
+	log.Printf("Loaded %d chunks from JSON in %v", len(idx.Chunks), time.Since(start))
+	idx.migrateVectors(path, version)
+	return nil
+}
+
+func (idx *Index) resetLoaded() {
+	idx.mu.Lock()
+	idx.Chunks = nil
+	idx.DocSummaries = nil
+	idx.stored = nil
+	idx.mu.Unlock()
+}
+
+// loadTracker counts the bytes read from a vectors file and appends
+// loaded batches to the index, reporting progress.
+type loadTracker struct {
+	r         io.Reader
+	read      int64
+	size      int64
+	idx       *Index
+	progress  LoadProgress
+	normalize bool   // the file predates normalized embeddings
+	prevPage  string // the last chunk's page text, which the next may share
+}
+
+func (t *loadTracker) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	return n, err
+}
+
+func (t *loadTracker) add(batch []Chunk) {
+	if t.normalize {
+		normalizeChunks(batch)
 	}
-	// Fallback: legacy format (just chunks array)
-	if err := json.Unmarshal(data, &idx.Chunks); err != nil {
-		return err
+	// A file without a page table repeats each page's text in every chunk
+	// of the page; those loaded one after another share one copy
+	for i := range batch {
+		if batch[i].ParentText == t.prevPage {
+			batch[i].ParentText = t.prevPage
+		}
+		t.prevPage = batch[i].ParentText
 	}
-	log.Printf("Loaded %d chunks from legacy JSON in %v", len(idx.Chunks), time.Since(start))
-	return nil
+	t.idx.mu.Lock()
+	t.idx.Chunks = append(t.idx.Chunks, batch...)
+	loaded := len(t.idx.Chunks)
+	t.idx.mu.Unlock()
+	if t.progress != nil && t.size > 0 {
+		t.progress(loaded, min(float64(t.read)/float64(t.size), 1))
+	}
+}
+
+func openVectors(path string, idx *Index, progress LoadProgress) (*os.File, *loadTracker, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := &loadTracker{r: f, idx: idx, progress: progress, normalize: true}
+	if fi, err := f.Stat(); err == nil {
+		t.size = fi.Size()
+	}
+	return f, t, nil
+}
+
+// loadVectorsBinary loads a vectors.gob, returning its VectorsVersion.
+func (idx *Index) loadVectorsBinary(path string, progress LoadProgress) (int, error) {
+	f, t, err := openVectors(path, idx, progress)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	dec := gob.NewDecoder(t)
+	var header vectorHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, err
+	}
+	version := header.StreamVersion
+	switch {
+	case version == 0:
+		// Written as a single vectorStore, before loads were progressive
+		return 0, idx.loadVectorsLegacyBinary(path)
+	case version > VectorsVersion:
+		return version, fmt.Errorf("%s is in format %d, newer than this build reads (%d)", path, version, VectorsVersion)
+	}
+
+	t.normalize = !header.Normalized
+	idx.mu.Lock()
+	// Capacity for every chunk, so snapshots taken mid-load keep sharing
+	// one backing array with the chunks appended after them
+	idx.Chunks = make([]Chunk, 0, header.ChunkCount)
+	idx.DocSummaries = header.DocSummaries
+	idx.stored = header.Embedding
+	idx.mu.Unlock()
+	var pages []string
+	for len(idx.Chunks) < header.ChunkCount {
+		var batch []Chunk
+		if version == 1 {
+			if err := dec.Decode(&batch); err != nil {
+				return version, err
+			}
+		} else {
+			var vb vectorBatch
+			if err := dec.Decode(&vb); err != nil {
+				return version, err
+			}
+			if len(vb.Parents) != len(vb.Chunks) {
+				return version, fmt.Errorf("batch of %d chunks has %d page references", len(vb.Chunks), len(vb.Parents))
+			}
+			pages = append(pages, vb.Pages...)
+			for i, p := range vb.Parents {
+				if p < 0 || int(p) >= len(pages) {
+					return version, fmt.Errorf("chunk %s refers to page %d of %d", vb.Chunks[i].ID, p, len(pages))
+				}
+				vb.Chunks[i].ParentText = pages[p]
+			}
+			batch = vb.Chunks
+		}
+		t.add(batch)
+	}
+	return version, nil
 }
 
-func (idx *Index) loadVectorsBinary(path string) error {
+func (idx *Index) loadVectorsLegacyBinary(path string) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
@@ -410,11 +989,112 @@ func (idx *Index) loadVectorsBinary(path string) error {
 	if err := gob.NewDecoder(f).Decode(&store); err != nil {
 		return err
 	}
+	normalizeChunks(store.Chunks)
+	idx.mu.Lock()
 	idx.Chunks = store.Chunks
 	idx.DocSummaries = store.DocSummaries
+	idx.stored = store.Embedding
+	idx.mu.Unlock()
 	return nil
 }
 
+// loadVectorsJSON streams a vectors.json: a vectorStore object or, in the
+// legacy format, just the chunks array. It returns the file's
+// VectorsVersion.
+func (idx *Index) loadVectorsJSON(path string, progress LoadProgress) (version int, err error) {
+	f, t, err := openVectors(path, idx, progress)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(t)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, err
+	}
+	switch tok {
+	case json.Delim('['):
+		return 0, decodeChunkArray(dec, t)
+	case json.Delim('{'):
+	default:
+		return 0, fmt.Errorf("unexpected vectors file content: %v", tok)
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return version, err
+		}
+		switch key {
+		case "version":
+			if err := dec.Decode(&version); err != nil {
+				return version, err
+			}
+		case "normalized":
+			var normalized bool
+			if err := dec.Decode(&normalized); err != nil {
+				return version, err
+			}
+			t.normalize = !normalized
+		case "embedding":
+			var stored EmbeddingInfo
+			if err := dec.Decode(&stored); err != nil {
+				return version, err
+			}
+			idx.mu.Lock()
+			idx.stored = &stored
+			idx.mu.Unlock()
+		case "chunks":
+			tok, err := dec.Token()
+			if err != nil {
+				return version, err
+			}
+			if tok == json.Delim('[') {
+				if err := decodeChunkArray(dec, t); err != nil {
+					return version, err
+				}
+			}
+		case "doc_summaries":
+			var summaries []DocumentSummary
+			if err := dec.Decode(&summaries); err != nil {
+				return version, err
+			}
+			idx.mu.Lock()
+			idx.DocSummaries = summaries
+			idx.mu.Unlock()
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return version, err
+			}
+		}
+	}
+	_, err = dec.Token()
+	return version, err
+}
+
+// decodeChunkArray reads the elements of a JSON chunk array whose opening
+// bracket has been read, and its closing one, adding them in batches.
+func decodeChunkArray(dec *json.Decoder, t *loadTracker) error {
+	batch := make([]Chunk, 0, vectorBatchSize)
+	for dec.More() {
+		var c Chunk
+		if err := dec.Decode(&c); err != nil {
+			return err
+		}
+		batch = append(batch, c)
+		if len(batch) == vectorBatchSize {
+			t.add(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		t.add(batch)
+	}
+	_, err := dec.Token()
+	return err
+}
+
 // AddDocSummary appends a document summary in a thread-safe way.
 func (idx *Index) AddDocSummary(summary DocumentSummary) {
 	idx.mu.Lock()
@@ -422,6 +1102,32 @@ func (idx *Index) AddDocSummary(summary DocumentSummary) {
 	idx.mu.Unlock()
 }
 
+// SetDocSummary replaces the summary of summary.Document, or adds it, and
+// relabels that document's page chunks with the new summary's sections.
+// Thread-safe.
+func (idx *Index) SetDocSummary(summary DocumentSummary) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	summaries := make([]DocumentSummary, 0, len(idx.DocSummaries)+1)
+	for _, s := range idx.DocSummaries {
+		if s.Document != summary.Document {
+			summaries = append(summaries, s)
+		}
+	}
+	idx.DocSummaries = append(summaries, summary)
+
+	// Copied, not edited in place: retrievers share the old slice
+	sections := sectionLookup{summaries: []DocumentSummary{summary}}
+	chunks := make([]Chunk, len(idx.Chunks))
+	copy(chunks, idx.Chunks)
+	for i := range chunks {
+		if chunks[i].Document == summary.Document && !chunks[i].IsSummary() {
+			chunks[i].Section = sections.lookup(summary.Document, chunks[i].PageNumber)
+		}
+	}
+	idx.Chunks = chunks
+}
+
 // RemoveDocument removes all chunks and summaries for a given document name.
 // It also deletes the corresponding BM25 entries. Thread-safe.
 // Returns the number of chunks removed.
@@ -494,14 +1200,39 @@ func LoadChunks(path string) ([]Chunk, error) {
 	if err := json.Unmarshal(data, &chunks); err != nil {
 		return nil, fmt.Errorf("unmarshal chunks: %w", err)
 	}
+	normalizeChunks(chunks)
 	return chunks, nil
 }
 
+// Normalize scales v to unit length in place and returns it. A zero vector
+// is left as it is.
+func Normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	inv := 1 / math.Sqrt(sum)
+	for i, x := range v {
+		v[i] = float32(float64(x) * inv)
+	}
+	return v
+}
+
+func normalizeChunks(chunks []Chunk) {
+	for i := range chunks {
+		Normalize(chunks[i].Embedding)
+	}
+}
+
 // ==========================================
 // OpenAI Embedder
 // ==========================================
 type OpenAIEmbedder struct {
 	client *openai.Client
+	apiKey string // one key, or several to rotate across (see httpclient.SplitKeys)
 	model  string
 }
 
@@ -521,30 +1252,43 @@ func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32
 	return results, nil
 }
 
-func (e *OpenAIEmbedder) BatchSize() int      { return 200 }
-func (e *OpenAIEmbedder) MaxConcurrency() int { return 8 }
+func (e *OpenAIEmbedder) BatchSize() int { return 200 }
+
+// MaxConcurrency is 8 calls per key.
+func (e *OpenAIEmbedder) MaxConcurrency() int { return 8 * httpclient.KeyCount(e.apiKey) }
+
+func (e *OpenAIEmbedder) endpoint() (host, key string) { return "api.openai.com", e.apiKey }
 
 // ==========================================
-// HuggingFace Embedder — connection-pooled, timeout-protected
+// HuggingFace Embedder — on the shared pool, timeout-protected
 // ==========================================
 type HuggingFaceEmbedder struct {
 	apiKey string
 	model  string
+	url    string // see hfEmbedURL
 	client *http.Client
 }
 
+// hfEmbedURLEnv overrides where HuggingFace embeddings are requested, e.g.
+// a dedicated Inference Endpoint or a text-embeddings-inference server.
+// "{model}" in it is replaced with the model name.
+const hfEmbedURLEnv = "HF_EMBED_URL"
+
+// hfEmbedURL is the feature-extraction pipeline URL for model: HF_EMBED_URL
+// if set, otherwise the model's pipeline on the HF Inference router.
+func hfEmbedURL(model string) string {
+	if u := os.Getenv(hfEmbedURLEnv); u != "" {
+		return strings.ReplaceAll(u, "{model}", model)
+	}
+	return fmt.Sprintf("https://router.huggingface.co/hf-inference/models/%s/pipeline/feature-extraction", model)
+}
+
 func newHuggingFaceEmbedder(apiKey, model string) *HuggingFaceEmbedder {
 	return &HuggingFaceEmbedder{
 		apiKey: apiKey,
 		model:  model,
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConns:        20,
-				MaxIdleConnsPerHost: 10,
-				IdleConnTimeout:     90 * time.Second,
-			},
-		},
+		url:    hfEmbedURL(model),
+		client: httpclient.WithTimeout(60 * time.Second),
 	}
 }
 
@@ -553,8 +1297,10 @@ func (e *HuggingFaceEmbedder) Embed(ctx context.Context, texts []string) ([][]fl
 		"inputs": texts,
 	})
 
-	url := fmt.Sprintf("https://router.huggingface.co/models/%s", e.model)
-	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("HF embedding URL %q: %w", e.url, err)
+	}
 	req.Header.Set("Authorization", "Bearer "+e.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 
@@ -564,27 +1310,132 @@ func (e *HuggingFaceEmbedder) Embed(ctx context.Context, texts []string) ([][]fl
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 	if resp.StatusCode != 200 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("HF api error: %d - %s", resp.StatusCode, string(bodyBytes))
+		return nil, e.apiError(resp.StatusCode, body)
+	}
+	embeddings, err := decodeHFEmbeddings(body, len(texts))
+	if err != nil {
+		return nil, fmt.Errorf("HF embeddings from %s: %w", e.model, err)
 	}
+	return embeddings, nil
+}
 
-	var hfResp [][]float64
-	if err := json.NewDecoder(resp.Body).Decode(&hfResp); err != nil {
-		return nil, err
+// apiError explains a failed HF request, with the message from its JSON
+// error body when there is one.
+func (e *HuggingFaceEmbedder) apiError(status int, body []byte) error {
+	var hfErr struct {
+		Error         json.RawMessage `json:"error"` // a string, or a list of them
+		EstimatedTime float64         `json:"estimated_time"`
 	}
+	msg := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &hfErr) == nil && len(hfErr.Error) > 0 {
+		var one string
+		var many []string
+		if json.Unmarshal(hfErr.Error, &one) == nil {
+			msg = one
+		} else if json.Unmarshal(hfErr.Error, &many) == nil {
+			msg = strings.Join(many, "; ")
+		}
+	}
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Errorf("HF api error: %d - the API key was rejected (it needs permission to call Inference Providers): %s", status, msg)
+	case status == http.StatusNotFound:
+		return fmt.Errorf("HF api error: %d - model %s is not served for feature extraction at %s (set %s for a dedicated endpoint): %s", status, e.model, e.url, hfEmbedURLEnv, msg)
+	case status == http.StatusServiceUnavailable && hfErr.EstimatedTime > 0:
+		return fmt.Errorf("HF api error: %d - model %s is loading, ready in about %.0fs", status, e.model, hfErr.EstimatedTime)
+	}
+	return fmt.Errorf("HF api error: %d - %s", status, msg)
+}
 
-	var results [][]float32
-	for _, vec := range hfResp {
-		var f32vec []float32
-		for _, val := range vec {
-			f32vec = append(f32vec, float32(val))
+// decodeHFEmbeddings reads a feature-extraction response for n inputs. A
+// sentence-embedding model returns one vector per input; a plain encoder
+// returns one per token, which are mean-pooled into the input's vector.
+// Dedicated endpoints may wrap the vectors in an object instead.
+func decodeHFEmbeddings(body []byte, n int) ([][]float32, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		var obj struct {
+			Embeddings [][]float32 `json:"embeddings"`
+			Data       []struct {
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"` // OpenAI-compatible
+		}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			return nil, err
 		}
-		results = append(results, f32vec)
+		for _, d := range obj.Data {
+			obj.Embeddings = append(obj.Embeddings, d.Embedding)
+		}
+		return checkHFCount(obj.Embeddings, n)
 	}
 
-	return results, nil
+	var sentences [][]float32
+	if err := json.Unmarshal(body, &sentences); err == nil {
+		if len(sentences) != n && n == 1 {
+			// One input's token vectors
+			return [][]float32{meanPool(sentences)}, nil
+		}
+		return checkHFCount(sentences, n)
+	}
+	var tokens [][][]float32
+	if err := json.Unmarshal(body, &tokens); err == nil {
+		out := make([][]float32, len(tokens))
+		for i, t := range tokens {
+			out[i] = meanPool(t)
+		}
+		return checkHFCount(out, n)
+	}
+	var single []float32
+	if err := json.Unmarshal(body, &single); err == nil && n == 1 {
+		return [][]float32{single}, nil
+	}
+	return nil, fmt.Errorf("unrecognised response shape: %.100s", body)
+}
+
+func checkHFCount(embeddings [][]float32, n int) ([][]float32, error) {
+	if len(embeddings) != n {
+		return nil, fmt.Errorf("got %d embeddings for %d inputs", len(embeddings), n)
+	}
+	for i, e := range embeddings {
+		if len(e) == 0 {
+			return nil, fmt.Errorf("embedding %d is empty", i)
+		}
+	}
+	return embeddings, nil
+}
+
+// meanPool averages token vectors into one.
+func meanPool(tokens [][]float32) []float32 {
+	if len(tokens) == 0 {
+		return nil
+	}
+	out := make([]float32, len(tokens[0]))
+	for _, t := range tokens {
+		for j := range out {
+			if j < len(t) {
+				out[j] += t[j]
+			}
+		}
+	}
+	for j := range out {
+		out[j] /= float32(len(tokens))
+	}
+	return out
 }
 
-func (e *HuggingFaceEmbedder) BatchSize() int      { return 50 }
-func (e *HuggingFaceEmbedder) MaxConcurrency() int { return 6 }
+func (e *HuggingFaceEmbedder) BatchSize() int { return 50 }
+
+// MaxConcurrency is 6 calls per key.
+func (e *HuggingFaceEmbedder) MaxConcurrency() int { return 6 * httpclient.KeyCount(e.apiKey) }
+
+func (e *HuggingFaceEmbedder) endpoint() (host, key string) {
+	if u, err := url.Parse(e.url); err == nil {
+		host = u.Host
+	}
+	return host, e.apiKey
+}
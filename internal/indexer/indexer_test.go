@@ -1,10 +1,25 @@
 package indexer
 
 import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"unsafe"
 
 	"gocognigo/internal/extractor"
+
+	"github.com/blevesearch/bleve/v2"
 )
 
 // ========== ChunkPages ==========
@@ -156,6 +171,57 @@ func TestChunkPages_UniqueChunkIDs(t *testing.T) {
 	}
 }
 
+func TestChunkPages_CustomShape(t *testing.T) {
+	words := make([]string, 100)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+	}
+	page := []extractor.DocumentChunk{{PageNumber: 1, Document: "a.pdf", Text: strings.Join(words, " ")}}
+
+	chunks := (&Index{ChunkWords: 40, ChunkOverlap: 10}).ChunkPages(page)
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks of 40 words stepping by 30, got %d", len(chunks))
+	}
+	if got := strings.Fields(chunks[1].Text)[0]; got != "w30" {
+		t.Errorf("second chunk starts at %q, want w30", got)
+	}
+
+	// An overlap as long as the chunk would never advance
+	if n := len((&Index{ChunkWords: 20, ChunkOverlap: 20}).ChunkPages(page)); n != 6 {
+		t.Errorf("overlap >= chunk size: got %d chunks, want 6 (overlap cut to 4)", n)
+	}
+}
+
+func TestChunkPages_StableChunkIDs(t *testing.T) {
+	words := make([]string, 300)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+	}
+	page2 := extractor.DocumentChunk{PageNumber: 2, Document: "a.pdf", Text: strings.Join(words, " ")}
+	idx := &Index{}
+	all := idx.ChunkPages([]extractor.DocumentChunk{{PageNumber: 1, Document: "a.pdf", Text: "Cover page."}, page2})
+	alone := idx.ChunkPages([]extractor.DocumentChunk{page2})
+
+	// The same page gets the same IDs however many chunks came before it
+	if len(alone) != len(all)-1 {
+		t.Fatalf("got %d chunks alone, %d with the cover", len(alone), len(all))
+	}
+	for i, c := range alone {
+		if c.ID != all[i+1].ID {
+			t.Errorf("chunk %d: ID %q alone, %q after the cover", i, c.ID, all[i+1].ID)
+		}
+	}
+	if !strings.HasPrefix(alone[1].ID, "a.pdf_p2_w120_") {
+		t.Errorf("second chunk ID = %q, want document, page and word offset first", alone[1].ID)
+	}
+
+	// Changed text gets a new ID
+	page2.Text = strings.Replace(page2.Text, "w5 ", "w5x ", 1)
+	if edited := idx.ChunkPages([]extractor.DocumentChunk{page2}); edited[0].ID == alone[0].ID || edited[2].ID != alone[2].ID {
+		t.Errorf("after editing the first chunk's text: IDs %q, %q; before %q, %q", edited[0].ID, edited[2].ID, alone[0].ID, alone[2].ID)
+	}
+}
+
 // ========== sectionLookup ==========
 
 func TestSectionLookup_MatchesCorrectSection(t *testing.T) {
@@ -226,3 +292,705 @@ func TestAddDocSummary_ThreadSafe(t *testing.T) {
 		t.Errorf("expected 10 summaries, got %d", len(idx.DocSummaries))
 	}
 }
+
+// ========== NewEmbedder ==========
+
+func TestNewEmbedder_Providers(t *testing.T) {
+	e, err := NewEmbedder("", "key", "")
+	if err != nil {
+		t.Fatalf("default provider: %v", err)
+	}
+	if oe, ok := e.(*OpenAIEmbedder); !ok || oe.model != "text-embedding-3-small" {
+		t.Errorf("default provider: got %T, want OpenAI text-embedding-3-small", e)
+	}
+
+	e, err = NewEmbedder("HuggingFace", "key", "")
+	if err != nil {
+		t.Fatalf("huggingface: %v", err)
+	}
+	if he, ok := e.(*HuggingFaceEmbedder); !ok || he.model != "BAAI/bge-small-en-v1.5" {
+		t.Errorf("huggingface: got %T, want HuggingFace bge-small", e)
+	}
+
+	if _, err := NewEmbedder("cohere", "key", ""); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
+
+func TestHuggingFaceEmbedder_ResponseShapes(t *testing.T) {
+	var reply string
+	var status int
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.WriteHeader(status)
+		fmt.Fprint(w, reply)
+	}))
+	defer srv.Close()
+	t.Setenv(hfEmbedURLEnv, srv.URL+"/models/{model}/pipeline/feature-extraction")
+	e := newHuggingFaceEmbedder("key", "org/model")
+
+	cases := []struct {
+		name   string
+		inputs []string
+		reply  string
+		want   [][]float32
+	}{
+		{"sentence vectors", []string{"a", "b"}, `[[1,2],[3,4]]`, [][]float32{{1, 2}, {3, 4}}},
+		{"token vectors", []string{"a", "b"}, `[[[1,2],[3,4]],[[5,6]]]`, [][]float32{{2, 3}, {5, 6}}},
+		{"one input's token vectors", []string{"a"}, `[[1,0],[3,2]]`, [][]float32{{2, 1}}},
+		{"one flat vector", []string{"a"}, `[0.5,0.25]`, [][]float32{{0.5, 0.25}}},
+		{"wrapped", []string{"a"}, `{"embeddings":[[7,8]]}`, [][]float32{{7, 8}}},
+	}
+	for _, c := range cases {
+		reply, status = c.reply, 200
+		got, err := e.Embed(context.Background(), c.inputs)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+	if gotPath != "/models/org/model/pipeline/feature-extraction" {
+		t.Errorf("requested %s", gotPath)
+	}
+
+	reply, status = `[[1,2]]`, 200
+	if _, err := e.Embed(context.Background(), []string{"a", "b"}); err == nil || !strings.Contains(err.Error(), "1 embeddings for 2 inputs") {
+		t.Errorf("short response: err = %v", err)
+	}
+	reply, status = `{"error":"Model org/model is currently loading","estimated_time":20.0}`, 503
+	if _, err := e.Embed(context.Background(), []string{"a"}); err == nil || !strings.Contains(err.Error(), "loading, ready in about 20s") {
+		t.Errorf("loading model: err = %v", err)
+	}
+	reply, status = `{"error":"Not Found"}`, 404
+	if _, err := e.Embed(context.Background(), []string{"a"}); err == nil || !strings.Contains(err.Error(), hfEmbedURLEnv) {
+		t.Errorf("unknown model: err = %v", err)
+	}
+}
+
+func TestHFEmbedURL_DefaultsToRouterPipeline(t *testing.T) {
+	t.Setenv(hfEmbedURLEnv, "")
+	if got := hfEmbedURL("BAAI/bge-small-en-v1.5"); got != "https://router.huggingface.co/hf-inference/models/BAAI/bge-small-en-v1.5/pipeline/feature-extraction" {
+		t.Errorf("hfEmbedURL = %s", got)
+	}
+}
+
+// ========== Language Routing ==========
+
+// constEmbedder returns the same vector for every text.
+type constEmbedder []float32
+
+func (e constEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i := range texts {
+		out[i] = e
+	}
+	return out, nil
+}
+func (constEmbedder) BatchSize() int      { return 2 }
+func (constEmbedder) MaxConcurrency() int { return 1 }
+
+func TestEmbedAndIndex_RoutesNonEnglishPages(t *testing.T) {
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &Index{BM25Index: bm, Embedder: constEmbedder{1, 0}, Multilingual: constEmbedder{0, 1, 0}}
+	chunks := idx.ChunkPages([]extractor.DocumentChunk{
+		{Document: "a.pdf", PageNumber: 1, Text: "The lessee shall pay the monthly rent on the first day of each month."},
+		{Document: "a.pdf", PageNumber: 2, Text: "किरायेदार प्रत्येक माह के पहले दिन मासिक किराए का भुगतान करेगा।"},
+	})
+	if err := idx.EmbedAndIndex(context.Background(), chunks, nil, 0); err != nil {
+		t.Fatalf("EmbedAndIndex: %v", err)
+	}
+
+	byPage := map[int]Chunk{}
+	for _, c := range idx.Chunks {
+		byPage[c.PageNumber] = c
+	}
+	if c := byPage[1]; c.Language != "en" || c.Multilingual || len(c.Embedding) != 2 {
+		t.Errorf("English page: language=%q multilingual=%v dims=%d", c.Language, c.Multilingual, len(c.Embedding))
+	}
+	if c := byPage[2]; c.Language != "hi" || !c.Multilingual || len(c.Embedding) != 3 {
+		t.Errorf("Hindi page: language=%q multilingual=%v dims=%d", c.Language, c.Multilingual, len(c.Embedding))
+	}
+}
+
+func TestEnglishOnlyModel(t *testing.T) {
+	for model, want := range map[string]bool{
+		"BAAI/bge-small-en-v1.5":                 true,
+		"sentence-transformers/all-MiniLM-L6-v2": true,
+		"BAAI/bge-m3":                            false,
+		"intfloat/multilingual-e5-large":         false,
+		"text-embedding-3-small":                 false,
+	} {
+		if got := englishOnlyModel(model); got != want {
+			t.Errorf("englishOnlyModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestEmbeddingCost(t *testing.T) {
+	if got := EmbedModelName("huggingface", ""); got != DefaultHuggingFaceEmbedModel {
+		t.Errorf("huggingface default = %q", got)
+	}
+	if got := EmbedModelName("", ""); got != DefaultOpenAIEmbedModel {
+		t.Errorf("openai default = %q", got)
+	}
+	cost, ok := EmbeddingCost(DefaultOpenAIEmbedModel, 1_000_000)
+	if !ok || cost != 0.02 {
+		t.Errorf("EmbeddingCost = %v, %v; want 0.02, true", cost, ok)
+	}
+	if _, ok := EmbeddingCost(DefaultHuggingFaceEmbedModel, 1000); ok {
+		t.Error("Hugging Face models should have no token price")
+	}
+	if n := EstimateEmbeddingTokens([]Chunk{{Text: strings.Repeat("x", 400)}}); n != 100 {
+		t.Errorf("EstimateEmbeddingTokens = %d, want 100", n)
+	}
+}
+
+// batchRecorder records the size of every batch it embeds.
+type batchRecorder struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (b *batchRecorder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	b.mu.Lock()
+	b.sizes = append(b.sizes, len(texts))
+	b.mu.Unlock()
+	out := make([][]float32, len(texts))
+	for i := range out {
+		out[i] = []float32{1}
+	}
+	return out, nil
+}
+func (b *batchRecorder) BatchSize() int      { return 200 }
+func (b *batchRecorder) MaxConcurrency() int { return 8 }
+
+func TestEmbedAndIndex_BatchSizeOverride(t *testing.T) {
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &batchRecorder{}
+	idx := &Index{BM25Index: bm, Embedder: rec, BatchSize: 3, Concurrency: 1}
+	chunks := make([]Chunk, 7)
+	for i := range chunks {
+		chunks[i] = Chunk{ID: fmt.Sprintf("c%d", i), Document: "a.pdf", PageNumber: i + 1, Text: "rent is payable monthly"}
+	}
+	if err := idx.EmbedAndIndex(context.Background(), chunks, nil, 0); err != nil {
+		t.Fatalf("EmbedAndIndex: %v", err)
+	}
+	if len(idx.Chunks) != 7 {
+		t.Fatalf("indexed %d chunks, want 7", len(idx.Chunks))
+	}
+	sort.Ints(rec.sizes)
+	if fmt.Sprint(rec.sizes) != "[1 3 3]" {
+		t.Errorf("batch sizes = %v, want [1 3 3]", rec.sizes)
+	}
+}
+
+func TestRechunk_ReusesUnchangedEmbeddings(t *testing.T) {
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &batchRecorder{}
+	idx := &Index{BM25Index: bm, Embedder: rec, ChunkWords: 100, ChunkOverlap: 20}
+	words := make([]string, 300)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+	}
+	chunks := idx.ChunkPages([]extractor.DocumentChunk{
+		{Document: "a.pdf", PageNumber: 1, Text: "The rent is due monthly."},
+		{Document: "a.pdf", PageNumber: 2, Text: strings.Join(words, " ")},
+	})
+	chunks = append(chunks, Chunk{ID: "a.pdf_summary", Document: "a.pdf", PageNumber: 1, PageEnd: 2, Level: "document", Text: "A lease.", ParentText: "A lease."})
+	if err := idx.EmbedAndIndex(context.Background(), chunks, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Chunks) != 6 {
+		t.Fatalf("indexed %d chunks, want 6", len(idx.Chunks))
+	}
+
+	// A smaller overlap moves every chunk boundary on page 2 but its first
+	rec.sizes = nil
+	idx.ChunkOverlap = 10
+	rechunked, reusable := idx.Rechunk(true)
+	if len(rechunked) != 6 || reusable != 3 {
+		t.Fatalf("Rechunk = %d chunks, %d reusable; want 6, 3", len(rechunked), reusable)
+	}
+	var done int
+	if err := idx.EmbedAndIndex(context.Background(), rechunked, func(_, d int) { done = d }, 0); err != nil {
+		t.Fatal(err)
+	}
+	embedded := 0
+	for _, n := range rec.sizes {
+		embedded += n
+	}
+	if embedded != 3 || done != 6 || len(idx.Chunks) != 6 {
+		t.Errorf("embedded %d texts, progress %d, %d chunks; want 3, 6, 6", embedded, done, len(idx.Chunks))
+	}
+	pages := 0
+	for _, c := range idx.Chunks {
+		if len(c.Embedding) == 0 {
+			t.Errorf("chunk %s has no embedding", c.ID)
+		}
+		if c.PageNumber == 2 && !c.IsSummary() {
+			pages++
+		}
+	}
+	if pages != 4 {
+		t.Errorf("page 2 has %d chunks, want 4", pages)
+	}
+
+	// Without reuse, everything is embedded again
+	rec.sizes = nil
+	rechunked, reusable = idx.Rechunk(false)
+	if err := idx.EmbedAndIndex(context.Background(), rechunked, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if reusable != 0 || len(rec.sizes) == 0 {
+		t.Errorf("Rechunk(false): %d reusable, batches %v", reusable, rec.sizes)
+	}
+}
+
+func TestSnapshot_SearchesGivenChunks(t *testing.T) {
+	idx := &Index{Embedder: constEmbedder{1, 0}}
+	snap, err := idx.Snapshot([]Chunk{
+		{ID: "a_p1_c0", Document: "a.pdf", PageNumber: 1, Text: "the lessee pays rent monthly", Embedding: []float32{1, 0}},
+	})
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	defer snap.Close()
+	res, err := snap.BM25Index.Search(bleve.NewSearchRequest(bleve.NewMatchQuery("rent")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 1 || len(snap.Chunks) != 1 || snap.Embedder == nil {
+		t.Errorf("snapshot: %d BM25 hits, %d chunks, embedder %v", res.Total, len(snap.Chunks), snap.Embedder)
+	}
+}
+
+func TestSetDocSummary_ReplacesAndRelabelsSections(t *testing.T) {
+	idx := &Index{
+		Chunks: []Chunk{
+			{ID: "a1", Document: "a.pdf", PageNumber: 1},
+			{ID: "a2", Document: "a.pdf", PageNumber: 7},
+			{ID: "b1", Document: "b.pdf", PageNumber: 1, Section: "Intro"},
+		},
+		DocSummaries: []DocumentSummary{{Document: "a.pdf", Title: "old"}, {Document: "b.pdf", Title: "b"}},
+	}
+	old := idx.Chunks
+
+	idx.SetDocSummary(DocumentSummary{Document: "a.pdf", Title: "new", Sections: []Section{
+		{Name: "Recitals", PageStart: 1, PageEnd: 5},
+		{Name: "Schedules", PageStart: 6, PageEnd: 9},
+	}})
+
+	if len(idx.DocSummaries) != 2 {
+		t.Fatalf("summaries = %+v, want a.pdf replaced", idx.DocSummaries)
+	}
+	for _, s := range idx.DocSummaries {
+		if s.Document == "a.pdf" && s.Title != "new" {
+			t.Errorf("a.pdf summary not replaced: %+v", s)
+		}
+	}
+	if idx.Chunks[0].Section != "Recitals" || idx.Chunks[1].Section != "Schedules" || idx.Chunks[2].Section != "Intro" {
+		t.Errorf("sections = %q, %q, %q", idx.Chunks[0].Section, idx.Chunks[1].Section, idx.Chunks[2].Section)
+	}
+	if old[0].Section != "" {
+		t.Error("chunks shared with retrievers were edited in place")
+	}
+}
+
+func TestSummaryNodes(t *testing.T) {
+	doc := DocumentSummary{Document: "a.pdf", Title: "Supply Agreement", DocType: "contract", Summary: "A supply contract."}
+	nodes := SummaryNodes(doc, []SectionSummary{
+		{Name: "Pricing", PageStart: 2, PageEnd: 4, Summary: "Fixed prices for two years."},
+		{Name: "Empty", PageStart: 5, PageEnd: 5},
+	}, 12)
+
+	if len(nodes) != 2 {
+		t.Fatalf("want one section node and one document node, got %+v", nodes)
+	}
+	sec, whole := nodes[0], nodes[1]
+	if sec.Level != LevelSection || sec.Section != "Pricing" || sec.PageNumber != 2 || sec.PageEnd != 4 {
+		t.Errorf("section node = %+v", sec)
+	}
+	if whole.Level != LevelDocument || whole.PageNumber != 1 || whole.PageEnd != 12 || !whole.IsSummary() {
+		t.Errorf("document node = %+v", whole)
+	}
+	if !strings.Contains(whole.Text, "Fixed prices") || sec.ID == whole.ID {
+		t.Errorf("document node text %q should outline its sections", whole.Text)
+	}
+}
+
+// ========== Health ==========
+
+func TestCheck_ReportsProblemsAndReindexFixesKeywordIndex(t *testing.T) {
+	bm, err := bleve.NewMemOnly(bm25Mapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &Index{BM25Index: bm, Chunks: []Chunk{
+		{ID: "a_p1_c0", Document: "a.pdf", Text: "rent", Embedding: []float32{1, 0, 0}},
+		{ID: "a_p2_c0", Document: "a.pdf", Text: "notice", Embedding: []float32{0, 1, 0}},
+		{ID: "b_p1_c0", Document: "b.pdf", Text: "term", Embedding: []float32{1, 0}},
+		{ID: "b_p2_c0", Document: "b.pdf", Text: "party"},
+	}}
+	defer idx.Close()
+
+	h := idx.Check()
+	if h.Documents != 2 || h.Dimensions != 3 || h.MissingEmbeddings != 1 || h.DimensionMismatches != 1 || h.DuplicateIDs != 0 {
+		t.Errorf("health = %+v", h)
+	}
+	if len(h.Problems) != 3 { // missing embedding, mixed dimensions, empty keyword index
+		t.Errorf("problems = %q, want 3", h.Problems)
+	}
+
+	if err := idx.ReindexBM25(); err != nil {
+		t.Fatalf("ReindexBM25: %v", err)
+	}
+	if h := idx.Check(); h.BM25Docs != 4 || len(h.Problems) != 2 {
+		t.Errorf("after reindex: %d keyword entries, problems %q", h.BM25Docs, h.Problems)
+	}
+}
+
+func TestCheck_ReportsKeywordIndexWithoutIdentifiers(t *testing.T) {
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &Index{BM25Index: bm}
+	defer idx.Close()
+	if h := idx.Check(); len(h.Problems) != 1 || !strings.Contains(h.Problems[0], "identifier") {
+		t.Errorf("problems = %q, want the outdated keyword index", h.Problems)
+	}
+}
+
+func TestMemoryEstimate_GrowsWithTextAndEmbeddings(t *testing.T) {
+	small := &Index{Chunks: []Chunk{{ID: "a", Text: "rent", Embedding: make([]float32, 8)}}}
+	large := &Index{Chunks: []Chunk{{ID: "a", Text: "rent", ParentText: strings.Repeat("x", 10000), Embedding: make([]float32, 1536)}}}
+
+	if got := (&Index{}).MemoryEstimate(); got != 0 {
+		t.Errorf("empty index estimate = %d, want 0", got)
+	}
+	s, l := small.MemoryEstimate(), large.MemoryEstimate()
+	if want := int64(10000 + 4*(1536-8)); l-s != want {
+		t.Errorf("estimate grew by %d, want %d", l-s, want)
+	}
+}
+
+// ========== Exact Identifiers ==========
+
+func TestIdentifiers(t *testing.T) {
+	got := Identifiers(`Under Section 14.2(b), invoice INV-2024-0042 ("the Invoice") and §3.1 apply; see 14.2(b). Case No. 2019-CV-0042 [2019] (s. 12(1)(a)) cites page 7 of 10042.`)
+	want := []string{"14.2(b)", "inv-2024-0042", "3.1", "2019-cv-0042", "12(1)(a)", "10042"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Identifiers = %q, want %q", got, want)
+	}
+	if got := Identifiers("no numbers here, only 7 and 2019"); len(got) != 0 {
+		t.Errorf("Identifiers = %q, want none", got)
+	}
+}
+
+func TestBM25_MatchesIdentifiersExactly(t *testing.T) {
+	bm, err := bleve.NewMemOnly(bm25Mapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	chunks := []Chunk{
+		{ID: "a", Text: "Section 14.2(b) limits liability."},
+		{ID: "b", Text: "Section 14.2 covers notices; 2(b) is unrelated."},
+	}
+	if err := indexBM25(bm, chunks); err != nil {
+		t.Fatal(err)
+	}
+	q := bleve.NewTermQuery("14.2(b)")
+	q.SetField(IdentifiersField)
+	res, err := bm.Search(bleve.NewSearchRequest(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 || res.Hits[0].ID != "a" {
+		t.Errorf("hits = %v, want only a", res.Hits)
+	}
+	if !hasIdentifiers(bm) {
+		t.Error("hasIdentifiers = false for the current mapping")
+	}
+}
+
+// ========== Vector Persistence ==========
+
+func TestLoadVectorsProgressive_LoadsInBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vectors.json")
+	saved := &Index{DocSummaries: []DocumentSummary{{Document: "a.pdf", Title: "Lease"}}}
+	for i := 0; i < 2*vectorBatchSize+10; i++ {
+		saved.Chunks = append(saved.Chunks, Chunk{ID: fmt.Sprintf("a_p%d_c0", i), Document: "a.pdf", Embedding: []float32{float32(i)}})
+	}
+	if err := saved.SaveVectors(path); err != nil {
+		t.Fatalf("SaveVectors: %v", err)
+	}
+
+	for _, format := range []string{"binary", "json"} {
+		if format == "json" {
+			os.Remove(strings.TrimSuffix(path, ".json") + ".gob")
+		}
+		idx := &Index{}
+		var loaded []int
+		var last float64
+		err := idx.LoadVectorsProgressive(path, func(n int, fraction float64) {
+			if fraction < last || fraction > 1 {
+				t.Errorf("%s: fraction went from %v to %v", format, last, fraction)
+			}
+			last = fraction
+			loaded = append(loaded, n)
+		})
+		if err != nil {
+			t.Fatalf("%s: LoadVectorsProgressive: %v", format, err)
+		}
+		if len(idx.Chunks) != len(saved.Chunks) || idx.Chunks[len(idx.Chunks)-1].ID != saved.Chunks[len(saved.Chunks)-1].ID {
+			t.Errorf("%s: loaded %d chunks, want %d", format, len(idx.Chunks), len(saved.Chunks))
+		}
+		if len(idx.DocSummaries) != 1 {
+			t.Errorf("%s: loaded %d summaries, want 1", format, len(idx.DocSummaries))
+		}
+		if want := []int{vectorBatchSize, 2 * vectorBatchSize, 2*vectorBatchSize + 10}; fmt.Sprint(loaded) != fmt.Sprint(want) {
+			t.Errorf("%s: progress after %v chunks, want %v", format, loaded, want)
+		}
+	}
+}
+
+func TestLoadVectors_ReadsOlderFormats(t *testing.T) {
+	dir := t.TempDir()
+	chunks := []Chunk{{ID: "a_p1_c0", Text: "rent"}, {ID: "a_p2_c0", Text: "notice"}}
+
+	// Binary vectors written as a single vectorStore
+	gobPath := filepath.Join(dir, "old.gob")
+	f, err := os.Create(gobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(f).Encode(vectorStore{Chunks: chunks, DocSummaries: []DocumentSummary{{Document: "a.pdf"}}}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	idx := &Index{}
+	if err := idx.LoadVectors(filepath.Join(dir, "old.json")); err != nil || len(idx.Chunks) != 2 || len(idx.DocSummaries) != 1 {
+		t.Errorf("single-value binary: %d chunks, %d summaries, err %v", len(idx.Chunks), len(idx.DocSummaries), err)
+	}
+
+	// JSON holding just the chunks array
+	data, _ := json.Marshal(chunks)
+	legacy := filepath.Join(dir, "legacy.json")
+	if err := os.WriteFile(legacy, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx = &Index{}
+	if err := idx.LoadVectors(legacy); err != nil || len(idx.Chunks) != 2 {
+		t.Errorf("legacy JSON: %d chunks, err %v", len(idx.Chunks), err)
+	}
+}
+
+func TestLoadVectors_MigratesToCurrentFormat(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vectors.json")
+	gobPath := filepath.Join(dir, "vectors.gob")
+	page1 := strings.Repeat("The tenant pays rent monthly. ", 200)
+	chunks := []Chunk{
+		{ID: "a_p1_w0", Document: "a.pdf", PageNumber: 1, Text: "tenant pays", ParentText: page1, Embedding: []float32{1, 0}},
+		{ID: "a_p1_w150", Document: "a.pdf", PageNumber: 1, Text: "rent monthly", ParentText: page1, Embedding: []float32{0, 1}},
+		{ID: "a_p2_w0", Document: "a.pdf", PageNumber: 2, Text: "notice", ParentText: "Notice is one month.", Embedding: []float32{1, 0}},
+	}
+
+	// Version 1: streamed, each chunk with its page's text, and no JSON
+	f, err := os.Create(gobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := gob.NewEncoder(f)
+	_ = enc.Encode(vectorHeader{StreamVersion: 1, ChunkCount: len(chunks), Normalized: true})
+	_ = enc.Encode(chunks)
+	f.Close()
+
+	idx := &Index{}
+	if err := idx.LoadVectors(path); err != nil {
+		t.Fatal(err)
+	}
+	if unsafe.StringData(idx.Chunks[0].ParentText) != unsafe.StringData(idx.Chunks[1].ParentText) {
+		t.Error("chunks of one page don't share its text")
+	}
+
+	// Both files are rewritten in the current format
+	f, _ = os.Open(gobPath)
+	var header vectorHeader
+	err = gob.NewDecoder(f).Decode(&header)
+	f.Close()
+	if err != nil || header.StreamVersion != VectorsVersion {
+		t.Errorf("binary vectors in format %d after loading, want %d (%v)", header.StreamVersion, VectorsVersion, err)
+	}
+	var stored vectorStore
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != VectorsVersion || len(stored.Chunks) != 3 {
+		t.Errorf("JSON vectors version %d with %d chunks, err %v", stored.Version, len(stored.Chunks), err)
+	}
+
+	// The page table restores every chunk's page text, shared
+	idx = &Index{}
+	if err := idx.LoadVectors(path); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range idx.Chunks {
+		if c.ParentText != chunks[i].ParentText || c.Text != chunks[i].Text {
+			t.Errorf("chunk %s: parent %.20q, text %q", c.ID, c.ParentText, c.Text)
+		}
+	}
+	if unsafe.StringData(idx.Chunks[0].ParentText) != unsafe.StringData(idx.Chunks[1].ParentText) {
+		t.Error("chunks loaded from the page table don't share its text")
+	}
+	if info, _ := os.Stat(gobPath); info.Size() >= int64(2*len(page1)) {
+		t.Errorf("binary vectors are %d bytes, want the page text stored once", info.Size())
+	}
+
+	// A binary file from a newer build falls back to the JSON
+	f, _ = os.Create(gobPath)
+	_ = gob.NewEncoder(f).Encode(vectorHeader{StreamVersion: VectorsVersion + 1, ChunkCount: 1})
+	f.Close()
+	idx = &Index{}
+	if err := idx.LoadVectors(path); err != nil || len(idx.Chunks) != 3 {
+		t.Errorf("newer binary: %d chunks, err %v", len(idx.Chunks), err)
+	}
+}
+
+func TestLoadVectors_NormalizesOlderEmbeddings(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vectors.json")
+	data, _ := json.Marshal(vectorStore{Chunks: []Chunk{{ID: "a_p1_c0", Embedding: []float32{3, 4}}, {ID: "a_p2_c0"}}})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := &Index{}
+	if err := idx.LoadVectors(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := idx.Chunks[0].Embedding; math.Abs(float64(got[0])-0.6) > 1e-6 || math.Abs(float64(got[1])-0.8) > 1e-6 {
+		t.Errorf("embedding = %v, want [0.6 0.8]", got)
+	}
+	if idx.Chunks[1].Embedding != nil {
+		t.Errorf("chunk without an embedding got %v", idx.Chunks[1].Embedding)
+	}
+
+	// Saved again, the file says so and its vectors load as they are
+	if err := idx.SaveVectors(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"binary", "JSON"} {
+		idx = &Index{}
+		if err := idx.LoadVectors(path); err != nil {
+			t.Fatal(err)
+		}
+		if got := idx.Chunks[0].Embedding; math.Abs(float64(got[0])-0.6) > 1e-6 {
+			t.Errorf("%s: embedding = %v after a round trip", format, got)
+		}
+		os.Remove(filepath.Join(dir, "vectors.gob"))
+	}
+	if data, _ := os.ReadFile(path); !strings.HasPrefix(string(data), `{"version":2,"normalized":true,`) {
+		t.Errorf("saved JSON starts %.40s, want the version and normalized flag first", data)
+	}
+}
+
+// ========== Embedding Provenance ==========
+
+func TestSaveVectors_RecordsEmbeddingModels(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vectors.json")
+	saved, err := NewIndex("HuggingFace", "key", "", filepath.Join(dir, "bm25"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer saved.Close()
+	if err := saved.SetMultilingual("huggingface", "key", "BAAI/bge-m3"); err != nil {
+		t.Fatal(err)
+	}
+	saved.Chunks = []Chunk{{ID: "a_p1_c0", Embedding: make([]float32, 384)}}
+	if err := saved.SaveVectors(path); err != nil {
+		t.Fatal(err)
+	}
+
+	want := EmbeddingInfo{Provider: "huggingface", Model: DefaultHuggingFaceEmbedModel, Multilingual: "BAAI/bge-m3"}
+	for _, format := range []string{"binary", "JSON"} {
+		if got, ok := ReadEmbeddingInfo(path); !ok || got != want {
+			t.Errorf("%s: ReadEmbeddingInfo = %+v, %v; want %+v", format, got, ok, want)
+		}
+		idx := &Index{}
+		if err := idx.LoadVectors(path); err != nil {
+			t.Fatal(err)
+		}
+		if idx.stored == nil || *idx.stored != want {
+			t.Errorf("%s: loaded embedding %+v, want %+v", format, idx.stored, want)
+		}
+		os.Remove(filepath.Join(dir, "vectors.gob"))
+	}
+
+	// Files from before it was recorded say nothing
+	data, _ := json.Marshal(vectorStore{Normalized: true, Chunks: saved.Chunks})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := ReadEmbeddingInfo(path); ok {
+		t.Errorf("older file: ReadEmbeddingInfo = %+v, want none", got)
+	}
+}
+
+func TestCheckEmbedding_DetectsOtherModels(t *testing.T) {
+	small := EmbeddingInfo{Provider: "openai", Model: DefaultOpenAIEmbedModel}
+	hf := EmbeddingInfo{Provider: "huggingface", Model: DefaultHuggingFaceEmbedModel}
+	withM3 := EmbeddingInfo{Provider: "openai", Model: DefaultOpenAIEmbedModel, Multilingual: "BAAI/bge-m3"}
+	withE5 := EmbeddingInfo{Provider: "openai", Model: DefaultOpenAIEmbedModel, Multilingual: "intfloat/multilingual-e5-large"}
+
+	tests := []struct {
+		name     string
+		stored   *EmbeddingInfo
+		current  string // multilingual model of the index
+		dims     int
+		mismatch bool
+	}{
+		{"same models", &small, "", 1536, false},
+		{"other provider", &hf, "", 384, true},
+		{"multilingual model added", &small, "BAAI/bge-m3", 1536, false},
+		{"multilingual model changed", &withE5, "BAAI/bge-m3", 1536, true},
+		{"multilingual model dropped", &withM3, "", 1536, false},
+		{"unrecorded, same dimensions", nil, "", 1536, false},
+		{"unrecorded, other dimensions", nil, "", 384, true},
+	}
+	for _, tt := range tests {
+		idx := &Index{
+			Embedder:          &OpenAIEmbedder{},
+			embedProvider:     "openai",
+			multilingualModel: tt.current,
+			stored:            tt.stored,
+			Chunks:            []Chunk{{ID: "a_p1_c0"}, {ID: "a_p2_c0", Embedding: make([]float32, tt.dims)}},
+		}
+		err := idx.CheckEmbedding()
+		var mismatch *EmbeddingMismatchError
+		if got := errors.As(err, &mismatch); got != tt.mismatch {
+			t.Errorf("%s: CheckEmbedding = %v, want mismatch %v", tt.name, err, tt.mismatch)
+		}
+		if health := idx.Check(); tt.mismatch && !strings.Contains(strings.Join(health.Problems, "\n"), err.Error()) {
+			t.Errorf("%s: Check problems %q don't report the mismatch", tt.name, health.Problems)
+		}
+	}
+
+	// An index not made by NewIndex doesn't know its models
+	if err := (&Index{stored: &hf}).CheckEmbedding(); err != nil {
+		t.Errorf("bare index: CheckEmbedding = %v", err)
+	}
+}
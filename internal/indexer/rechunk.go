@@ -0,0 +1,140 @@
+package indexer
+
+import (
+	"log"
+
+	"gocognigo/internal/extractor"
+)
+
+// ==================== Re-chunking ====================
+
+// EmbeddingCache holds embeddings by the text they embed and the embedder
+// that made them, so that a chunk whose text was embedded before gets that
+// embedding back instead of being embedded again (see SetReuse).
+type EmbeddingCache struct {
+	vectors map[embeddingKey][]float32
+}
+
+type embeddingKey struct {
+	text         string
+	multilingual bool // embedded with the index's Multilingual embedder
+}
+
+// NewEmbeddingCache caches the embeddings chunks carry. They must come
+// from the models of the index the cache is used with (see CheckEmbedding).
+func NewEmbeddingCache(chunks []Chunk) *EmbeddingCache {
+	c := &EmbeddingCache{vectors: make(map[embeddingKey][]float32, len(chunks))}
+	for _, ch := range chunks {
+		if len(ch.Embedding) > 0 {
+			c.vectors[embeddingKey{ch.Text, ch.Multilingual}] = ch.Embedding
+		}
+	}
+	return c
+}
+
+// Len returns how many embeddings the cache holds.
+func (c *EmbeddingCache) Len() int {
+	return len(c.vectors)
+}
+
+func (c *EmbeddingCache) get(text string, multilingual bool) []float32 {
+	return c.vectors[embeddingKey{text, multilingual}]
+}
+
+// SetReuse has EmbedAndIndex take the embeddings of chunks whose text cache
+// holds from it rather than from the embedders; nil embeds every chunk.
+func (idx *Index) SetReuse(cache *EmbeddingCache) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.reuse = cache
+}
+
+// addReused adds the chunks the reuse cache has embeddings for to the
+// index, returning the others, which still need embedding.
+func (idx *Index) addReused(chunks []Chunk) []Chunk {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.reuse == nil {
+		return chunks
+	}
+	var rest []Chunk
+	for _, c := range chunks {
+		emb := idx.reuse.get(c.Text, c.Multilingual)
+		if emb == nil {
+			rest = append(rest, c)
+			continue
+		}
+		c.Embedding = emb
+		idx.Chunks = append(idx.Chunks, c)
+		if err := idx.BM25Index.Index(c.ID, bm25Fields(c)); err != nil {
+			log.Printf("Failed to index BM25 for %s: %v", c.ID, err)
+		}
+	}
+	return rest
+}
+
+// Pages returns the pages chunks were cut from, as ChunkPages takes them,
+// in the order they first appear: a page chunk's ParentText is its page's
+// text. Summary nodes are left out.
+func Pages(chunks []Chunk) []extractor.DocumentChunk {
+	type pageKey struct {
+		doc  string
+		page int
+	}
+	seen := make(map[pageKey]bool)
+	var pages []extractor.DocumentChunk
+	for _, c := range chunks {
+		k := pageKey{c.Document, c.PageNumber}
+		if c.IsSummary() || seen[k] {
+			continue
+		}
+		seen[k] = true
+		pages = append(pages, extractor.DocumentChunk{
+			Document:      c.Document,
+			PageNumber:    c.PageNumber,
+			Text:          c.ParentText,
+			OCRConfidence: c.OCRConfidence,
+		})
+	}
+	return pages
+}
+
+// Rechunk takes the index's chunks to cut its pages again with the current
+// ChunkWords and ChunkOverlap, without extracting the documents again: it
+// empties the index and returns the new page chunks, then the summary
+// nodes, none of them embedded. With reuse, the old chunks' embeddings are
+// kept for EmbedAndIndex to give back to the new chunks of the same text
+// (see SetReuse), and reusable counts those chunks; reuse must be false if
+// the old chunks come from other models than the index's (see
+// CheckEmbedding), and then every chunk is embedded again.
+func (idx *Index) Rechunk(reuse bool) (chunks []Chunk, reusable int) {
+	idx.mu.Lock()
+	old := idx.Chunks
+	idx.Chunks = nil
+	if !reuse {
+		idx.stored = nil
+	}
+	idx.mu.Unlock()
+
+	chunks = idx.ChunkPages(Pages(old))
+	for _, c := range old {
+		if c.IsSummary() {
+			c.Embedding = nil
+			chunks = append(chunks, c)
+		}
+	}
+	if !reuse {
+		idx.SetReuse(nil)
+		return chunks, 0
+	}
+
+	cache := NewEmbeddingCache(old)
+	idx.SetReuse(cache)
+	for _, c := range chunks {
+		multilingual := c.Language != "" && c.Language != "en" && idx.Multilingual != nil
+		if cache.get(c.Text, multilingual) != nil {
+			reusable++
+		}
+	}
+	return chunks, reusable
+}
@@ -0,0 +1,270 @@
+package llm
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// CitationFix records one correction RepairCitations made to an answer's
+// footnotes.
+type CitationFix struct {
+	Footnote int    `json:"footnote"` // the footnote ID as the model gave it
+	Action   string `json:"action"`   // "dropped", "repaired", "merged" or "renumbered"
+	Detail   string `json:"detail"`
+}
+
+// markerRe matches footnote markers: [3], or several at once as [1, 2].
+var markerRe = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// RepairCitations checks an answer's footnotes against the context it was
+// given and fixes what the model got wrong:
+//
+//   - a document name that differs from a retrieved one only in case or
+//     extension is corrected, and a page that wasn't retrieved moves to the
+//     nearest retrieved page of the same document;
+//   - a footnote citing a document that is neither retrieved nor among the
+//     summaries is dropped, along with its markers;
+//   - a repeated ID keeps its first footnote, and footnotes citing the same
+//     page are merged into one;
+//   - markers are renumbered 1, 2, … in the order they first appear in the
+//     answer, and markers with no footnote are removed.
+//
+// Documents and Pages are rebuilt from the result, and each change is noted
+// in CitationFixes.
+func RepairCitations(answer *Answer, results []retriever.Result, summaries []indexer.DocumentSummary) {
+	if answer == nil || len(answer.Footnotes) == 0 && !markerRe.MatchString(answer.Answer) {
+		return
+	}
+	c := newCitationContext(results, summaries)
+
+	// Validate each footnote, mapping the model's IDs to surviving footnotes
+	var kept []Footnote
+	target := map[int]int{} // model ID -> index into kept, or -1 if dropped
+	seen := map[string]int{}
+	maxID := len(results)
+	for _, fn := range answer.Footnotes {
+		maxID = max(maxID, fn.ID)
+		if _, dup := target[fn.ID]; dup {
+			answer.addFix(fn.ID, "dropped", "duplicate footnote ID")
+			continue
+		}
+		fixed, detail, ok := c.resolve(fn)
+		if !ok {
+			target[fn.ID] = -1
+			answer.addFix(fn.ID, "dropped", detail)
+			continue
+		}
+		if detail != "" {
+			answer.addFix(fn.ID, "repaired", detail)
+		}
+		key := fmt.Sprintf("%s\x00%d", fixed.Document, fixed.Page)
+		if i, ok := seen[key]; ok {
+			target[fn.ID] = i
+			answer.addFix(fn.ID, "merged", fmt.Sprintf("cites the same page as footnote %d", kept[i].ID))
+			continue
+		}
+		seen[key] = len(kept)
+		target[fn.ID] = len(kept)
+		kept = append(kept, fixed)
+	}
+
+	// Number the kept footnotes by first appearance, then the uncited ones
+	number := make([]int, len(kept))
+	next := 1
+	for _, m := range markerRe.FindAllStringSubmatch(answer.Answer, -1) {
+		for _, id := range markerIDs(m[1]) {
+			if i, ok := target[id]; ok && i >= 0 && number[i] == 0 {
+				number[i] = next
+				next++
+			}
+		}
+	}
+	for i := range kept {
+		if number[i] == 0 {
+			number[i] = next
+			next++
+		}
+	}
+
+	answer.Answer = markerRe.ReplaceAllStringFunc(answer.Answer, func(m string) string {
+		ids := markerIDs(markerRe.FindStringSubmatch(m)[1])
+		for _, id := range ids {
+			if id > maxID {
+				return m // a bracketed number such as a year, not a marker
+			}
+		}
+		var out []int
+		for _, id := range ids {
+			if i, ok := target[id]; ok && i >= 0 && !containsInt(out, number[i]) {
+				out = append(out, number[i])
+			}
+		}
+		if len(out) == 0 {
+			return ""
+		}
+		sort.Ints(out)
+		parts := make([]string, len(out))
+		for i, n := range out {
+			parts[i] = strconv.Itoa(n)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	})
+	answer.Answer = dedupeMarkers(answer.Answer)
+
+	footnotes := make([]Footnote, len(kept))
+	for i, fn := range kept {
+		if number[i] != fn.ID {
+			answer.addFix(fn.ID, "renumbered", fmt.Sprintf("now [%d]", number[i]))
+		}
+		fn.ID = number[i]
+		footnotes[number[i]-1] = fn
+	}
+	answer.Footnotes = footnotes
+	answer.Documents, answer.Pages = citedSources(footnotes)
+}
+
+// validFootnotes drops the footnotes a model sent without a document and
+// trims the names of the rest.
+func validFootnotes(footnotes []Footnote) []Footnote {
+	var out []Footnote
+	for _, fn := range footnotes {
+		fn.Document = strings.TrimSpace(fn.Document)
+		if fn.Document != "" {
+			out = append(out, fn)
+		}
+	}
+	return out
+}
+
+// citedSources is the legacy Documents and Pages of an answer: the document
+// and page of each footnote, in order.
+func citedSources(footnotes []Footnote) (documents []string, pages []int) {
+	documents, pages = make([]string, len(footnotes)), make([]int, len(footnotes))
+	for i, fn := range footnotes {
+		documents[i], pages[i] = fn.Document, fn.Page
+	}
+	return documents, pages
+}
+
+func (a *Answer) addFix(id int, action, detail string) {
+	a.CitationFixes = append(a.CitationFixes, CitationFix{Footnote: id, Action: action, Detail: detail})
+}
+
+// citationContext is what an answer may cite: the pages retrieved for it,
+// by document, and the documents it was given summaries of.
+type citationContext struct {
+	pages      map[string][]pageSpan
+	summarized map[string]bool
+	names      map[string]string // normalized name -> name as indexed
+}
+
+type pageSpan struct{ start, end int }
+
+func newCitationContext(results []retriever.Result, summaries []indexer.DocumentSummary) *citationContext {
+	c := &citationContext{pages: map[string][]pageSpan{}, summarized: map[string]bool{}, names: map[string]string{}}
+	for _, r := range results {
+		span := pageSpan{r.PageNumber, r.PageNumber}
+		if r.PageEnd > r.PageStart {
+			span = pageSpan{r.PageStart, r.PageEnd}
+		}
+		c.pages[r.Document] = append(c.pages[r.Document], span)
+		c.names[normalizeDocName(r.Document)] = r.Document
+	}
+	for _, s := range summaries {
+		c.summarized[s.Document] = true
+		if _, ok := c.names[normalizeDocName(s.Document)]; !ok {
+			c.names[normalizeDocName(s.Document)] = s.Document
+		}
+	}
+	return c
+}
+
+// normalizeDocName folds the differences models introduce when copying a
+// document name: case, surrounding space and the file extension.
+func normalizeDocName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// resolve returns fn corrected to cite the context, with a description of
+// the correction ("" if none was needed), or ok false if it cites nothing
+// the answer was given.
+func (c *citationContext) resolve(fn Footnote) (fixed Footnote, detail string, ok bool) {
+	fixed = fn
+	var notes []string
+	if _, known := c.pages[fn.Document]; !known && !c.summarized[fn.Document] {
+		name, found := c.names[normalizeDocName(fn.Document)]
+		if !found {
+			return fn, fmt.Sprintf("%s is not among the retrieved documents", fn.Document), false
+		}
+		fixed.Document = name
+		notes = append(notes, fmt.Sprintf("document %q → %q", fn.Document, name))
+	}
+
+	spans := c.pages[fixed.Document]
+	if len(spans) == 0 {
+		// Only summarized: the page can't be checked, so it stands
+		return fixed, strings.Join(notes, "; "), true
+	}
+	best, bestDist := 0, -1
+	for _, s := range spans {
+		if fixed.Page >= s.start && fixed.Page <= s.end {
+			return fixed, strings.Join(notes, "; "), true
+		}
+		page, dist := s.start, s.start-fixed.Page
+		if fixed.Page > s.end {
+			page, dist = s.end, fixed.Page-s.end
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = page, dist
+		}
+	}
+	notes = append(notes, fmt.Sprintf("page %d was not retrieved → page %d", fixed.Page, best))
+	fixed.Page = best
+	return fixed, strings.Join(notes, "; "), true
+}
+
+// dedupeMarkers drops a marker that directly repeats the one before it, as
+// merging footnotes leaves "[2][2]".
+func dedupeMarkers(text string) string {
+	var b strings.Builder
+	prev, last := "", 0
+	for _, loc := range markerRe.FindAllStringIndex(text, -1) {
+		m := text[loc[0]:loc[1]]
+		if loc[0] == last && m == prev {
+			last = loc[1]
+			continue
+		}
+		b.WriteString(text[last:loc[1]])
+		prev, last = m, loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// markerIDs parses the numbers of a marker's contents ("1, 2").
+func markerIDs(s string) []int {
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+			ids = append(ids, n)
+		}
+	}
+	return ids
+}
+
+func containsInt(xs []int, x int) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
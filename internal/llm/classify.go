@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"regexp"
+	"strings"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ==================== Query Classification ====================
+
+// Query types ClassifyQuery assigns.
+const (
+	QueryLookup        = "lookup"        // one fact from one place
+	QueryEnumeration   = "enumeration"   // every instance of something, or a count
+	QueryComparison    = "comparison"    // two or more things side by side
+	QuerySummarization = "summarization" // an overview of a document or the corpus
+)
+
+// QueryTypes lists the known query types.
+func QueryTypes() []string {
+	return []string{QueryLookup, QueryEnumeration, QueryComparison, QuerySummarization}
+}
+
+var (
+	comparisonPattern    = regexp.MustCompile(`(?i)\b(compare|comparison|compared|versus|vs\.?|contrast|differ|differs|difference|differences|similarities)\b`)
+	summarizationPattern = regexp.MustCompile(`(?i)\b(summari[sz]e|give (me )?an? (summary|overview)|overview|gist|key (points|takeaways|themes)|main (points|themes|findings))\b|\bwhat (is|are) (this|these|the) (document|documents|report|reports|file|files) about\b`)
+	enumerationPattern   = regexp.MustCompile(`(?i)\b(list|enumerate|how many|count|all the|every|each of)\b|\b(which|what) (documents|companies|parties|cases|entities|clauses|risks)\b|\bname (all|the)\b`)
+)
+
+// ClassifyQuery labels a question with the query type that decides its
+// retrieval strategy. It is a keyword heuristic, free and instant; a
+// question matching none of the patterns is a lookup.
+func ClassifyQuery(question string) string {
+	switch {
+	case comparisonPattern.MatchString(question):
+		return QueryComparison
+	case summarizationPattern.MatchString(question):
+		return QuerySummarization
+	case enumerationPattern.MatchString(question):
+		return QueryEnumeration
+	}
+	return QueryLookup
+}
+
+// QueryStrategy is how a type of question is retrieved and answered.
+type QueryStrategy struct {
+	TopK int // chunks to retrieve
+	// AllSummaries puts every document's overview in the context, not
+	// only those of the documents the excerpts come from.
+	AllSummaries bool
+	Prompt       string // added to the system prompt; may be empty
+}
+
+var queryStrategies = map[string]QueryStrategy{
+	QueryLookup: {TopK: 10},
+	QueryEnumeration: {TopK: 40, AllSummaries: true,
+		Prompt: `This question asks for a complete list or a count. Go through every excerpt and document overview, list each distinct item once with its citation, and give the total. Say so if the excerpts may not cover every instance.`},
+	QueryComparison: {TopK: 30, AllSummaries: true,
+		Prompt: `This question asks for a comparison. Cover each side in turn, then set out the similarities and differences point by point (a table works well), citing each side's sources. Say so when the excerpts cover only one side.`},
+	QuerySummarization: {TopK: 20, AllSummaries: true,
+		Prompt: `This question asks for an overview. Synthesize the main points across the document overviews and excerpts instead of dwelling on a single passage, and keep each point cited.`},
+}
+
+// StrategyFor returns the retrieval strategy of a query type; an unknown
+// type gets the lookup strategy.
+func StrategyFor(queryType string) QueryStrategy {
+	if st, ok := queryStrategies[strings.ToLower(queryType)]; ok {
+		return st
+	}
+	return queryStrategies[QueryLookup]
+}
+
+// Summaries returns the document summaries to put in the context: all of
+// them, or only those of the documents results come from.
+func (st QueryStrategy) Summaries(results []retriever.Result, summaries []indexer.DocumentSummary) []indexer.DocumentSummary {
+	if st.AllSummaries {
+		return summaries
+	}
+	cited := make(map[string]bool, len(results))
+	for _, r := range results {
+		cited[r.Document] = true
+	}
+	var out []indexer.DocumentSummary
+	for _, s := range summaries {
+		if cited[s.Document] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gocognigo/internal/retriever"
+)
+
+// ==========================================
+// Cross-document Comparison
+// ==========================================
+
+// AllMaterialTerms is the comparison topic that covers every material term
+// rather than one subject.
+const AllMaterialTerms = "all material terms"
+
+// MaterialTermProbes are the retrieval queries run against each document
+// when comparing all material terms.
+var MaterialTermProbes = []string{
+	"parties and their roles",
+	"purpose, scope and subject matter",
+	"price, fees, payment terms and amounts",
+	"term, duration, renewal and termination",
+	"obligations, warranties and representations",
+	"liability, indemnity and remedies",
+	"governing law and dispute resolution",
+}
+
+// DocumentComparison is a side-by-side comparison of two documents.
+type DocumentComparison struct {
+	Topic     string          `json:"topic"`
+	Documents [2]string       `json:"documents"`
+	Summary   string          `json:"summary"`
+	Rows      []ComparisonRow `json:"rows"`
+	Usage     *Usage          `json:"usage,omitempty"`
+}
+
+// ComparisonRow compares the two documents on one aspect of the topic.
+type ComparisonRow struct {
+	Aspect     string            `json:"aspect"`
+	Values     [2]ComparisonCell `json:"values"` // in Documents order
+	Difference string            `json:"difference"`
+}
+
+// ComparisonCell is what one document says about an aspect. Pages cite the
+// excerpts it came from; an empty Value means the document is silent.
+type ComparisonCell struct {
+	Value string `json:"value"`
+	Pages []int  `json:"pages"`
+}
+
+// CompareDocuments compares two documents on a topic (or AllMaterialTerms)
+// from excerpts retrieved from each, with c. Cited pages that were not
+// among a document's excerpts are dropped.
+func CompareDocuments(ctx context.Context, c Completer, topic string, documents [2]string, excerpts [2][]retriever.Result) (*DocumentComparison, error) {
+	if c.APIKey == "" {
+		return nil, fmt.Errorf("an API key is required for document comparison")
+	}
+
+	var sb strings.Builder
+	for i, doc := range documents {
+		sb.WriteString(fmt.Sprintf("=== DOCUMENT %c: %s ===\n\n", 'A'+i, doc))
+		for _, r := range excerpts[i] {
+			text := r.ParentText
+			if text == "" {
+				text = r.Text
+			}
+			sb.WriteString(fmt.Sprintf("[%c p.%d]\n%s\n\n", 'A'+i, r.PageNumber, text))
+		}
+	}
+
+	focus := fmt.Sprintf("on %q", topic)
+	if topic == AllMaterialTerms {
+		focus = "on all of their material terms (parties, scope, money, term and termination, obligations, liability, governing law, and anything else that differs materially)"
+	}
+	prompt := fmt.Sprintf(`Compare Document A (%s) and Document B (%s) %s, using only the excerpts below.
+
+%s
+Return ONLY valid JSON in this exact format:
+{
+  "summary": "Two or three sentences on how the documents differ overall",
+  "rows": [
+    {
+      "aspect": "What is being compared",
+      "a": {"value": "What Document A says, with exact figures and wording", "pages": [3]},
+      "b": {"value": "What Document B says", "pages": [5]},
+      "difference": "The difference, or \"Same\" if they agree"
+    }
+  ]
+}
+
+Cite the page numbers of the excerpts each value comes from. If a document says nothing about an aspect, use an empty value and no pages.`,
+		documents[0], documents[1], focus, sb.String())
+
+	raw, usage, err := c.CompleteJSON(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	var parsed struct {
+		Summary string `json:"summary"`
+		Rows    []struct {
+			Aspect     string         `json:"aspect"`
+			A          ComparisonCell `json:"a"`
+			B          ComparisonCell `json:"b"`
+			Difference string         `json:"difference"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return nil, fmt.Errorf("parse comparison: %w", err)
+	}
+
+	cmp := &DocumentComparison{Topic: topic, Documents: documents, Summary: parsed.Summary, Rows: []ComparisonRow{}, Usage: usage}
+	for _, row := range parsed.Rows {
+		cells := [2]ComparisonCell{row.A, row.B}
+		for i := range cells {
+			cells[i].Pages = retrievedPages(cells[i].Pages, excerpts[i])
+		}
+		cmp.Rows = append(cmp.Rows, ComparisonRow{Aspect: row.Aspect, Values: cells, Difference: row.Difference})
+	}
+	return cmp, nil
+}
+
+// retrievedPages keeps the pages that were among the excerpts.
+func retrievedPages(pages []int, excerpts []retriever.Result) []int {
+	kept := []int{}
+	for _, p := range pages {
+		for _, r := range excerpts {
+			if r.PageNumber == p {
+				kept = append(kept, p)
+				break
+			}
+		}
+	}
+	return kept
+}
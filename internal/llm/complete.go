@@ -0,0 +1,208 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"gocognigo/internal/httpclient"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ==========================================
+// Background Completions
+// ==========================================
+
+// Completer runs the single-prompt JSON calls behind background tasks such
+// as ingest summaries, on whichever provider has been configured for them.
+type Completer struct {
+	Provider string // "openai" (or ""), "anthropic" or "huggingface"
+	APIKey   string
+	Model    string // "" uses DefaultSummaryModel(Provider)
+}
+
+// DefaultSummaryModel returns the cheap model a provider's background calls
+// use when none is configured.
+func DefaultSummaryModel(providerName string) string {
+	switch strings.ToLower(providerName) {
+	case "openai", "":
+		return summaryModel
+	case "anthropic":
+		return "claude-3-5-haiku-latest"
+	case "huggingface":
+		return "Qwen/Qwen2.5-7B-Instruct-1M"
+	}
+	return ""
+}
+
+func (c Completer) model() string {
+	if c.Model != "" {
+		return c.Model
+	}
+	return DefaultSummaryModel(c.Provider)
+}
+
+// CompleteJSON sends prompt, which must ask for a JSON reply, and returns
+// the reply with any code fence around it removed.
+func (c Completer) CompleteJSON(ctx context.Context, prompt string) (string, *Usage, error) {
+	if c.APIKey == "" {
+		return "", nil, fmt.Errorf("no API key configured for %s", c.Provider)
+	}
+	provider := strings.ToLower(c.Provider)
+	if provider == "" {
+		provider = "openai"
+	}
+	release, err := acquireSlot(ctx, provider, c.model())
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+
+	raw, usage, err := c.send(ctx, provider, prompt)
+	if err != nil {
+		return "", nil, fmt.Errorf("summary LLM call failed: %w", err)
+	}
+	if usage == nil {
+		usage = EstimateUsage(prompt, raw)
+	}
+	return stripCodeFence(raw), usage, nil
+}
+
+// send makes the call for CompleteJSON without taking a limiter slot, for
+// callers that already hold one.
+func (c Completer) send(ctx context.Context, provider, prompt string) (raw string, usage *Usage, err error) {
+	model := c.model()
+	switch provider {
+	case "openai":
+		raw, usage, err = completeOpenAIJSON(ctx, c.APIKey, model, prompt)
+	case "anthropic":
+		raw, usage, err = completeAnthropic(ctx, c.APIKey, model, prompt)
+	case "huggingface":
+		raw, usage, err = completeHuggingFace(ctx, c.APIKey, model, prompt)
+	default:
+		return "", nil, fmt.Errorf("unknown LLM provider: %s", c.Provider)
+	}
+	return raw, usage, err
+}
+
+// stripCodeFence removes a ```json fence models sometimes wrap JSON in.
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "```json\n")
+	s = strings.TrimPrefix(s, "```\n")
+	s = strings.Split(s, "```")[0]
+	return strings.TrimSpace(s)
+}
+
+func completeOpenAIJSON(ctx context.Context, apiKey, model, prompt string) (string, *Usage, error) {
+	req := openai.ChatCompletionRequest{
+		Model: model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: prompt},
+		},
+	}
+	if !isReasoningModel(model) {
+		req.Temperature = 0.1
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	}
+	resp, err := newOpenAIClient(apiKey).CreateChatCompletion(ctx, req)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return "", nil, fmt.Errorf("empty response from summary LLM")
+	}
+	return resp.Choices[0].Message.Content,
+		&Usage{InputTokens: resp.Usage.PromptTokens, OutputTokens: resp.Usage.CompletionTokens}, nil
+}
+
+func completeAnthropic(ctx context.Context, apiKey, model, prompt string) (string, *Usage, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":       model,
+		"max_tokens":  4096,
+		"temperature": 0.1,
+		"messages":    []map[string]string{{"role": "user", "content": prompt}},
+	})
+	req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(body))
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", "2023-06-01")
+	req.Header.Set("content-type", "application/json")
+
+	var out struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := doCompletion(req, &out); err != nil {
+		return "", nil, err
+	}
+	var sb strings.Builder
+	for _, block := range out.Content {
+		if block.Type == "text" {
+			sb.WriteString(block.Text)
+		}
+	}
+	if sb.Len() == 0 {
+		return "", nil, fmt.Errorf("empty response from summary LLM")
+	}
+	return sb.String(), &Usage{InputTokens: out.Usage.InputTokens, OutputTokens: out.Usage.OutputTokens}, nil
+}
+
+func completeHuggingFace(ctx context.Context, apiKey, model, prompt string) (string, *Usage, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":       model,
+		"max_tokens":  4096,
+		"temperature": 0.1,
+		"messages":    []map[string]string{{"role": "user", "content": prompt}},
+	})
+	req, _ := http.NewRequestWithContext(ctx, "POST", "https://router.huggingface.co/v1/chat/completions", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	var out struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := doCompletion(req, &out); err != nil {
+		return "", nil, err
+	}
+	if len(out.Choices) == 0 {
+		return "", nil, fmt.Errorf("empty response from summary LLM")
+	}
+	var usage *Usage
+	if out.Usage != nil {
+		usage = &Usage{InputTokens: out.Usage.PromptTokens, OutputTokens: out.Usage.CompletionTokens}
+	}
+	return out.Choices[0].Message.Content, usage, nil
+}
+
+// doCompletion sends req and decodes a 200 response into out.
+func doCompletion(req *http.Request, out interface{}) error {
+	resp, err := httpclient.Default.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
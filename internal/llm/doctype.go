@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"sort"
+	"strings"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ==================== Doc-Type Prompts ====================
+
+// DocTypePrompts are the extra answering instructions for each document
+// type GenerateDocSummary assigns, added when the retrieved excerpts come
+// from documents of that type.
+var DocTypePrompts = map[string]string{
+	"legal_case":        `For court judgments and case law: distinguish the holding from obiter dicta and from the parties' arguments; name the court, the bench and the date when they matter; quote the operative order exactly; cite paragraph numbers where the excerpt shows them.`,
+	"financial_report":  `For financial statements and annual reports: state the reporting period, currency and unit (lakhs, crores, millions) of every figure; keep standalone and consolidated figures apart; do not compute ratios or totals the document does not state unless asked, and show the working when you do.`,
+	"regulatory_filing": `For regulatory filings and circulars: name the regulator, the filing or circular number and its effective date; separate mandatory requirements ("shall", "must") from guidance; note when a provision amends or supersedes an earlier one.`,
+	"contract":          `For contracts and agreements: identify the parties by their defined names; quote obligations, conditions and exceptions exactly, including their clause numbers; point out where a definition, schedule or cross-referenced clause changes the plain reading.`,
+	"transcript":        `For transcripts of calls, hearings and meetings: attribute every statement to its speaker; distinguish what was said from what was decided or agreed; keep forward-looking statements and guidance clearly marked as such.`,
+}
+
+// DocTypes lists the known document types, "other" included.
+func DocTypes() []string {
+	types := make([]string, 0, len(DocTypePrompts)+1)
+	for t := range DocTypePrompts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return append(types, "other")
+}
+
+// DocTypeGuidance returns the instructions for the document types of the
+// retrieved results, most-cited type first. overrides replaces the built-in
+// prompt of a type; an empty override turns that type's prompt off.
+func DocTypeGuidance(results []retriever.Result, summaries []indexer.DocumentSummary, overrides map[string]string) string {
+	docType := make(map[string]string, len(summaries))
+	for _, s := range summaries {
+		docType[s.Document] = strings.ToLower(strings.TrimSpace(s.DocType))
+	}
+
+	counts := map[string]int{}
+	var order []string
+	for _, r := range results {
+		t := docType[r.Document]
+		if t == "" {
+			continue
+		}
+		if counts[t] == 0 {
+			order = append(order, t)
+		}
+		counts[t]++
+	}
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+
+	var parts []string
+	for _, t := range order {
+		prompt, ok := overrides[t]
+		if !ok {
+			prompt = DocTypePrompts[t]
+		}
+		if prompt = strings.TrimSpace(prompt); prompt != "" {
+			parts = append(parts, prompt)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
@@ -83,7 +83,11 @@ Current question: %s
 
 Respond with ONLY a JSON object: {"enhanced": "your rewritten question here"}`, corpusList, historyText, question)
 
-	client := openai.NewClient(apiKey)
+	release, err := acquireSlot(ctx, "openai", "gpt-4o-mini")
+	if err != nil {
+		return question, nil // cancelled while queued — fall back like other failures
+	}
+	client := newOpenAIClient(apiKey)
 	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
 		Model: "gpt-4o-mini",
 		Messages: []openai.ChatCompletionMessage{
@@ -93,6 +97,7 @@ Respond with ONLY a JSON object: {"enhanced": "your rewritten question here"}`,
 		MaxTokens:      256,
 		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
 	})
+	release()
 	if err != nil {
 		log.Printf("EnhanceQuery: LLM call failed (falling back to original): %v", err)
 		return question, nil // graceful fallback
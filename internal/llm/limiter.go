@@ -0,0 +1,126 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// ==========================================
+// Concurrency Limiter
+// ==========================================
+
+// Every LLM call (answers, streams, summaries, query enhancement) takes a
+// slot from its provider's weighted semaphore first. Calls beyond the limit
+// queue in FIFO order until a slot frees up or their context ends, so a
+// 100-question batch no longer fires 100 simultaneous requests at the API.
+//
+// Limits default per provider and can be overridden with
+// LLM_CONCURRENCY_OPENAI, LLM_CONCURRENCY_ANTHROPIC and
+// LLM_CONCURRENCY_HUGGINGFACE.
+
+var defaultConcurrency = map[string]int64{
+	"openai":      8,
+	"anthropic":   4,
+	"huggingface": 4,
+}
+
+type providerSlots struct {
+	sem      *semaphore.Weighted
+	limit    int64
+	inFlight int64 // weight currently held
+	waiting  int64 // calls queued for a slot
+}
+
+var (
+	limiterMu sync.Mutex
+	limiters  = map[string]*providerSlots{}
+)
+
+func slotsFor(provider string) *providerSlots {
+	provider = strings.ToLower(provider)
+	if provider == "" {
+		provider = "openai"
+	}
+
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+
+	if ps, ok := limiters[provider]; ok {
+		return ps
+	}
+	limit := defaultConcurrency[provider]
+	if limit == 0 {
+		limit = 4
+	}
+	if v, err := strconv.ParseInt(os.Getenv("LLM_CONCURRENCY_"+strings.ToUpper(provider)), 10, 64); err == nil && v > 0 {
+		limit = v
+	}
+	ps := &providerSlots{sem: semaphore.NewWeighted(limit), limit: limit}
+	limiters[provider] = ps
+	return ps
+}
+
+// callWeight is the number of slots a call to model occupies. Thinking and
+// reasoning models hold a connection far longer and burn more of the rate
+// limit, so they count double.
+func callWeight(model string) int64 {
+	if isAdaptiveThinkingModel(model) || isExtendedThinkingModel(model) || isReasoningModel(model) {
+		return 2
+	}
+	return 1
+}
+
+// acquireSlot blocks until the provider has capacity for a call to model.
+// The returned release func must be called when the call completes.
+func acquireSlot(ctx context.Context, provider, model string) (release func(), err error) {
+	ps := slotsFor(provider)
+	weight := callWeight(model)
+	if weight > ps.limit {
+		weight = ps.limit
+	}
+
+	atomic.AddInt64(&ps.waiting, 1)
+	err = ps.sem.Acquire(ctx, weight)
+	atomic.AddInt64(&ps.waiting, -1)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&ps.inFlight, weight)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&ps.inFlight, -weight)
+			ps.sem.Release(weight)
+		})
+	}, nil
+}
+
+// QueueStats describes one provider's limiter state.
+type QueueStats struct {
+	Limit    int64 `json:"limit"`
+	InFlight int64 `json:"in_flight"`
+	Waiting  int64 `json:"waiting"`
+}
+
+// LimiterStats reports the state of every provider limiter used so far.
+func LimiterStats() map[string]QueueStats {
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+
+	stats := make(map[string]QueueStats, len(limiters))
+	for name, ps := range limiters {
+		stats[name] = QueueStats{
+			Limit:    ps.limit,
+			InFlight: atomic.LoadInt64(&ps.inFlight),
+			Waiting:  atomic.LoadInt64(&ps.waiting),
+		}
+	}
+	return stats
+}
@@ -8,10 +8,10 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"regexp"
 	"strings"
-	"time"
 
+	"gocognigo/internal/analysis"
+	"gocognigo/internal/httpclient"
 	"gocognigo/internal/indexer"
 	"gocognigo/internal/retriever"
 
@@ -35,6 +35,51 @@ type Answer struct {
 	Footnotes        []Footnote `json:"footnotes,omitempty"`
 	Confidence       float64    `json:"confidence"`
 	ConfidenceReason string     `json:"confidence_reason,omitempty"`
+	Usage            *Usage     `json:"usage,omitempty"`
+
+	// NumberChecks verifies each figure in the answer against its cited
+	// page; filled in by the server after the answer is generated.
+	NumberChecks []analysis.NumberCheck `json:"number_checks,omitempty"`
+
+	// CitationFixes lists the footnotes RepairCitations dropped, repaired,
+	// merged or renumbered; filled in by the server.
+	CitationFixes []CitationFix `json:"citation_fixes,omitempty"`
+
+	// Verification is a second model's consistency check of the answer
+	// against its cited excerpts, when one was asked for (see
+	// VerifyAnswer).
+	Verification *Verification `json:"verification,omitempty"`
+
+	// Data is the structured output requested with a schema (see
+	// AnswerStructured); SchemaErrors lists how it still fails the schema
+	// after every retry.
+	Data         json.RawMessage `json:"data,omitempty"`
+	SchemaErrors []string        `json:"schema_errors,omitempty"`
+
+	// ToolSteps lists the tool calls made before answering (see
+	// AnswerWithTools).
+	ToolSteps []ToolStep `json:"tool_steps,omitempty"`
+}
+
+// Usage is the token count of a single LLM call. Providers fill it from the
+// API response; callers fall back to EstimateUsage when it is nil.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Total returns input plus output tokens.
+func (u *Usage) Total() int {
+	if u == nil {
+		return 0
+	}
+	return u.InputTokens + u.OutputTokens
+}
+
+// EstimateUsage approximates token counts at ~4 characters per token, for
+// paths (such as streaming) where the provider does not report usage.
+func EstimateUsage(prompt, completion string) *Usage {
+	return &Usage{InputTokens: len(prompt) / 4, OutputTokens: len(completion) / 4}
 }
 
 // Provider defines the interface for different LLM backends
@@ -44,37 +89,58 @@ type Provider interface {
 
 // buildSystemPrompt combines the base system prompt with an optional custom
 // system prompt. The custom prompt is prepended as additional context/instructions.
+// A second, non-empty element replaces the base instructions (a project's
+// house style); the JSON response format is appended either way.
 func buildSystemPrompt(customSystemPrompt ...string) string {
+	base := defaultBasePrompt
+	if len(customSystemPrompt) > 1 && strings.TrimSpace(customSystemPrompt[1]) != "" {
+		base = customSystemPrompt[1]
+	}
+	base += "\n\n" + responseFormatPrompt
 	if len(customSystemPrompt) > 0 && customSystemPrompt[0] != "" {
-		return customSystemPrompt[0] + "\n\n---\n\n" + baseSystemPrompt
+		return customSystemPrompt[0] + "\n\n---\n\n" + base
 	}
-	return baseSystemPrompt
+	return base
 }
 
 // NewProvider creates the appropriate LLM provider based on config
 func NewProvider(providerName, apiKey, model string) (Provider, error) {
 	providerName = strings.ToLower(providerName)
+	if model == "" {
+		model = DefaultModel(providerName)
+	}
 	switch providerName {
 	case "openai", "":
-		if model == "" {
-			model = openai.GPT4o
-		}
-		return &OpenAIProvider{client: openai.NewClient(apiKey), model: model}, nil
+		return &OpenAIProvider{client: newOpenAIClient(apiKey), apiKey: apiKey, model: model}, nil
 	case "huggingface":
-		if model == "" {
-			model = "Qwen/Qwen2.5-7B-Instruct-1M"
-		}
 		return &HuggingFaceProvider{apiKey: apiKey, model: model}, nil
 	case "anthropic":
-		if model == "" {
-			model = "claude-opus-4-6"
-		}
 		return &AnthropicProvider{apiKey: apiKey, model: model}, nil
 	default:
 		return nil, fmt.Errorf("unknown LLM provider: %s", providerName)
 	}
 }
 
+// newOpenAIClient is an OpenAI client on the shared HTTP pool.
+func newOpenAIClient(apiKey string) *openai.Client {
+	cfg := openai.DefaultConfig(apiKey)
+	cfg.HTTPClient = httpclient.Default
+	return openai.NewClientWithConfig(cfg)
+}
+
+// DefaultModel returns the model NewProvider uses when none is requested.
+func DefaultModel(providerName string) string {
+	switch strings.ToLower(providerName) {
+	case "openai", "":
+		return openai.GPT4o
+	case "huggingface":
+		return "Qwen/Qwen2.5-7B-Instruct-1M"
+	case "anthropic":
+		return "claude-opus-4-6"
+	}
+	return ""
+}
+
 // FormatContext builds the context string for prompts.
 // Uses ParentText (full page) when available for richer LLM context.
 func FormatContext(results []retriever.Result, summaries []indexer.DocumentSummary) string {
@@ -111,15 +177,26 @@ func FormatContext(results []retriever.Result, summaries []indexer.DocumentSumma
 			text = r.Text // fallback for legacy chunks without parent
 		}
 		header := fmt.Sprintf("[Source %d] Document: %s | Page: %d", i+1, r.Document, r.PageNumber)
+		if r.PageEnd > r.PageStart {
+			// A whole section: cite the [Page N] each fact is on
+			header = fmt.Sprintf("[Source %d] Document: %s | Pages: %d-%d", i+1, r.Document, r.PageStart, r.PageEnd)
+		}
 		if r.Section != "" {
 			header += " | Section: " + r.Section
 		}
+		if r.Level != "" {
+			// A summary node: an overview to orient by, not a quotable page
+			header += " | " + r.Level + " summary"
+		}
 		parts = append(parts, fmt.Sprintf("%s\n%s", header, text))
 	}
 	return strings.Join(parts, "\n\n---\n\n")
 }
 
-var baseSystemPrompt = `You are a precise document analysis assistant. You will be given a question and relevant excerpts from a corpus of legal, financial, and regulatory documents.
+// defaultBasePrompt is the instruction part of the system prompt: role,
+// reasoning, citation and conversation rules. A project may replace it (see
+// buildSystemPrompt); responseFormatPrompt is always kept so answers parse.
+const defaultBasePrompt = `You are a precise document analysis assistant. You will be given a question and relevant excerpts from a corpus of legal, financial, and regulatory documents.
 
 Your task:
 1. THINK step-by-step through the question before answering
@@ -127,18 +204,6 @@ Your task:
 3. Use inline footnote markers like [1], [2] in your answer to cite specific claims
 4. Be precise — use exact figures, names, and quotes when possible
 
-Respond in this exact JSON format:
-{
-  "thinking": "Let me analyze the question step by step. First, I need to... [your reasoning process here]",
-  "answer": "The revenue was $50B[1] with growth of 12%[2].",
-  "footnotes": [
-    {"id": 1, "document": "doc1.pdf", "page": 3},
-    {"id": 2, "document": "doc2.pdf", "page": 12}
-  ],
-  "confidence": 0.95,
-  "confidence_reason": "Exact figures found in two source documents"
-}
-
 Thinking rules:
 - In the "thinking" field, reason through the problem step by step
 - Identify which sources are relevant and why
@@ -166,11 +231,25 @@ Legal & regulatory citation rules:
 Conversation context:
 - You may receive prior conversation messages (user questions and assistant answers) for context.
 - Use them to understand references like "it", "that document", "the same company", "this section", etc.
-- Always prioritise the document excerpts provided for factual answers — conversation history is for reference resolution only.
+- Always prioritise the document excerpts provided for factual answers — conversation history is for reference resolution only.`
 
-Also keep the legacy fields for backward compatibility:
-- "documents": array of all cited document names
-- "pages": array of corresponding page numbers`
+const responseFormatPrompt = `Respond in this exact JSON format:
+{
+  "thinking": "Let me analyze the question step by step. First, I need to... [your reasoning process here]",
+  "answer": "The revenue was $50B[1] with growth of 12%[2].",
+  "footnotes": [
+    {"id": 1, "document": "doc1.pdf", "page": 3},
+    {"id": 2, "document": "doc2.pdf", "page": 12}
+  ],
+  "confidence": 0.95,
+  "confidence_reason": "Exact figures found in two source documents"
+}`
+
+// DefaultBasePrompt returns the built-in base instructions, for display
+// alongside a project's override.
+func DefaultBasePrompt() string {
+	return defaultBasePrompt
+}
 
 // isReasoningModel returns true for OpenAI o-series reasoning models
 // that do not support temperature, top_p, or response_format parameters.
@@ -202,23 +281,31 @@ func isExtendedThinkingModel(model string) bool {
 // OpenAI Provider
 // ==========================================
 type OpenAIProvider struct {
-	client *openai.Client
-	model  string
+	client    *openai.Client
+	apiKey    string
+	model     string
+	reasoning Reasoning // see WithReasoning
 }
 
 func (p *OpenAIProvider) AnswerQuestion(ctx context.Context, question string, results []retriever.Result, summaries []indexer.DocumentSummary, history []ChatMessage, customSystemPrompt ...string) (*Answer, error) {
+	release, err := acquireSlot(ctx, "openai", p.model)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	sysPrompt := buildSystemPrompt(customSystemPrompt...)
+	results, summaries = fitContext(p.model, openAIMaxTokens, sysPrompt+question+historyText(history), results, summaries)
 	contextStr := FormatContext(results, summaries)
 	userPrompt := fmt.Sprintf("**Question:** %s\n\n**Context:**\n\n%s", question, contextStr)
-	sysPrompt := buildSystemPrompt(customSystemPrompt...)
 
 	// Build message list with conversation history
 	historyMsgs := buildHistoryMessages(history)
 
 	var resp openai.ChatCompletionResponse
-	var err error
 
 	// Retry logic for rate limits (429) and server errors (5xx)
-	for attempt := 0; attempt < 5; attempt++ {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
 		if ctx.Err() != nil {
 			return nil, ctx.Err()
 		}
@@ -236,7 +323,8 @@ func (p *OpenAIProvider) AnswerQuestion(ctx context.Context, question string, re
 			resp, err = p.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
 				Model:               p.model,
 				Messages:            msgs,
-				MaxCompletionTokens: 4096,
+				MaxCompletionTokens: maxOutput(p.model, openAIMaxTokens),
+				ReasoningEffort:     p.reasoning.openAIEffort(),
 			})
 		} else {
 			msgs := []openai.ChatCompletionMessage{
@@ -257,30 +345,11 @@ func (p *OpenAIProvider) AnswerQuestion(ctx context.Context, question string, re
 		}
 
 		// Check if it's a retriable error (429 Too Many Requests or 5xx Server Error)
-		shouldRetry := false
-		if apiErr, ok := err.(*openai.APIError); ok {
-			if apiErr.HTTPStatusCode == 429 || apiErr.HTTPStatusCode >= 500 {
-				shouldRetry = true
-			}
-		} else if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "500") || strings.Contains(err.Error(), "502") || strings.Contains(err.Error(), "503") || strings.Contains(err.Error(), "504") {
-			shouldRetry = true
-		}
-
-		if shouldRetry {
-			wait := time.Duration(2*(1<<uint(attempt))) * time.Second
-			if wait > 20*time.Second {
-				wait = 20 * time.Second
-			}
-			log.Printf("OpenAI API error %v (attempt %d/5), retrying in %v...", err, attempt+1, wait)
-
-			timer := time.NewTimer(wait)
-			select {
-			case <-timer.C:
-				continue
-			case <-ctx.Done():
-				timer.Stop()
-				return nil, ctx.Err()
+		if status := openAIStatus(err); status == 429 || status >= 500 {
+			if err = backoff(ctx, "OpenAI", openAIHost, p.apiKey, attempt, status, err); err != nil {
+				return nil, fmt.Errorf("openai api failed after retries: %w", err)
 			}
+			continue
 		}
 
 		return nil, fmt.Errorf("openai error: %w", err)
@@ -294,21 +363,32 @@ func (p *OpenAIProvider) AnswerQuestion(ctx context.Context, question string, re
 		return nil, fmt.Errorf("openai empty response")
 	}
 
-	return parseAnswer(resp.Choices[0].Message.Content, question)
+	answer, repair := answerReply(ctx, p, resp.Choices[0].Message.Content, question)
+	nativeThinking(answer, resp.Choices[0].Message.ReasoningContent)
+	answer.Usage = addUsage(&Usage{InputTokens: resp.Usage.PromptTokens, OutputTokens: resp.Usage.CompletionTokens}, repair)
+	return answer, nil
 }
 
 // ==========================================
 // HuggingFace Provider (v1/chat/completions)
 // ==========================================
 type HuggingFaceProvider struct {
-	apiKey string
-	model  string
+	apiKey    string
+	model     string
+	reasoning Reasoning // see WithReasoning
 }
 
 func (p *HuggingFaceProvider) AnswerQuestion(ctx context.Context, question string, results []retriever.Result, summaries []indexer.DocumentSummary, history []ChatMessage, customSystemPrompt ...string) (*Answer, error) {
+	release, err := acquireSlot(ctx, "huggingface", p.model)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	sysPrompt := buildSystemPrompt(customSystemPrompt...)
+	results, summaries = fitContext(p.model, huggingFaceMaxTokens, sysPrompt+question+historyText(history), results, summaries)
 	contextStr := FormatContext(results, summaries)
 	userPrompt := fmt.Sprintf("Question: %s\n\nContext:\n%s", question, contextStr)
-	sysPrompt := buildSystemPrompt(customSystemPrompt...)
 
 	// Build messages with history
 	messages := []map[string]string{
@@ -319,21 +399,22 @@ func (p *HuggingFaceProvider) AnswerQuestion(ctx context.Context, question strin
 	}
 	messages = append(messages, map[string]string{"role": "user", "content": userPrompt})
 
-	reqBody, _ := json.Marshal(map[string]interface{}{
+	reqMap := map[string]interface{}{
 		"model":       p.model,
 		"messages":    messages,
-		"max_tokens":  2048,
+		"max_tokens":  maxOutput(p.model, huggingFaceMaxTokens),
 		"temperature": 0.1,
 		"stream":      false,
-	})
+	}
+	huggingFaceReasoning(reqMap, p.reasoning)
+	reqBody, _ := json.Marshal(reqMap)
 
 	url := "https://router.huggingface.co/v1/chat/completions"
 	var resp *http.Response
-	var err error
-	client := &http.Client{}
+	client := httpclient.Default
 
 	// Retry logic for rate limits (429) and server errors (5xx)
-	for attempt := 0; attempt < 5; attempt++ {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
 		req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 		req.Header.Set("Authorization", "Bearer "+p.apiKey)
 		req.Header.Set("Content-Type", "application/json")
@@ -350,24 +431,15 @@ func (p *HuggingFaceProvider) AnswerQuestion(ctx context.Context, question strin
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 
+		err = fmt.Errorf("huggingface api error: %d - %s", resp.StatusCode, string(bodyBytes))
 		if resp.StatusCode == 429 || resp.StatusCode >= 500 {
-			wait := time.Duration(2*(1<<uint(attempt))) * time.Second
-			if wait > 20*time.Second {
-				wait = 20 * time.Second
-			}
-			log.Printf("HuggingFace API error %d (attempt %d/5), retrying in %v...", resp.StatusCode, attempt+1, wait)
-
-			timer := time.NewTimer(wait)
-			select {
-			case <-timer.C:
-				continue
-			case <-ctx.Done():
-				timer.Stop()
-				return nil, ctx.Err()
+			if err = backoff(ctx, "HuggingFace", huggingFaceHost, p.apiKey, attempt, resp.StatusCode, err); err != nil {
+				return nil, err
 			}
+			continue
 		}
 
-		return nil, fmt.Errorf("huggingface api error: %d - %s", resp.StatusCode, string(bodyBytes))
+		return nil, err
 	}
 
 	if resp == nil || resp.StatusCode != 200 {
@@ -378,9 +450,14 @@ func (p *HuggingFaceProvider) AnswerQuestion(ctx context.Context, question strin
 	var chatResp struct {
 		Choices []struct {
 			Message struct {
-				Content string `json:"content"`
+				Content          string `json:"content"`
+				ReasoningContent string `json:"reasoning_content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
 		return nil, fmt.Errorf("huggingface json error: %w", err)
@@ -390,21 +467,39 @@ func (p *HuggingFaceProvider) AnswerQuestion(ctx context.Context, question strin
 		return nil, fmt.Errorf("huggingface empty response")
 	}
 
-	return parseAnswer(chatResp.Choices[0].Message.Content, question)
+	msg := chatResp.Choices[0].Message
+	thinking, content := splitThink(msg.Content)
+	answer, repair := answerReply(ctx, p, content, question)
+	nativeThinking(answer, msg.ReasoningContent+thinking)
+	if chatResp.Usage != nil {
+		answer.Usage = &Usage{InputTokens: chatResp.Usage.PromptTokens, OutputTokens: chatResp.Usage.CompletionTokens}
+	} else {
+		answer.Usage = EstimateUsage(sysPrompt+userPrompt, chatResp.Choices[0].Message.Content)
+	}
+	answer.Usage = addUsage(answer.Usage, repair)
+	return answer, nil
 }
 
 // ==========================================
 // Anthropic Provider
 // ==========================================
 type AnthropicProvider struct {
-	apiKey string
-	model  string
+	apiKey    string
+	model     string
+	reasoning Reasoning // see WithReasoning
 }
 
 func (p *AnthropicProvider) AnswerQuestion(ctx context.Context, question string, results []retriever.Result, summaries []indexer.DocumentSummary, history []ChatMessage, customSystemPrompt ...string) (*Answer, error) {
+	release, err := acquireSlot(ctx, "anthropic", p.model)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	sysPrompt := buildSystemPrompt(customSystemPrompt...)
+	results, summaries = fitContext(p.model, anthropicMaxTokens(p.model, p.reasoning), sysPrompt+question+historyText(history), results, summaries)
 	contextStr := FormatContext(results, summaries)
 	userPrompt := fmt.Sprintf("Question: %s\n\nContext:\n%s", question, contextStr)
-	sysPrompt := buildSystemPrompt(customSystemPrompt...)
 
 	// Build messages with history
 	anthMessages := []map[string]string{}
@@ -416,34 +511,20 @@ func (p *AnthropicProvider) AnswerQuestion(ctx context.Context, question string,
 	// Build request body conditionally based on model capabilities
 	reqMap := map[string]interface{}{
 		"model":      p.model,
-		"max_tokens": 4096,
+		"max_tokens": anthropicMaxTokens(p.model, p.reasoning),
 		"system":     sysPrompt,
 		"messages":   anthMessages,
 	}
 
-	if isAdaptiveThinkingModel(p.model) {
-		// Opus 4.6 / Sonnet 4.6: use adaptive thinking, no temperature allowed
-		reqMap["thinking"] = map[string]interface{}{"type": "adaptive"}
-		reqMap["max_tokens"] = 16000 // thinking budget comes from max_tokens
-		log.Printf("Anthropic: using adaptive thinking for model %s", p.model)
-	} else if isExtendedThinkingModel(p.model) {
-		// Older thinking models: use enabled + budget_tokens, no temperature
-		reqMap["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": 10000}
-		reqMap["max_tokens"] = 16000
-		log.Printf("Anthropic: using extended thinking for model %s", p.model)
-	} else {
-		// Non-thinking models (e.g. Claude 3 Opus, Claude 3.5 Sonnet)
-		reqMap["temperature"] = 0.1
-	}
+	anthropicThinking(reqMap, p.model, p.reasoning)
 
 	reqBody, _ := json.Marshal(reqMap)
 
 	var resp *http.Response
-	var err error
-	client := &http.Client{}
+	client := httpclient.Default
 
 	// Retry logic for rate limits (429) and overloaded (529) errors
-	for attempt := 0; attempt < 5; attempt++ {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
 		req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(reqBody))
 		req.Header.Set("x-api-key", p.apiKey)
 		req.Header.Set("anthropic-version", "2023-06-01")
@@ -461,24 +542,15 @@ func (p *AnthropicProvider) AnswerQuestion(ctx context.Context, question string,
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 
+		err = fmt.Errorf("anthropic api error: %d - %s", resp.StatusCode, string(bodyBytes))
 		if resp.StatusCode == 429 || resp.StatusCode == 529 {
-			wait := time.Duration(2*(1<<uint(attempt))) * time.Second
-			if wait > 20*time.Second {
-				wait = 20 * time.Second
-			}
-			log.Printf("Anthropic API error %d (attempt %d/5), retrying in %v...", resp.StatusCode, attempt+1, wait)
-
-			timer := time.NewTimer(wait)
-			select {
-			case <-timer.C:
-				continue
-			case <-ctx.Done():
-				timer.Stop()
-				return nil, ctx.Err()
+			if err = backoff(ctx, "Anthropic", anthropicHost, p.apiKey, attempt, resp.StatusCode, err); err != nil {
+				return nil, err
 			}
+			continue
 		}
 
-		return nil, fmt.Errorf("anthropic api error: %d - %s", resp.StatusCode, string(bodyBytes))
+		return nil, err
 	}
 
 	if resp == nil || resp.StatusCode != 200 {
@@ -494,8 +566,9 @@ func (p *AnthropicProvider) AnswerQuestion(ctx context.Context, question string,
 
 	var anthResp struct {
 		Content []struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			Thinking string `json:"thinking"`
 		} `json:"content"`
 		StopReason string `json:"stop_reason"`
 		Usage      struct {
@@ -537,7 +610,7 @@ func (p *AnthropicProvider) AnswerQuestion(ctx context.Context, question string,
 		case "", "text":
 			fullText += block.Text
 		case "thinking":
-			thinkingText += block.Text
+			thinkingText += block.Thinking
 		}
 	}
 
@@ -561,7 +634,10 @@ func (p *AnthropicProvider) AnswerQuestion(ctx context.Context, question string,
 		return nil, fmt.Errorf("anthropic: no text content in response (stop_reason: %s, blocks: %d)", anthResp.StopReason, len(anthResp.Content))
 	}
 
-	return parseAnswer(fullText, question)
+	answer, repair := answerReply(ctx, p, fullText, question)
+	nativeThinking(answer, thinkingText)
+	answer.Usage = addUsage(&Usage{InputTokens: anthResp.Usage.InputTokens, OutputTokens: anthResp.Usage.OutputTokens}, repair)
+	return answer, nil
 }
 
 // maxHistoryPairs is the number of recent Q&A exchanges to include.
@@ -595,84 +671,36 @@ func buildHistoryMessages(history []ChatMessage) []openai.ChatCompletionMessage
 	return msgs
 }
 
-// Helper to parse JSON from LLM text
+// parseAnswer turns a model's reply into an Answer. It never fails: a reply
+// without a usable JSON object becomes a truncated or raw-text answer (see
+// fallbackAnswer).
 func parseAnswer(rawText string, question string) (*Answer, error) {
-	rawText = strings.TrimPrefix(rawText, "```json\n")
-	rawText = strings.TrimPrefix(rawText, "```\n")
-	rawText = strings.Split(rawText, "```")[0]
-	rawText = strings.TrimSpace(rawText)
-
-	// If the text doesn't start with {, try to find JSON in it
-	if !strings.HasPrefix(rawText, "{") {
-		if idx := strings.Index(rawText, "{"); idx >= 0 {
-			rawText = rawText[idx:]
-		}
+	answer, _, err := decodeAnswer(rawText, question)
+	if err != nil {
+		log.Printf("parseAnswer JSON error: %v (first 200 chars: %.200s)", err, rawText)
+		answer, _ = fallbackAnswer(rawText, question)
 	}
+	return answer, nil
+}
 
-	// Use a loose struct that accepts any types for fields the LLM may vary
+// decodeAnswer parses the answer JSON object in a model's reply. extracted
+// reports that the object had to be found among other text.
+func decodeAnswer(rawText string, question string) (answer *Answer, extracted bool, err error) {
+	obj, extracted := answerJSON(rawText)
+
+	// Use a loose struct that accepts any types for fields the LLM may vary.
+	// Documents and pages are derived from the footnotes, so any the model
+	// sends alongside them are ignored.
 	var parsed struct {
 		Thinking         string          `json:"thinking"`
 		Answer           string          `json:"answer"`
-		Documents        []string        `json:"documents"`
-		Pages            json.RawMessage `json:"pages"`
 		Footnotes        json.RawMessage `json:"footnotes"`
 		Confidence       float64         `json:"confidence"`
 		ConfidenceReason string          `json:"confidence_reason"`
+		Data             json.RawMessage `json:"data"`
 	}
-	if err := json.Unmarshal([]byte(rawText), &parsed); err != nil {
-		log.Printf("parseAnswer JSON error: %v (first 200 chars: %.200s)", err, rawText)
-
-		// JSON parse failed — try to extract partial "answer" field with regex
-		// This handles truncated JSON from max_tokens cutoff
-		answerRe := regexp.MustCompile(`"answer"\s*:\s*"((?:[^"\\]|\\.)*)`)
-		if m := answerRe.FindStringSubmatch(rawText); len(m) >= 2 {
-			partialAnswer := strings.ReplaceAll(m[1], `\"`, `"`)
-			partialAnswer = strings.ReplaceAll(partialAnswer, `\\n`, "\n")
-			if len(partialAnswer) > 20 {
-				log.Printf("parseAnswer: recovered partial answer from truncated JSON (%d chars)", len(partialAnswer))
-				// Also try to extract thinking
-				thinkingRe := regexp.MustCompile(`"thinking"\s*:\s*"((?:[^"\\]|\\.)*)`)
-				var thinking string
-				if tm := thinkingRe.FindStringSubmatch(rawText); len(tm) >= 2 {
-					thinking = strings.ReplaceAll(tm[1], `\"`, `"`)
-					thinking = strings.ReplaceAll(thinking, `\\n`, "\n")
-				}
-				return &Answer{
-					Question:         question,
-					Thinking:         thinking,
-					Answer:           partialAnswer + " [response truncated]",
-					Confidence:       0.5,
-					ConfidenceReason: "Response was truncated — answer may be incomplete",
-				}, nil
-			}
-		}
-
-		// Last resort — return the raw text as the answer
-		return &Answer{
-			Question:   question,
-			Answer:     rawText,
-			Confidence: 0.5,
-		}, nil
-	}
-
-	// Parse pages flexibly (could be []int, []string, or mixed)
-	var pages []int
-	if len(parsed.Pages) > 0 {
-		// Try []int first
-		if err := json.Unmarshal(parsed.Pages, &pages); err != nil {
-			// Fall back: try []interface{} and coerce
-			var rawPages []interface{}
-			if err2 := json.Unmarshal(parsed.Pages, &rawPages); err2 == nil {
-				for _, p := range rawPages {
-					switch v := p.(type) {
-					case float64:
-						pages = append(pages, int(v))
-					default:
-						pages = append(pages, 0)
-					}
-				}
-			}
-		}
+	if err := json.Unmarshal([]byte(obj), &parsed); err != nil {
+		return nil, extracted, err
 	}
 
 	// Parse footnotes flexibly (page field could be int or string)
@@ -702,30 +730,35 @@ func parseAnswer(rawText string, question string) (*Answer, error) {
 		}
 	}
 
+	footnotes = validFootnotes(footnotes)
+
 	// If the model returned valid JSON but the "answer" field is empty,
 	// fall back to showing the full raw text so the user never sees a blank bubble
 	answerText := parsed.Answer
 	if strings.TrimSpace(answerText) == "" {
-		answerText = rawText
+		answerText = obj
 	}
 
+	documents, pages := citedSources(footnotes)
 	return &Answer{
 		Question:         question,
 		Thinking:         parsed.Thinking,
 		Answer:           answerText,
-		Documents:        parsed.Documents,
+		Documents:        documents,
 		Pages:            pages,
 		Footnotes:        footnotes,
 		Confidence:       parsed.Confidence,
 		ConfidenceReason: parsed.ConfidenceReason,
-	}, nil
+		Data:             parsed.Data,
+	}, extracted, nil
 }
 
-// GenerateDocSummary uses a cheap LLM call to produce a structured document summary.
-// It reads the first maxPages of extracted text and returns a DocumentSummary.
-func GenerateDocSummary(ctx context.Context, apiKey string, docName string, pages []string, totalPages int) (*indexer.DocumentSummary, error) {
-	if apiKey == "" {
-		return nil, fmt.Errorf("OpenAI API key required for summary generation")
+// GenerateDocSummary uses a cheap LLM call, on the completer's provider, to
+// produce a structured document summary. It reads the first maxPages of
+// extracted text and returns a DocumentSummary.
+func GenerateDocSummary(ctx context.Context, c Completer, docName string, pages []string, totalPages int) (*indexer.DocumentSummary, error) {
+	if c.APIKey == "" {
+		return nil, fmt.Errorf("an API key is required for summary generation")
 	}
 
 	// Sample first 5 pages (or all if fewer)
@@ -765,29 +798,11 @@ Return ONLY valid JSON in this exact format:
 For sections, estimate page ranges based on the content and total page count (%d pages).
 If you cannot determine sections, return an empty array.`, docName, totalPages, maxPages, sampleText, totalPages)
 
-	client := openai.NewClient(apiKey)
-	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: "gpt-4o-mini",
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: prompt},
-		},
-		Temperature:    0.1,
-		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
-	})
+	rawJSON, _, err := c.CompleteJSON(ctx, prompt)
 	if err != nil {
-		return nil, fmt.Errorf("summary LLM call failed: %w", err)
+		return nil, err
 	}
 
-	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("empty response from summary LLM")
-	}
-
-	rawJSON := resp.Choices[0].Message.Content
-	rawJSON = strings.TrimPrefix(rawJSON, "```json\n")
-	rawJSON = strings.TrimPrefix(rawJSON, "```\n")
-	rawJSON = strings.Split(rawJSON, "```")[0]
-	rawJSON = strings.TrimSpace(rawJSON)
-
 	var summary struct {
 		Title    string `json:"title"`
 		DocType  string `json:"type"`
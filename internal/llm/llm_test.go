@@ -1,7 +1,20 @@
 package llm
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"gocognigo/internal/httpclient"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
 )
 
 // ========== parseAnswer ==========
@@ -211,6 +224,75 @@ func TestParseAnswer_MultipleDocumentsAndPages(t *testing.T) {
 	}
 }
 
+func TestParseAnswer_SourcesDerivedFromFootnotes(t *testing.T) {
+	raw := `{
+		"answer": "Rent is 10,000[1] and due monthly[2].",
+		"documents": ["other.pdf"],
+		"pages": [9, 9, 9],
+		"footnotes": [
+			{"id": 1, "document": " lease.pdf ", "page": 2},
+			{"id": 2, "document": "", "page": 4},
+			{"id": 3, "document": "schedule.pdf", "page": 1}
+		],
+		"confidence": 0.8
+	}`
+
+	got, err := parseAnswer(raw, "q")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.Footnotes) != 2 || got.Footnotes[0].Document != "lease.pdf" {
+		t.Errorf("footnotes = %+v, want the two with documents", got.Footnotes)
+	}
+	if fmt.Sprint(got.Documents, got.Pages) != "[lease.pdf schedule.pdf] [2 1]" {
+		t.Errorf("documents, pages = %v, %v, want them from the footnotes", got.Documents, got.Pages)
+	}
+}
+
+func TestParseAnswer_TrailingCommentary(t *testing.T) {
+	raw := "Sure! Here is an example of the shape: {\"id\": 1}\n\n" + `{
+		"answer": "Use {braces} and \"quotes\" freely.\n` + "```go\\nx := 1\\n```" + `",
+		"footnotes": [{"id": 1, "document": "doc.pdf", "page": 2}],
+		"confidence": 0.9
+	}` + "\n\nLet me know if you need anything else {or more}."
+
+	got, extracted, err := decodeAnswer(raw, "q")
+	if err != nil {
+		t.Fatalf("decodeAnswer: %v", err)
+	}
+	if !extracted {
+		t.Error("extracted = false for JSON among other text")
+	}
+	if !strings.HasPrefix(got.Answer, `Use {braces} and "quotes" freely.`) || !strings.Contains(got.Answer, "x := 1") {
+		t.Errorf("answer = %q", got.Answer)
+	}
+	if len(got.Footnotes) != 1 || got.Confidence != 0.9 {
+		t.Errorf("footnotes = %v, confidence = %v", got.Footnotes, got.Confidence)
+	}
+
+	if _, extracted, err := decodeAnswer("```json\n{\"answer\": \"ok\"}\n```", "q"); err != nil || extracted {
+		t.Errorf("fenced reply: extracted = %v, err = %v", extracted, err)
+	}
+	if _, _, err := decodeAnswer(`{"answer": "trailing comma",}`, "q"); err == nil {
+		t.Error("invalid JSON decoded without error")
+	}
+}
+
+func TestAnswerReply_CountsFallbacks(t *testing.T) {
+	before := ParseStats()["other"]
+	answer, repair := answerReply(context.Background(), nil, `{"answer": "The notice period is thirty days from the date`, "q")
+	if !strings.HasSuffix(answer.Answer, "[response truncated]") || repair != nil {
+		t.Errorf("answer = %q, repair = %v", answer.Answer, repair)
+	}
+	answerReply(context.Background(), nil, "no JSON here", "q")
+	answerReply(context.Background(), nil, `{"answer": "fine"}`, "q")
+
+	after := ParseStats()["other"]
+	if after.Truncated != before.Truncated+1 || after.Failed != before.Failed+1 || after.Clean != before.Clean+1 {
+		t.Errorf("counts went from %+v to %+v", before, after)
+	}
+}
+
 // ========== NewProvider ==========
 
 func TestNewProvider_UnknownProvider(t *testing.T) {
@@ -295,3 +377,584 @@ func TestIsExtendedThinkingModel(t *testing.T) {
 		}
 	}
 }
+
+// ========== Concurrency Limiter ==========
+
+func TestAcquireSlot_QueuesBeyondLimit(t *testing.T) {
+	t.Setenv("LLM_CONCURRENCY_LIMITTEST", "2")
+
+	r1, err := acquireSlot(context.Background(), "limittest", "gpt-4o")
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	r2, _ := acquireSlot(context.Background(), "limittest", "gpt-4o")
+
+	// Third call must wait; with a short deadline it gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := acquireSlot(ctx, "limittest", "gpt-4o"); err == nil {
+		t.Fatal("expected third acquire to block until the deadline")
+	}
+
+	if st := LimiterStats()["limittest"]; st.Limit != 2 || st.InFlight != 2 {
+		t.Errorf("stats = %+v, want limit 2, in_flight 2", st)
+	}
+
+	r1()
+	r1() // double release must be a no-op
+	r3, err := acquireSlot(context.Background(), "limittest", "gpt-4o")
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	r2()
+	r3()
+
+	if st := LimiterStats()["limittest"]; st.InFlight != 0 {
+		t.Errorf("in_flight after releases = %d, want 0", st.InFlight)
+	}
+}
+
+func TestCallWeight(t *testing.T) {
+	if callWeight("gpt-4o") != 1 {
+		t.Error("gpt-4o should weigh 1")
+	}
+	if callWeight("claude-opus-4-6") != 2 {
+		t.Error("adaptive thinking model should weigh 2")
+	}
+	if callWeight("o3-mini") != 2 {
+		t.Error("reasoning model should weigh 2")
+	}
+}
+
+// ========== EstimateCost ==========
+
+func TestEstimateCost(t *testing.T) {
+	usage := &Usage{InputTokens: 1_000_000, OutputTokens: 100_000}
+
+	// Longest prefix wins: gpt-4o-mini must not be priced as gpt-4o
+	cost, ok := EstimateCost("gpt-4o-mini", usage)
+	if !ok || math.Abs(cost-0.21) > 1e-9 {
+		t.Errorf("gpt-4o-mini cost = %v, %v; want 0.21", cost, ok)
+	}
+	cost, ok = EstimateCost("claude-sonnet-4-20250514", usage)
+	if !ok || math.Abs(cost-4.5) > 1e-9 {
+		t.Errorf("claude-sonnet-4 snapshot cost = %v, %v; want 4.5", cost, ok)
+	}
+	if _, ok := EstimateCost("Qwen/Qwen3-8B", usage); ok {
+		t.Error("unknown model should report ok=false")
+	}
+}
+
+// ========== summaryWindows ==========
+
+func TestSummaryWindows(t *testing.T) {
+	words := func(n int) string { return strings.Repeat("w ", n) }
+	pages := []SummaryPage{
+		{1, words(40)}, {2, words(40)}, {3, words(40)}, {4, words(150)}, {5, words(10)},
+	}
+	windows := summaryWindows(pages, 100)
+
+	// 1+2 fit; 3 alone since 4 would overflow; 4 is oversized and alone; 5 alone
+	var got [][]int
+	for _, w := range windows {
+		var nums []int
+		for _, p := range w {
+			nums = append(nums, p.Number)
+		}
+		got = append(got, nums)
+	}
+	want := [][]int{{1, 2}, {3}, {4}, {5}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("windows = %v, want %v", got, want)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"type": "array",
+		"items": {
+			"type": "object",
+			"required": ["party", "amount"],
+			"properties": {
+				"party": {"type": "string"},
+				"amount": {"type": "number"},
+				"page": {"type": "integer"},
+				"kind": {"enum": ["fee", "penalty"]}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("ParseSchema: %v", err)
+	}
+
+	var ok, bad interface{}
+	json.Unmarshal([]byte(`[{"party": "Acme", "amount": 12.5, "page": 3, "kind": "fee"}]`), &ok)
+	json.Unmarshal([]byte(`[{"party": "Acme", "amount": "12.5", "page": 3.5, "kind": "tax"}, {"amount": 1}]`), &bad)
+	if errs := schema.Validate(ok); len(errs) != 0 {
+		t.Errorf("valid data rejected: %v", errs)
+	}
+	errs := schema.Validate(bad)
+	if len(errs) != 4 {
+		t.Errorf("expected 4 violations (amount type, page integer, kind enum, missing party), got %v", errs)
+	}
+
+	if _, err := ParseSchema([]byte(`{"type": "object", "required": ["x"]}`)); err == nil {
+		t.Error("expected an undefined required property to be rejected")
+	}
+}
+
+// schemaProvider returns a non-conforming answer first, then a valid one.
+type schemaProvider struct{ calls int }
+
+func (p *schemaProvider) AnswerQuestion(_ context.Context, question string, _ []retriever.Result, _ []indexer.DocumentSummary, _ []ChatMessage, sys ...string) (*Answer, error) {
+	p.calls++
+	data := `{"amount": "ten"}`
+	if p.calls > 1 {
+		if !strings.Contains(sys[0], "did not conform") {
+			return nil, fmt.Errorf("retry prompt is missing the violations")
+		}
+		data = `{"amount": 10}`
+	}
+	return &Answer{Question: question, Answer: "ten", Data: json.RawMessage(data), Usage: &Usage{InputTokens: 100, OutputTokens: 10}}, nil
+}
+
+func TestAnswerStructuredRetries(t *testing.T) {
+	schema, _ := ParseSchema([]byte(`{"type": "object", "properties": {"amount": {"type": "number"}}}`))
+	p := &schemaProvider{}
+	answer, err := AnswerStructured(context.Background(), p, schema, "how much?", nil, nil, nil, "")
+	if err != nil {
+		t.Fatalf("AnswerStructured: %v", err)
+	}
+	if p.calls != 2 || len(answer.SchemaErrors) != 0 || string(answer.Data) != `{"amount": 10}` {
+		t.Errorf("calls=%d errors=%v data=%s", p.calls, answer.SchemaErrors, answer.Data)
+	}
+	if answer.Usage.InputTokens != 200 {
+		t.Errorf("usage should cover both attempts, got %+v", answer.Usage)
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	cases := map[string]float64{
+		"1 + 2 * 3":            7,
+		"(1 + 2) * 3":          9,
+		"-2 ^ 2":               4,
+		"2 ^ 3 ^ 2":            512,
+		"1,250,000 * 12.5%":    156250,
+		"(4586 - 3912) / 3912": (4586.0 - 3912) / 3912,
+	}
+	for expr, want := range cases {
+		if got, err := Calculate(expr); err != nil || got != want {
+			t.Errorf("Calculate(%q) = %v, %v; want %v", expr, got, err, want)
+		}
+	}
+	for _, expr := range []string{"", "1 +", "(1", "1 / 0", "2 ^ 0.5", "x"} {
+		if _, err := Calculate(expr); err == nil {
+			t.Errorf("Calculate(%q) should fail", expr)
+		}
+	}
+}
+
+// toolProvider calls the calculator until told to stop, then answers with
+// the last tool result it saw.
+type toolProvider struct {
+	schemaProvider
+	turns []int // messages seen per turn
+	final bool
+}
+
+func (p *toolProvider) ToolTurn(_ context.Context, _ string, messages []ToolMessage, _ []Tool, final bool) (*ToolReply, error) {
+	p.turns = append(p.turns, len(messages))
+	usage := &Usage{InputTokens: 10, OutputTokens: 1}
+	if final {
+		p.final = true
+		last := messages[len(messages)-2].Content
+		return &ToolReply{Content: `{"answer": "` + last + `", "confidence": 0.9}`, Usage: usage}, nil
+	}
+	return &ToolReply{
+		ToolCalls: []ToolCall{{ID: fmt.Sprint(len(p.turns)), Name: "calculator", Arguments: json.RawMessage(`{"expression": "6 * 7"}`)}},
+		Usage:     usage,
+	}, nil
+}
+
+func TestAnswerWithToolsCapsSteps(t *testing.T) {
+	p := &toolProvider{}
+	answer, err := AnswerWithTools(context.Background(), p, []Tool{CalculatorTool()}, 2, "what is 6 x 7?", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("AnswerWithTools: %v", err)
+	}
+	if !p.final || len(p.turns) != 3 {
+		t.Fatalf("want 2 tool turns and a final one, got turns=%v final=%v", p.turns, p.final)
+	}
+	if answer.Answer != "42" || len(answer.ToolSteps) != 2 || answer.ToolSteps[0].Result != "42" {
+		t.Errorf("answer=%q steps=%+v", answer.Answer, answer.ToolSteps)
+	}
+	if answer.Usage.InputTokens != 30 {
+		t.Errorf("usage should cover every turn, got %+v", answer.Usage)
+	}
+}
+
+func TestBuildSystemPrompt_BaseOverride(t *testing.T) {
+	def := buildSystemPrompt("Cite as per house style.")
+	if !strings.HasPrefix(def, "Cite as per house style.") || !strings.Contains(def, defaultBasePrompt) {
+		t.Errorf("custom prompt should be prepended to the default instructions")
+	}
+
+	got := buildSystemPrompt("", "You answer for a UK law firm. Use British spelling.")
+	if strings.Contains(got, "precise document analysis assistant") {
+		t.Errorf("base override should replace the default instructions")
+	}
+	if !strings.HasPrefix(got, "You answer for a UK law firm.") || !strings.Contains(got, responseFormatPrompt) {
+		t.Errorf("override should keep the response format, got %q", got)
+	}
+}
+
+func TestLanguageName(t *testing.T) {
+	for in, want := range map[string]string{"hi": "Hindi", "ta-IN": "Tamil", "Marathi": "Marathi", " EN ": "English"} {
+		if got := LanguageName(in); got != want {
+			t.Errorf("LanguageName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWithTranslations(t *testing.T) {
+	results := []retriever.Result{{Text: "कंपनी", ParentText: "पृष्ठ"}, {Text: "already English"}}
+	out := WithTranslations(results, []string{"the company", ""}, "en")
+	if !strings.Contains(out[0].ParentText, "[English translation of the matched passage]\nthe company") {
+		t.Errorf("translation missing: %q", out[0].ParentText)
+	}
+	if out[1].Text != "already English" || results[0].ParentText != "पृष्ठ" {
+		t.Errorf("untranslated results and the input should be unchanged")
+	}
+}
+
+func TestDocTypeGuidance(t *testing.T) {
+	summaries := []indexer.DocumentSummary{
+		{Document: "q3-call.pdf", DocType: "transcript"},
+		{Document: "annual.pdf", DocType: "financial_report"},
+		{Document: "misc.pdf", DocType: "other"},
+	}
+	results := []retriever.Result{
+		{Document: "annual.pdf"}, {Document: "q3-call.pdf"}, {Document: "annual.pdf"}, {Document: "misc.pdf"},
+	}
+
+	got := DocTypeGuidance(results, summaries, nil)
+	fin, tr := strings.Index(got, DocTypePrompts["financial_report"]), strings.Index(got, DocTypePrompts["transcript"])
+	if fin != 0 || tr <= fin {
+		t.Errorf("want the financial report prompt first, then the transcript's; got %q", got)
+	}
+
+	got = DocTypeGuidance(results, summaries, map[string]string{"financial_report": "", "other": "Be brief."})
+	if strings.Contains(got, DocTypePrompts["financial_report"]) || !strings.Contains(got, "Be brief.") {
+		t.Errorf("overrides not applied: %q", got)
+	}
+	if DocTypeGuidance(nil, summaries, nil) != "" {
+		t.Error("no results should give no guidance")
+	}
+}
+
+func TestCompleter_Defaults(t *testing.T) {
+	if got := stripCodeFence("```json\n{\"a\": 1}\n```\n"); got != `{"a": 1}` {
+		t.Errorf("stripCodeFence = %q", got)
+	}
+	if m := (Completer{Provider: "anthropic"}).model(); m != DefaultSummaryModel("anthropic") {
+		t.Errorf("anthropic default model = %q", m)
+	}
+	if m := (Completer{Provider: "openai", Model: "gpt-4.1-mini"}).model(); m != "gpt-4.1-mini" {
+		t.Errorf("model override ignored: %q", m)
+	}
+	if _, err := GenerateDocSummary(context.Background(), Completer{Provider: "anthropic"}, "a.pdf", []string{"text"}, 1); err == nil {
+		t.Error("want an error without an API key")
+	}
+}
+
+func TestParseRoutedDocuments(t *testing.T) {
+	known := map[string]bool{"a.pdf": true, "b.pdf": true, "c.pdf": true}
+	docs, err := parseRoutedDocuments(`{"documents": ["b.pdf", "made-up.pdf", "b.pdf", "a.pdf", "c.pdf"]}`, known, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || docs[0] != "b.pdf" || docs[1] != "a.pdf" {
+		t.Errorf("docs = %v, want [b.pdf a.pdf]", docs)
+	}
+	if _, err := parseRoutedDocuments("not json", known, 2); err == nil {
+		t.Error("want an error for a non-JSON reply")
+	}
+}
+
+func TestClassifyQuery(t *testing.T) {
+	cases := map[string]string{
+		"What is the termination notice period?":                 QueryLookup,
+		"How many contracts contain an arbitration clause?":      QueryEnumeration,
+		"List every party to the share purchase agreement":       QueryEnumeration,
+		"Compare the indemnity caps in the 2022 and 2023 leases": QueryComparison,
+		"How does FY23 revenue differ from FY22?":                QueryComparison,
+		"Summarize the annual report":                            QuerySummarization,
+		"What are these documents about?":                        QuerySummarization,
+	}
+	for q, want := range cases {
+		if got := ClassifyQuery(q); got != want {
+			t.Errorf("ClassifyQuery(%q) = %s, want %s", q, got, want)
+		}
+	}
+}
+
+func TestQueryStrategy_Summaries(t *testing.T) {
+	summaries := []indexer.DocumentSummary{{Document: "a.pdf"}, {Document: "b.pdf"}}
+	results := []retriever.Result{{Document: "b.pdf"}}
+
+	if got := StrategyFor(QueryLookup).Summaries(results, summaries); len(got) != 1 || got[0].Document != "b.pdf" {
+		t.Errorf("lookup summaries = %+v, want only b.pdf", got)
+	}
+	if got := StrategyFor(QueryEnumeration).Summaries(results, summaries); len(got) != 2 {
+		t.Errorf("enumeration summaries = %+v, want all", got)
+	}
+	if StrategyFor(QueryEnumeration).TopK <= StrategyFor(QueryLookup).TopK {
+		t.Error("enumeration should retrieve more chunks than a lookup")
+	}
+	if StrategyFor("bogus").TopK != StrategyFor(QueryLookup).TopK {
+		t.Error("unknown types should fall back to lookup")
+	}
+}
+
+func TestParseVerification(t *testing.T) {
+	var v Verification
+	if err := parseVerification(`{"status": "Agrees", "discrepancies": [{"claim": "Revenue was ₹500 Cr", "issue": "page 4 says ₹450 Cr", "source": 1}, {"claim": "", "issue": ""}]}`, &v); err != nil {
+		t.Fatalf("parseVerification: %v", err)
+	}
+	if v.Status != VerifyPartial {
+		t.Errorf("status = %q, want %q when discrepancies are listed", v.Status, VerifyPartial)
+	}
+	if len(v.Discrepancies) != 1 || v.Discrepancies[0].Source != 1 {
+		t.Errorf("discrepancies = %+v", v.Discrepancies)
+	}
+
+	if err := parseVerification(`{"status": "maybe"}`, &Verification{}); err == nil {
+		t.Error("unknown status should be an error")
+	}
+}
+
+// ========== backoff ==========
+
+func TestBackoff_GivesUpOnLongRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	resp, err := httpclient.Default.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	host := resp.Request.URL.Host
+
+	cause := errors.New("api error: 429")
+	start := time.Now()
+	err = backoff(context.Background(), "Test", host, "", 0, http.StatusTooManyRequests, cause)
+	if time.Since(start) > time.Second {
+		t.Error("waited out an hour-long rate limit")
+	}
+	var rl *RateLimitError
+	if !errors.As(err, &rl) {
+		t.Fatalf("err = %v, want a RateLimitError", err)
+	}
+	if rl.RetryAfter < 59*time.Minute || !errors.Is(err, cause) {
+		t.Errorf("RetryAfter = %v, err = %v", rl.RetryAfter, err)
+	}
+	if tok := errorToken(err); tok.RetryAfter < 3500 {
+		t.Errorf("stream token retry_after = %v", tok.RetryAfter)
+	}
+}
+
+func TestBackoff_LastAttempt(t *testing.T) {
+	cause := errors.New("api error")
+	var rl *RateLimitError
+	if err := backoff(context.Background(), "Test", "unthrottled.example", "", maxAttempts-1, http.StatusTooManyRequests, cause); !errors.As(err, &rl) || rl.RetryAfter != 0 {
+		t.Errorf("429: %v, want a RateLimitError without a wait", err)
+	}
+	if err := backoff(context.Background(), "Test", "unthrottled.example", "", maxAttempts-1, http.StatusBadGateway, cause); err != cause {
+		t.Errorf("502: %v, want the cause", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := backoff(ctx, "Test", "unthrottled.example", "", 0, http.StatusBadGateway, cause); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled: %v", err)
+	}
+}
+
+func TestRepairCitations(t *testing.T) {
+	results := []retriever.Result{
+		{Document: "Lease.pdf", PageNumber: 3},
+		{Document: "Lease.pdf", PageNumber: 7},
+		{Document: "Policy.docx", PageNumber: 10, PageStart: 10, PageEnd: 12},
+	}
+	answer := &Answer{
+		Answer: "Rent is due monthly [2]. Notice is 30 days [4][1]. Refunds follow clause 9 [3, 5] (updated 2023 [2023]).",
+		Footnotes: []Footnote{
+			{ID: 1, Document: "Lease.pdf", Page: 3},
+			{ID: 2, Document: "lease", Page: 8},        // case, extension and page repaired
+			{ID: 3, Document: "Policy.docx", Page: 11}, // within the section's span
+			{ID: 4, Document: "Lease.pdf", Page: 3},    // same page as [1]
+			{ID: 5, Document: "Invented.pdf", Page: 1}, // never retrieved
+			{ID: 5, Document: "Policy.docx", Page: 12}, // repeated ID
+		},
+	}
+	RepairCitations(answer, results, nil)
+
+	want := "Rent is due monthly [1]. Notice is 30 days [2]. Refunds follow clause 9 [3] (updated 2023 [2023])."
+	if answer.Answer != want {
+		t.Errorf("answer = %q\nwant %q", answer.Answer, want)
+	}
+	wantNotes := []Footnote{
+		{ID: 1, Document: "Lease.pdf", Page: 7},
+		{ID: 2, Document: "Lease.pdf", Page: 3},
+		{ID: 3, Document: "Policy.docx", Page: 11},
+	}
+	if fmt.Sprint(answer.Footnotes) != fmt.Sprint(wantNotes) {
+		t.Errorf("footnotes = %v, want %v", answer.Footnotes, wantNotes)
+	}
+	if fmt.Sprint(answer.Documents, answer.Pages) != "[Lease.pdf Lease.pdf Policy.docx] [7 3 11]" {
+		t.Errorf("documents, pages = %v, %v", answer.Documents, answer.Pages)
+	}
+	actions := map[string]int{}
+	for _, f := range answer.CitationFixes {
+		actions[f.Action]++
+	}
+	if actions["dropped"] != 2 || actions["repaired"] != 1 || actions["merged"] != 1 || actions["renumbered"] != 2 {
+		t.Errorf("fixes = %+v", answer.CitationFixes)
+	}
+}
+
+func TestRepairCitations_KeepsSummarizedDocuments(t *testing.T) {
+	answer := &Answer{
+		Answer:    "There are two contracts [1].",
+		Footnotes: []Footnote{{ID: 1, Document: "B.pdf", Page: 4}},
+	}
+	RepairCitations(answer, nil, []indexer.DocumentSummary{{Document: "B.pdf"}})
+	if len(answer.Footnotes) != 1 || answer.Answer != "There are two contracts [1]." || len(answer.CitationFixes) != 0 {
+		t.Errorf("answer = %q, footnotes = %v, fixes = %v", answer.Answer, answer.Footnotes, answer.CitationFixes)
+	}
+}
+
+// ========== reasoning ==========
+
+func TestSplitThink(t *testing.T) {
+	cases := []struct{ in, thinking, answer string }{
+		{"<think>\nWeigh clause 4.\n</think>\n{\"answer\": \"x\"}", "Weigh clause 4.", `{"answer": "x"}`},
+		{"Weigh clause 4.</think>{\"answer\": \"x\"}", "Weigh clause 4.", `{"answer": "x"}`},
+		{`{"answer": "no reasoning"}`, "", `{"answer": "no reasoning"}`},
+		{`{"answer": "quotes <think>tags</think> literally"}`, "", `{"answer": "quotes <think>tags</think> literally"}`},
+	}
+	for _, c := range cases {
+		thinking, answer := splitThink(c.in)
+		if thinking != c.thinking || answer != c.answer {
+			t.Errorf("splitThink(%q) = %q, %q; want %q, %q", c.in, thinking, answer, c.thinking, c.answer)
+		}
+	}
+}
+
+func TestThinkFilter_TagsAcrossDeltas(t *testing.T) {
+	var f thinkFilter
+	var thinking, text strings.Builder
+	for _, delta := range []string{"<thi", "nk>step one, ", "step two</th", "ink>{\"answer\"", ": \"ok\"}<"} {
+		th, tx := f.write(delta)
+		thinking.WriteString(th)
+		text.WriteString(tx)
+	}
+	th, tx := f.flush()
+	thinking.WriteString(th)
+	text.WriteString(tx)
+	if thinking.String() != "step one, step two" || text.String() != `{"answer": "ok"}<` {
+		t.Errorf("thinking = %q, text = %q", thinking.String(), text.String())
+	}
+}
+
+func TestAnthropicThinking(t *testing.T) {
+	req := map[string]interface{}{}
+	anthropicThinking(req, "claude-sonnet-4-5", ReasoningHigh)
+	if th, _ := req["thinking"].(map[string]interface{}); th["budget_tokens"] != 24000 || anthropicMaxTokens("claude-sonnet-4-5", ReasoningHigh) != 30000 {
+		t.Errorf("high effort: %v, max_tokens %d", req, anthropicMaxTokens("claude-sonnet-4-5", ReasoningHigh))
+	}
+
+	req = map[string]interface{}{}
+	anthropicThinking(req, "claude-sonnet-4-5", ReasoningOff)
+	if _, ok := req["thinking"]; ok || req["temperature"] != 0.1 {
+		t.Errorf("off: %v", req)
+	}
+
+	req = map[string]interface{}{}
+	anthropicThinking(req, "claude-opus-4-6", ReasoningOff)
+	if th, _ := req["thinking"].(map[string]interface{}); th["type"] != "disabled" {
+		t.Errorf("adaptive off: %v", req)
+	}
+	if _, ok := req["temperature"]; ok {
+		t.Error("adaptive model sent a temperature")
+	}
+}
+
+func TestWithReasoning(t *testing.T) {
+	if _, err := ParseReasoning("extreme"); err == nil {
+		t.Error("unknown setting accepted")
+	}
+	r, err := ParseReasoning(" High ")
+	if err != nil || r != ReasoningHigh {
+		t.Fatalf("ParseReasoning = %q, %v", r, err)
+	}
+
+	base := &OpenAIProvider{model: "o3-mini"}
+	p, ok := WithReasoning(base, ReasoningOff).(*OpenAIProvider)
+	if !ok || p == base || base.reasoning != ReasoningDefault {
+		t.Fatal("WithReasoning should return a configured copy")
+	}
+	if p.reasoning.openAIEffort() != "low" {
+		t.Errorf("off effort = %q, want low", p.reasoning.openAIEffort())
+	}
+}
+
+// ========== model limits ==========
+
+func TestLimitsFor(t *testing.T) {
+	if l := LimitsFor("claude-3-5-sonnet-20241022"); l.MaxOutput != 8192 {
+		t.Errorf("dated snapshot: %+v", l)
+	}
+	if l := LimitsFor("gpt-4o-mini-2024-07-18"); l.Context != 128000 {
+		t.Errorf("gpt-4o-mini: %+v", l)
+	}
+	if l := LimitsFor("some/unknown-model"); l != defaultLimits {
+		t.Errorf("unknown model: %+v", l)
+	}
+	if got := maxOutput("claude-3-opus-20240229", 16000); got != 4096 {
+		t.Errorf("maxOutput = %d, want the model's 4096", got)
+	}
+}
+
+func TestFitContext(t *testing.T) {
+	page := strings.Repeat("word ", 3000) // ~15k chars, ~5k tokens
+	var results []retriever.Result
+	for i := 1; i <= 5; i++ {
+		results = append(results, retriever.Result{Document: "doc.pdf", PageNumber: i, ParentText: page})
+	}
+	summaries := []indexer.DocumentSummary{{Document: "doc.pdf", Summary: "A lease."}}
+
+	// phi-4's 16k window holds two such pages next to a 4k reply
+	got, sums := fitContext("microsoft/phi-4", 4096, "system prompt", results, summaries)
+	if len(got) != 2 || got[0].PageNumber != 1 || got[1].PageNumber != 2 || len(sums) != 1 {
+		t.Errorf("kept %d excerpts, %d overviews", len(got), len(sums))
+	}
+
+	// A window that fits everything leaves the context alone
+	if got, _ := fitContext("gpt-4o", 4096, "", results, summaries); len(got) != 5 {
+		t.Errorf("gpt-4o kept %d of 5 excerpts", len(got))
+	}
+
+	// One excerpt too big for the window is cut, not dropped
+	huge := []retriever.Result{{Document: "doc.pdf", PageNumber: 1, ParentText: strings.Repeat("x", 100000)}}
+	got, _ = fitContext("microsoft/phi-4", 4096, "", huge, nil)
+	if len(got) != 1 || len(got[0].ParentText) >= 100000 || !strings.Contains(got[0].ParentText, "truncated") {
+		t.Errorf("kept %d excerpts of %d chars", len(got), len(got[0].ParentText))
+	}
+	if len(huge[0].ParentText) != 100000 {
+		t.Error("fitContext modified the caller's results")
+	}
+}
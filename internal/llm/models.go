@@ -0,0 +1,163 @@
+package llm
+
+import (
+	"log"
+	"strings"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ==========================================
+// Model Limits
+// ==========================================
+
+// ModelLimits is what a model accepts, in tokens: its context window
+// (prompt and reply together) and the longest reply it can produce.
+type ModelLimits struct {
+	Context   int `json:"context_window"`
+	MaxOutput int `json:"max_output"`
+}
+
+// modelLimits is keyed by model-name prefix like modelPrices. Router models
+// list the limits of the model itself; a HuggingFace provider may serve
+// less.
+var modelLimits = map[string]ModelLimits{
+	"gpt-3.5-turbo":                   {16385, 4096},
+	"gpt-4":                           {8192, 8192},
+	"gpt-4-turbo":                     {128000, 4096},
+	"gpt-4o":                          {128000, 16384},
+	"gpt-4o-mini":                     {128000, 16384},
+	"gpt-4.1":                         {1047576, 32768},
+	"o1":                              {200000, 100000},
+	"o3":                              {200000, 100000},
+	"o3-mini":                         {200000, 100000},
+	"o4-mini":                         {200000, 100000},
+	"claude-opus-4":                   {200000, 32000},
+	"claude-opus-4-5":                 {200000, 64000},
+	"claude-opus-4-6":                 {200000, 128000},
+	"claude-sonnet-4":                 {200000, 64000},
+	"claude-haiku-4-5":                {200000, 64000},
+	"claude-3-7-sonnet":               {200000, 64000},
+	"claude-3-5-sonnet":               {200000, 8192},
+	"claude-3-5-haiku":                {200000, 8192},
+	"claude-3-opus":                   {200000, 4096},
+	"claude-3-haiku":                  {200000, 4096},
+	"Qwen/Qwen2.5-7B-Instruct-1M":     {1010000, 8192},
+	"Qwen/Qwen2.5-72B-Instruct":       {32768, 8192},
+	"Qwen/Qwen2.5-Coder-32B-Instruct": {32768, 8192},
+	"Qwen/Qwen3":                      {32768, 8192},
+	"Qwen/QwQ-32B":                    {131072, 8192},
+	"meta-llama/Llama-3.3-70B":        {131072, 8192},
+	"microsoft/phi-4":                 {16384, 4096},
+}
+
+// defaultLimits is assumed for models missing from modelLimits.
+var defaultLimits = ModelLimits{Context: 32768, MaxOutput: 4096}
+
+// LimitsFor returns model's limits, or conservative defaults for a model
+// that isn't in the table.
+func LimitsFor(model string) ModelLimits {
+	if l, ok := longestPrefix(modelLimits, model); ok {
+		return l
+	}
+	return defaultLimits
+}
+
+// longestPrefix looks model up in a table keyed by model-name prefix: the
+// longest matching prefix wins, so dated snapshots share their family's row.
+func longestPrefix[T any](table map[string]T, model string) (T, bool) {
+	var best string
+	for prefix := range table {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	v, ok := table[best]
+	return v, ok && best != ""
+}
+
+// The output tokens each provider's answer requests ask for, before
+// maxOutput caps them.
+const (
+	openAIMaxTokens           = 4096 // o-series only; reasoning counts toward it
+	huggingFaceMaxTokens      = 2048
+	anthropicMaxTokensDefault = 4096 // see anthropicMaxTokens
+)
+
+// maxOutput caps a request's output tokens at what model can produce.
+func maxOutput(model string, want int) int {
+	return min(want, LimitsFor(model).MaxOutput)
+}
+
+// promptTokens estimates the tokens of prompt text. It counts a token per
+// three bytes rather than EstimateUsage's four, since it must not undercount:
+// Indic scripts take several bytes a character and tokenize densely.
+func promptTokens(s string) int {
+	return (len(s) + 2) / 3
+}
+
+// historyText is the text trimHistory keeps of history, for sizing a prompt.
+func historyText(history []ChatMessage) string {
+	var sb strings.Builder
+	for _, m := range trimHistory(history) {
+		sb.WriteString(m.Content)
+	}
+	return sb.String()
+}
+
+// minExcerptChars is the least of an excerpt's text fitContext keeps when
+// even one excerpt is over the budget.
+const minExcerptChars = 2000
+
+// fitContext trims results and summaries so that the context fits model's
+// window next to fixed (the rest of the prompt: system prompt, question,
+// history) and a reply of replyTokens. The lowest-ranked excerpts go first,
+// then document overviews from the end; if a single excerpt is still too
+// long its text is cut. Excerpts keep their [Source N] numbers, as only
+// the tail is dropped.
+func fitContext(model string, replyTokens int, fixed string, results []retriever.Result, summaries []indexer.DocumentSummary) ([]retriever.Result, []indexer.DocumentSummary) {
+	limits := LimitsFor(model)
+	// A margin for the framing FormatContext adds and estimation error
+	budget := limits.Context - replyTokens - promptTokens(fixed) - limits.Context/20
+	resultTokens := make([]int, len(results))
+	total := 0
+	for i, r := range results {
+		resultTokens[i] = promptTokens(FormatContext([]retriever.Result{r}, nil))
+		total += resultTokens[i]
+	}
+	summaryTokens := make([]int, len(summaries))
+	for i, s := range summaries {
+		summaryTokens[i] = promptTokens(FormatContext(nil, []indexer.DocumentSummary{s}))
+		total += summaryTokens[i]
+	}
+	if total <= budget {
+		return results, summaries
+	}
+
+	n, m := len(results), len(summaries)
+	for n > 1 && total > budget {
+		n--
+		total -= resultTokens[n]
+	}
+	for m > 0 && total > budget {
+		m--
+		total -= summaryTokens[m]
+	}
+	results, summaries = results[:n:n], summaries[:m:m]
+	if n == 1 && total > budget {
+		r := results[0]
+		text := r.ParentText
+		if text == "" {
+			text = r.Text
+		}
+		keep := max(len(text)-(total-budget)*3, minExcerptChars)
+		if keep < len(text) {
+			r.ParentText = strings.ToValidUTF8(text[:keep], "") + "\n[excerpt truncated to fit the model's context window]"
+			results = []retriever.Result{r}
+		}
+	}
+	log.Printf("%s: context trimmed to fit its %d-token window: %d of %d excerpts, %d of %d overviews",
+		model, limits.Context, n, len(resultTokens), m, len(summaryTokens))
+	return results, summaries
+}
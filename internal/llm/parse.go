@@ -0,0 +1,262 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ==========================================
+// Answer Parsing
+// ==========================================
+
+// Models asked for a JSON answer don't always give only that: some wrap it
+// in commentary or a code fence, some emit a stray trailing comma, and some
+// stop mid-object at their token limit. answerReply finds the object among
+// other text, gives a reply that still doesn't parse back to the model once
+// to be fixed, and only then falls back to a partial or raw-text answer.
+// Each outcome is counted per provider (see ParseStats).
+
+// jsonObjects returns the top-level {...} spans of text in order, with any
+// object still open when the text ends last.
+func jsonObjects(text string) []string {
+	var objs []string
+	depth, start := 0, -1
+	inString, escaped := false, false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			if depth > 0 {
+				inString = true
+			}
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+				if depth == 0 {
+					objs = append(objs, text[start:i+1])
+				}
+			}
+		}
+	}
+	if depth > 0 {
+		objs = append(objs, text[start:])
+	}
+	return objs
+}
+
+// answerJSON picks the answer object out of a reply: the first valid object
+// with an "answer" field, else the first valid object, else the first
+// candidate (for the error and repair). extracted is false when the reply
+// was nothing but the object, fences and whitespace aside.
+func answerJSON(text string) (obj string, extracted bool) {
+	objs := jsonObjects(text)
+	if len(objs) == 0 {
+		return strings.TrimSpace(text), false
+	}
+	obj = objs[0]
+	var firstValid string
+	for _, o := range objs {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal([]byte(o), &fields) != nil {
+			continue
+		}
+		if _, ok := fields["answer"]; ok {
+			firstValid = o
+			break
+		}
+		if firstValid == "" {
+			firstValid = o
+		}
+	}
+	if firstValid != "" {
+		obj = firstValid
+	}
+	return obj, unfenced(text) != obj
+}
+
+// unfenced is text without surrounding whitespace and code fence.
+func unfenced(text string) string {
+	text = strings.TrimSpace(text)
+	if rest, ok := strings.CutPrefix(text, "```"); ok {
+		rest = strings.TrimPrefix(rest, "json")
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "```"))
+	}
+	return text
+}
+
+var (
+	partialAnswerRe   = regexp.MustCompile(`"answer"\s*:\s*"((?:[^"\\]|\\.)*)`)
+	partialThinkingRe = regexp.MustCompile(`"thinking"\s*:\s*"((?:[^"\\]|\\.)*)`)
+)
+
+// fallbackAnswer makes what it can of a reply with no usable JSON: the
+// "answer" text of an object cut off at the token limit (truncated), or
+// failing that the reply itself.
+func fallbackAnswer(rawText string, question string) (answer *Answer, truncated bool) {
+	if m := partialAnswerRe.FindStringSubmatch(rawText); len(m) >= 2 {
+		partialAnswer := strings.ReplaceAll(m[1], `\"`, `"`)
+		partialAnswer = strings.ReplaceAll(partialAnswer, `\\n`, "\n")
+		if len(partialAnswer) > 20 {
+			log.Printf("parseAnswer: recovered partial answer from truncated JSON (%d chars)", len(partialAnswer))
+			var thinking string
+			if tm := partialThinkingRe.FindStringSubmatch(rawText); len(tm) >= 2 {
+				thinking = strings.ReplaceAll(tm[1], `\"`, `"`)
+				thinking = strings.ReplaceAll(thinking, `\\n`, "\n")
+			}
+			return &Answer{
+				Question:         question,
+				Thinking:         thinking,
+				Answer:           partialAnswer + " [response truncated]",
+				Confidence:       0.5,
+				ConfidenceReason: "Response was truncated — answer may be incomplete",
+			}, true
+		}
+	}
+
+	// Last resort — return the raw text as the answer
+	return &Answer{
+		Question:   question,
+		Answer:     strings.TrimSpace(rawText),
+		Confidence: 0.5,
+	}, false
+}
+
+const repairPrompt = `The response below was meant to be a single JSON object but is not valid JSON: %v.
+
+Return the corrected JSON object only, with no other text. Keep its content exactly as it is; only fix the syntax. It should follow this format:
+%s
+
+Response:
+%s`
+
+// answerReply parses a model's reply to an answer prompt, asking provider
+// once to fix it if it isn't valid JSON. repair is the usage of that extra
+// call, nil if none was made. The caller may hold provider's limiter slot:
+// the repair call doesn't take another.
+func answerReply(ctx context.Context, provider Provider, rawText string, question string) (answer *Answer, repair *Usage) {
+	c, ok := completerFor(provider)
+	answer, extracted, err := decodeAnswer(rawText, question)
+	switch {
+	case err == nil && extracted:
+		countParse(c.Provider, "extracted")
+		return answer, nil
+	case err == nil:
+		countParse(c.Provider, "clean")
+		return answer, nil
+	}
+	log.Printf("answer parse failed: provider=%s model=%s error=%q chars=%d", c.Provider, c.model(), err, len(rawText))
+
+	if ok && ctx.Err() == nil {
+		fixed, usage, rerr := c.send(ctx, c.Provider, fmt.Sprintf(repairPrompt, err, responseFormatPrompt, rawText))
+		repair = usage
+		if rerr == nil {
+			if answer, _, err := decodeAnswer(fixed, question); err == nil {
+				countParse(c.Provider, "repaired")
+				return answer, repair
+			}
+		} else {
+			log.Printf("answer repair failed: provider=%s model=%s error=%q", c.Provider, c.model(), rerr)
+		}
+	}
+
+	answer, truncated := fallbackAnswer(rawText, question)
+	if truncated {
+		countParse(c.Provider, "truncated")
+	} else {
+		countParse(c.Provider, "failed")
+	}
+	return answer, repair
+}
+
+// completerFor returns a Completer on the same provider, key and model as
+// one of the built-in providers.
+func completerFor(p Provider) (Completer, bool) {
+	switch p := p.(type) {
+	case *OpenAIProvider:
+		return Completer{Provider: "openai", APIKey: p.apiKey, Model: p.model}, true
+	case *AnthropicProvider:
+		return Completer{Provider: "anthropic", APIKey: p.apiKey, Model: p.model}, true
+	case *HuggingFaceProvider:
+		return Completer{Provider: "huggingface", APIKey: p.apiKey, Model: p.model}, true
+	}
+	return Completer{Provider: "other"}, false
+}
+
+// addUsage returns u plus v, either of which may be nil.
+func addUsage(u, v *Usage) *Usage {
+	if v == nil {
+		return u
+	}
+	if u == nil {
+		return v
+	}
+	return &Usage{InputTokens: u.InputTokens + v.InputTokens, OutputTokens: u.OutputTokens + v.OutputTokens}
+}
+
+// ParseCounts tallies how a provider's answers were parsed.
+type ParseCounts struct {
+	Clean     int64 `json:"clean"`     // the reply was the JSON object
+	Extracted int64 `json:"extracted"` // the object was found among other text
+	Repaired  int64 `json:"repaired"`  // the model fixed invalid JSON when asked
+	Truncated int64 `json:"truncated"` // cut off; the partial answer text was kept
+	Failed    int64 `json:"failed"`    // no JSON; the reply was shown as it came
+}
+
+var (
+	parseMu    sync.Mutex
+	parseStats = map[string]*ParseCounts{}
+)
+
+func countParse(provider, outcome string) {
+	parseMu.Lock()
+	defer parseMu.Unlock()
+	pc, ok := parseStats[provider]
+	if !ok {
+		pc = &ParseCounts{}
+		parseStats[provider] = pc
+	}
+	switch outcome {
+	case "clean":
+		pc.Clean++
+	case "extracted":
+		pc.Extracted++
+	case "repaired":
+		pc.Repaired++
+	case "truncated":
+		pc.Truncated++
+	case "failed":
+		pc.Failed++
+	}
+}
+
+// ParseStats reports, per provider, how the answers parsed since startup.
+func ParseStats() map[string]ParseCounts {
+	parseMu.Lock()
+	defer parseMu.Unlock()
+	stats := make(map[string]ParseCounts, len(parseStats))
+	for name, pc := range parseStats {
+		stats[name] = *pc
+	}
+	return stats
+}
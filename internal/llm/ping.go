@@ -0,0 +1,85 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"gocognigo/internal/httpclient"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Ping sends the smallest possible chat request (one-word prompt, one output
+// token) to a provider to confirm the key, model and network path all work.
+// Unlike AnswerQuestion it never retries, so a failing provider reports its
+// real error immediately. An empty model uses a cheap default per provider.
+func Ping(ctx context.Context, providerName, apiKey, model string) error {
+	switch strings.ToLower(providerName) {
+	case "openai", "":
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		req := openai.ChatCompletionRequest{
+			Model:    model,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "ping"}},
+		}
+		if isReasoningModel(model) {
+			// Reasoning models spend tokens thinking before they answer
+			req.MaxCompletionTokens = 16
+		} else {
+			req.MaxTokens = 1
+		}
+		_, err := newOpenAIClient(apiKey).CreateChatCompletion(ctx, req)
+		return err
+
+	case "anthropic":
+		if model == "" {
+			model = "claude-3-haiku-20240307"
+		}
+		body, _ := json.Marshal(map[string]interface{}{
+			"model":      model,
+			"max_tokens": 1,
+			"messages":   []map[string]string{{"role": "user", "content": "ping"}},
+		})
+		req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(body))
+		req.Header.Set("x-api-key", apiKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+		req.Header.Set("content-type", "application/json")
+		return doPing(req)
+
+	case "huggingface":
+		if model == "" {
+			model = "Qwen/Qwen2.5-7B-Instruct-1M"
+		}
+		body, _ := json.Marshal(map[string]interface{}{
+			"model":      model,
+			"max_tokens": 1,
+			"messages":   []map[string]string{{"role": "user", "content": "ping"}},
+		})
+		req, _ := http.NewRequestWithContext(ctx, "POST", "https://router.huggingface.co/v1/chat/completions", bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		return doPing(req)
+
+	default:
+		return fmt.Errorf("unknown LLM provider: %s", providerName)
+	}
+}
+
+func doPing(req *http.Request) error {
+	resp, err := httpclient.Default.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+	return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,48 @@
+package llm
+
+// ==========================================
+// Token Pricing
+// ==========================================
+
+// modelPrice is a model's list price in USD per million tokens.
+type modelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// modelPrices is keyed by model-name prefix; the longest matching prefix
+// wins, so dated snapshots (claude-sonnet-4-20250514) share their family's
+// price. Prices are list prices and only meant for rough comparisons.
+var modelPrices = map[string]modelPrice{
+	"gpt-4o":            {2.50, 10.00},
+	"gpt-4o-mini":       {0.15, 0.60},
+	"gpt-4.1":           {2.00, 8.00},
+	"gpt-4.1-mini":      {0.40, 1.60},
+	"gpt-4.1-nano":      {0.10, 0.40},
+	"o1":                {15.00, 60.00},
+	"o3":                {2.00, 8.00},
+	"o3-mini":           {1.10, 4.40},
+	"o4-mini":           {1.10, 4.40},
+	"claude-opus-4":     {15.00, 75.00},
+	"claude-opus-4-5":   {5.00, 25.00},
+	"claude-opus-4-6":   {5.00, 25.00},
+	"claude-sonnet-4":   {3.00, 15.00},
+	"claude-haiku-4-5":  {1.00, 5.00},
+	"claude-3-5-sonnet": {3.00, 15.00},
+	"claude-3-5-haiku":  {0.80, 4.00},
+	"claude-3-opus":     {15.00, 75.00},
+	"claude-3-haiku":    {0.25, 1.25},
+}
+
+// EstimateCost returns the approximate USD cost of usage on model. ok is
+// false for models without a known price (e.g. Hugging Face router models).
+func EstimateCost(model string, usage *Usage) (usd float64, ok bool) {
+	if usage == nil {
+		return 0, false
+	}
+	p, ok := longestPrefix(modelPrices, model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.InputTokens)*p.Input + float64(usage.OutputTokens)*p.Output) / 1e6, true
+}
@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gocognigo/internal/httpclient"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Provider API hosts, as httpclient tracks their rate limits.
+const (
+	openAIHost      = "api.openai.com"
+	anthropicHost   = "api.anthropic.com"
+	huggingFaceHost = "router.huggingface.co"
+)
+
+// maxAttempts is how many times a call is tried before it gives up.
+const maxAttempts = 5
+
+// maxRetryWait is the longest a call waits out a rate limit. A provider
+// asking for more (a spent daily quota, say) fails the call at once.
+const maxRetryWait = time.Minute
+
+// RateLimitError is returned when a provider is still rate-limiting a call
+// after its retries, or asks for a longer wait than a call will sit out.
+type RateLimitError struct {
+	Provider string
+	// RetryAfter is how long the provider asked to wait; 0 if it didn't say.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s rate limit reached, retry in %v: %v", e.Provider, e.RetryAfter.Round(time.Second), e.Err)
+	}
+	return fmt.Sprintf("%s rate limit reached: %v", e.Provider, e.Err)
+}
+
+func (e *RateLimitError) Unwrap() error { return e.Err }
+
+// backoff waits before retrying a call to host with key (a key setting)
+// that failed with cause (status is its HTTP status, 0 if unknown) on the
+// given attempt. It waits until the first of the keys is free of its rate
+// limit, else backs off exponentially from 2s to 20s. It returns the error to give up with instead: the context's, or
+// cause after the last attempt or when the wait is over maxRetryWait,
+// wrapped in a *RateLimitError if the host was rate-limiting.
+func backoff(ctx context.Context, provider, host, key string, attempt, status int, cause error) error {
+	limited := httpclient.ThrottledFor(host, key)
+	if attempt >= maxAttempts-1 || limited > maxRetryWait {
+		if status == http.StatusTooManyRequests || limited > 0 {
+			return &RateLimitError{Provider: provider, RetryAfter: limited, Err: cause}
+		}
+		return cause
+	}
+
+	wait := time.Duration(2*(1<<uint(attempt))) * time.Second
+	if wait > 20*time.Second {
+		wait = 20 * time.Second
+	}
+	if limited > 0 {
+		wait = limited
+	}
+	log.Printf("%s API error %v (attempt %d/%d), retrying in %v...", provider, cause, attempt+1, maxAttempts, wait)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// openAIStatus is the HTTP status of a failed go-openai call, or 0 if it
+// never got a response.
+func openAIStatus(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode
+	}
+	return 0
+}
+
+// errorToken reports err on a stream, with the wait a rate limit asked for.
+func errorToken(err error) StreamToken {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return StreamToken{Type: "error", Error: "request cancelled"}
+	}
+	tok := StreamToken{Type: "error", Error: err.Error()}
+	var rl *RateLimitError
+	if errors.As(err, &rl) {
+		tok.RetryAfter = rl.RetryAfter.Seconds()
+	}
+	return tok
+}
@@ -0,0 +1,212 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==========================================
+// Native Reasoning
+// ==========================================
+
+// Reasoning models think before they answer, and most hand that reasoning
+// back apart from the answer: Claude's thinking blocks, reasoning_content
+// from OpenAI-compatible servers, or the <think>…</think> preamble of open
+// models such as QwQ and DeepSeek-R1. That reasoning becomes
+// Answer.Thinking, in place of whatever the model wrote in the answer
+// JSON's "thinking" field.
+
+// Reasoning is one request's setting for a model's native reasoning.
+type Reasoning string
+
+const (
+	ReasoningDefault Reasoning = ""    // as the model and provider decide
+	ReasoningOff     Reasoning = "off" // as little as the model allows
+	ReasoningLow     Reasoning = "low"
+	ReasoningMedium  Reasoning = "medium"
+	ReasoningHigh    Reasoning = "high"
+)
+
+// ParseReasoning reads a reasoning setting: "", "off", "low", "medium" or
+// "high".
+func ParseReasoning(s string) (Reasoning, error) {
+	switch r := Reasoning(strings.ToLower(strings.TrimSpace(s))); r {
+	case ReasoningDefault, ReasoningOff, ReasoningLow, ReasoningMedium, ReasoningHigh:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown reasoning setting %q (want off, low, medium or high)", s)
+}
+
+// WithReasoning returns a copy of p that reasons as r. Providers other than
+// the built-in ones are returned unchanged.
+//
+// OpenAI o-series models take r as their reasoning effort; they can't skip
+// reasoning, so off asks for low. Claude models with extended thinking get a
+// thinking budget by effort, or none when off; those with adaptive thinking
+// size it themselves, and only off changes them. HuggingFace models are sent
+// r as reasoning_effort, and off disables the chat template's thinking
+// (Qwen3 and similar).
+func WithReasoning(p Provider, r Reasoning) Provider {
+	switch p := p.(type) {
+	case *OpenAIProvider:
+		c := *p
+		c.reasoning = r
+		return &c
+	case *AnthropicProvider:
+		c := *p
+		c.reasoning = r
+		return &c
+	case *HuggingFaceProvider:
+		c := *p
+		c.reasoning = r
+		return &c
+	}
+	return p
+}
+
+// openAIEffort is the reasoning_effort of an o-series request, "" for the
+// API default.
+func (r Reasoning) openAIEffort() string {
+	if r == ReasoningOff {
+		return string(ReasoningLow)
+	}
+	return string(r)
+}
+
+// thinkingBudgets are the budget_tokens of Claude's extended thinking by
+// effort.
+var thinkingBudgets = map[Reasoning]int{
+	ReasoningLow:     4000,
+	ReasoningDefault: 10000,
+	ReasoningMedium:  10000,
+	ReasoningHigh:    24000,
+}
+
+// answerTokens is the room a thinking model's max_tokens leaves for the
+// answer after its thinking budget.
+const answerTokens = 6000
+
+// anthropicMaxTokens is the max_tokens of a Messages API request to model:
+// with thinking on, room for the thinking (adaptive models budget it from
+// max_tokens) and the answer.
+func anthropicMaxTokens(model string, r Reasoning) int {
+	want := anthropicMaxTokensDefault
+	switch {
+	case r == ReasoningOff:
+	case isAdaptiveThinkingModel(model):
+		want = 16000
+	case isExtendedThinkingModel(model):
+		want = thinkingBudgets[r] + answerTokens
+	}
+	return maxOutput(model, want)
+}
+
+// anthropicThinking sets the thinking and sampling parameters of a Messages
+// API request for model; max_tokens comes from anthropicMaxTokens.
+func anthropicThinking(reqMap map[string]interface{}, model string, r Reasoning) {
+	switch {
+	case isAdaptiveThinkingModel(model) && r == ReasoningOff:
+		// No temperature: adaptive models still reject it
+		reqMap["thinking"] = map[string]interface{}{"type": "disabled"}
+	case isAdaptiveThinkingModel(model):
+		reqMap["thinking"] = map[string]interface{}{"type": "adaptive"}
+	case isExtendedThinkingModel(model) && r != ReasoningOff:
+		// The budget must leave room in max_tokens for the answer
+		budget := min(thinkingBudgets[r], anthropicMaxTokens(model, r)-answerTokens)
+		reqMap["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": budget}
+	default:
+		reqMap["temperature"] = 0.1
+	}
+}
+
+// huggingFaceReasoning adds r to a chat completions request on the
+// HuggingFace router.
+func huggingFaceReasoning(reqMap map[string]interface{}, r Reasoning) {
+	switch r {
+	case ReasoningDefault:
+	case ReasoningOff:
+		reqMap["chat_template_kwargs"] = map[string]interface{}{"enable_thinking": false}
+	default:
+		reqMap["reasoning_effort"] = string(r)
+	}
+}
+
+// nativeThinking makes reasoning the model returned apart from its answer
+// the answer's Thinking.
+func nativeThinking(answer *Answer, thinking string) {
+	if strings.TrimSpace(thinking) != "" {
+		answer.Thinking = strings.TrimSpace(thinking)
+	}
+}
+
+// splitThink separates the <think>…</think> reasoning an open model put
+// before its answer. Some providers strip the opening tag, so text before a
+// lone closing tag counts as reasoning too.
+func splitThink(text string) (thinking, answer string) {
+	end := strings.Index(text, "</think>")
+	if end < 0 {
+		return "", text
+	}
+	thinking = text[:end]
+	if start := strings.Index(thinking, "<think>"); start >= 0 {
+		if strings.TrimSpace(thinking[:start]) != "" {
+			return "", text // a tag inside the answer, not a preamble
+		}
+		thinking = thinking[start+len("<think>"):]
+	}
+	return strings.TrimSpace(thinking), strings.TrimSpace(text[end+len("</think>"):])
+}
+
+// thinkFilter does splitThink's work on a stream, sending what is inside
+// <think>…</think> as reasoning and the rest as answer text. A tag split
+// across deltas is held back until the next one completes it.
+type thinkFilter struct {
+	inThink bool
+	pending string
+}
+
+func (f *thinkFilter) write(delta string) (thinking, text string) {
+	s := f.pending + delta
+	f.pending = ""
+	var th, tx strings.Builder
+	emit := func(part string) {
+		if f.inThink {
+			th.WriteString(part)
+		} else {
+			tx.WriteString(part)
+		}
+	}
+	for s != "" {
+		tag := "<think>"
+		if f.inThink {
+			tag = "</think>"
+		}
+		if i := strings.Index(s, tag); i >= 0 {
+			emit(s[:i])
+			s = s[i+len(tag):]
+			f.inThink = !f.inThink
+			continue
+		}
+		keep := 0
+		for n := min(len(tag)-1, len(s)); n > 0; n-- {
+			if strings.HasSuffix(s, tag[:n]) {
+				keep = n
+				break
+			}
+		}
+		emit(s[:len(s)-keep])
+		f.pending = s[len(s)-keep:]
+		break
+	}
+	return th.String(), tx.String()
+}
+
+// flush returns whatever write held back.
+func (f *thinkFilter) flush() (thinking, text string) {
+	s := f.pending
+	f.pending = ""
+	if f.inThink {
+		return s, ""
+	}
+	return "", s
+}
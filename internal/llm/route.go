@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gocognigo/internal/indexer"
+)
+
+// ==================== Document Routing ====================
+
+// RouteDocuments asks a cheap model which of the summarized documents could
+// plausibly answer question, so retrieval can skip the rest. It returns at
+// most max names, all from summaries, most relevant first; none means the
+// model saw no plausible document and the caller should search them all.
+func RouteDocuments(ctx context.Context, c Completer, question string, summaries []indexer.DocumentSummary, max int) ([]string, *Usage, error) {
+	if len(summaries) == 0 {
+		return nil, nil, nil
+	}
+
+	var sb strings.Builder
+	known := make(map[string]bool, len(summaries))
+	for _, s := range summaries {
+		known[s.Document] = true
+		sb.WriteString(fmt.Sprintf("- %s: %s (%s). %s", s.Document, s.Title, s.DocType, s.Summary))
+		if len(s.KeyEntities) > 0 {
+			sb.WriteString(" Entities: " + strings.Join(s.KeyEntities, ", "))
+		}
+		sb.WriteString("\n")
+	}
+
+	prompt := fmt.Sprintf(`You route questions to documents. Given the question and a catalogue of document summaries, pick the documents that could plausibly contain the answer. Prefer recall: include a document if it might be relevant, but leave out ones that clearly are not.
+
+Question: %s
+
+Documents:
+%s
+Return ONLY valid JSON: {"documents": ["exact document name", ...]}, most relevant first, at most %d. Return an empty list if none of them could answer it.`, question, sb.String(), max)
+
+	raw, usage, err := c.CompleteJSON(ctx, prompt)
+	if err != nil {
+		return nil, nil, err
+	}
+	docs, err := parseRoutedDocuments(raw, known, max)
+	return docs, usage, err
+}
+
+// parseRoutedDocuments reads a routing reply, keeping the first max known
+// names once each.
+func parseRoutedDocuments(raw string, known map[string]bool, max int) ([]string, error) {
+	var out struct {
+		Documents []string `json:"documents"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("parse routing reply: %w", err)
+	}
+	var docs []string
+	seen := map[string]bool{}
+	for _, d := range out.Documents {
+		if known[d] && !seen[d] {
+			seen[d] = true
+			docs = append(docs, d)
+			if len(docs) == max {
+				break
+			}
+		}
+	}
+	return docs, nil
+}
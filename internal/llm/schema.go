@@ -0,0 +1,236 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ==========================================
+// Structured Output Schemas
+// ==========================================
+
+// A request may ask for its answer as data conforming to a JSON schema
+// ({"party": string, "amount": number, ...}) for extraction workflows. The
+// schema is added to the system prompt, the model returns the data in the
+// answer's "data" field, and a response that doesn't conform is retried with
+// the violations spelled out. Any provider works; only the prompt changes.
+
+// maxSchemaAttempts is how many responses are requested before a
+// non-conforming one is returned with its violations.
+const maxSchemaAttempts = 3
+
+// Schema is the subset of JSON Schema used for structured answers: type,
+// properties, required, items, enum and description.
+type Schema struct {
+	Type        string             `json:"type,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []interface{}      `json:"enum,omitempty"`
+}
+
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// ParseSchema parses and checks a schema supplied with a request.
+func ParseSchema(raw json.RawMessage) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.check("$"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Schema) check(path string) error {
+	if s.Type != "" && !schemaTypes[s.Type] {
+		return fmt.Errorf("invalid schema: %s: unknown type %q", path, s.Type)
+	}
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			return fmt.Errorf("invalid schema: %s: required property %q is not defined", path, name)
+		}
+	}
+	for name, p := range s.Properties {
+		if p == nil {
+			return fmt.Errorf("invalid schema: %s.%s: empty property schema", path, name)
+		}
+		if err := p.check(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+	return nil
+}
+
+// Validate returns every way v (decoded JSON) violates the schema, as
+// "path: problem" strings. An empty result means v conforms.
+func (s *Schema) Validate(v interface{}) []string {
+	var errs []string
+	s.validate("$", v, &errs)
+	return errs
+}
+
+func (s *Schema) validate(path string, v interface{}, errs *[]string) {
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			*errs = append(*errs, fmt.Sprintf("%s: %v is not one of %v", path, v, s.Enum))
+		}
+	}
+
+	switch s.Type {
+	case "":
+		return
+	case "null":
+		if v != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: expected null, got %s", path, jsonType(v)))
+		}
+	case "string", "boolean":
+		if jsonType(v) != s.Type {
+			*errs = append(*errs, fmt.Sprintf("%s: expected %s, got %s", path, s.Type, jsonType(v)))
+		}
+	case "number", "integer":
+		f, ok := v.(float64)
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: expected %s, got %s", path, s.Type, jsonType(v)))
+		} else if s.Type == "integer" && f != float64(int64(f)) {
+			*errs = append(*errs, fmt.Sprintf("%s: expected integer, got %v", path, f))
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: expected array, got %s", path, jsonType(v)))
+			return
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: expected object, got %s", path, jsonType(v)))
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				*errs = append(*errs, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if val, ok := obj[name]; ok {
+				s.Properties[name].validate(path+"."+name, val, errs)
+			}
+		}
+	}
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// schemaInstructions tells the model to return data conforming to schema,
+// listing the violations of its previous attempt if there was one.
+func schemaInstructions(schema *Schema, violations []string) string {
+	schemaJSON, _ := json.MarshalIndent(schema, "", "  ")
+	var sb strings.Builder
+	sb.WriteString("STRUCTURED OUTPUT: in addition to the usual fields, include a \"data\" field in your JSON response. Its value must conform to this JSON schema:\n\n")
+	sb.Write(schemaJSON)
+	sb.WriteString("\n\nFill every value from the provided context only, with exact figures and wording, and cite the sources in the answer text as usual. Use null for values the context does not state, where the schema allows it.")
+	if len(violations) > 0 {
+		sb.WriteString("\n\nYour previous response's \"data\" field did not conform to the schema:\n- ")
+		sb.WriteString(strings.Join(violations, "\n- "))
+		sb.WriteString("\nReturn corrected data.")
+	}
+	return sb.String()
+}
+
+// AnswerStructured answers a question with Answer.Data conforming to
+// schema. Non-conforming responses are retried up to maxSchemaAttempts
+// times; if every attempt fails validation, the last answer is returned with
+// its violations in SchemaErrors. Usage covers every attempt.
+func AnswerStructured(ctx context.Context, p Provider, schema *Schema, question string, results []retriever.Result, summaries []indexer.DocumentSummary, history []ChatMessage, customSystemPrompt ...string) (*Answer, error) {
+	var custom, base string
+	if len(customSystemPrompt) > 0 {
+		custom = customSystemPrompt[0]
+	}
+	if len(customSystemPrompt) > 1 {
+		base = customSystemPrompt[1]
+	}
+	var total Usage
+	var violations []string
+	var answer *Answer
+	for attempt := 0; attempt < maxSchemaAttempts; attempt++ {
+		sysPrompt := schemaInstructions(schema, violations)
+		if custom != "" {
+			sysPrompt = custom + "\n\n" + sysPrompt
+		}
+		var err error
+		answer, err = p.AnswerQuestion(ctx, question, results, summaries, history, sysPrompt, base)
+		if err != nil {
+			return nil, err
+		}
+		if answer.Usage != nil {
+			total.InputTokens += answer.Usage.InputTokens
+			total.OutputTokens += answer.Usage.OutputTokens
+		}
+
+		violations = nil
+		var data interface{}
+		if len(answer.Data) == 0 {
+			violations = []string{"$: the \"data\" field is missing"}
+		} else if err := json.Unmarshal(answer.Data, &data); err != nil {
+			violations = []string{"$: \"data\" is not valid JSON"}
+		} else {
+			violations = schema.Validate(data)
+		}
+		if len(violations) == 0 {
+			break
+		}
+	}
+	answer.SchemaErrors = violations
+	if total.Total() > 0 {
+		answer.Usage = &total
+	}
+	return answer, nil
+}
@@ -7,11 +7,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"strings"
-	"time"
 
+	"gocognigo/internal/httpclient"
 	"gocognigo/internal/indexer"
 	"gocognigo/internal/retriever"
 
@@ -28,6 +27,9 @@ type StreamToken struct {
 	Final *Answer `json:"final,omitempty"`
 	// Error message, sent only with type="error".
 	Error string `json:"error,omitempty"`
+	// RetryAfter is how many seconds a rate-limited provider asked to wait
+	// before trying again, with type="error".
+	RetryAfter float64 `json:"retry_after,omitempty"`
 }
 
 // StreamProvider extends Provider with streaming capability.
@@ -46,9 +48,17 @@ type StreamProvider interface {
 func (p *AnthropicProvider) StreamAnswer(ctx context.Context, question string, results []retriever.Result, summaries []indexer.DocumentSummary, history []ChatMessage, tokens chan<- StreamToken, customSystemPrompt ...string) {
 	defer close(tokens)
 
+	release, err := acquireSlot(ctx, "anthropic", p.model)
+	if err != nil {
+		tokens <- StreamToken{Type: "error", Error: "request cancelled"}
+		return
+	}
+	defer release()
+
+	sysPrompt := buildSystemPrompt(customSystemPrompt...)
+	results, summaries = fitContext(p.model, anthropicMaxTokens(p.model, p.reasoning), sysPrompt+question+historyText(history), results, summaries)
 	contextStr := FormatContext(results, summaries)
 	userPrompt := fmt.Sprintf("Question: %s\n\nContext:\n%s", question, contextStr)
-	sysPrompt := buildSystemPrompt(customSystemPrompt...)
 
 	// Build messages with history
 	anthMessages := []map[string]string{}
@@ -59,30 +69,21 @@ func (p *AnthropicProvider) StreamAnswer(ctx context.Context, question string, r
 
 	reqMap := map[string]interface{}{
 		"model":      p.model,
-		"max_tokens": 4096,
+		"max_tokens": anthropicMaxTokens(p.model, p.reasoning),
 		"system":     sysPrompt,
 		"messages":   anthMessages,
 		"stream":     true,
 	}
 
-	if isAdaptiveThinkingModel(p.model) {
-		reqMap["thinking"] = map[string]interface{}{"type": "adaptive"}
-		reqMap["max_tokens"] = 16000
-	} else if isExtendedThinkingModel(p.model) {
-		reqMap["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": 10000}
-		reqMap["max_tokens"] = 16000
-	} else {
-		reqMap["temperature"] = 0.1
-	}
+	anthropicThinking(reqMap, p.model, p.reasoning)
 
 	reqBody, _ := json.Marshal(reqMap)
 
 	var resp *http.Response
-	var err error
-	client := &http.Client{}
+	client := httpclient.Default
 
 	// Retry logic
-	for attempt := 0; attempt < 5; attempt++ {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
 		req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(reqBody))
 		req.Header.Set("x-api-key", p.apiKey)
 		req.Header.Set("anthropic-version", "2023-06-01")
@@ -101,24 +102,16 @@ func (p *AnthropicProvider) StreamAnswer(ctx context.Context, question string, r
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 
+		err = fmt.Errorf("anthropic api error: %d - %s", resp.StatusCode, string(bodyBytes))
 		if resp.StatusCode == 429 || resp.StatusCode == 529 {
-			wait := time.Duration(2*(1<<uint(attempt))) * time.Second
-			if wait > 20*time.Second {
-				wait = 20 * time.Second
-			}
-			log.Printf("Anthropic streaming API error %d (attempt %d/5), retrying in %v...", resp.StatusCode, attempt+1, wait)
-			timer := time.NewTimer(wait)
-			select {
-			case <-timer.C:
-				continue
-			case <-ctx.Done():
-				timer.Stop()
-				tokens <- StreamToken{Type: "error", Error: "request cancelled"}
+			if err = backoff(ctx, "Anthropic", anthropicHost, p.apiKey, attempt, resp.StatusCode, err); err != nil {
+				tokens <- errorToken(err)
 				return
 			}
+			continue
 		}
 
-		tokens <- StreamToken{Type: "error", Error: fmt.Sprintf("anthropic api error: %d - %s", resp.StatusCode, string(bodyBytes))}
+		tokens <- errorToken(err)
 		return
 	}
 
@@ -195,16 +188,8 @@ func (p *AnthropicProvider) StreamAnswer(ctx context.Context, question string, r
 		return
 	}
 
-	answer, err := parseAnswer(rawText, question)
-	if err != nil {
-		tokens <- StreamToken{Type: "error", Error: fmt.Sprintf("parse error: %v", err)}
-		return
-	}
-
-	// Preserve thinking from the stream if parseAnswer didn't capture it
-	if answer.Thinking == "" && thinkingText.Len() > 0 {
-		answer.Thinking = thinkingText.String()
-	}
+	answer, _ := answerReply(ctx, p, rawText, question)
+	nativeThinking(answer, thinkingText.String())
 
 	tokens <- StreamToken{Type: "done", Final: answer}
 }
@@ -216,9 +201,17 @@ func (p *AnthropicProvider) StreamAnswer(ctx context.Context, question string, r
 func (p *OpenAIProvider) StreamAnswer(ctx context.Context, question string, results []retriever.Result, summaries []indexer.DocumentSummary, history []ChatMessage, tokens chan<- StreamToken, customSystemPrompt ...string) {
 	defer close(tokens)
 
+	release, err := acquireSlot(ctx, "openai", p.model)
+	if err != nil {
+		tokens <- StreamToken{Type: "error", Error: "request cancelled"}
+		return
+	}
+	defer release()
+
+	sysPrompt := buildSystemPrompt(customSystemPrompt...)
+	results, summaries = fitContext(p.model, openAIMaxTokens, sysPrompt+question+historyText(history), results, summaries)
 	contextStr := FormatContext(results, summaries)
 	userPrompt := fmt.Sprintf("**Question:** %s\n\n**Context:**\n\n%s", question, contextStr)
-	sysPrompt := buildSystemPrompt(customSystemPrompt...)
 
 	historyMsgs := buildHistoryMessages(history)
 	msgs := []openai.ChatCompletionMessage{
@@ -228,10 +221,9 @@ func (p *OpenAIProvider) StreamAnswer(ctx context.Context, question string, resu
 	msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: userPrompt})
 
 	var stream *openai.ChatCompletionStream
-	var err error
 
 	// Retry logic
-	for attempt := 0; attempt < 5; attempt++ {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
 		if ctx.Err() != nil {
 			tokens <- StreamToken{Type: "error", Error: "request cancelled"}
 			return
@@ -241,7 +233,8 @@ func (p *OpenAIProvider) StreamAnswer(ctx context.Context, question string, resu
 			stream, err = p.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
 				Model:               p.model,
 				Messages:            msgs,
-				MaxCompletionTokens: 4096,
+				MaxCompletionTokens: maxOutput(p.model, openAIMaxTokens),
+				ReasoningEffort:     p.reasoning.openAIEffort(),
 				Stream:              true,
 			})
 		} else {
@@ -257,30 +250,12 @@ func (p *OpenAIProvider) StreamAnswer(ctx context.Context, question string, resu
 			break
 		}
 
-		shouldRetry := false
-		if apiErr, ok := err.(*openai.APIError); ok {
-			if apiErr.HTTPStatusCode == 429 || apiErr.HTTPStatusCode >= 500 {
-				shouldRetry = true
-			}
-		} else if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "500") {
-			shouldRetry = true
-		}
-
-		if shouldRetry {
-			wait := time.Duration(2*(1<<uint(attempt))) * time.Second
-			if wait > 20*time.Second {
-				wait = 20 * time.Second
-			}
-			log.Printf("OpenAI streaming API error %v (attempt %d/5), retrying in %v...", err, attempt+1, wait)
-			timer := time.NewTimer(wait)
-			select {
-			case <-timer.C:
-				continue
-			case <-ctx.Done():
-				timer.Stop()
-				tokens <- StreamToken{Type: "error", Error: "request cancelled"}
+		if status := openAIStatus(err); status == 429 || status >= 500 {
+			if err = backoff(ctx, "OpenAI", openAIHost, p.apiKey, attempt, status, err); err != nil {
+				tokens <- errorToken(fmt.Errorf("openai error: %w", err))
 				return
 			}
+			continue
 		}
 
 		tokens <- StreamToken{Type: "error", Error: fmt.Sprintf("openai error: %v", err)}
@@ -293,7 +268,7 @@ func (p *OpenAIProvider) StreamAnswer(ctx context.Context, question string, resu
 	}
 	defer stream.Close()
 
-	var fullText strings.Builder
+	var fullText, thinkingText strings.Builder
 
 	for {
 		response, err := stream.Recv()
@@ -306,6 +281,10 @@ func (p *OpenAIProvider) StreamAnswer(ctx context.Context, question string, resu
 		}
 
 		if len(response.Choices) > 0 {
+			if reasoning := response.Choices[0].Delta.ReasoningContent; reasoning != "" {
+				thinkingText.WriteString(reasoning)
+				tokens <- StreamToken{Type: "thinking", Token: reasoning}
+			}
 			delta := response.Choices[0].Delta.Content
 			if delta != "" {
 				fullText.WriteString(delta)
@@ -320,11 +299,8 @@ func (p *OpenAIProvider) StreamAnswer(ctx context.Context, question string, resu
 		return
 	}
 
-	answer, err := parseAnswer(rawText, question)
-	if err != nil {
-		tokens <- StreamToken{Type: "error", Error: fmt.Sprintf("parse error: %v", err)}
-		return
-	}
+	answer, _ := answerReply(ctx, p, rawText, question)
+	nativeThinking(answer, thinkingText.String())
 
 	tokens <- StreamToken{Type: "done", Final: answer}
 }
@@ -336,9 +312,17 @@ func (p *OpenAIProvider) StreamAnswer(ctx context.Context, question string, resu
 func (p *HuggingFaceProvider) StreamAnswer(ctx context.Context, question string, results []retriever.Result, summaries []indexer.DocumentSummary, history []ChatMessage, tokens chan<- StreamToken, customSystemPrompt ...string) {
 	defer close(tokens)
 
+	release, err := acquireSlot(ctx, "huggingface", p.model)
+	if err != nil {
+		tokens <- StreamToken{Type: "error", Error: "request cancelled"}
+		return
+	}
+	defer release()
+
+	sysPrompt := buildSystemPrompt(customSystemPrompt...)
+	results, summaries = fitContext(p.model, huggingFaceMaxTokens, sysPrompt+question+historyText(history), results, summaries)
 	contextStr := FormatContext(results, summaries)
 	userPrompt := fmt.Sprintf("Question: %s\n\nContext:\n%s", question, contextStr)
-	sysPrompt := buildSystemPrompt(customSystemPrompt...)
 
 	messages := []map[string]string{
 		{"role": "system", "content": sysPrompt},
@@ -348,20 +332,21 @@ func (p *HuggingFaceProvider) StreamAnswer(ctx context.Context, question string,
 	}
 	messages = append(messages, map[string]string{"role": "user", "content": userPrompt})
 
-	reqBody, _ := json.Marshal(map[string]interface{}{
+	reqMap := map[string]interface{}{
 		"model":       p.model,
 		"messages":    messages,
-		"max_tokens":  2048,
+		"max_tokens":  maxOutput(p.model, huggingFaceMaxTokens),
 		"temperature": 0.1,
 		"stream":      true,
-	})
+	}
+	huggingFaceReasoning(reqMap, p.reasoning)
+	reqBody, _ := json.Marshal(reqMap)
 
 	url := "https://router.huggingface.co/v1/chat/completions"
 	var resp *http.Response
-	var err error
-	client := &http.Client{}
+	client := httpclient.Default
 
-	for attempt := 0; attempt < 5; attempt++ {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
 		req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 		req.Header.Set("Authorization", "Bearer "+p.apiKey)
 		req.Header.Set("Content-Type", "application/json")
@@ -379,24 +364,16 @@ func (p *HuggingFaceProvider) StreamAnswer(ctx context.Context, question string,
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 
+		err = fmt.Errorf("huggingface api error: %d - %s", resp.StatusCode, string(bodyBytes))
 		if resp.StatusCode == 429 || resp.StatusCode >= 500 {
-			wait := time.Duration(2*(1<<uint(attempt))) * time.Second
-			if wait > 20*time.Second {
-				wait = 20 * time.Second
-			}
-			log.Printf("HuggingFace streaming API error %d (attempt %d/5), retrying in %v...", resp.StatusCode, attempt+1, wait)
-			timer := time.NewTimer(wait)
-			select {
-			case <-timer.C:
-				continue
-			case <-ctx.Done():
-				timer.Stop()
-				tokens <- StreamToken{Type: "error", Error: "request cancelled"}
+			if err = backoff(ctx, "HuggingFace", huggingFaceHost, p.apiKey, attempt, resp.StatusCode, err); err != nil {
+				tokens <- errorToken(err)
 				return
 			}
+			continue
 		}
 
-		tokens <- StreamToken{Type: "error", Error: fmt.Sprintf("huggingface api error: %d - %s", resp.StatusCode, string(bodyBytes))}
+		tokens <- errorToken(err)
 		return
 	}
 
@@ -406,7 +383,18 @@ func (p *HuggingFaceProvider) StreamAnswer(ctx context.Context, question string,
 	}
 	defer resp.Body.Close()
 
-	var fullText strings.Builder
+	var fullText, thinkingText strings.Builder
+	var think thinkFilter
+	send := func(thinking, text string) {
+		if thinking != "" {
+			thinkingText.WriteString(thinking)
+			tokens <- StreamToken{Type: "thinking", Token: thinking}
+		}
+		if text != "" {
+			fullText.WriteString(text)
+			tokens <- StreamToken{Type: "text", Token: text}
+		}
+	}
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 
@@ -425,7 +413,8 @@ func (p *HuggingFaceProvider) StreamAnswer(ctx context.Context, question string,
 		var event struct {
 			Choices []struct {
 				Delta struct {
-					Content string `json:"content"`
+					Content          string `json:"content"`
+					ReasoningContent string `json:"reasoning_content"`
 				} `json:"delta"`
 			} `json:"choices"`
 		}
@@ -435,13 +424,11 @@ func (p *HuggingFaceProvider) StreamAnswer(ctx context.Context, question string,
 		}
 
 		if len(event.Choices) > 0 {
-			delta := event.Choices[0].Delta.Content
-			if delta != "" {
-				fullText.WriteString(delta)
-				tokens <- StreamToken{Type: "text", Token: delta}
-			}
+			send(event.Choices[0].Delta.ReasoningContent, "")
+			send(think.write(event.Choices[0].Delta.Content))
 		}
 	}
+	send(think.flush())
 
 	rawText := fullText.String()
 	if strings.TrimSpace(rawText) == "" {
@@ -449,11 +436,8 @@ func (p *HuggingFaceProvider) StreamAnswer(ctx context.Context, question string,
 		return
 	}
 
-	answer, err := parseAnswer(rawText, question)
-	if err != nil {
-		tokens <- StreamToken{Type: "error", Error: fmt.Sprintf("parse error: %v", err)}
-		return
-	}
+	answer, _ := answerReply(ctx, p, rawText, question)
+	nativeThinking(answer, thinkingText.String())
 
 	tokens <- StreamToken{Type: "done", Final: answer}
 }
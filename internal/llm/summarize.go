@@ -0,0 +1,381 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+)
+
+// ==========================================
+// Long-form Document Summaries
+// ==========================================
+
+// The ingest summary only samples a document's first pages. SummarizeDocument
+// reads all of it: pages are grouped into windows that are summarized in
+// parallel (map), and the window notes are then merged into one structured
+// summary (reduce). Very long documents merge in several rounds so no single
+// call sees more than a few windows' notes.
+
+const (
+	summaryModel       = "gpt-4o-mini"
+	summaryWindowWords = 3000 // page text per map call
+	summaryMergeFanIn  = 8    // notes per reduce call
+)
+
+// LongSummary is a structured summary of a whole document.
+type LongSummary struct {
+	Document    string           `json:"document"`
+	Title       string           `json:"title"`
+	Overview    string           `json:"overview"`
+	Sections    []SummarySection `json:"sections"`
+	KeyPoints   []string         `json:"key_points"`
+	KeyEntities []string         `json:"key_entities,omitempty"`
+	Pages       int              `json:"pages"`
+	Usage       *Usage           `json:"usage,omitempty"`
+}
+
+// SummarySection summarizes a page range of a document.
+type SummarySection struct {
+	Heading   string `json:"heading"`
+	PageStart int    `json:"page_start"`
+	PageEnd   int    `json:"page_end"`
+	Summary   string `json:"summary"`
+}
+
+// SummaryPage is one page of document text to summarize.
+type SummaryPage struct {
+	Number int
+	Text   string
+}
+
+// summaryNotes is the output of a map or intermediate reduce call.
+type summaryNotes struct {
+	Sections    []SummarySection `json:"sections"`
+	KeyPoints   []string         `json:"key_points"`
+	KeyEntities []string         `json:"key_entities"`
+}
+
+// summaryWindows groups consecutive pages into windows of at most maxWords
+// words. A single page longer than maxWords gets a window of its own.
+func summaryWindows(pages []SummaryPage, maxWords int) [][]SummaryPage {
+	var windows [][]SummaryPage
+	var cur []SummaryPage
+	words := 0
+	for _, p := range pages {
+		n := len(strings.Fields(p.Text))
+		if len(cur) > 0 && words+n > maxWords {
+			windows = append(windows, cur)
+			cur, words = nil, 0
+		}
+		cur = append(cur, p)
+		words += n
+	}
+	if len(cur) > 0 {
+		windows = append(windows, cur)
+	}
+	return windows
+}
+
+// SummarizeDocument produces a long-form summary of every page of a
+// document with c. Pages must be in reading order.
+func SummarizeDocument(ctx context.Context, c Completer, docName string, pages []SummaryPage) (*LongSummary, error) {
+	if c.APIKey == "" {
+		return nil, fmt.Errorf("an API key is required for summary generation")
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("document %s has no text to summarize", docName)
+	}
+
+	var usage Usage
+	var usageMu sync.Mutex
+	addUsage := func(u *Usage) {
+		usageMu.Lock()
+		usage.InputTokens += u.InputTokens
+		usage.OutputTokens += u.OutputTokens
+		usageMu.Unlock()
+	}
+
+	// Map: notes for each window of pages
+	windows := summaryWindows(pages, summaryWindowWords)
+	notes := make([]summaryNotes, len(windows))
+	errs := make([]error, len(windows))
+	var wg sync.WaitGroup
+	for i, win := range windows {
+		wg.Add(1)
+		go func(i int, win []SummaryPage) {
+			defer wg.Done()
+			var sb strings.Builder
+			for _, p := range win {
+				sb.WriteString(fmt.Sprintf("--- PAGE %d ---\n%s\n\n", p.Number, p.Text))
+			}
+			prompt := fmt.Sprintf(`Summarize pages %d-%d of the document "%s".
+
+%s
+Return ONLY valid JSON in this exact format:
+{
+  "sections": [
+    {"heading": "Topic or section name", "page_start": 1, "page_end": 3, "summary": "What these pages say, with key figures, dates, names and obligations"}
+  ],
+  "key_points": ["Important fact or finding"],
+  "key_entities": ["Party, organisation or person"]
+}
+
+Split the pages into sections by topic. Be specific; keep exact figures and terms from the text.`,
+				win[0].Number, win[len(win)-1].Number, docName, sb.String())
+			raw, u, err := c.CompleteJSON(ctx, prompt)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			addUsage(u)
+			errs[i] = json.Unmarshal([]byte(raw), &notes[i])
+		}(i, win)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("summarize pages %d-%d: %w", windows[i][0].Number, windows[i][len(windows[i])-1].Number, err)
+		}
+	}
+
+	// Intermediate reduce rounds until the notes fit in one call
+	for len(notes) > summaryMergeFanIn {
+		var merged []summaryNotes
+		for start := 0; start < len(notes); start += summaryMergeFanIn {
+			end := start + summaryMergeFanIn
+			if end > len(notes) {
+				end = len(notes)
+			}
+			prompt := fmt.Sprintf(`Merge these partial notes on the document "%s" into fewer, broader sections. Keep page ranges, exact figures and names.
+
+%s
+Return ONLY valid JSON in this exact format:
+{
+  "sections": [{"heading": "...", "page_start": 1, "page_end": 10, "summary": "..."}],
+  "key_points": ["..."],
+  "key_entities": ["..."]
+}`, docName, formatSummaryNotes(notes[start:end]))
+			raw, u, err := c.CompleteJSON(ctx, prompt)
+			if err != nil {
+				return nil, fmt.Errorf("merge summary notes: %w", err)
+			}
+			addUsage(u)
+			var n summaryNotes
+			if err := json.Unmarshal([]byte(raw), &n); err != nil {
+				return nil, fmt.Errorf("parse merged notes: %w", err)
+			}
+			merged = append(merged, n)
+		}
+		notes = merged
+	}
+
+	// Final reduce
+	prompt := fmt.Sprintf(`Write a structured summary of the document "%s" (%d pages) from these section notes.
+
+%s
+Return ONLY valid JSON in this exact format:
+{
+  "title": "Full document title",
+  "overview": "One or two paragraphs on what the document is, its purpose and its main conclusions",
+  "sections": [{"heading": "...", "page_start": 1, "page_end": 10, "summary": "..."}],
+  "key_points": ["The most important facts, figures, obligations and findings"],
+  "key_entities": ["Parties, organisations and people"]
+}
+
+Keep sections in document order and merge only sections that cover the same topic.`, docName, len(pages), formatSummaryNotes(notes))
+	raw, u, err := c.CompleteJSON(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("final summary: %w", err)
+	}
+	addUsage(u)
+
+	summary := &LongSummary{Document: docName, Pages: len(pages)}
+	if err := json.Unmarshal([]byte(raw), summary); err != nil {
+		return nil, fmt.Errorf("parse summary: %w", err)
+	}
+	summary.Document = docName
+	summary.Pages = len(pages)
+	summary.Usage = &usage
+	return summary, nil
+}
+
+func formatSummaryNotes(notes []summaryNotes) string {
+	var sb strings.Builder
+	for _, n := range notes {
+		for _, s := range n.Sections {
+			sb.WriteString(fmt.Sprintf("[pp. %d-%d] %s: %s\n", s.PageStart, s.PageEnd, s.Heading, s.Summary))
+		}
+		for _, kp := range n.KeyPoints {
+			sb.WriteString("Key point: " + kp + "\n")
+		}
+		if len(n.KeyEntities) > 0 {
+			sb.WriteString("Entities: " + strings.Join(n.KeyEntities, ", ") + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// SummarizeSections summarizes each section of a document from its pages,
+// for the summary nodes of hierarchical retrieval (see indexer.SummaryNodes).
+// Sections are summarized in parallel; one that fails or has no text is
+// left out, and an error is returned only if every section failed.
+func SummarizeSections(ctx context.Context, c Completer, docName string, pages []SummaryPage, sections []indexer.Section) ([]indexer.SectionSummary, *Usage, error) {
+	var usage Usage
+	var mu sync.Mutex
+	var firstErr error
+	out := make([]indexer.SectionSummary, len(sections))
+	var wg sync.WaitGroup
+	for i, sec := range sections {
+		var sb strings.Builder
+		words := 0
+		for _, p := range pages {
+			if p.Number < sec.PageStart || p.Number > sec.PageEnd || words >= summaryWindowWords {
+				continue
+			}
+			fields := strings.Fields(p.Text)
+			if words+len(fields) > summaryWindowWords {
+				fields = fields[:summaryWindowWords-words]
+			}
+			words += len(fields)
+			sb.WriteString(fmt.Sprintf("--- PAGE %d ---\n%s\n\n", p.Number, strings.Join(fields, " ")))
+		}
+		if words == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, sec indexer.Section, text string) {
+			defer wg.Done()
+			prompt := fmt.Sprintf(`Summarize the section "%s" (pages %d-%d) of the document "%s" for a search index: what it covers, its key facts, figures, obligations, risks and conclusions.
+
+%s
+Return ONLY valid JSON: {"summary": "4-6 sentences"}`, sec.Name, sec.PageStart, sec.PageEnd, docName, text)
+			raw, u, err := c.CompleteJSON(ctx, prompt)
+			var reply struct {
+				Summary string `json:"summary"`
+			}
+			if err == nil {
+				err = json.Unmarshal([]byte(raw), &reply)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if u != nil {
+				usage.InputTokens += u.InputTokens
+				usage.OutputTokens += u.OutputTokens
+			}
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("section %q: %w", sec.Name, err)
+				}
+				return
+			}
+			out[i] = indexer.SectionSummary{Name: sec.Name, PageStart: sec.PageStart, PageEnd: sec.PageEnd, Summary: strings.TrimSpace(reply.Summary)}
+		}(i, sec, sb.String())
+	}
+	wg.Wait()
+
+	var summaries []indexer.SectionSummary
+	for _, s := range out {
+		if s.Summary != "" {
+			summaries = append(summaries, s)
+		}
+	}
+	if len(summaries) == 0 && firstErr != nil {
+		return nil, &usage, firstErr
+	}
+	return summaries, &usage, nil
+}
+
+// ==========================================
+// Corpus Executive Summary
+// ==========================================
+
+// CorpusProbes are the retrieval queries whose results back a corpus
+// summary with excerpts, one per part of the report.
+var CorpusProbes = []string{
+	"main subject matter, purpose and conclusions",
+	"parties, organisations and people involved and their roles",
+	"key dates, deadlines, chronology of events",
+	"obligations, risks, disputes and open issues",
+	"financial figures, amounts, penalties and payments",
+}
+
+// CorpusSummary is a cross-document report on a whole project.
+type CorpusSummary struct {
+	Title            string         `json:"title"`
+	ExecutiveSummary string         `json:"executive_summary"`
+	Themes           []CorpusTheme  `json:"themes"`
+	KeyParties       []CorpusParty  `json:"key_parties"`
+	Timeline         []TimelineItem `json:"timeline"`
+	OpenQuestions    []string       `json:"open_questions,omitempty"`
+	Documents        int            `json:"documents"`
+	Usage            *Usage         `json:"usage,omitempty"`
+}
+
+// CorpusTheme is a theme running through one or more documents.
+type CorpusTheme struct {
+	Theme     string   `json:"theme"`
+	Summary   string   `json:"summary"`
+	Documents []string `json:"documents,omitempty"`
+}
+
+// CorpusParty is a party named in the corpus.
+type CorpusParty struct {
+	Name      string   `json:"name"`
+	Role      string   `json:"role"`
+	Documents []string `json:"documents,omitempty"`
+}
+
+// TimelineItem is a dated event with its source.
+type TimelineItem struct {
+	Date     string `json:"date"`
+	Event    string `json:"event"`
+	Document string `json:"document,omitempty"`
+	Page     int    `json:"page,omitempty"`
+}
+
+// SummarizeCorpus writes an executive summary of a project from its
+// document summaries and excerpts retrieved for CorpusProbes, with c.
+func SummarizeCorpus(ctx context.Context, c Completer, projectName string, summaries []indexer.DocumentSummary, excerpts []retriever.Result) (*CorpusSummary, error) {
+	if c.APIKey == "" {
+		return nil, fmt.Errorf("an API key is required for summary generation")
+	}
+	if len(summaries) == 0 && len(excerpts) == 0 {
+		return nil, fmt.Errorf("project has no document summaries or excerpts to summarize")
+	}
+
+	prompt := fmt.Sprintf(`You are preparing a kickoff memo on the document collection "%s". Using the document overviews and excerpts below, write a cross-document executive summary.
+
+%s
+
+Return ONLY valid JSON in this exact format:
+{
+  "title": "Short title for the collection",
+  "executive_summary": "Three to five paragraphs: what the collection is about, how the documents relate, and the most important conclusions",
+  "themes": [{"theme": "Theme name", "summary": "How it appears across the documents", "documents": ["doc.pdf"]}],
+  "key_parties": [{"name": "Party", "role": "Role or interest", "documents": ["doc.pdf"]}],
+  "timeline": [{"date": "2021-03-04 or as written", "event": "What happened or is due", "document": "doc.pdf", "page": 3}],
+  "open_questions": ["Gaps, inconsistencies or points needing follow-up"]
+}
+
+Order the timeline chronologically. Use only facts from the provided material, with exact names, dates and figures.`,
+		projectName, FormatContext(excerpts, summaries))
+
+	raw, usage, err := c.CompleteJSON(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	var cs CorpusSummary
+	if err := json.Unmarshal([]byte(raw), &cs); err != nil {
+		return nil, fmt.Errorf("parse corpus summary: %w", err)
+	}
+	cs.Documents = len(summaries)
+	cs.Usage = usage
+	return &cs, nil
+}
@@ -0,0 +1,600 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gocognigo/internal/httpclient"
+	"gocognigo/internal/indexer"
+	"gocognigo/internal/retriever"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ==========================================
+// Tool Calling
+// ==========================================
+
+// With tools, answering becomes a short agent loop: the model may call tools
+// (run another search, do arithmetic) and see their results before giving
+// its final JSON answer. The loop is capped; once the cap is reached the
+// model is asked to answer with what it has.
+
+// ToolProvider extends Provider with tool calling.
+type ToolProvider interface {
+	Provider
+	// ToolTurn sends one turn of a tool-using conversation. The reply either
+	// calls tools or, when it calls none, carries the final response text.
+	// When final is set the model may not call tools.
+	ToolTurn(ctx context.Context, system string, messages []ToolMessage, tools []Tool, final bool) (*ToolReply, error)
+}
+
+// Tool is a function the model can call.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  *Schema // an object schema of the arguments
+	Run         func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// ToolCall is a model's request to run a tool.
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolMessage is one message of a tool-using conversation. Role is "user",
+// "assistant" (possibly with ToolCalls) or "tool" (the result of the call
+// with ToolCallID).
+type ToolMessage struct {
+	Role       string
+	Content    string
+	ToolCalls  []ToolCall
+	ToolCallID string
+
+	raw json.RawMessage // the provider's own encoding of an assistant turn, replayed verbatim
+}
+
+// ToolReply is a model's response to one turn.
+type ToolReply struct {
+	Content   string
+	ToolCalls []ToolCall
+	Usage     *Usage
+
+	raw json.RawMessage
+}
+
+// ToolStep records one tool call made while answering.
+type ToolStep struct {
+	Tool      string          `json:"tool"`
+	Arguments json.RawMessage `json:"arguments"`
+	Result    string          `json:"result"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// DefaultMaxToolSteps is the number of tool-calling turns allowed before
+// the model must answer.
+const DefaultMaxToolSteps = 5
+
+const toolInstructions = `TOOLS: you may call the tools provided before answering — search again with a different query when the excerpts don't cover the question, or use the calculator for any arithmetic on figures rather than computing in your head. When you have what you need, respond with the final JSON answer in the format above and call no tool. Sources found by a tool can be cited like the original excerpts.`
+
+// AnswerWithTools answers a question with an agent loop of at most maxSteps
+// tool-calling turns. Every call is recorded in Answer.ToolSteps, and Usage
+// covers every turn.
+func AnswerWithTools(ctx context.Context, p ToolProvider, tools []Tool, maxSteps int, question string, results []retriever.Result, summaries []indexer.DocumentSummary, history []ChatMessage, customSystemPrompt ...string) (*Answer, error) {
+	if maxSteps <= 0 {
+		maxSteps = DefaultMaxToolSteps
+	}
+	system := buildSystemPrompt(customSystemPrompt...) + "\n\n" + toolInstructions
+	byName := map[string]Tool{}
+	for _, t := range tools {
+		byName[t.Name] = t
+	}
+
+	if c, ok := completerFor(p); ok {
+		// Tool results add to this as the loop goes; only the start is sized
+		results, summaries = fitContext(c.Model, maxOutput(c.Model, openAIMaxTokens), system+question+historyText(history), results, summaries)
+	}
+
+	var messages []ToolMessage
+	for _, m := range trimHistory(history) {
+		messages = append(messages, ToolMessage{Role: m.Role, Content: m.Content})
+	}
+	messages = append(messages, ToolMessage{
+		Role:    "user",
+		Content: fmt.Sprintf("Question: %s\n\nContext:\n%s", question, FormatContext(results, summaries)),
+	})
+
+	var steps []ToolStep
+	var total Usage
+	for turn := 0; ; turn++ {
+		final := turn >= maxSteps
+		if final {
+			messages = append(messages, ToolMessage{Role: "user", Content: "The tool budget is used up. Give your final JSON answer now from the information you have."})
+		}
+		reply, err := p.ToolTurn(ctx, system, messages, tools, final)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Usage != nil {
+			total.InputTokens += reply.Usage.InputTokens
+			total.OutputTokens += reply.Usage.OutputTokens
+		}
+
+		if len(reply.ToolCalls) == 0 || final {
+			answer, repair := answerReply(ctx, p, reply.Content, question)
+			answer.ToolSteps = steps
+			if total.Total() > 0 {
+				answer.Usage = addUsage(&total, repair)
+			}
+			return answer, nil
+		}
+
+		messages = append(messages, ToolMessage{Role: "assistant", Content: reply.Content, ToolCalls: reply.ToolCalls, raw: reply.raw})
+		for _, call := range reply.ToolCalls {
+			step := ToolStep{Tool: call.Name, Arguments: call.Arguments}
+			tool, ok := byName[call.Name]
+			if !ok {
+				step.Error = fmt.Sprintf("unknown tool %q", call.Name)
+			} else if out, err := tool.Run(ctx, call.Arguments); err != nil {
+				step.Error = err.Error()
+			} else {
+				step.Result = out
+			}
+			content := step.Result
+			if step.Error != "" {
+				content = "error: " + step.Error
+			}
+			messages = append(messages, ToolMessage{Role: "tool", Content: content, ToolCallID: call.ID})
+			steps = append(steps, step)
+		}
+	}
+}
+
+// toolAgent is a Provider that answers through AnswerWithTools.
+type toolAgent struct {
+	p        ToolProvider
+	tools    []Tool
+	maxSteps int
+}
+
+// WithTools returns a Provider whose answers may call tools, so tool use
+// composes with anything built on Provider (structured answers, batches).
+func WithTools(p ToolProvider, tools []Tool, maxSteps int) Provider {
+	return &toolAgent{p: p, tools: tools, maxSteps: maxSteps}
+}
+
+func (a *toolAgent) AnswerQuestion(ctx context.Context, question string, results []retriever.Result, summaries []indexer.DocumentSummary, history []ChatMessage, customSystemPrompt ...string) (*Answer, error) {
+	return AnswerWithTools(ctx, a.p, a.tools, a.maxSteps, question, results, summaries, history, customSystemPrompt...)
+}
+
+// retryTransient runs call up to maxAttempts times, backing off while it
+// reports a retriable error (rate limit, overload, 5xx) with its HTTP status.
+func retryTransient(ctx context.Context, provider, host, key string, call func() (retriable bool, status int, err error)) error {
+	for attempt := 0; ; attempt++ {
+		retriable, status, err := call()
+		if err == nil || !retriable {
+			return err
+		}
+		if err = backoff(ctx, provider, host, key, attempt, status, err); err != nil {
+			return err
+		}
+	}
+}
+
+// ========== Built-in Tools ==========
+
+// SearchTool lets the model run another retrieval with its own query.
+func SearchTool(search func(ctx context.Context, query string) ([]retriever.Result, error)) Tool {
+	return Tool{
+		Name:        "search_again",
+		Description: "Search the document collection again with a different or more specific query. Returns matching excerpts with their document and page.",
+		Parameters: &Schema{
+			Type:       "object",
+			Properties: map[string]*Schema{"query": {Type: "string", Description: "The search query"}},
+			Required:   []string{"query"},
+		},
+		Run: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var in struct {
+				Query string `json:"query"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil || strings.TrimSpace(in.Query) == "" {
+				return "", fmt.Errorf("query is required")
+			}
+			results, err := search(ctx, in.Query)
+			if err != nil {
+				return "", err
+			}
+			if len(results) == 0 {
+				return "No matching excerpts.", nil
+			}
+			return FormatContext(results, nil), nil
+		},
+	}
+}
+
+// CalculatorTool evaluates arithmetic expressions.
+func CalculatorTool() Tool {
+	return Tool{
+		Name:        "calculator",
+		Description: "Evaluate an arithmetic expression exactly, e.g. \"(4586550000 - 3912000000) / 3912000000 * 100\". Supports + - * / ^, parentheses and a postfix % (12.5% = 0.125). Commas in numbers are ignored.",
+		Parameters: &Schema{
+			Type:       "object",
+			Properties: map[string]*Schema{"expression": {Type: "string", Description: "The expression to evaluate"}},
+			Required:   []string{"expression"},
+		},
+		Run: func(_ context.Context, args json.RawMessage) (string, error) {
+			var in struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil {
+				return "", fmt.Errorf("expression is required")
+			}
+			v, err := Calculate(in.Expression)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		},
+	}
+}
+
+// Calculate evaluates an arithmetic expression: + - * / ^, parentheses,
+// unary minus and postfix %. Commas are ignored so "1,250,000" reads as a
+// number.
+func Calculate(expr string) (float64, error) {
+	c := &calc{s: strings.ReplaceAll(expr, ",", "")}
+	v, err := c.expr()
+	if err != nil {
+		return 0, err
+	}
+	c.space()
+	if c.i < len(c.s) {
+		return 0, fmt.Errorf("unexpected %q at position %d", c.s[c.i:], c.i)
+	}
+	return v, nil
+}
+
+// calc is a recursive-descent parser over an expression.
+type calc struct {
+	s string
+	i int
+}
+
+func (c *calc) space() {
+	for c.i < len(c.s) && unicode.IsSpace(rune(c.s[c.i])) {
+		c.i++
+	}
+}
+
+func (c *calc) peek() byte {
+	c.space()
+	if c.i < len(c.s) {
+		return c.s[c.i]
+	}
+	return 0
+}
+
+func (c *calc) expr() (float64, error) {
+	v, err := c.term()
+	for err == nil {
+		op := c.peek()
+		if op != '+' && op != '-' {
+			break
+		}
+		c.i++
+		var r float64
+		if r, err = c.term(); op == '+' {
+			v += r
+		} else {
+			v -= r
+		}
+	}
+	return v, err
+}
+
+func (c *calc) term() (float64, error) {
+	v, err := c.power()
+	for err == nil {
+		op := c.peek()
+		if op != '*' && op != '/' {
+			break
+		}
+		c.i++
+		var r float64
+		if r, err = c.power(); err != nil {
+			break
+		}
+		if op == '*' {
+			v *= r
+		} else if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		} else {
+			v /= r
+		}
+	}
+	return v, err
+}
+
+func (c *calc) power() (float64, error) {
+	v, err := c.unary()
+	if err != nil || c.peek() != '^' {
+		return v, err
+	}
+	c.i++
+	exp, err := c.power() // right-associative
+	if err != nil {
+		return 0, err
+	}
+	if exp < 0 || exp > 64 || exp != float64(int(exp)) {
+		return 0, fmt.Errorf("only whole exponents from 0 to 64 are supported")
+	}
+	r := 1.0
+	for n := int(exp); n > 0; n-- {
+		r *= v
+	}
+	return r, nil
+}
+
+func (c *calc) unary() (float64, error) {
+	switch c.peek() {
+	case '-':
+		c.i++
+		v, err := c.unary()
+		return -v, err
+	case '+':
+		c.i++
+		return c.unary()
+	}
+	v, err := c.primary()
+	for err == nil && c.peek() == '%' {
+		c.i++
+		v /= 100
+	}
+	return v, err
+}
+
+func (c *calc) primary() (float64, error) {
+	if c.peek() == '(' {
+		c.i++
+		v, err := c.expr()
+		if err != nil {
+			return 0, err
+		}
+		if c.peek() != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		c.i++
+		return v, nil
+	}
+	start := c.i
+	for c.i < len(c.s) && (c.s[c.i] >= '0' && c.s[c.i] <= '9' || c.s[c.i] == '.') {
+		c.i++
+	}
+	if start == c.i {
+		if c.i >= len(c.s) {
+			return 0, fmt.Errorf("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected %q at position %d", c.s[c.i], c.i)
+	}
+	return strconv.ParseFloat(c.s[start:c.i], 64)
+}
+
+// ========== OpenAI Tool Calling ==========
+
+func (p *OpenAIProvider) ToolTurn(ctx context.Context, system string, messages []ToolMessage, tools []Tool, final bool) (*ToolReply, error) {
+	release, err := acquireSlot(ctx, "openai", p.model)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	msgs := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: system}}
+	for _, m := range messages {
+		switch m.Role {
+		case "assistant":
+			msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: m.Content}
+			for _, c := range m.ToolCalls {
+				msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{
+					ID:       c.ID,
+					Type:     openai.ToolTypeFunction,
+					Function: openai.FunctionCall{Name: c.Name, Arguments: string(c.Arguments)},
+				})
+			}
+			msgs = append(msgs, msg)
+		case "tool":
+			msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleTool, Content: m.Content, ToolCallID: m.ToolCallID})
+		default:
+			msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: m.Content})
+		}
+	}
+
+	req := openai.ChatCompletionRequest{Model: p.model, Messages: msgs}
+	for _, t := range tools {
+		req.Tools = append(req.Tools, openai.Tool{
+			Type:     openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{Name: t.Name, Description: t.Description, Parameters: toolParameters(t)},
+		})
+	}
+	if final && len(req.Tools) > 0 {
+		req.ToolChoice = "none"
+	}
+	if isReasoningModel(p.model) {
+		req.MaxCompletionTokens = maxOutput(p.model, openAIMaxTokens)
+		req.ReasoningEffort = p.reasoning.openAIEffort()
+	} else {
+		req.Temperature = 0.1
+	}
+
+	var resp openai.ChatCompletionResponse
+	err = retryTransient(ctx, "OpenAI", openAIHost, p.apiKey, func() (bool, int, error) {
+		var err error
+		resp, err = p.client.CreateChatCompletion(ctx, req)
+		status := openAIStatus(err)
+		return status == 429 || status >= 500, status, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("openai error: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("openai empty response")
+	}
+
+	msg := resp.Choices[0].Message
+	reply := &ToolReply{
+		Content: msg.Content,
+		Usage:   &Usage{InputTokens: resp.Usage.PromptTokens, OutputTokens: resp.Usage.CompletionTokens},
+	}
+	for _, c := range msg.ToolCalls {
+		args := json.RawMessage(c.Function.Arguments)
+		if !json.Valid(args) {
+			args = json.RawMessage("{}")
+		}
+		reply.ToolCalls = append(reply.ToolCalls, ToolCall{ID: c.ID, Name: c.Function.Name, Arguments: args})
+	}
+	return reply, nil
+}
+
+// ========== Anthropic Tool Calling ==========
+
+func (p *AnthropicProvider) ToolTurn(ctx context.Context, system string, messages []ToolMessage, tools []Tool, final bool) (*ToolReply, error) {
+	release, err := acquireSlot(ctx, "anthropic", p.model)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Tool results go back as tool_result blocks of a user message, so
+	// consecutive tool and user messages are merged into one.
+	var anthMessages []map[string]interface{}
+	var pending []map[string]interface{}
+	flush := func() {
+		if len(pending) > 0 {
+			anthMessages = append(anthMessages, map[string]interface{}{"role": "user", "content": pending})
+			pending = nil
+		}
+	}
+	for _, m := range messages {
+		switch m.Role {
+		case "tool":
+			pending = append(pending, map[string]interface{}{"type": "tool_result", "tool_use_id": m.ToolCallID, "content": m.Content})
+		case "assistant":
+			flush()
+			var content interface{} = m.Content
+			if m.raw != nil {
+				content = m.raw // keeps thinking blocks and their signatures intact
+			} else if len(m.ToolCalls) > 0 {
+				var blocks []map[string]interface{}
+				if m.Content != "" {
+					blocks = append(blocks, map[string]interface{}{"type": "text", "text": m.Content})
+				}
+				for _, c := range m.ToolCalls {
+					blocks = append(blocks, map[string]interface{}{"type": "tool_use", "id": c.ID, "name": c.Name, "input": c.Arguments})
+				}
+				content = blocks
+			}
+			anthMessages = append(anthMessages, map[string]interface{}{"role": "assistant", "content": content})
+		default:
+			pending = append(pending, map[string]interface{}{"type": "text", "text": m.Content})
+		}
+	}
+	flush()
+
+	reqMap := map[string]interface{}{
+		"model":      p.model,
+		"max_tokens": anthropicMaxTokens(p.model, p.reasoning),
+		"system":     system,
+		"messages":   anthMessages,
+	}
+	var anthTools []map[string]interface{}
+	for _, t := range tools {
+		anthTools = append(anthTools, map[string]interface{}{"name": t.Name, "description": t.Description, "input_schema": toolParameters(t)})
+	}
+	if len(anthTools) > 0 {
+		reqMap["tools"] = anthTools
+		if final {
+			reqMap["tool_choice"] = map[string]string{"type": "none"}
+		}
+	}
+	anthropicThinking(reqMap, p.model, p.reasoning)
+	reqBody, _ := json.Marshal(reqMap)
+
+	var rawBody []byte
+	err = retryTransient(ctx, "Anthropic", anthropicHost, p.apiKey, func() (bool, int, error) {
+		req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(reqBody))
+		req.Header.Set("x-api-key", p.apiKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+		req.Header.Set("content-type", "application/json")
+		resp, err := httpclient.Default.Do(req)
+		if err != nil {
+			return false, 0, fmt.Errorf("anthropic req error: %w", err)
+		}
+		defer resp.Body.Close()
+		rawBody, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return false, 0, fmt.Errorf("anthropic: failed to read response body: %w", err)
+		}
+		if resp.StatusCode != 200 {
+			return resp.StatusCode == 429 || resp.StatusCode == 529, resp.StatusCode, fmt.Errorf("anthropic api error: %d - %s", resp.StatusCode, string(rawBody))
+		}
+		return false, 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var anthResp struct {
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+		Usage      struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	var rawContent struct {
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(rawBody, &anthResp); err != nil {
+		return nil, fmt.Errorf("anthropic json decode error: %w", err)
+	}
+	json.Unmarshal(rawBody, &rawContent)
+
+	reply := &ToolReply{
+		Usage: &Usage{InputTokens: anthResp.Usage.InputTokens, OutputTokens: anthResp.Usage.OutputTokens},
+		raw:   rawContent.Content,
+	}
+	for _, block := range anthResp.Content {
+		switch block.Type {
+		case "text":
+			reply.Content += block.Text
+		case "tool_use":
+			reply.ToolCalls = append(reply.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Arguments: block.Input})
+		}
+	}
+	if strings.TrimSpace(reply.Content) == "" && len(reply.ToolCalls) == 0 {
+		return nil, fmt.Errorf("anthropic: no text content in response (stop_reason: %s, blocks: %d)", anthResp.StopReason, len(anthResp.Content))
+	}
+	return reply, nil
+}
+
+// toolParameters is a tool's argument schema, defaulting to an empty object.
+func toolParameters(t Tool) *Schema {
+	if t.Parameters == nil {
+		return &Schema{Type: "object", Properties: map[string]*Schema{}}
+	}
+	return t.Parameters
+}
@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gocognigo/internal/retriever"
+)
+
+// ==========================================
+// Answer Language & Snippet Translation
+// ==========================================
+
+// Corpora ingested through Sarvam OCR mix English with Indian-language
+// pages. A query may name the language its answer should be written in,
+// whatever the sources' language, and ask for the retrieved snippets to be
+// translated too: the translation is added beside the original text, so
+// quotes and figures are still taken from the source.
+
+// languageNames maps ISO 639-1 codes to the language names used in prompts.
+var languageNames = map[string]string{
+	"en": "English", "hi": "Hindi", "bn": "Bengali", "ta": "Tamil",
+	"te": "Telugu", "mr": "Marathi", "gu": "Gujarati", "kn": "Kannada",
+	"ml": "Malayalam", "pa": "Punjabi", "od": "Odia", "or": "Odia",
+	"ur": "Urdu", "as": "Assamese", "fr": "French", "de": "German",
+	"es": "Spanish", "pt": "Portuguese", "it": "Italian", "nl": "Dutch",
+	"ar": "Arabic", "zh": "Chinese", "ja": "Japanese", "ko": "Korean",
+}
+
+// LanguageName resolves a language code ("hi", "hi-IN") to its name;
+// anything else is taken to be a name already ("Hindi").
+func LanguageName(lang string) string {
+	lang = strings.TrimSpace(lang)
+	code := strings.ToLower(lang)
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		code = code[:i]
+	}
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return lang
+}
+
+// LanguageInstruction is the system prompt addition that fixes an answer's
+// language.
+func LanguageInstruction(lang string) string {
+	name := LanguageName(lang)
+	return fmt.Sprintf(`ANSWER LANGUAGE: write the "answer", "thinking" and "confidence_reason" fields in %s, whatever the language of the source excerpts. Keep document names, section and provision numbers, and quoted figures exactly as they appear in the sources.`, name)
+}
+
+// maxTranslateChars caps the snippet text sent for translation.
+const maxTranslateChars = 1500
+
+// TranslateResults translates retrieved snippets into lang in one call to
+// c. Snippets already in lang come back empty.
+func TranslateResults(ctx context.Context, c Completer, results []retriever.Result, lang string) ([]string, *Usage, error) {
+	if len(results) == 0 {
+		return nil, nil, nil
+	}
+	if c.APIKey == "" {
+		return nil, nil, fmt.Errorf("an API key is required for translation")
+	}
+	name := LanguageName(lang)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `Translate each excerpt below into %s. Translate faithfully and completely; keep numbers, amounts, names and section references unchanged. If an excerpt is already in %s, return an empty string for it.
+
+Respond with JSON: {"translations": ["...", ...]} — one string per excerpt, in order.
+
+`, name, name)
+	for i, r := range results {
+		text := r.Text
+		if len(text) > maxTranslateChars {
+			text = text[:maxTranslateChars]
+		}
+		fmt.Fprintf(&sb, "[Excerpt %d]\n%s\n\n", i+1, text)
+	}
+
+	raw, usage, err := c.CompleteJSON(ctx, sb.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	var parsed struct {
+		Translations []string `json:"translations"`
+	}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return nil, usage, fmt.Errorf("translation parse error: %w", err)
+	}
+	out := make([]string, len(results))
+	copy(out, parsed.Translations)
+	for i := range out {
+		out[i] = strings.TrimSpace(out[i])
+	}
+	return out, usage, nil
+}
+
+// WithTranslations returns a copy of results whose context text carries
+// each non-empty translation after the original.
+func WithTranslations(results []retriever.Result, translations []string, lang string) []retriever.Result {
+	out := make([]retriever.Result, len(results))
+	copy(out, results)
+	name := LanguageName(lang)
+	for i := range out {
+		if i >= len(translations) || translations[i] == "" {
+			continue
+		}
+		note := fmt.Sprintf("\n\n[%s translation of the matched passage]\n%s", name, translations[i])
+		if out[i].ParentText != "" {
+			out[i].ParentText += note
+		} else {
+			out[i].Text += note
+		}
+	}
+	return out
+}
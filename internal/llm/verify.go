@@ -0,0 +1,123 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gocognigo/internal/retriever"
+)
+
+// ==================== Answer Verification ====================
+
+// Verification statuses.
+const (
+	VerifyAgrees     = "agrees"     // every claim is supported by its sources
+	VerifyPartial    = "partial"    // some claims are unsupported or misstated
+	VerifyDisagrees  = "disagrees"  // the answer contradicts its sources
+	VerifyUnverified = "unverified" // the check itself failed; see Error
+)
+
+// Verification is a second model's check of an answer against the excerpts
+// it cites.
+type Verification struct {
+	Status        string        `json:"status"`
+	Discrepancies []Discrepancy `json:"discrepancies,omitempty"`
+	Provider      string        `json:"provider"`
+	Model         string        `json:"model"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// Discrepancy is one claim of the answer its sources don't bear out.
+type Discrepancy struct {
+	Claim  string `json:"claim"`
+	Issue  string `json:"issue"`
+	Source int    `json:"source,omitempty"` // the footnote marker, if it cites one
+}
+
+// maxVerifyExcerptChars caps each cited excerpt sent to the verifier.
+const maxVerifyExcerptChars = 6000
+
+// VerifyAnswer asks c, ideally a different model from the one that
+// answered, whether answer is consistent with the excerpts its footnotes
+// cite (all of results when it cites none). A failed check is returned as
+// an unverified Verification with the error, never as a nil one.
+func VerifyAnswer(ctx context.Context, c Completer, question string, answer *Answer, results []retriever.Result) (*Verification, *Usage) {
+	v := &Verification{Provider: c.Provider, Model: c.model()}
+	if v.Provider == "" {
+		v.Provider = "openai"
+	}
+
+	var sb strings.Builder
+	excerpt := func(label string, r retriever.Result) {
+		text := r.ParentText
+		if text == "" {
+			text = r.Text
+		}
+		if len(text) > maxVerifyExcerptChars {
+			text = text[:maxVerifyExcerptChars] + "…"
+		}
+		sb.WriteString(fmt.Sprintf("%s %s, page %d:\n%s\n\n", label, r.Document, r.PageNumber, text))
+	}
+	for _, fn := range answer.Footnotes {
+		for _, r := range results {
+			if r.Document == fn.Document && r.PageNumber == fn.Page {
+				excerpt(fmt.Sprintf("[%d]", fn.ID), r)
+				break
+			}
+		}
+	}
+	if sb.Len() == 0 {
+		for _, r := range results {
+			excerpt("[excerpt]", r)
+		}
+	}
+
+	prompt := fmt.Sprintf(`You are checking a draft answer before it is relied on in a formal filing. Compare every factual claim in the answer (figures, dates, names, obligations, conclusions) with the cited excerpts. Footnote markers like [1] in the answer refer to the excerpt with that label. Flag claims the excerpts contradict, misstate, or do not support; do not flag claims that are supported, even if worded differently.
+
+Question: %s
+
+Draft answer:
+%s
+
+Cited excerpts:
+%s
+Return ONLY valid JSON: {"status": "agrees" | "partial" | "disagrees", "discrepancies": [{"claim": "the claim as stated", "issue": "what the excerpts say instead, or that they don't say it", "source": footnote number or 0}]}`, question, answer.Answer, sb.String())
+
+	raw, usage, err := c.CompleteJSON(ctx, prompt)
+	if err == nil {
+		err = parseVerification(raw, v)
+	}
+	if err != nil {
+		v.Status = VerifyUnverified
+		v.Error = err.Error()
+	}
+	return v, usage
+}
+
+// parseVerification reads a verifier reply into v.
+func parseVerification(raw string, v *Verification) error {
+	var out struct {
+		Status        string        `json:"status"`
+		Discrepancies []Discrepancy `json:"discrepancies"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return fmt.Errorf("parse verification reply: %w", err)
+	}
+	for _, d := range out.Discrepancies {
+		if strings.TrimSpace(d.Claim) != "" || strings.TrimSpace(d.Issue) != "" {
+			v.Discrepancies = append(v.Discrepancies, d)
+		}
+	}
+	switch status := strings.ToLower(strings.TrimSpace(out.Status)); status {
+	case VerifyAgrees, VerifyPartial, VerifyDisagrees:
+		v.Status = status
+	default:
+		return fmt.Errorf("unknown verification status %q", out.Status)
+	}
+	if v.Status == VerifyAgrees && len(v.Discrepancies) > 0 {
+		v.Status = VerifyPartial // listed problems outrank a blanket "agrees"
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package pdfdoc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Glyph widths of Helvetica and Helvetica-Bold, in thousandths of the font
+// size, for the printable ASCII characters (from the fonts' AFM files).
+// Other characters are taken to be as wide as a digit, close enough for
+// wrapping lines.
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// textWidthOf is the width of s, in points, set in Helvetica (or
+// Helvetica-Bold) at size.
+func textWidthOf(s string, bold bool, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		total += glyphWidth(winAnsi(r), bold)
+	}
+	return float64(total) * size / 1000
+}
+
+// glyphWidth is the width of a WinAnsiEncoding character code.
+func glyphWidth(c byte, bold bool) int {
+	widths := &helveticaWidths
+	if bold {
+		widths = &helveticaBoldWidths
+	}
+	switch {
+	case c >= 32 && c < 127:
+		return widths[c-32]
+	case c == 0x85 || c == 0x97: // … and —
+		return 1000
+	}
+	return 556
+}
+
+// fontDict is the font dictionary of Helvetica or Helvetica-Bold, with
+// the widths glyphWidth uses so readers place text as it was laid out.
+func fontDict(bold bool) string {
+	base := "Helvetica"
+	if bold {
+		base = "Helvetica-Bold"
+	}
+	var widths strings.Builder
+	for c := 32; c <= 255; c++ {
+		if c > 32 {
+			widths.WriteByte(' ')
+		}
+		widths.WriteString(strconv.Itoa(glyphWidth(byte(c), bold)))
+	}
+	return fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding /FirstChar 32 /LastChar 255 /Widths [%s] >>", base, widths.String())
+}
+
+// cp1252 maps the characters Windows-1252 has in 0x80–0x9F.
+var cp1252 = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// winAnsi returns r's code in WinAnsiEncoding, or '?' if it has none.
+// Tabs and other spaces become a space.
+func winAnsi(r rune) byte {
+	switch {
+	case r >= 0x20 && r < 0x7F, r >= 0xA0 && r <= 0xFF:
+		return byte(r)
+	case r == '\t', r == '\u2002', r == '\u2003', r == '\u2009', r == '\u202f':
+		return ' '
+	}
+	if c, ok := cp1252[r]; ok {
+		return c
+	}
+	return '?'
+}
+
+// encode writes s as the body of a PDF literal string in WinAnsiEncoding.
+func encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch c := winAnsi(r); c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,379 @@
+package pdfdoc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ==================== Highlighting ====================
+
+// A Highlighter adds highlight annotations to an existing PDF, as an
+// incremental update: the original bytes are kept, and the annotations and
+// the pages they are on are appended after them, so any viewer shows them
+// and removing them restores the file.
+type Highlighter struct {
+	data    []byte
+	r       *pdf.Reader
+	layouts map[int]*layout
+	marks   map[int][]markBox // by page
+}
+
+// Mark is a passage to highlight: bytes Start to End of a page's text, as
+// PageText returns it, with a note viewers show with the highlight.
+type Mark struct {
+	Page       int
+	Start, End int
+	Note       string
+}
+
+// markBox is a mark laid out: a box per line it spans.
+type markBox struct {
+	note  string
+	boxes []box
+}
+
+type box struct{ x0, y0, x1, y1 float64 }
+
+// layout is a page's text and where each character of it is.
+type layout struct {
+	text   string
+	starts []int // byte offset in text of each glyph
+	glyphs []pdf.Text
+}
+
+// highlightColor is the RGB of highlights, a marker yellow.
+const highlightColor = "1 0.85 0.2"
+
+// NewHighlighter reads a PDF to highlight. Encrypted PDFs are refused, as
+// the update would have to be encrypted too.
+func NewHighlighter(data []byte) (h *Highlighter, err error) {
+	defer recoverPDF(&err)
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("pdfdoc: %w", err)
+	}
+	if !r.Trailer().Key("Encrypt").IsNull() {
+		return nil, fmt.Errorf("pdfdoc: can't highlight an encrypted PDF")
+	}
+	return &Highlighter{data: data, r: r, layouts: map[int]*layout{}, marks: map[int][]markBox{}}, nil
+}
+
+// recoverPDF turns a panic of the PDF reader, which panics on some
+// malformed content, into an error.
+func recoverPDF(err *error) {
+	if p := recover(); p != nil {
+		*err = fmt.Errorf("pdfdoc: malformed PDF: %v", p)
+	}
+}
+
+// PageText returns the text of a page (from 1) as laid out: words apart
+// on the page are separated by a space, and lines by a newline.
+func (h *Highlighter) PageText(page int) (string, error) {
+	l, err := h.layout(page)
+	if err != nil {
+		return "", err
+	}
+	return l.text, nil
+}
+
+func (h *Highlighter) layout(page int) (l *layout, err error) {
+	if l, ok := h.layouts[page]; ok {
+		return l, nil
+	}
+	defer recoverPDF(&err)
+	if page < 1 || page > h.r.NumPage() {
+		return nil, fmt.Errorf("pdfdoc: no page %d", page)
+	}
+	l = &layout{}
+	var text strings.Builder
+	glyphs := h.r.Page(page).Content().Text
+	for i, g := range glyphs {
+		if i > 0 {
+			prev := glyphs[i-1]
+			size := math.Max(math.Abs(prev.FontSize), 1)
+			switch {
+			case math.Abs(g.Y-prev.Y) > size/2:
+				text.WriteString("\n")
+			case g.X-(prev.X+prev.W) > size*0.15 && g.S != " " && prev.S != " ":
+				text.WriteString(" ")
+			}
+		}
+		l.starts = append(l.starts, text.Len())
+		text.WriteString(g.S)
+	}
+	l.text = text.String()
+	l.glyphs = glyphs
+	h.layouts[page] = l
+	return l, nil
+}
+
+// Add highlights a mark. It is an error if the mark covers no text.
+func (h *Highlighter) Add(m Mark) error {
+	l, err := h.layout(m.Page)
+	if err != nil {
+		return err
+	}
+	var boxes []box
+	for i, g := range l.glyphs {
+		if l.starts[i] < m.Start || l.starts[i] >= m.End || strings.TrimSpace(g.S) == "" {
+			continue
+		}
+		size := math.Abs(g.FontSize)
+		b := box{g.X, g.Y - size*0.22, g.X + g.W, g.Y + size*0.9}
+		// Glyphs on the same line as the last join its box
+		if n := len(boxes); n > 0 {
+			if last := &boxes[n-1]; math.Abs(b.y0-last.y0) < size/2 && b.x0 >= last.x0 {
+				last.x1 = math.Max(last.x1, b.x1)
+				last.y0, last.y1 = math.Min(last.y0, b.y0), math.Max(last.y1, b.y1)
+				continue
+			}
+		}
+		boxes = append(boxes, b)
+	}
+	if len(boxes) == 0 {
+		return fmt.Errorf("pdfdoc: no text to highlight on page %d at %d-%d", m.Page, m.Start, m.End)
+	}
+	h.marks[m.Page] = append(h.marks[m.Page], markBox{note: m.Note, boxes: boxes})
+	return nil
+}
+
+// Marks returns how many marks were added.
+func (h *Highlighter) Marks() int {
+	n := 0
+	for _, marks := range h.marks {
+		n += len(marks)
+	}
+	return n
+}
+
+// Write writes the PDF with the marks highlighted to w.
+func (h *Highlighter) Write(w io.Writer) (err error) {
+	if h.Marks() == 0 {
+		_, err := w.Write(h.data)
+		return err
+	}
+	defer recoverPDF(&err)
+
+	trailer, err := parseValue(h.r.Trailer().String())
+	if err != nil {
+		return err
+	}
+	oldTrailer, ok := trailer.(*dictObj)
+	if !ok {
+		return fmt.Errorf("pdfdoc: malformed trailer")
+	}
+	size, _ := strconv.Atoi(fmt.Sprint(oldTrailer.get("Size")))
+	prev, xrefStream, err := lastXref(h.data)
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return fmt.Errorf("pdfdoc: trailer has no /Size")
+	}
+
+	out := bytes.NewBuffer(append([]byte(nil), h.data...))
+	if !bytes.HasSuffix(h.data, []byte("\n")) {
+		out.WriteByte('\n')
+	}
+	offsets := map[int]int{}
+	gens := map[int]int{}
+	next := size
+	obj := func(id, gen int, body string) {
+		offsets[id], gens[id] = out.Len(), gen
+		fmt.Fprintf(out, "%d %d obj\n%s\nendobj\n", id, gen, body)
+	}
+
+	now := "D:" + time.Now().UTC().Format("20060102150405Z")
+	pages := make([]int, 0, len(h.marks))
+	for page := range h.marks {
+		pages = append(pages, page)
+	}
+	sort.Ints(pages)
+	for _, page := range pages {
+		pageRef, pageVal, err := findPage(h.r, page)
+		if err != nil {
+			return err
+		}
+		parsed, err := parseValue(pageVal.String())
+		if err != nil {
+			return err
+		}
+		pageDict, ok := parsed.(*dictObj)
+		if !ok {
+			return fmt.Errorf("pdfdoc: page %d is not a dictionary", page)
+		}
+		annots := []object{}
+		if existing := pageVal.Key("Annots"); existing.Kind() == pdf.Array {
+			if v, err := parseValue(existing.String()); err == nil {
+				annots, _ = v.([]object)
+			}
+		}
+
+		for _, m := range h.marks[page] {
+			annotID, apID := next, next+1
+			next += 2
+			rect := m.boxes[0]
+			var quads, fills strings.Builder
+			for _, b := range m.boxes {
+				rect = box{math.Min(rect.x0, b.x0), math.Min(rect.y0, b.y0), math.Max(rect.x1, b.x1), math.Max(rect.y1, b.y1)}
+				fmt.Fprintf(&quads, "%.2f %.2f %.2f %.2f %.2f %.2f %.2f %.2f ", b.x0, b.y1, b.x1, b.y1, b.x0, b.y0, b.x1, b.y0)
+				fmt.Fprintf(&fills, "%.2f %.2f %.2f %.2f re f\n", b.x0, b.y0, b.x1-b.x0, b.y1-b.y0)
+			}
+			rectStr := fmt.Sprintf("[%.2f %.2f %.2f %.2f]", rect.x0, rect.y0, rect.x1, rect.y1)
+			obj(annotID, 0, fmt.Sprintf("<< /Type /Annot /Subtype /Highlight /Rect %s /QuadPoints [%s] /C [%s] /F 4 /P %d %d R /T (GoCognigo) /M (%s) /Contents %s /AP << /N %d 0 R >> >>",
+				rectStr, strings.TrimSpace(quads.String()), highlightColor, pageRef.id, pageRef.gen, now, hexString(m.note), apID))
+			// The appearance multiplies, so the text shows through
+			content := "/GS0 gs " + highlightColor + " rg\n" + fills.String()
+			obj(apID, 0, fmt.Sprintf("<< /Type /XObject /Subtype /Form /BBox %s /Resources << /ExtGState << /GS0 << /BM /Multiply >> >> >> /Length %d >>\nstream\n%s\nendstream",
+				rectStr, len(content), content))
+			annots = append(annots, ref{annotID, 0})
+		}
+		pageDict.set("Annots", annots)
+		var b strings.Builder
+		writeValue(&b, pageDict)
+		obj(pageRef.id, pageRef.gen, b.String())
+	}
+
+	newTrailer := &dictObj{vals: map[string]object{}}
+	for _, key := range []string{"Root", "Info", "ID"} {
+		if v := oldTrailer.get(key); v != nil {
+			newTrailer.set(key, v)
+		}
+	}
+	newTrailer.set("Prev", number(strconv.Itoa(prev)))
+	if xrefStream {
+		writeXrefStream(out, next, offsets, gens, newTrailer)
+	} else {
+		writeXrefTable(out, next, offsets, gens, newTrailer)
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// findPage returns the reference to a page (from 1) and its dictionary.
+func findPage(r *pdf.Reader, num int) (ref, pdf.Value, error) {
+	num--
+	node := r.Trailer().Key("Root").Key("Pages")
+Search:
+	for depth := 0; depth < 64 && node.Key("Type").Name() == "Pages"; depth++ {
+		kids := node.Key("Kids")
+		parsed, err := parseValue(kids.String())
+		if err != nil {
+			return ref{}, pdf.Value{}, err
+		}
+		refs, _ := parsed.([]object)
+		for i := 0; i < kids.Len() && i < len(refs); i++ {
+			kid := kids.Index(i)
+			if kid.Key("Type").Name() == "Pages" {
+				if c := int(kid.Key("Count").Int64()); num >= c {
+					num -= c
+					continue
+				}
+				node = kid
+				continue Search
+			}
+			if num > 0 {
+				num--
+				continue
+			}
+			kidRef, ok := refs[i].(ref)
+			if !ok {
+				return ref{}, pdf.Value{}, fmt.Errorf("pdfdoc: page isn't an indirect object")
+			}
+			return kidRef, kid, nil
+		}
+		break
+	}
+	return ref{}, pdf.Value{}, fmt.Errorf("pdfdoc: page not found")
+}
+
+var startxref = regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`)
+
+// lastXref returns where the file's last cross-reference section is, and
+// whether it is a stream (PDF 1.5) rather than a table.
+func lastXref(data []byte) (offset int, isStream bool, err error) {
+	tail := data[max(0, len(data)-1024):]
+	m := startxref.FindSubmatch(tail)
+	if m == nil {
+		return 0, false, fmt.Errorf("pdfdoc: no startxref")
+	}
+	offset, _ = strconv.Atoi(string(m[1]))
+	if offset <= 0 || offset >= len(data) {
+		return 0, false, fmt.Errorf("pdfdoc: bad startxref %d", offset)
+	}
+	return offset, !bytes.HasPrefix(bytes.TrimLeft(data[offset:], " \t\r\n"), []byte("xref")), nil
+}
+
+// xrefRuns groups object numbers into runs of consecutive ones, as
+// [first, count] pairs.
+func xrefRuns(ids []int) [][2]int {
+	sort.Ints(ids)
+	var runs [][2]int
+	for _, id := range ids {
+		if n := len(runs); n > 0 && runs[n-1][0]+runs[n-1][1] == id {
+			runs[n-1][1]++
+		} else {
+			runs = append(runs, [2]int{id, 1})
+		}
+	}
+	return runs
+}
+
+func writeXrefTable(out *bytes.Buffer, size int, offsets, gens map[int]int, trailer *dictObj) {
+	ids := make([]int, 0, len(offsets))
+	for id := range offsets {
+		ids = append(ids, id)
+	}
+	xref := out.Len()
+	out.WriteString("xref\n")
+	for _, run := range xrefRuns(ids) {
+		fmt.Fprintf(out, "%d %d\n", run[0], run[1])
+		for id := run[0]; id < run[0]+run[1]; id++ {
+			fmt.Fprintf(out, "%010d %05d n \n", offsets[id], gens[id])
+		}
+	}
+	trailer.set("Size", number(strconv.Itoa(size)))
+	var b strings.Builder
+	writeValue(&b, trailer)
+	fmt.Fprintf(out, "trailer\n%s\nstartxref\n%d\n%%%%EOF\n", b.String(), xref)
+}
+
+// writeXrefStream writes the cross-reference section as a stream object,
+// which files whose last section is one need.
+func writeXrefStream(out *bytes.Buffer, size int, offsets, gens map[int]int, trailer *dictObj) {
+	self := size
+	size++
+	offsets[self], gens[self] = out.Len(), 0
+	ids := make([]int, 0, len(offsets))
+	for id := range offsets {
+		ids = append(ids, id)
+	}
+	var data bytes.Buffer
+	index := []object{}
+	for _, run := range xrefRuns(ids) {
+		index = append(index, number(strconv.Itoa(run[0])), number(strconv.Itoa(run[1])))
+		for id := run[0]; id < run[0]+run[1]; id++ {
+			off, gen := offsets[id], gens[id]
+			data.Write([]byte{1, byte(off >> 24), byte(off >> 16), byte(off >> 8), byte(off), byte(gen >> 8), byte(gen)})
+		}
+	}
+	trailer.set("Type", name("XRef"))
+	trailer.set("Size", number(strconv.Itoa(size)))
+	trailer.set("W", []object{number("1"), number("4"), number("2")})
+	trailer.set("Index", index)
+	trailer.set("Length", number(strconv.Itoa(data.Len())))
+	var b strings.Builder
+	writeValue(&b, trailer)
+	fmt.Fprintf(out, "%d 0 obj\n%s\nstream\n", self, b.String())
+	out.Write(data.Bytes())
+	fmt.Fprintf(out, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", offsets[self])
+}
@@ -0,0 +1,217 @@
+package pdfdoc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The PDF reader resolves references as it goes and has no writer, so the
+// objects an update rewrites are recovered from its textual form of a
+// value (Value.String), which keeps references as "N G R", and written
+// back in PDF syntax.
+
+// object is a parsed PDF value: one of dictObj, []object, ref, name,
+// string (a PDF string's text), number, bool or nil.
+type object interface{}
+
+type (
+	dictObj struct {
+		keys []string
+		vals map[string]object
+	}
+	ref struct {
+		id, gen int
+	}
+	name   string
+	number string
+)
+
+func (d *dictObj) get(key string) object { return d.vals[key] }
+
+func (d *dictObj) set(key string, v object) {
+	if _, ok := d.vals[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.vals[key] = v
+}
+
+// parseValue parses s, the textual form of a PDF value.
+func parseValue(s string) (object, error) {
+	p := &valueParser{s: s}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.i != len(p.s) {
+		return nil, fmt.Errorf("pdfdoc: unexpected %.20q", p.s[p.i:])
+	}
+	return v, nil
+}
+
+// refPrefix matches a reference, "N G R".
+var refPrefix = regexp.MustCompile(`^(\d+) (\d+) R\b`)
+
+type valueParser struct {
+	s string
+	i int
+}
+
+func (p *valueParser) skipSpace() {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *valueParser) value() (object, error) {
+	p.skipSpace()
+	rest := p.s[p.i:]
+	switch {
+	case rest == "":
+		return nil, fmt.Errorf("pdfdoc: unexpected end of value")
+	case strings.HasPrefix(rest, "<<"):
+		p.i += 2
+		d := &dictObj{vals: map[string]object{}}
+		for {
+			p.skipSpace()
+			if strings.HasPrefix(p.s[p.i:], ">>") {
+				p.i += 2
+				if strings.HasPrefix(p.s[p.i:], "@") {
+					return nil, fmt.Errorf("pdfdoc: streams must be indirect")
+				}
+				return d, nil
+			}
+			key, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(name)
+			if !ok {
+				return nil, fmt.Errorf("pdfdoc: dictionary key %v is not a name", key)
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			d.set(string(k), v)
+		}
+	case rest[0] == '[':
+		p.i++
+		arr := []object{}
+		for {
+			p.skipSpace()
+			if strings.HasPrefix(p.s[p.i:], "]") {
+				p.i++
+				return arr, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	case rest[0] == '"':
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, fmt.Errorf("pdfdoc: bad string: %w", err)
+		}
+		p.i += len(quoted)
+		text, _ := strconv.Unquote(quoted)
+		return text, nil
+	case rest[0] == '/':
+		end := 1
+		for end < len(rest) && !strings.ContainsRune(" []<>/()\"", rune(rest[end])) {
+			end++
+		}
+		p.i += end
+		return name(rest[1:end]), nil
+	case strings.HasPrefix(rest, "true"):
+		p.i += 4
+		return true, nil
+	case strings.HasPrefix(rest, "false"):
+		p.i += 5
+		return false, nil
+	case strings.HasPrefix(rest, "<nil>"):
+		p.i += 5
+		return nil, nil
+	}
+
+	if m := refPrefix.FindStringSubmatch(rest); m != nil {
+		p.i += len(m[0])
+		id, _ := strconv.Atoi(m[1])
+		gen, _ := strconv.Atoi(m[2])
+		return ref{id, gen}, nil
+	}
+	end := 0
+	for end < len(rest) && strings.ContainsRune("0123456789+-.e", rune(rest[end])) {
+		end++
+	}
+	if end == 0 {
+		return nil, fmt.Errorf("pdfdoc: unexpected %.20q", rest)
+	}
+	p.i += end
+	return number(rest[:end]), nil
+}
+
+// writeValue writes v in PDF syntax.
+func writeValue(b *strings.Builder, v object) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case number:
+		if strings.ContainsAny(string(v), "e") { // PDF has no exponents
+			f, _ := strconv.ParseFloat(string(v), 64)
+			v = number(strconv.FormatFloat(f, 'f', -1, 64))
+		}
+		b.WriteString(string(v))
+	case name:
+		b.WriteByte('/')
+		for i := 0; i < len(v); i++ {
+			if c := v[i]; c < '!' || c > '~' || c == '#' || strings.IndexByte("[]<>/(){}%", c) >= 0 {
+				fmt.Fprintf(b, "#%02X", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	case string:
+		b.WriteString(hexString(v))
+	case ref:
+		fmt.Fprintf(b, "%d %d R", v.id, v.gen)
+	case []object:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeValue(b, e)
+		}
+		b.WriteByte(']')
+	case *dictObj:
+		b.WriteString("<<")
+		for _, k := range v.keys {
+			b.WriteByte(' ')
+			writeValue(b, name(k))
+			b.WriteByte(' ')
+			writeValue(b, v.vals[k])
+		}
+		b.WriteString(" >>")
+	}
+}
+
+// hexString writes s as a PDF hex string: as UTF-16 if it is text beyond
+// ASCII, as its bytes otherwise.
+func hexString(s string) string {
+	data := []byte(s)
+	if utf8.ValidString(s) && strings.IndexFunc(s, func(r rune) bool { return r > 0x7E }) >= 0 {
+		data = []byte{0xFE, 0xFF}
+		for _, u := range utf16.Encode([]rune(s)) {
+			data = append(data, byte(u>>8), byte(u))
+		}
+	}
+	return fmt.Sprintf("<%X>", data)
+}
@@ -0,0 +1,318 @@
+// Package pdfdoc writes simple PDF documents: headings, wrapped paragraphs
+// with bold and highlighted runs, notes and images, flowed onto A4 pages
+// with page numbers. It uses the standard Helvetica fonts, which need no
+// embedding, so text is limited to Windows-1252; other characters print as
+// '?'.
+//
+// It also highlights passages of existing PDFs; see Highlighter.
+package pdfdoc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+	"time"
+)
+
+// Page geometry, in points.
+const (
+	pageWidth  = 595.0 // A4
+	pageHeight = 842.0
+	margin     = 50.0
+	textWidth  = pageWidth - 2*margin
+)
+
+// Text sizes and leading, in points.
+const (
+	headingSize = 14.0
+	bodySize    = 10.0
+	noteSize    = 8.5
+	leading     = 1.3 // times the font size
+)
+
+// Run is a piece of a paragraph's text. Newlines in Text break the line.
+type Run struct {
+	Text      string
+	Bold      bool
+	Highlight bool
+}
+
+// Document is a PDF being laid out. Add content in reading order, then
+// Write it.
+type Document struct {
+	title   string
+	pages   []*bytes.Buffer
+	page    *bytes.Buffer
+	y       float64 // baseline of the next line on the current page
+	images  [][]byte
+	created time.Time
+}
+
+// New starts a document titled title (shown in PDF viewers' title bars).
+func New(title string) *Document {
+	return &Document{title: title, created: time.Now()}
+}
+
+// NewPage starts a new page, unless the current one is still empty.
+func (d *Document) NewPage() {
+	if d.page != nil && d.y == pageHeight-margin {
+		return
+	}
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pageHeight - margin
+}
+
+// PageCount returns how many pages the document has so far.
+func (d *Document) PageCount() int { return len(d.pages) }
+
+// room makes sure height points are left on the current page, starting a
+// new one if not.
+func (d *Document) room(height float64) {
+	if d.page == nil || d.y-height < margin {
+		d.page = nil
+		d.NewPage()
+	}
+}
+
+// Heading adds a bold heading.
+func (d *Document) Heading(text string) {
+	d.space(headingSize * 0.6)
+	d.flow([]Run{{Text: text, Bold: true}}, headingSize, 0)
+	d.space(headingSize * 0.3)
+}
+
+// Paragraph adds body text made of runs, wrapped to the page width.
+func (d *Document) Paragraph(runs ...Run) {
+	d.flow(runs, bodySize, 0)
+	d.space(bodySize * 0.6)
+}
+
+// Note adds small grey text, such as a caption.
+func (d *Document) Note(text string) {
+	d.flow([]Run{{Text: text}}, noteSize, 0.4)
+	d.space(noteSize * 0.6)
+}
+
+// space adds vertical space, unless at the top of a page.
+func (d *Document) space(h float64) {
+	if d.page != nil && d.y < pageHeight-margin {
+		d.y -= h
+	}
+}
+
+// word is a word of a run, as laid out.
+type word struct {
+	text      string
+	bold      bool
+	highlight bool
+	newline   bool // a line break precedes it
+}
+
+func splitWords(runs []Run) []word {
+	var words []word
+	newline := false
+	for _, r := range runs {
+		for li, line := range strings.Split(r.Text, "\n") {
+			if li > 0 {
+				newline = true
+			}
+			for _, f := range strings.Fields(line) {
+				words = append(words, word{text: f, bold: r.Bold, highlight: r.Highlight, newline: newline})
+				newline = false
+			}
+		}
+	}
+	return words
+}
+
+// flow lays runs out in lines of size-point text in grey level gray (0 is
+// black), breaking pages as needed.
+func (d *Document) flow(runs []Run, size, gray float64) {
+	words := splitWords(runs)
+	lineHeight := size * leading
+	for len(words) > 0 {
+		// Fill a line
+		n, width := 0, 0.0
+		for n < len(words) {
+			if n > 0 && words[n].newline {
+				break
+			}
+			w := textWidthOf(words[n].text, words[n].bold, size)
+			if n > 0 {
+				w += textWidthOf(" ", words[n].bold, size)
+			}
+			if n > 0 && width+w > textWidth {
+				break
+			}
+			width += w
+			n++
+		}
+		line := words[:n]
+		if n == 1 && width > textWidth {
+			line = []word{words[0]}
+			line[0].text = fitWord(words[0].text, words[0].bold, size)
+			words[0].text = words[0].text[len(line[0].text):]
+			words[0].newline = false
+			if words[0].text == "" {
+				words = words[1:]
+			}
+		} else {
+			words = words[n:]
+		}
+		d.room(lineHeight)
+		d.y -= size
+		d.drawLine(line, size, gray)
+		d.y -= lineHeight - size
+	}
+}
+
+// fitWord returns as much of the start of a word too long for a line as
+// fits on one.
+func fitWord(s string, bold bool, size float64) string {
+	end := len(s)
+	for end > 1 && textWidthOf(s[:end], bold, size) > textWidth {
+		end--
+		for end > 1 && !isRuneStart(s[end]) {
+			end--
+		}
+	}
+	return s[:end]
+}
+
+func isRuneStart(b byte) bool { return b&0xC0 != 0x80 }
+
+func (d *Document) drawLine(line []word, size, gray float64) {
+	// Highlights first, so the text is drawn over them
+	x := margin
+	for i, w := range line {
+		width := textWidthOf(w.text, w.bold, size)
+		if w.highlight {
+			start := x
+			if i > 0 && line[i-1].highlight {
+				start -= textWidthOf(" ", w.bold, size) // join with the previous word
+			}
+			fmt.Fprintf(d.page, "1 0.93 0.4 rg %.2f %.2f %.2f %.2f re f\n", start, d.y-size*0.25, x+width-start, size*1.2)
+		}
+		x += width + textWidthOf(" ", w.bold, size)
+	}
+
+	fmt.Fprintf(d.page, "BT %.2f g %.2f %.2f Td\n", gray, margin, d.y)
+	font := ""
+	for i, w := range line {
+		f := "/F1"
+		if w.bold {
+			f = "/F2"
+		}
+		if f != font {
+			fmt.Fprintf(d.page, "%s %.1f Tf\n", f, size)
+			font = f
+		}
+		text := w.text
+		if i < len(line)-1 {
+			text += " "
+		}
+		fmt.Fprintf(d.page, "(%s) Tj\n", encode(text))
+	}
+	d.page.WriteString("ET\n")
+}
+
+// Image adds a PNG image, scaled down to fit the page width and the height
+// left for it on a page.
+func (d *Document) Image(pngData []byte) error {
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		return fmt.Errorf("pdfdoc: image: %w", err)
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return fmt.Errorf("pdfdoc: empty image")
+	}
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, b, img, b.Min, draw.Over)
+	var raw bytes.Buffer
+	zw := zlib.NewWriter(&raw)
+	row := make([]byte, 3*b.Dx())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			i := y*rgba.Stride + 4*x
+			copy(row[3*x:], rgba.Pix[i:i+3])
+		}
+		_, _ = zw.Write(row)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	d.images = append(d.images, fmt.Appendf(nil, "<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+		b.Dx(), b.Dy(), raw.Len(), raw.Bytes()))
+
+	// Fit the width, and a full page's height; start a page if it doesn't
+	// fit in what is left of this one
+	w, h := float64(b.Dx()), float64(b.Dy())
+	scale := min(textWidth/w, (pageHeight-2*margin)/h)
+	w, h = w*scale, h*scale
+	d.room(h)
+	d.y -= h
+	fmt.Fprintf(d.page, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, margin+(textWidth-w)/2, d.y, len(d.images))
+	d.space(bodySize)
+	return nil
+}
+
+// Write writes the document as a PDF to w.
+func (d *Document) Write(w io.Writer) error {
+	if len(d.pages) == 0 {
+		d.NewPage()
+	}
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects: catalog, page tree, fonts, info, images, then each page and
+	// its content
+	const firstImage = 6
+	firstPage := firstImage + len(d.images)
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	var kids strings.Builder
+	for i := range d.pages {
+		fmt.Fprintf(&kids, "%d 0 R ", firstPage+2*i)
+	}
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.TrimSpace(kids.String()), len(d.pages)))
+	obj(fontDict(false))
+	obj(fontDict(true))
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (GoCognigo) /CreationDate (D:%s) >>", encode(d.title), d.created.UTC().Format("20060102150405Z")))
+	for _, img := range d.images {
+		obj(string(img))
+	}
+	var xobjects strings.Builder
+	for i := range d.images {
+		fmt.Fprintf(&xobjects, "/Im%d %d 0 R ", i+1, firstImage+i)
+	}
+	for i, content := range d.pages {
+		// Page number in the footer
+		footer := fmt.Sprintf("%d / %d", i+1, len(d.pages))
+		fmt.Fprintf(content, "BT 0.4 g /F1 %.1f Tf %.2f %.2f Td (%s) Tj ET\n", noteSize, pageWidth-margin-textWidthOf(footer, false, noteSize), margin/2, footer)
+
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> /XObject << %s>> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, xobjects.String(), firstPage+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
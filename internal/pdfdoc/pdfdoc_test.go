@@ -0,0 +1,291 @@
+package pdfdoc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// readBack parses a written document, returning its pages' text.
+func readBack(t *testing.T, doc *Document) ([]string, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	r, err := pdf.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("written PDF doesn't parse: %v", err)
+	}
+	var pages []string
+	for i := 1; i <= r.NumPage(); i++ {
+		text, err := r.Page(i).GetPlainText(nil)
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		pages = append(pages, text)
+	}
+	return pages, buf.Bytes()
+}
+
+func TestWrite_TextReadsBack(t *testing.T) {
+	doc := New("Appendix")
+	doc.Heading("Cited pages")
+	doc.Paragraph(Run{Text: "The rent is "}, Run{Text: "45,000 (per month)", Highlight: true}, Run{Text: " paid in advance."})
+	doc.Note("Cited by Q1 – café")
+
+	pages, raw := readBack(t, doc)
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	for _, want := range []string{"Cited pages", "The rent is", "45,000 (per month)", "paid in advance.", "1 / 1"} {
+		if !strings.Contains(pages[0], want) {
+			t.Errorf("page text %q lacks %q", pages[0], want)
+		}
+	}
+	if !bytes.Contains(raw, []byte("re f")) {
+		t.Error("highlighted run drew no highlight")
+	}
+	if !bytes.Contains(raw, []byte("caf\xe9")) || !bytes.Contains(raw, []byte("(\x96 )")) {
+		t.Error("text not written in WinAnsiEncoding")
+	}
+}
+
+func TestWrite_XrefOffsets(t *testing.T) {
+	doc := New("x")
+	doc.Paragraph(Run{Text: "one"})
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	start, err := strconv.Atoi(regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(out)[1])
+	if err != nil || !strings.HasPrefix(out[start:], "xref\n") {
+		t.Fatalf("startxref doesn't point at the xref table")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(out[start:], -1)
+	if len(entries) == 0 {
+		t.Fatal("no xref entries")
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(e[1])
+		if want := fmt.Sprintf("%d 0 obj", i+1); !strings.HasPrefix(out[off:], want) {
+			t.Errorf("xref entry %d points at %q, want %q", i+1, out[off:off+10], want)
+		}
+	}
+}
+
+func TestFlow_WrapsLinesAndBreaksPages(t *testing.T) {
+	doc := New("long")
+	words := make([]string, 2000)
+	for i := range words {
+		words[i] = fmt.Sprintf("word%d", i)
+	}
+	doc.Paragraph(Run{Text: strings.Join(words, " ")})
+	doc.Paragraph(Run{Text: strings.Repeat("x", 500)}) // longer than a line
+
+	pages, _ := readBack(t, doc)
+	if len(pages) < 3 {
+		t.Fatalf("got %d pages, want the text to run over several", len(pages))
+	}
+	all := strings.Join(pages, " ")
+	for _, w := range []string{"word0", "word999", "word1999"} {
+		if !strings.Contains(all, w) {
+			t.Errorf("text lacks %q", w)
+		}
+	}
+	if got := strings.Count(all, "x"); got != 500 {
+		t.Errorf("long word kept %d of 500 characters", got)
+	}
+}
+
+func TestImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 120, 160))
+	for x := 0; x < 120; x++ {
+		img.Set(x, 10, color.Black)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := New("images")
+	doc.Heading("Page 1")
+	if err := doc.Image(buf.Bytes()); err != nil {
+		t.Fatalf("Image: %v", err)
+	}
+	doc.NewPage()
+	if err := doc.Image(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	pages, raw := readBack(t, doc)
+	if len(pages) != 2 {
+		t.Errorf("got %d pages, want 2 (each image fills a page)", len(pages))
+	}
+	if n := bytes.Count(raw, []byte("/Subtype /Image /Width 120 /Height 160")); n != 2 {
+		t.Errorf("got %d image XObjects, want 2", n)
+	}
+	if err := doc.Image([]byte("not a png")); err == nil {
+		t.Error("Image accepted data that isn't a PNG")
+	}
+}
+
+func TestNewPage_SkipsEmptyPages(t *testing.T) {
+	doc := New("x")
+	doc.NewPage()
+	doc.NewPage()
+	doc.Paragraph(Run{Text: "a"})
+	doc.NewPage()
+	doc.NewPage()
+	if got := doc.PageCount(); got != 2 {
+		t.Errorf("PageCount = %d, want 2", got)
+	}
+}
+
+// ========== Highlighting ==========
+
+// highlight highlights the first occurrence of each passage in a page's
+// text, returning the updated PDF.
+func highlight(t *testing.T, data []byte, page int, passages ...string) []byte {
+	t.Helper()
+	h, err := NewHighlighter(data)
+	if err != nil {
+		t.Fatalf("NewHighlighter: %v", err)
+	}
+	text, err := h.PageText(page)
+	if err != nil {
+		t.Fatalf("PageText: %v", err)
+	}
+	for _, p := range passages {
+		i := strings.Index(text, p)
+		if i < 0 {
+			t.Fatalf("page text %q lacks %q", text, p)
+		}
+		if err := h.Add(Mark{Page: page, Start: i, End: i + len(p), Note: "Cited as [1]: " + p}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := h.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), data) {
+		t.Error("the update rewrote the original bytes")
+	}
+	return buf.Bytes()
+}
+
+// annotations returns the annotations of a page.
+func annotations(t *testing.T, data []byte, page int) pdf.Value {
+	t.Helper()
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("updated PDF doesn't parse: %v", err)
+	}
+	return r.Page(page).V.Key("Annots")
+}
+
+func TestHighlighter_AddsHighlightAnnotations(t *testing.T) {
+	doc := New("lease")
+	doc.Paragraph(Run{Text: "The monthly rent is 45,000 rupees. The notice period is 30 days."})
+	doc.NewPage()
+	doc.Paragraph(Run{Text: "Second page."})
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	out := highlight(t, buf.Bytes(), 1, "The notice period is 30 days.", "monthly rent")
+	annots := annotations(t, out, 1)
+	if annots.Len() != 2 {
+		t.Fatalf("page 1 has %d annotations, want 2", annots.Len())
+	}
+	a := annots.Index(0)
+	if a.Key("Subtype").Name() != "Highlight" || a.Key("AP").Key("N").Kind() != pdf.Stream {
+		t.Errorf("annotation = %v", a)
+	}
+	if got := a.Key("Contents").Text(); got != "Cited as [1]: The notice period is 30 days." {
+		t.Errorf("note = %q", got)
+	}
+	quads := a.Key("QuadPoints")
+	if quads.Len() != 8 {
+		t.Errorf("got %d quad points, want one line's 8", quads.Len())
+	}
+	if x0, x1 := quads.Index(0).Float64(), quads.Index(2).Float64(); x0 <= margin || x1 <= x0 || x1 > pageWidth-margin {
+		t.Errorf("highlight spans x %.1f to %.1f, not the sentence's place on the line", x0, x1)
+	}
+	if n := annotations(t, out, 2).Len(); n != 0 {
+		t.Errorf("page 2 got %d annotations", n)
+	}
+}
+
+func TestHighlighter_XrefStreamFile(t *testing.T) {
+	// A PDF 1.5 file indexed by a cross-reference stream, with an existing
+	// annotation the update must keep
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+	content := "BT /F1 12 Tf 20 100 Td (Hello highlighted world) Tj ET"
+	objs := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 300 200] /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R /Annots [6 0 R] >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		fontDict(false),
+		"<< /Type /Annot /Subtype /Text /Rect [0 0 10 10] /Contents (existing) >>",
+	}
+	offsets := []int{}
+	for i, o := range objs {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := b.Len()
+	offsets = append(offsets, xref)
+	entries := []byte{0, 0, 0, 0, 0, 0xFF, 0xFF}
+	for _, off := range offsets {
+		entries = append(entries, 1, byte(off>>24), byte(off>>16), byte(off>>8), byte(off), 0, 0)
+	}
+	fmt.Fprintf(&b, "7 0 obj\n<< /Type /XRef /Size 8 /W [1 4 2] /Root 1 0 R /Length %d >>\nstream\n", len(entries))
+	b.Write(entries)
+	fmt.Fprintf(&b, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xref)
+
+	out := highlight(t, b.Bytes(), 1, "highlighted")
+	if !bytes.Contains(out[b.Len():], []byte("/Type /XRef")) {
+		t.Error("update of an xref-stream file doesn't end in an xref stream")
+	}
+	annots := annotations(t, out, 1)
+	if annots.Len() != 2 || annots.Index(0).Key("Contents").Text() != "existing" || annots.Index(1).Key("Subtype").Name() != "Highlight" {
+		t.Errorf("annotations = %v, want the existing one and the highlight", annots)
+	}
+}
+
+func TestHighlighter_Errors(t *testing.T) {
+	if _, err := NewHighlighter([]byte("not a pdf")); err == nil {
+		t.Error("NewHighlighter accepted data that isn't a PDF")
+	}
+	doc := New("x")
+	doc.Paragraph(Run{Text: "words"})
+	var buf bytes.Buffer
+	_ = doc.Write(&buf)
+	h, err := NewHighlighter(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Add(Mark{Page: 1, Start: 1000, End: 1010}); err == nil {
+		t.Error("Add accepted a mark past the page's text")
+	}
+	if err := h.Add(Mark{Page: 9, Start: 0, End: 1}); err == nil {
+		t.Error("Add accepted a page the PDF doesn't have")
+	}
+	var out bytes.Buffer
+	if err := h.Write(&out); err != nil || !bytes.Equal(out.Bytes(), buf.Bytes()) {
+		t.Error("Write without marks changed the file")
+	}
+}
@@ -0,0 +1,26 @@
+package retriever
+
+// ========== Dot Product ==========
+
+// The dot product is nearly all of a query's CPU time on a large project,
+// so it has assembly kernels (dot_amd64.s, dot_arm64.s) where the CPU
+// supports them. Build with -tags purego to use the Go loop everywhere.
+
+// dot is the cosine similarity of two unit vectors; 0 if their dimensions
+// differ.
+func dot(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	return dotKernel(a, b)
+}
+
+// dotGeneric is the portable kernel, summing in float64. The assembly ones
+// sum in float32 lanes, which for unit vectors agrees to about 1e-6.
+func dotGeneric(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,19 @@
+//go:build !purego
+
+package retriever
+
+import "golang.org/x/sys/cpu"
+
+var useAVX2 = cpu.X86.HasAVX2 && cpu.X86.HasFMA
+
+// dotAVX2 returns the dot product of the n floats at a and b.
+//
+//go:noescape
+func dotAVX2(a, b *float32, n int) float32
+
+func dotKernel(a, b []float32) float64 {
+	if !useAVX2 {
+		return dotGeneric(a, b)
+	}
+	return float64(dotAVX2(&a[0], &b[0], len(a)))
+}
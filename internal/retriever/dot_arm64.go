@@ -0,0 +1,30 @@
+//go:build !purego
+
+package retriever
+
+import "golang.org/x/sys/cpu"
+
+var useNEON = cpu.ARM64.HasASIMD
+
+// dotNEON adds the products of the n floats at a and b, n a multiple of 16,
+// into 16 lanes written to acc.
+//
+//go:noescape
+func dotNEON(a, b *float32, n int, acc *[16]float32)
+
+func dotKernel(a, b []float32) float64 {
+	if !useNEON || len(a) < 16 {
+		return dotGeneric(a, b)
+	}
+	n := len(a) &^ 15
+	var acc [16]float32
+	dotNEON(&a[0], &b[0], n, &acc)
+	var sum float64
+	for _, x := range acc {
+		sum += float64(x)
+	}
+	for i := n; i < len(a); i++ {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
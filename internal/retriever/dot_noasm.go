@@ -0,0 +1,7 @@
+//go:build (!amd64 && !arm64) || purego
+
+package retriever
+
+func dotKernel(a, b []float32) float64 {
+	return dotGeneric(a, b)
+}
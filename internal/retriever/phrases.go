@@ -0,0 +1,195 @@
+package retriever
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Phrase is a quoted phrase from a question: its words must appear in that
+// order, or with Slop > 0 all within Slop words of it, as in
+// "termination notice"~3.
+type Phrase struct {
+	Text string
+	Slop int
+}
+
+// phrasePattern matches a phrase in straight or curly double quotes, with
+// an optional ~N proximity after it.
+var phrasePattern = regexp.MustCompile(`["“”]([^"“”]+)["“”](?:~(\d+))?`)
+
+// maxSlop bounds a proximity, so a stray ~ doesn't make every chunk with
+// the words anywhere count as a phrase match.
+const maxSlop = 50
+
+// ParsePhrases returns the quoted phrases in question, and the question
+// without their proximity suffixes, as keyword search should read it.
+func ParsePhrases(question string) ([]Phrase, string) {
+	var phrases []Phrase
+	for _, m := range phrasePattern.FindAllStringSubmatch(question, -1) {
+		text := strings.Join(strings.Fields(m[1]), " ")
+		if text == "" {
+			continue
+		}
+		p := Phrase{Text: text}
+		if m[2] != "" {
+			p.Slop, _ = strconv.Atoi(m[2])
+			p.Slop = min(p.Slop, maxSlop)
+		}
+		phrases = append(phrases, p)
+	}
+	if len(phrases) == 0 {
+		return nil, question
+	}
+	return phrases, phrasePattern.ReplaceAllString(question, `"$1"`)
+}
+
+// phraseWeight is how much more a phrase match counts in fusion than a rank
+// from the vector or BM25 search: a pasted quote is after the passage it
+// quotes, not ones about the same things.
+const phraseWeight = 2.0
+
+// phraseSearch ranks the chunks that contain the phrases quoted in text, up
+// to size of them, best first; nil if it quotes none. The chunks must pass
+// f, as allowedIDs does.
+func (r *Retriever) phraseSearch(text string, f Filters, allowedIDs []string, size int) (map[string]int, error) {
+	phrases, _ := ParsePhrases(text)
+	if len(phrases) == 0 {
+		return nil, nil
+	}
+	scores := make(map[string]float64)
+	for _, p := range phrases {
+		hits, err := r.searchPhrase(p, f, allowedIDs, size)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range hits {
+			scores[hit.ID] += hit.Score
+		}
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	ranks := make(map[string]int, min(len(ids), size))
+	for rank, id := range ids[:min(len(ids), size)] {
+		ranks[id] = rank + 1
+	}
+	return ranks, nil
+}
+
+// searchPhrase finds the chunks containing p: a phrase query for an exact
+// phrase; for a proximity, the chunks with all its words, kept if they
+// occur within the slop of each other.
+func (r *Retriever) searchPhrase(p Phrase, f Filters, allowedIDs []string, size int) ([]*search.DocumentMatch, error) {
+	var q query.Query
+	if p.Slop == 0 {
+		phrase := bleve.NewMatchPhraseQuery(p.Text)
+		phrase.SetField("text")
+		q = phrase
+	} else {
+		words := bleve.NewMatchQuery(p.Text)
+		words.SetField("text")
+		words.SetOperator(query.MatchQueryOperatorAnd)
+		q = words
+	}
+	if !f.IsZero() {
+		q = bleve.NewConjunctionQuery(q, bleve.NewDocIDQuery(allowedIDs))
+	}
+	req := bleve.NewSearchRequest(q)
+	req.Size = size
+	if p.Slop == 0 {
+		res, err := r.BM25Index.Search(req)
+		if err != nil {
+			return nil, fmt.Errorf("phrase search error: %w", err)
+		}
+		return res.Hits, nil
+	}
+
+	// Many chunks have the words somewhere; look further for those that
+	// have them close together
+	req.Size = size * 5
+	req.IncludeLocations = true
+	res, err := r.BM25Index.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("proximity search error: %w", err)
+	}
+	terms := r.phraseTerms(p.Text)
+	var hits []*search.DocumentMatch
+	for _, hit := range res.Hits {
+		if within(hit.Locations["text"], terms, len(terms)-1+p.Slop) && len(hits) < size {
+			hits = append(hits, hit)
+		}
+	}
+	return hits, nil
+}
+
+// phraseTerms returns the distinct terms the keyword index makes of text.
+func (r *Retriever) phraseTerms(text string) []string {
+	m := r.BM25Index.Mapping()
+	analyzer := m.AnalyzerNamed(m.AnalyzerNameForPath("text"))
+	if analyzer == nil {
+		return strings.Fields(strings.ToLower(text))
+	}
+	var terms []string
+	seen := make(map[string]bool)
+	for _, tok := range analyzer.Analyze([]byte(text)) {
+		if t := string(tok.Term); !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// within reports whether locs holds every one of terms inside a window of
+// span positions beyond the first.
+func within(locs search.TermLocationMap, terms []string, span int) bool {
+	type occurrence struct {
+		pos  int
+		term int
+	}
+	var occ []occurrence
+	for i, t := range terms {
+		if len(locs[t]) == 0 {
+			return false
+		}
+		for _, l := range locs[t] {
+			occ = append(occ, occurrence{int(l.Pos), i})
+		}
+	}
+	sort.Slice(occ, func(i, j int) bool { return occ[i].pos < occ[j].pos })
+
+	// Slide a window over the occurrences, shrinking it from the left while
+	// it still holds every term
+	count := make([]int, len(terms))
+	have, lo := 0, 0
+	for _, o := range occ {
+		if count[o.term]++; count[o.term] == 1 {
+			have++
+		}
+		for have == len(terms) {
+			if o.pos-occ[lo].pos <= span {
+				return true
+			}
+			if count[occ[lo].term]--; count[occ[lo].term] == 0 {
+				have--
+			}
+			lo++
+		}
+	}
+	return false
+}
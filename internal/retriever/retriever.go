@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strings"
 
+	"gocognigo/internal/analysis"
 	"gocognigo/internal/indexer"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
 )
 
 // Result represents a retrieved chunk with its relevance score
@@ -20,23 +23,49 @@ type Result struct {
 	ParentText string  `json:"parent_text"` // full page text for LLM context
 	Section    string  `json:"section"`     // section name from document summary
 	Score      float64 `json:"score"`
+	// PageStart and PageEnd span the pages ParentText covers when a
+	// section-level search returned a whole section; PageNumber is then
+	// the page of the best-matching chunk.
+	PageStart int `json:"page_start,omitempty"`
+	PageEnd   int `json:"page_end,omitempty"`
+	// Level is set when the result is a summary node rather than page
+	// text (indexer.LevelSection or LevelDocument); PageStart and PageEnd
+	// then span the pages it summarizes.
+	Level string `json:"level,omitempty"`
+	// OCRConfidence is how sure the OCR was of the chunk's page, 0 to 1;
+	// 0 if it wasn't OCR'd or the OCR couldn't say.
+	OCRConfidence float64 `json:"ocr_confidence,omitempty"`
 }
 
 // Retriever performs hybrid search over vector and BM25 indexes
 type Retriever struct {
 	Chunks       []indexer.Chunk
 	DocSummaries []indexer.DocumentSummary
+	Glossary     []analysis.GlossaryEntry // terms defined in the documents
 	BM25Index    bleve.Index
 	Embedder     indexer.EmbeddingProvider
+	Multilingual indexer.EmbeddingProvider // embeds queries for chunks with Multilingual set
+	Fuzziness    int                       // edits a BM25 term may be off by (see indexer.Index.Fuzziness)
+	// MinOCRConfidence, when > 0, down-weights chunks OCR read with less
+	// confidence than it (see ocrWeight).
+	MinOCRConfidence float64
 }
 
-// NewRetriever creates a Retriever from a pre-built Index
+// NewRetriever creates a Retriever over a View of a pre-built Index. It
+// searches the chunks the index held when it was created, and is safe to
+// use while the index is appended to.
 func NewRetriever(idx *indexer.Index) *Retriever {
+	chunks, summaries := idx.View()
 	return &Retriever{
-		Chunks:       idx.Chunks,
-		DocSummaries: idx.DocSummaries,
+		Chunks:       chunks,
+		DocSummaries: summaries,
+		Glossary:     indexer.Glossary(chunks),
 		BM25Index:    idx.BM25Index,
 		Embedder:     idx.Embedder,
+		Multilingual: idx.Multilingual,
+		Fuzziness:    idx.Fuzziness,
+
+		MinOCRConfidence: idx.MinOCRConfidence,
 	}
 }
 
@@ -44,6 +73,193 @@ func NewRetriever(idx *indexer.Index) *Retriever {
 // Results are deduplicated by parent page — if multiple small chunks from the same page match,
 // only the highest-scored one is kept (but the full parent page text is returned for LLM context).
 func (r *Retriever) Search(ctx context.Context, query string, topK int) ([]Result, error) {
+	ex, err := r.Explain(ctx, query, topK, "")
+	if err != nil {
+		return nil, err
+	}
+	return ex.Results, nil
+}
+
+// SearchDocument runs the same hybrid search restricted to one document's
+// chunks, for questions that must be answered from a specific document.
+func (r *Retriever) SearchDocument(ctx context.Context, query, document string, topK int) ([]Result, error) {
+	return r.SearchFiltered(ctx, query, topK, Filters{Documents: []string{document}})
+}
+
+// Filters restrict a search to part of the corpus. The zero value searches
+// everything.
+type Filters struct {
+	Documents []string // only these documents, if set
+	// Tags keeps only documents carrying every one of these tags, as
+	// assigned in DocumentTags (document → tags). Matching ignores case.
+	Tags         []string
+	DocumentTags map[string][]string
+}
+
+// IsZero reports whether f filters nothing out.
+func (f Filters) IsZero() bool {
+	return len(f.Documents) == 0 && len(f.Tags) == 0
+}
+
+// Allows reports whether document passes the filters.
+func (f Filters) Allows(document string) bool {
+	if len(f.Documents) > 0 {
+		found := false
+		for _, d := range f.Documents {
+			if d == document {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, want := range f.Tags {
+		found := false
+		for _, tag := range f.DocumentTags[document] {
+			if strings.EqualFold(tag, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchFiltered runs the same hybrid search over only the chunks of
+// documents that pass f.
+func (r *Retriever) SearchFiltered(ctx context.Context, query string, topK int, f Filters) ([]Result, error) {
+	ex, err := r.explain(ctx, query, topK, "", f, false)
+	if err != nil {
+		return nil, err
+	}
+	return ex.Results, nil
+}
+
+// maxSectionChars caps the text a section-level result carries, so one
+// long chapter can't crowd out every other source.
+const maxSectionChars = 12000
+
+// SearchSections runs the same hybrid search but deduplicates by section
+// (the page ranges of the document summaries) instead of by page, and
+// returns each hit's whole section as ParentText, page by page, so a
+// question about a clause or chapter gets it in one piece. Chunks outside
+// any known section fall back to their page.
+func (r *Retriever) SearchSections(ctx context.Context, query string, topK int, f Filters) ([]Result, error) {
+	ex, err := r.explain(ctx, query, topK, "", f, true)
+	if err != nil {
+		return nil, err
+	}
+	return ex.Results, nil
+}
+
+// sectionText joins the pages of a document's section around page, at most
+// maxSectionChars of them, each marked with its page number. It reports the
+// page range it covers.
+func sectionText(chunks []indexer.Chunk, document, section string, page int) (text string, start, end int) {
+	pages := map[int]string{}
+	hasParent := map[int]bool{}
+	for _, c := range chunks {
+		if c.Document != document || c.Section != section || c.IsSummary() {
+			continue
+		}
+		switch {
+		case c.ParentText != "":
+			pages[c.PageNumber] = c.ParentText
+			hasParent[c.PageNumber] = true
+		case !hasParent[c.PageNumber]:
+			pages[c.PageNumber] += c.Text + "\n" // legacy chunks without a parent
+		}
+	}
+	nums := make([]int, 0, len(pages))
+	for n := range pages {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	hit := sort.SearchInts(nums, page)
+	if hit == len(nums) || nums[hit] != page {
+		return "", page, page
+	}
+
+	// Grow a window of pages around the hit while it fits
+	lo, hi := hit, hit
+	size := len(pages[page])
+	for {
+		grown := false
+		if hi+1 < len(nums) && size+len(pages[nums[hi+1]]) <= maxSectionChars {
+			hi++
+			size += len(pages[nums[hi]])
+			grown = true
+		}
+		if lo > 0 && size+len(pages[nums[lo-1]]) <= maxSectionChars {
+			lo--
+			size += len(pages[nums[lo]])
+			grown = true
+		}
+		if !grown {
+			break
+		}
+	}
+
+	var sb strings.Builder
+	for _, n := range nums[lo : hi+1] {
+		sb.WriteString(fmt.Sprintf("[Page %d]\n%s\n\n", n, strings.TrimSpace(pages[n])))
+	}
+	return strings.TrimSpace(sb.String()), nums[lo], nums[hi]
+}
+
+// Candidate is one chunk considered during a search, with the rank and score
+// it got from each retriever. A rank of 0 means the chunk was not among that
+// retriever's candidates.
+type Candidate struct {
+	ChunkID     string  `json:"chunk_id"`
+	Document    string  `json:"document"`
+	PageNumber  int     `json:"page_number"`
+	Section     string  `json:"section,omitempty"`
+	Text        string  `json:"text"`
+	VectorRank  int     `json:"vector_rank"`
+	VectorScore float64 `json:"vector_score"` // cosine similarity
+	BM25Rank    int     `json:"bm25_rank"`
+	BM25Score   float64 `json:"bm25_score"`
+	ExactRank   int     `json:"exact_rank"`  // among chunks citing an identifier in the query (see exactSearch)
+	PhraseRank  int     `json:"phrase_rank"` // among chunks containing a phrase the query quotes (see phraseSearch)
+	FusedRank   int     `json:"fused_rank"`
+	FusedScore  float64 `json:"fused_score"`
+	// OCRConfidence is the chunk's (see Result); below MinOCRConfidence
+	// it scaled FusedScore down.
+	OCRConfidence float64 `json:"ocr_confidence,omitempty"`
+	// Status is "selected", "deduplicated" (another chunk of the same page
+	// ranked higher), "below_cutoff" (fused rank past topK) or, for targets
+	// only, "not_candidate" (outside both retrievers' candidate lists).
+	Status      string `json:"status"`
+	DuplicateOf string `json:"duplicate_of,omitempty"` // chunk kept for the page
+}
+
+// Explanation breaks a search down into its vector, BM25 and fusion stages.
+type Explanation struct {
+	Query      string      `json:"query"`
+	TopK       int         `json:"top_k"`
+	Candidates []Candidate `json:"candidates"` // every fused candidate, in fused order
+	Targets    []Candidate `json:"targets,omitempty"`
+	Results    []Result    `json:"results"`
+}
+
+// Explain runs the same hybrid search as Search and reports how every
+// candidate was scored and why it was kept or dropped. If find is non-empty,
+// Targets lists every chunk whose text contains it (case-insensitive) with
+// its full vector rank, so a missing passage can be traced even when it never
+// became a candidate.
+func (r *Retriever) Explain(ctx context.Context, query string, topK int, find string) (*Explanation, error) {
+	return r.explain(ctx, query, topK, find, Filters{}, false)
+}
+
+// explain implements Explain, searching only the chunks of documents that
+// pass f, and deduplicating by section rather than page when bySection.
+func (r *Retriever) explain(ctx context.Context, query string, topK int, find string, f Filters, bySection bool) (*Explanation, error) {
 	// 1. Embed the query
 	resp, err := r.Embedder.Embed(ctx, []string{query})
 	if err != nil {
@@ -51,42 +267,62 @@ func (r *Retriever) Search(ctx context.Context, query string, topK int) ([]Resul
 	}
 	queryEmb := resp[0]
 
-	// 2. Vector search — cosine similarity
-	type scored struct {
-		idx   int
-		score float64
+	// 2. Vector search — cosine similarity, across all cores
+	var multiEmb []float32 // the query embedded for Multilingual chunks
+	if r.Multilingual != nil && needsMultilingual(r.Chunks, f) {
+		resp, err := r.Multilingual.Embed(ctx, []string{query})
+		if err != nil {
+			return nil, fmt.Errorf("multilingual query embedding error: %w", err)
+		}
+		multiEmb = resp[0]
 	}
-	var vectorScores []scored
-	for i, chunk := range r.Chunks {
-		sim := cosineSimilarity(queryEmb, chunk.Embedding)
-		vectorScores = append(vectorScores, scored{i, sim})
+	vectorScores, allowedIDs := vectorScan(r.Chunks, queryEmb, multiEmb, f)
+	limit := min(topK*3, len(vectorScores))
+	// Only the top candidates are ranked, unless find needs every chunk's rank
+	var ranked []scored // best first
+	if find != "" {
+		ranked = append([]scored(nil), vectorScores...)
+		sort.Slice(ranked, func(i, j int) bool { return ranked[i].better(ranked[j]) })
+	} else {
+		ranked = topScored(vectorScores, limit)
 	}
-	sort.Slice(vectorScores, func(i, j int) bool {
-		return vectorScores[i].score > vectorScores[j].score
-	})
 
 	// 3. BM25 search
-	bm25Query := bleve.NewMatchQuery(query)
+	_, keywords := ParsePhrases(query)
+	bm25Query := bleve.NewMatchQuery(keywords)
+	bm25Query.SetFuzziness(r.Fuzziness)
 	searchReq := bleve.NewSearchRequest(bm25Query)
+	if !f.IsZero() {
+		searchReq = bleve.NewSearchRequest(bleve.NewConjunctionQuery(bm25Query, bleve.NewDocIDQuery(allowedIDs)))
+	}
 	searchReq.Size = topK * 3 // Get more candidates for fusion
 	bm25Results, err := r.BM25Index.Search(searchReq)
 	if err != nil {
 		return nil, fmt.Errorf("BM25 search error: %w", err)
 	}
 
+	// ...and of the chunks citing identifiers the query names, or quoting
+	// its phrases
+	exactRanks, err := r.exactSearch(keywords, f, allowedIDs, topK*3)
+	if err != nil {
+		return nil, err
+	}
+	phraseRanks, err := r.phraseSearch(query, f, allowedIDs, topK*3)
+	if err != nil {
+		return nil, err
+	}
+
 	// 4. Build chunk ID → rank maps for RRF
 	vectorRanks := make(map[string]int)
-	limit := topK * 3
-	if limit > len(vectorScores) {
-		limit = len(vectorScores)
-	}
-	for rank, s := range vectorScores[:limit] {
+	for rank, s := range ranked[:limit] {
 		vectorRanks[r.Chunks[s.idx].ID] = rank + 1
 	}
 
 	bm25Ranks := make(map[string]int)
+	bm25Scores := make(map[string]float64)
 	for rank, hit := range bm25Results.Hits {
 		bm25Ranks[hit.ID] = rank + 1
+		bm25Scores[hit.ID] = hit.Score
 	}
 
 	// 5. Reciprocal Rank Fusion (k=60)
@@ -98,6 +334,17 @@ func (r *Retriever) Search(ctx context.Context, query string, topK int) ([]Resul
 	for id := range bm25Ranks {
 		allIDs[id] = true
 	}
+	for id := range exactRanks {
+		allIDs[id] = true
+	}
+	for id := range phraseRanks {
+		allIDs[id] = true
+	}
+
+	chunkMap := make(map[string]indexer.Chunk)
+	for _, c := range r.Chunks {
+		chunkMap[c.ID] = c
+	}
 
 	type fusedResult struct {
 		id    string
@@ -112,47 +359,190 @@ func (r *Retriever) Search(ctx context.Context, query string, topK int) ([]Resul
 		if br, ok := bm25Ranks[id]; ok {
 			score += 1.0 / (k + float64(br))
 		}
+		if er, ok := exactRanks[id]; ok {
+			score += exactWeight / (k + float64(er))
+		}
+		if pr, ok := phraseRanks[id]; ok {
+			score += phraseWeight / (k + float64(pr))
+		}
+		score *= r.ocrWeight(chunkMap[id])
 		fused = append(fused, fusedResult{id, score})
 	}
-	sort.Slice(fused, func(i, j int) bool {
-		return fused[i].score > fused[j].score
+	// allIDs is a map, so ties are broken by vector rank (ranked chunks
+	// first), then chunk ID, to keep the order the same from run to run
+	sort.SliceStable(fused, func(i, j int) bool {
+		a, b := fused[i], fused[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		va, vb := vectorRanks[a.id], vectorRanks[b.id]
+		if va != vb {
+			return vb == 0 || (va != 0 && va < vb)
+		}
+		return a.id < b.id
 	})
 
 	// 6. Build result list with parent-page deduplication
-	chunkMap := make(map[string]indexer.Chunk)
-	for _, c := range r.Chunks {
-		chunkMap[c.ID] = c
+	cosine := make(map[string]float64, len(allIDs))
+	fullVectorRank := make(map[string]int)
+	if find != "" {
+		for rank, s := range ranked {
+			cosine[r.Chunks[s.idx].ID] = s.score
+			fullVectorRank[r.Chunks[s.idx].ID] = rank + 1
+		}
+	} else {
+		// Keyword-only candidates still report their vector score
+		for _, s := range vectorScores {
+			if id := r.Chunks[s.idx].ID; allIDs[id] {
+				cosine[id] = s.score
+			}
+		}
 	}
 
-	// Deduplicate: keep only the best-scoring chunk per parent page (doc+page)
-	seen := make(map[string]bool) // "document_pageN" → already included
-	var results []Result
-	for _, f := range fused {
-		if len(results) >= topK {
-			break
-		}
+	ex := &Explanation{Query: query, TopK: topK, Candidates: []Candidate{}, Results: []Result{}}
+
+	// Deduplicate: keep only the best-scoring chunk per parent page (doc+page),
+	// or per section
+	seen := make(map[string]string)      // "document_pageN" → chunk ID already included
+	candidateIdx := make(map[string]int) // chunk ID → index in ex.Candidates
+	for i, f := range fused {
 		chunk, ok := chunkMap[f.id]
 		if !ok {
 			continue
 		}
+		c := Candidate{
+			ChunkID:     chunk.ID,
+			Document:    chunk.Document,
+			PageNumber:  chunk.PageNumber,
+			Section:     chunk.Section,
+			Text:        chunk.Text,
+			VectorRank:  vectorRanks[f.id],
+			VectorScore: cosine[f.id],
+			BM25Rank:    bm25Ranks[f.id],
+			BM25Score:   bm25Scores[f.id],
+			ExactRank:   exactRanks[f.id],
+			PhraseRank:  phraseRanks[f.id],
+			FusedRank:   i + 1,
+			FusedScore:  f.score,
+
+			OCRConfidence: chunk.OCRConfidence,
+		}
+
 		parentKey := fmt.Sprintf("%s_p%d", chunk.Document, chunk.PageNumber)
-		if seen[parentKey] {
-			continue // already have a chunk from this page
+		switch {
+		case chunk.IsSummary():
+			parentKey = chunk.ID // a summary never stands in for page text
+		case bySection && chunk.Section != "":
+			parentKey = chunk.Document + "_s_" + chunk.Section
+		}
+		switch {
+		case len(ex.Results) >= topK:
+			c.Status = "below_cutoff"
+		case seen[parentKey] != "":
+			c.Status = "deduplicated" // already have a chunk from this page
+			c.DuplicateOf = seen[parentKey]
+		default:
+			c.Status = "selected"
+			seen[parentKey] = chunk.ID
+			res := Result{
+				ChunkID:    chunk.ID,
+				Document:   chunk.Document,
+				PageNumber: chunk.PageNumber,
+				Text:       chunk.Text,
+				ParentText: chunk.ParentText,
+				Section:    chunk.Section,
+				Score:      f.score,
+
+				OCRConfidence: chunk.OCRConfidence,
+			}
+			switch {
+			case chunk.IsSummary():
+				res.Level, res.PageStart, res.PageEnd = chunk.Level, chunk.PageNumber, chunk.PageEnd
+			case bySection && chunk.Section != "":
+				if text, start, end := sectionText(r.Chunks, chunk.Document, chunk.Section, chunk.PageNumber); end > start {
+					res.ParentText, res.PageStart, res.PageEnd = text, start, end
+				}
+			}
+			ex.Results = append(ex.Results, res)
 		}
-		seen[parentKey] = true
+		ex.Candidates = append(ex.Candidates, c)
+		candidateIdx[c.ChunkID] = len(ex.Candidates) - 1
+	}
 
-		results = append(results, Result{
-			ChunkID:    chunk.ID,
-			Document:   chunk.Document,
-			PageNumber: chunk.PageNumber,
-			Text:       chunk.Text,
-			ParentText: chunk.ParentText,
-			Section:    chunk.Section,
-			Score:      f.score,
-		})
+	if find != "" {
+		needle := strings.ToLower(find)
+		for _, chunk := range r.Chunks {
+			if !strings.Contains(strings.ToLower(chunk.Text), needle) {
+				continue
+			}
+			var t Candidate
+			if i, ok := candidateIdx[chunk.ID]; ok {
+				t = ex.Candidates[i]
+			} else {
+				t = Candidate{
+					ChunkID:     chunk.ID,
+					Document:    chunk.Document,
+					PageNumber:  chunk.PageNumber,
+					Section:     chunk.Section,
+					Text:        chunk.Text,
+					VectorScore: cosine[chunk.ID],
+					Status:      "not_candidate",
+				}
+			}
+			// Report the rank over all chunks, not just the candidate window
+			t.VectorRank = fullVectorRank[chunk.ID]
+			ex.Targets = append(ex.Targets, t)
+		}
 	}
 
-	return results, nil
+	return ex, nil
+}
+
+// ocrWeight is what c's fused score is multiplied by: its OCR confidence
+// over MinOCRConfidence if it falls short of it, so a page OCR garbled
+// ranks behind the same words read cleanly; otherwise 1, as for chunks
+// with no confidence.
+func (r *Retriever) ocrWeight(c indexer.Chunk) float64 {
+	if c.OCRConfidence <= 0 || c.OCRConfidence >= r.MinOCRConfidence {
+		return 1
+	}
+	return c.OCRConfidence / r.MinOCRConfidence
+}
+
+// exactWeight is how much more an exact identifier match counts in fusion
+// than a rank from the vector or BM25 search: a query naming Section
+// 14.2(b) is after that section, however many chunks read like it.
+const exactWeight = 2.0
+
+// exactSearch ranks the chunks that cite the identifiers in text (see
+// indexer.Identifiers) verbatim, up to size of them, best first; nil if it
+// has none. The chunks must pass f, as allowedIDs does.
+func (r *Retriever) exactSearch(text string, f Filters, allowedIDs []string, size int) (map[string]int, error) {
+	ids := indexer.Identifiers(text)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	terms := make([]query.Query, len(ids))
+	for i, id := range ids {
+		q := bleve.NewTermQuery(id)
+		q.SetField(indexer.IdentifiersField)
+		terms[i] = q
+	}
+	var match query.Query = bleve.NewDisjunctionQuery(terms...)
+	if !f.IsZero() {
+		match = bleve.NewConjunctionQuery(match, bleve.NewDocIDQuery(allowedIDs))
+	}
+	req := bleve.NewSearchRequest(match)
+	req.Size = size
+	res, err := r.BM25Index.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("identifier search error: %w", err)
+	}
+	ranks := make(map[string]int, len(res.Hits))
+	for rank, hit := range res.Hits {
+		ranks[hit.ID] = rank + 1
+	}
+	return ranks, nil
 }
 
 func cosineSimilarity(a, b []float32) float64 {
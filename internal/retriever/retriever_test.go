@@ -1,8 +1,19 @@
 package retriever
 
 import (
+	"context"
+	"fmt"
 	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+
+	"gocognigo/internal/indexer"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
 )
 
 // ========== cosineSimilarity ==========
@@ -88,3 +99,547 @@ func TestNewRetriever_NilIndex(t *testing.T) {
 	}()
 	NewRetriever(nil)
 }
+
+// ========== Explain ==========
+
+// fixedEmbedder returns the same vector for every text.
+type fixedEmbedder []float32
+
+func (e fixedEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i := range texts {
+		out[i] = append([]float32(nil), e...) // indexing normalizes it in place
+	}
+	return out, nil
+}
+func (fixedEmbedder) BatchSize() int      { return 1 }
+func (fixedEmbedder) MaxConcurrency() int { return 1 }
+
+func TestExplain_DedupAndTargets(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "a1", Document: "a.pdf", PageNumber: 1, Text: "termination clause notice", Embedding: []float32{1, 0}},
+		{ID: "a2", Document: "a.pdf", PageNumber: 1, Text: "termination fees payable on early exit", Embedding: []float32{0.9, 0.1}},
+		{ID: "b1", Document: "b.pdf", PageNumber: 4, Text: "clause 14.2 governing law", Embedding: []float32{0, 1}},
+	}
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]string{"text": c.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	ex, err := r.Explain(context.Background(), "termination", 2, "14.2")
+	if err != nil {
+		t.Fatalf("Explain: %v", err)
+	}
+	if len(ex.Results) != 2 || ex.Results[0].ChunkID != "a1" || ex.Results[1].ChunkID != "b1" {
+		t.Fatalf("results = %+v, want a1 then b1", ex.Results)
+	}
+
+	status := map[string]Candidate{}
+	for _, c := range ex.Candidates {
+		status[c.ChunkID] = c
+	}
+	if c := status["a2"]; c.Status != "deduplicated" || c.DuplicateOf != "a1" {
+		t.Errorf("a2 = %q (dup of %q), want deduplicated by a1", c.Status, c.DuplicateOf)
+	}
+	if status["a1"].BM25Rank == 0 || status["a1"].VectorRank != 1 {
+		t.Errorf("a1 ranks = vector %d, bm25 %d", status["a1"].VectorRank, status["a1"].BM25Rank)
+	}
+
+	if len(ex.Targets) != 1 || ex.Targets[0].ChunkID != "b1" || ex.Targets[0].VectorRank != 3 {
+		t.Errorf("targets = %+v, want b1 at vector rank 3", ex.Targets)
+	}
+
+	// Past topK, remaining candidates are cut off rather than deduplicated
+	res, err := r.Search(context.Background(), "termination", 1)
+	if err != nil || len(res) != 1 || res[0].ChunkID != "a1" {
+		t.Errorf("Search = %+v, %v", res, err)
+	}
+}
+
+func TestSearchDocument(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "a1", Document: "a.pdf", PageNumber: 1, Text: "termination on notice", Embedding: []float32{1, 0}},
+		{ID: "b1", Document: "b.pdf", PageNumber: 2, Text: "termination for breach", Embedding: []float32{1, 0}},
+		{ID: "b2", Document: "b.pdf", PageNumber: 5, Text: "governing law", Embedding: []float32{0, 1}},
+	}
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]string{"text": c.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	res, err := r.SearchDocument(context.Background(), "termination", "b.pdf", 5)
+	if err != nil {
+		t.Fatalf("SearchDocument: %v", err)
+	}
+	if len(res) != 2 || res[0].ChunkID != "b1" {
+		t.Fatalf("results = %+v, want b1 first and only b.pdf", res)
+	}
+	for _, x := range res {
+		if x.Document != "b.pdf" {
+			t.Errorf("result from %s leaked into a b.pdf search", x.Document)
+		}
+	}
+}
+
+func TestSearchFiltered_Tags(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "a1", Document: "a.pdf", PageNumber: 1, Text: "termination on notice", Embedding: []float32{1, 0}},
+		{ID: "b1", Document: "b.pdf", PageNumber: 1, Text: "termination for breach", Embedding: []float32{1, 0}},
+		{ID: "c1", Document: "c.pdf", PageNumber: 1, Text: "termination by consent", Embedding: []float32{1, 0}},
+	}
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]string{"text": c.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	f := Filters{
+		Tags:         []string{"Contract", "2023"},
+		DocumentTags: map[string][]string{"a.pdf": {"contract"}, "b.pdf": {"contract", "2023"}, "c.pdf": {"2023"}},
+	}
+	res, err := r.SearchFiltered(context.Background(), "termination", 5, f)
+	if err != nil {
+		t.Fatalf("SearchFiltered: %v", err)
+	}
+	if len(res) != 1 || res[0].Document != "b.pdf" {
+		t.Errorf("results = %+v, want only b.pdf", res)
+	}
+
+	if res, _ := r.SearchFiltered(context.Background(), "termination", 5, Filters{Tags: []string{"board-minutes"}, DocumentTags: f.DocumentTags}); len(res) != 0 {
+		t.Errorf("no document has the tag, got %+v", res)
+	}
+}
+
+func TestSearchSections_ReturnsWholeSection(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "a1", Document: "a.pdf", PageNumber: 1, Section: "Recitals", Text: "whereas the parties", ParentText: "Page one recitals.", Embedding: []float32{0, 1}},
+		{ID: "a2", Document: "a.pdf", PageNumber: 2, Section: "Termination", Text: "termination on notice", ParentText: "Page two termination.", Embedding: []float32{1, 0}},
+		{ID: "a3", Document: "a.pdf", PageNumber: 3, Section: "Termination", Text: "termination for breach", ParentText: "Page three termination.", Embedding: []float32{1, 0}},
+		{ID: "a4", Document: "a.pdf", PageNumber: 4, Section: "Termination", Text: "effects of termination", ParentText: "Page four termination.", Embedding: []float32{1, 0}},
+	}
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]string{"text": c.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	res, err := r.SearchSections(context.Background(), "termination", 5, Filters{})
+	if err != nil {
+		t.Fatalf("SearchSections: %v", err)
+	}
+	var term []Result
+	for _, x := range res {
+		if x.Section == "Termination" {
+			term = append(term, x)
+		}
+	}
+	if len(term) != 1 {
+		t.Fatalf("want the Termination section once, got %+v", res)
+	}
+	if term[0].PageStart != 2 || term[0].PageEnd != 4 {
+		t.Errorf("pages %d-%d, want 2-4", term[0].PageStart, term[0].PageEnd)
+	}
+	for _, want := range []string{"[Page 2]", "Page three termination.", "[Page 4]"} {
+		if !strings.Contains(term[0].ParentText, want) {
+			t.Errorf("section text %q lacks %q", term[0].ParentText, want)
+		}
+	}
+}
+
+func TestSearch_SummaryNodeKeptBesidePageChunk(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "a_p1_c0", Document: "a.pdf", PageNumber: 1, Text: "indemnity cap", ParentText: "Page one.", Embedding: []float32{1, 0}},
+		{ID: "a.pdf_doc", Document: "a.pdf", PageNumber: 1, PageEnd: 9, Level: indexer.LevelDocument, Text: "Summary of a.pdf: indemnity terms", Embedding: []float32{1, 0}},
+	}
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]string{"text": c.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	res, err := r.Search(context.Background(), "indemnity", 5)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("want the page chunk and the summary node, got %+v", res)
+	}
+	for _, x := range res {
+		if x.ChunkID == "a.pdf_doc" && (x.Level != indexer.LevelDocument || x.PageStart != 1 || x.PageEnd != 9) {
+			t.Errorf("summary node result = %+v", x)
+		}
+	}
+}
+
+func TestSearch_ExactIdentifierRanksFirst(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "b_p1_c0", Document: "b.pdf", PageNumber: 1, Text: "Section 14.2 sets the notice period; Section 2(b) the notice address", Embedding: []float32{1, 0}},
+		{ID: "c_p1_c0", Document: "c.pdf", PageNumber: 1, Text: "Section 14 notice", Embedding: []float32{1, 0}},
+		{ID: "a_p3_c0", Document: "a.pdf", PageNumber: 3, Text: "Liability is capped under 14.2(b).", Embedding: []float32{0, 1}},
+	}
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping.AddFieldMappingsAt(indexer.IdentifiersField, bleve.NewKeywordFieldMapping())
+	bm, err := bleve.NewMemOnly(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]interface{}{"text": c.Text, indexer.IdentifiersField: indexer.Identifiers(c.Text)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	ex, err := r.Explain(context.Background(), "Section 14.2(b) notice", 3, "")
+	if err != nil {
+		t.Fatalf("Explain: %v", err)
+	}
+	if len(ex.Results) != 3 || ex.Results[0].ChunkID != "a_p3_c0" {
+		t.Fatalf("results = %+v, want a_p3_c0 first", ex.Results)
+	}
+	for _, c := range ex.Candidates {
+		if want := c.ChunkID == "a_p3_c0"; (c.ExactRank == 1) != want || (c.ExactRank == 0) == want {
+			t.Errorf("%s: exact rank %d", c.ChunkID, c.ExactRank)
+		}
+	}
+}
+
+func TestSearch_FuzzinessMatchesMisreadTerms(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "a_p1_c0", Document: "a.pdf", PageNumber: 1, Text: "Ievenue grew by ten percent", Embedding: []float32{0, 1}},
+		{ID: "b_p1_c0", Document: "b.pdf", PageNumber: 1, Text: "costs fell", Embedding: []float32{1, 0}},
+	}
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]string{"text": c.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	for fuzziness, want := range []int{0, 1} {
+		r.Fuzziness = fuzziness
+		ex, err := r.Explain(context.Background(), "revenue", 2, "")
+		if err != nil {
+			t.Fatalf("Explain: %v", err)
+		}
+		for _, c := range ex.Candidates {
+			if c.ChunkID == "a_p1_c0" && c.BM25Rank != want {
+				t.Errorf("fuzziness %d: BM25 rank %d, want %d", fuzziness, c.BM25Rank, want)
+			}
+		}
+	}
+}
+
+func TestSearch_LowOCRConfidenceRanksLower(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "a_p1_c0", Document: "a.pdf", PageNumber: 1, Text: "revenue grew", Embedding: []float32{1, 0}, OCRConfidence: 0.3},
+		{ID: "b_p1_c0", Document: "b.pdf", PageNumber: 1, Text: "revenue grew", Embedding: []float32{0, 1}, OCRConfidence: 0.9},
+	}
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]string{"text": c.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	for _, tc := range []struct {
+		min   float64
+		first string
+	}{{0, "a_p1_c0"}, {0.6, "b_p1_c0"}} {
+		r.MinOCRConfidence = tc.min
+		results, err := r.Search(context.Background(), "revenue", 2)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(results) != 2 || results[0].ChunkID != tc.first {
+			t.Fatalf("min %.1f: results %+v, want %s first", tc.min, results, tc.first)
+		}
+		if want := map[string]float64{"a_p1_c0": 0.3, "b_p1_c0": 0.9}[tc.first]; results[0].OCRConfidence != want {
+			t.Errorf("min %.1f: OCR confidence %v, want %v", tc.min, results[0].OCRConfidence, want)
+		}
+	}
+}
+
+func TestExplain_TiesBrokenByVectorRank(t *testing.T) {
+	// b1 is first by vector and second by BM25, a1 the reverse, so their
+	// fused scores tie
+	chunks := []indexer.Chunk{
+		{ID: "a1", Document: "a.pdf", PageNumber: 1, Text: "revenue revenue revenue", Embedding: []float32{0.9, 0.1}},
+		{ID: "b1", Document: "b.pdf", PageNumber: 1, Text: "revenue grew slowly over the whole of the quarter", Embedding: []float32{1, 0}},
+	}
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]string{"text": c.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	for range 20 {
+		ex, err := r.Explain(context.Background(), "revenue", 2, "")
+		if err != nil {
+			t.Fatalf("Explain: %v", err)
+		}
+		if len(ex.Candidates) != 2 {
+			t.Fatalf("candidates = %+v, want 2", ex.Candidates)
+		}
+		first, second := ex.Candidates[0], ex.Candidates[1]
+		if first.FusedScore != second.FusedScore {
+			t.Fatalf("fused scores %v and %v don't tie", first.FusedScore, second.FusedScore)
+		}
+		if first.ChunkID != "b1" || second.ChunkID != "a1" {
+			t.Fatalf("order = %s, %s, want b1 (better vector rank) first", first.ChunkID, second.ChunkID)
+		}
+	}
+}
+
+func TestParsePhrases(t *testing.T) {
+	phrases, rest := ParsePhrases(`Where does it say "the tenant shall  vacate" or “notice of termination”~4 or ""?`)
+	want := []Phrase{{Text: "the tenant shall vacate"}, {Text: "notice of termination", Slop: 4}}
+	if !reflect.DeepEqual(phrases, want) {
+		t.Errorf("phrases = %+v, want %+v", phrases, want)
+	}
+	if rest != `Where does it say "the tenant shall  vacate" or "notice of termination" or ""?` {
+		t.Errorf("rest = %q", rest)
+	}
+	if phrases, rest := ParsePhrases("no quotes ~3"); phrases != nil || rest != "no quotes ~3" {
+		t.Errorf("ParsePhrases = %+v, %q", phrases, rest)
+	}
+}
+
+func TestSearch_QuotedPhraseRanksFirst(t *testing.T) {
+	chunks := []indexer.Chunk{
+		{ID: "b_p1_c0", Document: "b.pdf", PageNumber: 1, Text: "The tenant may give notice to the landlord at any time and must then vacate", Embedding: []float32{1, 0}},
+		{ID: "c_p1_c0", Document: "c.pdf", PageNumber: 1, Text: "tenant notice vacate", Embedding: []float32{1, 0}},
+		{ID: "a_p2_c0", Document: "a.pdf", PageNumber: 2, Text: "On expiry the tenant shall vacate the premises with notice", Embedding: []float32{0, 1}},
+		{ID: "d_p1_c0", Document: "d.pdf", PageNumber: 1, Text: "Vacate by the date the tenant was given in writing", Embedding: []float32{0, 1}},
+	}
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if err := bm.Index(c.ID, map[string]string{"text": c.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Retriever{Chunks: chunks, BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+
+	ranks := func(question string) (map[string]int, string) {
+		t.Helper()
+		ex, err := r.Explain(context.Background(), question, 4, "")
+		if err != nil {
+			t.Fatalf("Explain: %v", err)
+		}
+		got := map[string]int{}
+		for _, c := range ex.Candidates {
+			got[c.ChunkID] = c.PhraseRank
+		}
+		return got, ex.Results[0].ChunkID
+	}
+
+	got, first := ranks(`notice "tenant shall vacate"`)
+	if got["a_p2_c0"] != 1 || got["b_p1_c0"]+got["c_p1_c0"]+got["d_p1_c0"] != 0 {
+		t.Errorf("phrase ranks = %v, want only a_p2_c0", got)
+	}
+	if first != "a_p2_c0" {
+		t.Errorf("first result = %s, want the chunk quoted", first)
+	}
+	// Within 2 words of each other, in any order
+	got, _ = ranks(`notice "vacate tenant"~2`)
+	if got["a_p2_c0"] == 0 || got["c_p1_c0"] == 0 || got["b_p1_c0"] != 0 || got["d_p1_c0"] != 0 {
+		t.Errorf("proximity ranks = %v, want a_p2_c0 and c_p1_c0 only", got)
+	}
+}
+
+func TestWithin(t *testing.T) {
+	locs := search.TermLocationMap{
+		"tenant": {{Pos: 2}, {Pos: 20}},
+		"vacate": {{Pos: 9}, {Pos: 23}},
+	}
+	terms := []string{"tenant", "vacate"}
+	if !within(locs, terms, 3) || within(locs, terms, 2) {
+		t.Error("want the closest pair, 3 positions apart")
+	}
+	if within(locs, []string{"tenant", "notice"}, 10) {
+		t.Error("a missing term can't be within reach")
+	}
+}
+
+func TestVectorScan_ShardsMatchSingleScan(t *testing.T) {
+	n := 3*minChunksPerWorker + 17
+	chunks := make([]indexer.Chunk, n)
+	for i := range chunks {
+		chunks[i] = indexer.Chunk{
+			ID:           fmt.Sprintf("c%d", i),
+			Document:     fmt.Sprintf("doc%d.pdf", i%3),
+			Embedding:    indexer.Normalize([]float32{float32(i % 7), float32(i % 5), 1}),
+			Multilingual: i%11 == 0,
+		}
+	}
+	f := Filters{Documents: []string{"doc0.pdf", "doc2.pdf"}}
+	query, multi := []float32{1, 2, 3}, []float32{3, 2, 1}
+
+	scores, allowed := vectorScan(chunks, query, multi, f)
+	var wantScores []scored
+	var wantAllowed []string
+	for i, c := range chunks {
+		if !f.Allows(c.Document) {
+			continue
+		}
+		wantAllowed = append(wantAllowed, c.ID)
+		emb := query
+		if c.Multilingual {
+			emb = multi
+		}
+		wantScores = append(wantScores, scored{i, cosineSimilarity(emb, c.Embedding)})
+	}
+	if len(scores) != len(wantScores) {
+		t.Fatalf("sharded scan returned %d scores, want %d", len(scores), len(wantScores))
+	}
+	for i, s := range scores {
+		// The dot product of unit vectors is their cosine similarity
+		if want := wantScores[i]; s.idx != want.idx || math.Abs(s.score-want.score) > 1e-6 {
+			t.Fatalf("score %d = %+v, want %+v", i, s, want)
+		}
+	}
+	if !reflect.DeepEqual(allowed, wantAllowed) {
+		t.Errorf("allowed IDs: got %d, want %d in chunk order", len(allowed), len(wantAllowed))
+	}
+
+	// Without a multilingual query embedding those chunks are left to BM25
+	scores, _ = vectorScan(chunks, query, nil, Filters{})
+	for _, s := range scores {
+		if chunks[s.idx].Multilingual {
+			t.Fatalf("chunk %s scored without a multilingual query embedding", chunks[s.idx].ID)
+		}
+	}
+}
+
+func TestDot_KernelMatchesGeneric(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 3, 7, 8, 9, 15, 16, 17, 31, 32, 33, 63, 100, 384, 1536, 3072} {
+		a, b := make([]float32, n), make([]float32, n)
+		for i := range a {
+			a[i], b[i] = rng.Float32()*2-1, rng.Float32()*2-1
+		}
+		indexer.Normalize(a)
+		indexer.Normalize(b)
+		if got, want := dot(a, b), dotGeneric(a, b); math.Abs(got-want) > 1e-5 {
+			t.Errorf("n=%d: dot = %v, want %v", n, got, want)
+		}
+	}
+	if got := dot([]float32{1, 0}, []float32{1, 0, 0}); got != 0 {
+		t.Errorf("dot of mismatched dimensions = %v, want 0", got)
+	}
+	if got := dot(nil, nil); got != 0 {
+		t.Errorf("dot of empty vectors = %v, want 0", got)
+	}
+}
+
+func TestTopScored_MatchesFullSort(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	scores := make([]scored, 5000)
+	for i := range scores {
+		scores[i] = scored{i, float64(rng.Intn(500)) / 500} // plenty of ties
+	}
+	sorted := append([]scored(nil), scores...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].better(sorted[j]) })
+
+	for _, k := range []int{0, 1, 15, 30, 5000, 6000} {
+		want := sorted[:min(k, len(sorted))]
+		if got := topScored(scores, k); len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("k=%d: got %d scores, not the first %d of a full sort", k, len(got), len(want))
+		}
+	}
+}
+
+func TestRetriever_SearchesWhileIndexIsAppendedTo(t *testing.T) {
+	bm, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &indexer.Index{BM25Index: bm, Embedder: fixedEmbedder{1, 0}}
+	batch := func(n int) []indexer.Chunk {
+		var chunks []indexer.Chunk
+		for i := 0; i < 10; i++ {
+			chunks = append(chunks, indexer.Chunk{ID: fmt.Sprintf("b%d_c%d", n, i), Document: fmt.Sprintf("doc%d.pdf", n), PageNumber: i + 1, Text: "lease renewal terms"})
+		}
+		return chunks
+	}
+	if err := idx.EmbedAndIndex(context.Background(), batch(0), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 1; n <= 20; n++ {
+			if err := idx.EmbedAndIndex(context.Background(), batch(n), nil, 0); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for searching := true; searching; {
+		select {
+		case <-done:
+			searching = false
+		default:
+		}
+		r := NewRetriever(idx)
+		before := len(r.Chunks)
+		if _, err := r.Search(context.Background(), "renewal", 5); err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		// A view keeps its own length and copies when appended to
+		_ = append(r.Chunks, indexer.Chunk{ID: "scratch"})
+		if len(r.Chunks) != before {
+			t.Fatalf("retriever's chunks changed from %d to %d under it", before, len(r.Chunks))
+		}
+	}
+	if r := NewRetriever(idx); len(r.Chunks) != 210 {
+		t.Errorf("retriever built after ingestion has %d chunks, want 210", len(r.Chunks))
+	}
+	if got := idx.ChunkCount(); got != 210 {
+		t.Errorf("ChunkCount = %d, want 210", got)
+	}
+}
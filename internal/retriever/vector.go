@@ -0,0 +1,159 @@
+package retriever
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+
+	"gocognigo/internal/indexer"
+)
+
+// ========== Vector Scan ==========
+
+// scored is a chunk's cosine similarity to the query, by index in Chunks.
+type scored struct {
+	idx   int
+	score float64
+}
+
+// better orders scores best first, ties by chunk order.
+func (s scored) better(o scored) bool {
+	if s.score != o.score {
+		return s.score > o.score
+	}
+	return s.idx < o.idx
+}
+
+// scoreHeap is a min-heap, its worst score at the root.
+type scoreHeap []scored
+
+func (h scoreHeap) Len() int           { return len(h) }
+func (h scoreHeap) Less(i, j int) bool { return h[j].better(h[i]) }
+func (h scoreHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scoreHeap) Push(x any)        { *h = append(*h, x.(scored)) }
+func (h *scoreHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// topScored returns the k best of scores, best first, keeping a heap of k
+// rather than sorting them all: a query wants a few dozen candidates out of
+// what can be hundreds of thousands of chunks.
+func topScored(scores []scored, k int) []scored {
+	if k <= 0 {
+		return nil
+	}
+	h := make(scoreHeap, 0, k)
+	for _, s := range scores {
+		switch {
+		case len(h) < k:
+			heap.Push(&h, s)
+		case s.better(h[0]):
+			h[0] = s
+			heap.Fix(&h, 0)
+		}
+	}
+	top := make([]scored, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		top[i] = heap.Pop(&h).(scored)
+	}
+	return top
+}
+
+// minChunksPerWorker keeps small projects on one goroutine, where starting
+// workers would cost more than the scan.
+const minChunksPerWorker = 4096
+
+// vectorScan scores the chunks that pass f against the query, splitting
+// them across up to GOMAXPROCS goroutines. queryEmb scores ordinary chunks
+// and multiEmb those with Multilingual set, which are skipped if it is nil.
+// Scores come back in chunk order, as do the IDs of the chunks allowed by a
+// non-zero f.
+//
+// Chunk embeddings are stored unit length, so once the query is normalized
+// too each score is a plain dot product.
+func vectorScan(chunks []indexer.Chunk, queryEmb, multiEmb []float32, f Filters) (scores []scored, allowedIDs []string) {
+	queryEmb = unit(queryEmb)
+	if multiEmb != nil {
+		multiEmb = unit(multiEmb)
+	}
+	type shard struct {
+		scores  []scored
+		allowed []string
+	}
+	workers := min(runtime.GOMAXPROCS(0), len(chunks)/minChunksPerWorker)
+	if workers < 1 {
+		workers = 1
+	}
+	shards := make([]shard, workers)
+	per := (len(chunks) + workers - 1) / workers
+
+	scan := func(s *shard, start, end int) {
+		s.scores = make([]scored, 0, end-start)
+		for i := start; i < end; i++ {
+			chunk := &chunks[i]
+			if !f.IsZero() {
+				if !f.Allows(chunk.Document) {
+					continue
+				}
+				s.allowed = append(s.allowed, chunk.ID)
+			}
+			emb := queryEmb
+			if chunk.Multilingual {
+				if multiEmb == nil {
+					continue // its vectors are from a model we can't embed with; BM25 still finds it
+				}
+				emb = multiEmb
+			}
+			s.scores = append(s.scores, scored{i, dot(emb, chunk.Embedding)})
+		}
+	}
+
+	if workers == 1 {
+		scan(&shards[0], 0, len(chunks))
+		return shards[0].scores, shards[0].allowed
+	}
+	var wg sync.WaitGroup
+	for w := range shards {
+		start, end := w*per, min((w+1)*per, len(chunks))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scan(&shards[w], start, end)
+		}()
+	}
+	wg.Wait()
+
+	n, nAllowed := 0, 0
+	for _, s := range shards {
+		n += len(s.scores)
+		nAllowed += len(s.allowed)
+	}
+	scores = make([]scored, 0, n)
+	if nAllowed > 0 {
+		allowedIDs = make([]string, 0, nAllowed)
+	}
+	for _, s := range shards {
+		scores = append(scores, s.scores...)
+		allowedIDs = append(allowedIDs, s.allowed...)
+	}
+	return scores, allowedIDs
+}
+
+// needsMultilingual reports whether any chunk that passes f was embedded
+// with the multilingual model, so the query must be embedded with it too.
+func needsMultilingual(chunks []indexer.Chunk, f Filters) bool {
+	for i := range chunks {
+		if chunks[i].Multilingual && (f.IsZero() || f.Allows(chunks[i].Document)) {
+			return true
+		}
+	}
+	return false
+}
+
+// unit returns a unit-length copy of v, leaving the embedder's slice alone.
+func unit(v []float32) []float32 {
+	return indexer.Normalize(append([]float32(nil), v...))
+}
@@ -0,0 +1,376 @@
+// Package s3 is a minimal client for S3-compatible object storage (AWS S3,
+// MinIO and the like): reading, writing, listing and deleting objects with
+// requests signed with Signature Version 4, and pre-signed URLs that let a
+// browser read or write one object without the credentials. It covers
+// what the server needs, not the rest of the API.
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocognigo/internal/httpclient"
+)
+
+// Client works on the objects of one bucket.
+type Client struct {
+	Endpoint  string // e.g. "https://s3.eu-west-1.amazonaws.com" or "http://minio:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// PathStyle puts the bucket in the URL's path rather than its host, as
+	// MinIO and most self-hosted stores need.
+	PathStyle bool
+
+	HTTP *http.Client // nil uses httpclient.Default
+}
+
+// Environment variables FromEnv reads. S3_ENDPOINT defaults to AWS's
+// endpoint for the region, and S3_REGION to us-east-1.
+const (
+	EndpointEnv  = "S3_ENDPOINT"
+	RegionEnv    = "S3_REGION"
+	AccessKeyEnv = "S3_ACCESS_KEY_ID"
+	SecretKeyEnv = "S3_SECRET_ACCESS_KEY"
+	PathStyleEnv = "S3_PATH_STYLE"
+)
+
+// FromEnv returns a client for bucket configured from the environment, or
+// nil if bucket is "".
+func FromEnv(bucket string) *Client {
+	if bucket == "" {
+		return nil
+	}
+	c := &Client{
+		Endpoint:  strings.TrimRight(os.Getenv(EndpointEnv), "/"),
+		Region:    os.Getenv(RegionEnv),
+		Bucket:    bucket,
+		AccessKey: os.Getenv(AccessKeyEnv),
+		SecretKey: os.Getenv(SecretKeyEnv),
+	}
+	c.PathStyle, _ = strconv.ParseBool(os.Getenv(PathStyleEnv))
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	return c
+}
+
+// ErrNotFound is returned for an object the bucket doesn't have.
+var ErrNotFound = errors.New("s3: object not found")
+
+// Error is an error response from the store.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s (HTTP %d)", e.Code, e.Message, e.StatusCode)
+}
+
+// PresignPut returns a URL that uploads key with a PUT of its body until
+// expires has passed.
+func (c *Client) PresignPut(key string, expires time.Duration) (string, error) {
+	return c.presign(http.MethodPut, key, expires, time.Now())
+}
+
+// PresignGet returns a URL that downloads key until expires has passed.
+func (c *Client) PresignGet(key string, expires time.Duration) (string, error) {
+	return c.presign(http.MethodGet, key, expires, time.Now())
+}
+
+// Put uploads size bytes read from body as key, replacing any object there.
+func (c *Client) Put(key string, body io.Reader, size int64) error {
+	resp, err := c.do(http.MethodPut, key, nil, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get opens key for reading. The caller closes the body; size is the
+// object's length.
+func (c *Client) Get(key string) (body io.ReadCloser, size int64, err error) {
+	resp, err := c.do(http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Head returns key's length.
+func (c *Client) Head(key string) (int64, error) {
+	resp, err := c.do(http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// Delete removes key. Deleting a missing object is not an error.
+func (c *Client) Delete(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, nil, 0)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Object is an object as listed.
+type Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+}
+
+// List returns every object whose key starts with prefix, in key order.
+func (c *Client) List(prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := c.do(http.MethodGet, "", q, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents              []Object `xml:"Contents"`
+			IsTruncated           bool     `xml:"IsTruncated"`
+			NextContinuationToken string   `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: parse listing: %w", err)
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for key ("" for the bucket) with query and,
+// for a PUT, size bytes of body. Responses other than 2xx are returned as
+// errors, with the body closed.
+func (c *Client) do(method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u, err := c.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := emptyHash
+	if body != nil {
+		// The body streams unhashed; TLS protects it in transit
+		req.ContentLength = size
+		payloadHash = unsignedPayload
+	}
+	c.sign(req, payloadHash, time.Now())
+
+	client := c.HTTP
+	if client == nil {
+		client = httpclient.Default
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	e := &Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	_ = xml.Unmarshal(data, e)
+	return nil, e
+}
+
+// objectURL is key's URL, virtual-hosted or path-style.
+func (c *Client) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", c.Endpoint)
+	}
+	key = strings.TrimLeft(key, "/")
+	switch {
+	case c.PathStyle && key == "":
+		u.Path = "/" + c.Bucket
+	case c.PathStyle:
+		u.Path = "/" + c.Bucket + "/" + key
+	default:
+		u.Host = c.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = encodePath(u.Path)
+	return u, nil
+}
+
+// ========== Signature Version 4 ==========
+
+const (
+	algorithm       = "AWS4-HMAC-SHA256"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	timeFormat      = "20060102T150405Z"
+)
+
+// emptyHash is the SHA-256 of an empty body.
+var emptyHash = hashHex(nil)
+
+// sign adds the headers authorizing req, whose body hashes to payloadHash.
+func (c *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	stamp := now.UTC().Format(timeFormat)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signed = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + stamp + "\n",
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := c.scope(stamp)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, c.AccessKey, scope, signed, c.signature(stamp, scope, canonical)))
+}
+
+// presign returns key's URL with the query parameters authorizing method
+// on it from now until expires has passed.
+func (c *Client) presign(method, key string, expires time.Duration, now time.Time) (string, error) {
+	if expires < time.Second || expires > 7*24*time.Hour {
+		return "", fmt.Errorf("s3: pre-signed URLs expire after 1s to 7 days, not %s", expires)
+	}
+	u, err := c.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	stamp := now.UTC().Format(timeFormat)
+	scope := c.scope(stamp)
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", algorithm)
+	q.Set("X-Amz-Credential", c.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", stamp)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+
+	query := canonicalQuery(q)
+	canonical := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		query,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	u.RawQuery = query + "&X-Amz-Signature=" + c.signature(stamp, scope, canonical)
+	return u.String(), nil
+}
+
+// scope is the credential scope of a request made at stamp.
+func (c *Client) scope(stamp string) string {
+	return stamp[:8] + "/" + c.Region + "/s3/aws4_request"
+}
+
+// signature signs the canonical request made at stamp.
+func (c *Client) signature(stamp, scope, canonical string) string {
+	toSign := algorithm + "\n" + stamp + "\n" + scope + "\n" + hashHex([]byte(canonical))
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), stamp[:8])
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalQuery encodes q sorted by name, as signing requires.
+func canonicalQuery(q url.Values) string {
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), q[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// encodePath URI-encodes an object path, keeping its slashes.
+func encodePath(path string) string {
+	return uriEncode(path, false)
+}
+
+// uriEncode percent-encodes every byte but the unreserved characters, and
+// slashes too if encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
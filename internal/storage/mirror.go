@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========== Mirror ==========
+
+// Mirror keeps a local directory and a Storage in step. The directory is
+// the working copy the server uses; Pull brings it what the storage holds,
+// and Push sends the storage what changed in it since. Only one process
+// may mirror a storage at a time.
+type Mirror struct {
+	Dir   string
+	Store Storage
+
+	// Skip names the files never mirrored, e.g. locks and temp files, by
+	// key.
+	Skip func(key string) bool
+
+	pushMu sync.Mutex // one push at a time
+
+	mu     sync.Mutex
+	synced map[string]fileState // by key: the local file as last pulled or pushed
+}
+
+// fileState identifies a version of a local file, as cheaply as a stat.
+type fileState struct {
+	size int64
+	mod  time.Time
+}
+
+// NewMirror returns a mirror of dir in store.
+func NewMirror(dir string, store Storage, skip func(key string) bool) *Mirror {
+	if skip == nil {
+		skip = func(string) bool { return false }
+	}
+	return &Mirror{Dir: dir, Store: store, Skip: skip, synced: make(map[string]fileState)}
+}
+
+func (m *Mirror) localPath(key string) string {
+	return filepath.Join(m.Dir, filepath.FromSlash(key))
+}
+
+// Key returns the key of path, a file or directory in the working copy.
+func (m *Mirror) Key(path string) (string, error) {
+	rel, err := filepath.Rel(m.Dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in %s", path, m.Dir)
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Pull copies into the working copy the stored files under prefix (other
+// than those exclude names) that it lacks. A local file the mirror hasn't
+// synced yet is replaced if it differs from the stored one; one it has
+// synced is left alone, changed or deleted, as the working copy is the
+// newer. It returns the files copied.
+func (m *Mirror) Pull(prefix string, exclude func(key string) bool) (int, error) {
+	objects, err := m.Store.List(prefix)
+	if err != nil {
+		return 0, fmt.Errorf("list %s: %w", m.Store, err)
+	}
+	pulled := 0
+	for _, o := range objects {
+		if m.Skip(o.Key) || (exclude != nil && exclude(o.Key)) {
+			continue
+		}
+		m.mu.Lock()
+		_, known := m.synced[o.Key]
+		m.mu.Unlock()
+		if known {
+			continue // changed or deleted here since, if at all
+		}
+		path := m.localPath(o.Key)
+		if info, err := os.Stat(path); err == nil && sameContent(path, info, o) {
+			m.record(o.Key, info)
+			continue
+		}
+		if err := m.download(o.Key, path); err != nil {
+			return pulled, err
+		}
+		pulled++
+	}
+	return pulled, nil
+}
+
+// sameContent reports whether the local file at path holds object o, by
+// MD5 when the store knows it and by size otherwise.
+func sameContent(path string, info os.FileInfo, o Object) bool {
+	if info.Size() != o.Size {
+		return false
+	}
+	if o.MD5 == "" {
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == o.MD5
+}
+
+// download copies the stored key to path through a temp file.
+func (m *Mirror) download(key, path string) error {
+	body, err := m.Store.Get(key)
+	if err != nil {
+		return fmt.Errorf("get %s: %w", key, err)
+	}
+	defer body.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("get %s: %w", key, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	m.record(key, info)
+	return nil
+}
+
+func (m *Mirror) record(key string, info os.FileInfo) {
+	m.mu.Lock()
+	m.synced[key] = fileState{size: info.Size(), mod: info.ModTime()}
+	m.mu.Unlock()
+}
+
+// Push stores the files under prefix that changed since they were last
+// pulled or pushed, and deletes the stored copies of synced files since
+// removed. It returns the files stored and deleted; a file that fails is
+// retried on the next push, and the first error is returned.
+func (m *Mirror) Push(prefix string) (stored, deleted int, err error) {
+	m.pushMu.Lock()
+	defer m.pushMu.Unlock()
+
+	seen := map[string]bool{}
+	walkErr := filepath.WalkDir(m.localPath(prefix), func(path string, d fs.DirEntry, werr error) error {
+		if werr != nil {
+			if os.IsNotExist(werr) {
+				return nil // removed while walking
+			}
+			return werr
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		key, kerr := m.Key(path)
+		if kerr != nil || !strings.HasPrefix(key, prefix) || strings.Contains(d.Name(), ".tmp-") || m.Skip(key) {
+			return nil
+		}
+		seen[key] = true
+		info, ierr := d.Info()
+		if ierr != nil {
+			return nil
+		}
+		m.mu.Lock()
+		last, known := m.synced[key]
+		m.mu.Unlock()
+		if known && last.size == info.Size() && last.mod.Equal(info.ModTime()) {
+			return nil
+		}
+		if perr := m.upload(key, path, info); perr != nil {
+			if err == nil {
+				err = perr
+			}
+			return nil
+		}
+		stored++
+		return nil
+	})
+	if walkErr != nil && !os.IsNotExist(walkErr) && err == nil {
+		err = walkErr
+	}
+
+	m.mu.Lock()
+	var gone []string
+	for key := range m.synced {
+		if strings.HasPrefix(key, prefix) && !seen[key] {
+			gone = append(gone, key)
+		}
+	}
+	m.mu.Unlock()
+	for _, key := range gone {
+		if _, serr := os.Stat(m.localPath(key)); serr == nil {
+			continue // created since the walk passed it
+		}
+		if derr := m.Store.Delete(key); derr != nil {
+			if err == nil {
+				err = fmt.Errorf("delete %s: %w", key, derr)
+			}
+			continue
+		}
+		m.mu.Lock()
+		delete(m.synced, key)
+		m.mu.Unlock()
+		deleted++
+	}
+	return stored, deleted, err
+}
+
+// upload stores the file at path as it was when stat'd as info.
+func (m *Mirror) upload(key, path string, info os.FileInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := m.Store.Put(key, io.LimitReader(f, info.Size()), info.Size()); err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	m.record(key, info)
+	return nil
+}
+
+// Remove deletes every stored file under prefix, synced or not, e.g. once a
+// project's directory is deleted. Skipped files are left alone.
+func (m *Mirror) Remove(prefix string) (int, error) {
+	objects, err := m.Store.List(prefix)
+	if err != nil {
+		return 0, fmt.Errorf("list %s: %w", m.Store, err)
+	}
+	removed := 0
+	for _, o := range objects {
+		if m.Skip != nil && m.Skip(o.Key) {
+			continue
+		}
+		if err := m.Store.Delete(o.Key); err != nil {
+			return removed, fmt.Errorf("delete %s: %w", o.Key, err)
+		}
+		m.mu.Lock()
+		delete(m.synced, o.Key)
+		m.mu.Unlock()
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,245 @@
+// Package storage keeps the server's data durable beyond the machine it
+// runs on. A Storage holds files by slash-separated key: Local in a
+// directory, Bucket in an S3-compatible bucket. A Mirror keeps a local
+// working copy of the data directory — which the server and its indexes
+// read and write as files — in step with a Storage, so an instance without
+// a disk of its own starts from what the last one left and its changes
+// outlive it.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocognigo/internal/s3"
+)
+
+// Storage is a durable store of files by key, e.g.
+// "users/u1_projects/projects.json". Get returns an error wrapping
+// fs.ErrNotExist for a key it doesn't hold.
+type Storage interface {
+	Get(key string) (io.ReadCloser, error)
+	Put(key string, r io.Reader, size int64) error
+	Delete(key string) error              // deleting a missing key is not an error
+	List(prefix string) ([]Object, error) // every key starting with prefix
+	String() string                       // where the files are, for logs
+}
+
+// Object is a stored file as listed.
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+	MD5      string // hex MD5 of the content, "" if the store doesn't know it
+}
+
+// Open returns the storage at location: "s3://bucket/prefix" for a bucket
+// (connected with the S3_* environment variables, see s3.FromEnv) or a
+// directory, optionally as "file:///path".
+func Open(location string) (Storage, error) {
+	switch {
+	case location == "":
+		return nil, fmt.Errorf("no storage location")
+	case strings.HasPrefix(location, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("no bucket in %q", location)
+		}
+		return &Bucket{Client: s3.FromEnv(bucket), Prefix: strings.Trim(prefix, "/")}, nil
+	default:
+		return &Local{Root: strings.TrimPrefix(location, "file://")}, nil
+	}
+}
+
+// validKey rejects keys that would leave the store's root.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid storage key %q", key)
+		}
+	}
+	return nil
+}
+
+// ========== Local ==========
+
+// Local keeps files under a directory, e.g. a mounted volume.
+type Local struct {
+	Root string
+}
+
+func (l *Local) String() string { return l.Root }
+
+func (l *Local) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Put writes the file beside its key and renames it into place, so a
+// reader never sees half of it.
+func (l *Local) Put(key string, r io.Reader, size int64) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("wrote %d bytes of %s, expected %d", n, key, size)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) List(prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(l.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == l.Root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, _ := filepath.Rel(l.Root, path)
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			// Skip directories that can't hold a key with the prefix
+			if path != l.Root && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasPrefix(key, prefix) || strings.Contains(d.Name(), ".tmp-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed since listed
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// ========== Bucket ==========
+
+// Bucket keeps files in an S3-compatible bucket, under Prefix.
+type Bucket struct {
+	Client *s3.Client
+	Prefix string // without leading or trailing slashes; "" for the whole bucket
+}
+
+func (b *Bucket) String() string {
+	if b.Prefix == "" {
+		return "s3://" + b.Client.Bucket
+	}
+	return "s3://" + b.Client.Bucket + "/" + b.Prefix
+}
+
+func (b *Bucket) objectKey(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	if b.Prefix == "" {
+		return key, nil
+	}
+	return b.Prefix + "/" + key, nil
+}
+
+func (b *Bucket) Get(key string) (io.ReadCloser, error) {
+	k, err := b.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	body, _, err := b.Client.Get(k)
+	if errors.Is(err, s3.ErrNotFound) {
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return body, err
+}
+
+func (b *Bucket) Put(key string, r io.Reader, size int64) error {
+	k, err := b.objectKey(key)
+	if err != nil {
+		return err
+	}
+	return b.Client.Put(k, r, size)
+}
+
+func (b *Bucket) Delete(key string) error {
+	k, err := b.objectKey(key)
+	if err != nil {
+		return err
+	}
+	return b.Client.Delete(k)
+}
+
+func (b *Bucket) List(prefix string) ([]Object, error) {
+	full := prefix
+	if b.Prefix != "" {
+		full = b.Prefix + "/" + prefix
+	}
+	listed, err := b.Client.List(full)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]Object, 0, len(listed))
+	for _, o := range listed {
+		key := o.Key
+		if b.Prefix != "" {
+			key = strings.TrimPrefix(key, b.Prefix+"/")
+		}
+		// A single-part upload's ETag is its MD5; a multipart one's has a dash
+		md5 := strings.Trim(o.ETag, `"`)
+		if len(md5) != 32 || strings.Contains(md5, "-") {
+			md5 = ""
+		}
+		objects = append(objects, Object{Key: key, Size: o.Size, Modified: o.LastModified, MD5: md5})
+	}
+	return objects, nil
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func put(t *testing.T, st Storage, key, content string) {
+	t.Helper()
+	if err := st.Put(key, strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Put %s: %v", key, err)
+	}
+}
+
+func read(t *testing.T, st Storage, key string) string {
+	t.Helper()
+	body, err := st.Get(key)
+	if err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	return string(data)
+}
+
+func keys(objects []Object) string {
+	var ks []string
+	for _, o := range objects {
+		ks = append(ks, o.Key)
+	}
+	return strings.Join(ks, ",")
+}
+
+// ========== Storage ==========
+
+func TestOpen(t *testing.T) {
+	st, err := Open("s3://bucket/backups/prod/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := st.(*Bucket); !ok || b.Client.Bucket != "bucket" || b.Prefix != "backups/prod" {
+		t.Errorf("Open s3 = %#v", st)
+	}
+	if st, _ := Open("file:///var/lib/gocognigo"); st.String() != "/var/lib/gocognigo" {
+		t.Errorf("Open file = %s", st)
+	}
+	if _, err := Open("s3://"); err == nil {
+		t.Error("expected an error for a location without a bucket")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	st := &Local{Root: t.TempDir()}
+	put(t, st, "users/u1_projects/projects.json", "[]")
+	put(t, st, "users/u1_projects/p1/uploads/a.pdf", "%PDF")
+	put(t, st, "workspaces.json", "[]")
+
+	if got := read(t, st, "users/u1_projects/p1/uploads/a.pdf"); got != "%PDF" {
+		t.Errorf("Get = %q", got)
+	}
+	if _, err := st.Get("missing.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+	for _, bad := range []string{"../escape", "/abs", "a//b", ""} {
+		if err := st.Put(bad, strings.NewReader("x"), 1); err == nil {
+			t.Errorf("Put(%q) should fail", bad)
+		}
+	}
+
+	objs, err := st.List("users/u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(objs); got != "users/u1_projects/p1/uploads/a.pdf,users/u1_projects/projects.json" {
+		t.Errorf("List = %s", got)
+	}
+	if err := st.Delete("workspaces.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Delete("workspaces.json"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+	if objs, _ := st.List(""); len(objs) != 2 {
+		t.Errorf("List after delete = %s", keys(objs))
+	}
+	if objs, err := (&Local{Root: filepath.Join(t.TempDir(), "none")}).List(""); err != nil || len(objs) != 0 {
+		t.Errorf("List of a missing root = %v, %v", objs, err)
+	}
+}
+
+// ========== Mirror ==========
+
+func TestMirror_PullAndPush(t *testing.T) {
+	st := &Local{Root: t.TempDir()}
+	put(t, st, "workspaces.json", "[]")
+	put(t, st, "users/u1_projects/projects.json", `[{"id":"p1"}]`)
+	put(t, st, "users/u1_projects/p1/vectors.json", "{}")
+	put(t, st, ".instance.lock", "pid")
+
+	dir := t.TempDir()
+	m := NewMirror(dir, st, func(key string) bool { return key == ".instance.lock" })
+	inProject := func(key string) bool { return strings.HasPrefix(key, "users/u1_projects/p1/") }
+	if n, err := m.Pull("", inProject); err != nil || n != 2 {
+		t.Fatalf("Pull = %d, %v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users/u1_projects/p1/vectors.json")); err == nil {
+		t.Error("excluded file was pulled")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".instance.lock")); err == nil {
+		t.Error("skipped file was pulled")
+	}
+	if n, err := m.Pull("users/u1_projects/p1/", nil); err != nil || n != 1 {
+		t.Fatalf("Pull project = %d, %v", n, err)
+	}
+
+	// Nothing changed: nothing to push
+	if stored, deleted, err := m.Push(""); err != nil || stored != 0 || deleted != 0 {
+		t.Fatalf("Push unchanged = %d, %d, %v", stored, deleted, err)
+	}
+
+	// A change, a new file, a removal, a temp file and a skipped one
+	later := time.Now().Add(time.Minute)
+	projects := filepath.Join(dir, "users/u1_projects/projects.json")
+	_ = os.WriteFile(projects, []byte(`[{"id":"p1"},{"id":"p2"}]`), 0644)
+	_ = os.Chtimes(projects, later, later)
+	_ = os.MkdirAll(filepath.Join(dir, "users/u1_projects/p2/uploads"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "users/u1_projects/p2/uploads/b.pdf"), []byte("%PDF-b"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "users/u1_projects/p2/x.json.tmp-123"), []byte("partial"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, ".instance.lock"), []byte("me"), 0644)
+	_ = os.Remove(filepath.Join(dir, "workspaces.json"))
+
+	stored, deleted, err := m.Push("")
+	if err != nil || stored != 2 || deleted != 1 {
+		t.Fatalf("Push = %d stored, %d deleted, %v", stored, deleted, err)
+	}
+	if got := read(t, st, "users/u1_projects/projects.json"); !strings.Contains(got, "p2") {
+		t.Errorf("changed file not pushed: %s", got)
+	}
+	if got := read(t, st, ".instance.lock"); got != "pid" {
+		t.Errorf("skipped file was pushed: %s", got)
+	}
+	objs, _ := st.List("")
+	if got := keys(objs); got != ".instance.lock,users/u1_projects/p1/vectors.json,users/u1_projects/p2/uploads/b.pdf,users/u1_projects/projects.json" {
+		t.Errorf("stored after push = %s", got)
+	}
+
+	// A file deleted here isn't brought back before the next push
+	_ = os.Remove(filepath.Join(dir, "users/u1_projects/p1/vectors.json"))
+	if n, _ := m.Pull("", nil); n != 0 {
+		t.Errorf("Pull brought back %d deleted files", n)
+	}
+
+	// A fresh working copy starts from the store
+	fresh := NewMirror(t.TempDir(), st, m.Skip)
+	if n, err := fresh.Pull("", nil); err != nil || n != 3 {
+		t.Errorf("Pull into a fresh copy = %d, %v", n, err)
+	}
+}
+
+func TestMirror_Remove(t *testing.T) {
+	st := &Local{Root: t.TempDir()}
+	put(t, st, "users/u1_projects/p1/vectors.json", "{}")
+	put(t, st, "users/u1_projects/p1/uploads/a.pdf", "%PDF")
+	put(t, st, "users/u1_projects/p10/vectors.json", "{}")
+	put(t, st, ".instance.lock", "pid")
+
+	m := NewMirror(t.TempDir(), st, func(key string) bool { return key == ".instance.lock" })
+	if n, err := m.Remove("users/u1_projects/p1/"); err != nil || n != 2 {
+		t.Fatalf("Remove = %d, %v", n, err)
+	}
+	if objs, _ := st.List(""); keys(objs) != ".instance.lock,users/u1_projects/p10/vectors.json" {
+		t.Errorf("left after Remove: %s", keys(objs))
+	}
+	if n, _ := m.Remove(""); n != 1 {
+		t.Errorf("Remove all = %d, want the skipped file kept", n)
+	}
+}
@@ -0,0 +1,257 @@
+// Package xlsx writes simple Excel workbooks: sheets of text and number
+// cells under a bold, frozen and filterable header row. It covers what
+// exports need, not the rest of the format, and writes cells as inline
+// strings so a workbook is produced in one pass.
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Sheet is one worksheet.
+type Sheet struct {
+	Name    string // at most 31 characters, without []:*?/\; fixed up if not
+	Columns []Column
+	Rows    [][]Cell
+}
+
+// Column is a header and its width in characters (0 for Excel's default).
+type Column struct {
+	Header string
+	Width  float64
+}
+
+// Cell is one cell's value; see Text, Number and Int.
+type Cell struct {
+	text  string
+	num   float64
+	style int
+}
+
+// Cell styles, indexes into styles.xml's cellXfs.
+const (
+	styleDefault = iota
+	styleHeader
+	styleText
+	styleNumber
+	styleInt
+)
+
+// maxCellChars is the most text Excel keeps in a cell.
+const maxCellChars = 32767
+
+// Text is a text cell, wrapped and aligned to the top. Text over Excel's
+// limit of 32,767 characters is cut.
+func Text(s string) Cell {
+	if utf8.RuneCountInString(s) > maxCellChars {
+		s = string([]rune(s)[:maxCellChars])
+	}
+	return Cell{text: s, style: styleText}
+}
+
+// Number is a number cell shown with two decimals.
+func Number(f float64) Cell { return Cell{num: f, style: styleNumber} }
+
+// Int is a whole-number cell.
+func Int(n int) Cell { return Cell{num: float64(n), style: styleInt} }
+
+// Write writes a workbook of sheets to w.
+func Write(w io.Writer, sheets ...Sheet) error {
+	if len(sheets) == 0 {
+		return fmt.Errorf("xlsx: a workbook needs a sheet")
+	}
+	names := make([]string, len(sheets))
+	used := map[string]bool{}
+	for i, sh := range sheets {
+		names[i] = sheetName(sh.Name, i, used)
+	}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"[Content_Types].xml", contentTypes(len(sheets))},
+		{"_rels/.rels", []byte(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`)},
+		{"xl/workbook.xml", workbook(names)},
+		{"xl/_rels/workbook.xml.rels", workbookRels(len(sheets))},
+		{"xl/styles.xml", []byte(styles)},
+	}
+	for i, sh := range sheets {
+		files = append(files, struct {
+			name string
+			data []byte
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), worksheet(sh)})
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// sheetName makes name a valid sheet name unique among used.
+func sheetName(name string, i int, used map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", i+1)
+	}
+	if r := []rune(name); len(r) > 31 {
+		name = string(r[:31])
+	}
+	base := name
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		r := []rune(base)
+		name = string(r[:min(len(r), 31-len(suffix))]) + suffix
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+func contentTypes(sheets int) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	b.WriteString(`</Types>`)
+	return b.Bytes()
+}
+
+func workbook(names []string) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, name := range names {
+		fmt.Fprintf(&b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(name), i+1, i+1)
+	}
+	b.WriteString(`</sheets></workbook>`)
+	return b.Bytes()
+}
+
+func workbookRels(sheets int) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, sheets+1)
+	b.WriteString(`</Relationships>`)
+	return b.Bytes()
+}
+
+// styles defines the cellXfs the style constants index: the default, the
+// header (bold on grey, wrapped), and top-aligned wrapped text, two-decimal
+// numbers and whole numbers.
+const styles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="1"><numFmt numFmtId="164" formatCode="0.00"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="3"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FFD9D9D9"/><bgColor indexed="64"/></patternFill></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="5">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="0" xfId="0" applyFont="1" applyFill="1" applyAlignment="1"><alignment vertical="top" wrapText="1"/></xf>` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0" applyAlignment="1"><alignment vertical="top" wrapText="1"/></xf>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1" applyAlignment="1"><alignment vertical="top"/></xf>` +
+	`<xf numFmtId="1" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1" applyAlignment="1"><alignment vertical="top"/></xf>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
+
+func worksheet(sh Sheet) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if len(sh.Columns) > 0 {
+		// The header row stays in view as the rows scroll
+		b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+		var cols strings.Builder
+		for i, c := range sh.Columns {
+			if c.Width > 0 {
+				fmt.Fprintf(&cols, `<col min="%d" max="%d" width="%s" customWidth="1"/>`, i+1, i+1, strconv.FormatFloat(c.Width, 'f', -1, 64))
+			}
+		}
+		if cols.Len() > 0 {
+			b.WriteString(`<cols>` + cols.String() + `</cols>`)
+		}
+	}
+	b.WriteString(`<sheetData>`)
+	row := 0
+	if len(sh.Columns) > 0 {
+		header := make([]Cell, len(sh.Columns))
+		for i, c := range sh.Columns {
+			header[i] = Cell{text: c.Header, style: styleHeader}
+		}
+		row++
+		writeRow(&b, row, header)
+	}
+	for _, cells := range sh.Rows {
+		row++
+		writeRow(&b, row, cells)
+	}
+	b.WriteString(`</sheetData>`)
+	if len(sh.Columns) > 0 {
+		fmt.Fprintf(&b, `<autoFilter ref="A1:%s%d"/>`, columnName(len(sh.Columns)-1), max(row, 1))
+	}
+	b.WriteString(`</worksheet>`)
+	return b.Bytes()
+}
+
+func writeRow(b *bytes.Buffer, row int, cells []Cell) {
+	fmt.Fprintf(b, `<row r="%d">`, row)
+	for i, c := range cells {
+		ref := columnName(i) + strconv.Itoa(row)
+		switch c.style {
+		case styleNumber, styleInt:
+			fmt.Fprintf(b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, c.style, strconv.FormatFloat(c.num, 'f', -1, 64))
+		default:
+			if c.text == "" && c.style == styleDefault {
+				continue
+			}
+			fmt.Fprintf(b, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, c.style, escape(c.text))
+		}
+	}
+	b.WriteString(`</row>`)
+}
+
+// columnName is the letters of the i'th column, from 0: A, B, … Z, AA, AB, …
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// escape escapes s for XML text and attributes. Characters XML can't hold,
+// such as most control characters, become U+FFFD.
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
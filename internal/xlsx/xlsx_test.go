@@ -0,0 +1,123 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+// readPart returns a part of the workbook in buf.
+func readPart(t *testing.T, buf []byte, name string) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	f, err := zr.Open(name)
+	if err != nil {
+		t.Fatalf("no %s: %v", name, err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	return data
+}
+
+type sheetXML struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+	AutoFilter struct {
+		Ref string `xml:"ref,attr"`
+	} `xml:"autoFilter"`
+}
+
+func TestWrite_CellsAndHeader(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, Sheet{
+		Name:    "Results",
+		Columns: []Column{{Header: "Question", Width: 40}, {Header: "Confidence"}, {Header: "Pages"}},
+		Rows: [][]Cell{
+			{Text("What is the rent?"), Number(0.85), Int(3)},
+			{Text("Notice <period> & \"terms\"\nsecond line"), Number(0.5), Int(12)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	for _, part := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if data := readPart(t, buf.Bytes(), part); xml.Unmarshal(data, new(struct{})) != nil {
+			t.Errorf("%s is not well-formed XML", part)
+		}
+	}
+
+	var sheet sheetXML
+	if err := xml.Unmarshal(readPart(t, buf.Bytes(), "xl/worksheets/sheet1.xml"), &sheet); err != nil {
+		t.Fatalf("sheet XML: %v", err)
+	}
+	if len(sheet.Rows) != 3 {
+		t.Fatalf("got %d rows, want header and 2", len(sheet.Rows))
+	}
+	if got := sheet.Rows[0].Cells[1].Inline; got != "Confidence" {
+		t.Errorf("header B1 = %q", got)
+	}
+	row := sheet.Rows[2].Cells
+	if row[0].Type != "inlineStr" || row[0].Inline != "Notice <period> & \"terms\"\nsecond line" {
+		t.Errorf("A3 = %+v", row[0])
+	}
+	if row[1].Ref != "B3" || row[1].Value != "0.5" || row[2].Value != "12" {
+		t.Errorf("numbers = %+v, %+v", row[1], row[2])
+	}
+	if sheet.AutoFilter.Ref != "A1:C3" {
+		t.Errorf("autoFilter = %q, want A1:C3", sheet.AutoFilter.Ref)
+	}
+}
+
+func TestWrite_SheetNames(t *testing.T) {
+	var buf bytes.Buffer
+	long := strings.Repeat("x", 40)
+	if err := Write(&buf, Sheet{Name: "Q1/Q2: [draft]"}, Sheet{Name: long}, Sheet{Name: long}, Sheet{}); err != nil {
+		t.Fatal(err)
+	}
+	var wb struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(readPart(t, buf.Bytes(), "xl/workbook.xml"), &wb); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Q1_Q2_ _draft_", long[:31], long[:27] + " (2)", "Sheet4"}
+	for i, sh := range wb.Sheets {
+		if sh.Name != want[i] {
+			t.Errorf("sheet %d named %q, want %q", i+1, sh.Name, want[i])
+		}
+	}
+
+	if err := Write(&buf); err == nil {
+		t.Error("Write with no sheets succeeded")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestText_CutsAtExcelLimit(t *testing.T) {
+	if got := Text(strings.Repeat("é", maxCellChars+10)).text; len([]rune(got)) != maxCellChars {
+		t.Errorf("text kept %d characters, want %d", len([]rune(got)), maxCellChars)
+	}
+}
@@ -74,7 +74,7 @@ function openDocViewer(projectId, filename, page) {
 
     // Build the URL for the PDF
     const pageNum = page && page > 0 ? page : 1;
-    const pdfUrl = `${API_BASE}/api/file/view?project_id=${encodeURIComponent(projectId)}&name=${encodeURIComponent(filename)}#page=${pageNum}`;
+    const pdfUrl = `${API_BASE}/api/files/raw?project_id=${encodeURIComponent(projectId)}&name=${encodeURIComponent(filename)}#page=${pageNum}`;
 
     filenameEl.textContent = filename;
     pageBadge.textContent = page && page > 0 ? `Page ${pageNum}` : '';
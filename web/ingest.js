@@ -369,7 +369,7 @@ function waitForIndex() {
 
     // Check right away — might already be cached
     fetch(`${API_BASE}/api/index-status?project_id=${activeProjectId}`).then(r => r.json()).then(data => {
-        if (data.ready) return; // Already loaded (cache hit)
+        if (data.ready && !data.partial) return; // Already loaded (cache hit)
 
         // Show loading banner
         const banner = document.createElement('div');
@@ -388,7 +388,17 @@ function waitForIndex() {
             try {
                 const res = await fetch(`${API_BASE}/api/index-status?project_id=${activeProjectId}`);
                 const status = await res.json();
-                if (status.ready) {
+                if (status.partial) {
+                    // Queries already search the part loaded so far
+                    input.disabled = !status.ready;
+                    submitBtn.disabled = !status.ready;
+                    const label = document.querySelector('#indexLoadingBanner span');
+                    if (label) {
+                        label.textContent = status.ready
+                            ? `Loading document index... ${status.percent}% (answers use the part loaded so far)`
+                            : `Loading document index... ${status.percent}%`;
+                    }
+                } else if (status.ready) {
                     clearInterval(poll);
                     input.disabled = false;
                     submitBtn.disabled = false;
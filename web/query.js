@@ -917,6 +917,7 @@ async function loadStats() {
         const url = activeProjectId ? `${API_BASE}/api/stats?project_id=${activeProjectId}` : `${API_BASE}/api/stats`;
         const res = await fetch(url);
         const data = await res.json();
+        document.body.classList.toggle('read-only', !!data.read_only);
         document.getElementById('statDocs').textContent = data.documents;
         document.getElementById('statChunks').textContent = data.chunks.toLocaleString();
         document.getElementById('statStatus').innerHTML = data.index_ready